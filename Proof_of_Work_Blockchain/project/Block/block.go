@@ -1,8 +1,13 @@
 package block
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	help "project/Helpers"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -10,11 +15,38 @@ type Block struct {
 	PrevBlockHash []byte `json:"prev_hash"`
 	Index         int    `json:"index"`
 
-	Timestamp int64  `json:"timestamp"`
-	Content   []byte `json:"data"`
+	Timestamp int64 `json:"timestamp"`
+
+	// The content entries of the block, and the root of their Merkle tree
+	Entries    [][]byte `json:"entries,omitempty"` // None in a header
+	MerkleRoot []byte   `json:"merkle_root"`
+
+	// Address of the user who sent each entry, none if no user sent them, e.g. in the genesis block
+	Authors []string `json:"authors,omitempty"`
+
+	// ID of each entry, see ContentID, none if no user sent them
+	ContentIDs []string `json:"content_ids,omitempty"`
+
+	// Fee the author of each entry pays Miner, none if no entry pays one
+	Fees  []uint64 `json:"fees,omitempty"`
+	Miner string   `json:"miner,omitempty"` // Address of the node that mined the block, see Coinbase
+
+	Difficulty int `json:"difficulty"` // Leading zero bits the block's hash must have
+
+	// Port of the node that proposed the block under Proof of Stake, see
+	// Consensus/pos. None for a mined block, which carries a Proof of Work.
+	Proposer string `json:"proposer,omitempty"`
+
+	// Network the block belongs to, see help.NETWORK_ID. None on the default network.
+	NetworkID string `json:"network_id,omitempty"`
 
 	Nonce    int    `json:"nonce"`
 	SelfHash []byte `json:"hash"`
+
+	// Set on a header, see Header: the block without its entries, authors and content IDs
+	HeaderOnly       bool   `json:"header_only,omitempty"`
+	HeaderAuthors    []byte `json:"authors_hash,omitempty"`     // AuthorsHash of the block, covered by the PoW
+	HeaderContentIDs []byte `json:"content_ids_hash,omitempty"` // ContentIDsHash of the block, covered by the PoW
 }
 
 /*
@@ -33,12 +65,14 @@ func (b *Block) SetHash() {
 }
 
 /*
-Create and return a new block
+Create and return a new block of this process's network holding a single
+content entry, mined at the given difficulty
 */
-func NewBlock(content string, prevBlockHash []byte, prevIndex int) *Block {
+func NewBlock(content string, prevBlockHash []byte, prevIndex int, difficulty int) *Block {
 	// Create a new block using given data, prevBlockHash and the current time.
 	// 		Initialize the block's SelfHash as an empty array of bytes
-	block := &Block{prevBlockHash, prevIndex + 1, time.Now().UnixNano(), []byte(content), 0, []byte{}}
+	entries := [][]byte{[]byte(content)}
+	block := &Block{prevBlockHash, prevIndex + 1, time.Now().UnixNano(), entries, MerkleRoot(entries), nil, nil, nil, "", difficulty, "", help.NETWORK_ID, 0, []byte{}, false, nil, nil}
 	pow := NewProofOfWork(block)
 
 	// Run proof of work
@@ -52,9 +86,95 @@ func NewBlock(content string, prevBlockHash []byte, prevIndex int) *Block {
 }
 
 /*
-Turn the block into a PoW, then validate it.
+Return the header of the block: a copy without its entries, authors and
+content IDs, keeping the Merkle root and the hashes of the authors and
+content IDs the PoW covers, and its fees. A header validates and chains like its block,
+but holds no content.
+*/
+func (block *Block) Header() *Block {
+	if block.HeaderOnly {
+		return block
+	}
+	return &Block{
+		PrevBlockHash: block.PrevBlockHash,
+		Index:         block.Index,
+		Timestamp:     block.Timestamp,
+		MerkleRoot:    block.MerkleRoot,
+		Fees:          block.Fees,
+		Miner:         block.Miner,
+		Difficulty:    block.Difficulty,
+		Proposer:      block.Proposer,
+		NetworkID:     block.NetworkID,
+		Nonce:         block.Nonce,
+		SelfHash:      block.SelfHash,
+		HeaderOnly:    true,
+		HeaderAuthors: block.AuthorsHash(),
+
+		HeaderContentIDs: block.ContentIDsHash(),
+	}
+}
+
+/*
+Set when the nodes of this process seal blocks without a PoW, e.g. under
+Proof of Stake, see the node's --consensus flag. Otherwise a block naming a
+proposer is not valid, whatever difficulty it declares.
+*/
+var PROPOSED_BLOCKS bool = false
+
+/*
+Turn the block into a PoW, then validate it against the block's declared difficulty.
+The block's Merkle root must also match its entries, since the PoW only covers the root,
+and there must be an author, a content ID and a fee for every entry if the block has them.
+An entry paying a fee must have an author, who pays it, and the block a miner to pay.
+Its coinbase, if any, must reward its miner, see ValidCoinbase.
+Its entries must be within the size limits, see WithinSizeLimits.
+It must belong to this process's network, see help.NETWORK_ID.
+A header has neither, so only its PoW is validated. The block must carry
+its own hash, see Hash, since blocks link up by it. A block proposed under
+Proof of Stake carries no PoW, its hash must only match it, and it is only
+valid where PROPOSED_BLOCKS is set: whether its proposer was the one
+selected is up to the node, see Consensus/pos.
 */
 func (block *Block) Validate() bool {
+	if block.NetworkID != help.NETWORK_ID {
+		return false
+	}
+
+	if !block.HeaderOnly && !bytes.Equal(block.MerkleRoot, MerkleRoot(block.Entries)) {
+		return false
+	}
+
+	if !block.WithinSizeLimits() {
+		return false
+	}
+
+	if len(block.Authors) != 0 && len(block.Authors) != len(block.Entries) {
+		return false
+	}
+
+	if len(block.ContentIDs) != 0 && len(block.ContentIDs) != len(block.Entries) {
+		return false
+	}
+
+	if !block.HeaderOnly && len(block.Fees) != 0 && (len(block.Fees) != len(block.Entries) || len(block.Authors) == 0 || block.Miner == "") {
+		return false
+	}
+
+	if !block.ValidCoinbase() {
+		return false
+	}
+
+	if !bytes.Equal(block.SelfHash, block.Hash()) {
+		return false
+	}
+
+	if block.Proposer != "" {
+		return PROPOSED_BLOCKS
+	}
+
+	if block.Difficulty < MIN_DIFFICULTY || block.Difficulty > MAX_DIFFICULTY {
+		return false
+	}
 
 	// Turn block into a PoW
 	pow := NewProofOfWork(block)
@@ -62,3 +182,130 @@ func (block *Block) Validate() bool {
 	// Return the validate result for this PoW.
 	return pow.ValidatePoW()
 }
+
+/*
+Return the hash of the block with its nonce, the hash its PoW is checked against.
+*/
+func (block *Block) Hash() []byte {
+	hash := sha256.Sum256(NewProofOfWork(block).MergeBlockNonce(block.Nonce))
+	return hash[:]
+}
+
+/*
+Return the hash of the block's authors, so the PoW covers them too.
+A block without authors adds nothing to the PoW.
+*/
+func (block *Block) AuthorsHash() []byte {
+	if block.HeaderOnly {
+		return append([]byte{}, block.HeaderAuthors...)
+	}
+	if len(block.Authors) == 0 {
+		return []byte{}
+	}
+	hash := sha256.Sum256([]byte(strings.Join(block.Authors, "\n")))
+	return hash[:]
+}
+
+/*
+Return the hash of the block's content IDs, so the PoW covers them too.
+A block without content IDs adds nothing to the PoW.
+*/
+func (block *Block) ContentIDsHash() []byte {
+	if block.HeaderOnly {
+		return append([]byte{}, block.HeaderContentIDs...)
+	}
+	if len(block.ContentIDs) == 0 {
+		return []byte{}
+	}
+	hash := sha256.Sum256([]byte(strings.Join(block.ContentIDs, "\n")))
+	return hash[:]
+}
+
+/*
+Return the hash of the block's fees and the address they are paid to, so
+the PoW covers them too. A block without fees adds nothing to the PoW.
+*/
+func (block *Block) FeesHash() []byte {
+	if len(block.Fees) == 0 {
+		return []byte{}
+	}
+	fees := []string{}
+	for _, fee := range block.Fees {
+		fees = append(fees, strconv.FormatUint(fee, 10))
+	}
+	hash := sha256.Sum256([]byte(strings.Join(fees, "\n") + "\n" + block.Miner))
+	return hash[:]
+}
+
+/*
+Return the sum of the fees the block pays its miner.
+*/
+func (block *Block) TotalFees() uint64 {
+	var total uint64
+	for _, fee := range block.Fees {
+		total += fee
+	}
+	return total
+}
+
+/*
+Return the ID of content sent by the user with the author address at the
+given time, in nanoseconds: the SHA-256 of the three, hex encoded. Users
+send the time along with their content, so every node they send it to
+agrees on its ID.
+*/
+func ContentID(content string, author string, timestamp int64) string {
+	hash := sha256.Sum256([]byte(content + "\n" + author + "\n" + strconv.FormatInt(timestamp, 10)))
+	return hex.EncodeToString(hash[:])
+}
+
+/*
+Return the hash of content sent by the user with the author address: the
+ContentHash of the two. Unlike its ContentID, it does not depend on when
+the content was sent, so content its user sends again, e.g. retrying, has
+the same hash and is committed once.
+*/
+func SubmissionHash(content string, author string) string {
+	return ContentHash([]byte(content + "\n" + author))
+}
+
+/*
+Return the index of the entry with the given content ID, or -1 if the
+block has none.
+*/
+func (block *Block) EntryWithID(contentID string) int {
+	for i, id := range block.ContentIDs {
+		if id == contentID {
+			return i
+		}
+	}
+	return -1
+}
+
+/*
+Return the address of the user who sent the i-th entry, or "" if unknown.
+*/
+func (block *Block) Author(i int) string {
+	if i < len(block.Authors) {
+		return block.Authors[i]
+	}
+	return ""
+}
+
+/*
+Return the block's content entries as strings.
+*/
+func (block *Block) Contents() []string {
+	contents := []string{}
+	for _, entry := range block.Entries {
+		contents = append(contents, string(entry))
+	}
+	return contents
+}
+
+/*
+Return the block's content entries as one string, for printing.
+*/
+func (block *Block) ContentString() string {
+	return strings.Join(block.Contents(), " | ")
+}
@@ -0,0 +1,90 @@
+package block
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+/* Prefix of a block's first content entry when it rewards its miner */
+const COINBASE_PREFIX string = "coinbase:"
+
+/* Amount a block's coinbase credits its miner with */
+var BLOCK_REWARD uint64 = 50
+
+/*
+The reward of a block's miner, the block's first entry, carried as
+"coinbase:<JSON>". It credits the block's Miner with BLOCK_REWARD, and
+names the block's index so no two coinbases are the same.
+*/
+type Coinbase struct {
+	Miner  string `json:"miner"`
+	Reward uint64 `json:"reward"`
+	Index  int    `json:"index"`
+}
+
+/*
+Return the coinbase rewarding the miner of the block at the given index.
+*/
+func NewCoinbase(miner string, index int) Coinbase {
+	return Coinbase{Miner: miner, Reward: BLOCK_REWARD, Index: index}
+}
+
+/*
+Return the coinbase as it is stored in a block's content.
+*/
+func (coinbase Coinbase) String() string {
+	jsonBytes, err := json.Marshal(coinbase)
+	if err != nil {
+		panic(err)
+	}
+	return COINBASE_PREFIX + string(jsonBytes)
+}
+
+/*
+Parse a block's content as a coinbase.
+Returns false if the content is not one.
+*/
+func ParseCoinbase(content []byte) (Coinbase, bool) {
+	str := string(content)
+	if !strings.HasPrefix(str, COINBASE_PREFIX) {
+		return Coinbase{}, false
+	}
+
+	var coinbase Coinbase
+	if json.Unmarshal([]byte(strings.TrimPrefix(str, COINBASE_PREFIX)), &coinbase) != nil {
+		return Coinbase{}, false
+	}
+	return coinbase, true
+}
+
+/*
+Return the coinbase of the block, false if it has none.
+*/
+func (block *Block) Coinbase() (Coinbase, bool) {
+	if len(block.Entries) == 0 {
+		return Coinbase{}, false
+	}
+	return ParseCoinbase(block.Entries[0])
+}
+
+/*
+Returns true if the block has at most one coinbase, as its first entry,
+rewarding its miner with BLOCK_REWARD at the block's index. A coinbase
+sent by a user, with an author, is not valid.
+*/
+func (block *Block) ValidCoinbase() bool {
+	for i, entry := range block.Entries {
+		if !strings.HasPrefix(string(entry), COINBASE_PREFIX) {
+			continue
+		}
+		coinbase, ok := ParseCoinbase(entry)
+		if i != 0 || !ok || coinbase.Miner == "" || coinbase.Miner != block.Miner ||
+			coinbase.Reward != BLOCK_REWARD || coinbase.Index != block.Index {
+			return false
+		}
+		if len(block.Authors) != 0 && block.Authors[0] != "" {
+			return false
+		}
+	}
+	return true
+}
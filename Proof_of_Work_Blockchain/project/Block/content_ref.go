@@ -0,0 +1,52 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+/* Prefix of a block's content when its body is stored off-chain */
+const OFFCHAIN_PREFIX string = "offchain:"
+
+/*
+A reference to content stored off-chain, in a blob store.
+The block carries only the reference, as "offchain:<hash>@<location>".
+*/
+type ContentRef struct {
+	Hash     string // SHA-256 of the content body, hex encoded
+	Location string // Where the blob store keeps the body
+}
+
+/*
+Return the hex encoded SHA-256 hash of a content body.
+*/
+func ContentHash(body []byte) string {
+	hash := sha256.Sum256(body)
+	return hex.EncodeToString(hash[:])
+}
+
+/*
+Return the reference as it is stored in a block's content.
+*/
+func (ref ContentRef) String() string {
+	return OFFCHAIN_PREFIX + ref.Hash + "@" + ref.Location
+}
+
+/*
+Parse a block's content as a reference to off-chain content.
+Returns false if the content is stored on-chain.
+*/
+func ParseContentRef(content []byte) (ContentRef, bool) {
+	str := string(content)
+	if !strings.HasPrefix(str, OFFCHAIN_PREFIX) {
+		return ContentRef{}, false
+	}
+
+	hash, location, found := strings.Cut(strings.TrimPrefix(str, OFFCHAIN_PREFIX), "@")
+	if !found || hash == "" || location == "" {
+		return ContentRef{}, false
+	}
+
+	return ContentRef{Hash: hash, Location: location}, true
+}
@@ -0,0 +1,66 @@
+package block
+
+import (
+	"math"
+	"time"
+)
+
+/* Number of blocks between two difficulty adjustments */
+const RETARGET_INTERVAL int = 10
+
+/* Time the network aims to spend mining each block */
+const TARGET_BLOCK_TIME time.Duration = 2 * time.Second
+
+/* Most difficulty bits a single adjustment adds or removes */
+const MAX_RETARGET_STEP int = 2
+
+/* Bounds of a block's difficulty */
+const MIN_DIFFICULTY int = 8
+const MAX_DIFFICULTY int = 32
+
+/*
+Return the difficulty the block following the given blocks must declare.
+
+The difficulty only changes on blocks whose index is a multiple of
+RETARGET_INTERVAL. It then moves by one bit for every doubling or halving
+of the average interval between the last RETARGET_INTERVAL blocks away
+from TARGET_BLOCK_TIME, since each bit doubles the work of a PoW. A
+single adjustment moves by at most MAX_RETARGET_STEP bits, so a few
+skewed timestamps cannot swing the difficulty. A valid difficulty change
+the last block holds overrides it, see DifficultyChange.
+*/
+func NextDifficulty(blocks []*Block) int {
+	if len(blocks) == 0 {
+		return DIFFICULTY
+	}
+
+	last := blocks[len(blocks)-1]
+	if difficulty, changed := last.ChangedDifficulty(); changed {
+		return difficulty
+	}
+	if (last.Index+1)%RETARGET_INTERVAL != 0 || len(blocks) < RETARGET_INTERVAL {
+		return last.Difficulty
+	}
+
+	first := blocks[len(blocks)-RETARGET_INTERVAL]
+	average := (last.Timestamp - first.Timestamp) / int64(RETARGET_INTERVAL-1)
+
+	step := MAX_RETARGET_STEP
+	if average > 0 {
+		step = int(math.Round(math.Log2(float64(TARGET_BLOCK_TIME) / float64(average))))
+	}
+	step = clamp(step, -MAX_RETARGET_STEP, MAX_RETARGET_STEP)
+
+	return clamp(last.Difficulty+step, MIN_DIFFICULTY, MAX_DIFFICULTY)
+}
+
+/* Return n, or the nearest bound if it is out of bounds */
+func clamp(n int, low int, high int) int {
+	if n < low {
+		return low
+	}
+	if n > high {
+		return high
+	}
+	return n
+}
@@ -0,0 +1,57 @@
+package block
+
+import (
+	"math"
+)
+
+/*
+	Fees proportional to the size of content, since every node keeps a copy
+	of it: the author of a content entry pays at least RequiredFee to the
+	miner of the block holding it. Nodes refuse to queue content paying less,
+	and to validate blocks holding some, see Block.PaysRequiredFees.
+*/
+
+/* Fee each byte of a content entry costs, 0 charges nothing by size */
+var FEE_PER_BYTE uint64 = 0
+
+/* Least fee any content entry pays */
+var MIN_FEE uint64 = 0
+
+/*
+Return the least fee the content pays: FEE_PER_BYTE for each of its bytes,
+at least MIN_FEE.
+*/
+func RequiredFee(content string) uint64 {
+	fee := uint64(math.MaxUint64)
+	if size := uint64(len(content)); size == 0 || FEE_PER_BYTE <= math.MaxUint64/size {
+		fee = FEE_PER_BYTE * size
+	}
+	if fee < MIN_FEE {
+		return MIN_FEE
+	}
+	return fee
+}
+
+/*
+Returns true if every content entry of the block its author sent pays at
+least RequiredFee, whoever mined it. Coinbases and entries without an
+author, e.g. difficulty changes, pay none. A header holds no entries.
+*/
+func (block *Block) PaysRequiredFees() bool {
+	for i, entry := range block.Entries {
+		if len(block.Authors) <= i || block.Authors[i] == "" {
+			continue
+		}
+		if _, ok := ParseCoinbase(entry); ok {
+			continue
+		}
+		var fee uint64
+		if len(block.Fees) > i {
+			fee = block.Fees[i]
+		}
+		if fee < RequiredFee(string(entry)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+package block
+
+import (
+	"encoding/json"
+	"fmt"
+	wlt "project/Wallet"
+	"strings"
+	"time"
+)
+
+/* Prefix of a block's content when it changes the difficulty */
+const DIFFICULTY_CHANGE_PREFIX string = "difficulty:"
+
+/*
+Addresses of the wallets whose difficulty changes nodes follow, the same on
+all nodes of a network. None ignores every change.
+*/
+var GOVERNORS []string
+
+/*
+A governance message setting the difficulty of the blocks that follow the
+block carrying it, signed with the wallet of one of the GOVERNORS. The
+block carries it as "difficulty:<JSON>", see NextDifficulty. Retargeting
+goes on from the new difficulty.
+*/
+type DifficultyChange struct {
+	Difficulty int    `json:"difficulty"`
+	Governor   string `json:"governor"`
+	Nonce      int64  `json:"nonce"`      // Set by the governor so the same change may be made twice
+	PublicKey  string `json:"public_key"` // Of the wallet of Governor
+	Signature  string `json:"signature"`  // Of Message, by the wallet of Governor
+}
+
+/*
+Return a change to the given difficulty, signed with the governor's wallet.
+*/
+func NewDifficultyChange(wallet *wlt.Wallet, difficulty int) (DifficultyChange, error) {
+	change := DifficultyChange{Difficulty: difficulty, Governor: wallet.Address, Nonce: time.Now().UnixNano(), PublicKey: wallet.PublicKey}
+	signature, err := wallet.Sign(change.Message())
+	if err != nil {
+		return DifficultyChange{}, err
+	}
+	change.Signature = signature
+	return change, nil
+}
+
+/*
+Return the message the governor signs, binding its signature to the
+difficulty and the nonce.
+*/
+func (change DifficultyChange) Message() string {
+	return fmt.Sprintf("%s%s:%d:%d", DIFFICULTY_CHANGE_PREFIX, change.Governor, change.Difficulty, change.Nonce)
+}
+
+/*
+Return the ID of the change: the hex encoded SHA-256 of its Message, so a
+change committed once cannot be replayed, even with another signature.
+*/
+func (change DifficultyChange) ID() string {
+	return ContentHash([]byte(change.Message()))
+}
+
+/*
+Return the change as it is stored in a block's content.
+*/
+func (change DifficultyChange) String() string {
+	jsonBytes, err := json.Marshal(change)
+	if err != nil {
+		panic(err)
+	}
+	return DIFFICULTY_CHANGE_PREFIX + string(jsonBytes)
+}
+
+/*
+Parse a block's content as a difficulty change.
+Returns false if the content is not one.
+*/
+func ParseDifficultyChange(content []byte) (DifficultyChange, bool) {
+	str := string(content)
+	if !strings.HasPrefix(str, DIFFICULTY_CHANGE_PREFIX) {
+		return DifficultyChange{}, false
+	}
+
+	var change DifficultyChange
+	if json.Unmarshal([]byte(strings.TrimPrefix(str, DIFFICULTY_CHANGE_PREFIX)), &change) != nil {
+		return DifficultyChange{}, false
+	}
+	return change, true
+}
+
+/*
+Returns true if the change sets a difficulty within MIN_DIFFICULTY and
+MAX_DIFFICULTY, and is signed by the wallet of its governor, one of the
+GOVERNORS.
+*/
+func (change DifficultyChange) Verify() bool {
+	if change.Difficulty < MIN_DIFFICULTY || change.Difficulty > MAX_DIFFICULTY {
+		return false
+	}
+	governor := false
+	for _, address := range GOVERNORS {
+		governor = governor || address == change.Governor
+	}
+	if !governor {
+		return false
+	}
+	address, err := wlt.Address(change.PublicKey)
+	if err != nil || address != change.Governor {
+		return false
+	}
+	return wlt.Verify(change.PublicKey, change.Message(), change.Signature)
+}
+
+/*
+Return the difficulty the last valid difficulty change the block holds
+sets, or false if it holds none. A header holds none, its entries are gone.
+*/
+func (block *Block) ChangedDifficulty() (int, bool) {
+	difficulty, changed := 0, false
+	for _, entry := range block.Entries {
+		if change, ok := ParseDifficultyChange(entry); ok && change.Verify() {
+			difficulty, changed = change.Difficulty, true
+		}
+	}
+	return difficulty, changed
+}
@@ -0,0 +1,38 @@
+package block
+
+/*
+	Limits on the size of what goes on the blockchain, since every node
+	keeps a copy of it. Larger content is stored off-chain by users and
+	only referenced on the blockchain, see ContentRef.
+*/
+
+/* Bytes a single content entry may take */
+var MAX_CONTENT_SIZE int = 64 << 10
+
+/* Bytes all the content entries of a block may take together */
+var MAX_BLOCK_SIZE int = 1 << 20
+
+/*
+Return the number of bytes the content entries of the block take.
+A header has none.
+*/
+func (block *Block) Size() int {
+	size := 0
+	for _, entry := range block.Entries {
+		size += len(entry)
+	}
+	return size
+}
+
+/*
+Returns true if each entry of the block is at most MAX_CONTENT_SIZE bytes
+and all of them are at most MAX_BLOCK_SIZE bytes.
+*/
+func (block *Block) WithinSizeLimits() bool {
+	for _, entry := range block.Entries {
+		if len(entry) > MAX_CONTENT_SIZE {
+			return false
+		}
+	}
+	return block.Size() <= MAX_BLOCK_SIZE
+}
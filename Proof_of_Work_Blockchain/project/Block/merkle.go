@@ -0,0 +1,136 @@
+package block
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+/*
+A step of a Merkle inclusion proof: the hash of the sibling of the node
+reached so far, and whether the sibling is the left child of their parent.
+*/
+type MerkleStep struct {
+	Hash []byte `json:"hash"`
+	Left bool   `json:"left"`
+}
+
+/*
+Return the root of the Merkle tree over the entries of a block.
+
+The leaves are the hashes of the entries and every parent is the hash of
+its two children; when a level has an odd number of nodes, its last node
+is paired with itself. Leaves and parents are hashed with different
+prefixes, so a parent can never pass for an entry.
+*/
+func MerkleRoot(entries [][]byte) []byte {
+	if len(entries) == 0 {
+		hash := sha256.Sum256([]byte{})
+		return hash[:]
+	}
+
+	level := merkleLeaves(entries)
+	for len(level) > 1 {
+		level = merkleParents(level)
+	}
+
+	return level[0]
+}
+
+/*
+Return the proof that the i-th of entries is in the Merkle tree over
+entries: the siblings on the path from its leaf up to the root. Returns
+nil if there is no i-th entry.
+*/
+func MerkleProof(entries [][]byte, i int) []MerkleStep {
+	if i < 0 || i >= len(entries) {
+		return nil
+	}
+
+	proof := []MerkleStep{}
+	level := merkleLeaves(entries)
+	for len(level) > 1 {
+		sibling := i ^ 1
+		if sibling >= len(level) {
+			sibling = i // The last node is paired with itself
+		}
+		proof = append(proof, MerkleStep{Hash: level[sibling], Left: sibling < i})
+
+		level = merkleParents(level)
+		i /= 2
+	}
+	return proof
+}
+
+/*
+Return true if proof leads from entry up to root, i.e. a block with that
+Merkle root holds entry.
+*/
+func VerifyMerkleProof(entry []byte, proof []MerkleStep, root []byte) bool {
+	hash := merkleLeaf(entry)
+	for _, step := range proof {
+		if step.Left {
+			hash = merkleParent(step.Hash, hash)
+		} else {
+			hash = merkleParent(hash, step.Hash)
+		}
+	}
+	return bytes.Equal(hash, root)
+}
+
+/*
+Return the position of the entry a proof of n entries starts from, or -1 if
+the proof cannot be one of a tree over n entries, i.e. it does not take one
+step for each level of the tree.
+*/
+func MerkleProofPosition(proof []MerkleStep, n int) int {
+	levels := 0
+	for width := n; width > 1; width = (width + 1) / 2 {
+		levels++
+	}
+	if n <= 0 || len(proof) != levels {
+		return -1
+	}
+
+	// The entry is the right child on each level its sibling is the left one
+	position := 0
+	for level, step := range proof {
+		if step.Left {
+			position |= 1 << level
+		}
+	}
+	if position >= n {
+		return -1
+	}
+	return position
+}
+
+func merkleLeaf(entry []byte) []byte {
+	hash := sha256.Sum256(append([]byte{0}, entry...))
+	return hash[:]
+}
+
+func merkleParent(left []byte, right []byte) []byte {
+	data := append([]byte{1}, left...)
+	hash := sha256.Sum256(append(data, right...))
+	return hash[:]
+}
+
+func merkleLeaves(entries [][]byte) [][]byte {
+	level := [][]byte{}
+	for _, entry := range entries {
+		level = append(level, merkleLeaf(entry))
+	}
+	return level
+}
+
+func merkleParents(level [][]byte) [][]byte {
+	parents := [][]byte{}
+	for i := 0; i < len(level); i += 2 {
+		left, right := level[i], level[i]
+		if i+1 < len(level) {
+			right = level[i+1]
+		}
+		parents = append(parents, merkleParent(left, right))
+	}
+	return parents
+}
@@ -32,8 +32,12 @@ target bits in BTC is the difficulty level.
 This constant is used in calculating the hex representation of
 the target. In this demo, difficulty is 24 which would look like
 this in hex -> 0x10000000000000000000000000000000000000000000000000000000000
+
+Each block declares its own difficulty, see NextDifficulty.
+It may be set before the genesis block is mined, e.g. by the node's --difficulty flag,
+to the difficulty a node's /admin/mining/stats suggests for its hash rate.
 */
-const DIFFICULTY = 18 // Current difficulty
+var DIFFICULTY = 18 // Difficulty of the genesis block
 
 /**/
 type ProofOfWork struct {
@@ -57,9 +61,9 @@ func NewProofOfWork(b *Block) *ProofOfWork {
 
 	target := big.NewInt(1) // 000.....0001
 
-	// Bitwise left-shift target by (256 - DIFFICULTY) positions
+	// Bitwise left-shift target by (256 - difficulty) positions
 	// 000.....0001 -> 000...1...0000
-	target.Lsh(target, uint(256-DIFFICULTY))
+	target.Lsh(target, uint(256-b.Difficulty))
 
 	pow := &ProofOfWork{b, target}
 
@@ -76,9 +80,14 @@ func (pow *ProofOfWork) MergeBlockNonce(nonce int) []byte {
 	data := bytes.Join(
 		[][]byte{
 			pow.Block.PrevBlockHash,
-			pow.Block.Content,
+			pow.Block.MerkleRoot,
+			pow.Block.AuthorsHash(),
+			pow.Block.ContentIDsHash(),
+			pow.Block.FeesHash(),
+			[]byte(pow.Block.Proposer),  // None for a mined block
+			[]byte(pow.Block.NetworkID), // None on the default network
 			IntToHex(pow.Block.Timestamp),
-			IntToHex(int64(DIFFICULTY)),
+			IntToHex(int64(pow.Block.Difficulty)),
 			IntToHex(int64(nonce)),
 		},
 		[]byte{},
@@ -129,6 +138,11 @@ func (pow *ProofOfWork) Run() (int, []byte) {
 	return nonce, hash[:]
 }
 
+/*
+Returns true if the hash of the block with its nonce meets the difficulty
+the block declares, not this node's DIFFICULTY, so nodes started with
+different difficulties agree on which blocks are valid.
+*/
 func (pow *ProofOfWork) ValidatePoW() bool {
 	var hashInt big.Int
 
@@ -0,0 +1,51 @@
+package block
+
+import (
+	"sort"
+	"time"
+)
+
+/*
+	Rules on the timestamp a block declares, so it tells when the block was
+	mined: a block may not claim to be from the future, beyond the drift
+	between the clocks of nodes, nor from before the blocks it follows.
+	Comparing with the median of the last blocks rather than the last one
+	lets a miner's clock run somewhat behind, but not far.
+*/
+
+/* How far ahead of a node's clock a block may be timestamped */
+var MAX_FUTURE_DRIFT time.Duration = 2 * time.Minute
+
+/* Number of the last blocks whose median timestamp a new block may not be earlier than */
+var MEDIAN_TIME_BLOCKS int = 11
+
+/*
+Return the median timestamp of the last MEDIAN_TIME_BLOCKS blocks, or of
+all the blocks if there are fewer. Returns 0 for no blocks.
+*/
+func MedianTimestamp(blocks []*Block) int64 {
+	if len(blocks) == 0 {
+		return 0
+	}
+	start := len(blocks) - MEDIAN_TIME_BLOCKS
+	if start < 0 {
+		start = 0
+	}
+
+	timestamps := []int64{}
+	for _, block := range blocks[start:] {
+		timestamps = append(timestamps, block.Timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2]
+}
+
+/*
+Returns true if the block, following the given blocks, is timestamped at
+most MAX_FUTURE_DRIFT after now, and no earlier than the median timestamp
+of the last blocks, see MedianTimestamp.
+*/
+func ValidTimestamp(blocks []*Block, block *Block, now time.Time) bool {
+	return block.Timestamp <= now.Add(MAX_FUTURE_DRIFT).UnixNano() &&
+		block.Timestamp >= MedianTimestamp(blocks)
+}
@@ -0,0 +1,158 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	wlt "project/Wallet"
+	"strings"
+)
+
+/* Prefix of a block's content when it is a transaction */
+const TRANSACTION_PREFIX string = "tx:"
+
+/* Prefix of the ID of the output every address is allocated before any transaction */
+const ALLOCATION_PREFIX string = "allocation:"
+
+/* An output of a transaction, by the transaction's ID and its index among the outputs */
+type OutPoint struct {
+	TxID  string `json:"tx"`
+	Index int    `json:"index"`
+}
+
+/*
+An input of a transaction, spending an unspent output. It is signed with
+the wallet of the address the output pays to.
+*/
+type Input struct {
+	OutPoint
+	PublicKey string `json:"public_key"`
+	Signature string `json:"signature"` // Of the transaction's Message
+}
+
+/* An output of a transaction, paying Amount to an address */
+type Output struct {
+	Address string `json:"address"`
+	Amount  uint64 `json:"amount"`
+}
+
+/*
+A transaction spending unspent outputs, the inputs, into new outputs. The
+block carries it as "tx:<JSON>". Each node keeps the set of unspent
+outputs from the transactions on its blockchain, and an output may only be
+spent once. Every address starts with one unspent output, see Allocation.
+*/
+type Transaction struct {
+	Inputs  []Input  `json:"inputs"`
+	Outputs []Output `json:"outputs"`
+}
+
+/*
+Return the output every address is allocated before any transaction.
+*/
+func Allocation(address string) OutPoint {
+	return OutPoint{TxID: ALLOCATION_PREFIX + address, Index: 0}
+}
+
+/*
+Returns the address the output is allocated to if it is an allocation,
+see Allocation.
+*/
+func (outPoint OutPoint) Allocated() (string, bool) {
+	if outPoint.Index != 0 || !strings.HasPrefix(outPoint.TxID, ALLOCATION_PREFIX) {
+		return "", false
+	}
+	return strings.TrimPrefix(outPoint.TxID, ALLOCATION_PREFIX), true
+}
+
+/*
+Return the ID of the transaction: the hex encoded SHA-256 of the
+transaction without its signatures, which sign it.
+*/
+func (tx Transaction) ID() string {
+	unsigned := Transaction{Outputs: tx.Outputs}
+	for _, input := range tx.Inputs {
+		unsigned.Inputs = append(unsigned.Inputs, Input{OutPoint: input.OutPoint, PublicKey: input.PublicKey})
+	}
+	jsonBytes, err := json.Marshal(unsigned)
+	if err != nil {
+		panic(err)
+	}
+	hash := sha256.Sum256(jsonBytes)
+	return hex.EncodeToString(hash[:])
+}
+
+/*
+Return the message the owner of each input signs.
+*/
+func (tx Transaction) Message() string {
+	return TRANSACTION_PREFIX + tx.ID()
+}
+
+/*
+Return the transaction as it is stored in a block's content.
+*/
+func (tx Transaction) String() string {
+	jsonBytes, err := json.Marshal(tx)
+	if err != nil {
+		panic(err)
+	}
+	return TRANSACTION_PREFIX + string(jsonBytes)
+}
+
+/*
+Parse a block's content as a transaction.
+Returns false if the content is not one.
+*/
+func ParseTransaction(content []byte) (Transaction, bool) {
+	str := string(content)
+	if !strings.HasPrefix(str, TRANSACTION_PREFIX) {
+		return Transaction{}, false
+	}
+
+	var tx Transaction
+	if json.Unmarshal([]byte(strings.TrimPrefix(str, TRANSACTION_PREFIX)), &tx) != nil {
+		return Transaction{}, false
+	}
+	return tx, true
+}
+
+/*
+Return the ID of the transfer or the transaction a block's content holds,
+which may only be committed once. Returns false for other content.
+*/
+func TransactionID(content []byte) (string, bool) {
+	if transfer, ok := ParseTransfer(content); ok {
+		return transfer.ID(), true
+	}
+	if tx, ok := ParseTransaction(content); ok {
+		return tx.ID(), true
+	}
+	return "", false
+}
+
+/*
+Returns true if the transaction spends distinct outputs into positive
+amounts, and each input is signed with the public key it carries. Whether
+that key owns the output spent is checked against the unspent outputs.
+*/
+func (tx Transaction) Verify() bool {
+	if len(tx.Inputs) == 0 || len(tx.Outputs) == 0 {
+		return false
+	}
+	for _, output := range tx.Outputs {
+		if output.Amount == 0 || output.Address == "" {
+			return false
+		}
+	}
+
+	spent := map[OutPoint]bool{}
+	message := tx.Message()
+	for _, input := range tx.Inputs {
+		if spent[input.OutPoint] || !wlt.Verify(input.PublicKey, message, input.Signature) {
+			return false
+		}
+		spent[input.OutPoint] = true
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package block
+
+import (
+	"encoding/json"
+	"fmt"
+	wlt "project/Wallet"
+	"strings"
+)
+
+/* Prefix of a block's content when it is a transfer */
+const TRANSFER_PREFIX string = "transfer:"
+
+/*
+A transfer of Amount from the address From to the address To, signed with
+the wallet of From. The block carries it as "transfer:<JSON>", nodes keep
+the balance of each address from the transfers on their blockchain.
+*/
+type Transfer struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Amount    uint64 `json:"amount"`
+	Nonce     int64  `json:"nonce,omitempty"` // Set by the sender so the same transfer may be made twice
+	PublicKey string `json:"public_key"`      // Of the wallet of From
+	Signature string `json:"signature"`       // Of Message, by the wallet of From
+}
+
+/*
+Return the message the sender signs, binding its signature to the
+addresses, the amount and the nonce.
+*/
+func (transfer Transfer) Message() string {
+	message := fmt.Sprintf("%s%s:%s:%d", TRANSFER_PREFIX, transfer.From, transfer.To, transfer.Amount)
+	if transfer.Nonce != 0 {
+		message += fmt.Sprintf(":%d", transfer.Nonce)
+	}
+	return message
+}
+
+/*
+Return the ID of the transfer: the hex encoded SHA-256 of its Message, so
+a transfer committed once cannot be replayed, even with another signature.
+*/
+func (transfer Transfer) ID() string {
+	return ContentHash([]byte(transfer.Message()))
+}
+
+/*
+Return the transfer as it is stored in a block's content.
+*/
+func (transfer Transfer) String() string {
+	jsonBytes, err := json.Marshal(transfer)
+	if err != nil {
+		panic(err)
+	}
+	return TRANSFER_PREFIX + string(jsonBytes)
+}
+
+/*
+Parse a block's content as a transfer.
+Returns false if the content is not one.
+*/
+func ParseTransfer(content []byte) (Transfer, bool) {
+	str := string(content)
+	if !strings.HasPrefix(str, TRANSFER_PREFIX) {
+		return Transfer{}, false
+	}
+
+	var transfer Transfer
+	if json.Unmarshal([]byte(strings.TrimPrefix(str, TRANSFER_PREFIX)), &transfer) != nil {
+		return Transfer{}, false
+	}
+	return transfer, true
+}
+
+/*
+Returns true if the transfer moves a positive amount between two
+addresses, and is signed by the wallet of the address it is sent from.
+*/
+func (transfer Transfer) Verify() bool {
+	if transfer.Amount == 0 || transfer.To == "" || transfer.From == transfer.To {
+		return false
+	}
+	address, err := wlt.Address(transfer.PublicKey)
+	if err != nil || address != transfer.From {
+		return false
+	}
+	return wlt.Verify(transfer.PublicKey, transfer.Message(), transfer.Signature)
+}
@@ -1,8 +1,11 @@
 package blockchain
 
 import (
+	"bytes"
 	"fmt"
 	block "project/Block"
+	help "project/Helpers"
+	"time"
 )
 
 const NON_TRIVIAL int = 4
@@ -22,7 +25,7 @@ type Blockchain struct {
 // */
 func (bc *Blockchain) AddBlock(data string) {
 	prevBlock := bc.Blocks[len(bc.Blocks)-1]
-	newBlock := block.NewBlock(data, prevBlock.SelfHash, prevBlock.Index)
+	newBlock := block.NewBlock(data, prevBlock.SelfHash, prevBlock.Index, block.NextDifficulty(bc.Blocks))
 	bc.Blocks = append(bc.Blocks, newBlock)
 }
 
@@ -32,13 +35,54 @@ When there are 4 peers, a new blockchain should be automatically created.
 func NewBlockchain(known_ports []string) (*Blockchain, bool) {
 
 	if len(known_ports) == NON_TRIVIAL {
-		genesisBlock := []*block.Block{block.NewBlock("Genesis Block", []byte{}, -1)}
+		genesisBlock := []*block.Block{block.NewBlock("Genesis Block", []byte{}, -1, block.DIFFICULTY)}
 		return &Blockchain{Blocks: genesisBlock}, true
 	}
 
 	return &Blockchain{}, false
 }
 
+/*
+Walk the whole blockchain from its genesis block and return an error naming
+the first block that does not hold: each block must be at its index, link
+to the previous block by its hash, carry the hash it declares, belong to
+this process's network, see help.NETWORK_ID, and validate,
+see block.Validate, so its Proof of Work meets its difficulty, declare the
+difficulty the blocks before it expect, see block.NextDifficulty, and carry a
+valid timestamp, see block.ValidTimestamp. Headers are verified as well. An
+empty blockchain is valid.
+*/
+func (bc *Blockchain) Verify() error {
+	var prevHash []byte
+	now := time.Now()
+	for i, b := range bc.Blocks {
+		if b.Index != i {
+			return fmt.Errorf("block %d is at index %d", b.Index, i)
+		}
+		if i > 0 && !bytes.Equal(b.PrevBlockHash, prevHash) {
+			return fmt.Errorf("block %d does not link to block %d", i, i-1)
+		}
+		if !bytes.Equal(b.SelfHash, b.Hash()) {
+			return fmt.Errorf("block %d does not match its hash", i)
+		}
+		if b.NetworkID != help.NETWORK_ID {
+			return fmt.Errorf("block %d belongs to network %q, not %q", i, b.NetworkID, help.NETWORK_ID)
+		}
+		if !b.Validate() {
+			return fmt.Errorf("block %d is not valid", i)
+		}
+		// A header's entries may hold a difficulty change, so the block after one is taken at its word
+		if i > 0 && b.Proposer == "" && !bc.Blocks[i-1].HeaderOnly && b.Difficulty != block.NextDifficulty(bc.Blocks[:i]) {
+			return fmt.Errorf("block %d declares difficulty %d, not the %d its blockchain expects", i, b.Difficulty, block.NextDifficulty(bc.Blocks[:i]))
+		}
+		if !block.ValidTimestamp(bc.Blocks[:i], b, now) {
+			return fmt.Errorf("block %d is timestamped in the future or before the blocks it follows", i)
+		}
+		prevHash = b.SelfHash
+	}
+	return nil
+}
+
 /* Print a blockchain's blocks and fields to the console */
 func PrintBlockchain(blockchain Blockchain) {
 	fmt.Println("---------------------------------**Blockchain**---------------------------------")
@@ -48,7 +92,8 @@ func PrintBlockchain(blockchain Blockchain) {
 		fmt.Printf("  PrevBlockHash: %x\n", string(block.PrevBlockHash))
 		fmt.Printf("  Index: %d\n", block.Index)
 		fmt.Printf("  Timestamp: %d\n", block.Timestamp)
-		fmt.Printf("  Data: %s\n", string(block.Content))
+		fmt.Printf("  Data: %s\n", block.ContentString())
+		fmt.Printf("  Difficulty: %d\n", block.Difficulty)
 		fmt.Printf("  Nonce: %d\n", block.Nonce)
 		fmt.Printf("  SelfHash: %x\n", string(block.SelfHash))
 	}
@@ -0,0 +1,214 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Environment variable holding the path of the configuration file, see FromEnvironment */
+const CONFIG_ENV string = "BLOCKCHAIN_CONFIG"
+
+/* Prefix of the environment variables overriding the configuration, e.g. BLOCKCHAIN_DIFFICULTY */
+const ENV_PREFIX string = "BLOCKCHAIN_"
+
+/*
+The runtime configuration of the blockchain network. Each field is set by
+the key in its yaml tag in a configuration file, or by the environment
+variable ENV_PREFIX followed by that key in upper case, which wins over
+the file. Durations are written as in time.ParseDuration, e.g. 500ms.
+*/
+type Config struct {
+	Difficulty int    `yaml:"difficulty"` // Difficulty of the genesis block
+	SeedPort   string `yaml:"seed_port"`  // Port of the first node, the others join through it
+	UserList   string `yaml:"user_list"`  // File holding the registered users, or the URL of a registry; empty for one main.go runs
+	StoreDir   string `yaml:"store_dir"`  // Directory nodes store their blockchain in
+	ReceiptDir string `yaml:"receipt_dir"`
+	BlobDir    string `yaml:"blob_dir"` // Directory off-chain content is stored in
+
+	WaitTime            time.Duration `yaml:"wait_time"` // Most time main.go waits for the network to process each step
+	DivergenceCheckTime time.Duration `yaml:"divergence_check_time"`
+	LivenessCheckTime   time.Duration `yaml:"liveness_check_time"`
+	DrainGraceTime      time.Duration `yaml:"drain_grace_time"`
+	SubscribePingTime   time.Duration `yaml:"subscribe_ping_time"`
+	ReceiptCheckTime    time.Duration `yaml:"receipt_check_time"`
+	ResubmitBackoff     time.Duration `yaml:"resubmit_backoff"`
+
+	OffchainSize     int           `yaml:"offchain_size"`      // Content longer than this many bytes is stored off-chain
+	MaxContentSize   int           `yaml:"max_content_size"`   // Bytes a content entry on the blockchain may take
+	MaxBlockSize     int           `yaml:"max_block_size"`     // Bytes the content entries of a block may take together
+	MaxFutureDrift   time.Duration `yaml:"max_future_drift"`   // How far ahead of a node's clock a block may be timestamped
+	MedianTimeBlocks int           `yaml:"median_time_blocks"` // Last blocks whose median timestamp a block may not be earlier than
+	Quorum           string        `yaml:"quorum"`             // Nodes that must agree: majority, two_thirds or a number of nodes
+	NetworkID        string        `yaml:"network_id"`         // Network the nodes and users belong to, empty for the default one
+	BanThreshold     int           `yaml:"ban_threshold"`      // Strikes after which a node bans a misbehaving peer, 0 never bans
+	FinalityDepth    int           `yaml:"finality_depth"`     // Blocks on top of a block before it is considered irreversible
+	FeePerByte       uint64        `yaml:"fee_per_byte"`       // Fee each byte of content costs its user
+	MinFee           uint64        `yaml:"min_fee"`            // Least fee any content pays
+	Governors        string        `yaml:"governors"`          // Comma separated addresses of the wallets that may change the difficulty, none may
+	LogLevel         string        `yaml:"log_level"`
+	LogFile          string        `yaml:"log_file"` // Empty logs to stdout
+}
+
+/*
+Return the configuration the network ran with before it was configurable,
+but for the user list: main.go runs a registry instead of sharing a file,
+and the quorum: two thirds of the nodes, rounded up, where it used to be
+rounded down to an even count, i.e. none of a network of 2 nodes.
+*/
+func Default() Config {
+	return Config{
+		Difficulty: 18,
+		SeedPort:   "1234",
+		UserList:   "",
+		StoreDir:   "/tmp/",
+		ReceiptDir: "/tmp/",
+		BlobDir:    "/tmp/Blobs/",
+
+		WaitTime:            2000 * time.Millisecond,
+		DivergenceCheckTime: 1000 * time.Millisecond,
+		LivenessCheckTime:   1000 * time.Millisecond,
+		DrainGraceTime:      2000 * time.Millisecond,
+		SubscribePingTime:   15 * time.Second,
+		ReceiptCheckTime:    500 * time.Millisecond,
+		ResubmitBackoff:     2000 * time.Millisecond,
+
+		OffchainSize:     1024,
+		MaxContentSize:   64 << 10,
+		MaxBlockSize:     1 << 20,
+		MaxFutureDrift:   2 * time.Minute,
+		MedianTimeBlocks: 11,
+		Quorum:           "two_thirds",
+		NetworkID:        "",
+		BanThreshold:     5,
+		FinalityDepth:    6,
+		FeePerByte:       0,
+		MinFee:           0,
+		Governors:        "",
+		LogLevel:         "info",
+		LogFile:          "output.txt",
+	}
+}
+
+/*
+Return the default configuration, overridden by the configuration file at
+path, if not empty, then by the environment.
+*/
+func Load(path string) (Config, error) {
+	config := Default()
+	if path != "" {
+		if err := config.readFile(path); err != nil {
+			return config, err
+		}
+	}
+	return config, config.readEnv()
+}
+
+/*
+Load the configuration file named by CONFIG_ENV, if set, see Load.
+*/
+func FromEnvironment() (Config, error) {
+	return Load(os.Getenv(CONFIG_ENV))
+}
+
+/*
+Read a flat YAML file of "key: value" lines. Blank lines and comments
+starting with # are skipped, and values may be quoted.
+*/
+func (config *Config) readFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			return fmt.Errorf("%s:%d: expected key: value", path, n)
+		}
+		if err := config.set(strings.TrimSpace(key), unquote(value)); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+	}
+	return scanner.Err()
+}
+
+/* Return value without its trailing comment and surrounding quotes */
+func unquote(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') {
+		if end := strings.IndexByte(value[1:], value[0]); end >= 0 {
+			return value[1 : end+1]
+		}
+	}
+	if comment := strings.Index(value, " #"); comment >= 0 {
+		value = strings.TrimSpace(value[:comment])
+	}
+	return value
+}
+
+/*
+Override the configuration with the ENV_PREFIX environment variables that are set.
+*/
+func (config *Config) readEnv() error {
+	fields := reflect.TypeOf(*config)
+	for i := 0; i < fields.NumField(); i++ {
+		key := fields.Field(i).Tag.Get("yaml")
+		name := ENV_PREFIX + strings.ToUpper(key)
+		if value, set := os.LookupEnv(name); set {
+			if err := config.set(key, value); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+/*
+Set the field with the given yaml key to value, parsed by the field's type.
+*/
+func (config *Config) set(key string, value string) error {
+	fields := reflect.TypeOf(*config)
+	values := reflect.ValueOf(config).Elem()
+	for i := 0; i < fields.NumField(); i++ {
+		if fields.Field(i).Tag.Get("yaml") != key {
+			continue
+		}
+
+		field := values.Field(i)
+		switch field.Interface().(type) {
+		case time.Duration:
+			duration, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("invalid duration %q for %s", value, key)
+			}
+			field.SetInt(int64(duration))
+		case int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid number %q for %s", value, key)
+			}
+			field.SetInt(int64(n))
+		case uint64:
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid number %q for %s", value, key)
+			}
+			field.SetUint(n)
+		case string:
+			field.SetString(value)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown key %q", key)
+}
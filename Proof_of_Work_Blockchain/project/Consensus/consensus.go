@@ -0,0 +1,62 @@
+/*
+How nodes agree on the blocks of the blockchain. A consensus engine seals
+the blocks a node makes and verifies the blocks its peers send, so nodes
+handle requests the same whichever engine they run, e.g. Proof of Work,
+see Consensus/pow, or Proof of Stake, see Consensus/pos. All the nodes of
+a network must run the same engine.
+*/
+
+package consensus
+
+import (
+	blk "project/Block"
+)
+
+type Consensus interface {
+	/*
+		Prepare block, holding content, to follow blocks on the node at port,
+		among the given peers, e.g. set who made it. Returns false if that
+		node may not seal the block.
+	*/
+	Prepare(blocks []*blk.Block, block *blk.Block, port string, peers []string) bool
+
+	/*
+		Seal a prepared block, e.g. mine its Proof of Work, and set its hash.
+		Returns false if sealing stopped because interrupted returned true,
+		e.g. once a peer's block for the same index was validated.
+	*/
+	Seal(block *blk.Block, interrupted func() bool) bool
+
+	/*
+		Returns true if block, following blocks, was sealed as the engine
+		requires, by one of the given peers.
+	*/
+	Verify(blocks []*blk.Block, block *blk.Block, peers []string) bool
+}
+
+/*
+An engine where a single node, the leader, may seal the block following
+blocks, e.g. the proposer under Proof of Stake. Other nodes relay the
+content they receive to it.
+*/
+type Leader interface {
+	/*
+		Return the leader of the block following blocks among the peers.
+		Returns false if none of them may lead.
+	*/
+	Leader(blocks []*blk.Block, peers []string) (string, bool)
+}
+
+/*
+An engine whose sealing may stop and resume later where it got to, e.g.
+after the node crashed while mining.
+*/
+type Resumable interface {
+	/*
+		Seal a prepared block as Seal does, starting from progress, as
+		reported before to checkpoint for the same block. Nil progress starts
+		from scratch. The progress made is reported to checkpoint every so
+		often, unless it is nil.
+	*/
+	Resume(block *blk.Block, progress []int, interrupted func() bool, checkpoint func(progress []int)) bool
+}
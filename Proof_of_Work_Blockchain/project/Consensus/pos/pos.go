@@ -0,0 +1,162 @@
+/*
+Proof of Stake, an alternative to mining blocks with a Proof of Work.
+
+Registered nodes stake a weight on the StakeList. For each height of the
+blockchain, a single proposer is selected among the staked nodes on the
+network, each with a chance proportional to its stake, from the hash of
+the previous block. Every node selects the same proposer, so only that
+node seals the next block, and the others refuse blocks proposed by
+anyone else. Proposed blocks share the Block type with mined ones: their
+Proposer is set and their hash is checked instead of a Proof of Work.
+
+Limitation: nodes hold no keys, so a block is not signed by its proposer.
+Run nodes with mutual TLS so only peers can propose blocks.
+*/
+
+package pos
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"math/big"
+	"os"
+	blk "project/Block"
+	cs "project/Consensus"
+	help "project/Helpers"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+var stakes_mutex sync.Mutex
+
+var _ cs.Consensus = (*Engine)(nil)
+var _ cs.Leader = (*Engine)(nil)
+
+/* Weight each node stakes, keyed by its port */
+type Stakes map[string]int
+
+/*
+Read the stakes registered on the StakeList.
+*/
+func LoadStakes(StakeList string) Stakes {
+	data, err := os.ReadFile(StakeList)
+	if err != nil || len(data) == 0 {
+		return Stakes{} // No stakes yet
+	}
+
+	var stakes Stakes
+	if help.Check(json.Unmarshal(data, &stakes)) {
+		return Stakes{}
+	}
+	return stakes
+}
+
+/*
+Register the node at port with the given stake on the StakeList, replacing
+its previous stake. A new file is written then renamed, so nodes never
+read half a list.
+*/
+func RegisterStake(StakeList string, port string, stake int) error {
+	stakes_mutex.Lock()
+	defer stakes_mutex.Unlock()
+
+	stakes := LoadStakes(StakeList)
+	stakes[port] = stake
+
+	data, err := json.Marshal(stakes)
+	if err != nil {
+		return err
+	}
+	tmp := StakeList + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, StakeList)
+}
+
+/*
+Return the proposer of the block at height, following the block with hash
+prevHash, among the eligible nodes with a positive stake. Each is selected
+with a chance proportional to its stake. Returns false if none of the
+eligible nodes has a stake.
+*/
+func (stakes Stakes) Proposer(height int, prevHash []byte, eligible []string) (string, bool) {
+	ports := []string{}
+	total := int64(0)
+	for _, port := range eligible {
+		if stakes[port] > 0 {
+			ports = append(ports, port)
+			total += int64(stakes[port])
+		}
+	}
+	if total == 0 {
+		return "", false
+	}
+	sort.Strings(ports) // Every node walks the stakes in the same order
+
+	seed := sha256.Sum256(append(append([]byte{}, prevHash...), []byte(strconv.Itoa(height))...))
+	ticket := new(big.Int).Mod(new(big.Int).SetBytes(seed[:]), big.NewInt(total)).Int64()
+	for _, port := range ports {
+		ticket -= int64(stakes[port])
+		if ticket < 0 {
+			return port, true
+		}
+	}
+	return ports[len(ports)-1], true // Not reached
+}
+
+/*
+The Proof of Stake of a node, selecting proposers among the nodes staked
+on its StakeList.
+*/
+type Engine struct {
+	StakeList string
+}
+
+func NewEngine(StakeList string) *Engine {
+	return &Engine{StakeList: StakeList}
+}
+
+/*
+Return the proposer of the block following blocks, among the peers.
+*/
+func (engine *Engine) Leader(blocks []*blk.Block, peers []string) (string, bool) {
+	var prevHash []byte
+	if len(blocks) > 0 {
+		prevHash = blocks[len(blocks)-1].SelfHash
+	}
+	return LoadStakes(engine.StakeList).Proposer(len(blocks), prevHash, peers)
+}
+
+/*
+Prepare a block proposed by the node at port, if it is the proposer
+selected among the peers: it carries no Proof of Work, its hash covers
+its proposer instead.
+*/
+func (engine *Engine) Prepare(blocks []*blk.Block, block *blk.Block, port string, peers []string) bool {
+	if proposer, ok := engine.Leader(blocks, peers); !ok || proposer != port {
+		return false
+	}
+	block.Proposer = port
+	block.Difficulty = 0
+	block.Nonce = 0
+	return true
+}
+
+/*
+Seal a prepared block, right away.
+*/
+func (engine *Engine) Seal(block *blk.Block, interrupted func() bool) bool {
+	block.SelfHash = block.Hash()
+	return true
+}
+
+/*
+Returns true if block, following blocks, was proposed by the proposer
+selected among the peers and its hash matches it.
+*/
+func (engine *Engine) Verify(blocks []*blk.Block, block *blk.Block, peers []string) bool {
+	proposer, ok := engine.Leader(blocks, peers)
+	return ok && block.Proposer == proposer && block.Validate()
+}
@@ -0,0 +1,184 @@
+/*
+Proof of Work, the consensus nodes run by default. Any node may mine the
+next block: it searches for a nonce making the hash of the block fall
+below the target of the difficulty the blockchain expects next, see
+blk.NextDifficulty. Peers verify the hash in a single try.
+*/
+
+package pow
+
+import (
+	"crypto/sha256"
+	"math"
+	"math/big"
+	blk "project/Block"
+	cs "project/Consensus"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/* Number of nonces tried between checks for interruptions */
+const INTERRUPT_CHECK_NONCES int = 1000
+
+/* How often the progress of mining is reported, see Resume */
+var CHECKPOINT_INTERVAL time.Duration = time.Second
+
+var _ cs.Consensus = (*Engine)(nil)
+var _ cs.Resumable = (*Engine)(nil)
+
+/*
+Proof of Work mined on Miners goroutines, each over its own range of
+nonces. 0 mines on a single goroutine.
+*/
+type Engine struct {
+	Miners int
+}
+
+func NewEngine(miners int) *Engine {
+	return &Engine{Miners: miners}
+}
+
+/*
+Any node may mine a block, at the difficulty it declares.
+*/
+func (engine *Engine) Prepare(blocks []*blk.Block, block *blk.Block, port string, peers []string) bool {
+	return block.Difficulty >= blk.MIN_DIFFICULTY && block.Difficulty <= blk.MAX_DIFFICULTY
+}
+
+/*
+Search a nonce for the block on engine.Miners goroutines, each over its own
+range of nonces, until one finds a hash below the target. The first one
+found stops the others. Mining is interrupted, and false returned, once
+interrupted returns true.
+*/
+func (engine *Engine) Seal(block *blk.Block, interrupted func() bool) bool {
+	return engine.Resume(block, nil, interrupted, nil)
+}
+
+/*
+Seal the block as Seal does, each miner starting from its nonce in
+progress, the next one it had to try. Progress of another number of miners
+is ignored. The next nonce of each miner is reported to checkpoint every
+CHECKPOINT_INTERVAL.
+*/
+func (engine *Engine) Resume(block *blk.Block, progress []int, interrupted func() bool, checkpoint func(progress []int)) bool {
+	workers := engine.Miners
+	if workers < 1 {
+		workers = 1
+	}
+	pow := blk.NewProofOfWork(block)
+
+	var stop int32 // Set once a nonce is found or mining is interrupted
+	var mu sync.Mutex
+	nonce, hash := -1, []byte{}
+
+	// Next nonce of each miner
+	span := math.MaxInt64 / workers
+	next := make([]int64, workers)
+	for w := range next {
+		next[w] = int64(w * span)
+		if len(progress) == workers && progress[w] >= w*span && progress[w] < w*span+span {
+			next[w] = int64(progress[w])
+		}
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			found, foundHash := searchNonces(pow, int(next[w]), w*span+span, &next[w], &stop, interrupted)
+			if found == -1 {
+				return
+			}
+
+			mu.Lock()
+			if nonce == -1 {
+				nonce, hash = found, foundHash
+			}
+			mu.Unlock()
+		}(w)
+	}
+
+	// Report the progress until the miners stop
+	done := make(chan struct{})
+	if checkpoint != nil {
+		go func() {
+			ticker := time.NewTicker(CHECKPOINT_INTERVAL)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					checkpoint(loadProgress(next))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+
+	// If this is a case of interruption by peer sending a valid block
+	if nonce == -1 || interrupted() {
+		return false
+	}
+
+	block.Nonce, block.SelfHash = nonce, hash
+	return true
+}
+
+/* Return the next nonce of each miner */
+func loadProgress(next []int64) []int {
+	progress := make([]int, len(next))
+	for w := range next {
+		progress[w] = int(atomic.LoadInt64(&next[w]))
+	}
+	return progress
+}
+
+/*
+Try the nonces from first up to last, excluded, and return the first one
+whose hash is below the target of pow, setting stop. Returns -1 once stop
+is set by another miner, or when mining is interrupted. The next nonce to
+try is stored in next as the search goes.
+*/
+func searchNonces(pow *blk.ProofOfWork, first int, last int, next *int64, stop *int32, interrupted func() bool) (int, []byte) {
+	var hashInt big.Int // Wraps poW hash for fast verification
+
+	for nonce := first; nonce < last; nonce++ {
+		if atomic.LoadInt32(stop) != 0 {
+			return -1, nil
+		}
+
+		// Merge the block and the nonce and hash them
+		hash := sha256.Sum256(pow.MergeBlockNonce(nonce))
+		hashInt.SetBytes(hash[:])
+
+		// PoW is legit if hashInt is less than pow.Target
+		if hashInt.Cmp(pow.Target) == -1 {
+			atomic.StoreInt32(stop, 1)
+			return nonce, hash[:]
+		}
+
+		if (nonce-first+1)%INTERRUPT_CHECK_NONCES == 0 {
+			atomic.StoreInt64(next, int64(nonce+1))
+
+			// Stop mining if a peer's block was validated meanwhile
+			if interrupted() {
+				atomic.StoreInt32(stop, 1)
+				return -1, nil
+			}
+		}
+	}
+	return -1, nil
+}
+
+/*
+Returns true if the block declares the difficulty the blockchain expects
+next and carries a valid Proof of Work at that difficulty.
+*/
+func (engine *Engine) Verify(blocks []*blk.Block, block *blk.Block, peers []string) bool {
+	return block.Proposer == "" && block.Difficulty == blk.NextDifficulty(blocks) && block.Validate()
+}
@@ -0,0 +1,187 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+/*
+Version of the messages nodes exchange, bumped whenever they change in a
+way older nodes would misread, e.g. a new block field covered by the Proof
+of Work.
+
+	1: blocks without authors
+	2: blocks list the authors of their entries
+	3: blocks list the content IDs of their entries
+*/
+const API_VERSION int = 3
+
+/* Oldest version this node can exchange messages with */
+const MIN_API_VERSION int = 3
+
+/* Header every call through HTTP_CLIENT, and every answer of a node, carries its version in */
+const API_VERSION_HEADER string = "X-Api-Version"
+
+const API_VERSION_PATH string = "/api_version"
+const HANDSHAKE_PATH string = "/handshake"
+
+/*
+The versions a node speaks, as returned by /api_version.
+*/
+type APIVersion struct {
+	Version    int `json:"version"`
+	MinVersion int `json:"min_version"`
+}
+
+/*
+What two nodes exchange on /handshake before they peer: the versions of the
+API they speak and the network they belong to, see CheckHandshake.
+*/
+type Handshake struct {
+	Port       string `json:"port"`
+	Version    int    `json:"version"`
+	MinVersion int    `json:"min_version"`
+	NetworkID  string `json:"network_id"`
+}
+
+/* Return the handshake of this process's node at port */
+func NewHandshake(port string) Handshake {
+	return Handshake{Port: port, Version: API_VERSION, MinVersion: MIN_API_VERSION, NetworkID: NETWORK_ID}
+}
+
+/*
+Returned by HTTP_CLIENT calls when the other side speaks a version of the
+API this node cannot exchange messages with. Callers treat the other side
+as if it did not answer.
+*/
+type IncompatibleVersionError struct {
+	URL     string
+	Version int // Version of the other side, 0 if it did not say
+}
+
+func (err *IncompatibleVersionError) Error() string {
+	return fmt.Sprintf("%s speaks API version %d, this node speaks %d down to %d", err.URL, err.Version, API_VERSION, MIN_API_VERSION)
+}
+
+/*
+Returns true if this node can exchange messages with a node speaking the given version.
+*/
+func CompatibleVersion(version int) bool {
+	return version >= MIN_API_VERSION
+}
+
+/*
+Return an IncompatibleVersionError unless this node and the node of the
+handshake can each read the other's messages, or a ForeignNetworkError if
+it belongs to another network. url names the other node in the error.
+*/
+func CheckHandshake(handshake Handshake, url string) error {
+	if !CompatibleVersion(handshake.Version) || API_VERSION < handshake.MinVersion {
+		return &IncompatibleVersionError{URL: url, Version: handshake.Version}
+	}
+	if handshake.NetworkID != NETWORK_ID {
+		return &ForeignNetworkError{URL: url, Network: handshake.NetworkID}
+	}
+	return nil
+}
+
+/*
+Return the version carried by headers, and false if they carry none,
+e.g. for a user calling with curl.
+*/
+func HeaderVersion(header http.Header) (int, bool) {
+	value := header.Get(API_VERSION_HEADER)
+	if value == "" {
+		return 0, false
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, true // Not a version this node knows of
+	}
+	return version, true
+}
+
+/*
+Sends this node's version and network ID with every call, and turns answers
+from nodes speaking an incompatible version into an IncompatibleVersionError,
+and answers from nodes of another network into a ForeignNetworkError, so
+their bodies are never decoded.
+*/
+type versionTransport struct {
+	base http.RoundTripper
+}
+
+func (transport versionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request it is given
+	req = req.Clone(req.Context())
+	req.Header.Set(API_VERSION_HEADER, strconv.Itoa(API_VERSION))
+	req.Header.Set(NETWORK_ID_HEADER, NETWORK_ID)
+
+	resp, err := transport.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	version, versioned := HeaderVersion(resp.Header)
+	if resp.StatusCode == http.StatusUpgradeRequired || (versioned && !CompatibleVersion(version)) {
+		CloseBody(resp)
+		return nil, &IncompatibleVersionError{URL: req.URL.String(), Version: version}
+	}
+	if resp.StatusCode == http.StatusMisdirectedRequest || !SameNetwork(resp.Header) {
+		CloseBody(resp)
+		return nil, &ForeignNetworkError{URL: req.URL.String(), Network: resp.Header.Get(NETWORK_ID_HEADER)}
+	}
+	return resp, nil
+}
+
+/*
+Ask the node at port for the versions it speaks.
+*/
+func GetAPIVersion(port string) (APIVersion, error) {
+	var version APIVersion
+	resp, err := HTTP_CLIENT.Get(NodeURL(port) + API_VERSION_PATH)
+	if err != nil {
+		return version, err
+	}
+	defer CloseBody(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		// Nodes from before versioning do not know /api_version
+		return APIVersion{Version: 1, MinVersion: 1}, nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(&version)
+	return version, err
+}
+
+/*
+Send /handshake to the node at port on behalf of this process's node at
+self, and return the node's handshake. Returns an IncompatibleVersionError
+or a ForeignNetworkError if the nodes cannot peer, see CheckHandshake. A
+node from before /handshake is asked for its versions instead.
+*/
+func SendHandshake(port string, self string) (Handshake, error) {
+	url := NodeURL(port) + HANDSHAKE_PATH
+	body, err := json.Marshal(NewHandshake(self))
+	if err != nil {
+		return Handshake{}, err
+	}
+	resp, err := HTTP_CLIENT.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return Handshake{}, err
+	}
+	defer CloseBody(resp)
+
+	var handshake Handshake
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&handshake) != nil {
+		// A node from before /handshake, of this network since it answered
+		version, err := GetAPIVersion(port)
+		if err != nil {
+			return handshake, err
+		}
+		handshake = Handshake{Port: port, Version: version.Version, MinVersion: version.MinVersion, NetworkID: NETWORK_ID}
+	}
+	return handshake, CheckHandshake(handshake, url)
+}
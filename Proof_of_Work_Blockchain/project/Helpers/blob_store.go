@@ -0,0 +1,183 @@
+package helpers
+
+import (
+	"bytes"
+	"dfsclient"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+A store for content bodies kept off-chain. Users put large content in it,
+and blocks only carry the content's hash and the location it was put at.
+*/
+type BlobStore interface {
+	// Store a body under its hash and return its location
+	Put(hash string, body []byte) (string, error)
+	// Return the body stored at a location
+	Get(location string) ([]byte, error)
+}
+
+/*
+The blob store shared by Users and Nodes. Any BlobStore can be plugged
+in here, e.g. a DFSBlobStore to keep the bodies on the distributed file system.
+*/
+var BLOB_STORE BlobStore = DirBlobStore{Dir: "/tmp/Blobs/"}
+
+/*
+A blob store that keeps each body in a file of a local directory.
+*/
+type DirBlobStore struct {
+	Dir string
+}
+
+func (store DirBlobStore) Put(hash string, body []byte) (string, error) {
+	if err := os.MkdirAll(store.Dir, 0755); err != nil {
+		return "", err
+	}
+
+	location := filepath.Join(store.Dir, hash)
+	return location, os.WriteFile(location, body, 0644)
+}
+
+func (store DirBlobStore) Get(location string) ([]byte, error) {
+	return os.ReadFile(location)
+}
+
+/*
+A blob store that keeps each body in a file of the Distributed Filesystem,
+under Dir. Server is the service address of the DFS Naming Server, e.g. localhost:4444.
+
+Bodies are read in chunks of ChunkSize bytes, Readers at a time, spread
+over every replica of the file. A chunk that cannot be read from one
+replica is retried on the others.
+*/
+type DFSBlobStore struct {
+	Server    string
+	Dir       string
+	ChunkSize int64 // dfsclient.CHUNK_SIZE if 0
+	Readers   int   // dfsclient.READERS if 0
+}
+
+/* Requests and responses of the DFS, see its API directory */
+type dfsPathRequest struct {
+	Path string `json:"path"`
+}
+
+type dfsWriteRequest struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+	Data   []byte `json:"data"` // Encoded as Base64
+}
+
+type dfsServerInfo struct {
+	ServerIP   string `json:"server_ip"`
+	ServerPort int    `json:"server_port"`
+}
+
+type dfsReplicas struct {
+	Size     int64           `json:"size"`
+	Replicas []dfsServerInfo `json:"replicas"`
+}
+
+type dfsResponse struct {
+	Success       bool   `json:"success"`
+	Size          int64  `json:"size"`
+	ExceptionType string `json:"exception_type"`
+	ExceptionInfo string `json:"exception_info"`
+}
+
+/*
+Send a command to a DFS server and decode its response into response.
+*/
+func dfsCall(address string, command string, body interface{}, response interface{}) error {
+	jsonBytes, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := HTTP_CLIENT.Post("http://"+address+command, "application/json", bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		return err
+	}
+	defer CloseBody(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		var exception dfsResponse
+		json.NewDecoder(resp.Body).Decode(&exception)
+		return fmt.Errorf("%s failed: %s %s", command, exception.ExceptionType, exception.ExceptionInfo)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+/*
+Return the client address of the storage server hosting a file.
+*/
+func (store DFSBlobStore) storage(path string) (string, error) {
+	var info dfsServerInfo
+	if err := dfsCall(store.Server, "/get_storage", dfsPathRequest{path}, &info); err != nil {
+		return "", err
+	}
+	return info.address(), nil
+}
+
+/*
+Return the client address of a storage server.
+*/
+func (info dfsServerInfo) address() string {
+	// Storage servers register their IP as a URL prefix, e.g. "http://127.0.0.1:"
+	host := strings.TrimSuffix(strings.TrimPrefix(info.ServerIP, "http://"), ":")
+	return fmt.Sprintf("%s:%d", host, info.ServerPort)
+}
+
+func (store DFSBlobStore) Put(hash string, body []byte) (string, error) {
+	var response dfsResponse
+
+	// The directory may already exist, in which case success is false
+	if err := dfsCall(store.Server, "/create_directory", dfsPathRequest{store.Dir}, &response); err != nil {
+		return "", err
+	}
+
+	// Bodies are stored under their hash, so an existing file already holds this body
+	path := store.Dir + "/" + hash
+	if err := dfsCall(store.Server, "/create_file", dfsPathRequest{path}, &response); err != nil {
+		return "", err
+	}
+	if !response.Success {
+		return path, nil
+	}
+
+	address, err := store.storage(path)
+	if err != nil {
+		return "", err
+	}
+
+	if err := dfsCall(address, "/storage_write", dfsWriteRequest{path, 0, body}, &response); err != nil {
+		return "", err
+	}
+	if !response.Success {
+		return "", errors.New("could not write " + path)
+	}
+
+	return path, nil
+}
+
+func (store DFSBlobStore) Get(location string) ([]byte, error) {
+	var replicas dfsReplicas
+	if err := dfsCall(store.Server, "/get_replicas", dfsPathRequest{location}, &replicas); err != nil {
+		return nil, err
+	}
+
+	addresses := []string{}
+	for _, info := range replicas.Replicas {
+		addresses = append(addresses, info.address())
+	}
+
+	return dfsclient.ReadChunks(addresses, location, replicas.Size, store.ChunkSize, store.Readers)
+}
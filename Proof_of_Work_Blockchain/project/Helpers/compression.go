@@ -0,0 +1,96 @@
+package helpers
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/* The content encoding of compressed responses, e.g. whole blockchains */
+const GZIP_ENCODING string = "gzip"
+
+/*
+Returns true if the caller of the request accepts gzip compressed
+responses, see its Accept-Encoding header. "gzip;q=0" refuses them.
+*/
+func AcceptsGzip(r *http.Request) bool {
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(coding), ";")
+			if !strings.EqualFold(strings.TrimSpace(name), GZIP_ENCODING) {
+				continue
+			}
+			for _, param := range strings.Split(params, ";") {
+				key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if q, err := strconv.ParseFloat(value, 64); key == "q" && err == nil && q == 0 {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Reply 200 OK with v encoded as JSON, gzip compressed if the caller accepts
+it, see AcceptsGzip.
+*/
+func WriteJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !AcceptsGzip(r) {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(v)
+		return
+	}
+
+	w.Header().Set("Content-Encoding", GZIP_ENCODING)
+	w.WriteHeader(http.StatusOK)
+	gz := gzip.NewWriter(w)
+	json.NewEncoder(gz).Encode(v)
+	gz.Close()
+}
+
+/* A gzip compressed response body, read decompressed */
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (body gzipBody) Close() error {
+	body.Reader.Close()
+	return body.body.Close()
+}
+
+/*
+Send GET url with HTTP_CLIENT, accepting a gzip compressed response. The
+body of the response returned is decompressed, whether the other side
+compressed it or not, e.g. a node from before compression.
+*/
+func GetGzip(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept-Encoding", GZIP_ENCODING)
+
+	resp, err := HTTP_CLIENT.Do(req)
+	if err != nil || !strings.EqualFold(resp.Header.Get("Content-Encoding"), GZIP_ENCODING) {
+		return resp, err
+	}
+
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		CloseBody(resp)
+		return nil, err
+	}
+	resp.Body = gzipBody{Reader: gz, body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
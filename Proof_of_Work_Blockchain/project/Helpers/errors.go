@@ -1,13 +1,12 @@
 package helpers
 
-import "fmt"
-
 /*
-Returns true if the error exists and false if it does not
+Returns true if the error exists and false if it does not.
+The error is logged to LOG as a warning.
 */
 func Check(err error) bool {
 	if err != nil {
-		fmt.Println(err)
+		LOG.Warnf("%v", err)
 		return true
 	}
 	return false
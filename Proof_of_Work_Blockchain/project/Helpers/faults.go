@@ -0,0 +1,157 @@
+package helpers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+	Fault injection between nodes, like the LeakySocket of the Raft project.
+	A Faults drops, delays or duplicates messages at random, each with its
+	own probability, from a seeded source, so a network under faults can be
+	replayed. Faults apply to the messages a node serves, see LeakyHandler,
+	or to every call made with HTTP_CLIENT, see InjectFaults.
+*/
+
+/* Returned by the calls a LeakyTransport drops */
+var ErrDropped = errors.New("message dropped by fault injection")
+
+/* Probabilities and durations of the faults injected into messages */
+type Faults struct {
+	DropRate      float64       // Probability of a message being lost, its call failing
+	DropTimeout   time.Duration // Time a lost message takes to fail, like a timeout
+	DelayRate     float64       // Probability of a message being delayed
+	Delay         time.Duration // Most a delayed message is delayed by
+	DuplicateRate float64       // Probability of a message being sent twice, the copy's answer discarded
+	Seed          int64         // Seed of the random source, so faults can be replayed
+
+	random *rand.Rand
+	mu     sync.Mutex // Guards random
+}
+
+/*
+Return the faults a message suffers: whether it is dropped, how long it is
+delayed and whether it is duplicated.
+*/
+func (faults *Faults) draw() (bool, time.Duration, bool) {
+	faults.mu.Lock()
+	defer faults.mu.Unlock()
+
+	if faults.random == nil {
+		faults.random = rand.New(rand.NewSource(faults.Seed))
+	}
+	if faults.random.Float64() < faults.DropRate {
+		return true, 0, false
+	}
+	var delay time.Duration
+	if faults.random.Float64() < faults.DelayRate && faults.Delay > 0 {
+		delay = time.Duration(faults.random.Int63n(int64(faults.Delay) + 1))
+	}
+	return false, delay, faults.random.Float64() < faults.DuplicateRate
+}
+
+/* A response writer discarding what is written to it, for duplicated messages */
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+
+/*
+Return a handler serving requests with handler under faults. A dropped
+request is aborted without an answer after DropTimeout, a delayed one
+waits before it is served, and a duplicated one is served twice, the first
+answer being discarded. A nil faults injects none.
+*/
+func LeakyHandler(handler http.Handler, faults *Faults) http.Handler {
+	if faults == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dropped, delay, duplicated := faults.draw()
+		if dropped {
+			time.Sleep(faults.DropTimeout)
+			panic(http.ErrAbortHandler) // Closes the connection, the caller gets no answer
+		}
+		time.Sleep(delay)
+
+		if duplicated {
+			body, err := io.ReadAll(r.Body)
+			if Check(err) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			duplicate := r.Clone(r.Context())
+			duplicate.Body = io.NopCloser(bytes.NewReader(body))
+			handler.ServeHTTP(&discardWriter{header: http.Header{}}, duplicate)
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+/*
+A round tripper sending requests with Base under faults. A dropped
+request fails with ErrDropped after DropTimeout without being sent, a
+delayed one waits before it is sent, and a duplicated one is sent twice,
+the first answer being discarded.
+*/
+type LeakyTransport struct {
+	Base   http.RoundTripper
+	Faults *Faults
+}
+
+func (transport LeakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	dropped, delay, duplicated := transport.Faults.draw()
+	if dropped {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		time.Sleep(transport.Faults.DropTimeout)
+		return nil, ErrDropped
+	}
+	time.Sleep(delay)
+
+	if duplicated {
+		var body []byte
+		if req.Body != nil {
+			var err error
+			body, err = io.ReadAll(req.Body)
+			req.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+		send := func() (*http.Response, error) {
+			clone := req.Clone(req.Context())
+			if body != nil {
+				clone.Body = io.NopCloser(bytes.NewReader(body))
+			}
+			return transport.Base.RoundTrip(clone)
+		}
+		if resp, err := send(); err == nil {
+			CloseBody(resp)
+		}
+		return send()
+	}
+	return transport.Base.RoundTrip(req)
+}
+
+/*
+Inject faults into every call made with HTTP_CLIENT, by nodes and users
+alike, until InjectFaults(nil) removes them.
+*/
+func InjectFaults(faults *Faults) {
+	var base http.RoundTripper = http_transport
+	if faults != nil {
+		base = LeakyTransport{Base: http_transport, Faults: faults}
+	}
+	HTTP_CLIENT.Transport = versionTransport{base: base}
+}
@@ -0,0 +1,44 @@
+package helpers
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+/* Limits and timeouts of the shared HTTP client */
+const DIAL_TIMEOUT = 2 * time.Second     // Time to connect to a peer
+const IDLE_TIMEOUT = 90 * time.Second    // Time an unused connection is kept open
+const REQUEST_TIMEOUT = 60 * time.Second // Time a whole call may take, mining included
+const MAX_IDLE_CONNS_PER_HOST = 16       // Open unused connections kept per peer
+const MAX_CONNS_PER_HOST = 64            // Connections per peer, used or not
+
+/*
+The HTTP client used for every call Nodes and Users make to Nodes.
+Its transport keeps connections alive, so calls to a peer reuse an open
+connection instead of dialing a new one each time, and negotiates the
+API version with the other side, see API_VERSION.
+*/
+var HTTP_CLIENT = &http.Client{
+	Transport: versionTransport{base: http_transport},
+	Timeout:   REQUEST_TIMEOUT,
+}
+
+/* The transport of HTTP_CLIENT, configured for TLS by EnableTLS */
+var http_transport = &http.Transport{
+	DialContext:         (&net.Dialer{Timeout: DIAL_TIMEOUT, KeepAlive: 30 * time.Second}).DialContext,
+	MaxIdleConns:        MAX_IDLE_CONNS_PER_HOST * 8,
+	MaxIdleConnsPerHost: MAX_IDLE_CONNS_PER_HOST,
+	MaxConnsPerHost:     MAX_CONNS_PER_HOST,
+	IdleConnTimeout:     IDLE_TIMEOUT,
+}
+
+/*
+Read the rest of a response's body and close it, so its connection
+can be reused for the next call.
+*/
+func CloseBody(resp *http.Response) {
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
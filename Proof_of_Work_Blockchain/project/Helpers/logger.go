@@ -0,0 +1,255 @@
+package helpers
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Severity of a log line, a logger drops the lines below its level */
+type Level int
+
+const (
+	DEBUG Level = iota
+	INFO
+	WARN
+	ERROR
+)
+
+var level_names = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+/* Layout of the time starting each log line */
+const LOG_TIME_LAYOUT string = "2006-01-02 15:04:05.000"
+
+/* Logger of the nodes and users that were not given their own, to stdout at INFO */
+var LOG *Logger = NewLogger(os.Stdout, INFO)
+
+func (level Level) String() string {
+	if level < DEBUG || level > ERROR {
+		return fmt.Sprintf("LEVEL(%d)", int(level))
+	}
+	return level_names[level]
+}
+
+/*
+Return the level with the given name, e.g. "info", whatever its case.
+*/
+func ParseLevel(name string) (Level, error) {
+	for level, level_name := range level_names {
+		if strings.EqualFold(name, level_name) {
+			return Level(level), nil
+		}
+	}
+	return INFO, fmt.Errorf("unknown log level %q, expected one of %s", name, strings.Join(level_names, ", "))
+}
+
+/*
+A leveled logger writing whole lines to an io.Writer, e.g. os.Stdout or a
+RotatingFile. Loggers derived with With add their prefix to each line and
+share the output and level of their parent. A nil *Logger logs to LOG.
+*/
+type Logger struct {
+	sink   *logSink
+	prefix string
+}
+
+/* The output and level shared by a logger and the loggers derived from it */
+type logSink struct {
+	mu    sync.Mutex
+	out   io.Writer
+	level Level
+}
+
+func NewLogger(out io.Writer, level Level) *Logger {
+	return &Logger{sink: &logSink{out: out, level: level}}
+}
+
+/*
+Return a logger writing to the same output at the same level, with prefix
+added to its lines, e.g. "node 1234".
+*/
+func (logger *Logger) With(prefix string) *Logger {
+	if logger == nil {
+		logger = LOG
+	}
+	if logger.prefix != "" {
+		prefix = logger.prefix + " " + prefix
+	}
+	return &Logger{sink: logger.sink, prefix: prefix}
+}
+
+/*
+Drop the lines below level, for this logger and the loggers sharing its output.
+*/
+func (logger *Logger) SetLevel(level Level) {
+	if logger == nil {
+		logger = LOG
+	}
+	logger.sink.mu.Lock()
+	defer logger.sink.mu.Unlock()
+	logger.sink.level = level
+}
+
+/*
+Write the lines of this logger, and of the loggers sharing its output, to out.
+*/
+func (logger *Logger) SetOutput(out io.Writer) {
+	if logger == nil {
+		logger = LOG
+	}
+	logger.sink.mu.Lock()
+	defer logger.sink.mu.Unlock()
+	logger.sink.out = out
+}
+
+/*
+Return true if the logger writes lines of the given level.
+*/
+func (logger *Logger) Enabled(level Level) bool {
+	if logger == nil {
+		logger = LOG
+	}
+	logger.sink.mu.Lock()
+	defer logger.sink.mu.Unlock()
+	return level >= logger.sink.level
+}
+
+/*
+Write a line of the given level, formatted as with fmt.Printf, unless the
+logger drops that level. The line starts with the time, the level and the
+logger's prefix, and a trailing newline is added if missing.
+*/
+func (logger *Logger) Logf(level Level, format string, args ...interface{}) {
+	if logger == nil {
+		logger = LOG
+	}
+
+	var line strings.Builder
+	line.WriteString(time.Now().Format(LOG_TIME_LAYOUT))
+	fmt.Fprintf(&line, " %-5s ", level)
+	if logger.prefix != "" {
+		line.WriteString("[" + logger.prefix + "] ")
+	}
+	fmt.Fprintf(&line, format, args...)
+	if !strings.HasSuffix(line.String(), "\n") {
+		line.WriteString("\n")
+	}
+
+	logger.sink.mu.Lock()
+	defer logger.sink.mu.Unlock()
+	if level < logger.sink.level || logger.sink.out == nil {
+		return
+	}
+	io.WriteString(logger.sink.out, line.String())
+}
+
+func (logger *Logger) Debugf(format string, args ...interface{}) {
+	logger.Logf(DEBUG, format, args...)
+}
+
+func (logger *Logger) Infof(format string, args ...interface{}) {
+	logger.Logf(INFO, format, args...)
+}
+
+func (logger *Logger) Warnf(format string, args ...interface{}) {
+	logger.Logf(WARN, format, args...)
+}
+
+func (logger *Logger) Errorf(format string, args ...interface{}) {
+	logger.Logf(ERROR, format, args...)
+}
+
+/*
+A log file that is rotated once it grows past MaxBytes: the file is renamed
+to <path>.1, the previous <path>.1 to <path>.2 and so on, keeping Backups
+old files, and a new file is started at path. A MaxBytes of 0 never rotates.
+*/
+type RotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	size     int64
+	MaxBytes int64
+	Backups  int
+}
+
+/*
+Open the log file at path, appending to it if it exists.
+*/
+func OpenRotatingFile(path string, maxBytes int64, backups int) (*RotatingFile, error) {
+	rotating := &RotatingFile{path: path, MaxBytes: maxBytes, Backups: backups}
+	if err := rotating.open(); err != nil {
+		return nil, err
+	}
+	return rotating, nil
+}
+
+func (rotating *RotatingFile) open() error {
+	file, err := os.OpenFile(rotating.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rotating.file, rotating.size = file, info.Size()
+	return nil
+}
+
+/*
+Append p to the log file, rotating it first if p would take it past MaxBytes.
+*/
+func (rotating *RotatingFile) Write(p []byte) (int, error) {
+	rotating.mu.Lock()
+	defer rotating.mu.Unlock()
+
+	if rotating.file == nil {
+		return 0, os.ErrClosed
+	}
+	if rotating.MaxBytes > 0 && rotating.size > 0 && rotating.size+int64(len(p)) > rotating.MaxBytes {
+		if err := rotating.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rotating.file.Write(p)
+	rotating.size += int64(n)
+	return n, err
+}
+
+/*
+Shift the old files up by one, dropping the oldest, then start a new file.
+*/
+func (rotating *RotatingFile) rotate() error {
+	rotating.file.Close()
+	rotating.file = nil
+
+	if rotating.Backups > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", rotating.path, rotating.Backups))
+		for i := rotating.Backups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", rotating.path, i), fmt.Sprintf("%s.%d", rotating.path, i+1))
+		}
+		if err := os.Rename(rotating.path, rotating.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(rotating.path); err != nil {
+		return err
+	}
+	return rotating.open()
+}
+
+func (rotating *RotatingFile) Close() error {
+	rotating.mu.Lock()
+	defer rotating.mu.Unlock()
+
+	if rotating.file == nil {
+		return nil
+	}
+	err := rotating.file.Close()
+	rotating.file = nil
+	return err
+}
@@ -0,0 +1,113 @@
+package helpers
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+	Metrics in the Prometheus text exposition format, enough of it for
+	nodes to expose counters, gauges and histograms on /metrics without
+	a client library.
+*/
+
+/* Content-Type of the Prometheus text exposition format */
+const METRICS_CONTENT_TYPE string = "text/plain; version=0.0.4; charset=utf-8"
+
+/* A count that only goes up, e.g. of blocks mined */
+type Counter struct {
+	value atomic.Uint64
+}
+
+func (counter *Counter) Inc() {
+	counter.value.Add(1)
+}
+
+func (counter *Counter) Value() uint64 {
+	return counter.value.Load()
+}
+
+/*
+Observations counted into buckets by their upper bounds, e.g. of how long
+mining a block took in seconds.
+*/
+type Histogram struct {
+	mu      sync.Mutex
+	bounds  []float64 // Upper bounds of the buckets, ascending
+	buckets []uint64  // Observations in each bucket, not cumulated
+	sum     float64
+	count   uint64
+}
+
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{bounds: bounds, buckets: make([]uint64, len(bounds))}
+}
+
+func (histogram *Histogram) Observe(value float64) {
+	histogram.mu.Lock()
+	defer histogram.mu.Unlock()
+
+	for i, bound := range histogram.bounds {
+		if value <= bound {
+			histogram.buckets[i]++
+			break
+		}
+	}
+	histogram.sum += value
+	histogram.count++
+}
+
+/*
+Return the number of observations.
+*/
+func (histogram *Histogram) Count() uint64 {
+	histogram.mu.Lock()
+	defer histogram.mu.Unlock()
+	return histogram.count
+}
+
+func writeMetricHeader(w io.Writer, name string, help string, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+/*
+Write a counter named name, described by help.
+*/
+func WriteCounter(w io.Writer, name string, help string, counter *Counter) {
+	writeMetricHeader(w, name, help, "counter")
+	fmt.Fprintf(w, "%s %d\n", name, counter.Value())
+}
+
+/*
+Write a gauge named name, described by help, with the given value.
+*/
+func WriteGauge(w io.Writer, name string, help string, value float64) {
+	writeMetricHeader(w, name, help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+}
+
+/*
+Write a histogram named name, described by help: its cumulative buckets,
+the sum of its observations and their count.
+*/
+func WriteHistogram(w io.Writer, name string, help string, histogram *Histogram) {
+	histogram.mu.Lock()
+	defer histogram.mu.Unlock()
+
+	writeMetricHeader(w, name, help, "histogram")
+	cumulative := uint64(0)
+	for i, bound := range histogram.bounds {
+		cumulative += histogram.buckets[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, histogram.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(histogram.sum))
+	fmt.Fprintf(w, "%s_count %d\n", name, histogram.count)
+}
@@ -0,0 +1,40 @@
+package helpers
+
+import (
+	"fmt"
+	"net/http"
+)
+
+/*
+Network the nodes and users of this process belong to, so independent
+networks, e.g. a test and a demo network, can run side by side without
+mixing their blocks. Blocks carry it, see Block.NetworkID, and every call
+through HTTP_CLIENT carries it in NETWORK_ID_HEADER, even when empty. Empty
+for the default network, the one nodes ran before networks had IDs.
+*/
+var NETWORK_ID string = ""
+
+/* Header every call through HTTP_CLIENT, and every answer of a node, carries its network ID in */
+const NETWORK_ID_HEADER string = "X-Network-Id"
+
+/*
+Returned by HTTP_CLIENT calls answered by a node of another network.
+Callers treat the other side as if it did not answer.
+*/
+type ForeignNetworkError struct {
+	URL     string
+	Network string // Network ID of the other side
+}
+
+func (err *ForeignNetworkError) Error() string {
+	return fmt.Sprintf("%s belongs to network %q, this node to %q", err.URL, err.Network, NETWORK_ID)
+}
+
+/*
+Returns true if headers carry NETWORK_ID, or no network ID at all, e.g.
+for a user calling with curl. An empty network ID is the default network's.
+*/
+func SameNetwork(header http.Header) bool {
+	network, ok := header[http.CanonicalHeaderKey(NETWORK_ID_HEADER)]
+	return !ok || network[0] == NETWORK_ID
+}
@@ -0,0 +1,49 @@
+package helpers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+/* A node's endpoint returning the ports of the nodes it knows of */
+const PEERS string = "/peers"
+
+/* The peer set a node returns from /peers and /join */
+type PeerList struct {
+	Peers []string `json:"peers"`
+}
+
+/*
+Ask the nodes at the seed ports for the ports of the nodes on the network,
+trying each seed in turn until one answers. Returns an empty list if none does.
+*/
+func GetPeers(seeds ...string) []string {
+	for _, seed := range seeds {
+		resp, err := HTTP_CLIENT.Get(NodeURL(seed) + PEERS)
+		if err != nil {
+			continue // Seed is not active
+		}
+
+		var peers PeerList
+		err = json.NewDecoder(resp.Body).Decode(&peers)
+		CloseBody(resp)
+		if err == nil && resp.StatusCode == http.StatusOK {
+			return peers.Peers
+		}
+	}
+
+	return []string{}
+}
+
+/*
+Sort ports by their number, so the highest port is last.
+*/
+func SortPorts(ports []string) {
+	sort.Slice(ports, func(i, j int) bool {
+		a, _ := strconv.Atoi(ports[i])
+		b, _ := strconv.Atoi(ports[j])
+		return a < b
+	})
+}
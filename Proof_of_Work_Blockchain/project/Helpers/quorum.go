@@ -0,0 +1,55 @@
+package helpers
+
+import (
+	"fmt"
+	"strconv"
+)
+
+/*
+Quorum policies: how many of a network's nodes must agree on a block or a
+blockchain. Besides these, a policy may be a fixed number of nodes, e.g.
+"3". All the nodes of a network must follow the same policy.
+*/
+const (
+	QUORUM_MAJORITY   string = "majority"   // More than half of the nodes
+	QUORUM_TWO_THIRDS string = "two_thirds" // At least two thirds of the nodes
+)
+
+/* The quorum policy of the nodes and users of this process, see Quorum */
+var QUORUM string = QUORUM_TWO_THIRDS
+
+/*
+Returns an error unless policy is QUORUM_MAJORITY, QUORUM_TWO_THIRDS or a
+positive number of nodes.
+*/
+func ValidQuorum(policy string) error {
+	if policy == QUORUM_MAJORITY || policy == QUORUM_TWO_THIRDS {
+		return nil
+	}
+	if count, err := strconv.Atoi(policy); err == nil && count > 0 {
+		return nil
+	}
+	return fmt.Errorf("quorum must be %s, %s or a positive number of nodes, not %q", QUORUM_MAJORITY, QUORUM_TWO_THIRDS, policy)
+}
+
+/*
+Return how many of n nodes must agree under the QUORUM policy, at least
+one of them unless n is 0. A fixed number of nodes over n is lowered to n,
+so a network smaller than the count needs all of its nodes. An invalid
+policy is taken for QUORUM_TWO_THIRDS.
+*/
+func Quorum(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	if QUORUM == QUORUM_MAJORITY {
+		return n/2 + 1
+	}
+	if count, err := strconv.Atoi(QUORUM); err == nil && count > 0 {
+		if count > n {
+			return n
+		}
+		return count
+	}
+	return (2*n + 2) / 3 // Two thirds, rounded up
+}
@@ -0,0 +1,229 @@
+package helpers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+/*
+	TLS between nodes. Each node holds its own certificate, issued by a
+	certificate authority all nodes trust, and serves its API over HTTPS.
+	With mutual authentication, nodes also present their certificate when
+	calling peers, and calls only peers make, e.g. /validate and /new_chain,
+	are refused unless the caller's certificate was issued by the authority.
+	Users need not hold a certificate, only trust the authority.
+*/
+
+/* Time certificates issued by a CertificateAuthority are valid for */
+const CERTIFICATE_VALIDITY = 365 * 24 * time.Hour
+
+/* Scheme of the URLs of nodes, https:// once TLS is enabled */
+var URL_SCHEME string = "http://"
+
+/* Set by EnableTLS, nil while nodes talk plaintext HTTP */
+var TLS_SERVER_CONFIG *tls.Config
+var TLS_CLIENT_CONFIG *tls.Config
+
+/* Whether calls only peers make require a certificate, see AuthenticatedPeer */
+var MUTUAL_TLS bool
+
+/*
+Return the URL of the node at port, e.g. http://localhost:1234.
+*/
+func NodeURL(port string) string {
+	return URL_SCHEME + "localhost:" + port
+}
+
+/*
+Serve and call nodes over TLS. caFile holds the certificate of the
+authority the certificates of nodes are checked against. certFile and
+keyFile hold this process's own certificate and key, and may be empty for
+users, which only call nodes. With mutual, nodes refuse calls only peers
+make from callers without a certificate issued by the authority.
+*/
+func EnableTLS(certFile string, keyFile string, caFile string, mutual bool) error {
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return errors.New("no certificate in " + caFile)
+	}
+
+	var certificates []tls.Certificate
+	if certFile != "" || keyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		certificates = append(certificates, certificate)
+	}
+
+	server := &tls.Config{Certificates: certificates, MinVersion: tls.VersionTLS12}
+	if mutual {
+		// Users call nodes without a certificate, peers are told apart per endpoint
+		server.ClientCAs = pool
+		server.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	client := &tls.Config{Certificates: certificates, RootCAs: pool, MinVersion: tls.VersionTLS12}
+
+	TLS_SERVER_CONFIG, TLS_CLIENT_CONFIG, MUTUAL_TLS = server, client, mutual
+	URL_SCHEME = "https://"
+	http_transport.TLSClientConfig = client
+	http_transport.CloseIdleConnections()
+	return nil
+}
+
+/*
+Go back to plaintext HTTP between nodes.
+*/
+func DisableTLS() {
+	TLS_SERVER_CONFIG, TLS_CLIENT_CONFIG, MUTUAL_TLS = nil, nil, false
+	URL_SCHEME = "http://"
+	http_transport.TLSClientConfig = nil
+	http_transport.CloseIdleConnections()
+}
+
+/*
+Wrap listener to serve TLS once it is enabled.
+*/
+func ListenTLS(listener net.Listener) net.Listener {
+	if TLS_SERVER_CONFIG == nil {
+		return listener
+	}
+	return tls.NewListener(listener, TLS_SERVER_CONFIG)
+}
+
+/*
+Return whether r comes from a peer: any caller without mutual
+authentication, otherwise one whose certificate the authority issued.
+*/
+func AuthenticatedPeer(r *http.Request) bool {
+	if !MUTUAL_TLS {
+		return true
+	}
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+}
+
+/*
+A certificate authority issuing the certificates of nodes and users.
+*/
+type CertificateAuthority struct {
+	Certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+}
+
+/*
+Create a self-signed certificate authority named name.
+*/
+func NewCertificateAuthority(name string) (*CertificateAuthority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template, err := certificateTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CertificateAuthority{Certificate: certificate, key: key}, nil
+}
+
+/*
+Load a certificate authority from the PEM files written by WriteFiles.
+*/
+func LoadCertificateAuthority(certFile string, keyFile string) (*CertificateAuthority, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok || !certificate.IsCA {
+		return nil, errors.New(certFile + " is not an ECDSA certificate authority")
+	}
+	return &CertificateAuthority{Certificate: certificate, key: key}, nil
+}
+
+/*
+Write the certificate and key of this authority as PEM files.
+*/
+func (ca *CertificateAuthority) WriteFiles(certFile string, keyFile string) error {
+	return writePEMFiles(ca.Certificate.Raw, ca.key, certFile, keyFile)
+}
+
+/*
+Issue a certificate named name, e.g. "node 1234", for nodes on localhost to
+serve and call peers with, and write it and its key as PEM files.
+*/
+func (ca *CertificateAuthority) Issue(name string, certFile string, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	template, err := certificateTemplate(name)
+	if err != nil {
+		return err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	template.DNSNames = []string{"localhost"}
+	template.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Certificate, &key.PublicKey, ca.key)
+	if err != nil {
+		return err
+	}
+	return writePEMFiles(der, key, certFile, keyFile)
+}
+
+func certificateTemplate(name string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour), // Tolerate clocks slightly behind
+		NotAfter:     time.Now().Add(CERTIFICATE_VALIDITY),
+	}, nil
+}
+
+/* Write a DER certificate and its key as PEM files, the key readable by its owner only */
+func writePEMFiles(der []byte, key *ecdsa.PrivateKey, certFile string, keyFile string) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+}
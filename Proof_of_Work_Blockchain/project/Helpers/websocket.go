@@ -0,0 +1,270 @@
+package helpers
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Appended to the client's key by servers accepting a WebSocket handshake, see RFC 6455 */
+const WEBSOCKET_GUID string = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+/* Largest message read from a WebSocket */
+const MAX_WEBSOCKET_MESSAGE uint64 = 1 << 20
+
+/* WebSocket frame opcodes */
+const (
+	WS_TEXT  byte = 0x1
+	WS_CLOSE byte = 0x8
+	WS_PING  byte = 0x9
+	WS_PONG  byte = 0xA
+)
+
+/*
+A WebSocket connection, with enough of RFC 6455 for nodes to push JSON
+events: unfragmented text messages, pings and closing. Clients mask the
+frames they send, servers do not.
+*/
+type WebSocket struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	client bool
+	mu     sync.Mutex // Writes of whole frames
+}
+
+/*
+Return the Sec-WebSocket-Accept of the handshake with the given Sec-WebSocket-Key.
+*/
+func acceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + WEBSOCKET_GUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+/*
+Upgrade the connection of a request to a WebSocket. If the request is not
+a WebSocket handshake, reply 400 Bad Request and return an error.
+*/
+func UpgradeWebSocket(w http.ResponseWriter, r *http.Request) (*WebSocket, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		http.Error(w, "expected a WebSocket handshake", http.StatusBadRequest)
+		return nil, errors.New("not a WebSocket handshake")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "cannot upgrade the connection", http.StatusInternalServerError)
+		return nil, errors.New("connection cannot be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n" +
+		API_VERSION_HEADER + ": " + strconv.Itoa(API_VERSION) + "\r\n\r\n"
+	if _, err := rw.WriteString(response); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &WebSocket{conn: conn, reader: rw.Reader}, nil
+}
+
+/*
+Open a WebSocket to path on the node at port.
+*/
+func DialWebSocket(port string, path string) (*WebSocket, error) {
+	dialer := &net.Dialer{Timeout: DIAL_TIMEOUT}
+	var conn net.Conn
+	var err error
+	if TLS_CLIENT_CONFIG != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", "localhost:"+port, TLS_CLIENT_CONFIG)
+	} else {
+		conn, err = dialer.Dial("tcp", "localhost:"+port)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req, err := http.NewRequest("GET", NodeURL(port)+path, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set(API_VERSION_HEADER, strconv.Itoa(API_VERSION))
+	req.Header.Set(NETWORK_ID_HEADER, NETWORK_ID)
+
+	conn.SetDeadline(time.Now().Add(REQUEST_TIMEOUT))
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		conn.Close()
+		return nil, fmt.Errorf("%s refused the WebSocket handshake with %s", path, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+
+	return &WebSocket{conn: conn, reader: reader, client: true}, nil
+}
+
+/*
+Send a text message.
+*/
+func (ws *WebSocket) WriteText(message []byte) error {
+	return ws.writeFrame(WS_TEXT, message)
+}
+
+/*
+Send a ping, answered with a pong by the other side. Returns an error once
+the other side went away.
+*/
+func (ws *WebSocket) Ping() error {
+	return ws.writeFrame(WS_PING, nil)
+}
+
+/*
+Return the next text message, answering pings along the way. Returns
+io.EOF once the other side closed the WebSocket.
+*/
+func (ws *WebSocket) ReadMessage() ([]byte, error) {
+	for {
+		opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case WS_CLOSE:
+			ws.writeFrame(WS_CLOSE, nil)
+			return nil, io.EOF
+		case WS_PING:
+			ws.writeFrame(WS_PONG, payload)
+		case WS_PONG:
+		default:
+			return payload, nil
+		}
+	}
+}
+
+/*
+Close the WebSocket, telling the other side first if it is still there.
+*/
+func (ws *WebSocket) Close() error {
+	ws.writeFrame(WS_CLOSE, nil)
+	return ws.conn.Close()
+}
+
+func (ws *WebSocket) writeFrame(opcode byte, payload []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	frame := []byte{0x80 | opcode} // A whole message in one frame
+	mask := byte(0)
+	if ws.client {
+		mask = 0x80
+	}
+
+	length := len(payload)
+	switch {
+	case length < 126:
+		frame = append(frame, mask|byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, mask|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(length))
+	default:
+		frame = append(frame, mask|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(length))
+	}
+
+	if ws.client {
+		key := make([]byte, 4)
+		rand.Read(key)
+		frame = append(frame, key...)
+		for i, b := range payload {
+			frame = append(frame, b^key[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	_, err := ws.conn.Write(frame)
+	return err
+}
+
+func (ws *WebSocket) readFrame() (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(ws.reader, head[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode, masked := head[0]&0x0F, head[1]&0x80 != 0
+
+	length := uint64(head[1] & 0x7F)
+	if length == 126 {
+		var extended [2]byte
+		if _, err := io.ReadFull(ws.reader, extended[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	} else if length == 127 {
+		var extended [8]byte
+		if _, err := io.ReadFull(ws.reader, extended[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if length > MAX_WEBSOCKET_MESSAGE {
+		return 0, nil, fmt.Errorf("WebSocket message of %d bytes is too large", length)
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(ws.reader, key[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.reader, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= key[i%4]
+		}
+	}
+	return opcode, payload, nil
+}
@@ -0,0 +1,403 @@
+/*
+The indexer tails a node's /block_events and indexes every content entry
+on the blockchain by its author, its block's time and its keywords, so
+applications can look content up without scanning the whole chain.
+
+Indexed entries are stored as a flat append-only file, one JSON encoded
+record per line, and reloaded when the indexer restarts. When the node
+adopts another chain, the index is rebuilt from the genesis block.
+*/
+
+package indexer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	blk "project/Block"
+	help "project/Helpers"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const SEARCH string = "/search"
+const BLOCK_EVENTS string = "/block_events"
+
+/* Records a search returns, unless it asks for fewer */
+const SEARCH_LIMIT int = 100
+
+/* How long a /block_events request waits for a new block */
+const TAIL_WAIT time.Duration = 10 * time.Second
+
+/* How long to wait before asking a node that did not answer again */
+const TAIL_RETRY time.Duration = time.Second
+
+/*
+A content entry on the blockchain, as indexed.
+*/
+type Record struct {
+	Block     int    `json:"block"`
+	BlockHash []byte `json:"block_hash"`
+	Entry     int    `json:"entry"` // Index of the entry in its block
+	Content   string `json:"content"`
+	Author    string `json:"author,omitempty"` // Address of the user who sent it
+	Timestamp int64  `json:"timestamp"`        // Of the block, in Unix nanoseconds
+}
+
+/*
+The index of the blockchain of the node at port Node, stored in the file at Path.
+*/
+type Indexer struct {
+	Node string
+	Path string
+
+	mu        sync.Mutex
+	records   []Record
+	byAuthor  map[string][]int // Positions in records
+	byKeyword map[string][]int
+	height    int    // Number of blocks indexed
+	tip       []byte // Hash of the last block indexed
+}
+
+/* Blocks sent by /block_events, see the Node package */
+type blockEvents struct {
+	Height int          `json:"height"`
+	Tip    []byte       `json:"tip"`
+	Blocks []*blk.Block `json:"blocks"`
+}
+
+/*
+Return the indexer of the node at the given port, storing its index in dir.
+*/
+func NewIndexer(node string, dir string) *Indexer {
+	ix := &Indexer{Node: node, Path: filepath.Join(dir, "Index_"+node+".jsonl")}
+	ix.clear()
+	return ix
+}
+
+func (ix *Indexer) clear() {
+	ix.records = []Record{}
+	ix.byAuthor = map[string][]int{}
+	ix.byKeyword = map[string][]int{}
+	ix.height, ix.tip = 0, nil
+}
+
+/*
+Return the distinct lower case words of content.
+*/
+func Keywords(content string) []string {
+	words := strings.FieldsFunc(strings.ToLower(content), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := map[string]bool{}
+	keywords := []string{}
+	for _, word := range words {
+		if !seen[word] {
+			seen[word] = true
+			keywords = append(keywords, word)
+		}
+	}
+	return keywords
+}
+
+/* Add a record to the in-memory index. Must be called with ix.mu held. */
+func (ix *Indexer) add(record Record) {
+	position := len(ix.records)
+	ix.records = append(ix.records, record)
+	if record.Author != "" {
+		ix.byAuthor[record.Author] = append(ix.byAuthor[record.Author], position)
+	}
+	for _, keyword := range Keywords(record.Content) {
+		ix.byKeyword[keyword] = append(ix.byKeyword[keyword], position)
+	}
+	ix.height, ix.tip = record.Block+1, record.BlockHash
+}
+
+/*
+Read the stored index. Records after a torn line, left by a crash while
+appending, are dropped and indexed again from the node.
+*/
+func (ix *Indexer) Load() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.clear()
+	data, err := os.ReadFile(ix.Path)
+	if os.IsNotExist(err) {
+		return nil // Nothing indexed yet
+	} else if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		var record Record
+		if json.Unmarshal(scanner.Bytes(), &record) != nil {
+			return ix.rewrite()
+		}
+		ix.add(record)
+	}
+	return nil
+}
+
+/* Replace the stored index with the in-memory one. Must be called with ix.mu held. */
+func (ix *Indexer) rewrite() error {
+	var buf bytes.Buffer
+	for _, record := range ix.records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(line, '\n'))
+	}
+
+	tmp := ix.Path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ix.Path)
+}
+
+/*
+Index the entries of blocks, which follow the blocks already indexed,
+and append them to the stored index.
+*/
+func (ix *Indexer) Index(blocks []*blk.Block) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, block := range blocks {
+		for i, content := range block.Contents() {
+			record := Record{
+				Block:     block.Index,
+				BlockHash: block.SelfHash,
+				Entry:     i,
+				Content:   content,
+				Author:    block.Author(i),
+				Timestamp: block.Timestamp,
+			}
+			line, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			buf.Write(append(line, '\n'))
+			ix.add(record)
+		}
+	}
+
+	file, err := os.OpenFile(ix.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(buf.Bytes())
+	return err
+}
+
+/*
+Forget the whole index, e.g. when the node adopted another chain.
+*/
+func (ix *Indexer) Reset() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.clear()
+	return ix.rewrite()
+}
+
+/*
+Index the blocks the node accepted since the last call, waiting up to wait
+for a new block. If the node adopted another chain, the index is rebuilt.
+*/
+func (ix *Indexer) Sync(wait time.Duration) error {
+	ix.mu.Lock()
+	height, tip := ix.height, ix.tip
+	ix.mu.Unlock()
+
+	url := fmt.Sprintf("%s%s?since=%d&wait_ms=%d", help.NodeURL(ix.Node), BLOCK_EVENTS, height, wait.Milliseconds())
+	resp, err := help.HTTP_CLIENT.Get(url)
+	if err != nil {
+		return err
+	}
+	defer help.CloseBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("node %s answered %d", ix.Node, resp.StatusCode)
+	}
+
+	var events blockEvents
+	if err := json.NewDecoder(resp.Body).Decode(&events); err != nil {
+		return err
+	}
+
+	// The new blocks must follow the last block indexed
+	if height > 0 {
+		replaced := events.Height < height
+		if len(events.Blocks) > 0 {
+			replaced = replaced || !bytes.Equal(events.Blocks[0].PrevBlockHash, tip)
+		} else if events.Height == height {
+			replaced = !bytes.Equal(events.Tip, tip)
+		}
+
+		if replaced {
+			fmt.Printf("Node %s adopted another chain, rebuilding the index\n", ix.Node)
+			if err := ix.Reset(); err != nil {
+				return err
+			}
+			return ix.Sync(0)
+		}
+	}
+
+	return ix.Index(events.Blocks)
+}
+
+/*
+Keep the index up to date with the node until stop is closed.
+*/
+func (ix *Indexer) Tail(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		if err := ix.Sync(TAIL_WAIT); err != nil {
+			fmt.Printf("Indexer could not sync with node %s: %v\n", ix.Node, err)
+			time.Sleep(TAIL_RETRY)
+		}
+	}
+}
+
+/*
+A search of the index. Empty fields match every record.
+*/
+type Query struct {
+	Author   string
+	Keywords []string  // All must be in the content
+	From, To time.Time // Of the block, To excluded
+	Limit    int       // SEARCH_LIMIT if 0
+}
+
+/*
+Return the records matching the query, in blockchain order.
+*/
+func (ix *Indexer) Search(query Query) []Record {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	// Start from the shortest list of candidates the query allows
+	var candidates []int
+	all := true
+	narrow := func(positions []int) {
+		if all || len(positions) < len(candidates) {
+			candidates, all = positions, false
+		}
+	}
+	if query.Author != "" {
+		narrow(ix.byAuthor[query.Author])
+	}
+	for _, keyword := range query.Keywords {
+		narrow(ix.byKeyword[strings.ToLower(keyword)])
+	}
+	if all {
+		candidates = make([]int, len(ix.records))
+		for i := range candidates {
+			candidates[i] = i
+		}
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = SEARCH_LIMIT
+	}
+
+	records := []Record{}
+	for _, position := range candidates {
+		record := ix.records[position]
+		if ix.matches(record, query) {
+			records = append(records, record)
+			if len(records) == limit {
+				break
+			}
+		}
+	}
+	return records
+}
+
+func (ix *Indexer) matches(record Record, query Query) bool {
+	if query.Author != "" && record.Author != query.Author {
+		return false
+	}
+	if !query.From.IsZero() && record.Timestamp < query.From.UnixNano() {
+		return false
+	}
+	if !query.To.IsZero() && record.Timestamp >= query.To.UnixNano() {
+		return false
+	}
+
+	keywords := map[string]bool{}
+	for _, keyword := range Keywords(record.Content) {
+		keywords[keyword] = true
+	}
+	for _, keyword := range query.Keywords {
+		if !keywords[strings.ToLower(keyword)] {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+The records a search found, and the number of blocks indexed.
+*/
+type SearchResponse struct {
+	Height  int      `json:"height"`
+	Records []Record `json:"records"`
+}
+
+/*
+Handle /search?user=&keyword=&from=&to=&limit=, all optional. Times are
+RFC 3339, and keyword may hold several words, which must all match.
+*/
+func (ix *Indexer) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	query := Query{Author: values.Get("user"), Keywords: Keywords(values.Get("keyword"))}
+
+	for _, bound := range []struct {
+		name string
+		time *time.Time
+	}{{"from", &query.From}, {"to", &query.To}} {
+		if value := values.Get(bound.name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				http.Error(w, bound.name+" must be an RFC 3339 time", http.StatusBadRequest)
+				return
+			}
+			*bound.time = t
+		}
+	}
+	if value := values.Get("limit"); value != "" {
+		if _, err := fmt.Sscanf(value, "%d", &query.Limit); err != nil || query.Limit < 0 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	records := ix.Search(query)
+	ix.mu.Lock()
+	response := SearchResponse{Height: ix.height, Records: records}
+	ix.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
@@ -2,77 +2,308 @@ package node
 
 import (
 	"bytes"
+	"context"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	blk "project/Block"
 	help "project/Helpers"
+	"sync"
+	"time"
+)
+
+/* Most blocks a rejection from /validate carries, see Rejection */
+const MAX_REJECTION_BLOCKS int = 16
+
+/* Why a node rejected a block sent to /validate, see Rejection */
+const (
+	REJECTED_STALE   string = "stale"   // The block's index is taken on the node's blockchain, its sender is behind
+	REJECTED_AHEAD   string = "ahead"   // The block skips blocks the node does not hold, the node is behind
+	REJECTED_INVALID string = "invalid" // The block is not valid at its index
 )
 
 /*
-This function requests peers to accept a block,
-if majority of peers accept it, this node too can accept it.
+The body of a 403 Forbidden answer to /validate: why the block was
+rejected, the height of the rejecting node's blockchain and the hash of its
+last block. If the block was mined on a block the rejecting node holds, the
+blocks after it, which its sender is missing, are sent back too, up to
+MAX_REJECTION_BLOCKS, so the sender catches up without copying the
+blockchain, see catchUpFromRejections.
 */
-func (node *Node) AcceptBlock(newBlock blk.Block, i int) bool {
-	/* Marshall request object */
-	jsonBytes, err := json.Marshal(newBlock)
-	help.Check(err)
+type Rejection struct {
+	Reason string       `json:"reason"`
+	Height int          `json:"height"`
+	Tip    []byte       `json:"tip"`
+	Blocks []*blk.Block `json:"blocks,omitempty"`
+}
 
-	// Get the known ports
-	known_ports := help.GetPorts(NODE_LIST)
+/*
+Return the rejection of the block by this node, see Rejection.
+*/
+func (node *Node) rejection(block blk.Block) Rejection {
+	blocks := node.chain()
+	rejection := Rejection{Reason: REJECTED_INVALID, Height: len(blocks)}
+	if len(blocks) > 0 {
+		rejection.Tip = blocks[len(blocks)-1].SelfHash
+	}
+	if block.Index > len(blocks) {
+		rejection.Reason = REJECTED_AHEAD
+	}
+	if block.Index < len(blocks) {
+		rejection.Reason = REJECTED_STALE
+	}
 
-	// Initialize the vote count
-	count_votes := 0
+	// The sender holds the blocks up to the one its block follows
+	if block.Index > 0 && block.Index < len(blocks) && bytes.Equal(blocks[block.Index-1].SelfHash, block.PrevBlockHash) {
+		missing := blocks[block.Index:]
+		if len(missing) > MAX_REJECTION_BLOCKS {
+			missing = missing[:MAX_REJECTION_BLOCKS]
+		}
+		if full, ok := node.fullBlocks(missing); ok {
+			rejection.Blocks = full
+		}
+	}
+	return rejection
+}
 
-	/* Iterate over all known nodes */
-	for _, port := range known_ports {
-		// Skip this node
-		if i != 1 && port == node.Port {
+/*
+Reply 403 Forbidden to /validate with the rejection of the block.
+*/
+func (node *Node) rejectBlock(w http.ResponseWriter, block blk.Block) {
+	rejection := node.rejection(block)
+	node.logger().Warnf("could not validate Block{ %s }, %s", block.ContentString(), rejection.Reason)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(rejection)
+}
+
+/*
+Catch up with the blocks peers sent back rejecting a block of this node:
+once a majority of the known ports agree on the height and the last block
+hash of their blockchain, and the blocks one of them sent follow this
+node's last block, they are appended once checked to link up by their
+hashes and to carry a valid Proof of Work, as in syncBlockchain.
+Returns true if this node's blockchain was extended.
+*/
+func (node *Node) catchUpFromRejections(rejections []Rejection, known int) bool {
+	counts := map[string]int{}
+	var majority *Rejection
+	for i, rejection := range rejections {
+		key := fmt.Sprintf("%d:%s", rejection.Height, hex.EncodeToString(rejection.Tip))
+		counts[key]++
+		if counts[key] >= help.Quorum(known) {
+			majority = &rejections[i]
+			break
+		}
+	}
+	if majority == nil {
+		return false
+	}
+
+	node.Acceptance_mu.Lock()
+	defer node.Acceptance_mu.Unlock()
+
+	blocks := node.Blockchain.Blocks
+	height := len(blocks)
+	if height == 0 || majority.Height <= height {
+		return false
+	}
+	tip := blocks[height-1].SelfHash
+
+	// Peers that agree on the height and the tip hold the same blocks, any one's will do
+	for _, rejection := range rejections {
+		if rejection.Height != majority.Height || !bytes.Equal(rejection.Tip, majority.Tip) || len(rejection.Blocks) == 0 {
+			continue
+		}
+		last := rejection.Blocks[len(rejection.Blocks)-1].SelfHash
+		if !linksTo(tip, height, rejection.Blocks, last) {
 			continue
 		}
 
-		url := LOCALHOST + port
+		updated := make([]*blk.Block, 0, height+len(rejection.Blocks))
+		updated = append(updated, blocks...)
+		node.Blockchain.Blocks = append(updated, rejection.Blocks...)
+		node.persistBlockchain()
+		node.logger().Infof("caught up with %d blocks from rejections", len(rejection.Blocks))
+		return true
+	}
+	return false
+}
+
+/* Most /validate requests a node sends at once, see AcceptBlock */
+const VALIDATE_WORKERS int = 8
+
+/* A peer's answer to /validate, see requestVotes */
+type vote struct {
+	port      string
+	accepted  bool
+	rejection *Rejection // Set if the peer rejected the block and said why
+}
+
+/*
+Send the block to the given ports' /validate, VALIDATE_WORKERS at a time,
+and return the channel their votes arrive on as they answer, closed once
+all answered, along with the function to call once the votes decided: it
+drops the requests not sent yet, cancels those in flight and returns once
+the workers are done, so none outlives the caller. A peer that cannot be
+reached votes against the block. Peers whose request was dropped or
+cancelled catch up with the block later, see CatchUp.
+*/
+func (node *Node) requestVotes(block blk.Block, ports []string) (<-chan vote, func()) {
+	jsonBytes, err := json.Marshal(block)
+	help.Check(err)
+	ctx, cancel := context.WithCancel(context.Background())
 
-		// Create the request
-		req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBytes))
-		help.Check(err)
+	jobs := make(chan string, len(ports))
+	for _, port := range ports {
+		jobs <- port
+	}
+	close(jobs)
 
-		// Set the request's header to JSON
-		req.Header.Set("Content-Type", "application/json")
+	// Buffered, so workers never wait for a caller that stopped reading
+	votes := make(chan vote, len(ports))
+	var wg sync.WaitGroup
+	for w := 0; w < VALIDATE_WORKERS && w < len(ports); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for port := range jobs {
+				if ctx.Err() != nil {
+					return // The votes decided already
+				}
+				votes <- node.requestVote(ctx, block, jsonBytes, port)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(votes)
+	}()
+	stop := func() {
+		cancel()
+		wg.Wait()
+	}
+	return votes, stop
+}
 
-		// Set the request's URI to /validate
-		req.URL.Path = VALIDATE
+/*
+Send the JSON encoded block to the port's /validate and return its vote.
+A request cancelled through ctx once the votes decided is not logged, nor
+held against the peer.
+*/
+func (node *Node) requestVote(ctx context.Context, block blk.Block, jsonBytes []byte, port string) vote {
+	result := vote{port: port}
 
-		client := &http.Client{}
-		// Send request, then wait for a response
-		resp, err := client.Do(req)
-		if help.Check(err) {
-			fmt.Printf("%s could not send /validate to %s", node.Port, port)
-			return false
-		} else if resp.StatusCode == 200 {
-			count_votes++ // increment count_vote for every 200 code received
+	// Create the request
+	req, err := http.NewRequestWithContext(ctx, "POST", help.NodeURL(port)+VALIDATE, bytes.NewBuffer(jsonBytes))
+	if help.Check(err) {
+		return result
+	}
+	// Set the request's header to JSON
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(PEER_PORT_HEADER, node.Port)
+
+	// Send request, then wait for a response
+	start := time.Now()
+	resp, err := help.HTTP_CLIENT.Do(req)
+	if err != nil && ctx.Err() != nil {
+		return result
+	}
+	node.RecordLatency(port, time.Since(start), err == nil)
+	if help.Check(err) {
+		node.logger().Warnf("could not send /validate to %s", port)
+		node.strikeTimeout(port, err)
+		return result
+	}
+	defer help.CloseBody(resp)
+
+	node.logger().Debugf("sent /validate{ %s } to %s", block.ContentString(), port)
+
+	if resp.StatusCode == http.StatusOK {
+		result.accepted = true
+	} else if resp.StatusCode == http.StatusForbidden {
+		var rejection Rejection
+		if json.NewDecoder(resp.Body).Decode(&rejection) == nil {
+			result.rejection = &rejection
 		}
+	}
+	return result
+}
 
-		fmt.Printf("%s Sent /validate{ %s } to %s\n", node.Port, newBlock.Content, port)
+/*
+This function requests peers to accept a block,
+if a quorum of the nodes accept it, see help.Quorum, this node too can accept it.
+Peers are asked concurrently, see requestVotes, and the block is accepted
+as soon as a majority voted for it, without waiting for the others: their
+requests are cancelled.
+Otherwise, it catches up with the blocks the peers rejecting the block
+sent back, if a majority of them agree, see catchUpFromRejections.
+*/
+func (node *Node) AcceptBlock(newBlock blk.Block, i int) bool {
+	// Get the known ports, fastest peers first
+	known_ports := node.PeersByLatency(node.KnownPeers())
+	majority := help.Quorum(len(known_ports))
+
+	// Skip this node, which votes for the block it sends
+	count_votes := 0
+	ports := []string{}
+	for _, port := range known_ports {
+		if i != 1 && port == node.Port {
+			count_votes++
+			continue
+		}
+		ports = append(ports, port)
+	}
 
-		resp.Body.Close()
+	// Count the votes as they arrive, until a majority accepts the block or all peers voted
+	rejections := []Rejection{}
+	votes, stop := node.requestVotes(newBlock, ports)
+	for count_votes < majority {
+		vote, ok := <-votes
+		if !ok {
+			break
+		}
+		if vote.accepted {
+			count_votes++ // increment count_vote for every 200 code received
+		} else if vote.rejection != nil {
+			rejections = append(rejections, *vote.rejection)
+		}
 	}
+	stop()
 
 	if i == 1 {
 		// Check if count_votes is majority
-		if count_votes >= ((len(known_ports) / 3) * 2) {
+		if count_votes >= majority {
 			return true
 		}
 	}
 
 	// Check if count_votes is majority
-	if count_votes >= ((len(known_ports) / 3) * 2) {
+	if count_votes >= majority {
+		node.Acceptance_mu.Lock()
+		defer node.Acceptance_mu.Unlock()
+
+		// The block may already be on the blockchain, caught up with from a peer, see CatchUp
+		blocks := node.Blockchain.Blocks
+		if newBlock.Index < len(blocks) && bytes.Equal(blocks[newBlock.Index].SelfHash, newBlock.SelfHash) {
+			return true
+		}
+		if newBlock.Index != len(blocks) {
+			node.logger().Warnf("could not accept block{ %s }, the blockchain changed", newBlock.ContentString())
+			return false
+		}
+
 		// Accept the block.
 		node.Blockchain.Blocks = append(node.Blockchain.Blocks, &newBlock)
-		fmt.Printf("Node %s accepted block{ %s }\n", node.Port, newBlock.Content)
+		node.persistBlockchain()
+		node.logger().Infof("accepted block{ %s }", newBlock.ContentString())
 		return true
 	}
 
+	if i == 0 {
+		node.catchUpFromRejections(rejections, len(known_ports))
+	}
 	return false
 
 }
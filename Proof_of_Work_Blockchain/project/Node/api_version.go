@@ -0,0 +1,90 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	help "project/Helpers"
+	"strconv"
+)
+
+/*
+Tag the answer to a request with this node's API version, and refuse the
+request if it comes from a node speaking a version this node cannot read,
+before its body is decoded. Requests without a version, e.g. from curl,
+are served. Returns false if the request was refused.
+*/
+func (node *Node) checkAPIVersion(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set(help.API_VERSION_HEADER, strconv.Itoa(help.API_VERSION))
+
+	version, versioned := help.HeaderVersion(r.Header)
+	if !versioned || help.CompatibleVersion(version) {
+		return true
+	}
+
+	node.logger().Warnf("refused %s from %s, which speaks API version %d", r.RequestURI, r.RemoteAddr, version)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUpgradeRequired)
+	json.NewEncoder(w).Encode(help.APIVersion{Version: help.API_VERSION, MinVersion: help.MIN_API_VERSION})
+	return false
+}
+
+/*
+Tag the answer to a request with this node's network ID, and refuse the
+request with 421 if it comes from a node or user of another network, see
+help.SameNetwork. Returns false if the request was refused.
+*/
+func (node *Node) checkNetwork(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set(help.NETWORK_ID_HEADER, help.NETWORK_ID)
+	if help.SameNetwork(r.Header) {
+		return true
+	}
+
+	node.logger().Warnf("refused %s from %s, which belongs to network %q", r.RequestURI, r.RemoteAddr, r.Header.Get(help.NETWORK_ID_HEADER))
+	http.Error(w, "this node belongs to network "+strconv.Quote(help.NETWORK_ID), http.StatusMisdirectedRequest)
+	return false
+}
+
+/*
+Handle /api_version, answered whatever the version of the caller.
+*/
+func (node *Node) HandleAPIVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(help.API_VERSION_HEADER, strconv.Itoa(help.API_VERSION))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(help.APIVersion{Version: help.API_VERSION, MinVersion: help.MIN_API_VERSION})
+}
+
+/*
+Handle /handshake, a node's request to peer with this node: reply with this
+node's handshake if the two nodes can exchange messages, see
+help.CheckHandshake, otherwise refuse with 426 Upgrade Required for
+incompatible versions, or 421 Misdirected Request for another network, and
+this node's handshake all the same so the caller can tell why. Answered
+whatever the version of the caller.
+*/
+func (node *Node) HandleHandshake(w http.ResponseWriter, r *http.Request) {
+	var handshake help.Handshake
+	if help.Check(json.NewDecoder(r.Body).Decode(&handshake)) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	status := http.StatusOK
+	err := help.CheckHandshake(handshake, handshake.Port)
+	var incompatible *help.IncompatibleVersionError
+	if errors.As(err, &incompatible) {
+		status = http.StatusUpgradeRequired
+	} else if err != nil {
+		status = http.StatusMisdirectedRequest
+	}
+	if err != nil {
+		node.logger().Warnf("refused to peer with %s: %v", handshake.Port, err)
+	}
+
+	w.Header().Set(help.API_VERSION_HEADER, strconv.Itoa(help.API_VERSION))
+	w.Header().Set(help.NETWORK_ID_HEADER, help.NETWORK_ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(help.NewHandshake(node.Port))
+}
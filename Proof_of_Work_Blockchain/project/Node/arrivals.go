@@ -0,0 +1,151 @@
+package node
+
+import (
+	"bytes"
+	blk "project/Block"
+	"sync"
+)
+
+/*
+Block arrivals: the blocks of peers a node validated on /validate wait in
+its BlockQueue until accepted or refused. While any does, the node's mining
+is interrupted, see interrupted. Blocks that arrive together, i.e. pushed
+before any of them is settled, conflict: settling takes them all as one
+round, and only the block heading the heaviest branch is accepted. The
+others are kept as branches once it is, see considerBranch.
+
+Each block is owned by the handler that pushed it: it settles its round,
+unless another handler of the round did first, answers its sender, and
+marks the block done.
+*/
+
+var arrivals_mutex sync.Mutex
+
+/*
+Blocks that arrived together, settled at once.
+*/
+type round struct {
+	blocks   int
+	winner   *Arrival
+	accepted chan struct{} // Closed once the winner is done, see BlockQueue.Done
+}
+
+/*
+A block waiting in a BlockQueue, see BlockQueue.Push.
+*/
+type Arrival struct {
+	block blk.Block
+	round *round // Nil until settled
+}
+
+/* Returns true if the block was settled as the one accepted of its round */
+func (arrival *Arrival) Won() bool {
+	return arrival.round != nil && arrival.round.winner == arrival
+}
+
+/*
+The blocks of peers a node validated, waiting to be accepted or refused.
+*/
+type BlockQueue struct {
+	mu       sync.Mutex
+	pending  []*Arrival // Pushed, not settled yet
+	inFlight int        // Pushed, not done yet
+
+	settling sync.Mutex // Held while a round is settled
+}
+
+func NewBlockQueue() *BlockQueue {
+	return &BlockQueue{}
+}
+
+/*
+Queue a validated block until it is done with, see Done. Mining stops
+meanwhile.
+*/
+func (queue *BlockQueue) Push(block blk.Block) *Arrival {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	arrival := &Arrival{block: block}
+	queue.pending = append(queue.pending, arrival)
+	queue.inFlight++
+	return arrival
+}
+
+/* Returns true while a block pushed is not done with */
+func (queue *BlockQueue) Pending() bool {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	return queue.inFlight > 0
+}
+
+/*
+Mark a block pushed as done with, once accepted or refused. The blocks that
+lost to it may then be considered as branches.
+*/
+func (queue *BlockQueue) Done(arrival *Arrival) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.inFlight--
+	if arrival.Won() {
+		close(arrival.round.accepted)
+	}
+}
+
+/* Take the blocks pushed and not settled yet */
+func (queue *BlockQueue) take() []*Arrival {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	taken := queue.pending
+	queue.pending = nil
+	return taken
+}
+
+/*
+Return the queue of blocks arriving at this node, created on first use.
+*/
+func (node *Node) arrivals() *BlockQueue {
+	arrivals_mutex.Lock()
+	defer arrivals_mutex.Unlock()
+
+	if node.Arrivals == nil {
+		node.Arrivals = NewBlockQueue()
+	}
+	return node.Arrivals
+}
+
+/*
+Settle the round of the arrival, unless another block of it did already:
+the blocks that arrived together, the winner being the one heading the
+heaviest branch, see FindHeaviestBranch. Returns the number of blocks of the
+round, more than 1 when they conflicted.
+*/
+func (node *Node) settle(arrival *Arrival) int {
+	queue := node.arrivals()
+	queue.settling.Lock()
+	defer queue.settling.Unlock()
+
+	if arrival.round != nil {
+		return arrival.round.blocks
+	}
+
+	arrived := queue.take()
+	blocks := make([]blk.Block, len(arrived))
+	for i, pending := range arrived {
+		blocks[i] = pending.block
+	}
+	node.Acceptance_mu.Lock()
+	heaviest := node.FindHeaviestBranch(blocks)
+	node.Acceptance_mu.Unlock()
+
+	settled := &round{blocks: len(arrived), accepted: make(chan struct{})}
+	for _, pending := range arrived {
+		if settled.winner == nil && bytes.Equal(pending.block.SelfHash, heaviest.SelfHash) {
+			settled.winner = pending
+		}
+		pending.round = settled
+	}
+	return settled.blocks
+}
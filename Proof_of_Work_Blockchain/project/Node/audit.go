@@ -0,0 +1,215 @@
+package node
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	blk "project/Block"
+	help "project/Helpers"
+	"time"
+)
+
+/*
+Chain audit: a blockchain a node persisted, see st.BlockStore, exported
+from /copy_chain or archived by Snapshot is verified offline from its
+genesis block, e.g. to find where nodes that diverged in a test went
+wrong, see cmd/audit. The audit stops at the first violation, since the
+blocks after it are judged against the state it broke.
+*/
+
+/* What a block audited violates */
+const (
+	VIOLATION_INDEX       string = "index"         // The block is not at its index
+	VIOLATION_LINK        string = "link"          // The block does not link to the previous one by its hash
+	VIOLATION_HASH        string = "hash"          // The block does not carry its own hash
+	VIOLATION_NETWORK     string = "network"       // The block belongs to another network
+	VIOLATION_POW         string = "proof of work" // The block's hash does not meet its difficulty
+	VIOLATION_BLOCK       string = "block"         // The block fails its other checks, see blk.Block.Validate
+	VIOLATION_TIMESTAMP   string = "timestamp"     // See blk.ValidTimestamp
+	VIOLATION_PRUNED      string = "pruned"        // The block is a header, its entries cannot be audited
+	VIOLATION_SIGNATURE   string = "signature"     // A transfer or transaction is not valid or not signed by its sender
+	VIOLATION_BALANCE     string = "balance"       // A fee or transfer is not affordable, or a reward overflows
+	VIOLATION_TRANSACTION string = "transaction"   // A transaction spends outputs that are not unspent or pays out another amount
+)
+
+/*
+The first violation an audit found.
+*/
+type Violation struct {
+	Index  int    `json:"index"` // Of the block
+	Entry  int    `json:"entry"` // Of the entry in the block, -1 if the whole block is at fault
+	Kind   string `json:"kind"`  // One of the VIOLATION_ kinds
+	Reason string `json:"reason"`
+}
+
+func (violation Violation) String() string {
+	if violation.Entry < 0 {
+		return fmt.Sprintf("block %d: %s: %s", violation.Index, violation.Kind, violation.Reason)
+	}
+	return fmt.Sprintf("block %d, entry %d: %s: %s", violation.Index, violation.Entry, violation.Kind, violation.Reason)
+}
+
+/*
+The result of an audit: the blocks that passed it and the first violation,
+nil if the whole blockchain holds, along with the balances it derived.
+*/
+type AuditReport struct {
+	Blocks    int               `json:"blocks"` // Blocks audited
+	Passed    int               `json:"passed"` // Blocks before the first violation
+	Violation *Violation        `json:"violation,omitempty"`
+	Balances  map[string]uint64 `json:"balances"` // Of the addresses the blocks that passed moved funds of
+}
+
+/*
+Audit the blocks from the genesis block on: each block must be at its
+index, link to the previous one by its hash, carry its own hash, belong to
+this process's network, carry a valid Proof of Work unless proposed under
+Proof of Stake, pass its other checks and be validly timestamped, as
+Blockchain.Verify checks. Its transfers and transactions must be signed by
+their senders, and its fees, transfers, rewards and transactions must
+apply to the balances and unspent outputs the blocks before it left, as
+ValidTransfers and ValidTransactions check. Headers of pruned blocks are
+violations, since their entries are gone.
+*/
+func Audit(blocks []*blk.Block) AuditReport {
+	report := AuditReport{Blocks: len(blocks)}
+	balances, utxos := NewBalances(), NewUTXOSet()
+	now := time.Now()
+
+	for i, block := range blocks {
+		if violation := auditBlock(blocks[:i], block, i, now); violation != nil {
+			report.Violation = violation
+			break
+		}
+		if violation := auditEntries(balances, utxos, block, i); violation != nil {
+			report.Violation = violation
+			break
+		}
+		report.Passed++
+	}
+
+	report.Balances = balances.accounts
+	return report
+}
+
+/*
+Return the first violation of the block at index i in itself or in how it
+follows the blocks before it, nil if there is none.
+*/
+func auditBlock(before []*blk.Block, block *blk.Block, i int, now time.Time) *Violation {
+	violation := func(kind string, format string, args ...interface{}) *Violation {
+		return &Violation{Index: i, Entry: -1, Kind: kind, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	if block.Index != i {
+		return violation(VIOLATION_INDEX, "block %d is at index %d", block.Index, i)
+	}
+	if i > 0 && !bytes.Equal(block.PrevBlockHash, before[i-1].SelfHash) {
+		return violation(VIOLATION_LINK, "links to %x, block %d is %x", block.PrevBlockHash, i-1, before[i-1].SelfHash)
+	}
+	if hash := block.Hash(); !bytes.Equal(block.SelfHash, hash) {
+		return violation(VIOLATION_HASH, "carries %x, hashes to %x", block.SelfHash, hash)
+	}
+	if block.NetworkID != help.NETWORK_ID {
+		return violation(VIOLATION_NETWORK, "belongs to network %q, not %q", block.NetworkID, help.NETWORK_ID)
+	}
+	if block.Proposer == "" && (block.Difficulty < blk.MIN_DIFFICULTY || block.Difficulty > blk.MAX_DIFFICULTY || !blk.NewProofOfWork(block).ValidatePoW()) {
+		return violation(VIOLATION_POW, "hash %x does not meet difficulty %d", block.SelfHash, block.Difficulty)
+	}
+	if !block.Validate() {
+		return violation(VIOLATION_BLOCK, "fails its Merkle root, size, author, content ID, fee or coinbase checks")
+	}
+	if !blk.ValidTimestamp(before, block, now) {
+		return violation(VIOLATION_TIMESTAMP, "timestamped in the future or before the blocks it follows")
+	}
+	if block.HeaderOnly {
+		return violation(VIOLATION_PRUNED, "only its header is left, audit a chain from an archive node")
+	}
+	return nil
+}
+
+/*
+Apply the entries of the block at index i to the balances and the unspent
+outputs, and return the first that does not apply, nil if all do.
+*/
+func auditEntries(balances *Balances, utxos *UTXOSet, block *blk.Block, i int) *Violation {
+	violation := func(entry int, kind string, format string, args ...interface{}) *Violation {
+		return &Violation{Index: i, Entry: entry, Kind: kind, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	accounts := map[string]uint64{}
+	view := utxos.view()
+	for j, entry := range block.Entries {
+		transfer, isTransfer := blk.ParseTransfer(entry)
+		if isTransfer && !transfer.Verify() {
+			return violation(j, VIOLATION_SIGNATURE, "transfer of %d from %s is not valid or not signed by its sender", transfer.Amount, transfer.From)
+		}
+		tx, isTransaction := blk.ParseTransaction(entry)
+		if isTransaction && !tx.Verify() {
+			return violation(j, VIOLATION_SIGNATURE, "transaction %s is not valid or not signed by the owners of its inputs", tx.ID())
+		}
+		if !balances.applyEntry(accounts, block, j) {
+			return violation(j, VIOLATION_BALANCE, "its fee or transfer is not affordable, or its reward overflows")
+		}
+		if isTransaction && !view.apply(tx) {
+			return violation(j, VIOLATION_TRANSACTION, "transaction %s spends outputs that are not unspent, or pays out another amount", tx.ID())
+		}
+	}
+
+	for address, balance := range accounts {
+		balances.accounts[address] = balance
+	}
+	view.commit()
+	return nil
+}
+
+/*
+Read the blocks of a blockchain in any of the forms nodes leave it in: a
+block store file, one block per line, see st.BlockStore, the reply of
+/copy_chain, or a snapshot, see Snapshot, gzipped or not. Unlike
+BlockStore.Load, every block is read, whether it chains or not, so the
+audit finds where the chain breaks.
+*/
+func LoadChain(r io.Reader) ([]*blk.Block, error) {
+	reader := bufio.NewReader(r)
+	if magic, err := reader.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		archive, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer archive.Close()
+		reader = bufio.NewReader(archive)
+	}
+
+	// A blockchain or a snapshot holds its blocks, a block store is a stream of them
+	blocks := []*blk.Block{}
+	decoder := json.NewDecoder(reader)
+	for {
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err == io.EOF {
+			return blocks, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("block %d: %v", len(blocks), err)
+		}
+
+		var chain struct {
+			Blocks *[]*blk.Block `json:"blocks"`
+		}
+		if err := json.Unmarshal(value, &chain); err != nil {
+			return nil, fmt.Errorf("block %d: %v", len(blocks), err)
+		}
+		if chain.Blocks != nil {
+			blocks = append(blocks, *chain.Blocks...)
+			continue
+		}
+
+		var block blk.Block
+		if err := json.Unmarshal(value, &block); err != nil {
+			return nil, fmt.Errorf("block %d: %v", len(blocks), err)
+		}
+		blocks = append(blocks, &block)
+	}
+}
@@ -0,0 +1,302 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	blk "project/Block"
+	"strings"
+	"sync"
+)
+
+const BALANCE string = "/balance"
+
+/* Balance of every address before any transfer */
+var INITIAL_BALANCE uint64 = 100
+
+var balances_mutex sync.Mutex
+
+/* The balance of an address as served on /balance/{address} */
+type Balance struct {
+	Address string `json:"address"`
+	Balance uint64 `json:"balance"`
+	Height  int    `json:"height"` // Number of blocks the balance was derived from
+}
+
+/*
+State a node derives from its blockchain block by block, e.g. its Balances,
+see syncState.
+*/
+type chainState struct {
+	mu     sync.Mutex
+	height int    // Number of blocks applied
+	tip    []byte // Hash of the last block applied
+}
+
+/*
+The balance of each address, derived from the transfers, the fees and the
+coinbases on the blockchain of a node, see blk.Transfer and blk.Coinbase. Every address starts with INITIAL_BALANCE.
+Blocks are applied as the blockchain grows, and all of them again when the
+node adopts another chain.
+*/
+type Balances struct {
+	chainState
+	accounts map[string]uint64 // Addresses that sent or received a transfer, a fee or a reward
+}
+
+func NewBalances() *Balances {
+	return &Balances{accounts: map[string]uint64{}}
+}
+
+/*
+Return the balance of the address in accounts, or its balance in balances
+if accounts does not hold it. The caller holds balances.mu.
+*/
+func (balances *Balances) lookup(accounts map[string]uint64, address string) uint64 {
+	if balance, found := accounts[address]; found {
+		return balance
+	}
+	if balance, found := balances.accounts[address]; found {
+		return balance
+	}
+	return INITIAL_BALANCE
+}
+
+/*
+Move amount from the address from to the address to in accounts, over the
+balances of balances. Returns false, leaving accounts unchanged, if from
+cannot afford it. The caller holds balances.mu.
+*/
+func (balances *Balances) move(accounts map[string]uint64, from string, to string, amount uint64) bool {
+	fromBalance := balances.lookup(accounts, from)
+	if fromBalance < amount {
+		return false
+	}
+	if from == to {
+		return true
+	}
+	toBalance := balances.lookup(accounts, to)
+	if toBalance+amount < toBalance {
+		return false
+	}
+	accounts[from] = fromBalance - amount
+	accounts[to] = toBalance + amount
+	return true
+}
+
+/*
+Apply the transfer to accounts, over the balances of balances. Returns
+false, leaving accounts unchanged, if the transfer is not valid or its
+sender cannot afford it. The caller holds balances.mu.
+*/
+func (balances *Balances) apply(accounts map[string]uint64, transfer blk.Transfer) bool {
+	return transfer.Verify() && balances.move(accounts, transfer.From, transfer.To, transfer.Amount)
+}
+
+/*
+Apply the entry of the block at index i to accounts: a coinbase credits
+the miner with its reward, otherwise the entry's author pays its fee to the
+miner, then its transfer, if it is one, is applied. Returns false if
+either is not valid or not affordable, the fee staying paid if only the
+transfer is not. The caller holds balances.mu.
+*/
+func (balances *Balances) applyEntry(accounts map[string]uint64, block *blk.Block, i int) bool {
+	if coinbase, ok := blk.ParseCoinbase(block.Entries[i]); ok {
+		balance := balances.lookup(accounts, coinbase.Miner)
+		if i != 0 || !block.ValidCoinbase() || balance+coinbase.Reward < balance {
+			return false
+		}
+		accounts[coinbase.Miner] = balance + coinbase.Reward
+		return true
+	}
+	if len(block.Fees) > i && len(block.Authors) > i && block.Fees[i] > 0 && !balances.move(accounts, block.Authors[i], block.Miner, block.Fees[i]) {
+		return false
+	}
+	if transfer, ok := blk.ParseTransfer(block.Entries[i]); ok {
+		return balances.apply(accounts, transfer)
+	}
+	return true
+}
+
+/*
+Apply the coinbase, the fees and the transfers of the block to the
+balances, in the order of its entries. Invalid ones, which no validated block holds, are skipped.
+*/
+func (balances *Balances) applyBlock(block *blk.Block) {
+	for i := range block.Entries {
+		balances.applyEntry(balances.accounts, block, i)
+	}
+}
+
+/*
+Return the balances of this node, created on first use.
+*/
+func (node *Node) balances() *Balances {
+	balances_mutex.Lock()
+	defer balances_mutex.Unlock()
+
+	if node.Balances == nil {
+		node.Balances = NewBalances()
+	}
+	return node.Balances
+}
+
+/*
+Bring state up to the given blocks of this node's blockchain: apply the
+blocks past the last one applied, or reset state and apply all the blocks
+if they do not extend it, e.g. after the node adopted another chain. Pruned
+blocks are fetched in full from archive peers. Returns false if they could
+not be. The caller holds state.mu, and reads the blocks under
+Acceptance_mu, see chain.
+*/
+func (node *Node) syncState(blocks []*blk.Block, state *chainState, reset func(), apply func(*blk.Block)) bool {
+	if state.height > len(blocks) || (state.height > 0 && !bytes.Equal(blocks[state.height-1].SelfHash, state.tip)) {
+		reset()
+		state.height, state.tip = 0, nil
+	}
+
+	full, ok := node.fullBlocks(blocks[state.height:])
+	if !ok {
+		node.logger().Warnf("could not derive its state from its pruned blocks")
+		return false
+	}
+	for _, block := range full {
+		apply(block)
+		state.height++
+		state.tip = block.SelfHash
+	}
+	return true
+}
+
+/*
+Bring the balances of this node up to the given blocks of its blockchain and return them
+locked, the caller unlocks them. Returns false, with the balances
+unlocked, if they could not be, see syncState.
+*/
+func (node *Node) syncBalances(blocks []*blk.Block) (*Balances, bool) {
+	balances := node.balances()
+	balances.mu.Lock()
+
+	reset := func() { balances.accounts = map[string]uint64{} }
+	if !node.syncState(blocks, &balances.chainState, reset, balances.applyBlock) {
+		balances.mu.Unlock()
+		return nil, false
+	}
+	return balances, true
+}
+
+/*
+Return the balance of the address and the number of blocks it was derived
+from. Returns false if the balances could not be derived.
+*/
+func (node *Node) Balance(address string) (Balance, bool) {
+	balances, ok := node.syncBalances(node.chain())
+	if !ok {
+		return Balance{}, false
+	}
+	defer balances.mu.Unlock()
+
+	return Balance{Address: address, Balance: balances.lookup(nil, address), Height: balances.height}, true
+}
+
+/*
+Returns true if every fee and transfer the block holds is valid and
+affordable by its payer on top of the given blocks of this node's
+blockchain, including the fees and transfers the block holds before it.
+*/
+func (node *Node) ValidTransfers(blocks []*blk.Block, block blk.Block) bool {
+	balances, ok := node.syncBalances(blocks)
+	if !ok {
+		return false
+	}
+	defer balances.mu.Unlock()
+
+	accounts := map[string]uint64{}
+	for i := range block.Entries {
+		if !balances.applyEntry(accounts, &block, i) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Returns true if the author can afford the fee of content it sends, paid to
+this node, and the content's transfer if it is one. A node without an
+address collects no fee.
+*/
+func (node *Node) Affords(content string, author string, fee uint64) bool {
+	balances, ok := node.syncBalances(node.chain())
+	if !ok {
+		return false
+	}
+	defer balances.mu.Unlock()
+
+	block := &blk.Block{Entries: [][]byte{[]byte(content)}, Authors: []string{author}}
+	if fee > 0 && node.Address != "" {
+		block.Fees, block.Miner = []uint64{fee}, node.Address
+	}
+	return balances.applyEntry(map[string]uint64{}, block, 0)
+}
+
+/*
+Split a batch of pending content into the content that may be mined on top
+of this node's blockchain, in order, and the content whose author cannot
+afford its fee, the transfers whose sender cannot afford them and the
+transactions spending outputs already spent, e.g. by content before them in
+the batch. If the balances or the unspent
+outputs could not be derived, all transfers or transactions are dropped.
+*/
+func (node *Node) affordable(batch []*pendingContent) ([]*pendingContent, []*pendingContent) {
+	blocks := node.chain()
+	balances, balancesOK := node.syncBalances(blocks)
+	if balancesOK {
+		defer balances.mu.Unlock()
+	}
+	utxos, utxosOK := node.syncUTXOs(blocks)
+	var view *utxoView
+	if utxosOK {
+		defer utxos.mu.Unlock()
+		view = utxos.view()
+	}
+
+	kept, dropped := []*pendingContent{}, []*pendingContent{}
+	accounts := map[string]uint64{}
+	for _, entry := range batch {
+		block := &blk.Block{Entries: [][]byte{[]byte(entry.content)}, Authors: []string{entry.author}}
+		if entry.fee > 0 && node.Address != "" {
+			block.Fees, block.Miner = []uint64{entry.fee}, node.Address
+		}
+		_, isTransfer := blk.ParseTransfer([]byte(entry.content))
+		tx, isTransaction := blk.ParseTransaction([]byte(entry.content))
+		if (isTransfer || block.Fees != nil) && (!balancesOK || !balances.applyEntry(accounts, block, 0)) ||
+			isTransaction && (!utxosOK || !view.apply(tx)) {
+			dropped = append(dropped, entry)
+		} else {
+			kept = append(kept, entry)
+		}
+	}
+	return kept, dropped
+}
+
+/*
+Handle /balance/{address}: reply with the balance of the address derived
+from this node's blockchain, or 503 Service Unavailable if its pruned
+blocks could not be fetched.
+*/
+func (node *Node) HandleBalance(w http.ResponseWriter, r *http.Request) {
+	address := strings.TrimPrefix(r.URL.Path, BALANCE+"/")
+	if address == "" || strings.Contains(address, "/") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	balance, ok := node.Balance(address)
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(balance)
+}
@@ -0,0 +1,139 @@
+package node
+
+import (
+	"net/http"
+	blk "project/Block"
+	help "project/Helpers"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const BLOCK_EVENTS string = "/block_events"
+const BLOCKS_SINCE string = "/blocks_since"
+
+/* Longest a /block_events request waits for a new block */
+const MAX_EVENTS_WAIT time.Duration = 30 * time.Second
+
+var events_mutex sync.Mutex
+
+/*
+The blocks of a node's blockchain from some index on, its height and the hash of its last block.
+*/
+type BlockEvents struct {
+	Height int          `json:"height"`
+	Tip    []byte       `json:"tip"`
+	Blocks []*blk.Block `json:"blocks"`
+}
+
+/*
+Wake up the /block_events requests waiting for this node's blockchain to change.
+*/
+func (node *Node) notifyBlockEvents() {
+	events_mutex.Lock()
+	defer events_mutex.Unlock()
+
+	if node.blockEvents != nil {
+		close(node.blockEvents)
+	}
+	node.blockEvents = make(chan struct{})
+}
+
+/*
+Return a channel closed the next time this node's blockchain changes.
+*/
+func (node *Node) blockchainChanged() chan struct{} {
+	events_mutex.Lock()
+	defer events_mutex.Unlock()
+
+	if node.blockEvents == nil {
+		node.blockEvents = make(chan struct{})
+	}
+	return node.blockEvents
+}
+
+/*
+Return the blocks of this node's blockchain from index since on, waiting up
+to wait for the blockchain to change when there are none yet. A reader that
+read the blockchain up to since can tell it was replaced when the first
+block returned does not chain to the last block it read, or when the tip
+changed without new blocks. The blocks returned are a copy, read under
+Acceptance_mu, so readers do not race the blocks accepted meanwhile.
+*/
+func (node *Node) BlocksSince(since int, wait time.Duration) BlockEvents {
+	notify := node.blockchainChanged()
+
+	blocks := node.chain()
+	if since >= len(blocks) && wait > 0 {
+		select {
+		case <-notify:
+		case <-time.After(wait):
+		}
+		blocks = node.chain()
+	}
+
+	events := BlockEvents{Height: len(blocks), Blocks: []*blk.Block{}}
+	if len(blocks) > 0 {
+		events.Tip = blocks[len(blocks)-1].SelfHash
+	}
+	if since >= 0 && since < len(blocks) {
+		events.Blocks = append(events.Blocks, blocks[since:]...)
+	}
+	return events
+}
+
+/*
+Handle /block_events?since=N&wait_ms=M, both optional.
+*/
+func (node *Node) HandleBlockEvents(w http.ResponseWriter, r *http.Request) {
+	since, wait := 0, time.Duration(0)
+	if value := r.URL.Query().Get("since"); value != "" {
+		n, err := strconv.Atoi(value)
+		if help.Check(err) || n < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		since = n
+	}
+	if value := r.URL.Query().Get("wait_ms"); value != "" {
+		ms, err := strconv.Atoi(value)
+		if help.Check(err) || ms < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		wait = time.Duration(ms) * time.Millisecond
+		if wait > MAX_EVENTS_WAIT {
+			wait = MAX_EVENTS_WAIT
+		}
+	}
+
+	node.writeBlockEvents(w, r, node.BlocksSince(since, wait))
+}
+
+/*
+Handle /blocks_since?index=N, the blocks nodes catch up with, see UpdateBlockchain.
+*/
+func (node *Node) HandleBlocksSince(w http.ResponseWriter, r *http.Request) {
+	index, err := strconv.Atoi(r.URL.Query().Get("index"))
+	if help.Check(err) || index < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	node.writeBlockEvents(w, r, node.BlocksSince(index, 0))
+}
+
+/*
+Reply with events, their pruned blocks fetched in full from an archive peer,
+gzip compressed if the caller accepts it.
+*/
+func (node *Node) writeBlockEvents(w http.ResponseWriter, r *http.Request, events BlockEvents) {
+	blocks, ok := node.fullBlocks(events.Blocks)
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	events.Blocks = blocks
+
+	help.WriteJSON(w, r, events)
+}
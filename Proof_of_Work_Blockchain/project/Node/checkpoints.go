@@ -0,0 +1,123 @@
+package node
+
+import (
+	"encoding/hex"
+	blk "project/Block"
+	"sort"
+	"sync"
+)
+
+/* Number of blocks between two checkpoints */
+const CHECKPOINT_INTERVAL int = 10
+
+/* Blocks on top of a block before it is final and its checkpoint is recorded */
+const CHECKPOINT_DEPTH int = 6
+
+/* A finalized block: the hash the blockchain holds at its index for good */
+type Checkpoint struct {
+	Index int    `json:"index"`
+	Hash  string `json:"hash"` // Hex encoded
+}
+
+/*
+The checkpoints a node recorded, keyed by block index. Every block whose
+index is a multiple of CHECKPOINT_INTERVAL gets one once CHECKPOINT_DEPTH
+blocks follow it. A recorded checkpoint never changes, so no chain rolling
+back a checkpointed block is adopted, which bounds how far a fork can reach.
+*/
+type Checkpoints struct {
+	mu     sync.Mutex
+	hashes map[int]string
+}
+
+func NewCheckpoints() *Checkpoints {
+	return &Checkpoints{hashes: map[int]string{}}
+}
+
+/*
+Record the checkpoints blocks finalized and return the new ones. A block
+contradicting a checkpoint recorded already is not recorded over it.
+*/
+func (checkpoints *Checkpoints) Record(blocks []*blk.Block) []Checkpoint {
+	if checkpoints == nil {
+		return nil
+	}
+	checkpoints.mu.Lock()
+	defer checkpoints.mu.Unlock()
+
+	recorded := []Checkpoint{}
+	for index := 0; index+CHECKPOINT_DEPTH < len(blocks); index += CHECKPOINT_INTERVAL {
+		if _, known := checkpoints.hashes[index]; known {
+			continue
+		}
+		hash := hex.EncodeToString(blocks[index].SelfHash)
+		checkpoints.hashes[index] = hash
+		recorded = append(recorded, Checkpoint{Index: index, Hash: hash})
+	}
+	return recorded
+}
+
+/*
+Return true if block can be at its index: no checkpoint is recorded there,
+or the checkpoint's hash is the block's.
+*/
+func (checkpoints *Checkpoints) Allows(block blk.Block) bool {
+	if checkpoints == nil {
+		return true
+	}
+	checkpoints.mu.Lock()
+	defer checkpoints.mu.Unlock()
+
+	hash, known := checkpoints.hashes[block.Index]
+	return !known || hash == hex.EncodeToString(block.SelfHash)
+}
+
+/*
+Return the first checkpoint blocks contradict, by holding another block at
+its index or by ending before it, or false if blocks hold every checkpoint.
+*/
+func (checkpoints *Checkpoints) Contradicted(blocks []*blk.Block) (Checkpoint, bool) {
+	for _, checkpoint := range checkpoints.List() {
+		if checkpoint.Index >= len(blocks) || hex.EncodeToString(blocks[checkpoint.Index].SelfHash) != checkpoint.Hash {
+			return checkpoint, true
+		}
+	}
+	return Checkpoint{}, false
+}
+
+/* Return the recorded checkpoints, lowest index first */
+func (checkpoints *Checkpoints) List() []Checkpoint {
+	list := []Checkpoint{}
+	if checkpoints == nil {
+		return list
+	}
+	checkpoints.mu.Lock()
+	defer checkpoints.mu.Unlock()
+
+	for index, hash := range checkpoints.hashes {
+		list = append(list, Checkpoint{Index: index, Hash: hash})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Index < list[j].Index })
+	return list
+}
+
+/* Return the checkpoint with the highest index, or false if there is none yet */
+func (checkpoints *Checkpoints) Last() (Checkpoint, bool) {
+	list := checkpoints.List()
+	if len(list) == 0 {
+		return Checkpoint{}, false
+	}
+	return list[len(list)-1], true
+}
+
+/*
+Return false, and log why, if adopting blocks as this node's blockchain
+would roll back one of its checkpoints.
+*/
+func (node *Node) keepsCheckpoints(blocks []*blk.Block) bool {
+	checkpoint, contradicted := node.Checkpoints.Contradicted(blocks)
+	if contradicted {
+		node.logger().Warnf("refused a chain contradicting its checkpoint at block %d", checkpoint.Index)
+	}
+	return !contradicted
+}
@@ -0,0 +1,63 @@
+package node
+
+import (
+	"fmt"
+	blk "project/Block"
+	cfg "project/Config"
+	help "project/Helpers"
+	st "project/Store"
+	"strings"
+)
+
+/*
+Apply the runtime configuration to the nodes of this process: the
+difficulty of the genesis block, the size limits of content and blocks,
+the fees content pays for its size,
+the rules on the timestamps of blocks, the quorum of nodes that must agree,
+the network the nodes belong to and its governors, when they ban
+misbehaving peers, how deep a block is before it is finalized, where
+blockchains and off-chain content are stored, and how often nodes check on
+their peers and subscribers.
+Call it before starting nodes.
+*/
+func Configure(config cfg.Config) error {
+	if config.Difficulty < blk.MIN_DIFFICULTY || config.Difficulty > blk.MAX_DIFFICULTY {
+		return fmt.Errorf("difficulty must be between %d and %d", blk.MIN_DIFFICULTY, blk.MAX_DIFFICULTY)
+	}
+	if config.MaxContentSize <= 0 || config.MaxBlockSize < config.MaxContentSize {
+		return fmt.Errorf("max content size must be positive and at most the max block size")
+	}
+	if config.MaxFutureDrift < 0 || config.MedianTimeBlocks <= 0 {
+		return fmt.Errorf("max future drift must not be negative and median time blocks must be positive")
+	}
+	if err := help.ValidQuorum(config.Quorum); err != nil {
+		return err
+	}
+	if config.BanThreshold < 0 || config.FinalityDepth < 0 {
+		return fmt.Errorf("ban threshold and finality depth must not be negative")
+	}
+	if config.DivergenceCheckTime <= 0 || config.LivenessCheckTime <= 0 || config.SubscribePingTime <= 0 || config.DrainGraceTime < 0 {
+		return fmt.Errorf("check times must be positive")
+	}
+
+	blk.DIFFICULTY = config.Difficulty
+	blk.MAX_CONTENT_SIZE = config.MaxContentSize
+	blk.MAX_BLOCK_SIZE = config.MaxBlockSize
+	blk.MAX_FUTURE_DRIFT = config.MaxFutureDrift
+	blk.MEDIAN_TIME_BLOCKS = config.MedianTimeBlocks
+	blk.FEE_PER_BYTE = config.FeePerByte
+	blk.MIN_FEE = config.MinFee
+	help.QUORUM = config.Quorum
+	help.NETWORK_ID = config.NetworkID
+	blk.GOVERNORS = strings.FieldsFunc(config.Governors, func(r rune) bool { return r == ',' || r == ' ' })
+	st.STORE_DIR = config.StoreDir
+	help.BLOB_STORE = help.DirBlobStore{Dir: config.BlobDir}
+
+	divergence_check_time = config.DivergenceCheckTime
+	liveness_check_time = config.LivenessCheckTime
+	drain_grace_time = config.DrainGraceTime
+	subscribe_ping_time = config.SubscribePingTime
+	ban_threshold = config.BanThreshold
+	finality_depth = config.FinalityDepth
+	return nil
+}
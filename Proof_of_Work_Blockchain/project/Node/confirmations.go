@@ -0,0 +1,150 @@
+package node
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	blk "project/Block"
+	help "project/Helpers"
+	"sync"
+	"time"
+)
+
+/* Longest a node keeps a callback waiting for its content to be committed */
+const CALLBACK_TIMEOUT time.Duration = 10 * time.Minute
+
+var callbacks_mutex sync.Mutex
+
+/*
+The confirmation of content, as returned by /content, /receipt/{id} and
+/status?content_id=, and posted to the callback of the content.
+*/
+type ContentStatus struct {
+	ContentID     string `json:"content_id"`
+	Found         bool   `json:"found"`
+	Index         int    `json:"index"`
+	BlockHash     string `json:"block_hash"`
+	Confirmations int    `json:"confirmations"` // Blocks from the content's block up to the tip, both included
+	Final         bool   `json:"final"`         // The content's block is finalized, see Finalized
+}
+
+/* A URL to post the ContentStatus of content to once it is committed */
+type ContentCallback struct {
+	ContentID string
+	URL       string
+	Expires   time.Time
+}
+
+/*
+Return the confirmation of the content with the given ID on this node's
+blockchain, see blk.ContentID. Content sent without an ID, e.g. by older
+users, is found by its SHA-256 instead, hex encoded. A pruned node only
+looks through the blocks it keeps in full.
+*/
+func (node *Node) FindContentStatus(contentID string) ContentStatus {
+	return findContentStatus(node.chain(), contentID)
+}
+
+/*
+Return the confirmation of the content with the given ID on the given
+blocks of a node's blockchain, see FindContentStatus.
+*/
+func findContentStatus(blocks []*blk.Block, contentID string) ContentStatus {
+	status := ContentStatus{ContentID: contentID}
+	for _, block := range blocks {
+		if block.EntryWithID(contentID) >= 0 {
+			status.Found, status.Index, status.BlockHash = true, block.Index, hex.EncodeToString(block.SelfHash)
+			break
+		}
+	}
+	if !status.Found {
+		receipt := findReceipt(blocks, ReceiptRequest{ContentHash: contentID, After: -1})
+		status.Found, status.Index, status.BlockHash = receipt.Found, receipt.Index, receipt.BlockHash
+	}
+	if status.Found {
+		status.Confirmations = len(blocks) - status.Index
+		status.Final = final(status.Confirmations)
+	}
+	return status
+}
+
+/*
+Return the confirmation of the content the user with the author address
+sent, under the content ID it was committed with, whenever it was sent,
+see blk.SubmissionHash. Users sending content again get the confirmation of
+the content they sent first.
+*/
+func (node *Node) FindSentContentStatus(content string, author string) (ContentStatus, bool) {
+	blocks := node.chain()
+	for _, block := range blocks {
+		for i, entry := range block.Entries {
+			if len(block.Authors) > i && block.Authors[i] == author && len(block.ContentIDs) > i && string(entry) == content {
+				return findContentStatus(blocks, block.ContentIDs[i]), true
+			}
+		}
+	}
+	return ContentStatus{}, false
+}
+
+/*
+Return true if callback is a URL this node may post confirmations to.
+Nodes and users only reach each other on localhost.
+*/
+func ValidCallback(callback string) bool {
+	parsed, err := url.Parse(callback)
+	if err != nil || parsed.Scheme != "http" {
+		return false
+	}
+	host := parsed.Hostname()
+	return host == "localhost" || host == "127.0.0.1"
+}
+
+/*
+Post the ContentStatus of the content with the given ID to callback once
+the content is committed to this node's blockchain, or right away if it
+is already. The callback is dropped after CALLBACK_TIMEOUT.
+*/
+func (node *Node) AddCallback(contentID string, callback string) {
+	callbacks_mutex.Lock()
+	node.callbacks = append(node.callbacks, &ContentCallback{ContentID: contentID, URL: callback, Expires: time.Now().Add(CALLBACK_TIMEOUT)})
+	callbacks_mutex.Unlock()
+
+	node.notifyCallbacks(node.chain())
+}
+
+/*
+Post their ContentStatus to the callbacks whose content is now committed
+to the given blocks of this node's blockchain, and drop them along with
+the expired ones.
+*/
+func (node *Node) notifyCallbacks(blocks []*blk.Block) {
+	callbacks_mutex.Lock()
+	defer callbacks_mutex.Unlock()
+
+	waiting := []*ContentCallback{}
+	for _, callback := range node.callbacks {
+		status := findContentStatus(blocks, callback.ContentID)
+		if status.Found {
+			go node.postCallback(callback.URL, status)
+		} else if time.Now().Before(callback.Expires) {
+			waiting = append(waiting, callback)
+		}
+	}
+	node.callbacks = waiting
+}
+
+func (node *Node) postCallback(callback string, status ContentStatus) {
+	jsonBytes, err := json.Marshal(status)
+	if help.Check(err) {
+		return
+	}
+
+	resp, err := help.HTTP_CLIENT.Post(callback, "application/json", bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		node.logger().Warnf("could not confirm content to %s", callback)
+		return
+	}
+	help.CloseBody(resp)
+	node.logger().Infof("confirmed content %s in block %d to %s", status.ContentID, status.Index, callback)
+}
@@ -0,0 +1,88 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	cs "project/Consensus"
+	"project/Consensus/pow"
+	help "project/Helpers"
+	usr "project/User"
+)
+
+/* Set on content a node relays to the leader, which does not relay it again */
+const RELAYED_HEADER string = "X-Relayed-By"
+
+/*
+Return the consensus engine of this node, mining with a Proof of Work on
+node.Miners goroutines unless node.Consensus is set.
+*/
+func (node *Node) engine() cs.Consensus {
+	if node.Consensus == nil {
+		return pow.NewEngine(node.Miners)
+	}
+	return node.Consensus
+}
+
+/*
+Returns true once sealing a block should stop: a peer's block was validated
+meanwhile, or the node entered safe mode.
+*/
+func (node *Node) interrupted() bool {
+	return node.arrivals().Pending() || node.InSafeMode()
+}
+
+/*
+Return the leader of the block following this node's blockchain, among its
+known peers, "" if none of them may lead. Returns false if any node may
+seal the next block under this node's consensus engine.
+*/
+func (node *Node) leader() (string, bool) {
+	engine, led := node.engine().(cs.Leader)
+	if !led {
+		return "", false
+	}
+	leader, _ := engine.Leader(node.Blockchain.Blocks, node.KnownPeers())
+	return leader, true
+}
+
+/*
+Relay content a user sent to this node to the leader of the next block,
+and reply with the leader's response. Content relayed once is not relayed
+again, the user resubmits it once the nodes agree on the leader.
+*/
+func (node *Node) relayContent(w http.ResponseWriter, r *http.Request, content usr.Content, leader string) {
+	if leader == "" || r.Header.Get(RELAYED_HEADER) != "" {
+		node.logger().Warnf("could not relay content, the leader is %q", leader)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	jsonBytes, err := json.Marshal(content)
+	if help.Check(err) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	req, err := http.NewRequest("POST", help.NodeURL(leader)+CONTENT, bytes.NewBuffer(jsonBytes))
+	if help.Check(err) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(RELAYED_HEADER, node.Port)
+
+	resp, err := help.HTTP_CLIENT.Do(req)
+	if help.Check(err) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	defer help.CloseBody(resp)
+
+	node.logger().Debugf("relayed content to the leader %s", leader)
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
@@ -0,0 +1,208 @@
+package node
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	blk "project/Block"
+	help "project/Helpers"
+	"sync"
+	"time"
+)
+
+var divergence_mutex sync.Mutex
+
+/* How often a node compares its tip with its peers' */
+var divergence_check_time time.Duration = 1000 * time.Millisecond
+
+/*
+Return the hex encoded hash of the last block in this node's blockchain,
+or an empty string if it has no blockchain yet.
+*/
+func (node *Node) TipHash() string {
+	return tipHash(node.chain())
+}
+
+/*
+Return the hex encoded hash of the last of the blocks, see TipHash.
+*/
+func tipHash(blocks []*blk.Block) string {
+	if len(blocks) == 0 {
+		return ""
+	}
+	return hex.EncodeToString(blocks[len(blocks)-1].SelfHash)
+}
+
+/*
+Return true while this node is in safe mode, after it diverged from its
+peers and before its blockchain was reconciled. Nodes do not mine in safe mode.
+*/
+func (node *Node) InSafeMode() bool {
+	divergence_mutex.Lock()
+	defer divergence_mutex.Unlock()
+	return node.Diverged
+}
+
+/*
+Ask each peer for its /status and return true if a majority of the
+known nodes are at the same height as this node, but with a different tip.
+*/
+func (node *Node) DetectDivergence() bool {
+	known_ports := node.KnownPeers()
+	blocks := node.chain()
+	height, tip := len(blocks), tipHash(blocks)
+	if tip == "" {
+		return false // No blockchain to diverge from yet
+	}
+
+	count_diverged := 0
+	for _, port := range known_ports {
+		// Skip this node
+		if port == node.Port {
+			continue
+		}
+
+		resp, err := help.HTTP_CLIENT.Get(help.NodeURL(port) + STATUS)
+		if err != nil {
+			continue // Peer is not active
+		}
+
+		var status NodeStatus
+		err = json.NewDecoder(resp.Body).Decode(&status)
+		help.CloseBody(resp)
+		if err != nil {
+			continue
+		}
+
+		if status.Height == height && status.TipHash != tip {
+			count_diverged++
+		}
+	}
+
+	return count_diverged > 0 && count_diverged >= help.Quorum(len(known_ports))
+}
+
+/*
+Check whether this node diverged from its peers. On divergence, raise the
+alarm: log it, count it and enter safe mode, which pauses mining. Then try
+to reconcile by adopting the majority blockchain, and leave safe mode once
+the node agrees with its peers again.
+*/
+func (node *Node) CheckDivergence() {
+	diverged := node.DetectDivergence()
+
+	divergence_mutex.Lock()
+	raised := diverged && !node.Diverged
+	if raised {
+		node.Diverged = true
+		node.DivergenceAlarms++
+	}
+	inSafeMode := node.Diverged
+	divergence_mutex.Unlock()
+
+	if raised {
+		blocks := node.chain()
+		node.logger().Errorf("ALARM: diverged from its peers at height %d with tip %s, entering safe mode",
+			len(blocks), tipHash(blocks))
+	}
+
+	if !inSafeMode {
+		return
+	}
+
+	// Reconcile with the majority, then see if we agree with them now
+	if node.UpdateBlockchain() && !node.DetectDivergence() {
+		divergence_mutex.Lock()
+		node.Diverged = false
+		divergence_mutex.Unlock()
+
+		node.logger().Infof("reconciled its blockchain at tip %s, leaving safe mode", node.TipHash())
+	}
+}
+
+/*
+Append the blocks a peer ahead of this node holds after this node's last
+block, e.g. a block its peers accepted while this node rejected it in a
+conflict, once checked to link up by their hashes and each validated on top
+of the blocks before it, see ValidateBlock. A blockchain extending this
+node's is heavier, so a single peer is enough, and a peer sending an invalid
+block is passed over for the next. The blocks are only appended if this
+node's blockchain still ends in the same block once the peer answered. Returns true if the
+blockchain grew.
+*/
+func (node *Node) CatchUp() bool {
+	blocks := node.chain()
+	height := len(blocks)
+	if height == 0 {
+		return false // No blockchain to extend yet
+	}
+	tip := blocks[height-1].SelfHash
+
+peers:
+	for _, port := range node.PeersByLatency(node.KnownPeers()) {
+		if port == node.Port {
+			continue
+		}
+
+		resp, err := help.HTTP_CLIENT.Get(help.NodeURL(port) + STATUS)
+		if err != nil {
+			continue // Peer is not active
+		}
+		var status NodeStatus
+		err = json.NewDecoder(resp.Body).Decode(&status)
+		help.CloseBody(resp)
+		if err != nil || status.Height <= height {
+			continue
+		}
+
+		resp, err = help.GetGzip(fmt.Sprintf("%s%s?index=%d", help.NodeURL(port), BLOCKS_SINCE, height))
+		if err != nil {
+			continue
+		}
+		var events BlockEvents
+		err = json.NewDecoder(resp.Body).Decode(&events)
+		help.CloseBody(resp)
+		if err != nil || len(events.Blocks) == 0 || !linksTo(tip, height, events.Blocks, events.Tip) {
+			continue // E.g. the peer is on another branch
+		}
+
+		node.Acceptance_mu.Lock()
+		current := node.Blockchain.Blocks
+		if len(current) != height || !bytes.Equal(current[height-1].SelfHash, tip) {
+			node.Acceptance_mu.Unlock()
+			return false // The blockchain changed while the peer was asked
+		}
+		// Each block must be valid on top of the blocks before it, as if it arrived alone
+		updated := make([]*blk.Block, 0, events.Height)
+		updated = append(updated, current...)
+		for _, block := range events.Blocks {
+			if !node.validateBlock(updated, *block, 0) {
+				node.Acceptance_mu.Unlock()
+				node.logger().Warnf("refused block{ %s } from %s while catching up", block.ContentString(), port)
+				continue peers
+			}
+			updated = append(updated, block)
+		}
+		node.Blockchain.Blocks = updated
+		node.persistBlockchain()
+		node.Acceptance_mu.Unlock()
+
+		node.logger().Infof("caught up with %d blocks from %s", len(events.Blocks), port)
+		return true
+	}
+	return false
+}
+
+/*
+Periodically catch up with peers ahead of this node, see CatchUp, and check
+it for divergence from its peers, until it shuts down.
+*/
+func (node *Node) MonitorDivergence() {
+	for node.sleep(divergence_check_time) {
+		if !node.InSafeMode() {
+			node.CatchUp()
+		}
+		node.CheckDivergence()
+	}
+}
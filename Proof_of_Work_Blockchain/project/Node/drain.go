@@ -0,0 +1,141 @@
+package node
+
+import (
+	help "project/Helpers"
+	"sync"
+	"time"
+)
+
+var drain_mutex sync.Mutex
+
+/* Time a draining node keeps validating peers' blocks once its mining is done */
+var drain_grace_time time.Duration = 2000 * time.Millisecond
+
+/* A request for a node's /drain */
+type DrainRequest struct {
+	GraceMs int `json:"grace_ms"` // Grace period in milliseconds, drain_grace_time if 0
+}
+
+/*
+Return true while this node is draining, i.e. it no longer accepts /content.
+*/
+func (node *Node) IsDraining() bool {
+	drain_mutex.Lock()
+	defer drain_mutex.Unlock()
+	return node.Draining
+}
+
+/*
+Return true until this node shuts down.
+*/
+func (node *Node) IsRunning() bool {
+	drain_mutex.Lock()
+	defer drain_mutex.Unlock()
+	return node.Running
+}
+
+/*
+Wait for d, or until the node shuts down. Returns false if it shut down.
+*/
+func (node *Node) sleep(d time.Duration) bool {
+	drain_mutex.Lock()
+	stopped := node.stopped
+	drain_mutex.Unlock()
+
+	select {
+	case <-time.After(d):
+		return node.IsRunning()
+	case <-stopped:
+		return false
+	}
+}
+
+/*
+Count content this node queues for mining, so draining can wait for it.
+Returns false if the node is draining and must not mine new content.
+*/
+func (node *Node) startMining() bool {
+	drain_mutex.Lock()
+	defer drain_mutex.Unlock()
+
+	if node.Draining {
+		return false
+	}
+	node.Mining++
+	return true
+}
+
+/*
+Count content this node is done mining.
+*/
+func (node *Node) doneMining() {
+	drain_mutex.Lock()
+	defer drain_mutex.Unlock()
+	node.Mining--
+}
+
+/*
+Start draining this node for a maintenance window: stop accepting new
+/content, finish mining the content it queued, even if mining was paused,
+keep validating peers' blocks
+for the grace period, then shut down. Returns false if the node was
+already draining.
+*/
+func (node *Node) Drain(grace time.Duration) bool {
+	drain_mutex.Lock()
+	if node.Draining {
+		drain_mutex.Unlock()
+		return false
+	}
+	node.Draining = true
+	drain_mutex.Unlock()
+
+	node.logger().Infof("is draining")
+	node.ResumeMining() // The queued content is mined before the node shuts down
+
+	go func() {
+		// Finish mining the queued content
+		for {
+			drain_mutex.Lock()
+			mining := node.Mining
+			drain_mutex.Unlock()
+
+			if mining == 0 {
+				break
+			}
+			time.Sleep(wait10_time)
+		}
+
+		// Keep validating peers' blocks, so they still reach a majority
+		time.Sleep(grace)
+
+		node.Shutdown()
+	}()
+
+	return true
+}
+
+/*
+Shut this node down cleanly: announce it leaves the network, so peers stop
+counting on its votes, stop listening and wait for the goroutines watching
+its peers to return. The node may then be replaced by a newly registered one.
+*/
+func (node *Node) Shutdown() {
+	node.Leave()
+
+	drain_mutex.Lock()
+	if node.Running && node.stopped != nil {
+		close(node.stopped)
+	}
+	node.Running = false
+	drain_mutex.Unlock()
+
+	if node.server != nil {
+		help.Check(node.server.Close())
+	}
+	if node.monitors != nil {
+		node.monitors.Wait()
+	}
+
+	node.logger().Infof("shut down")
+}
@@ -0,0 +1,62 @@
+package node
+
+import (
+	"encoding/json"
+	"net/http"
+	blk "project/Block"
+)
+
+const FINALIZED string = "/finalized"
+
+/*
+Blocks on top of a block before users may consider it irreversible, see
+Finalized. Checkpoints, which bound how far forks reach, are recorded
+CHECKPOINT_DEPTH blocks deep whatever the finality depth.
+*/
+var finality_depth int = CHECKPOINT_DEPTH
+
+/*
+The latest finalized block of a node, as replied by /finalized.
+*/
+type FinalizedBlock struct {
+	Height int        `json:"height"` // Number of blocks on the node's blockchain
+	Depth  int        `json:"depth"`  // Blocks on top of a block before it is finalized
+	Block  *blk.Block `json:"block"`
+}
+
+/*
+Return the latest block of this node's blockchain with finality_depth
+blocks on top of it, which users may consider irreversible, or false if the
+blockchain is not that long yet.
+*/
+func (node *Node) Finalized() (*blk.Block, bool) {
+	return node.BlockByIndex(len(node.Blockchain.Blocks) - 1 - finality_depth)
+}
+
+/* Returns true if content with the given confirmations is in a finalized block */
+func final(confirmations int) bool {
+	return confirmations > finality_depth
+}
+
+/*
+Handle /finalized, reply with the latest finalized block. A pruned block is
+fetched in full from an archive peer first. Answers 404 Not Found while the
+blockchain holds no finalized block.
+*/
+func (node *Node) HandleFinalized(w http.ResponseWriter, r *http.Request) {
+	height := len(node.Blockchain.Blocks)
+	block, found := node.Finalized()
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	full, ok := node.fullBlocks([]*blk.Block{block})
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(FinalizedBlock{Height: height, Depth: finality_depth, Block: full[0]})
+}
@@ -0,0 +1,196 @@
+package node
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	blk "project/Block"
+	"sync"
+)
+
+/* Most blocks kept off the blockchain; the lowest ones are forgotten first */
+const MAX_BRANCH_BLOCKS int = 64
+
+/*
+Valid blocks that are not on this node's blockchain, e.g. the losers of a
+tie or the blocks a node rolled back, kept in case their branch becomes
+heavier than the blockchain. Keyed by their hex encoded hash.
+*/
+type Branches struct {
+	mu     sync.Mutex
+	blocks map[string]*blk.Block
+}
+
+func NewBranches() *Branches {
+	return &Branches{blocks: map[string]*blk.Block{}}
+}
+
+/*
+Keep a block off the blockchain. Returns false if it was kept already.
+*/
+func (branches *Branches) Add(block *blk.Block) bool {
+	branches.mu.Lock()
+	defer branches.mu.Unlock()
+
+	key := hex.EncodeToString(block.SelfHash)
+	if _, known := branches.blocks[key]; known {
+		return false
+	}
+	branches.blocks[key] = block
+
+	for len(branches.blocks) > MAX_BRANCH_BLOCKS {
+		lowest := ""
+		for hash, kept := range branches.blocks {
+			if lowest == "" || kept.Index < branches.blocks[lowest].Index {
+				lowest = hash
+			}
+		}
+		delete(branches.blocks, lowest)
+	}
+	return true
+}
+
+/* Forget a block, e.g. once it is on the blockchain */
+func (branches *Branches) Remove(block *blk.Block) {
+	branches.mu.Lock()
+	defer branches.mu.Unlock()
+	delete(branches.blocks, hex.EncodeToString(block.SelfHash))
+}
+
+/* Return the number of blocks kept */
+func (branches *Branches) Len() int {
+	branches.mu.Lock()
+	defer branches.mu.Unlock()
+	return len(branches.blocks)
+}
+
+/*
+Follow tip back through the kept blocks to the blockchain blocks. Returns
+the index the branch forks from blocks at and the blocks of the branch in
+order, or false if the branch does not lead back to blocks.
+*/
+func (branches *Branches) branchTo(tip *blk.Block, blocks []*blk.Block) (int, []*blk.Block, bool) {
+	branches.mu.Lock()
+	defer branches.mu.Unlock()
+
+	branch := []*blk.Block{tip}
+	for {
+		first := branch[0]
+		parent := first.Index - 1
+		if parent < 0 {
+			return 0, nil, false // Another genesis block
+		}
+		if parent < len(blocks) && bytes.Equal(blocks[parent].SelfHash, first.PrevBlockHash) {
+			return parent + 1, branch, true
+		}
+
+		prev, found := branches.blocks[hex.EncodeToString(first.PrevBlockHash)]
+		if !found || prev.Index != parent {
+			return 0, nil, false // Missing a block of the branch
+		}
+		branch = append([]*blk.Block{prev}, branch...)
+	}
+}
+
+/*
+Return the work a block of the given difficulty proves: the number of
+hashes it takes on average to find its nonce.
+*/
+func Work(difficulty int) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(difficulty))
+}
+
+/*
+Return the cumulative work of blocks, the sum of the work of each block.
+*/
+func ChainWork(blocks []*blk.Block) *big.Int {
+	work := big.NewInt(0)
+	for _, block := range blocks {
+		work.Add(work, Work(block.Difficulty))
+	}
+	return work
+}
+
+/*
+Keep a valid block that is not on this node's blockchain, and switch to
+its branch if the branch now carries more cumulative work than the
+blockchain. The blocks after the fork are rolled back and kept in turn,
+and the blocks of the branch are applied once each validates against the
+blocks before it, see ValidateBlock. On equal work, the blockchain is kept, and a branch forking
+off before a checkpoint is never switched to.
+Returns true if the node switched to the block's branch.
+*/
+func (node *Node) considerBranch(block blk.Block) bool {
+	if node.Branches == nil || !block.Validate() {
+		return false
+	}
+
+	node.Acceptance_mu.Lock()
+	defer node.Acceptance_mu.Unlock()
+
+	blocks := node.Blockchain.Blocks
+	if len(blocks) == 0 || node.IsDoubleSpend(blocks, block) {
+		return false
+	}
+	node.Branches.Add(&block)
+
+	fork, branch, ok := node.Branches.branchTo(&block, blocks)
+	if !ok {
+		return false
+	}
+
+	candidate := make([]*blk.Block, 0, fork+len(branch))
+	candidate = append(candidate, blocks[:fork]...)
+	candidate = append(candidate, branch...)
+	if ChainWork(candidate).Cmp(ChainWork(blocks)) <= 0 || !node.keepsCheckpoints(candidate) {
+		return false
+	}
+
+	// Each block of the branch must be valid on top of the blocks before it, as if it arrived alone
+	for i, applied := range branch {
+		if !node.validateBlock(candidate[:fork+i], *applied, 0) {
+			node.logger().Warnf("dropped invalid branch block{ %s }", applied.ContentString())
+			node.Branches.Remove(applied)
+			return false
+		}
+	}
+
+	rolledBack := blocks[fork:]
+	for _, removed := range rolledBack {
+		node.Branches.Add(removed)
+	}
+	for _, applied := range branch {
+		node.Branches.Remove(applied)
+	}
+
+	node.Blockchain.Blocks = candidate
+	node.persistBlockchain()
+	node.logger().Infof("switched to a heavier branch at block %d: rolled back %d blocks, applied %d", fork, len(rolledBack), len(branch))
+	return true
+}
+
+/*
+Get the validated block heading the heaviest branch: the blockchain up to
+the block, then the block. Blocks skipped by a block are counted at its
+difficulty. On a tie, the earliest validated block is returned.
+*/
+func (node *Node) FindHeaviestBranch(validated []blk.Block) blk.Block {
+	blocks := node.Blockchain.Blocks
+	best := 0
+	var bestWork *big.Int
+
+	for i, block := range validated {
+		prefix := blocks
+		if block.Index < len(prefix) {
+			prefix = prefix[:block.Index]
+		}
+		blocksAdded := big.NewInt(int64(block.Index - len(prefix) + 1))
+		work := new(big.Int).Add(ChainWork(prefix), new(big.Int).Mul(blocksAdded, Work(block.Difficulty)))
+
+		if bestWork == nil || work.Cmp(bestWork) > 0 {
+			best, bestWork = i, work
+		}
+	}
+
+	return validated[best]
+}
@@ -0,0 +1,109 @@
+package node
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	blk "project/Block"
+	help "project/Helpers"
+	"strconv"
+)
+
+const BLOCK string = "/block"
+
+/*
+Return the block at the given index of this node's blockchain, if it has one.
+*/
+func (node *Node) BlockByIndex(index int) (*blk.Block, bool) {
+	blocks := node.Blockchain.Blocks
+	if index < 0 || index >= len(blocks) {
+		return nil, false
+	}
+	return blocks[index], true
+}
+
+/*
+Return the block of this node's blockchain with the given hex encoded hash, if it has one.
+*/
+func (node *Node) BlockByHash(hash string) (*blk.Block, bool) {
+	for _, block := range node.Blockchain.Blocks {
+		if hex.EncodeToString(block.SelfHash) == hash {
+			return block, true
+		}
+	}
+	return nil, false
+}
+
+/*
+Handle /block?index=N or /block?hash=H, reply with the block. A pruned
+block is fetched in full from an archive peer first.
+*/
+func (node *Node) HandleBlock(w http.ResponseWriter, r *http.Request) {
+	index, hash := r.URL.Query().Get("index"), r.URL.Query().Get("hash")
+
+	var block *blk.Block
+	found := false
+	if index != "" && hash == "" {
+		i, err := strconv.Atoi(index)
+		if help.Check(err) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		block, found = node.BlockByIndex(i)
+	} else if hash != "" && index == "" {
+		block, found = node.BlockByHash(hash)
+	} else {
+		w.WriteHeader(http.StatusBadRequest) // Exactly one of them
+		return
+	}
+
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	full, ok := node.fullBlocks([]*blk.Block{block})
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	block = full[0]
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(block)
+}
+
+/*
+Ask the node at port for the block at the given index of its blockchain.
+Returns false if the node did not answer or has no such block.
+*/
+func GetBlockByIndex(port string, index int) (*blk.Block, bool) {
+	return getBlock(port, url.Values{"index": {strconv.Itoa(index)}})
+}
+
+/*
+Ask the node at port for the block with the given hex encoded hash.
+Returns false if the node did not answer or has no such block.
+*/
+func GetBlockByHash(port string, hash string) (*blk.Block, bool) {
+	return getBlock(port, url.Values{"hash": {hash}})
+}
+
+func getBlock(port string, query url.Values) (*blk.Block, bool) {
+	resp, err := help.HTTP_CLIENT.Get(help.NodeURL(port) + BLOCK + "?" + query.Encode())
+	if help.Check(err) {
+		return nil, false
+	}
+	defer help.CloseBody(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, false
+	}
+
+	var block blk.Block
+	if help.Check(json.NewDecoder(resp.Body).Decode(&block)) {
+		return nil, false
+	}
+	return &block, true
+}
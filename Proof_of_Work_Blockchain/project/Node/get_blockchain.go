@@ -1,74 +1,309 @@
 package node
 
 import (
+	"bytes"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	blk "project/Block"
 	bc "project/Blockchain"
 	help "project/Helpers"
+	"strconv"
+	"strings"
+	"time"
 )
 
+const TIP string = "/tip"
+
+/* Number of blocks asked for in each page when copying a blockchain */
+var CHAIN_PAGE_SIZE = 100
+
+/* Most blocks a page of /copy_chain holds */
+const MAX_CHAIN_PAGE int = 1000
+
 /*
-Send /copychain to all known ports and return the majority blockchain.
+A page of a node's blockchain as returned by /copy_chain?offset=N&limit=M:
+the blocks from Offset on, and the height and the hash of the last block of
+the whole blockchain, so pages fetched one after the other are known to
+come from the same blockchain.
 */
-func GetBlockchain(filepath string) (bool, bc.Blockchain) {
-	// Get all known ports
-	known_ports := help.GetPorts(filepath)
+type ChainPage struct {
+	bc.Blockchain
+	Offset int    `json:"offset"`
+	Height int    `json:"height"`
+	Tip    []byte `json:"tip"`
+}
 
-	// Array of response bodies
-	responses := []string{}
+/*
+The height of a node's blockchain and the hash of its last block, as
+returned by /tip. The tip of an empty blockchain has no hash.
+*/
+type ChainTip struct {
+	Height int    `json:"height"`
+	Tip    []byte `json:"tip"`
+}
 
-	/* Iterate over each port */
-	for _, port := range known_ports {
-		// Create url using the node's port
-		url := LOCALHOST + port + COPY_CHAIN
+/*
+Return the height and the last block hash of this node's blockchain.
+*/
+func (node *Node) ChainTip() ChainTip {
+	return chainTip(node.chain())
+}
 
-		// Create a new HTTP client
-		client := &http.Client{}
+/* Return the height and the last block hash of the given blocks */
+func chainTip(blocks []*blk.Block) ChainTip {
+	tip := ChainTip{Height: len(blocks)}
+	if len(blocks) > 0 {
+		tip.Tip = blocks[len(blocks)-1].SelfHash
+	}
+	return tip
+}
 
-		// Send a GET request to http://localhost:known_port/copychain
-		resp, err := client.Get(url)
-		if !help.Check(err) {
-			// Read the response body
-			body, err := io.ReadAll(resp.Body)
-			help.Check(err)
-			// Append the response for later
-			responses = append(responses, string(body))
+/*
+Handle /tip, reply with the height and the last block hash of this node's blockchain.
+*/
+func (node *Node) HandleTip(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(node.ChainTip())
+}
+
+/*
+Handle /copy_chain: reply with this node's blockchain, or with the headers
+of its blocks for ?headers=true. With ?offset=N, ?limit=M or both, reply
+with the page of the blockchain of at most M blocks from index N on, M
+being capped at MAX_CHAIN_PAGE, see ChainPage. Pruned blocks are fetched in
+full from an archive peer, or 503 Service Unavailable is replied.
+*/
+func (node *Node) HandleCopyChain(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	blocks := node.chain()
+	tip := chainTip(blocks)
+	page := ChainPage{Height: tip.Height, Tip: tip.Tip}
+
+	paged := query.Has("offset") || query.Has("limit")
+	if paged {
+		offset, limit := 0, MAX_CHAIN_PAGE
+		var err error
+		if query.Has("offset") {
+			offset, err = strconv.Atoi(query.Get("offset"))
+		}
+		if err == nil && query.Has("limit") {
+			limit, err = strconv.Atoi(query.Get("limit"))
+		}
+		if help.Check(err) || offset < 0 || limit <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if limit > MAX_CHAIN_PAGE {
+			limit = MAX_CHAIN_PAGE
+		}
+		if offset > len(blocks) {
+			offset = len(blocks)
 		}
+		if offset+limit < len(blocks) {
+			blocks = blocks[:offset+limit]
+		}
+		blocks = blocks[offset:]
+		page.Offset = offset
+	}
+
+	if query.Get("headers") == "true" {
+		blocks = Headers(blocks)
+	} else if full, ok := node.fullBlocks(blocks); ok {
+		blocks = full
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
 	}
 
-	chosenChain := ""
+	if !paged {
+		help.WriteJSON(w, r, bc.Blockchain{Blocks: blocks})
+		return
+	}
+	page.Blocks = blocks
+	help.WriteJSON(w, r, page)
+}
+
+/*
+Send /copychain to all the peers the seed node knows of and return the majority blockchain.
+*/
+func GetBlockchain(seed string) (bool, bc.Blockchain) {
+	return getBlockchain(help.GetPeers(seed), nil)
+}
 
-	// For each received responseA
-	for _, responseA := range responses {
-		count := 0
-		// For each received responseA
-		for _, responseB := range responses {
-			// Compare responseA with all other responses
-			if responseB == responseA {
-				count++ // Count how many responses are similar
-			}
+/*
+Send /copy_chain?headers=true to all the peers the seed node knows of and
+return the majority blockchain, holding the headers of its blocks only.
+*/
+func GetHeaders(seed string) (bool, bc.Blockchain) {
+	return getChain(help.GetPeers(seed), nil, COPY_CHAIN+"?"+HEADERS_ONLY)
+}
 
-			// If the response count is greater than majority,
-			// then adopt it as the right blockchain
-			if count >= (len(known_ports)/3)*2 {
-				chosenChain = responseA
-			}
+/*
+Send /copychain to the known ports and return the majority blockchain.
+When a node asks, it measures the round-trip time to each peer, asks the
+fastest peers first and stops asking once a majority agrees. A node syncs
+headers first instead, see getBlockchainHeadersFirst, and only copies the
+whole blockchain if its peers do not agree on headers, e.g. peers from
+before /headers.
+*/
+func getBlockchain(known_ports []string, node *Node) (bool, bc.Blockchain) {
+	if node != nil {
+		if success, blockchain := node.getBlockchainHeadersFirst(known_ports); success || node.PruneDepth > 0 {
+			return success, blockchain
 		}
 	}
+	return getChain(known_ports, node, COPY_CHAIN)
+}
 
-	if chosenChain == "" {
+/*
+Return the majority blockchain of the known ports, see majorityTip,
+downloaded with GET path from a single peer of the majority, see copyChain,
+the next one if it fails.
+Returns false if there is no majority or no peer of it serves its blockchain.
+*/
+func getChain(known_ports []string, node *Node, path string) (bool, bc.Blockchain) {
+	tip, majority, ok := majorityTip(known_ports, node)
+	if !ok {
 		return false, bc.Blockchain{}
 	}
+	for _, port := range majority {
+		if blockchain, ok := copyChain(port, node, path, tip); ok {
+			help.LOG.Debugf("Successfully got a blockchain from %s", port)
+			return true, blockchain
+		}
+	}
+	help.LOG.Warnf("could not copy the majority's blockchain from any of %v", majority)
+	return false, bc.Blockchain{}
+}
+
+/*
+Send /tip to the known ports, fastest first, until a majority agrees on the
+height and last block hash of their blockchain, and return them with the
+ports of the majority. Peers that agree on the height and the tip hold the
+same blocks, since each hash covers the previous one, so comparing tips
+finds the majority without transferring any blockchain.
+Returns false if there is no majority.
+*/
+func majorityTip(known_ports []string, node *Node) (ChainTip, []string, bool) {
+	if node != nil {
+		known_ports = node.PeersByLatency(known_ports)
+	}
+
+	agreeing := map[string][]string{}
+	for _, port := range known_ports {
+		tip, ok := getTip(port, node)
+		if !ok {
+			continue
+		}
+		key := fmt.Sprintf("%d:%s", tip.Height, hex.EncodeToString(tip.Tip))
+		agreeing[key] = append(agreeing[key], port)
+		if len(agreeing[key]) >= help.Quorum(len(known_ports)) {
+			return tip, agreeing[key], true
+		}
+	}
+	return ChainTip{}, nil, false
+}
+
+/*
+Send /tip to the peer at port. Returns false if it did not answer.
+*/
+func getTip(port string, node *Node) (ChainTip, bool) {
+	start := time.Now()
+	resp, err := help.HTTP_CLIENT.Get(help.NodeURL(port) + TIP)
+	if node != nil {
+		node.RecordLatency(port, time.Since(start), err == nil)
+	}
+	if help.Check(err) {
+		return ChainTip{}, false
+	}
+	defer help.CloseBody(resp)
+
+	var tip ChainTip
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&tip) != nil || tip.Height < 0 {
+		return ChainTip{}, false // E.g. a peer from before /tip
+	}
+	return tip, true
+}
+
+/*
+Fetch the blockchain of the peer at port with GET path, in pages, see
+getChainPage, up to the given tip. The peer may have accepted blocks since
+it answered /tip, they are left out. Returns false unless the blocks lead
+to the tip and verify, see bc.Blockchain.Verify.
+*/
+func copyChain(port string, node *Node, path string, tip ChainTip) (bc.Blockchain, bool) {
+	blocks := []*blk.Block{}
+	for len(blocks) < tip.Height {
+		page, ok := getChainPage(port, node, path, len(blocks))
+		if !ok || len(page.Blocks) == 0 {
+			help.LOG.Warnf("could not fetch the blocks from %d on from %s", len(blocks), port)
+			return bc.Blockchain{}, false
+		}
+		blocks = append(blocks, page.Blocks...)
+	}
+	blocks = blocks[:tip.Height]
 
-	/* Get the blockchain object from the json request */
-	var blockchain bc.Blockchain
-	if err := json.Unmarshal([]byte(chosenChain), &blockchain); err != nil {
-		panic(err)
+	blockchain := bc.Blockchain{Blocks: blocks}
+	if len(blocks) > 0 && !bytes.Equal(blocks[len(blocks)-1].SelfHash, tip.Tip) {
+		help.LOG.Warnf("refused a blockchain from %s that does not lead to the majority's last block", port)
+		return bc.Blockchain{}, false
 	}
-	fmt.Println("Successfully got a blockchain")
-	return true, blockchain
+	if err := blockchain.Verify(); err != nil {
+		help.LOG.Warnf("refused a blockchain from %s: %v", port, err)
+		return bc.Blockchain{}, false
+	}
+	return blockchain, true
+}
+
+/*
+Send GET path to the peer at port for the page of its blockchain starting
+at offset, CHAIN_PAGE_SIZE blocks long at most. Returns false if the peer
+did not answer with that page, e.g. a peer from before pagination.
+*/
+func getChainPage(port string, node *Node, path string, offset int) (ChainPage, bool) {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	url := fmt.Sprintf("%s%s%soffset=%d&limit=%d", help.NodeURL(port), path, separator, offset, CHAIN_PAGE_SIZE)
+
+	start := time.Now()
+	resp, err := help.GetGzip(url)
+	if node != nil {
+		node.RecordLatency(port, time.Since(start), err == nil)
+	}
+	if help.Check(err) {
+		return ChainPage{}, false
+	}
+	defer help.CloseBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return ChainPage{}, false
+	}
+
+	var page ChainPage
+	if json.NewDecoder(resp.Body).Decode(&page) != nil || page.Offset != offset || len(page.Blocks) > page.Height-offset {
+		return ChainPage{}, false
+	}
+	if !WithinSizeLimits(page.Blocks) {
+		help.LOG.Warnf("refused a page of a blockchain with oversized blocks")
+		return ChainPage{}, false
+	}
+	return page, true
+}
+
+/*
+Returns true if every block is within the size limits, see blk.Block.WithinSizeLimits.
+*/
+func WithinSizeLimits(blocks []*blk.Block) bool {
+	for _, block := range blocks {
+		if !block.WithinSizeLimits() {
+			return false
+		}
+	}
+	return true
 }
 
 /*
@@ -82,7 +317,8 @@ func PrintBlockchain(blockchain bc.Blockchain) {
 		fmt.Printf("  PrevBlockHash: %x\n", string(block.PrevBlockHash))
 		fmt.Printf("  Index: %d\n", block.Index)
 		fmt.Printf("  Timestamp: %d\n", block.Timestamp)
-		fmt.Printf("  Data: %s\n", string(block.Content))
+		fmt.Printf("  Data: %s\n", block.ContentString())
+		fmt.Printf("  Difficulty: %d\n", block.Difficulty)
 		fmt.Printf("  Nonce: %d\n", block.Nonce)
 		fmt.Printf("  SelfHash: %x\n", string(block.SelfHash))
 	}
@@ -92,14 +328,22 @@ func PrintBlockchain(blockchain bc.Blockchain) {
 }
 
 /*
-Update this node's blockchain. This function is not thread safe and should be
-called in a thread safe manner using Acceptance_mu since it updates the blockchain.
+Update this node's blockchain to the majority blockchain of its peers.
+Peers are first asked for the blocks after this node's last block only,
+see syncBlockchain. If that is not enough, e.g. after a fork, the whole
+majority blockchain is copied instead, unless it does not verify, see
+bc.Blockchain.Verify, or contradicts one of this node's checkpoints.
 */
 func (node *Node) UpdateBlockchain() bool {
-	success, blockchain := GetBlockchain(NODE_LIST)
-	if success {
+	if node.syncBlockchain() {
+		return true
+	}
+
+	success, blockchain := getBlockchain(node.KnownPeers(), node)
+	if success && node.verifies(blockchain) && node.keepsCheckpoints(blockchain.Blocks) {
 		node.Acceptance_mu.Lock()
 		node.Blockchain = blockchain
+		node.persistBlockchain()
 		node.Acceptance_mu.Unlock()
 	} else {
 		return false // Could not update blockchain
@@ -107,3 +351,98 @@ func (node *Node) UpdateBlockchain() bool {
 
 	return true
 }
+
+/*
+Returns true if the blockchain verifies, see bc.Blockchain.Verify, and logs
+why it does not otherwise.
+*/
+func (node *Node) verifies(blockchain bc.Blockchain) bool {
+	if err := blockchain.Verify(); err != nil {
+		node.logger().Warnf("refused a blockchain: %v", err)
+		return false
+	}
+	return true
+}
+
+/*
+Send /blocks_since to the known peers, fastest first, for the blocks after
+this node's last block, until a majority agrees on the height and tip they
+lead to. If the majority's blocks follow this node's last block, they are
+appended once checked to link up by their hashes and to carry a valid Proof
+of Work, and true is returned.
+Returns false if there is no majority or the majority's blockchain does not
+extend this node's.
+*/
+func (node *Node) syncBlockchain() bool {
+	known_ports := node.PeersByLatency(node.KnownPeers())
+	blocks := node.chain()
+	height := len(blocks)
+	var tip []byte
+	if height > 0 {
+		tip = blocks[height-1].SelfHash
+	}
+
+	// Peers that agree on the height and the tip hold the same blocks, since each hash covers the previous one
+	counts := map[string]int{}
+	var majority *BlockEvents
+	for _, port := range known_ports {
+		url := fmt.Sprintf("%s%s?index=%d", help.NodeURL(port), BLOCKS_SINCE, height)
+
+		start := time.Now()
+		resp, err := help.GetGzip(url)
+		node.RecordLatency(port, time.Since(start), err == nil)
+		if help.Check(err) {
+			continue
+		}
+
+		var events BlockEvents
+		err = json.NewDecoder(resp.Body).Decode(&events)
+		help.CloseBody(resp)
+		if err != nil {
+			continue // E.g. a peer from before /blocks_since
+		}
+
+		key := fmt.Sprintf("%d:%s", events.Height, hex.EncodeToString(events.Tip))
+		counts[key]++
+		if counts[key] >= help.Quorum(len(known_ports)) {
+			majority = &events
+			break
+		}
+	}
+
+	if majority == nil || majority.Height < height || !linksTo(tip, height, majority.Blocks, majority.Tip) {
+		return false
+	}
+	if len(majority.Blocks) == 0 {
+		return true // Up to date
+	}
+
+	node.Acceptance_mu.Lock()
+	defer node.Acceptance_mu.Unlock()
+
+	// The blockchain may have changed while peers were asked
+	if len(node.Blockchain.Blocks) != height {
+		return len(node.Blockchain.Blocks) > height
+	}
+
+	updated := make([]*blk.Block, 0, majority.Height)
+	updated = append(updated, blocks...)
+	node.Blockchain.Blocks = append(updated, majority.Blocks...)
+	node.persistBlockchain()
+	node.logger().Infof("caught up with %d blocks", len(majority.Blocks))
+	return true
+}
+
+/*
+Returns true if blocks follow the block with hash tip, at index height-1,
+each linking to the previous one by its hash, up to the block with hash last.
+*/
+func linksTo(tip []byte, height int, blocks []*blk.Block, last []byte) bool {
+	for i, block := range blocks {
+		if block.Index != height+i || !bytes.Equal(block.PrevBlockHash, tip) || !block.Validate() {
+			return false
+		}
+		tip = block.SelfHash
+	}
+	return bytes.Equal(tip, last)
+}
@@ -0,0 +1,120 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	blk "project/Block"
+	help "project/Helpers"
+)
+
+/*
+Governance: a governor changes the difficulty of the whole network at
+runtime, e.g. for an experiment, by sending a difficulty change signed with
+its wallet to any node, see blk.DifficultyChange. The node queues the change
+in its mempool like content and gossips it to its peers, each of which does
+the same if it is news to them, so whichever node mines next commits it.
+The blocks following the block committing it then declare the new
+difficulty, and nodes that join later derive it from their blockchain.
+*/
+
+const DIFFICULTY_CHANGE string = "/admin/difficulty"
+
+/*
+A difficulty change a node queued, as replied by /admin/difficulty.
+*/
+type DifficultyChangeStatus struct {
+	ID         string `json:"id"`         // Of the change, see blk.DifficultyChange.ID
+	ContentID  string `json:"content_id"` // Of the entry committing it, see /receipt/{content ID}
+	Difficulty int    `json:"difficulty"`
+}
+
+/*
+Send the change to every known peer, as this node's peer.
+*/
+func (node *Node) gossipDifficultyChange(change blk.DifficultyChange) {
+	jsonBytes, err := json.Marshal(change)
+	if help.Check(err) {
+		return
+	}
+
+	for _, port := range node.KnownPeers() {
+		if port == node.Port {
+			continue
+		}
+		req, err := http.NewRequest(http.MethodPost, help.NodeURL(port)+DIFFICULTY_CHANGE, bytes.NewBuffer(jsonBytes))
+		if help.Check(err) {
+			continue
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(PEER_PORT_HEADER, node.Port)
+		resp, err := help.HTTP_CLIENT.Do(req)
+		if err != nil {
+			node.logger().Warnf("could not send %s to %s", DIFFICULTY_CHANGE, port)
+			continue
+		}
+		help.CloseBody(resp)
+	}
+}
+
+/*
+Handle /admin/difficulty, a governor's or a peer's difficulty change: queue
+it to be mined and gossip it to the peers, then reply 202 Accepted with
+its IDs. Answers 403 Forbidden if it is not signed by one of the
+blk.GOVERNORS or sets a difficulty out of bounds, 409 Conflict if it is
+committed or queued already, and 503 Service Unavailable if the node has
+no mempool or is draining.
+*/
+func (node *Node) HandleDifficultyChange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var change blk.DifficultyChange
+	if help.Check(json.NewDecoder(r.Body).Decode(&change)) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !change.Verify() {
+		node.logger().Warnf("refused a difficulty change to %d not signed by a governor", change.Difficulty)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if node.Mempool == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	content := change.String()
+	status := DifficultyChangeStatus{ID: change.ID(), ContentID: blk.ContentID(content, "", 0), Difficulty: change.Difficulty}
+	if node.Replays(content, "", status.ContentID) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(status)
+		return
+	}
+	if !node.startMining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	mined, evicted := node.Mempool.Add(content, "", status.ContentID, 0)
+	if mined == nil {
+		node.doneMining()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(status)
+		return
+	}
+	if evicted {
+		node.doneMining()
+	}
+	node.logger().Infof("queued the change of the difficulty to %d by %s", change.Difficulty, change.Governor)
+	node.saveWork()
+	go node.mineMempool()
+	go node.gossipDifficultyChange(change)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(status)
+}
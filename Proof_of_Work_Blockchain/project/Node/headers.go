@@ -0,0 +1,180 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	blk "project/Block"
+	bc "project/Blockchain"
+	help "project/Helpers"
+	"strconv"
+	"sync"
+)
+
+const HEADERS string = "/headers"
+
+/* Number of block bodies fetched at once during a headers-first sync */
+const BODY_FETCHERS int = 4
+
+/*
+The headers of a node's blockchain from some index on, and its height,
+as returned by /headers.
+*/
+type HeaderChain struct {
+	Height  int          `json:"height"`
+	Headers []*blk.Block `json:"headers"`
+}
+
+/*
+Return the headers of this node's blockchain from index since on.
+*/
+func (node *Node) HeadersSince(since int) HeaderChain {
+	blocks := node.Blockchain.Blocks
+	chain := HeaderChain{Height: len(blocks), Headers: []*blk.Block{}}
+	if since >= 0 && since < len(blocks) {
+		chain.Headers = Headers(blocks[since:])
+	}
+	return chain
+}
+
+/*
+Handle /headers?since=N, since is optional and defaults to 0.
+*/
+func (node *Node) HandleHeaders(w http.ResponseWriter, r *http.Request) {
+	since := 0
+	if value := r.URL.Query().Get("since"); value != "" {
+		n, err := strconv.Atoi(value)
+		if help.Check(err) || n < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		since = n
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(node.HeadersSince(since))
+}
+
+/*
+Return the majority blockchain of the known ports, synced headers first:
+the headers leading to the tip a majority of peers agree on, see
+majorityTip, are fetched from a single peer of the majority, see
+getHeaders, and checked to link up by their hashes, to carry a valid Proof
+of Work and to keep this node's checkpoints, then the block bodies are
+fetched from the peers in parallel, see fillBlocks. Blocks this node holds
+already are kept instead of fetched, and a pruned node only fetches its
+last PruneDepth blocks.
+*/
+func (node *Node) getBlockchainHeadersFirst(known_ports []string) (bool, bc.Blockchain) {
+	tip, majority, ok := majorityTip(known_ports, node)
+	if !ok || tip.Height == 0 {
+		return false, bc.Blockchain{}
+	}
+
+	var headers []*blk.Block
+	for _, port := range majority {
+		if headers, ok = getHeaders(port, tip); ok {
+			break
+		}
+	}
+	if !ok || !linksTo(nil, 0, headers, tip.Tip) || !node.keepsCheckpoints(headers) {
+		node.logger().Warnf("refused invalid headers")
+		return false, bc.Blockchain{}
+	}
+
+	// Keep the blocks this node holds already, then fetch the others
+	blocks := append([]*blk.Block{}, headers...)
+	own := node.Blockchain.Blocks
+	for i := 0; i < len(own) && i < len(blocks); i++ {
+		if own[i].HeaderOnly || !matchesHeader(own[i], blocks[i]) {
+			break
+		}
+		blocks[i] = own[i]
+	}
+
+	from := 0
+	if node.PruneDepth > 0 && len(blocks) > node.PruneDepth {
+		from = len(blocks) - node.PruneDepth
+	}
+	full, ok := fillBlocks(blocks[from:], node.PeersByLatency(known_ports))
+	if !ok {
+		node.logger().Warnf("could not fetch the bodies of its headers")
+		return false, bc.Blockchain{}
+	}
+
+	node.logger().Infof("synced %d headers first", len(headers))
+	return true, bc.Blockchain{Blocks: append(blocks[:from:from], full...)}
+}
+
+/*
+Send /headers to the peer at port and return the headers of its blockchain
+up to the given tip, leaving out the blocks it accepted since it answered
+/tip. Returns false unless the headers lead to the tip.
+*/
+func getHeaders(port string, tip ChainTip) ([]*blk.Block, bool) {
+	resp, err := help.GetGzip(help.NodeURL(port) + HEADERS)
+	if help.Check(err) {
+		return nil, false
+	}
+	defer help.CloseBody(resp)
+
+	var chain HeaderChain
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&chain) != nil || len(chain.Headers) != chain.Height || chain.Height < tip.Height {
+		return nil, false
+	}
+	headers := chain.Headers[:tip.Height]
+	return headers, bytes.Equal(headers[len(headers)-1].SelfHash, tip.Tip)
+}
+
+/*
+Fetch the full blocks of the headers among blocks from the given peers and
+return blocks with the headers filled in. BODY_FETCHERS blocks are fetched
+at once, each from the peers in turn starting at a different one, so the
+load is spread over them. A fetched block must match its header.
+Returns false if a header could not be filled in.
+*/
+func fillBlocks(blocks []*blk.Block, ports []string) ([]*blk.Block, bool) {
+	full := append([]*blk.Block{}, blocks...)
+	missing := make(chan int, len(blocks))
+	for i, block := range blocks {
+		if block.HeaderOnly {
+			missing <- i
+		}
+	}
+	close(missing)
+	if len(missing) == 0 {
+		return blocks, true
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	filled := true
+	for w := 0; w < BODY_FETCHERS; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range missing {
+				found := false
+				for n := range ports {
+					port := ports[(i+n)%len(ports)]
+					if fetched, ok := GetBlockByIndex(port, blocks[i].Index); ok && matchesHeader(fetched, blocks[i]) {
+						full[i], found = fetched, true
+						break
+					}
+				}
+				if !found {
+					mu.Lock()
+					filled = false
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if !filled {
+		return nil, false
+	}
+	return full, true
+}
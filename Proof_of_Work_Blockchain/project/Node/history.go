@@ -0,0 +1,181 @@
+package node
+
+import (
+	"net/http"
+	blk "project/Block"
+	help "project/Helpers"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/* Served on /user/{address}/history */
+const (
+	USER    string = "/user"
+	HISTORY string = "/history"
+)
+
+var history_mutex sync.Mutex
+
+/*
+The blocks of a node's blockchain holding content of each user, by the
+address the content was sent from, see blk.Block.Authors. Blocks are
+applied as the blockchain grows, and all of them again when the node
+adopts another chain, so a user's history is looked up instead of the
+blockchain scanned for each request.
+*/
+type UserHistory struct {
+	chainState
+	blocks map[string][]int // Indexes of the blocks holding content of each address, in order
+}
+
+func NewUserHistory() *UserHistory {
+	return &UserHistory{blocks: map[string][]int{}}
+}
+
+/*
+Record the block under the authors of its entries, once for each author.
+*/
+func (history *UserHistory) applyBlock(block *blk.Block) {
+	recorded := map[string]bool{}
+	for _, author := range block.Authors {
+		if author != "" && !recorded[author] {
+			history.blocks[author] = append(history.blocks[author], block.Index)
+			recorded[author] = true
+		}
+	}
+}
+
+/*
+Return the user history of this node, created on first use.
+*/
+func (node *Node) history() *UserHistory {
+	history_mutex.Lock()
+	defer history_mutex.Unlock()
+
+	if node.History == nil {
+		node.History = NewUserHistory()
+	}
+	return node.History
+}
+
+/*
+Bring the user history of this node up to the given blocks of its blockchain and return it
+locked, the caller unlocks it. Returns false, with the history unlocked,
+if it could not be, see syncState.
+*/
+func (node *Node) syncHistory(blocks []*blk.Block) (*UserHistory, bool) {
+	history := node.history()
+	history.mu.Lock()
+
+	reset := func() { history.blocks = map[string][]int{} }
+	if !node.syncState(blocks, &history.chainState, reset, history.applyBlock) {
+		history.mu.Unlock()
+		return nil, false
+	}
+	return history, true
+}
+
+/*
+A block of a user's history: the block and the entries of it the user sent.
+*/
+type HistoryBlock struct {
+	Block   *blk.Block `json:"block"`
+	Entries []int      `json:"entries"`
+}
+
+/*
+A page of the blocks holding content of a user, as returned by
+/user/{address}/history?offset=N&limit=M, oldest first: the blocks from
+the Offset-th one of the user's on, out of Total.
+*/
+type HistoryPage struct {
+	Address string         `json:"address"`
+	Offset  int            `json:"offset"`
+	Total   int            `json:"total"`  // Blocks holding content of the user
+	Height  int            `json:"height"` // Number of blocks the history was derived from
+	Blocks  []HistoryBlock `json:"blocks"`
+}
+
+/*
+Return the page of at most limit blocks of this node's blockchain holding
+content of the address, from the offset-th one on. Returns false if the
+history could not be derived or the blocks of the page could not be
+fetched in full, see fullBlocks.
+*/
+func (node *Node) UserHistory(address string, offset int, limit int) (HistoryPage, bool) {
+	node.Acceptance_mu.Lock()
+	history, ok := node.syncHistory(node.Blockchain.Blocks)
+	if !ok {
+		node.Acceptance_mu.Unlock()
+		return HistoryPage{}, false
+	}
+	indexes := history.blocks[address]
+	if offset > len(indexes) {
+		offset = len(indexes)
+	}
+	page := HistoryPage{Address: address, Offset: offset, Total: len(indexes), Height: history.height, Blocks: []HistoryBlock{}}
+	if offset+limit < len(indexes) {
+		indexes = indexes[:offset+limit]
+	}
+	blocks := []*blk.Block{}
+	for _, index := range indexes[offset:] {
+		blocks = append(blocks, node.Blockchain.Blocks[index])
+	}
+	history.mu.Unlock()
+	node.Acceptance_mu.Unlock()
+
+	full, ok := node.fullBlocks(blocks)
+	if !ok {
+		return HistoryPage{}, false
+	}
+	for _, block := range full {
+		entries := []int{}
+		for i, author := range block.Authors {
+			if author == address {
+				entries = append(entries, i)
+			}
+		}
+		page.Blocks = append(page.Blocks, HistoryBlock{Block: block, Entries: entries})
+	}
+	return page, true
+}
+
+/*
+Handle /user/{address}/history: reply with the page of the blocks holding
+content the address sent, see HistoryPage. ?offset=N skips the first N of
+them, ?limit=M returns at most M, CHAIN_PAGE_SIZE by default and at most
+MAX_CHAIN_PAGE. Pruned blocks are fetched in full from an archive peer, or
+503 Service Unavailable is replied.
+*/
+func (node *Node) HandleUserHistory(w http.ResponseWriter, r *http.Request) {
+	address := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, USER+"/"), HISTORY)
+	if address == "" || strings.Contains(address, "/") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	offset, limit := 0, CHAIN_PAGE_SIZE
+	var err error
+	if query.Has("offset") {
+		offset, err = strconv.Atoi(query.Get("offset"))
+	}
+	if err == nil && query.Has("limit") {
+		limit, err = strconv.Atoi(query.Get("limit"))
+	}
+	if help.Check(err) || offset < 0 || limit <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if limit > MAX_CHAIN_PAGE {
+		limit = MAX_CHAIN_PAGE
+	}
+
+	page, ok := node.UserHistory(address, offset, limit)
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	help.WriteJSON(w, r, page)
+}
@@ -0,0 +1,168 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	help "project/Helpers"
+	"sync"
+	"time"
+)
+
+const PING string = "/ping"
+const EVICT string = "/evict"
+
+/* Health checks a peer may miss in a row before it is voted out */
+const MISSED_PINGS_THRESHOLD int = 3
+
+/* Time a peer has to answer a /ping */
+const PING_TIMEOUT time.Duration = 2 * time.Second
+
+/* How often a node pings its peers */
+var liveness_check_time time.Duration = 1000 * time.Millisecond
+
+var liveness_mutex sync.Mutex
+
+/*
+Send /ping to the node at port and return true if it answered in time.
+*/
+func Ping(port string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), PING_TIMEOUT)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", help.NodeURL(port)+PING, nil)
+	if help.Check(err) {
+		return false
+	}
+	resp, err := help.HTTP_CLIENT.Do(req)
+	if err != nil {
+		return false
+	}
+	defer help.CloseBody(resp)
+	return resp.StatusCode == http.StatusOK
+}
+
+/*
+Record whether the peer at port answered its last /ping and return the
+number of pings it missed in a row.
+*/
+func (node *Node) recordPing(port string, answered bool) int {
+	liveness_mutex.Lock()
+	defer liveness_mutex.Unlock()
+
+	if node.MissedPings == nil {
+		node.MissedPings = map[string]int{}
+	}
+	if answered {
+		delete(node.MissedPings, port)
+		return 0
+	}
+	node.MissedPings[port]++
+	return node.MissedPings[port]
+}
+
+/*
+Return true if this node considers the peer at port dead: it missed
+MISSED_PINGS_THRESHOLD pings in a row, or does not answer one now.
+*/
+func (node *Node) suspects(port string) bool {
+	liveness_mutex.Lock()
+	missed := node.MissedPings[port]
+	liveness_mutex.Unlock()
+
+	return missed >= MISSED_PINGS_THRESHOLD || node.recordPing(port, Ping(port)) > 0
+}
+
+/*
+Ping every peer once. A peer that missed MISSED_PINGS_THRESHOLD pings in a
+row is put to an eviction vote, see Evict.
+*/
+func (node *Node) CheckLiveness() {
+	for _, port := range node.KnownPeers() {
+		if port == node.Port {
+			continue
+		}
+		if node.recordPing(port, Ping(port)) >= MISSED_PINGS_THRESHOLD {
+			node.Evict(port)
+		}
+	}
+}
+
+/*
+Ask the other peers to vote on evicting the node at port, which this node
+considers dead or banned. If more than half of the remaining nodes, this one included,
+agree, the node is removed from the network with /leave, announced on its
+behalf, so it no longer counts towards the majorities of AcceptBlock and the
+other votes. Returns true if the node was evicted.
+*/
+func (node *Node) Evict(port string) bool {
+	jsonBytes, err := json.Marshal(PeerAnnouncement{Port: port})
+	if help.Check(err) {
+		return false
+	}
+
+	remaining := []string{}
+	for _, peer := range node.KnownPeers() {
+		if peer != port {
+			remaining = append(remaining, peer)
+		}
+	}
+
+	votes := 1 // This node's
+	for _, peer := range remaining {
+		if peer == node.Port {
+			continue
+		}
+		resp, err := help.HTTP_CLIENT.Post(help.NodeURL(peer)+EVICT, "application/json", bytes.NewBuffer(jsonBytes))
+		if err != nil {
+			continue // Possibly dead too, it gets its own vote
+		}
+		if resp.StatusCode == http.StatusOK {
+			votes++
+		}
+		help.CloseBody(resp)
+	}
+
+	// A strict majority, so a single node cannot evict its peers from a small network
+	if votes*2 <= len(remaining) {
+		node.logger().Warnf("could not evict %s, only %d of %d nodes agree", port, votes, len(remaining))
+		return false
+	}
+
+	node.logger().Infof("evicted %s with %d of %d votes", port, votes, len(remaining))
+	if node.Peers.Remove(port) {
+		node.recordPing(port, true) // Forget its missed pings, in case it joins again
+		go node.gossip(LEAVE, PeerAnnouncement{Port: port})
+	}
+	return true
+}
+
+/*
+Handle /evict, a peer's vote on evicting a node: agree with 200 OK if this
+node considers it dead too, or banned it, see strike, otherwise refuse with
+409 Conflict.
+*/
+func (node *Node) HandleEvict(w http.ResponseWriter, r *http.Request) {
+	var announcement PeerAnnouncement
+	if help.Check(json.NewDecoder(r.Body).Decode(&announcement)) || announcement.Port == "" {
+		node.strike(sender(r), STRIKE_MALFORMED)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if announcement.Port == node.Port || !(node.IsBanned(announcement.Port) || node.suspects(announcement.Port)) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+/*
+Periodically ping this node's peers, until it shuts down.
+*/
+func (node *Node) MonitorLiveness() {
+	for node.sleep(liveness_check_time) {
+		node.CheckLiveness()
+	}
+}
@@ -0,0 +1,327 @@
+package node
+
+import (
+	"bytes"
+	blk "project/Block"
+	st "project/Store"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/* Number of pending content a node's mempool holds */
+const MEMPOOL_SIZE int = 100
+
+/*
+The content a node received and has yet to mine. Everything pending is
+bundled into the next block, highest fee first and in the order it arrived
+among equal fees, up to the size of a block. Once the mempool is full,
+content paying more than the lowest pending fee takes its place. Content
+already pending or being mined is not queued twice, even sent again later,
+see blk.SubmissionHash.
+
+The mempool also tracks the block its worker mines, so a node stores all it
+has yet to mine, see Work, and resumes mining once restarted, see ResumeWork.
+*/
+type Mempool struct {
+	mu      sync.Mutex
+	pending []*pendingContent
+	queued  map[string]bool // Hashes of the content pending or being mined
+	mining  bool            // True while a worker mines the pending content
+	paused  bool            // True while mining is paused, see pause
+	job     *st.MiningJob   // The block the worker mines
+	resumed *st.MiningJob   // The block mined before the node restarted, see resume
+}
+
+/* Content in a mempool, the user who sent it, its ID, the fee it pays, and where to report whether it was mined */
+type pendingContent struct {
+	content string
+	author  string
+	id      string
+	fee     uint64
+	mined   chan bool
+}
+
+/* Return the hash the content is queued under, see blk.SubmissionHash */
+func (entry *pendingContent) hash() string {
+	return blk.SubmissionHash(entry.content, entry.author)
+}
+
+func NewMempool() *Mempool {
+	return &Mempool{queued: map[string]bool{}}
+}
+
+/*
+Queue content sent by the user with the author address to be mined, under
+the given content ID, paying fee to the miner. Returns a channel receiving
+whether the content was mined into an accepted block, or nil if the content
+is already queued or the mempool is full of content paying as much. Also
+returns true if the content took the place of pending content, which
+receives false and whose mining the caller ends, see doneMining.
+*/
+func (pool *Mempool) Add(content string, author string, id string, fee uint64) (chan bool, bool) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	hash := blk.SubmissionHash(content, author)
+	if pool.queued[hash] {
+		return nil, false
+	}
+	evicted := false
+	if len(pool.pending) >= MEMPOOL_SIZE {
+		lowest := pool.pending[len(pool.pending)-1]
+		if lowest.fee >= fee {
+			return nil, false
+		}
+		pool.pending = pool.pending[:len(pool.pending)-1]
+		delete(pool.queued, lowest.hash())
+		lowest.mined <- false
+		evicted = true
+	}
+
+	// After the content paying as much, so equal fees keep their order
+	at := sort.Search(len(pool.pending), func(i int) bool { return pool.pending[i].fee < fee })
+	entry := &pendingContent{content: content, author: author, id: id, fee: fee, mined: make(chan bool, 1)}
+	pool.pending = append(pool.pending, nil)
+	copy(pool.pending[at+1:], pool.pending[at:])
+	pool.pending[at] = entry
+	pool.queued[hash] = true
+	return entry.mined, evicted
+}
+
+/*
+Return the number of content waiting to be mined.
+*/
+func (pool *Mempool) Len() int {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	return len(pool.pending)
+}
+
+/*
+Return the pending content, in the order it is mined.
+*/
+func (pool *Mempool) Pending() []string {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	pending := []string{}
+	for _, entry := range pool.pending {
+		pending = append(pending, entry.content)
+	}
+	return pending
+}
+
+/*
+Become the mempool's worker. Returns false if it already has one.
+*/
+func (pool *Mempool) claim() bool {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if pool.mining {
+		return false
+	}
+	pool.mining = true
+	return true
+}
+
+/*
+Take the pending content, highest fee first, as much as fits in a block of
+blk.MAX_BLOCK_SIZE bytes, the rest waits for the next block. When there is
+none, or mining is paused, the worker stops and false is returned.
+*/
+func (pool *Mempool) next() ([]*pendingContent, bool) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if len(pool.pending) == 0 || pool.paused {
+		pool.mining = false
+		return nil, false
+	}
+	// Content is at most blk.MAX_CONTENT_SIZE, so the first always fits
+	n, size := 1, len(pool.pending[0].content)
+	for ; n < len(pool.pending); n++ {
+		size += len(pool.pending[n].content)
+		if size > blk.MAX_BLOCK_SIZE {
+			break
+		}
+	}
+	batch := pool.pending[:n:n]
+	pool.pending = pool.pending[n:]
+	pool.job = &st.MiningJob{Content: stored(batch)}
+	return batch, true
+}
+
+/*
+Pause or resume mining: while paused, the worker stops once done with the
+block it mines, and content is queued but not mined. Returns false if
+mining already was paused, or running.
+*/
+func (pool *Mempool) pause(paused bool) bool {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if pool.paused == paused {
+		return false
+	}
+	pool.paused = paused
+	return true
+}
+
+/* Returns true while mining is paused */
+func (pool *Mempool) Paused() bool {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	return pool.paused
+}
+
+/*
+Record the block the worker seals for the content it took. If, but for its
+timestamp, it is the block mined before the node restarted, the block takes
+that block's timestamp and the nonces it got to are returned, so its Proof
+of Work resumes where it stopped. Returns nil otherwise.
+*/
+func (pool *Mempool) sealing(block *blk.Block) []int {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	var progress []int
+	if pool.resumed != nil && pool.resumed.Block != nil {
+		timestamp := block.Timestamp
+		block.Timestamp = pool.resumed.Block.Timestamp
+		if bytes.Equal(blk.NewProofOfWork(block).MergeBlockNonce(0), blk.NewProofOfWork(pool.resumed.Block).MergeBlockNonce(0)) {
+			progress = pool.resumed.Progress
+		} else {
+			block.Timestamp = timestamp
+		}
+	}
+	pool.resumed = nil
+
+	if pool.job != nil {
+		sealed := *block
+		pool.job.Block, pool.job.Progress = &sealed, progress
+	}
+	return progress
+}
+
+/*
+Record the nonces the worker got to on the block it seals.
+*/
+func (pool *Mempool) checkpoint(progress []int) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if pool.job != nil && pool.job.Block != nil {
+		pool.job.Progress = progress
+	}
+}
+
+/*
+Forget the block the worker mined, once its content is done.
+*/
+func (pool *Mempool) finish() {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.job = nil
+}
+
+/*
+Resume the mining of the block of job, mined before the node restarted,
+once the worker seals it again, see sealing. Its content is queued again
+by the caller.
+*/
+func (pool *Mempool) resume(job *st.MiningJob) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.resumed = job
+}
+
+/*
+Return what is left to mine: the pending content, in the order it is mined,
+and the block the worker mines, if any.
+*/
+func (pool *Mempool) Work() st.Work {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	work := st.Work{Pending: stored(pool.pending)}
+	if pool.job != nil {
+		job := *pool.job
+		work.Job = &job
+	}
+	return work
+}
+
+/* Return the content as stored in a work store */
+func stored(entries []*pendingContent) []st.PendingContent {
+	content := []st.PendingContent{}
+	for _, entry := range entries {
+		content = append(content, st.PendingContent{Content: entry.content, Author: entry.author, ID: entry.id, Fee: entry.fee})
+	}
+	return content
+}
+
+/*
+Forget content once it is mined, so it may be queued again if it did not
+make it to the blockchain.
+*/
+func (pool *Mempool) done(entry *pendingContent) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	delete(pool.queued, entry.hash())
+}
+
+/*
+Mine the content in this node's mempool until it is empty, unless another
+worker already does. Each block bundles all the content pending when its
+mining starts, and pays this node the fees of its content. Content interrupted by a peer's block is not retried, its
+user resubmits it if it never lands on the blockchain. Content left when the node crashes is mined once it restarts, see ResumeWork.
+*/
+func (node *Node) mineMempool() {
+	if !node.Mempool.claim() {
+		return
+	}
+
+	for {
+		batch, ok := node.Mempool.next()
+		if !ok {
+			node.saveWork()
+			return
+		}
+		node.saveWork() // The batch is stored as the mined block's content
+
+		// Content replaying the blockchain is not mined, nor are transfers and transactions
+		// the blockchain and the content before them leave unaffordable
+		batch, replayed := node.fresh(batch)
+		batch, unaffordable := node.affordable(batch)
+		for _, entry := range append(replayed, unaffordable...) {
+			node.logger().Warnf("dropped replayed or unaffordable content{ %s }", entry.content)
+			node.Mempool.done(entry)
+			node.doneMining()
+			entry.mined <- false
+		}
+		if len(batch) == 0 {
+			continue
+		}
+
+		contents, authors, ids, fees := []string{}, []string{}, []string{}, []uint64{}
+		for _, entry := range batch {
+			contents = append(contents, entry.content)
+			authors = append(authors, entry.author)
+			ids = append(ids, entry.id)
+			fees = append(fees, entry.fee)
+		}
+
+		mined := node.MineContent(contents, authors, ids, fees)
+		if !mined {
+			node.logger().Warnf("could not mine content{ %s }", strings.Join(contents, " | "))
+		}
+		for _, entry := range batch {
+			node.Mempool.done(entry)
+			node.doneMining()
+			entry.mined <- mined
+		}
+		node.Mempool.finish()
+	}
+}
@@ -0,0 +1,63 @@
+package node
+
+import (
+	"net/http"
+	help "project/Helpers"
+	"sync"
+)
+
+const METRICS string = "/metrics"
+
+/* Upper bounds of the mining duration buckets, in seconds. Blocks are retargeted to take about 2s. */
+var MINING_DURATION_BUCKETS = []float64{0.1, 0.25, 0.5, 1, 2, 4, 8, 16, 32, 64}
+
+var metrics_mutex sync.Mutex
+
+/*
+The metrics of a node, served on /metrics for Prometheus to scrape.
+*/
+type Metrics struct {
+	BlocksMined        help.Counter
+	MiningDuration     *help.Histogram // Seconds taken to mine each block mined
+	ValidationRequests help.Counter    // Blocks received on /validate
+	Conflicts          help.Counter    // Validated blocks that conflicted with others
+	Bans               help.Counter    // Peers banned for misbehaving, see strike
+	Interruptions      help.Counter    // Blocks whose mining a peer's block interrupted
+
+	mined []MinedBlock // The last MINING_STATS_WINDOW blocks mined, see MiningStats
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{MiningDuration: help.NewHistogram(MINING_DURATION_BUCKETS)}
+}
+
+/*
+Return the metrics of this node, created on first use.
+*/
+func (node *Node) metrics() *Metrics {
+	metrics_mutex.Lock()
+	defer metrics_mutex.Unlock()
+
+	if node.Metrics == nil {
+		node.Metrics = NewMetrics()
+	}
+	return node.Metrics
+}
+
+/*
+Handle /metrics, reply with this node's metrics in the Prometheus text format.
+The chain height is read when scraped.
+*/
+func (node *Node) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	metrics := node.metrics()
+
+	w.Header().Set("Content-Type", help.METRICS_CONTENT_TYPE)
+	w.WriteHeader(http.StatusOK)
+	help.WriteCounter(w, "blockchain_blocks_mined_total", "Blocks this node mined.", &metrics.BlocksMined)
+	help.WriteHistogram(w, "blockchain_mining_duration_seconds", "Time this node took to mine a block.", metrics.MiningDuration)
+	help.WriteCounter(w, "blockchain_validation_requests_total", "Blocks peers sent this node to validate.", &metrics.ValidationRequests)
+	help.WriteCounter(w, "blockchain_conflicts_total", "Validated blocks that conflicted with other blocks of the same height.", &metrics.Conflicts)
+	help.WriteCounter(w, "blockchain_peers_banned_total", "Peers this node banned for misbehaving.", &metrics.Bans)
+	help.WriteCounter(w, "blockchain_mining_interruptions_total", "Blocks whose mining a peer's block interrupted.", &metrics.Interruptions)
+	help.WriteGauge(w, "blockchain_chain_height", "Number of blocks on this node's blockchain.", float64(len(node.Blockchain.Blocks)))
+}
@@ -1,13 +1,14 @@
 package node
 
 import (
-	"fmt"
 	blk "project/Block"
+	cs "project/Consensus"
+	help "project/Helpers"
 	"time"
 )
 
 /*
-Mine content into a block with a PoW,
+Mine content entries, sent by the users with the authors addresses under the given content IDs and paying fees, into a block with a PoW,
 then accept the block once majority of peers accept it.
 
 The mining can get interrupted by a block sent by a peer.
@@ -22,17 +23,25 @@ requests.
 Before mining and a node should update its blockchain to
 the most recent version.
 */
-func (node *Node) MineContent(content string) bool {
+func (node *Node) MineContent(contents []string, authors []string, ids []string, fees []uint64) bool {
+	// Mining is paused in safe mode, the chain may be the wrong one
+	if node.InSafeMode() {
+		node.logger().Warnf("is in safe mode and will not mine")
+		return false
+	}
+
 	// node.Acceptance_mu.Lock()
 	// Update blockchain before mining
 	node.UpdateBlockchain()
 	// node.Acceptance_mu.Unlock()
 
 	// Get the previous block
-	prevBlock := node.Blockchain.Blocks[len(node.Blockchain.Blocks)-1]
+	blocks := node.chain()
+	prevBlock := blocks[len(blocks)-1]
 
 	// Get the new block (this process is interruptible)
-	success, newBlock := node.MineNewBlock(content, prevBlock.SelfHash, prevBlock.Index)
+	difficulty := blk.NextDifficulty(blocks)
+	success, newBlock := node.MineNewBlock(contents, authors, ids, fees, prevBlock.SelfHash, prevBlock.Index, difficulty)
 	if !success {
 		// Could not mine new block
 		// Either due to interruption or errors while mining
@@ -51,12 +60,13 @@ func (node *Node) MineContent(content string) bool {
 		if !success {
 			/*
 				Update blockchain if acceptance is denied by majority. Means our blockchain might be
-				outdated.
+				outdated. The blocks the peers sent back were appended already, so only
+				the blocks after them, if any, are fetched.
 			*/
 			node.UpdateBlockchain()
 		}
 	} else {
-		fmt.Printf("%s got interrupted by valid block\n", node.Port)
+		node.logger().Warnf("got interrupted by valid block")
 		// node.Acceptance_mu.Unlock() // Unlock before exiting
 		return false
 	}
@@ -65,35 +75,84 @@ func (node *Node) MineContent(content string) bool {
 }
 
 /*
-Create and return a new block.
+Create and return a new block holding the given content entries, their authors, content IDs and fees, mined at the given difficulty,
+or sealed as the node's consensus engine requires, see engine. The block's coinbase and fees are paid to node.Address,
+a node without one mines for free.
 */
-func (node *Node) MineNewBlock(data string, prevBlockHash []byte, prevIndex int) (bool, *blk.Block) {
+func (node *Node) MineNewBlock(data []string, authors []string, ids []string, fees []uint64, prevBlockHash []byte, prevIndex int, difficulty int) (bool, *blk.Block) {
+	// A block lists fees only if its miner collects some
+	var total uint64
+	for _, fee := range fees {
+		total += fee
+	}
+	if total == 0 || node.Address == "" {
+		fees = nil
+	}
+
+	// A node with an address rewards itself with the block's coinbase, its first entry
+	if node.Address != "" {
+		coinbase := blk.NewCoinbase(node.Address, prevIndex+1).String()
+		data = append([]string{coinbase}, data...)
+		if authors != nil {
+			authors = append([]string{""}, authors...)
+		}
+		if ids != nil {
+			ids = append([]string{blk.ContentID(coinbase, "", 0)}, ids...)
+		}
+		if fees != nil {
+			fees = append([]uint64{0}, fees...)
+		}
+	}
+
+	entries := [][]byte{}
+	for _, content := range data {
+		entries = append(entries, []byte(content))
+	}
+
 	// Create a new block using given data, prevBlockHash and the current time.
 	// 		Initialize the block's SelfHash as an empty array of bytes
 	block := &blk.Block{
 		PrevBlockHash: prevBlockHash,
 		Index:         prevIndex + 1, Timestamp: time.Now().UnixNano(),
-		Content:  []byte(data),
-		Nonce:    0,
-		SelfHash: []byte{}}
-
-	// Create a new PoW object using the recently created block.
-	pow := blk.NewProofOfWork(block)
-
-	// Run proof of work
-	// Returns a hash proof and corresponding nonce.
-	nonce, hash := node.RunPoW(*pow)
-
-	// If nonce == -1, then the Run function was unsuccessful
-	// Due to interruptions or errors
-	if nonce == -1 {
+		Entries:    entries,
+		MerkleRoot: blk.MerkleRoot(entries),
+		Authors:    authors,
+		ContentIDs: ids,
+		Fees:       fees,
+		Difficulty: difficulty,
+		NetworkID:  help.NETWORK_ID,
+		Nonce:      0,
+		SelfHash:   []byte{}}
+	block.Miner = node.Address
+
+	// The consensus engine decides whether this node may seal the block,
+	// e.g. under Proof of Stake only the proposer selected for it may
+	engine := node.engine()
+	if !engine.Prepare(node.Blockchain.Blocks, block, node.Port, node.KnownPeers()) {
+		node.logger().Warnf("may not seal block %d", block.Index)
 		return false, nil
 	}
 
-	/* Set the block's PoW hash and corresponding nonce*/
-	block.SelfHash = hash[:]
-	block.Nonce = nonce
+	// Seal the block, e.g. run its proof of work.
+	// Sealing fails once interrupted by a peer's block.
+	// The proof of work is stored as it goes, and resumed if the node crashed mining the same block.
+	start := time.Now()
+	sealed, resumed := false, false
+	if resumable, ok := engine.(cs.Resumable); ok && node.Mempool != nil {
+		progress := node.Mempool.sealing(block)
+		node.saveWork()
+		resumed = progress != nil
+		sealed = resumable.Resume(block, progress, node.interrupted, node.checkpointWork)
+	} else {
+		sealed = engine.Seal(block, node.interrupted)
+	}
+	if !sealed {
+		node.metrics().Interruptions.Inc()
+		return false, nil
+	}
 
-	fmt.Printf("%s successfully mined block{ %s }\n", node.Port, block.Content)
+	node.recordMined(block, time.Since(start), resumed)
+	node.logger().Debugf("sealed block %d in %s", block.Index, time.Since(start))
+	node.logger().Infof("successfully mined block{ %s }", block.ContentString())
 	return true, block
 }
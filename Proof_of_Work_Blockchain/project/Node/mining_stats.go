@@ -0,0 +1,114 @@
+package node
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	blk "project/Block"
+	"time"
+)
+
+/*
+Mining telemetry: a node records how long it took to mine each of its last
+blocks, and how often a peer's block interrupted its mining, to choose the
+difficulty of a network, e.g. the node's --difficulty, from measurements.
+The hash rate is estimated from the work a block's difficulty takes on
+average, 2^difficulty hashes, over the time it took to mine it.
+*/
+
+const MINING_STATS string = "/admin/mining/stats"
+
+/* Number of blocks mined the telemetry is computed over */
+const MINING_STATS_WINDOW int = 100
+
+/*
+A block this node mined, as recorded by recordMined.
+*/
+type MinedBlock struct {
+	Index      int           `json:"index"`
+	Difficulty int           `json:"difficulty"`
+	Duration   time.Duration `json:"duration_ns"`
+	HashRate   float64       `json:"hash_rate"` // Estimated hashes per second, 0 when not mined with a Proof of Work
+	Resumed    bool          `json:"resumed"`   // Mining resumed after a restart, so Duration only covers part of the work
+}
+
+/*
+The mining telemetry of a node, as replied by /admin/mining/stats, over its
+last MINING_STATS_WINDOW blocks mined.
+*/
+type MiningStats struct {
+	BlocksMined         uint64        `json:"blocks_mined"`         // Since the node started
+	Interruptions       uint64        `json:"interruptions"`        // Blocks whose mining a peer's block interrupted since the node started
+	AverageDuration     time.Duration `json:"average_duration_ns"`  // Average time taken to mine a block
+	HashRate            float64       `json:"hash_rate"`            // Estimated hashes per second
+	Difficulty          int           `json:"difficulty"`           // Difficulty of the last block mined
+	SuggestedDifficulty int           `json:"suggested_difficulty"` // Difficulty taking about blk.TARGET_BLOCK_TIME at HashRate, 0 without one
+	Recent              []MinedBlock  `json:"recent"`               // Oldest first
+}
+
+/*
+Record a block this node mined in the given time, resumed or not.
+*/
+func (node *Node) recordMined(block *blk.Block, elapsed time.Duration, resumed bool) {
+	metrics := node.metrics()
+	metrics.BlocksMined.Inc()
+	metrics.MiningDuration.Observe(elapsed.Seconds())
+
+	mined := MinedBlock{Index: block.Index, Difficulty: block.Difficulty, Duration: elapsed, Resumed: resumed}
+	if block.Proposer == "" && elapsed > 0 {
+		mined.HashRate = math.Exp2(float64(block.Difficulty)) / elapsed.Seconds()
+	}
+
+	metrics_mutex.Lock()
+	defer metrics_mutex.Unlock()
+	metrics.mined = append(metrics.mined, mined)
+	if len(metrics.mined) > MINING_STATS_WINDOW {
+		metrics.mined = metrics.mined[len(metrics.mined)-MINING_STATS_WINDOW:]
+	}
+}
+
+/*
+Return this node's mining telemetry. The hash rate is estimated over the
+blocks mined with a Proof of Work from scratch, the suggested difficulty is
+the one whose average work takes blk.TARGET_BLOCK_TIME at that hash rate,
+within blk.MIN_DIFFICULTY and blk.MAX_DIFFICULTY.
+*/
+func (node *Node) MiningStats() MiningStats {
+	metrics := node.metrics()
+	stats := MiningStats{BlocksMined: metrics.BlocksMined.Value(), Interruptions: metrics.Interruptions.Value()}
+
+	metrics_mutex.Lock()
+	stats.Recent = append([]MinedBlock{}, metrics.mined...)
+	metrics_mutex.Unlock()
+	if len(stats.Recent) == 0 {
+		return stats
+	}
+
+	var total time.Duration
+	var work, worked float64 // Hashes, and seconds taken to compute them
+	for _, mined := range stats.Recent {
+		total += mined.Duration
+		if mined.HashRate > 0 && !mined.Resumed {
+			work += math.Exp2(float64(mined.Difficulty))
+			worked += mined.Duration.Seconds()
+		}
+	}
+	stats.AverageDuration = total / time.Duration(len(stats.Recent))
+	stats.Difficulty = stats.Recent[len(stats.Recent)-1].Difficulty
+
+	if worked > 0 {
+		stats.HashRate = work / worked
+		suggested := int(math.Round(math.Log2(stats.HashRate * blk.TARGET_BLOCK_TIME.Seconds())))
+		stats.SuggestedDifficulty = int(math.Max(float64(blk.MIN_DIFFICULTY), math.Min(float64(blk.MAX_DIFFICULTY), float64(suggested))))
+	}
+	return stats
+}
+
+/*
+Handle /admin/mining/stats, reply with this node's mining telemetry.
+*/
+func (node *Node) HandleMiningStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(node.MiningStats())
+}
@@ -0,0 +1,124 @@
+package node
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	blk "project/Block"
+	"sync"
+	"time"
+)
+
+/*
+Misbehavior tracking: a node counts strikes against the peers that send it
+blocks that are invalid in themselves, payloads it cannot decode, or that
+time out on its calls. A peer reaching ban_threshold strikes is banned:
+its traffic is refused, and its eviction proposed to the other peers, see
+Evict. Blocks refused only for being stale or on another branch earn no
+strike, since honest peers send those when they lose a race.
+
+Limitation: a peer is known by the port it sends in PEER_PORT_HEADER, a
+caller without it by its host, and without mutual TLS a caller can claim
+any port.
+*/
+
+/* Header a node's calls to its peers carry its port in, so they know who misbehaved */
+const PEER_PORT_HEADER string = "X-Peer-Port"
+
+/* Why a peer earned a strike */
+const (
+	STRIKE_INVALID_BLOCK string = "invalid block"
+	STRIKE_MALFORMED     string = "malformed payload"
+	STRIKE_TIMEOUT       string = "timeout"
+)
+
+/* Strikes after which a peer is banned, 0 never bans */
+var ban_threshold int = 5
+
+var misbehavior_mutex sync.Mutex
+
+/*
+Return who sent the request: the port in its PEER_PORT_HEADER, or else
+the host it came from.
+*/
+func sender(r *http.Request) string {
+	if port := r.Header.Get(PEER_PORT_HEADER); port != "" {
+		return port
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+/*
+Count a strike of the given reason against peer, and ban it once it has
+ban_threshold of them. Returns true if this strike got the peer banned.
+*/
+func (node *Node) strike(peer string, reason string) bool {
+	if peer == "" || peer == node.Port {
+		return false
+	}
+
+	misbehavior_mutex.Lock()
+	if node.Strikes == nil {
+		node.Strikes = map[string]int{}
+	}
+	node.Strikes[peer]++
+	strikes := node.Strikes[peer]
+	banned := ban_threshold > 0 && strikes >= ban_threshold && !node.Banned[peer]
+	if banned {
+		if node.Banned == nil {
+			node.Banned = map[string]bool{}
+		}
+		node.Banned[peer] = true
+	}
+	misbehavior_mutex.Unlock()
+
+	node.logger().Warnf("struck %s for a %s, %d strikes", peer, reason, strikes)
+	if banned {
+		node.logger().Warnf("banned %s after %d strikes", peer, strikes)
+		node.metrics().Bans.Inc()
+		if node.Peers != nil && node.Peers.Contains(peer) {
+			go node.Evict(peer)
+		}
+	}
+	return banned
+}
+
+/* Returns true if this node banned peer, see strike */
+func (node *Node) IsBanned(peer string) bool {
+	misbehavior_mutex.Lock()
+	defer misbehavior_mutex.Unlock()
+	return node.Banned[peer]
+}
+
+/* Return the strikes this node counted against peer */
+func (node *Node) StrikesOf(peer string) int {
+	misbehavior_mutex.Lock()
+	defer misbehavior_mutex.Unlock()
+	return node.Strikes[peer]
+}
+
+/*
+Strike peer if it timed out on a call that failed with err.
+*/
+func (node *Node) strikeTimeout(peer string, err error) {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		node.strike(peer, STRIKE_TIMEOUT)
+	}
+}
+
+/*
+Returns true if the block is invalid whatever blockchain it follows: it
+does not carry its own hash, fails its own checks, see blk.Block.Validate,
+or is timestamped too far in the future.
+*/
+func invalidInItself(block *blk.Block) bool {
+	return !bytes.Equal(block.SelfHash, block.Hash()) ||
+		!block.Validate() ||
+		block.Timestamp > time.Now().Add(blk.MAX_FUTURE_DRIFT).UnixNano()
+}
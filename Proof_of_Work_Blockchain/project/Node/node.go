@@ -1,44 +1,51 @@
 /*
   # Limitations & Improvements Suggestions:
 
-	- The system can only move forward if a majority of Nodes are active. Otherwise, no consensus can be achieved,
-	and no new nodes can register. To improve this, Nodes that do not answer to some threshold number of API calls should be voted to
-	be removed from the list, and once a majority of its peers agree, then the Node should be removed.
+	- The system can only move forward if a majority of Nodes are active. Nodes ping each other, and a Node missing
+	MISSED_PINGS_THRESHOLD pings in a row is voted out of the network once a majority of its peers agree, see Evict.
+	Until then, no consensus can be achieved, and no new nodes can register.
 
-	- Our blockchain can only accept content from one user at a time. Nodes can be modified to bundle data together over time.
+	- Nodes bundle all the content in their mempool into one block, but only mine one block at a time. Content arriving while a block is
+	mined waits for the next block.
 */
 
 package node
 
 import (
 	"encoding/json"
-	"fmt"
 	"net"
 	"net/http"
-	"os"
 	blk "project/Block"
 	bc "project/Blockchain"
+	cs "project/Consensus"
 	help "project/Helpers"
+	st "project/Store"
 	usr "project/User"
+	wlt "project/Wallet"
+	"strings"
 	"sync"
 	"time"
 )
 
-/* Output files for logs */
-var OUT os.File
 var wait10_time time.Duration = 10 * time.Millisecond
 
-var NODE_LIST string
+var SEED string // Port of the node new nodes join the network through
 var USER_LIST string
 
 const PROTOCOL string = "tcp"
-const LOCALHOST string = "http://localhost:"
 const LOCALHOST_IP string = "127.0.0.1:"
 
 const NEW_CHAIN string = "/new_chain"
 const COPY_CHAIN string = "/copy_chain"
 const CONTENT string = "/content"
 const VALIDATE string = "/validate"
+const STATUS string = "/status"
+const RECEIPT string = "/receipt"
+const PROOF string = "/proof"
+const DRAIN string = "/drain"
+
+/* Calls only peers make, refused from callers without a certificate under mutual TLS */
+var PEER_ONLY = map[string]bool{NEW_CHAIN: true, VALIDATE: true, JOIN: true, LEAVE: true, EVICT: true, DRAIN: true, PAUSE_MINING: true, RESUME_MINING: true, MINING_STATS: true}
 
 /*
 A Node is referenced to by its port and holds a copy of the blockchain.
@@ -55,42 +62,196 @@ type Node struct {
 	Listener   net.Listener
 	Running    bool
 
-	Validated []blk.Block
+	// The blockchain on disk, reloaded when the node restarts
+	Store *st.BlockStore
+
+	// The content this node has yet to mine on disk, mined when the node restarts
+	Work *st.WorkStore
+
+	// Blocks of peers validated and waiting to be accepted, see BlockQueue
+	Arrivals *BlockQueue
+
+	// Valid blocks off the blockchain, switched to when their branch is heavier
+	Branches *Branches
+
+	// Finalized blocks the blockchain never rolls back
+	Checkpoints *Checkpoints
+
+	// Pruning: only the last PruneDepth blocks are kept in full, older ones as
+	// headers fetched in full from archive peers when asked for. 0 keeps all blocks.
+	PruneDepth int
 
 	Acceptance_mu *sync.Mutex
+
+	// Round-trip times measured to each peer, keyed by port
+	Latencies map[string]*PeerLatency
+
+	// Pings each peer missed in a row, keyed by port, see CheckLiveness
+	MissedPings map[string]int
+
+	// Strikes counted against each peer, and the peers banned for them, see strike
+	Strikes map[string]int
+	Banned  map[string]bool
+
+	// Safe mode: the node diverged from its peers and does not mine until reconciled
+	Diverged         bool
+	DivergenceAlarms int // Number of times the node diverged
+
+	// Content received over /content, waiting to be mined
+	Mempool *Mempool
+
+	// Number of goroutines mining a block, each over its own range of nonces,
+	// when Consensus is nil. 0 mines on a single goroutine, see pow.Engine.
+	Miners int
+
+	// Maintenance: a draining node finishes its mining, then shuts down
+	Draining bool
+	Mining   int // Number of /content queued or being mined
+
+	// The nodes on the network, learned through /join and /leave
+	Peers *PeerSet
+
+	// Serves the listener, closing it also closes connections kept alive by peers
+	server *http.Server
+
+	// Closed by Shutdown, waking up the goroutines watching the node's peers,
+	// which Shutdown then waits for, see StartListening
+	stopped  chan struct{}
+	monitors *sync.WaitGroup
+
+	// Closed when the blockchain changes, see BlocksSince
+	blockEvents chan struct{}
+
+	// Callbacks waiting for their content to be committed, see AddCallback
+	callbacks []*ContentCallback
+
+	// Where this node logs, see logger. Nil logs to help.LOG. Set when the
+	// node registers or starts, before its goroutines do, and not changed afterwards.
+	Log *help.Logger
+
+	// Served on /metrics, see HandleMetrics
+	Metrics *Metrics
+
+	// How this node seals and verifies blocks, e.g. pos.Engine for Proof of
+	// Stake. Nil mines blocks with a Proof of Work on Miners goroutines.
+	Consensus cs.Consensus
+
+	// The balance of each address, derived from the transfers on the blockchain
+	Balances *Balances
+
+	// The outputs of the transactions on the blockchain that are not spent yet
+	UTXOs *UTXOSet
+
+	// The content, transfer and transaction IDs on the blockchain, never committed twice
+	Committed *CommittedIDs
+
+	// The blocks holding content of each user, served on /user/{address}/history
+	History *UserHistory
+
+	// Wallet address the rewards and fees of the blocks this node mines are paid to.
+	// A node without one mines content for free.
+	Address string
+
+	// Faults injected into the messages this node serves, see help.LeakyHandler.
+	// Nil serves every message as it comes.
+	Faults *help.Faults
 }
 
 /*
-This function creates an http listener for both users and peers.
+Return the logger of this node, which prefixes its lines with the node's port.
 */
-func (node *Node) StartListening(out os.File) {
+func (node *Node) logger() *help.Logger {
+	return node.Log.With("node " + node.Port)
+}
 
-	OUT = out
+/*
+Return the blocks of this node's blockchain as they are at once, read under
+Acceptance_mu. Blocks are only ever appended to a new slice or replaced by
+another one, so the blocks returned stay as they are. The caller must not
+hold Acceptance_mu.
+*/
+func (node *Node) chain() []*blk.Block {
+	node.Acceptance_mu.Lock()
+	defer node.Acceptance_mu.Unlock()
+	return node.Blockchain.Blocks
+}
+
+/*
+This function creates an http listener for both users and peers. The node
+logs to log once it opened its listener, see listen: the logger is set
+before any of the node's goroutines starts, never while they may log.
+*/
+func (node *Node) StartListening(log *help.Logger) {
+	/* Otherwise, start the service. */
+
+	NODE_ADDRESS := LOCALHOST_IP + node.Port
+
+	// Registration opens the listener before the node joins the network
+	if node.Listener == nil && !node.listen(log) {
+		return
+	}
+	listener := node.Listener
+
+	// Watch for this node's chain diverging from its peers', and for dead peers
+	node.monitors.Add(2)
+	go func() {
+		defer node.monitors.Done()
+		node.MonitorDivergence()
+	}()
+	go func() {
+		defer node.monitors.Done()
+		node.MonitorLiveness()
+	}()
+
+	// Serve returns an error once the listener is closed by Shutdown
+	err := node.server.Serve(listener)
+	if node.IsRunning() && help.Check(err) {
+		node.logger().Errorf("Error Serving HTTP on CLT PORT")
+	}
+
+	node.logger().Infof("Client Interface has started on %v", NODE_ADDRESS)
+}
+
+/*
+Open this node's listener and log to log, or help.LOG if nil. Requests
+wait on the listener until the node serves them.
+*/
+func (node *Node) listen(log *help.Logger) bool {
+	if log != nil {
+		node.Log = log
+	}
 
 	// Declare a new mutex variable
 	var myMutex sync.Mutex
 
 	node.Acceptance_mu = &myMutex
+	node.Mempool = NewMempool()
+	node.Branches = NewBranches()
+	node.Checkpoints = NewCheckpoints()
+	node.Checkpoints.Record(node.Blockchain.Blocks) // A restarted node trusts its stored blocks
+	if node.Peers == nil {
+		node.Peers = NewPeerSet(node.Port)
+	}
 
-	/* Otherwise, start the service. */
-
-	NODE_ADDRESS := LOCALHOST_IP + node.Port
-
-	listener, err := net.Listen(PROTOCOL, NODE_ADDRESS)
+	listener, err := net.Listen(PROTOCOL, LOCALHOST_IP+node.Port)
 	if help.Check(err) {
-		return
+		return false
 	}
+	listener = help.ListenTLS(listener)
 
 	/* Wrapper Function to Handle HTTP Requests */
 	handler := func(w http.ResponseWriter, r *http.Request) {
 		node.HandleRequests(w, r)
 	}
 
-	if help.Check(http.Serve(listener, http.HandlerFunc(handler))) {
-		fmt.Fprintf(&OUT, "%s Error Serving HTTP on CLT PORT", node.Port)
-	}
-
-	fmt.Fprintf(&OUT, "Client Interface has started on %v", NODE_ADDRESS)
+	drain_mutex.Lock()
+	node.Listener = listener
+	node.server = &http.Server{Handler: help.LeakyHandler(http.HandlerFunc(handler), node.Faults)}
+	node.Running = true
+	node.stopped = make(chan struct{})
+	node.monitors = &sync.WaitGroup{}
+	drain_mutex.Unlock()
+	return true
 }
 
 /*
@@ -99,125 +260,517 @@ function will be called to handle the request made by either a user or
 a peer.
 */
 func (node *Node) HandleRequests(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintf(&OUT, "\n---------------%s Received %v command from %v---------------\n", node.Port, r.RequestURI, r.RemoteAddr)
+	// A health check from a peer, replied to before logging since peers ping every second
+	if r.URL.Path == PING {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	node.logger().Debugf("received %v command from %v", r.RequestURI, r.RemoteAddr)
+
+	// A request for the API versions this node speaks, answered to any caller
+	if r.URL.Path == help.API_VERSION_PATH {
+		node.HandleAPIVersion(w, r)
+		return
+	}
+
+	// A node's handshake before peering, answered to any caller so it learns why it is refused
+	if r.URL.Path == help.HANDSHAKE_PATH {
+		node.HandleHandshake(w, r)
+		return
+	}
+
+	// Requests from nodes speaking an incompatible API version are refused
+	if !node.checkAPIVersion(w, r) {
+		return
+	}
+
+	// And so are requests from other networks
+	if !node.checkNetwork(w, r) {
+		return
+	}
+
+	// And so is the traffic of banned peers
+	if node.IsBanned(sender(r)) {
+		node.logger().Debugf("refused %v from banned %v", r.URL.Path, sender(r))
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	// Under mutual TLS, calls only peers make must come with a peer's certificate,
+	// so other processes cannot spoof blocks or chains
+	if PEER_ONLY[r.URL.Path] && !help.AuthenticatedPeer(r) {
+		node.logger().Warnf("refused %v from %v without a peer certificate", r.URL.Path, r.RemoteAddr)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
 
 	// A new chain was created by the 4th node
 	// Handle this by accepting it.
 	if r.RequestURI == NEW_CHAIN {
-		if len(node.Blockchain.Blocks) == 0 {
+		if len(node.chain()) == 0 {
 			/* Decode the blockchain object from the json request */
 			var blockchain bc.Blockchain
 			err := json.NewDecoder(r.Body).Decode(&blockchain)
 			if help.Check(err) {
-				fmt.Fprintln(&OUT, "ERROR: Could not decode JSON")
+				node.logger().Errorf("could not decode JSON")
+				node.strike(sender(r), STRIKE_MALFORMED)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if !WithinSizeLimits(blockchain.Blocks) {
+				node.logger().Warnf("refused a new blockchain with oversized blocks")
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				return
+			}
+			if !node.verifies(blockchain) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
 			}
 
 			/*
 				Limitation: Before accepting blockchain, we should verify that the 5th node is
 				the one that sent this chain.
 			*/
+			// The blockchain may have been created meanwhile, e.g. caught up with from a peer
+			node.Acceptance_mu.Lock()
+			if len(node.Blockchain.Blocks) != 0 {
+				node.Acceptance_mu.Unlock()
+				return
+			}
 			node.Blockchain = blockchain
+			node.persistBlockchain()
+			node.Acceptance_mu.Unlock()
 
-			fmt.Fprintln(&OUT, "New blockchain accepted!")
+			node.logger().Infof("New blockchain accepted!")
 		}
 
 		return
 	}
 
+	// A scrape of this node's metrics, e.g. by Prometheus
+	if r.URL.Path == METRICS {
+		node.HandleMetrics(w, r)
+		return
+	}
+
+	// A request for the height and last block hash of this node's blockchain,
+	// asked by nodes and users looking for the majority blockchain.
+	if r.URL.Path == TIP {
+		node.HandleTip(w, r)
+		return
+	}
+
 	// A request for a copy of the currently committed blockchain,
-	// Reply back with this node's copy of a committed blockchain.
-	if r.RequestURI == COPY_CHAIN {
-		blockchain := node.Blockchain
+	// Reply back with this node's copy of a committed blockchain,
+	// or with the headers of its blocks for /copy_chain?headers=true,
+	// or with a page of it for /copy_chain?offset=N&limit=M.
+	if r.URL.Path == COPY_CHAIN {
+		node.HandleCopyChain(w, r)
+		return
+	}
+
+	// A request for the peers this node knows of, or a node
+	// announcing it joined or left the network.
+	if r.RequestURI == PEERS || r.RequestURI == JOIN || r.RequestURI == LEAVE {
+		node.HandlePeers(w, r)
+		return
+	}
+
+	// A peer's call to vote on evicting a node it considers dead,
+	// reply whether this node considers it dead too.
+	if r.URL.Path == EVICT {
+		node.HandleEvict(w, r)
+		return
+	}
+
+	// A request for the blocks after some index, from a peer catching up,
+	// reply with them and the height and tip they lead to.
+	if r.URL.Path == BLOCKS_SINCE {
+		node.HandleBlocksSince(w, r)
+		return
+	}
+
+	// A request for the blocks accepted since some index,
+	// reply once there are some or the request waited long enough.
+	if r.URL.Path == BLOCK_EVENTS {
+		node.HandleBlockEvents(w, r)
+		return
+	}
+
+	// A request for the headers of the blocks after some index,
+	// from a node syncing headers first.
+	if r.URL.Path == HEADERS {
+		node.HandleHeaders(w, r)
+		return
+	}
+
+	// A dashboard or user following the blockchain,
+	// push the new blocks over a WebSocket as they are accepted.
+	if r.URL.Path == SUBSCRIBE {
+		node.HandleSubscribe(w, r)
+		return
+	}
+
+	// A request for a single block, by its index or its hash,
+	// reply with the block if this node's blockchain has it.
+	if r.URL.Path == BLOCK {
+		node.HandleBlock(w, r)
+		return
+	}
+
+	// A request for the latest block buried deep enough to be irreversible
+	if r.URL.Path == FINALIZED {
+		node.HandleFinalized(w, r)
+		return
+	}
 
+	// A request for the state of this node,
+	// reply with its chain height and the latencies measured to its peers.
+	// With ?content_id=, reply with the confirmation of that content instead.
+	if r.URL.Path == STATUS {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(blockchain)
+		if contentID := r.URL.Query().Get("content_id"); contentID != "" {
+			json.NewEncoder(w).Encode(node.FindContentStatus(contentID))
+			return
+		}
+		json.NewEncoder(w).Encode(node.Status())
+		return
+	}
+
+	// A request for the receipt of content,
+	// reply with the block holding it if it is on the blockchain.
+	if r.RequestURI == RECEIPT {
+		var request ReceiptRequest
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if help.Check(err) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(node.FindReceipt(request))
+		return
+	}
+
+	// A request for the confirmation of content by its content ID,
+	// reply with the block holding it and its confirmations.
+	if strings.HasPrefix(r.URL.Path, RECEIPT+"/") {
+		node.HandleReceiptByID(w, r)
+		return
+	}
+
+	// A request for the balance of an address,
+	// reply with the balance the transfers on the blockchain leave it.
+	if strings.HasPrefix(r.URL.Path, BALANCE+"/") {
+		node.HandleBalance(w, r)
+		return
+	}
+
+	// A request for the unspent outputs paying to an address,
+	// reply with the outputs the transactions on the blockchain left it.
+	if strings.HasPrefix(r.URL.Path, UTXOS+"/") {
+		node.HandleUTXOs(w, r)
+		return
+	}
+
+	// A request for the blocks holding content of a user, a page at a time
+	if strings.HasPrefix(r.URL.Path, USER+"/") && strings.HasSuffix(r.URL.Path, HISTORY) {
+		node.HandleUserHistory(w, r)
+		return
+	}
+
+	// A request for the inclusion proof of content in a block, by its content ID
+	if r.URL.Path == PROOF && r.Method == http.MethodGet {
+		node.HandleContentProof(w, r)
+		return
+	}
+
+	// A request for the inclusion proof of content, from a light client,
+	// reply with the Merkle proof of the entry holding it if it is on the blockchain.
+	if r.RequestURI == PROOF {
+		var request ReceiptRequest
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if help.Check(err) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(node.ProveInclusion(request))
+		return
+	}
+
+	// An operator asking how fast this node mines, to tune the difficulty
+	if r.URL.Path == MINING_STATS {
+		node.HandleMiningStats(w, r)
+		return
+	}
+
+	// An operator pausing or resuming this node's mining,
+	// reply whether the node mines now.
+	if r.URL.Path == PAUSE_MINING || r.URL.Path == RESUME_MINING {
+		node.HandleMiningControl(w, r)
+		return
+	}
+
+	// A governor's difficulty change, or a peer gossiping it,
+	// reply with its IDs once it is queued to be mined.
+	if r.URL.Path == DIFFICULTY_CHANGE {
+		node.HandleDifficultyChange(w, r)
+		return
+	}
+
+	// A request to drain this node for maintenance,
+	// reply once draining started, the node shuts down later.
+	if r.RequestURI == DRAIN {
+		request := DrainRequest{}
+		if r.ContentLength != 0 && help.Check(json.NewDecoder(r.Body).Decode(&request)) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// Users need a non-trivial number of nodes to send content,
+		// so register a replacement before draining a node
+		if len(node.KnownPeers())-1 <= bc.NON_TRIVIAL {
+			node.logger().Warnf("cannot drain, too few nodes would be left")
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+
+		grace := drain_grace_time
+		if request.GraceMs > 0 {
+			grace = time.Duration(request.GraceMs) * time.Millisecond
+		}
+
+		if !node.Drain(grace) {
+			w.WriteHeader(http.StatusConflict) // Already draining
+			return
+		}
+		w.WriteHeader(http.StatusOK)
 		return
 	}
 
 	// A request for content to be mined and accepted on the blockchain.
 	// User's must be registered to get their content accepted.
 	if r.RequestURI == CONTENT {
+		// A draining node does not take new content
+		if !node.startMining() {
+			node.logger().Warnf("is draining and refused content")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
 		/* Unmarshal the content */
 		var content usr.Content
 		err := json.NewDecoder(r.Body).Decode(&content) // Decode the request's body
 		help.Check(err)
 
-		// Check if user is registered
-		if content.User.IsUserRegistered() {
-			// Only mine content coming from registered users.
-			node.MineContent(content.Content)
+		// Larger content is stored off-chain by its user, see usr.OFFCHAIN_SIZE
+		if len(content.Content) > blk.MAX_CONTENT_SIZE {
+			node.logger().Warnf("rejected content of %d bytes, over %d", len(content.Content), blk.MAX_CONTENT_SIZE)
+			node.doneMining()
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		// Check if user is registered, users are identified by their address,
+		// and that the content was signed with the user's registered key
+		user, registered := usr.FindUser(USER_LIST, content.User.Address)
+		if !registered || !wlt.Verify(user.PublicKey, usr.SignedMessage(content.Content, content.Fee), content.Signature) {
+			node.logger().Warnf("rejected content that is not signed by a registered user")
+			node.doneMining()
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		// Users that did not renew their registration in time must register again
+		if user.Expired(time.Now()) {
+			node.logger().Warnf("rejected content of a user whose registration expired")
+			node.doneMining()
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		// Only miners reward themselves
+		if strings.HasPrefix(content.Content, blk.COINBASE_PREFIX) {
+			node.logger().Warnf("rejected a coinbase sent as content")
+			node.doneMining()
+			w.WriteHeader(http.StatusBadRequest)
+			return
 		}
+
+		// A transfer must be signed by its sender
+		if transfer, isTransfer := blk.ParseTransfer([]byte(content.Content)); isTransfer && !transfer.Verify() {
+			node.logger().Warnf("rejected a transfer that is not signed by its sender")
+			node.doneMining()
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		// Content pays at least the fee its size requires, or no peer validates the block holding it
+		if required := blk.RequiredFee(content.Content); content.Fee < required {
+			node.logger().Warnf("rejected content paying %d, under the fee of %d its size requires", content.Fee, required)
+			node.doneMining()
+			w.WriteHeader(http.StatusPaymentRequired)
+			return
+		} else if required > 0 && node.Address == "" {
+			node.logger().Warnf("rejected content requiring a fee, it has no address to collect fees to")
+			node.doneMining()
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		// The fee of the content, and its transfer if it is one, must be affordable
+		if !node.Affords(content.Content, content.User.Address, content.Fee) {
+			node.logger().Warnf("rejected content whose fee or transfer is over the balance of its payer")
+			node.doneMining()
+			w.WriteHeader(http.StatusPaymentRequired)
+			return
+		}
+
+		// A transaction must be signed by the owners of the outputs it spends, which must be unspent
+		if tx, isTransaction := blk.ParseTransaction([]byte(content.Content)); isTransaction {
+			if !tx.Verify() {
+				node.logger().Warnf("rejected a transaction that is not signed")
+				node.doneMining()
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			if !node.Spendable(tx) {
+				node.logger().Warnf("rejected transaction %s spending outputs it cannot", tx.ID())
+				node.doneMining()
+				w.WriteHeader(http.StatusPaymentRequired)
+				return
+			}
+		}
+
+		if content.Callback != "" && !ValidCallback(content.Callback) {
+			node.logger().Warnf("rejected content with callback %s", content.Callback)
+			node.doneMining()
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// When a single node may seal the next block, e.g. under Proof of Stake,
+		// only that leader takes content
+		if leader, led := node.leader(); led && leader != node.Port {
+			node.doneMining()
+			node.relayContent(w, r, content, leader)
+			return
+		}
+
+		// Users send the time of their content, so every node agrees on its ID
+		timestamp := content.Timestamp
+		if timestamp == 0 {
+			timestamp = time.Now().UnixNano()
+		}
+		contentID := blk.ContentID(content.Content, content.User.Address, timestamp)
+
+		// Content, transfers and transactions are committed once, replays are refused.
+		// Content its user sends again is answered with the confirmation of the content committed.
+		if node.Replays(content.Content, content.User.Address, contentID) {
+			node.logger().Warnf("rejected content{ %s } replaying the blockchain", content.Content)
+			node.doneMining()
+			status, sent := node.FindSentContentStatus(content.Content, content.User.Address)
+			if !sent {
+				status = node.FindContentStatus(contentID)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(status)
+			return
+		}
+
+		// Only mine content coming from registered users.
+		// Queue it, content arriving while the node mines is mined next, highest fee first.
+		mined, evicted := node.Mempool.Add(content.Content, content.User.Address, contentID, content.Fee)
+		if mined == nil {
+			node.logger().Warnf("already queued content{ %s } or its mempool is full", content.Content)
+			node.doneMining()
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		if evicted {
+			node.logger().Infof("evicted the content paying the lowest fee from its full mempool")
+			node.doneMining()
+		}
+		if content.Callback != "" {
+			node.AddCallback(contentID, content.Callback)
+		}
+		node.saveWork()
+		go node.mineMempool()
+
+		// Respond once the content is mined, or its mining was interrupted,
+		// with the confirmation of the content
+		code := http.StatusOK
+		if !<-mined {
+			code = http.StatusConflict
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(node.FindContentStatus(contentID))
+		return
 	}
 
 	// When a block is sent for validation,
-	// validate it and accept it if it is valid.
-	// Otherwise remove it from the validated list.
+	// validate it and accept it if it is valid
+	// and heads the heaviest branch of the blocks arrived with it.
 	if r.RequestURI == VALIDATE {
 		/* Unmarshal the block */
 		var block blk.Block
 		err := json.NewDecoder(r.Body).Decode(&block) // Decode the request's body
-		help.Check(err)
+		if help.Check(err) {
+			node.strike(sender(r), STRIKE_MALFORMED)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if invalidInItself(&block) {
+			node.strike(sender(r), STRIKE_INVALID_BLOCK)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
 
-		fmt.Fprintf(&OUT, "block{ %s } received for validation\n", block.Content)
+		node.logger().Debugf("block{ %s } received for validation", block.ContentString())
+		node.metrics().ValidationRequests.Inc()
 
 		// Check if block is fully valid
 		if node.ValidateBlock(block, 0) {
-			// Add block to the list of validated blocks (this stops mining)
-			node.Validated = append(node.Validated, block)
-
-			/* This sleep call is purely for demonstration purposes.
-
-			We are essentially waiting for nodes to create potential conflicts
-			more often. Recall that conflicts are blocks that are validated (i.e. added
-			to the node's array of validated blocks) during the same period of time.
-			Although rare, conflicts can occur frequently at scale.
-			To demonstrate this, we add the short sleep for the node.Validated array
-			to fill up, thereby forcing	conflicts.
-			Conflicts will still occur randomly, but the longer the wait time the more
-			likely they will occur.
-			*/
-			time.Sleep(wait10_time)
-
-			// Acceptance is non-interruptible
-			// node.Acceptance_mu.Lock()
-
-			// If there are conflicting blocks then this will be false
-			conflict := len(node.Validated) > 1
-
-			// If there are no conflicting blocks
-			if !conflict {
-				// Accept the block; no conflicts
+			// Queue the block as arrived (this stops mining) until this handler is done with it
+			arrivals := node.arrivals()
+			arrival := arrivals.Push(block)
+			defer arrivals.Done(arrival)
+
+			// Settle the blocks that arrived together: conflicts
+			// are blocks validated during the same period of time
+			blocks := node.settle(arrival)
+
+			// Accept the block heading the heaviest branch, the earliest on a tie
+			if arrival.Won() {
+				if blocks > 1 {
+					node.logger().Warnf("Conflict detected!!!")
+					node.metrics().Conflicts.Inc()
+				}
 				node.acceptValidatedBlock(w, block)
-				// node.Acceptance_mu.Unlock() // unlock
-
-				// Reset the node's list of validated
-				node.Validated = []blk.Block{}
-
-				fmt.Fprintf(&OUT, "Node %s validated and accepted Block{ %s }\n", node.Port, block.Content)
+				node.logger().Infof("validated and accepted Block{ %s }", block.ContentString())
 				return
-			} else { // There exists at least one conflict
-				fmt.Fprintln(&OUT, "Conflict detected!!!")
-
-				// This process is ran as part of pre-acceptance, so
-				// should be thread safe as it accesses the node's Validated array
-				// node.Acceptance_mu.Lock()
-				// Check if the blocks are tied, before validating.
-				if node.ValidatedBlocksTied() {
-					// Accept earliest block
-					node.acceptValidatedBlock(w, node.Validated[0])
-				} else {
-					// Get the block with the longest index
-					block = node.FindLongestBranch()
-					node.acceptValidatedBlock(w, block)
-				}
 			}
-			// node.Acceptance_mu.Unlock() // unlock
 
+			// Keep this block in case its branch grows heavier later on,
+			// once the block it lost to is accepted
+			<-arrival.round.accepted
+			if !node.considerBranch(block) {
+				node.rejectBlock(w, block)
+			}
+		} else if node.considerBranch(block) {
+			// The block heads a branch heavier than this node's blockchain
+			node.logger().Infof("accepted Block{ %s } on a heavier branch", block.ContentString())
 		} else {
-			fmt.Fprintf(&OUT, "Node %s could not validate Block{ %s }\n", node.Port, block.Content)
-			// respond with 403
-			w.WriteHeader(403)
+			// respond with 403, telling the sender what it is missing
+			node.rejectBlock(w, block)
 		}
 	}
 }
@@ -225,25 +778,33 @@ func (node *Node) HandleRequests(w http.ResponseWriter, r *http.Request) {
 /*
 Accept the given block. Only check if the block was validated for its index.
 
-If the block's index is invalid, then do not accept and respond with a 403.
+If the block's index is invalid, then do not accept and respond with a 403
+and the rejection of the block, see Rejection.
 If block's index is greater than the blockchain's last index + 1, then block was
 sent to be validated with a skipped index, then node should update blockchain.
 */
 func (node *Node) acceptValidatedBlock(w http.ResponseWriter, block blk.Block) {
 	node.Acceptance_mu.Lock()
 	// Check the index is still valid on the block
-	if !node.ValidateBlock(block, 0) {
-		fmt.Fprintf(&OUT, "Node %s could not validate Block{ %s }\n", node.Port, block.Content)
-		// respond with 403
-		w.WriteHeader(403)
+	if !node.validateBlock(node.Blockchain.Blocks, block, 0) {
+		node.Acceptance_mu.Unlock()
+
+		// The block may still head a branch heavier than this node's blockchain
+		if node.considerBranch(block) {
+			return
+		}
+		// respond with 403, telling the sender what it is missing
+		node.rejectBlock(w, block)
 		return
 	}
 
 	// Check no missing blocks.
-	if block.Index == len(node.Blockchain.Blocks) {
+	height := len(node.Blockchain.Blocks)
+	if block.Index == height {
 		// Accept block
 		// node.Acceptance_mu.Lock()
 		node.Blockchain.Blocks = append(node.Blockchain.Blocks, &block)
+		node.persistBlockchain()
 	}
 	node.Acceptance_mu.Unlock()
 
@@ -258,47 +819,8 @@ func (node *Node) acceptValidatedBlock(w http.ResponseWriter, block blk.Block) {
 
 	// If block's index is greater than the blockchain's last index + 1
 	// Then the node knows it has skipped a block so it should update its blockchain
-	if block.Index > len(node.Blockchain.Blocks) {
+	if block.Index > height {
 		node.UpdateBlockchain()
 	}
 
 }
-
-/*
-Check if this node's validated blocks are tied. If so, return true, else
-return false.
-*/
-func (node *Node) ValidatedBlocksTied() bool {
-
-	idx := node.Validated[0].Index
-
-	/* Iterate over each block */
-	for _, block := range node.Validated {
-		if idx != block.Index {
-			// Not a tied list of validated blocks
-			return false
-		}
-	}
-
-	// List of validated blocks is indeed tied.
-	return true
-}
-
-/*
-Get the block with the highest index in the node's list of validated blocks.
-*/
-func (node *Node) FindLongestBranch() blk.Block {
-	longestIdx := 0 // Initialize longest index
-	blockIdx := 0   // Initialize index of block in the validated list
-
-	/* Iterate over list of validated blocks */
-	for idx, block := range node.Validated {
-		if block.Index > longestIdx {
-			longestIdx = block.Index // update longest Index field
-			blockIdx = idx           // update block index with longest Index
-		}
-	}
-
-	// Return the block with the longest index field.
-	return node.Validated[blockIdx]
-}
@@ -0,0 +1,57 @@
+package node
+
+/*
+The state of a node as reported by /status.
+*/
+type NodeStatus struct {
+	Port             string        `json:"port"`
+	Height           int           `json:"height"`            // Number of blocks in the node's blockchain
+	TipHash          string        `json:"tip_hash"`          // Hash of the last block, hex encoded
+	Checkpoint       *Checkpoint   `json:"checkpoint"`        // Last checkpoint recorded, null before the first
+	Diverged         bool          `json:"diverged"`          // The node is in safe mode and does not mine
+	DivergenceAlarms int           `json:"divergence_alarms"` // Number of times the node diverged
+	Draining         bool          `json:"draining"`          // The node refuses /content and will shut down
+	Pending          int           `json:"pending"`           // Content in the mempool waiting to be mined
+	MiningPaused     bool          `json:"mining_paused"`     // Mining is paused, see PauseMining
+	PruneDepth       int           `json:"prune_depth"`       // Blocks kept in full, 0 for an archive node
+	Peers            []PeerLatency `json:"peers"`             // Round-trip times measured to peers
+}
+
+/*
+Return the current state of this node.
+*/
+func (node *Node) Status() NodeStatus {
+	divergence_mutex.Lock()
+	diverged, alarms := node.Diverged, node.DivergenceAlarms
+	divergence_mutex.Unlock()
+
+	var last *Checkpoint
+	if checkpoint, found := node.Checkpoints.Last(); found {
+		last = &checkpoint
+	}
+
+	blocks := node.chain()
+	return NodeStatus{
+		Port:             node.Port,
+		Height:           len(blocks),
+		TipHash:          tipHash(blocks),
+		Checkpoint:       last,
+		Diverged:         diverged,
+		DivergenceAlarms: alarms,
+		Draining:         node.IsDraining(),
+		Pending:          node.pending(),
+		MiningPaused:     node.MiningPaused(),
+		PruneDepth:       node.PruneDepth,
+		Peers:            node.PeerLatencies(),
+	}
+}
+
+/*
+Return the number of content in this node's mempool, 0 before it listens.
+*/
+func (node *Node) pending() int {
+	if node.Mempool == nil {
+		return 0
+	}
+	return node.Mempool.Len()
+}
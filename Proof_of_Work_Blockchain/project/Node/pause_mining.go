@@ -0,0 +1,81 @@
+package node
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const PAUSE_MINING string = "/admin/mining/pause"
+const RESUME_MINING string = "/admin/mining/resume"
+
+/*
+Whether a node mines, as replied by /admin/mining/pause and /admin/mining/resume.
+*/
+type MiningState struct {
+	Paused  bool `json:"paused"`
+	Pending int  `json:"pending"` // Content in the mempool waiting to be mined
+}
+
+/*
+Pause mining, e.g. to quiesce this node for maintenance without shutting it
+down: the block being mined is finished, then content received is queued
+in the mempool but not mined until ResumeMining. The node keeps validating
+its peers' blocks. Returns false if mining already was paused, or the node
+has no mempool, i.e. it is not listening.
+*/
+func (node *Node) PauseMining() bool {
+	if node.Mempool == nil || !node.Mempool.pause(true) {
+		return false
+	}
+	node.logger().Infof("paused mining with %d content pending", node.Mempool.Len())
+	return true
+}
+
+/*
+Resume mining after PauseMining, starting with the content queued while
+paused. Returns false if mining was not paused.
+*/
+func (node *Node) ResumeMining() bool {
+	if node.Mempool == nil || !node.Mempool.pause(false) {
+		return false
+	}
+	node.logger().Infof("resumed mining with %d content pending", node.Mempool.Len())
+	go node.mineMempool()
+	return true
+}
+
+/* Returns true while mining is paused, see PauseMining */
+func (node *Node) MiningPaused() bool {
+	return node.Mempool != nil && node.Mempool.Paused()
+}
+
+/*
+Handle /admin/mining/pause and /admin/mining/resume, reply with whether the
+node mines now. Answers 409 Conflict if mining already was paused, or
+running, and 503 Service Unavailable if the node has no mempool.
+*/
+func (node *Node) HandleMiningControl(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if node.Mempool == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	changed := false
+	if r.URL.Path == PAUSE_MINING {
+		changed = node.PauseMining()
+	} else {
+		changed = node.ResumeMining()
+	}
+
+	code := http.StatusOK
+	if !changed {
+		code = http.StatusConflict
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(MiningState{Paused: node.MiningPaused(), Pending: node.pending()})
+}
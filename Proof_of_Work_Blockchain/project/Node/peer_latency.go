@@ -0,0 +1,106 @@
+package node
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+var latency_mutex sync.Mutex
+
+/* Weight of the newest round-trip time in a peer's average */
+const LATENCY_WEIGHT float64 = 0.2
+
+/*
+Round-trip times measured to a peer from /validate and /copy_chain calls.
+*/
+type PeerLatency struct {
+	Port     string  `json:"port"`
+	LastMs   float64 `json:"last_ms"`  // Most recent round-trip time
+	AvgMs    float64 `json:"avg_ms"`   // Moving average of the round-trip times
+	Samples  int     `json:"samples"`  // Number of successful calls measured
+	Failures int     `json:"failures"` // Failed calls since the last successful one
+}
+
+/*
+Record the round-trip time of a call to a peer, or that the call failed.
+*/
+func (node *Node) RecordLatency(port string, rtt time.Duration, ok bool) {
+	latency_mutex.Lock()
+	defer latency_mutex.Unlock()
+
+	if node.Latencies == nil {
+		node.Latencies = map[string]*PeerLatency{}
+	}
+
+	peer, found := node.Latencies[port]
+	if !found {
+		peer = &PeerLatency{Port: port}
+		node.Latencies[port] = peer
+	}
+
+	if !ok {
+		peer.Failures++
+		return
+	}
+
+	ms := float64(rtt.Microseconds()) / 1000
+	peer.LastMs = ms
+	if peer.Samples == 0 {
+		peer.AvgMs = ms
+	} else {
+		peer.AvgMs = LATENCY_WEIGHT*ms + (1-LATENCY_WEIGHT)*peer.AvgMs
+	}
+	peer.Samples++
+	peer.Failures = 0
+}
+
+/*
+Return the given ports ordered from the lowest to the highest average
+round-trip time. Peers that were never measured come first, so they get
+measured, and peers whose last calls failed come last.
+*/
+func (node *Node) PeersByLatency(ports []string) []string {
+	latency_mutex.Lock()
+	defer latency_mutex.Unlock()
+
+	ordered := append([]string{}, ports...)
+
+	rank := func(port string) (int, float64) {
+		peer, found := node.Latencies[port]
+		if !found || peer.Samples == 0 && peer.Failures == 0 {
+			return 0, 0
+		}
+		if peer.Failures > 0 {
+			return 2, float64(peer.Failures)
+		}
+		return 1, peer.AvgMs
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		group_i, ms_i := rank(ordered[i])
+		group_j, ms_j := rank(ordered[j])
+		if group_i != group_j {
+			return group_i < group_j
+		}
+		return ms_i < ms_j
+	})
+
+	return ordered
+}
+
+/*
+Return a copy of the round-trip times measured to each peer, ordered by port.
+*/
+func (node *Node) PeerLatencies() []PeerLatency {
+	latency_mutex.Lock()
+	defer latency_mutex.Unlock()
+
+	latencies := []PeerLatency{}
+	for _, peer := range node.Latencies {
+		latencies = append(latencies, *peer)
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i].Port < latencies[j].Port })
+
+	return latencies
+}
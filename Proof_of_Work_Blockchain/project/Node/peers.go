@@ -0,0 +1,229 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	help "project/Helpers"
+	reg "project/Registry"
+	"sync"
+)
+
+const PEERS string = help.PEERS
+const JOIN string = "/join"
+const LEAVE string = "/leave"
+
+/*
+The ports of the nodes a node knows of, itself included.
+
+Nodes learn of each other through the peer-discovery protocol: a new node
+sends /join to a seed node, gets the seed's peer set back, and the seed
+gossips the newcomer to its peers. A node that shuts down gossips /leave
+the same way.
+*/
+type PeerSet struct {
+	mu    sync.Mutex
+	ports map[string]bool
+}
+
+/* A node announcing it joined or left the network */
+type PeerAnnouncement struct {
+	Port string `json:"port"`
+}
+
+func NewPeerSet(ports ...string) *PeerSet {
+	peers := &PeerSet{ports: map[string]bool{}}
+	for _, port := range ports {
+		peers.ports[port] = true
+	}
+	return peers
+}
+
+/*
+Add a port to the set. Returns false if it was already known.
+*/
+func (peers *PeerSet) Add(port string) bool {
+	peers.mu.Lock()
+	defer peers.mu.Unlock()
+
+	if peers.ports[port] {
+		return false
+	}
+	peers.ports[port] = true
+	return true
+}
+
+/*
+Remove a port from the set. Returns false if it was not known.
+*/
+func (peers *PeerSet) Remove(port string) bool {
+	peers.mu.Lock()
+	defer peers.mu.Unlock()
+
+	if !peers.ports[port] {
+		return false
+	}
+	delete(peers.ports, port)
+	return true
+}
+
+/*
+Returns true if the port is in the set.
+*/
+func (peers *PeerSet) Contains(port string) bool {
+	peers.mu.Lock()
+	defer peers.mu.Unlock()
+	return peers.ports[port]
+}
+
+/*
+Return the ports in the set, lowest first.
+*/
+func (peers *PeerSet) List() []string {
+	peers.mu.Lock()
+	defer peers.mu.Unlock()
+
+	ports := []string{}
+	for port := range peers.ports {
+		ports = append(ports, port)
+	}
+	help.SortPorts(ports)
+	return ports
+}
+
+/*
+Return the ports of the nodes on the network. A node that has not joined
+the network, e.g. one only used to send blocks, asks the seed node.
+*/
+func (node *Node) KnownPeers() []string {
+	if node.Peers == nil {
+		return help.GetPeers(SEED)
+	}
+	return node.Peers.List()
+}
+
+/*
+Join the network through the node at seed: shake hands with it, announce
+this node to it and learn the peers it knows of. Returns false if the seed
+did not answer, or speaks an incompatible version of the API or belongs to
+another network, see help.SendHandshake.
+*/
+func (node *Node) Join(seed string) bool {
+	if seed == node.Port {
+		return false // A node cannot join through itself
+	}
+	if !node.handshake(seed) {
+		return false
+	}
+
+	var peers help.PeerList
+	if !node.announce(seed, JOIN, PeerAnnouncement{Port: node.Port}, &peers) {
+		return false
+	}
+
+	for _, port := range peers.Peers {
+		node.Peers.Add(port)
+	}
+	return true
+}
+
+/*
+Shake hands with the node at port, see help.SendHandshake. Returns false if
+the two nodes cannot peer; a node that does not answer, e.g. one still
+starting, is given the benefit of the doubt.
+*/
+func (node *Node) handshake(port string) bool {
+	_, err := help.SendHandshake(port, node.Port)
+	var incompatible *help.IncompatibleVersionError
+	var foreign *help.ForeignNetworkError
+	if errors.As(err, &incompatible) || errors.As(err, &foreign) {
+		node.logger().Warnf("refused to peer with %s: %v", port, err)
+		return false
+	}
+	return true
+}
+
+/*
+Tell the network this node is leaving, so peers stop counting on its votes,
+and the registry, if the node registered with one, forgets it.
+*/
+func (node *Node) Leave() {
+	if node.Peers == nil {
+		return
+	}
+	node.Peers.Remove(node.Port)
+	node.gossip(LEAVE, PeerAnnouncement{Port: node.Port})
+
+	// The registry, if there is one, forgets the node too. A node started on
+	// a port of its own, see StartNode, was never registered with it.
+	if reg.IsRegistry(USER_LIST) {
+		reg.RemoveNode(USER_LIST, node.Port)
+	}
+}
+
+/*
+Send an announcement to every known peer but the announced node. Each peer
+forwards announcements that are news to it, so the whole network learns of
+them, and stops there since every peer already knows.
+*/
+func (node *Node) gossip(command string, announcement PeerAnnouncement) {
+	for _, port := range node.Peers.List() {
+		if port == node.Port || port == announcement.Port {
+			continue
+		}
+		node.announce(port, command, announcement, &help.PeerList{})
+	}
+}
+
+/*
+Send an announcement to the node at port and decode the peer set it returns.
+*/
+func (node *Node) announce(port string, command string, announcement PeerAnnouncement, peers *help.PeerList) bool {
+	jsonBytes, err := json.Marshal(announcement)
+	if help.Check(err) {
+		return false
+	}
+
+	resp, err := help.HTTP_CLIENT.Post(help.NodeURL(port)+command, "application/json", bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		node.logger().Warnf("could not send %s to %s", command, port)
+		return false
+	}
+	defer help.CloseBody(resp)
+
+	return resp.StatusCode == http.StatusOK && !help.Check(json.NewDecoder(resp.Body).Decode(peers))
+}
+
+/*
+Handle /peers, /join and /leave. An announcement that is news to this
+node is gossiped on to its peers.
+*/
+func (node *Node) HandlePeers(w http.ResponseWriter, r *http.Request) {
+	if r.RequestURI != PEERS {
+		var announcement PeerAnnouncement
+		if help.Check(json.NewDecoder(r.Body).Decode(&announcement)) || announcement.Port == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		news := false
+		if r.RequestURI == JOIN && node.IsBanned(announcement.Port) {
+			node.logger().Warnf("refused %s joining, it is banned", announcement.Port)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		} else if r.RequestURI == JOIN {
+			news = node.Peers.Add(announcement.Port)
+		} else {
+			news = node.Peers.Remove(announcement.Port)
+		}
+		if news {
+			node.logger().Infof("learned %s %s", announcement.Port, r.RequestURI)
+			go node.gossip(r.RequestURI, announcement)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(help.PeerList{Peers: node.Peers.List()})
+}
@@ -0,0 +1,111 @@
+package node
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	blk "project/Block"
+	"strconv"
+)
+
+/*
+The proof that content is on the blockchain, for light clients holding the
+headers of the blocks only: the entry holding the content, and the Merkle
+proof leading from it to the Merkle root of its block's header. A proof of
+content found by its content ID also holds the content IDs of the block,
+which hash to the header's, so the entry's position can be checked to be
+the content ID's.
+*/
+type InclusionProof struct {
+	Found      bool             `json:"found"`
+	Index      int              `json:"index"`
+	BlockHash  string           `json:"block_hash"`
+	Entry      []byte           `json:"entry"`
+	Proof      []blk.MerkleStep `json:"proof"`
+	ContentIDs []string         `json:"content_ids,omitempty"`
+}
+
+/*
+Return the inclusion proof of the first entry with the given hash in a
+block after the given index, see FindReceipt.
+*/
+func (node *Node) ProveInclusion(request ReceiptRequest) InclusionProof {
+	for _, block := range node.Blockchain.Blocks {
+		if block.Index <= request.After {
+			continue
+		}
+
+		for i, entry := range block.Entries {
+			hash := sha256.Sum256(entry)
+			if hex.EncodeToString(hash[:]) == request.ContentHash {
+				return InclusionProof{
+					Found:     true,
+					Index:     block.Index,
+					BlockHash: hex.EncodeToString(block.SelfHash),
+					Entry:     entry,
+					Proof:     blk.MerkleProof(block.Entries, i),
+				}
+			}
+		}
+	}
+
+	return InclusionProof{Found: false}
+}
+
+/*
+Return the inclusion proof of the content committed under contentID in the
+block at the given index. A pruned block is fetched in full from an archive
+peer first. Returns false if it could not be.
+*/
+func (node *Node) ProveContent(index int, contentID string) (InclusionProof, bool) {
+	block, found := node.BlockByIndex(index)
+	if !found {
+		return InclusionProof{Found: false}, true
+	}
+	full, ok := node.fullBlocks([]*blk.Block{block})
+	if !ok {
+		return InclusionProof{}, false
+	}
+	block = full[0]
+
+	i := block.EntryWithID(contentID)
+	if i < 0 {
+		return InclusionProof{Found: false}, true
+	}
+	return InclusionProof{
+		Found:      true,
+		Index:      block.Index,
+		BlockHash:  hex.EncodeToString(block.SelfHash),
+		Entry:      block.Entries[i],
+		Proof:      blk.MerkleProof(block.Entries, i),
+		ContentIDs: block.ContentIDs,
+	}, true
+}
+
+/*
+Handle /proof?block=N&content_id=X, reply with the inclusion proof of the
+content committed under content ID X in block N, see ProveContent. Answers
+404 Not Found, with "found": false, if the block does not hold it.
+*/
+func (node *Node) HandleContentProof(w http.ResponseWriter, r *http.Request) {
+	index, err := strconv.Atoi(r.URL.Query().Get("block"))
+	contentID := r.URL.Query().Get("content_id")
+	if err != nil || contentID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	proof, ok := node.ProveContent(index, contentID)
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	code := http.StatusOK
+	if !proof.Found {
+		code = http.StatusNotFound
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(proof)
+}
@@ -0,0 +1,122 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	blk "project/Block"
+	help "project/Helpers"
+)
+
+/* Query asking /copy_chain for the headers of the blocks only */
+const HEADERS_ONLY string = "headers=true"
+
+/*
+Return the headers of blocks, see Block.Header.
+*/
+func Headers(blocks []*blk.Block) []*blk.Block {
+	headers := make([]*blk.Block, len(blocks))
+	for i, block := range blocks {
+		headers[i] = block.Header()
+	}
+	return headers
+}
+
+/*
+Replace the blocks of this node's blockchain older than its last PruneDepth
+blocks by their headers, and return how many were replaced. Only blocks up
+to the last checkpoint are pruned, so no fork ever rolls a pruned block
+back. An archive node, whose PruneDepth is 0, prunes nothing.
+*/
+func (node *Node) pruneBlockchain() int {
+	if node.PruneDepth <= 0 {
+		return 0
+	}
+	checkpoint, found := node.Checkpoints.Last()
+	if !found {
+		return 0
+	}
+
+	blocks := node.Blockchain.Blocks
+	limit := len(blocks) - node.PruneDepth
+	if limit > checkpoint.Index+1 {
+		limit = checkpoint.Index + 1
+	}
+
+	// Readers may hold the old slice, so the pruned blockchain is a copy
+	var pruned []*blk.Block
+	count := 0
+	for i := 0; i < limit; i++ {
+		if blocks[i].HeaderOnly {
+			continue
+		}
+		if pruned == nil {
+			pruned = append([]*blk.Block{}, blocks...)
+		}
+		pruned[i] = blocks[i].Header()
+		count++
+	}
+	if count > 0 {
+		node.Blockchain.Blocks = pruned
+	}
+	return count
+}
+
+/*
+Return the peers this node can fetch full blocks from, fastest first: the
+archive nodes, whose /status reports a prune depth of 0.
+*/
+func (node *Node) archivePeers() []string {
+	archives := []string{}
+	for _, port := range node.PeersByLatency(node.KnownPeers()) {
+		if port == node.Port {
+			continue
+		}
+
+		resp, err := help.HTTP_CLIENT.Get(help.NodeURL(port) + STATUS)
+		if err != nil {
+			continue // Peer is not active
+		}
+		var status NodeStatus
+		err = json.NewDecoder(resp.Body).Decode(&status)
+		help.CloseBody(resp)
+		if err == nil && status.PruneDepth == 0 {
+			archives = append(archives, port)
+		}
+	}
+	return archives
+}
+
+/*
+Return true if header is the header of the full block: a header covers the
+Merkle root and the authors of its block, so a block holding other content
+than the one mined does not match.
+*/
+func matchesHeader(full *blk.Block, header *blk.Block) bool {
+	if full.HeaderOnly || !full.Validate() {
+		return false
+	}
+	a, errA := json.Marshal(full.Header())
+	b, errB := json.Marshal(header.Header())
+	return errA == nil && errB == nil && bytes.Equal(a, b)
+}
+
+/*
+Return blocks of this node's blockchain with the pruned ones fetched from
+archive peers, so a pruned node serves full blocks like an archive node.
+Returns false if no archive peer holds one of them.
+*/
+func (node *Node) fullBlocks(blocks []*blk.Block) ([]*blk.Block, bool) {
+	pruned := false
+	for _, block := range blocks {
+		pruned = pruned || block.HeaderOnly
+	}
+	if !pruned {
+		return blocks, true
+	}
+
+	full, ok := fillBlocks(blocks, node.archivePeers())
+	if !ok {
+		node.logger().Warnf("found no archive peer for its pruned blocks")
+	}
+	return full, ok
+}
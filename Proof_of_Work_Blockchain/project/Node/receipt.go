@@ -0,0 +1,80 @@
+package node
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	blk "project/Block"
+	"strings"
+)
+
+/*
+A request for the receipt of content, sent by users to find out
+whether their content landed on the blockchain.
+*/
+type ReceiptRequest struct {
+	ContentHash string `json:"content_hash"` // SHA-256 of the content, hex encoded
+	After       int    `json:"after"`        // Only look at blocks with a higher index
+}
+
+/*
+The receipt of content: the block holding it, if it is on the blockchain.
+*/
+type Receipt struct {
+	Found     bool   `json:"found"`
+	Index     int    `json:"index"`
+	BlockHash string `json:"block_hash"`
+}
+
+/*
+Return the receipt of the first block after the given index with a
+content entry that has the given hash. A pruned node only looks through
+the blocks it keeps in full.
+*/
+func (node *Node) FindReceipt(request ReceiptRequest) Receipt {
+	return findReceipt(node.chain(), request)
+}
+
+/*
+Return the receipt of the content on the given blocks of a node's
+blockchain, see FindReceipt.
+*/
+func findReceipt(blocks []*blk.Block, request ReceiptRequest) Receipt {
+	for _, block := range blocks {
+		if block.Index <= request.After {
+			continue
+		}
+
+		for _, entry := range block.Entries {
+			hash := sha256.Sum256(entry)
+			if hex.EncodeToString(hash[:]) == request.ContentHash {
+				return Receipt{Found: true, Index: block.Index, BlockHash: hex.EncodeToString(block.SelfHash)}
+			}
+		}
+	}
+
+	return Receipt{Found: false}
+}
+
+/*
+Handle /receipt/{id}, the confirmation of the content with that ID: reply
+with its block index, block hash and confirmations, or 404 Not Found if
+it is not on the blockchain.
+*/
+func (node *Node) HandleReceiptByID(w http.ResponseWriter, r *http.Request) {
+	contentID := strings.TrimPrefix(r.URL.Path, RECEIPT+"/")
+	if contentID == "" || strings.Contains(contentID, "/") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	status := node.FindContentStatus(contentID)
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Found {
+		w.WriteHeader(http.StatusNotFound)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	json.NewEncoder(w).Encode(status)
+}
@@ -3,11 +3,11 @@ package node
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
 	"net/http"
-	"os"
 	bc "project/Blockchain"
 	help "project/Helpers"
+	reg "project/Registry"
+	st "project/Store"
 	"strconv"
 	"sync"
 )
@@ -18,68 +18,120 @@ var registration_mutex sync.Mutex
 	Register a node to the blockchain
 	RegisterNode may be called concurrently and should be thread safe.
 */
-func (node *Node) RegisterNode(NodeList string, UserList string, OUT os.File) {
+func (node *Node) RegisterNode(Seed string, UserList string, log *help.Logger) {
 	
 	/*
-		Nodes read the NodeList and choose a port number that
-		has not already	been used.
+		Nodes ask the seed node for the peers on the network and choose
+		a port number that has not already been used.
 
 		Once there are a non-trivial number of nodes on the network
 		a NewBlockchain() may be created.
+
+		If UserList is the URL of a registry, the registry allocates the
+		port instead, so nodes of several processes never choose the same.
 	*/
 
+	// The node logs to log before any of its goroutines starts
+	if log != nil {
+		node.Log = log
+	}
+
 	registration_mutex.Lock()
 
-	// Read from the NodeList
-	known_ports := help.GetPorts(NodeList)
+	// Ask the seed node for the known peers
+	known_ports := help.GetPeers(Seed)
+
+	// Initial port choice, the first node is the seed
+	chosen_port, err := strconv.Atoi(Seed)
+	if help.Check(err) {
+		registration_mutex.Unlock()
+		return // Seed is not a port number
+	}
 
-	// Initial port choice
-	chosen_port := 1234
+	// Port allocated by the registry, if there is one
+	registered := ""
+	if reg.IsRegistry(UserList) {
+		registered, err = reg.RegisterNode(UserList)
+		if help.Check(err) {
+			registration_mutex.Unlock()
+			return // Registry is not reachable
+		}
+	}
 
 	// If there are nodes already registered
 	if len(known_ports) > 0 {
 		/* Choose a port number */
-		init := known_ports[len(known_ports)-1] // Initialize port choice to last known port
+		init := known_ports[len(known_ports)-1] // Initialize port choice to highest known port
 		chosen_port, err := strconv.Atoi(init)  // Convert initial port choice to int
 		if help.Check(err) {
+			registration_mutex.Unlock()
 			return // Error while converting to int
 		}
 		chosen_port++ // Increment chosen port by 1
 
 		/* Set the node's port field */
 		node.Port = strconv.Itoa(chosen_port)
+		if registered != "" {
+			node.Port = registered
+		}
 
 		// Only fourth node will create a new chain
 		blockchain, success := bc.NewBlockchain(known_ports) // Create new blockchain
 		if success {
-			fmt.Println("Successfully created a new Blockchain")
+			node.logger().Infof("created a new blockchain")
 			// Once a blockchain is created, broadcast it to all peers.
 			if !node.BroadcastNewChain(known_ports, blockchain) {
-				fmt.Printf("Node %s could not broadcast to peers. Stop registration.\n", node.Port)
+				node.logger().Warnf("could not broadcast to peers. Stop registration.")
+				registration_mutex.Unlock()
 				return // could not broadcast to peers. Stop registration.
 			}
 		}
 
-		// NEXT: If more than 4 peers exist, call CopyBlockchain instead.
+		// A node joining an existing network syncs its blockchain headers first
+		if !success && len(known_ports) > bc.NON_TRIVIAL {
+			if synced, chain := getBlockchain(known_ports, node); synced {
+				blockchain = &chain
+			}
+		}
 
 		/* Set the node's blockchain field */
 		node.Blockchain = *blockchain
 	} else { // First node
-		node.Port = strconv.Itoa(chosen_port) // Set the node's port to initial port 5000
+		node.Port = strconv.Itoa(chosen_port) // Set the node's port to the seed port
+		if registered != "" {
+			node.Port = registered
+		}
 	}
 
-	// Add the node to the list
-	help.RegisterPort(node.Port, NodeList)
+	// A new node starts with an empty block store and nothing to mine
+	node.Store = st.NewBlockStore(node.Port)
+	help.Check(node.Store.Reset())
+	node.Work = st.NewWorkStore(node.Port)
+	help.Check(node.Work.Reset())
+	node.persistBlockchain()
+
+	// Listen before joining, so peers can reach this node once they learn of it
+	node.Peers = NewPeerSet(node.Port)
+	if !node.listen(log) {
+		if registered != "" {
+			help.Check(reg.RemoveNode(UserList, registered))
+		}
+		registration_mutex.Unlock()
+		return
+	}
+	if len(known_ports) > 0 && !node.Join(Seed) {
+		node.logger().Warnf("could not join through seed %s", Seed)
+	}
 
 	registration_mutex.Unlock()
 
-	fmt.Fprintf(&OUT, "Successfully registered a Node at port %s\n", node.Port)
+	node.logger().Infof("registered")
 
-	// Set the NodeList and UserList constants
-	NODE_LIST = NodeList
+	// Set the Seed and UserList constants
+	SEED = Seed
 	USER_LIST = UserList
 
-	go node.StartListening(OUT)
+	go node.StartListening(log)
 }
 
 /* Send the new chain to all peers */
@@ -100,7 +152,7 @@ func (node *Node) BroadcastNewChain(known_ports []string, chain *bc.Blockchain)
 		}
 
 		// Create url using the peer's port
-		url := LOCALHOST + peer_port
+		url := help.NodeURL(peer_port)
 
 		// Create the request
 		req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBytes))
@@ -114,21 +166,20 @@ func (node *Node) BroadcastNewChain(known_ports []string, chain *bc.Blockchain)
 		// Set the request's URI to /new_chain
 		req.URL.Path = NEW_CHAIN
 
-		client := &http.Client{}
 		// Send request, and wait for a response
-		resp, err := client.Do(req)
+		resp, err := help.HTTP_CLIENT.Do(req)
 		if err != nil {
-			fmt.Printf("Error getting response from : %v\n", url)
+			node.logger().Warnf("could not get a response from %v", url)
 			return false
 		}
 
-		fmt.Printf("Sent /new_chain to %s\n Received %d from %s\n", url, resp.StatusCode, url)
+		node.logger().Debugf("sent /new_chain to %s, received %d", url, resp.StatusCode)
 
 		if help.Check(err) {
 			return false
 		} else {
-			// No errors, so close the connection.
-			resp.Body.Close()
+			// No errors, so hand the connection back for reuse.
+			help.CloseBody(resp)
 		}
 	}
 
@@ -0,0 +1,162 @@
+package node
+
+import (
+	blk "project/Block"
+	"sync"
+)
+
+var committed_mutex sync.Mutex
+
+/* Prefix of the hashes of the content users sent among committed IDs, see blk.SubmissionHash */
+const SUBMISSION_PREFIX string = "submission:"
+
+/*
+The content IDs, the hashes of the content users sent and the IDs of the
+transfers and transactions on the blockchain of a node, see blk.SubmissionHash
+and blk.TransactionID. Content is committed once: a block or content
+replaying one of them is refused, so users may safely send content again. Blocks are applied as
+the blockchain grows, and all of them again when the node adopts another
+chain.
+*/
+type CommittedIDs struct {
+	chainState
+	ids map[string]int // Index of the block committing each ID
+}
+
+func NewCommittedIDs() *CommittedIDs {
+	return &CommittedIDs{ids: map[string]int{}}
+}
+
+/*
+Return the IDs the entry of the block at index i commits: its content ID,
+the hash of its content if a user sent it, and its transfer, transaction or
+difficulty change ID if it holds one.
+*/
+func entryIDs(block *blk.Block, i int) []string {
+	ids := []string{}
+	if len(block.ContentIDs) > i && block.ContentIDs[i] != "" {
+		ids = append(ids, block.ContentIDs[i])
+	}
+	if len(block.Authors) > i && block.Authors[i] != "" {
+		ids = append(ids, SUBMISSION_PREFIX+blk.SubmissionHash(string(block.Entries[i]), block.Authors[i]))
+	}
+	if id, ok := blk.TransactionID(block.Entries[i]); ok {
+		ids = append(ids, id)
+	}
+	if change, ok := blk.ParseDifficultyChange(block.Entries[i]); ok {
+		ids = append(ids, change.ID())
+	}
+	return ids
+}
+
+/*
+Record the IDs the entries of the block commit.
+*/
+func (committed *CommittedIDs) applyBlock(block *blk.Block) {
+	for i := range block.Entries {
+		for _, id := range entryIDs(block, i) {
+			if _, found := committed.ids[id]; !found {
+				committed.ids[id] = block.Index
+			}
+		}
+	}
+}
+
+/*
+Return the committed IDs of this node, created on first use.
+*/
+func (node *Node) committed() *CommittedIDs {
+	committed_mutex.Lock()
+	defer committed_mutex.Unlock()
+
+	if node.Committed == nil {
+		node.Committed = NewCommittedIDs()
+	}
+	return node.Committed
+}
+
+/*
+Bring the committed IDs of this node up to the given blocks of its blockchain and return them
+locked, the caller unlocks them. Returns false, with the IDs unlocked, if
+they could not be, see syncState.
+*/
+func (node *Node) syncCommitted(blocks []*blk.Block) (*CommittedIDs, bool) {
+	committed := node.committed()
+	committed.mu.Lock()
+
+	reset := func() { committed.ids = map[string]int{} }
+	if !node.syncState(blocks, &committed.chainState, reset, committed.applyBlock) {
+		committed.mu.Unlock()
+		return nil, false
+	}
+	return committed, true
+}
+
+/*
+Returns true if the block replays content or a transfer or transaction
+already on the given blocks of this node's blockchain, or holds the same
+one twice.
+*/
+func (node *Node) IsReplay(blocks []*blk.Block, block blk.Block) bool {
+	committed, ok := node.syncCommitted(blocks)
+	if !ok {
+		return true
+	}
+	defer committed.mu.Unlock()
+
+	seen := map[string]bool{}
+	for i := range block.Entries {
+		for _, id := range entryIDs(&block, i) {
+			if _, found := committed.ids[id]; found || seen[id] {
+				return true
+			}
+			seen[id] = true
+		}
+	}
+	return false
+}
+
+/*
+Returns true if content the user with the author address sent under the
+given content ID would replay content or a transfer or transaction already
+on this node's blockchain, e.g. content its user sent before.
+*/
+func (node *Node) Replays(content string, author string, contentID string) bool {
+	block := blk.Block{Entries: [][]byte{[]byte(content)}, Authors: []string{author}, ContentIDs: []string{contentID}}
+	return node.IsReplay(node.chain(), block)
+}
+
+/*
+Split a batch of pending content into the content that is new to this
+node's blockchain, in order, and the content replaying the blockchain or
+content before it in the batch. If the committed IDs could not be derived,
+all content is dropped.
+*/
+func (node *Node) fresh(batch []*pendingContent) ([]*pendingContent, []*pendingContent) {
+	committed, ok := node.syncCommitted(node.chain())
+	if !ok {
+		return nil, batch
+	}
+	defer committed.mu.Unlock()
+
+	kept, dropped := []*pendingContent{}, []*pendingContent{}
+	seen := map[string]bool{}
+	for _, entry := range batch {
+		ids := entryIDs(&blk.Block{Entries: [][]byte{[]byte(entry.content)}, Authors: []string{entry.author}, ContentIDs: []string{entry.id}}, 0)
+		replay := false
+		for _, id := range ids {
+			if _, found := committed.ids[id]; found || seen[id] {
+				replay = true
+			}
+		}
+		if replay {
+			dropped = append(dropped, entry)
+			continue
+		}
+		for _, id := range ids {
+			seen[id] = true
+		}
+		kept = append(kept, entry)
+	}
+	return kept, dropped
+}
@@ -0,0 +1,163 @@
+package node
+
+import (
+	bc "project/Blockchain"
+	help "project/Helpers"
+	st "project/Store"
+)
+
+/*
+Write this node's blockchain to its block store, if it has one, record
+the checkpoints it finalized, apply the new blocks to its balances, unspent
+outputs, committed IDs and user history, prune old blocks if the node is pruned, wake up /block_events requests
+and confirm committed content to its callbacks. Called whenever the node accepts a block
+or adopts another chain.
+*/
+func (node *Node) persistBlockchain() {
+	for _, checkpoint := range node.Checkpoints.Record(node.Blockchain.Blocks) {
+		node.logger().Infof("checkpointed block %d{ %s }", checkpoint.Index, checkpoint.Hash)
+	}
+	// Blocks are applied while they are still held in full
+	if balances, ok := node.syncBalances(node.Blockchain.Blocks); ok {
+		balances.mu.Unlock()
+	}
+	if utxos, ok := node.syncUTXOs(node.Blockchain.Blocks); ok {
+		utxos.mu.Unlock()
+	}
+	if committed, ok := node.syncCommitted(node.Blockchain.Blocks); ok {
+		committed.mu.Unlock()
+	}
+	if history, ok := node.syncHistory(node.Blockchain.Blocks); ok {
+		history.mu.Unlock()
+	}
+	pruned := node.pruneBlockchain()
+	if pruned > 0 {
+		node.logger().Infof("pruned %d blocks", pruned)
+	}
+	node.notifyBlockEvents()
+	node.notifyCallbacks(node.Blockchain.Blocks)
+
+	if node.Store == nil {
+		return
+	}
+	if pruned > 0 {
+		help.Check(node.Store.Rewrite(node.Blockchain.Blocks)) // Save only appends to the stored blocks
+	} else {
+		help.Check(node.Store.Save(node.Blockchain.Blocks))
+	}
+}
+
+/*
+Restart a node that was registered at the given port before, e.g. after it
+was drained or crashed. Its blockchain is reloaded from its block store,
+then replaced by the majority blockchain of its peers if they answer, since
+blocks may have been accepted while the node was down.
+
+The node rejoins the network through the node at Seed. A restarted seed
+node must be given the port of another node instead.
+*/
+func (node *Node) RestartNode(port string, Seed string, UserList string, log *help.Logger) bool {
+	return node.StartNode(port, []string{Seed}, UserList, log)
+}
+
+/*
+Start a node at the given port, e.g. from the command line. Its blockchain
+is loaded from its block store, which is empty for a new node, unless it
+does not verify: then the store is discarded and the blockchain copied from
+the node's peers. The content the node had yet to mine is mined again, see
+ResumeWork. The node
+joins the network through the first of Seeds that answers. If none does,
+it starts a network of its own and new nodes join through it.
+
+As in RegisterNode, a new node joining a network of NON_TRIVIAL nodes
+creates the blockchain and broadcasts it. The node logs to log, or to
+help.LOG if nil.
+*/
+func (node *Node) StartNode(port string, Seeds []string, UserList string, log *help.Logger) bool {
+	registration_mutex.Lock()
+
+	// The node logs to log before any of its goroutines starts
+	if log != nil {
+		node.Log = log
+	}
+	node.Port = port
+	node.Store = st.NewBlockStore(port)
+	node.Work = st.NewWorkStore(port)
+
+	blocks, err := node.Store.Load()
+	if help.Check(err) {
+		registration_mutex.Unlock()
+		return false
+	}
+	// A tampered or corrupted store is discarded, the blockchain is copied from peers instead
+	discarded := false
+	if err := (&bc.Blockchain{Blocks: blocks}).Verify(); err != nil {
+		node.logger().Warnf("discarded its stored blockchain: %v", err)
+		help.Check(node.Store.Reset())
+		blocks, discarded = nil, true
+	}
+	node.Blockchain.Blocks = blocks
+
+	// A crashed node may still be known to its peers
+	known_ports := []string{}
+	for _, known_port := range help.GetPeers(Seeds...) {
+		if known_port != port {
+			known_ports = append(known_ports, known_port)
+		}
+	}
+
+	if len(blocks) == 0 && !discarded {
+		blockchain, success := bc.NewBlockchain(known_ports)
+		if success {
+			node.logger().Infof("created a new blockchain")
+			if !node.BroadcastNewChain(known_ports, blockchain) {
+				node.logger().Warnf("could not broadcast to peers. Stop starting.")
+				registration_mutex.Unlock()
+				return false
+			}
+			node.Blockchain = *blockchain
+			node.persistBlockchain()
+		}
+	}
+
+	// A drained node left the network and a crashed one may have been
+	// dropped by its peers, so join again either way
+	node.Peers = NewPeerSet(port)
+	if !node.listen(log) {
+		registration_mutex.Unlock()
+		return false
+	}
+	seed := port
+	for _, s := range Seeds {
+		if s != port && node.Join(s) {
+			seed = s
+			break
+		}
+	}
+	if seed == port && len(known_ports) > 0 {
+		node.logger().Warnf("could not join through seeds %v", Seeds)
+	}
+
+	registration_mutex.Unlock()
+
+	// Set the Seed and UserList constants
+	SEED = seed
+	USER_LIST = UserList
+
+	node.logger().Infof("started with %d stored blocks", len(blocks))
+
+	go node.StartListening(log)
+
+	// Catch up on blocks accepted while this node was down
+	if seed != port {
+		if success, blockchain := getBlockchain(node.KnownPeers(), node); success && node.verifies(blockchain) && node.keepsCheckpoints(blockchain.Blocks) {
+			node.Blockchain = blockchain
+			node.persistBlockchain()
+		}
+	}
+
+	// Mine the content left when the node stopped
+	node.ResumeWork()
+
+	return true
+}
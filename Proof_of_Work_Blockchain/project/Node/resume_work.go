@@ -0,0 +1,87 @@
+package node
+
+import (
+	help "project/Helpers"
+	st "project/Store"
+	"sync"
+)
+
+var work_mutex sync.Mutex
+
+/*
+Write what this node has yet to mine to its work store, if it has one:
+the content in its mempool and the block it mines. Called whenever either
+changes, and as the mining of a block progresses.
+*/
+func (node *Node) saveWork() {
+	if node.Work == nil || node.Mempool == nil {
+		return
+	}
+	// Saves in order, so an older snapshot never replaces a newer one
+	work_mutex.Lock()
+	defer work_mutex.Unlock()
+	help.Check(node.Work.Save(node.Mempool.Work()))
+}
+
+/*
+Record the nonces this node got to on the block it mines, see pow.Engine.Resume.
+*/
+func (node *Node) checkpointWork(progress []int) {
+	node.Mempool.checkpoint(progress)
+	node.saveWork()
+}
+
+/*
+Queue again the content this node had yet to mine when it stopped, from its
+work store, and mine it. The block it was mining is resumed from the nonces
+it got to, as long as it still follows the blockchain and holds the same
+content, otherwise its content is mined into a new block. Content committed
+meanwhile is dropped by the mining, see fresh. Returns the number of content
+queued again.
+*/
+func (node *Node) ResumeWork() int {
+	if node.Work == nil {
+		return 0
+	}
+	work, err := node.Work.Load()
+	if help.Check(err) || work.Empty() {
+		return 0
+	}
+
+	if work.Job != nil {
+		node.Mempool.resume(work.Job)
+	}
+	requeued := node.requeue(work.Content())
+	if requeued > 0 {
+		node.logger().Infof("resumed mining %d content", requeued)
+	}
+	return requeued
+}
+
+/*
+Queue content in this node's mempool, as if sent over /content, and mine
+it. Content already queued is skipped. Returns the number of content queued.
+*/
+func (node *Node) requeue(content []st.PendingContent) int {
+	requeued := 0
+	for _, entry := range content {
+		if !node.startMining() {
+			break // Draining
+		}
+		mined, evicted := node.Mempool.Add(entry.Content, entry.Author, entry.ID, entry.Fee)
+		if mined == nil {
+			node.doneMining()
+			continue
+		}
+		if evicted {
+			node.doneMining()
+		}
+		requeued++
+	}
+	node.saveWork()
+
+	if requeued > 0 {
+		go node.mineMempool()
+	}
+	return requeued
+}
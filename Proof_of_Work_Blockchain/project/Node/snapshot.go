@@ -0,0 +1,142 @@
+package node
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	blk "project/Block"
+	bc "project/Blockchain"
+	st "project/Store"
+)
+
+/* Version of the snapshots Snapshot writes, Restore refuses others */
+const SNAPSHOT_VERSION int = 1
+
+/*
+The state of a node archived by Snapshot: its blockchain, the content it
+had yet to mine, its peers and the balances it derived from its blockchain.
+*/
+type NodeSnapshot struct {
+	Version  int                 `json:"version"`
+	Port     string              `json:"port"` // Port of the node the snapshot was taken of
+	Blocks   []*blk.Block        `json:"blocks"`
+	Pending  []st.PendingContent `json:"pending"`
+	Peers    []string            `json:"peers"`
+	Balances SnapshotBalances    `json:"balances"`
+}
+
+/* The balances of a snapshot and the blocks they were derived from, see Balances */
+type SnapshotBalances struct {
+	Height   int               `json:"height"`
+	Tip      []byte            `json:"tip"`
+	Accounts map[string]uint64 `json:"accounts"`
+}
+
+/*
+Write the state of this node to w as a single gzipped JSON archive, see
+NodeSnapshot, e.g. to move the node to another machine or to come back
+to a known good state with Restore. Returns an error if the balances
+could not be derived, see syncBalances.
+*/
+func (node *Node) Snapshot(w io.Writer) error {
+	snapshot := NodeSnapshot{Version: SNAPSHOT_VERSION, Port: node.Port, Peers: node.KnownPeers(), Pending: []st.PendingContent{}}
+
+	// The blockchain and the balances derived from it, as they are at once
+	node.Acceptance_mu.Lock()
+	snapshot.Blocks = append([]*blk.Block{}, node.Blockchain.Blocks...)
+	balances, ok := node.syncBalances(snapshot.Blocks)
+	if ok {
+		snapshot.Balances = SnapshotBalances{Height: balances.height, Tip: balances.tip, Accounts: map[string]uint64{}}
+		for address, balance := range balances.accounts {
+			snapshot.Balances.Accounts[address] = balance
+		}
+		balances.mu.Unlock()
+	}
+	node.Acceptance_mu.Unlock()
+	if !ok {
+		return fmt.Errorf("could not derive the balances of node %s", node.Port)
+	}
+
+	if node.Mempool != nil {
+		snapshot.Pending = node.Mempool.Work().Content()
+	}
+
+	archive := gzip.NewWriter(w)
+	if err := json.NewEncoder(archive).Encode(snapshot); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+/*
+Replace the state of this node by the snapshot read from r, see Snapshot:
+adopt its blockchain once it verifies, with its balances, learn of its
+peers and mine the content it had yet to mine. The node may run on another
+port than the node the snapshot was taken of. Checkpoints are recorded
+again from the blockchain restored, which may roll back the blockchain the
+node held, e.g. to a known good state.
+*/
+func (node *Node) Restore(r io.Reader) error {
+	archive, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	var snapshot NodeSnapshot
+	if err := json.NewDecoder(archive).Decode(&snapshot); err != nil {
+		return err
+	}
+	if snapshot.Version != SNAPSHOT_VERSION {
+		return fmt.Errorf("snapshot version %d, expected %d", snapshot.Version, SNAPSHOT_VERSION)
+	}
+	if err := (&bc.Blockchain{Blocks: snapshot.Blocks}).Verify(); err != nil {
+		return fmt.Errorf("snapshot blockchain does not verify: %v", err)
+	}
+	balances := snapshot.Balances
+	if balances.Height > len(snapshot.Blocks) || (balances.Height > 0 && !bytes.Equal(snapshot.Blocks[balances.Height-1].SelfHash, balances.Tip)) {
+		return fmt.Errorf("snapshot balances do not follow its blockchain")
+	}
+
+	restored := NewBalances()
+	restored.height, restored.tip = balances.Height, balances.Tip
+	for address, balance := range balances.Accounts {
+		restored.accounts[address] = balance
+	}
+
+	node.Acceptance_mu.Lock()
+	node.Blockchain.Blocks = snapshot.Blocks
+	node.Checkpoints = NewCheckpoints()
+	balances_mutex.Lock()
+	node.Balances = restored
+	balances_mutex.Unlock()
+	// The unspent outputs, committed IDs and user history are derived again from the blockchain restored
+	utxos_mutex.Lock()
+	node.UTXOs = nil
+	utxos_mutex.Unlock()
+	committed_mutex.Lock()
+	node.Committed = nil
+	committed_mutex.Unlock()
+	history_mutex.Lock()
+	node.History = nil
+	history_mutex.Unlock()
+	node.persistBlockchain()
+	node.Acceptance_mu.Unlock()
+
+	if node.Peers == nil {
+		node.Peers = NewPeerSet(node.Port)
+	}
+	for _, peer := range snapshot.Peers {
+		if peer != snapshot.Port {
+			node.Peers.Add(peer)
+		}
+	}
+
+	node.logger().Infof("restored %d blocks from a snapshot of node %s", len(snapshot.Blocks), snapshot.Port)
+	if node.Mempool != nil {
+		node.requeue(snapshot.Pending)
+	}
+	return nil
+}
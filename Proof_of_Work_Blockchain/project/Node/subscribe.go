@@ -0,0 +1,78 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	help "project/Helpers"
+	"strconv"
+	"time"
+)
+
+const SUBSCRIBE string = "/subscribe"
+
+/* Time between pings to a subscriber, to notice it went away */
+var subscribe_ping_time time.Duration = 15 * time.Second
+
+/*
+Handle /subscribe?since=N: upgrade the connection to a WebSocket, then push
+the blocks from index since on, and again each time the blockchain changes,
+e.g. when acceptValidatedBlock appends a block. Each message is a JSON
+BlockEvents, as returned by /block_events. since is optional and defaults
+to the height of the blockchain, so only new blocks are pushed. A message
+without blocks but with another tip means the blockchain was replaced.
+*/
+func (node *Node) HandleSubscribe(w http.ResponseWriter, r *http.Request) {
+	since := len(node.Blockchain.Blocks)
+	if value := r.URL.Query().Get("since"); value != "" {
+		n, err := strconv.Atoi(value)
+		if help.Check(err) || n < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		since = n
+	}
+
+	ws, err := help.UpgradeWebSocket(w, r)
+	if help.Check(err) {
+		return
+	}
+	defer ws.Close()
+	node.logger().Infof("has a new subscriber %s", r.RemoteAddr)
+
+	// Subscribers only send pongs and closing frames, read them to notice they went away
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	var tip []byte
+	for {
+		changed := node.blockchainChanged()
+
+		events := node.BlocksSince(since, 0)
+		if len(events.Blocks) > 0 || (tip != nil && !bytes.Equal(events.Tip, tip)) {
+			message, err := json.Marshal(events)
+			if help.Check(err) || ws.WriteText(message) != nil {
+				return
+			}
+		}
+		since, tip = events.Height, events.Tip
+
+		select {
+		case <-changed:
+		case <-gone:
+			return
+		case <-time.After(subscribe_ping_time):
+			// Shutting down does not close hijacked connections
+			if node.Listener != nil && !node.IsRunning() || ws.Ping() != nil {
+				return
+			}
+		}
+	}
+}
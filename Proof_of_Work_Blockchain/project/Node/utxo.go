@@ -0,0 +1,268 @@
+package node
+
+import (
+	"encoding/json"
+	"net/http"
+	blk "project/Block"
+	wlt "project/Wallet"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const UTXOS string = "/utxos"
+
+var utxos_mutex sync.Mutex
+
+/* An unspent output as served on /utxos/{address} */
+type UTXO struct {
+	blk.OutPoint
+	blk.Output
+}
+
+/*
+The outputs of the transactions on the blockchain of a node that are not
+spent yet, see blk.Transaction. Every address is also allocated one output
+of INITIAL_BALANCE until it spends it, see blk.Allocation. Blocks are
+applied as the blockchain grows, and all of them again when the node adopts
+another chain.
+*/
+type UTXOSet struct {
+	chainState
+	outputs   map[blk.OutPoint]blk.Output
+	allocated map[string]bool // Addresses that spent their allocation
+}
+
+func NewUTXOSet() *UTXOSet {
+	return &UTXOSet{outputs: map[blk.OutPoint]blk.Output{}, allocated: map[string]bool{}}
+}
+
+/*
+Return the unspent output, or false if it was spent or never created.
+The caller holds set.mu.
+*/
+func (set *UTXOSet) output(outPoint blk.OutPoint) (blk.Output, bool) {
+	if output, found := set.outputs[outPoint]; found {
+		return output, true
+	}
+	if address, allocation := outPoint.Allocated(); allocation && !set.allocated[address] {
+		return blk.Output{Address: address, Amount: INITIAL_BALANCE}, true
+	}
+	return blk.Output{}, false
+}
+
+/*
+The unspent outputs once some transactions are applied on top of a UTXO
+set, which stays unchanged until the view is committed.
+*/
+type utxoView struct {
+	set     *UTXOSet
+	created map[blk.OutPoint]blk.Output
+	spent   map[blk.OutPoint]bool
+}
+
+func (set *UTXOSet) view() *utxoView {
+	return &utxoView{set: set, created: map[blk.OutPoint]blk.Output{}, spent: map[blk.OutPoint]bool{}}
+}
+
+func (view *utxoView) output(outPoint blk.OutPoint) (blk.Output, bool) {
+	if view.spent[outPoint] {
+		return blk.Output{}, false
+	}
+	if output, found := view.created[outPoint]; found {
+		return output, true
+	}
+	return view.set.output(outPoint)
+}
+
+/*
+Apply the transaction to the view. Returns false, leaving the view
+unchanged, if the transaction is not valid, spends an output that is not
+unspent or not owned by the key signing its input, or pays out another
+amount than it spends.
+*/
+func (view *utxoView) apply(tx blk.Transaction) bool {
+	if !tx.Verify() {
+		return false
+	}
+
+	var in, out uint64
+	for _, input := range tx.Inputs {
+		output, unspent := view.output(input.OutPoint)
+		if !unspent {
+			return false
+		}
+		if owner, err := wlt.Address(input.PublicKey); err != nil || owner != output.Address {
+			return false
+		}
+		if in+output.Amount < in {
+			return false
+		}
+		in += output.Amount
+	}
+	for _, output := range tx.Outputs {
+		if out+output.Amount < out {
+			return false
+		}
+		out += output.Amount
+	}
+	if in != out {
+		return false
+	}
+
+	id := tx.ID()
+	for _, input := range tx.Inputs {
+		view.spent[input.OutPoint] = true
+		delete(view.created, input.OutPoint)
+	}
+	for i, output := range tx.Outputs {
+		view.created[blk.OutPoint{TxID: id, Index: i}] = output
+	}
+	return true
+}
+
+/*
+Apply the transactions of the view to its UTXO set.
+The caller holds the set's mu.
+*/
+func (view *utxoView) commit() {
+	for outPoint := range view.spent {
+		if address, allocation := outPoint.Allocated(); allocation {
+			view.set.allocated[address] = true
+		}
+		delete(view.set.outputs, outPoint)
+	}
+	for outPoint, output := range view.created {
+		view.set.outputs[outPoint] = output
+	}
+}
+
+/*
+Apply the transactions of the block to the UTXO set, in the order of its
+entries. Invalid transactions, which no validated block holds, are skipped.
+*/
+func (set *UTXOSet) applyBlock(block *blk.Block) {
+	view := set.view()
+	for _, entry := range block.Entries {
+		if tx, ok := blk.ParseTransaction(entry); ok {
+			view.apply(tx)
+		}
+	}
+	view.commit()
+}
+
+/*
+Return the UTXO set of this node, created on first use.
+*/
+func (node *Node) utxos() *UTXOSet {
+	utxos_mutex.Lock()
+	defer utxos_mutex.Unlock()
+
+	if node.UTXOs == nil {
+		node.UTXOs = NewUTXOSet()
+	}
+	return node.UTXOs
+}
+
+/*
+Bring the UTXO set of this node up to the given blocks of its blockchain and return it locked,
+the caller unlocks it. Returns false, with the set unlocked, if it could
+not be, see syncState.
+*/
+func (node *Node) syncUTXOs(blocks []*blk.Block) (*UTXOSet, bool) {
+	set := node.utxos()
+	set.mu.Lock()
+
+	reset := func() { set.outputs, set.allocated = map[blk.OutPoint]blk.Output{}, map[string]bool{} }
+	if !node.syncState(blocks, &set.chainState, reset, set.applyBlock) {
+		set.mu.Unlock()
+		return nil, false
+	}
+	return set, true
+}
+
+/*
+Return the unspent outputs paying to the address, its allocation first,
+then by transaction ID and index. Returns false if the UTXO set could not
+be derived.
+*/
+func (node *Node) UnspentOutputs(address string) ([]UTXO, bool) {
+	set, ok := node.syncUTXOs(node.chain())
+	if !ok {
+		return nil, false
+	}
+	defer set.mu.Unlock()
+
+	utxos := []UTXO{}
+	for outPoint, output := range set.outputs {
+		if output.Address == address {
+			utxos = append(utxos, UTXO{OutPoint: outPoint, Output: output})
+		}
+	}
+	sort.Slice(utxos, func(i, j int) bool {
+		a, b := utxos[i].OutPoint, utxos[j].OutPoint
+		return a.TxID < b.TxID || (a.TxID == b.TxID && a.Index < b.Index)
+	})
+	if output, unspent := set.output(blk.Allocation(address)); unspent {
+		utxos = append([]UTXO{{OutPoint: blk.Allocation(address), Output: output}}, utxos...)
+	}
+	return utxos, true
+}
+
+/*
+Returns true if every transaction the block holds is valid and spends
+outputs unspent on the given blocks of this node's blockchain, including the outputs the
+transactions before it in the block create. An output spent twice, by
+blocks or within one, makes the block invalid.
+*/
+func (node *Node) ValidTransactions(blocks []*blk.Block, block blk.Block) bool {
+	set, ok := node.syncUTXOs(blocks)
+	if !ok {
+		return false
+	}
+	defer set.mu.Unlock()
+
+	view := set.view()
+	for _, entry := range block.Entries {
+		tx, isTransaction := blk.ParseTransaction(entry)
+		if isTransaction && !view.apply(tx) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Returns true if the transaction spends outputs unspent on this node's
+blockchain, see ValidTransactions.
+*/
+func (node *Node) Spendable(tx blk.Transaction) bool {
+	set, ok := node.syncUTXOs(node.chain())
+	if !ok {
+		return false
+	}
+	defer set.mu.Unlock()
+	return set.view().apply(tx)
+}
+
+/*
+Handle /utxos/{address}: reply with the unspent outputs paying to the
+address, or 503 Service Unavailable if this node's pruned blocks could not
+be fetched.
+*/
+func (node *Node) HandleUTXOs(w http.ResponseWriter, r *http.Request) {
+	address := strings.TrimPrefix(r.URL.Path, UTXOS+"/")
+	if address == "" || strings.Contains(address, "/") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	utxos, ok := node.UnspentOutputs(address)
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(utxos)
+}
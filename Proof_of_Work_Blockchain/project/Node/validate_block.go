@@ -3,6 +3,7 @@ package node
 import (
 	"bytes"
 	blk "project/Block"
+	"time"
 )
 
 /*
@@ -11,20 +12,44 @@ import (
 	   A block is valid if:
 			- it has a valid index,
 			- it has a valid prevHash,
-			- its Proof-of-Work is valid and
-			- the block is not already in the chain.
+			- it is sealed as the node's consensus engine requires, e.g. it
+			  declares the difficulty the chain expects next and its
+			  Proof-of-Work is valid at that difficulty,
+			- its content is within the size limits,
+			- its content pays the fees its size requires,
+			- its timestamp is not too far in the future, nor earlier than
+			  the median timestamp of the last blocks,
+			- the block is not already in the chain,
+			- it does not replay content, transfers or transactions on the chain,
+			- it does not contradict a checkpoint,
+			- its transfers are signed and affordable by their senders,
+			- its transactions only spend unspent outputs, once, and
+			- its off-chain content, if any, matches its hash.
 
 Params: When passed 1, ValidateBlock only checks for matching indeces.
+
+The blockchain is held by Acceptance_mu while the block is checked, so no
+block is accepted in the meantime, see validateBlock.
 */
 func (node *Node) ValidateBlock(block blk.Block, i int) bool {
+	node.Acceptance_mu.Lock()
+	defer node.Acceptance_mu.Unlock()
+	return node.validateBlock(node.Blockchain.Blocks, block, i)
+}
+
+/*
+Check the block against the given blocks of this node's blockchain, see
+ValidateBlock. The caller holds Acceptance_mu.
+*/
+func (node *Node) validateBlock(blocks []*blk.Block, block blk.Block, i int) bool {
 	/*
 		A valid index is higher than the most recently committed block index.
 		When a node receives a block that skipped an index or more,
 		the node realizes it is missing a block and it should update its blockchain
 		before accepting the block.
 	*/
-	prevIndex := len(node.Blockchain.Blocks) - 1
-	prevBlock := node.Blockchain.Blocks[prevIndex]
+	prevIndex := len(blocks) - 1
+	prevBlock := blocks[prevIndex]
 	prevHash := prevBlock.SelfHash
 
 	if i == 1 {
@@ -33,17 +58,24 @@ func (node *Node) ValidateBlock(block blk.Block, i int) bool {
 
 	return block.Index > prevIndex &&
 		bytes.Equal(prevHash, block.PrevBlockHash) &&
-		block.Validate() &&
-		!node.IsDoubleSpend(block)
+		node.engine().Verify(blocks, &block, node.KnownPeers()) &&
+		block.PaysRequiredFees() &&
+		blk.ValidTimestamp(blocks, &block, time.Now()) &&
+		!node.IsDoubleSpend(blocks, block) &&
+		!node.IsReplay(blocks, block) &&
+		node.Checkpoints.Allows(block) &&
+		node.ValidTransfers(blocks, block) &&
+		node.ValidTransactions(blocks, block) &&
+		node.VerifyContent(block)
 }
 
 /*
-Return true if the block is already in the chain,
-else false.
+Return true if the block is already in the given blocks,
+else false. Content replayed in another block is caught by IsReplay.
 */
-func (node *Node) IsDoubleSpend(block blk.Block) bool {
+func (node *Node) IsDoubleSpend(blocks []*blk.Block, block blk.Block) bool {
 	// Iterate over all blocks in node's blockchain
-	for _, b := range node.Blockchain.Blocks {
+	for _, b := range blocks {
 		// If the hashes equal, we have a double spend.
 		if bytes.Equal(b.SelfHash, block.SelfHash) {
 			return true
@@ -0,0 +1,44 @@
+package node
+
+import (
+	blk "project/Block"
+	help "project/Helpers"
+)
+
+/*
+Return true if every content entry of the block can be trusted.
+
+Content stored on-chain always can. Content stored off-chain is fetched
+from the blob store, and must hash to the hash the block carries.
+*/
+func (node *Node) VerifyContent(block blk.Block) bool {
+	for _, entry := range block.Entries {
+		if !node.verifyEntry(entry) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Return true if a content entry can be trusted, see VerifyContent.
+*/
+func (node *Node) verifyEntry(entry []byte) bool {
+	ref, offchain := blk.ParseContentRef(entry)
+	if !offchain {
+		return true
+	}
+
+	body, err := help.BLOB_STORE.Get(ref.Location)
+	if err != nil {
+		node.logger().Warnf("could not fetch off-chain content at %s: %v", ref.Location, err)
+		return false
+	}
+
+	if blk.ContentHash(body) != ref.Hash {
+		node.logger().Warnf("found off-chain content at %s that does not match its hash", ref.Location)
+		return false
+	}
+
+	return true
+}
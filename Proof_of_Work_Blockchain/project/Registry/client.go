@@ -0,0 +1,84 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	help "project/Helpers"
+)
+
+/*
+The client calls to a registry are made with. It is not help.HTTP_CLIENT,
+so faults injected between nodes do not keep them from registering.
+*/
+var client = &http.Client{Timeout: help.REQUEST_TIMEOUT}
+
+/* Send a request to the registry at url and decode its JSON answer into v, if not nil */
+func call(method string, url string, body interface{}, v interface{}) error {
+	var reader bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reader).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, url, &reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer help.CloseBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("registry answered %s %s with %d", method, url, resp.StatusCode)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+/* Return the users registered with the registry at url */
+func GetUsers(url string) ([]Record, error) {
+	users := []Record{}
+	return users, call(http.MethodGet, url+USERS, nil, &users)
+}
+
+/*
+Register a user by its address and public key with the registry at url,
+and return its record, holding the port it was allocated.
+*/
+func RegisterUser(url string, address string, publicKey string) (Record, error) {
+	var record Record
+	return record, call(http.MethodPost, url+USERS, Record{Address: address, PublicKey: publicKey}, &record)
+}
+
+/*
+Renew the registration of a user with the registry at url, and return its
+record, holding the time it now expires.
+*/
+func RenewUser(url string, renewal Renewal) (Record, error) {
+	var record Record
+	return record, call(http.MethodPost, url+RENEW, renewal, &record)
+}
+
+/* Return the ports of the nodes registered with the registry at url */
+func GetNodes(url string) ([]string, error) {
+	nodes := []string{}
+	return nodes, call(http.MethodGet, url+NODES, nil, &nodes)
+}
+
+/* Have the registry at url allocate a port to a new node, and return it */
+func RegisterNode(url string) (string, error) {
+	var port string
+	return port, call(http.MethodPost, url+NODES, nil, &port)
+}
+
+/* Have the registry at url forget the node at the given port */
+func RemoveNode(url string, port string) error {
+	return call(http.MethodDelete, url+NODES+"?port="+port, nil, nil)
+}
@@ -0,0 +1,362 @@
+/*
+The registry tracks the nodes and users of a network in memory, in place of
+a user list file shared through /tmp: it allocates each one its port and
+answers who is registered. As it keeps no file, registrations cannot race
+on one, and a run never sees the nodes and users of an earlier run.
+
+Nodes and users use a registry when they are given its URL instead of the
+path of a user list file, see IsRegistry.
+
+A user's registration expires REGISTRATION_TTL after it registered, unless
+it renews it with a Renewal signed by its wallet, so users that are gone
+stop being able to send content while long-running users keep their identity.
+*/
+
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	wlt "project/Wallet"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const USERS string = "/users"
+const RENEW string = "/users/renew"
+const NODES string = "/nodes"
+
+/* Port of the first user, the others take the next ports, as with a user list file */
+const FIRST_USER_PORT int = 10000
+
+/* How long a registration lasts before the user must renew it */
+var REGISTRATION_TTL time.Duration = 24 * time.Hour
+
+/* How old the timestamp of a renewal may be, so a renewal cannot be replayed later */
+const RENEWAL_WINDOW time.Duration = time.Minute
+
+/* Returned for a renewal of a user that is not registered */
+var ErrUnknownUser = errors.New("user is not registered")
+
+/*
+A user as registered, the record nodes check users against.
+*/
+type Record struct {
+	Port      string `json:"port"`
+	Address   string `json:"address"`
+	PublicKey string `json:"public_key"`
+	Expires   int64  `json:"expires,omitempty"` // In Unix nanoseconds, 0 for a registration that never expires
+	Renewed   int64  `json:"renewed,omitempty"` // Timestamp of the last renewal, see Renewal
+}
+
+/* Returns true if the registration expired by the given time */
+func (record Record) Expired(now time.Time) bool {
+	return record.Expires != 0 && now.UnixNano() >= record.Expires
+}
+
+/*
+Returns true if the record's address is the address of its public key, so
+whoever holds the private key of that key is the user registered.
+*/
+func (record Record) Verify() bool {
+	address, err := wlt.Address(record.PublicKey)
+	return err == nil && address == record.Address
+}
+
+/*
+A user's request to extend its registration by REGISTRATION_TTL. It is
+signed by the user's wallet, see RenewalMessage, so only the user can renew
+its registration.
+*/
+type Renewal struct {
+	Address   string `json:"address"`
+	Timestamp int64  `json:"timestamp"` // Time the renewal was signed, in Unix nanoseconds
+	Signature string `json:"signature"`
+}
+
+/* Return the message a user signs to renew its registration at the given time */
+func RenewalMessage(address string, timestamp int64) string {
+	return "renew:" + address + ":" + strconv.FormatInt(timestamp, 10)
+}
+
+/*
+Check a renewal of the registration of the given record and return the
+record renewed. The renewal must be signed with the registered public key,
+within RENEWAL_WINDOW of now, and after the last renewal, so it cannot be
+replayed.
+*/
+func Renew(record Record, renewal Renewal, now time.Time) (Record, error) {
+	if renewal.Address != record.Address {
+		return record, ErrUnknownUser
+	}
+	age := now.Sub(time.Unix(0, renewal.Timestamp))
+	if age > RENEWAL_WINDOW || age < -RENEWAL_WINDOW || renewal.Timestamp <= record.Renewed {
+		return record, errors.New("renewal is stale or replayed")
+	}
+	if !wlt.Verify(record.PublicKey, RenewalMessage(renewal.Address, renewal.Timestamp), renewal.Signature) {
+		return record, errors.New("renewal is not signed by the registered key")
+	}
+	record.Renewed = renewal.Timestamp
+	record.Expires = now.Add(REGISTRATION_TTL).UnixNano()
+	return record, nil
+}
+
+/*
+The nodes and users registered with a network whose first node listens on
+the Seed port.
+*/
+type Registry struct {
+	Seed string
+
+	mu        sync.Mutex
+	users     []Record
+	nodes     []string
+	last_node int // Last port allocated to a node, never allocated again
+	last_user int // Last port allocated to a user
+	listener  net.Listener
+	server    *http.Server
+}
+
+func NewRegistry(seed string) *Registry {
+	return &Registry{Seed: seed}
+}
+
+/*
+Allocate a port to a new node: the seed port first, then the port after
+the last one allocated, so a node that left never hands its port on.
+*/
+func (registry *Registry) AddNode() (string, error) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	port := registry.last_node + 1
+	if registry.last_node == 0 {
+		seed, err := strconv.Atoi(registry.Seed)
+		if err != nil {
+			return "", fmt.Errorf("seed %q is not a port", registry.Seed)
+		}
+		port = seed
+	}
+	registry.last_node = port
+	registry.nodes = append(registry.nodes, strconv.Itoa(port))
+	return strconv.Itoa(port), nil
+}
+
+/* Forget a node that left the network. Returns false if it was not registered. */
+func (registry *Registry) RemoveNode(port string) bool {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	for i, node := range registry.nodes {
+		if node == port {
+			registry.nodes = append(registry.nodes[:i], registry.nodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+/* Return the ports of the registered nodes, in the order they registered */
+func (registry *Registry) Nodes() []string {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	return append([]string{}, registry.nodes...)
+}
+
+/*
+Register a user by its address and public key, allocating it the port
+after the last user's, until REGISTRATION_TTL from now. A user registering
+again keeps its record, renewed if it had expired. Returns an error if the
+address is not the address of the public key.
+*/
+func (registry *Registry) AddUser(address string, publicKey string) (Record, error) {
+	record := Record{Address: address, PublicKey: publicKey, Expires: time.Now().Add(REGISTRATION_TTL).UnixNano()}
+	if !record.Verify() {
+		return Record{}, errors.New("address is not the address of the public key")
+	}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	for i, registered := range registry.users {
+		if registered.Address == address {
+			if registered.Expired(time.Now()) {
+				registry.users[i].Expires = record.Expires
+			}
+			return registry.users[i], nil
+		}
+	}
+	port := FIRST_USER_PORT
+	if registry.last_user != 0 {
+		port = registry.last_user + 1
+	}
+	registry.last_user = port
+
+	record.Port = strconv.Itoa(port)
+	registry.users = append(registry.users, record)
+	return record, nil
+}
+
+/*
+Renew the registration of a user, see Renew.
+*/
+func (registry *Registry) RenewUser(renewal Renewal) (Record, error) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	for i, registered := range registry.users {
+		if registered.Address == renewal.Address {
+			record, err := Renew(registered, renewal, time.Now())
+			if err != nil {
+				return registered, err
+			}
+			registry.users[i] = record
+			return record, nil
+		}
+	}
+	return Record{}, ErrUnknownUser
+}
+
+/* Return the registered users, in the order they registered */
+func (registry *Registry) Users() []Record {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	return append([]Record{}, registry.users...)
+}
+
+/*
+Handle /users: GET lists the registered users, POST registers the user of
+the Record sent, its port left out, and replies with its record.
+*/
+func (registry *Registry) HandleUsers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, registry.Users())
+	case http.MethodPost:
+		var record Record
+		if json.NewDecoder(r.Body).Decode(&record) != nil || record.Address == "" || record.PublicKey == "" {
+			http.Error(w, "a user registers with its address and public key", http.StatusBadRequest)
+			return
+		}
+		record, err := registry.AddUser(record.Address, record.PublicKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, record)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+/*
+Handle POST /users/renew: renew the registration of the user of the Renewal
+sent and reply with its record. Answers 404 for a user not registered and
+403 for a renewal that is stale, replayed or not signed by the user.
+*/
+func (registry *Registry) HandleRenew(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var renewal Renewal
+	if json.NewDecoder(r.Body).Decode(&renewal) != nil {
+		http.Error(w, "a renewal holds the user's address, a timestamp and a signature", http.StatusBadRequest)
+		return
+	}
+	record, err := registry.RenewUser(renewal)
+	if errors.Is(err, ErrUnknownUser) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	writeJSON(w, record)
+}
+
+/*
+Handle /nodes: GET lists the ports of the registered nodes, POST allocates
+a port to a new node and replies with it, and DELETE /nodes?port= forgets
+the node at that port.
+*/
+func (registry *Registry) HandleNodes(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, registry.Nodes())
+	case http.MethodPost:
+		port, err := registry.AddNode()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, port)
+	case http.MethodDelete:
+		if !registry.RemoveNode(r.URL.Query().Get("port")) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(v)
+}
+
+/* Return the handler of the registry's API */
+func (registry *Registry) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(USERS, registry.HandleUsers)
+	mux.HandleFunc(RENEW, registry.HandleRenew)
+	mux.HandleFunc(NODES, registry.HandleNodes)
+	return mux
+}
+
+/*
+Serve the registry's API on the given port, in the background, or on a
+free port if it is empty or "0". See URL for where it listens.
+*/
+func (registry *Registry) Start(port string) error {
+	if port == "" {
+		port = "0"
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		return err
+	}
+	registry.listener = listener
+	registry.server = &http.Server{Handler: registry.Handler()}
+	go registry.server.Serve(listener)
+	return nil
+}
+
+/* Return the URL the registry serves its API at, once started */
+func (registry *Registry) URL() string {
+	return "http://" + registry.listener.Addr().String()
+}
+
+/* Stop serving the registry's API */
+func (registry *Registry) Stop() error {
+	if registry.server == nil {
+		return nil
+	}
+	return registry.server.Close()
+}
+
+/*
+Returns true if the user list nodes and users were given is the URL of a
+registry rather than the path of a user list file.
+*/
+func IsRegistry(list string) bool {
+	return strings.HasPrefix(list, "http://") || strings.HasPrefix(list, "https://")
+}
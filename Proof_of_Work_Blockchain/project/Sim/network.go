@@ -0,0 +1,209 @@
+package sim
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	help "project/Helpers"
+	nd "project/Node"
+	reg "project/Registry"
+	usr "project/User"
+	"sync"
+	"time"
+)
+
+/*
+A network of in-process nodes and users, grown one at a time, that demos
+and tests drive step by step: each step waits on the condition it expects,
+e.g. a height every node reaches, instead of sleeping for a fixed time.
+*/
+type Network struct {
+	Seed     string       // Port of the first node, the others take the next free ports
+	UserList string       // File or URL of the registry the nodes and users are registered with, see Start
+	Log      *help.Logger // Where the nodes log, help.LOG if nil
+	Faults   *help.Faults // Faults injected into the messages every node serves, none if nil
+	Nodes    []*nd.Node
+	Users    []*usr.User
+
+	registry *reg.Registry // Run by the network itself if UserList was empty
+	mu       sync.Mutex    // Guards Nodes and Users
+}
+
+func NewNetwork(seed string, userList string, log *help.Logger) *Network {
+	return &Network{Seed: seed, UserList: userList, Log: log}
+}
+
+/*
+Register the given number of nodes, all at once, and wait until each holds
+the genesis block of the blockchain they create. If UserList is empty, the
+nodes and users are registered with a registry the network runs in
+process, see the Registry package; if it is a file, it is removed first.
+*/
+func (network *Network) Start(nodes int, timeout time.Duration) error {
+	if nodes < MIN_NODES {
+		return fmt.Errorf("a network needs at least %d nodes, not %d", MIN_NODES, nodes)
+	}
+	if network.UserList == "" {
+		network.registry = reg.NewRegistry(network.Seed)
+		if err := network.registry.Start(""); err != nil {
+			return err
+		}
+		network.UserList = network.registry.URL()
+	} else if !reg.IsRegistry(network.UserList) {
+		if err := os.Remove(network.UserList); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := network.AddNodes(nodes); err != nil {
+		return err
+	}
+	if !network.WaitForHeight(1, timeout) {
+		return fmt.Errorf("the nodes did not create a blockchain within %v", timeout)
+	}
+	return nil
+}
+
+/*
+Register a node with the network. Returns an error if it could not.
+*/
+func (network *Network) AddNode() (*nd.Node, error) {
+	node := &nd.Node{Faults: network.Faults}
+	node.RegisterNode(network.Seed, network.UserList, network.Log)
+	if node.Port == "" {
+		return nil, fmt.Errorf("a node could not register through %s", network.Seed)
+	}
+
+	network.mu.Lock()
+	network.Nodes = append(network.Nodes, node)
+	network.mu.Unlock()
+	return node, nil
+}
+
+/*
+Register the given number of nodes with the network, all at once.
+Returns an error if any could not register.
+*/
+func (network *Network) AddNodes(count int) error {
+	errs := make(chan error, count)
+	var registering sync.WaitGroup
+	for i := 0; i < count; i++ {
+		registering.Add(1)
+		go func() {
+			defer registering.Done()
+			_, err := network.AddNode()
+			errs <- err
+		}()
+	}
+	registering.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* Register a user with the network */
+func (network *Network) AddUser() *usr.User {
+	user := &usr.User{}
+	user.RegisterUser(network.UserList, network.Seed)
+
+	network.mu.Lock()
+	network.Users = append(network.Users, user)
+	network.mu.Unlock()
+	return user
+}
+
+/*
+Have the user submit content to the network.
+Returns false if it could not be sent, see usr.User.SendContent.
+*/
+func (network *Network) Submit(user *usr.User, content string) bool {
+	return user.SendContent(content)
+}
+
+/* Return the nodes of the network, as they are now */
+func (network *Network) nodes() []*nd.Node {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	return append([]*nd.Node{}, network.Nodes...)
+}
+
+/*
+Returns true if every node holds the same blockchain: the same number of
+blocks, up to the same last block, which covers all the blocks before it.
+*/
+func (network *Network) Converged() bool {
+	nodes := network.nodes()
+	var tip []byte
+	for i, node := range nodes {
+		blocks := node.Blockchain.Blocks
+		if i > 0 && len(blocks) != len(nodes[0].Blockchain.Blocks) {
+			return false
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+		if i > 0 && !bytes.Equal(blocks[len(blocks)-1].SelfHash, tip) {
+			return false
+		}
+		tip = blocks[len(blocks)-1].SelfHash
+	}
+	return true
+}
+
+/* Return the height of the blockchain of the first node */
+func (network *Network) Height() int {
+	nodes := network.nodes()
+	if len(nodes) == 0 {
+		return 0
+	}
+	return len(nodes[0].Blockchain.Blocks)
+}
+
+/* Return the height of the blockchain of each node */
+func (network *Network) Heights() []int {
+	heights := []int{}
+	for _, node := range network.nodes() {
+		heights = append(heights, len(node.Blockchain.Blocks))
+	}
+	return heights
+}
+
+/*
+Wait until every node holds the same blockchain of at least the given
+number of blocks, the genesis block included, for up to timeout.
+Returns false if they never did.
+*/
+func (network *Network) WaitForHeight(height int, timeout time.Duration) bool {
+	return network.WaitFor(timeout, func() bool {
+		return network.Converged() && network.Height() >= height
+	})
+}
+
+/*
+Check condition every POLL_INTERVAL until it holds, for up to timeout.
+Returns false if it never did.
+*/
+func (network *Network) WaitFor(timeout time.Duration, condition func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(POLL_INTERVAL)
+	}
+	return true
+}
+
+/* Shut down the nodes of the network, and its registry if it runs one */
+func (network *Network) Stop() {
+	for _, node := range network.nodes() {
+		node.Shutdown()
+	}
+	if network.registry != nil {
+		help.Check(network.registry.Stop())
+	}
+}
@@ -0,0 +1,181 @@
+/*
+The simulator spins up a network of nodes and users in one process and
+drives it at scale: every user submits content at its own rate, delayed at
+random so submissions race each other and nodes mine conflicting blocks.
+Once the users are done, the simulator waits for every node to hold the
+same blockchain, which the conflict handling of the nodes must lead to.
+
+The network it runs on, a Network, may also be driven step by step, as
+main.go does to show conflicts between a few blocks: each step waits for
+the height it leads to instead of sleeping.
+*/
+
+package sim
+
+import (
+	"fmt"
+	"math/rand"
+	help "project/Helpers"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/* How often a network is checked for whether the nodes agree */
+const POLL_INTERVAL time.Duration = 50 * time.Millisecond
+
+/* Nodes of a network, so a blockchain is created, see blockchain.NON_TRIVIAL */
+const MIN_NODES int = 5
+
+/* The parameters of a simulation */
+type Config struct {
+	Nodes    int           // Nodes of the network, at least MIN_NODES
+	Users    int           // Users submitting content
+	Contents int           // Content each user submits
+	Interval time.Duration // Time between two submissions of a user
+	MaxDelay time.Duration // Most each submission is delayed by on top of Interval, at random
+	Seed     string        // Port of the first node, the others take the next free ports
+	UserList string        // File the users are registered in, removed when the simulation starts, or the URL of a registry; empty for a registry of its own
+	Timeout  time.Duration // Time the nodes are given to agree once the users are done
+	RandSeed int64         // Seed of the delays, so a simulation can be replayed
+	Log      *help.Logger  // Where the nodes log, help.LOG if nil
+	Faults   *help.Faults  // Faults injected into the messages every node serves, none if nil
+}
+
+/*
+Return the configuration of a small simulation of MIN_NODES nodes and two
+users, at the given seed port.
+*/
+func DefaultConfig(seed string, userList string) Config {
+	return Config{
+		Nodes:    MIN_NODES,
+		Users:    2,
+		Contents: 3,
+		Interval: 10 * time.Millisecond,
+		MaxDelay: 50 * time.Millisecond,
+		Seed:     seed,
+		UserList: userList,
+		Timeout:  30 * time.Second,
+		RandSeed: 1,
+	}
+}
+
+/* The outcome of a simulation */
+type Result struct {
+	Submitted int           // Content the users submitted
+	Refused   int           // Submissions the users could not send, e.g. too few nodes known
+	Committed int           // Submitted content on the blockchain the nodes agree on
+	Height    int           // Blocks of that blockchain, the genesis block included
+	Elapsed   time.Duration // Time from the first submission until the nodes agreed
+}
+
+/* A simulated network of in-process nodes and users, see Run */
+type Simulation struct {
+	*Network
+	Config Config
+
+	random *rand.Rand
+	mu     sync.Mutex // Guards random
+}
+
+func New(config Config) *Simulation {
+	network := NewNetwork(config.Seed, config.UserList, config.Log)
+	network.Faults = config.Faults
+	return &Simulation{Network: network, Config: config, random: rand.New(rand.NewSource(config.RandSeed))}
+}
+
+/*
+Register the nodes, all at once, and wait until each holds the genesis
+block of the blockchain the network creates.
+*/
+func (sim *Simulation) Start() error {
+	return sim.Network.Start(sim.Config.Nodes, sim.Config.Timeout)
+}
+
+/*
+Register the users and have each submit its content, all users at once.
+Returns the number of submissions and how many of them could not be sent.
+*/
+func (sim *Simulation) submit() (int, int) {
+	for i := 0; i < sim.Config.Users; i++ {
+		sim.AddUser()
+	}
+
+	var submitted, refused int
+	var mu sync.Mutex
+	var submitting sync.WaitGroup
+	for u, user := range sim.Users {
+		u, user := u, user
+		submitting.Add(1)
+		go func() {
+			defer submitting.Done()
+			for c := 0; c < sim.Config.Contents; c++ {
+				time.Sleep(sim.Config.Interval + sim.delay())
+				accepted := sim.Submit(user, Content(u, c))
+
+				mu.Lock()
+				submitted++
+				if !accepted {
+					refused++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	submitting.Wait()
+	return submitted, refused
+}
+
+/*
+Return the content the user at index u submits c-th, unique to the simulation.
+*/
+func Content(u int, c int) string {
+	return "Content " + strconv.Itoa(c) + " of user " + strconv.Itoa(u)
+}
+
+/* Return a random delay of at most MaxDelay */
+func (sim *Simulation) delay() time.Duration {
+	if sim.Config.MaxDelay <= 0 {
+		return 0
+	}
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return time.Duration(sim.random.Int63n(int64(sim.Config.MaxDelay) + 1))
+}
+
+/*
+Start the network, drive the users' submissions, then wait for the nodes to
+agree on their blockchain. Returns an error if the network could not start
+or the nodes still disagree after Timeout; the nodes keep running either
+way until Stop.
+*/
+func (sim *Simulation) Run() (Result, error) {
+	if err := sim.Start(); err != nil {
+		return Result{}, err
+	}
+
+	start := time.Now()
+	var result Result
+	result.Submitted, result.Refused = sim.submit()
+	if !sim.WaitFor(sim.Config.Timeout, sim.Converged) {
+		return result, fmt.Errorf("the nodes did not agree within %v, heights %v", sim.Config.Timeout, sim.Heights())
+	}
+	result.Elapsed = time.Since(start)
+
+	blocks := sim.Nodes[0].Blockchain.Blocks
+	result.Height = len(blocks)
+	committed := map[string]bool{}
+	for _, block := range blocks {
+		for _, entry := range block.Entries {
+			committed[string(entry)] = true
+		}
+	}
+	for u := range sim.Users {
+		for c := 0; c < sim.Config.Contents; c++ {
+			if committed[Content(u, c)] {
+				result.Committed++
+			}
+		}
+	}
+	return result, nil
+}
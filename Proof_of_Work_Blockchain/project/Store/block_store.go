@@ -0,0 +1,230 @@
+/*
+The block store keeps a node's blockchain on disk, so a restarted node can
+reload it instead of copying the whole chain from its peers.
+
+Blocks are stored as flat append-only files, one JSON encoded block per line.
+Accepted blocks are appended to the file. When a node adopts another chain,
+the file is rewritten instead.
+*/
+
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	blk "project/Block"
+	"sync"
+)
+
+/* Blocks are stored in STORE_DIR/Blocks_<node port>.jsonl, see the node's --data flag */
+var STORE_DIR string = "/tmp/"
+
+/*
+The blocks of one node's blockchain, stored in a file.
+*/
+type BlockStore struct {
+	Path string
+
+	mu     sync.Mutex
+	stored int    // Number of blocks in the file
+	tip    []byte // Hash of the last block in the file
+}
+
+/*
+Return the block store of the node at the given port.
+*/
+func NewBlockStore(port string) *BlockStore {
+	return &BlockStore{Path: filepath.Join(STORE_DIR, "Blocks_"+port+".jsonl")}
+}
+
+/*
+Read the stored blockchain. A torn last line, left by a crash while appending,
+and blocks that do not chain to the previous one are dropped from the file.
+*/
+func (store *BlockStore) Load() ([]*blk.Block, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	file, err := os.Open(store.Path)
+	if os.IsNotExist(err) {
+		store.stored, store.tip = 0, nil
+		return []*blk.Block{}, nil // No blocks stored yet
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	blocks := []*blk.Block{}
+	valid := int64(0) // Length of the file up to the last good block
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			break // End of file, or a line without its newline
+		}
+
+		var block blk.Block
+		if json.Unmarshal(line, &block) != nil {
+			break
+		}
+		if len(blocks) > 0 && !bytes.Equal(blocks[len(blocks)-1].SelfHash, block.PrevBlockHash) {
+			break
+		}
+
+		blocks = append(blocks, &block)
+		valid += int64(len(line))
+	}
+
+	// Drop whatever follows the last good block, so appends continue from it
+	if err := os.Truncate(store.Path, valid); err != nil {
+		return nil, err
+	}
+
+	store.stored, store.tip = len(blocks), nil
+	if len(blocks) > 0 {
+		store.tip = blocks[len(blocks)-1].SelfHash
+	}
+
+	return blocks, nil
+}
+
+/*
+Write the blockchain to the file. If the file holds the start of the
+blockchain, only the new blocks are appended, otherwise the file is replaced.
+*/
+func (store *BlockStore) Save(blocks []*blk.Block) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	extends := store.stored <= len(blocks) &&
+		(store.stored == 0 || bytes.Equal(blocks[store.stored-1].SelfHash, store.tip))
+
+	if extends && store.stored == len(blocks) {
+		return nil // Nothing new
+	}
+
+	var err error
+	if extends {
+		err = store.appendBlocks(blocks[store.stored:])
+	} else {
+		err = store.rewrite(blocks)
+	}
+	if err != nil {
+		return err
+	}
+
+	store.stored, store.tip = len(blocks), nil
+	if len(blocks) > 0 {
+		store.tip = blocks[len(blocks)-1].SelfHash
+	}
+	return nil
+}
+
+/*
+Replace the stored blockchain with blocks, even when they end with the
+stored tip, e.g. once a pruned node replaced old blocks by their headers.
+*/
+func (store *BlockStore) Rewrite(blocks []*blk.Block) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if err := store.rewrite(blocks); err != nil {
+		return err
+	}
+	store.stored, store.tip = len(blocks), nil
+	if len(blocks) > 0 {
+		store.tip = blocks[len(blocks)-1].SelfHash
+	}
+	return nil
+}
+
+/*
+Delete the stored blockchain, e.g. when a new node registers at the port
+of a node that was removed.
+*/
+func (store *BlockStore) Reset() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.stored, store.tip = 0, nil
+	if err := os.Remove(store.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+/*
+Encode blocks as JSON lines.
+*/
+func encodeBlocks(blocks []*blk.Block) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer) // Encode ends each block with a newline
+	for _, block := range blocks {
+		if err := encoder.Encode(block); err != nil {
+			return nil, err
+		}
+	}
+	return buffer.Bytes(), nil
+}
+
+/*
+Append blocks to the file and flush them to disk.
+*/
+func (store *BlockStore) appendBlocks(blocks []*blk.Block) error {
+	data, err := encodeBlocks(blocks)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(store.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+/*
+Replace the file with the given blocks, see writeFile, so a crash never
+leaves a mix of both chains.
+*/
+func (store *BlockStore) rewrite(blocks []*blk.Block) error {
+	data, err := encodeBlocks(blocks)
+	if err != nil {
+		return err
+	}
+	return writeFile(store.Path, data)
+}
+
+/*
+Replace the file at path with data. A new file is written then renamed,
+so a crash never leaves a mix of the old and new data.
+*/
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,116 @@
+/*
+The work store keeps what a node has yet to mine on disk: the content in
+its mempool and the block it is mining, with the nonces already tried, so a
+node that crashed resumes its mining instead of dropping the content its
+users sent.
+
+The work is stored as a single JSON file, replaced whenever it changes.
+*/
+
+package store
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	blk "project/Block"
+	"sync"
+)
+
+/* Content a node queued to be mined, the user who sent it, its ID and the fee it pays */
+type PendingContent struct {
+	Content string `json:"content"`
+	Author  string `json:"author"`
+	ID      string `json:"id"`
+	Fee     uint64 `json:"fee"`
+}
+
+/*
+The block a node is mining, the content it takes from the mempool, and
+where each miner's search for a nonce got to.
+*/
+type MiningJob struct {
+	Content  []PendingContent `json:"content"`
+	Block    *blk.Block       `json:"block,omitempty"`    // Not sealed yet, nil until the block is prepared
+	Progress []int            `json:"progress,omitempty"` // Next nonce of each miner, see pow.Engine.Resume
+}
+
+/* What a node has yet to mine */
+type Work struct {
+	Pending []PendingContent `json:"pending"`
+	Job     *MiningJob       `json:"job,omitempty"`
+}
+
+/* Return all the content left to mine, the content of the mined block first */
+func (work Work) Content() []PendingContent {
+	if work.Job == nil {
+		return work.Pending
+	}
+	return append(append([]PendingContent{}, work.Job.Content...), work.Pending...)
+}
+
+/* Returns true if there is nothing left to mine */
+func (work Work) Empty() bool {
+	return len(work.Pending) == 0 && (work.Job == nil || len(work.Job.Content) == 0)
+}
+
+/*
+The work of one node, stored in a file.
+*/
+type WorkStore struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+/*
+Return the work store of the node at the given port, in STORE_DIR/Work_<node port>.json.
+*/
+func NewWorkStore(port string) *WorkStore {
+	return &WorkStore{Path: filepath.Join(STORE_DIR, "Work_"+port+".json")}
+}
+
+/*
+Read the stored work, empty if none was stored.
+*/
+func (store *WorkStore) Load() (Work, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var work Work
+	data, err := os.ReadFile(store.Path)
+	if os.IsNotExist(err) {
+		return work, nil
+	} else if err != nil {
+		return work, err
+	}
+	return work, json.Unmarshal(data, &work)
+}
+
+/*
+Replace the stored work, see writeFile.
+*/
+func (store *WorkStore) Save(work Work) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	data, err := json.Marshal(work)
+	if err != nil {
+		return err
+	}
+	return writeFile(store.Path, data)
+}
+
+/*
+Delete the stored work, e.g. when a new node registers at the port of a
+node that was removed.
+*/
+func (store *WorkStore) Reset() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if err := os.Remove(store.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
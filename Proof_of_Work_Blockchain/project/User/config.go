@@ -0,0 +1,43 @@
+package user
+
+import (
+	"fmt"
+	blk "project/Block"
+	cfg "project/Config"
+	help "project/Helpers"
+)
+
+/*
+Apply the runtime configuration to the users of this process: the seed
+node and user list they register with, where receipts and off-chain
+content are stored, how often receipts are checked, the size of the
+content nodes take, the fees content pays for its size, the quorum of
+nodes a light client trusts, and the network the users belong to. Call it before
+registering users.
+*/
+func Configure(config cfg.Config) error {
+	if config.ReceiptCheckTime <= 0 || config.ResubmitBackoff <= 0 || config.OffchainSize < 0 {
+		return fmt.Errorf("receipt times must be positive and the off-chain size not negative")
+	}
+	if config.MaxContentSize <= 0 {
+		return fmt.Errorf("max content size must be positive")
+	}
+	if err := help.ValidQuorum(config.Quorum); err != nil {
+		return err
+	}
+
+	SEED = config.SeedPort
+	USER_LIST = config.UserList
+	RECEIPT_DIR = config.ReceiptDir
+	help.BLOB_STORE = help.DirBlobStore{Dir: config.BlobDir}
+
+	receipt_check_time = config.ReceiptCheckTime
+	resubmit_backoff = config.ResubmitBackoff
+	OFFCHAIN_SIZE = config.OffchainSize
+	blk.MAX_CONTENT_SIZE = config.MaxContentSize
+	blk.FEE_PER_BYTE = config.FeePerByte
+	blk.MIN_FEE = config.MinFee
+	help.QUORUM = config.Quorum
+	help.NETWORK_ID = config.NetworkID
+	return nil
+}
@@ -0,0 +1,86 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	help "project/Helpers"
+)
+
+/* Path of a user's HandleConfirmation, appended to its Callback */
+const CONFIRMATION string = "/confirmation"
+
+/* The confirmation of content as returned by a node's /receipt/{id} and /status?content_id=, and posted to callbacks */
+type ContentStatus struct {
+	ContentID     string `json:"content_id"`
+	Found         bool   `json:"found"`
+	Index         int    `json:"index"`
+	BlockHash     string `json:"block_hash"`
+	Confirmations int    `json:"confirmations"` // Blocks from the content's block up to the tip, both included
+}
+
+/*
+Ask the node at port for the confirmation of the content with the given ID,
+see blk.ContentID, or with the given SHA-256 of the content, hex encoded.
+Returns false if the node could not be reached.
+*/
+func GetContentStatus(port string, contentID string) (ContentStatus, bool) {
+	var status ContentStatus
+	return status, callNode(port, STATUS+"?content_id="+contentID, struct{}{}, &status)
+}
+
+/*
+Ask the node at port for the receipt of the content with the given ID,
+see blk.ContentID. Returns false if the node could not be reached.
+*/
+func GetReceipt(port string, contentID string) (ContentStatus, bool) {
+	var status ContentStatus
+	resp, err := help.HTTP_CLIENT.Get(help.NodeURL(port) + RECEIPT + "/" + contentID)
+	if help.Check(err) {
+		return status, false
+	}
+	defer help.CloseBody(resp)
+
+	// Content that is not on the blockchain comes with 404 Not Found
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return status, false
+	}
+	return status, !help.Check(json.NewDecoder(resp.Body).Decode(&status))
+}
+
+/*
+Handle a node's confirmation that content this user submitted was
+committed: the matching submission is confirmed and the receipts saved.
+A light client does not trust confirmations, it still checks the
+inclusion proof of its content with CheckReceipts.
+*/
+func (user *User) HandleConfirmation(w http.ResponseWriter, r *http.Request) {
+	var status ContentStatus
+	if help.Check(json.NewDecoder(r.Body).Decode(&status)) || !status.Found {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if user.Light {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	receipts_mutex.Lock()
+	defer receipts_mutex.Unlock()
+
+	for _, submission := range user.Receipts {
+		if submission.ContentID != status.ContentID && submission.ContentHash != status.ContentID {
+			continue
+		}
+		if !submission.Confirmed {
+			user.logger().Infof("content { %s } is in block %d (%d confirmations)", submission.Content, status.Index, status.Confirmations)
+		}
+		submission.Confirmed = true
+		submission.Index = status.Index
+		submission.BlockHash = status.BlockHash
+		submission.Confirmations = status.Confirmations
+		user.saveReceipts()
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
@@ -0,0 +1,49 @@
+package user
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	blk "project/Block"
+	help "project/Helpers"
+	wlt "project/Wallet"
+)
+
+const DIFFICULTY_CHANGE string = "/admin/difficulty"
+
+/* A difficulty change as queued by a node's /admin/difficulty */
+type DifficultyChangeStatus struct {
+	ID         string `json:"id"`
+	ContentID  string `json:"content_id"` // Of the entry committing the change, see GetReceipt
+	Difficulty int    `json:"difficulty"`
+}
+
+/*
+Sign a change of the network's difficulty with the governor's wallet and
+send it to the node at port, which gossips it to its peers. The blocks
+following the block committing it declare the new difficulty. Returns
+false if the node refused the change, e.g. the wallet is not one of its
+governors, see blk.GOVERNORS.
+*/
+func ChangeDifficulty(port string, governor *wlt.Wallet, difficulty int) (DifficultyChangeStatus, bool) {
+	change, err := blk.NewDifficultyChange(governor, difficulty)
+	if help.Check(err) {
+		return DifficultyChangeStatus{}, false
+	}
+	jsonBytes, err := json.Marshal(change)
+	if help.Check(err) {
+		return DifficultyChangeStatus{}, false
+	}
+
+	resp, err := help.HTTP_CLIENT.Post(help.NodeURL(port)+DIFFICULTY_CHANGE, "application/json", bytes.NewBuffer(jsonBytes))
+	if help.Check(err) {
+		return DifficultyChangeStatus{}, false
+	}
+	defer help.CloseBody(resp)
+
+	var status DifficultyChangeStatus
+	if resp.StatusCode != http.StatusAccepted || help.Check(json.NewDecoder(resp.Body).Decode(&status)) {
+		return DifficultyChangeStatus{}, false
+	}
+	return status, true
+}
@@ -0,0 +1,213 @@
+package user
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	blk "project/Block"
+	bc "project/Blockchain"
+	help "project/Helpers"
+	"strconv"
+)
+
+const PROOF string = "/proof"
+const COPY_HEADERS string = "/copy_chain?headers=true"
+
+/* An inclusion proof as returned by a node's /proof */
+type InclusionProof struct {
+	Found      bool             `json:"found"`
+	Index      int              `json:"index"`
+	BlockHash  string           `json:"block_hash"`
+	Entry      []byte           `json:"entry"`
+	Proof      []blk.MerkleStep `json:"proof"`
+	ContentIDs []string         `json:"content_ids,omitempty"` // Of the block, in a proof by content ID
+}
+
+/*
+Replace this light client's headers by the headers a majority of the
+known nodes agree on, once checked to link up by their hashes and to
+carry a valid Proof of Work. Returns false if there is no such majority.
+*/
+func (user *User) SyncHeaders() bool {
+	known_nodes := KnownNodes()
+
+	// Nodes holding the same headers reply with the same body
+	counts := map[string]int{}
+	majority := ""
+	for _, port := range known_nodes {
+		resp, err := help.HTTP_CLIENT.Get(help.NodeURL(port) + COPY_HEADERS)
+		if help.Check(err) {
+			continue
+		}
+		body, err := io.ReadAll(resp.Body)
+		help.CloseBody(resp)
+		if help.Check(err) || resp.StatusCode != 200 {
+			continue
+		}
+
+		counts[string(body)]++
+		if counts[string(body)] >= help.Quorum(len(known_nodes)) {
+			majority = string(body)
+			break
+		}
+	}
+	if majority == "" {
+		return false
+	}
+
+	var headers bc.Blockchain
+	if help.Check(json.Unmarshal([]byte(majority), &headers)) || !validHeaders(headers.Blocks) {
+		return false
+	}
+
+	headers_mutex.Lock()
+	user.Headers = headers.Blocks
+	headers_mutex.Unlock()
+	return true
+}
+
+/*
+Return true if each header follows the previous one by its index and hash
+and carries a valid Proof of Work.
+*/
+func validHeaders(headers []*blk.Block) bool {
+	var tip []byte
+	for i, header := range headers {
+		if header.Index != i || (i > 0 && !bytes.Equal(header.PrevBlockHash, tip)) || !header.Validate() {
+			return false
+		}
+		tip = header.SelfHash
+	}
+	return true
+}
+
+/*
+Return the header this light client holds at index, syncing its headers
+first if it does not hold it yet.
+*/
+func (user *User) header(index int) (*blk.Block, bool) {
+	headers_mutex.Lock()
+	headers := user.Headers
+	headers_mutex.Unlock()
+
+	if index >= len(headers) {
+		if !user.SyncHeaders() {
+			return nil, false
+		}
+		headers_mutex.Lock()
+		headers = user.Headers
+		headers_mutex.Unlock()
+	}
+	if index < 0 || index >= len(headers) {
+		return nil, false
+	}
+	return headers[index], true
+}
+
+/*
+Return true if proof shows that content with the given hash is on the
+blockchain: the entry hashes to it, and its Merkle proof leads to the
+Merkle root of the header at the proof's index.
+*/
+func (user *User) VerifyInclusion(contentHash string, proof InclusionProof) bool {
+	hash := sha256.Sum256(proof.Entry)
+	if !proof.Found || hex.EncodeToString(hash[:]) != contentHash {
+		return false
+	}
+
+	header, found := user.header(proof.Index)
+	if !found || hex.EncodeToString(header.SelfHash) != proof.BlockHash {
+		return false
+	}
+	return blk.VerifyMerkleProof(proof.Entry, proof.Proof, header.MerkleRoot)
+}
+
+/*
+Return true if proof shows that the content committed under contentID is in
+its block: the block's content IDs hash to the ones of the header at the
+proof's index, and the Merkle proof leads from the entry at the content
+ID's position to the header's Merkle root.
+*/
+func (user *User) VerifyContentProof(contentID string, proof InclusionProof) bool {
+	if !proof.Found {
+		return false
+	}
+	header, found := user.header(proof.Index)
+	if !found || hex.EncodeToString(header.SelfHash) != proof.BlockHash {
+		return false
+	}
+
+	block := blk.Block{ContentIDs: proof.ContentIDs}
+	position := block.EntryWithID(contentID)
+	if position < 0 || !bytes.Equal(block.ContentIDsHash(), header.ContentIDsHash()) ||
+		blk.MerkleProofPosition(proof.Proof, len(proof.ContentIDs)) != position {
+		return false
+	}
+	return blk.VerifyMerkleProof(proof.Entry, proof.Proof, header.MerkleRoot)
+}
+
+/*
+Ask the node at port for the inclusion proof of the content committed under
+contentID in the block at the given index, and check it against this light
+client's headers, see VerifyContentProof. Returns false if the node could
+not be reached or its proof is invalid, and a proof that is not found if
+the block does not hold the content.
+*/
+func (user *User) ProveContent(port string, block int, contentID string) (InclusionProof, bool) {
+	var proof InclusionProof
+	query := url.Values{"block": {strconv.Itoa(block)}, "content_id": {contentID}}
+	resp, err := help.HTTP_CLIENT.Get(help.NodeURL(port) + PROOF + "?" + query.Encode())
+	if help.Check(err) {
+		return proof, false
+	}
+	defer help.CloseBody(resp)
+
+	// Content that is not in the block comes with 404 Not Found
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return proof, false
+	}
+	if help.Check(json.NewDecoder(resp.Body).Decode(&proof)) {
+		return proof, false
+	}
+	if proof.Found && !user.VerifyContentProof(contentID, proof) {
+		user.logger().Warnf("node %s sent an invalid inclusion proof for block %d", port, block)
+		return InclusionProof{}, false
+	}
+	return proof, true
+}
+
+/*
+Ask the node at port for the receipt of submitted content, by its content
+ID if it has one. A light client asks for the inclusion proof of the
+content instead, and only trusts the receipt once the proof checks out
+against its headers.
+Returns false if the node could not be reached or its proof is invalid.
+*/
+func (user *User) receipt(port string, submission *Submission) (ContentStatus, bool) {
+	request := ReceiptRequest{ContentHash: submission.ContentHash, After: submission.After}
+	if !user.Light {
+		if submission.ContentID != "" {
+			return GetReceipt(port, submission.ContentID)
+		}
+		var receipt Receipt
+		ok := callNode(port, RECEIPT, request, &receipt)
+		return ContentStatus{Found: receipt.Found, Index: receipt.Index, BlockHash: receipt.BlockHash}, ok
+	}
+
+	var proof InclusionProof
+	if !callNode(port, PROOF, request, &proof) {
+		return ContentStatus{}, false
+	}
+	if !proof.Found {
+		return ContentStatus{}, true
+	}
+	if !user.VerifyInclusion(request.ContentHash, proof) {
+		user.logger().Warnf("node %s sent an invalid inclusion proof for block %d", port, proof.Index)
+		return ContentStatus{}, false
+	}
+	return ContentStatus{Found: true, Index: proof.Index, BlockHash: proof.BlockHash}, true
+}
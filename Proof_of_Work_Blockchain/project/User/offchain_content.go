@@ -0,0 +1,28 @@
+package user
+
+import (
+	blk "project/Block"
+	help "project/Helpers"
+)
+
+/*
+Content longer than this many bytes is stored off-chain, in help.BLOB_STORE,
+and only its hash and location are sent to be mined. 0 keeps all content on-chain.
+*/
+var OFFCHAIN_SIZE int = 1024
+
+/*
+Put content in the blob store and return the reference to send in its place.
+Returns false if the blob store could not store it.
+*/
+func (user *User) StoreOffChain(content string) (string, bool) {
+	body := []byte(content)
+	hash := blk.ContentHash(body)
+
+	location, err := help.BLOB_STORE.Put(hash, body)
+	if help.Check(err) {
+		return "", false
+	}
+
+	return blk.ContentRef{Hash: hash, Location: location}.String(), true
+}
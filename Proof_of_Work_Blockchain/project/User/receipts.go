@@ -0,0 +1,291 @@
+package user
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math/rand"
+	"os"
+	"path/filepath"
+	blk "project/Block"
+	help "project/Helpers"
+	"time"
+)
+
+const RECEIPT string = "/receipt"
+const STATUS string = "/status"
+
+/* Receipts are stored in RECEIPT_DIR/Receipts_<user port>.json, cmd/user keeps them next to the wallet */
+var RECEIPT_DIR string = "/tmp/"
+
+/* Time between checks of the pending receipts */
+var receipt_check_time time.Duration = 500 * time.Millisecond
+
+/* Time to wait for content before the first resubmission, doubled after each one */
+var resubmit_backoff time.Duration = 2000 * time.Millisecond
+
+/* Times content is sent before giving up on it */
+const MAX_ATTEMPTS int = 5
+
+/*
+The receipt of content a user submitted, kept until the content is
+found on the blockchain.
+*/
+type Submission struct {
+	Content     string    `json:"content"`
+	ContentHash string    `json:"content_hash"` // SHA-256 of the content, hex encoded
+	After       int       `json:"after"`        // Index of the last block when first submitted
+	SentAt      time.Time `json:"sent_at"`      // Last time the content was sent
+	Attempts    int       `json:"attempts"`     // Times the content was sent
+	Confirmed   bool      `json:"confirmed"`    // The content is on the blockchain
+	Index       int       `json:"index"`        // Index of the block holding the content
+	BlockHash   string    `json:"block_hash"`
+
+	// The durable reference to the content, see blk.ContentID, derived from the
+	// time it was first sent. Empty in receipts recorded before content IDs.
+	ContentID string `json:"content_id,omitempty"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+
+	// Confirmations of the block when a node last confirmed the content, see HandleConfirmation
+	Confirmations int `json:"confirmations,omitempty"`
+}
+
+/* A request for a node's /receipt */
+type ReceiptRequest struct {
+	ContentHash string `json:"content_hash"`
+	After       int    `json:"after"`
+}
+
+/* A receipt as returned by a node's /receipt */
+type Receipt struct {
+	Found     bool   `json:"found"`
+	Index     int    `json:"index"`
+	BlockHash string `json:"block_hash"`
+}
+
+/* The part of a node's /status users need */
+type NodeStatus struct {
+	Height int `json:"height"`
+}
+
+/*
+Return the file this user's receipts are stored in.
+*/
+func (user *User) ReceiptFile() string {
+	return filepath.Join(RECEIPT_DIR, "Receipts_"+user.Port+".json")
+}
+
+/*
+Load this user's receipts from its receipt file, if it has one.
+*/
+func (user *User) LoadReceipts() {
+	receipts_mutex.Lock()
+	defer receipts_mutex.Unlock()
+
+	data, err := os.ReadFile(user.ReceiptFile())
+	if err != nil {
+		return // No receipts yet
+	}
+
+	var receipts []*Submission
+	if help.Check(json.Unmarshal(data, &receipts)) {
+		return
+	}
+	user.Receipts = receipts
+}
+
+/*
+Write this user's receipts to its receipt file. Must be called while
+holding receipts_mutex.
+*/
+func (user *User) saveReceipts() {
+	data, err := json.Marshal(user.Receipts)
+	if help.Check(err) {
+		return
+	}
+
+	// Write a new file then rename it, so a crash never leaves half a file
+	tmp := user.ReceiptFile() + ".tmp"
+	if help.Check(os.WriteFile(tmp, data, 0644)) {
+		return
+	}
+	help.Check(os.Rename(tmp, user.ReceiptFile()))
+}
+
+/*
+Record that content is about to be sent at the given time, in nanoseconds,
+while the blockchain's last block has the given index.
+*/
+func (user *User) RecordSubmission(content string, after int, timestamp int64) {
+	hash := sha256.Sum256([]byte(content))
+
+	receipts_mutex.Lock()
+	defer receipts_mutex.Unlock()
+
+	user.Receipts = append(user.Receipts, &Submission{
+		Content:     content,
+		ContentHash: hex.EncodeToString(hash[:]),
+		After:       after,
+		SentAt:      time.Now(),
+		Attempts:    1,
+		ContentID:   blk.ContentID(content, user.Address, timestamp),
+		Timestamp:   timestamp,
+	})
+	user.saveReceipts()
+}
+
+/*
+Return a copy of the receipts of this user, as they are at once. Nodes
+confirm content to HandleConfirmation while they are read.
+*/
+func (user *User) Submissions() []Submission {
+	receipts_mutex.Lock()
+	defer receipts_mutex.Unlock()
+
+	submissions := make([]Submission, len(user.Receipts))
+	for i, submission := range user.Receipts {
+		submissions[i] = *submission
+	}
+	return submissions
+}
+
+/*
+Return the time content was first sent, if this user has its receipt.
+*/
+func (user *User) submittedAt(content string) (int64, bool) {
+	receipts_mutex.Lock()
+	defer receipts_mutex.Unlock()
+
+	for _, submission := range user.Receipts {
+		if submission.Content == content && submission.Timestamp != 0 {
+			return submission.Timestamp, true
+		}
+	}
+	return 0, false
+}
+
+/*
+Send an http request to a node and decode its JSON response into response.
+Returns false if the node could not be reached.
+*/
+func callNode(port string, uri string, body interface{}, response interface{}) bool {
+	jsonBytes, err := json.Marshal(body)
+	if help.Check(err) {
+		return false
+	}
+
+	resp, err := help.HTTP_CLIENT.Post(help.NodeURL(port)+uri, "application/json", bytes.NewBuffer(jsonBytes))
+	if help.Check(err) {
+		return false
+	}
+	defer help.CloseBody(resp)
+
+	return !help.Check(json.NewDecoder(resp.Body).Decode(response))
+}
+
+/*
+Return the index of the last block on a random node's blockchain,
+or -1 if no node answers.
+*/
+func LastBlockIndex() int {
+	known_nodes := KnownNodes()
+	if len(known_nodes) == 0 {
+		return -1
+	}
+
+	var status NodeStatus
+	if !callNode(known_nodes[rand.Intn(len(known_nodes))], STATUS, struct{}{}, &status) {
+		return -1
+	}
+	return status.Height - 1
+}
+
+/*
+Check the receipts of this user's unconfirmed content with a random node.
+Content found on the blockchain is confirmed, for a light client once its
+inclusion proof checks out. Content that is still
+missing once its backoff is over was dropped, e.g. in a conflict,
+and is sent again, until MAX_ATTEMPTS is reached.
+Returns the number of submissions still waiting to be confirmed.
+*/
+func (user *User) CheckReceipts() int {
+	known_nodes := KnownNodes()
+	if len(known_nodes) == 0 {
+		return 0
+	}
+
+	receipts_mutex.Lock()
+	unconfirmed := []*Submission{}
+	for _, submission := range user.Receipts {
+		if !submission.Confirmed {
+			unconfirmed = append(unconfirmed, submission)
+		}
+	}
+	receipts_mutex.Unlock()
+
+	pending := 0
+	for _, submission := range unconfirmed {
+		port := known_nodes[rand.Intn(len(known_nodes))]
+		receipt, ok := user.receipt(port, submission)
+		if !ok {
+			pending++
+			continue // Try another node next time
+		}
+
+		receipts_mutex.Lock()
+		resubmit := false
+		if receipt.Found {
+			submission.Confirmed = true
+			submission.Index = receipt.Index
+			submission.BlockHash = receipt.BlockHash
+			submission.Confirmations = receipt.Confirmations
+			user.logger().Infof("content { %s } is in block %d", submission.Content, receipt.Index)
+		} else if submission.Attempts < MAX_ATTEMPTS {
+			backoff := resubmit_backoff << (submission.Attempts - 1)
+			if time.Since(submission.SentAt) >= backoff {
+				submission.Attempts++
+				submission.SentAt = time.Now()
+				resubmit = true
+			}
+			pending++
+		}
+		user.saveReceipts()
+		receipts_mutex.Unlock()
+
+		if resubmit {
+			user.logger().Warnf("content { %s } was dropped, resubmitting (attempt %d)", submission.Content, submission.Attempts)
+			user.sendContent(submission.Content, submission.Timestamp)
+		}
+	}
+
+	return pending
+}
+
+/*
+Check this user's receipts until all its content is confirmed or given up
+on, resubmitting dropped content along the way.
+*/
+func (user *User) WatchReceipts() {
+	for {
+		time.Sleep(receipt_check_time)
+		if user.CheckReceipts() == 0 {
+			return
+		}
+	}
+}
+
+/*
+Like WatchReceipts, but gives up once timeout passed.
+Returns the number of submissions still waiting to be confirmed.
+*/
+func (user *User) WatchReceiptsFor(timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	for {
+		time.Sleep(receipt_check_time)
+		pending := user.CheckReceipts()
+		if pending == 0 || time.Now().After(deadline) {
+			return pending
+		}
+	}
+}
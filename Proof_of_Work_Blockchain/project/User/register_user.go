@@ -1,38 +1,79 @@
 package user
 
 import (
-	"fmt"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
 	help "project/Helpers"
+	reg "project/Registry"
+	wlt "project/Wallet"
 	"strconv"
+	"time"
 )
 
 /*
 RegisterUser a user to the given user list. Must be thread safe.
 
-Limitation:
 Registration is required so users can be distinguished and only
-known users can send content to the blockchain. Usually, a blockchain
-would use a Private/Public key infrastructure to track user's across
-time (for example, when counting wallet amounts). Instead, we use
-a simple registration file on our local machine. This file is accessible
-by nodes who check if the user is on the list before accepting their content.
+known users can send content to the blockchain. Each user gets a wallet,
+an ECDSA key pair, and is registered by the address derived from its public
+key. The user list is a registry file on our local machine holding each
+user's port, address and public key. This file is accessible by nodes who
+check if the user's address is on the list before accepting their content.
+The user list may also be the URL of a registry, which allocates the port,
+see the Registry package.
 */
-func (user *User) RegisterUser(UserList string, NodeList string) {
+func (user *User) RegisterUser(UserList string, Seed string) {
+	wallet, err := wlt.NewWallet()
+	if help.Check(err) {
+		return // Could not generate a key pair
+	}
+	user.wallet = wallet
+	user.Address = wallet.Address
+	user.PublicKey = wallet.PublicKey
+
+	if reg.IsRegistry(UserList) {
+		record, err := reg.RegisterUser(UserList, user.Address, user.PublicKey)
+		if help.Check(err) {
+			return // Registry is not reachable
+		}
+		user.Port, user.Expires = record.Port, record.Expires
+	} else if !registerInFile(user, UserList) {
+		return
+	}
+
+	// Set the UserList and Seed constants
+	USER_LIST = UserList
+	SEED = Seed
+
+	// Pick up the receipts of an earlier user on this port
+	user.LoadReceipts()
+
+	user.logger().Infof("registered with address %s", user.Address)
+}
+
+/*
+Register the user on the user list file, choosing the port after the last
+registered user's. Returns false if the list holds an invalid port.
+*/
+func registerInFile(user *User, UserList string) bool {
 	registration_mutex.Lock()
+	defer registration_mutex.Unlock()
 
 	// Read from the UserList
-	known_ports := help.GetPorts(UserList)
+	known_users := LoadUserList(UserList)
 
 	// Initial port choice
 	chosen_port := 10000
 
 	// If there are users already registered
-	if len(known_ports) > 0 {
+	if len(known_users) > 0 {
 		/* Choose a port number */
-		init := known_ports[len(known_ports)-1] // Initialize port choice to last known port
-		chosen_port, err := strconv.Atoi(init)  // Convert initial port choice to int
+		init := known_users[len(known_users)-1].Port // Initialize port choice to last known port
+		chosen_port, err := strconv.Atoi(init)       // Convert initial port choice to int
 		if help.Check(err) {
-			return // Error while converting to int
+			return false // Error while converting to int
 		}
 		chosen_port++ // Increment chosen port by 1
 
@@ -42,30 +83,172 @@ func (user *User) RegisterUser(UserList string, NodeList string) {
 		user.Port = strconv.Itoa(chosen_port) // Set the user's port to initial port 5000
 	}
 
-	// Add the user to the list
-	help.RegisterPort(user.Port, UserList)
+	// Add the user to the list, until its registration expires
+	user.Expires = time.Now().Add(reg.REGISTRATION_TTL).UnixNano()
+	known_users = append(known_users, UserRecord{Port: user.Port, Address: user.Address, PublicKey: user.PublicKey, Expires: user.Expires})
+	saveUserList(UserList, known_users)
+	return true
+}
+
+/*
+Write this user's wallet to a file, so it can be loaded back with LoadUser.
+Returns false if the user has no wallet, i.e. it was not registered.
+*/
+func (user *User) SaveWallet(path string) bool {
+	if user.wallet == nil {
+		return false
+	}
+	return !help.Check(user.wallet.Save(path))
+}
+
+/*
+Return the user whose wallet was saved at path, as registered on the
+given user list, with its receipts.
+Returns false if the wallet cannot be read or the user is not registered.
+*/
+func LoadUser(UserList string, Seed string, path string) (*User, bool) {
+	wallet, err := wlt.LoadWallet(path)
+	if help.Check(err) {
+		return nil, false
+	}
 
-	registration_mutex.Unlock()
+	record, found := FindUser(UserList, wallet.Address)
+	if !found {
+		return nil, false
+	}
 
-	// Set the NodeList and UserList constants
 	USER_LIST = UserList
-	NODE_LIST = NodeList
+	SEED = Seed
 
-	fmt.Printf("Successfully registered a User at port %s\n", user.Port)
+	user := &User{Port: record.Port, Address: wallet.Address, PublicKey: wallet.PublicKey, Expires: record.Expires, wallet: wallet}
+	user.LoadReceipts()
+	return user, true
 }
 
 /*
-Returns true if the user is registered on the UserList
+Read the users registered on the UserList, or with the registry it is the URL of.
 */
-func (user *User) IsUserRegistered() bool {
-	// Read from the NodeList
-	known_ports := help.GetPorts(USER_LIST)
+func LoadUserList(UserList string) []UserRecord {
+	if reg.IsRegistry(UserList) {
+		users, err := reg.GetUsers(UserList)
+		if help.Check(err) {
+			return []UserRecord{}
+		}
+		return users
+	}
+
+	data, err := os.ReadFile(UserList)
+	if err != nil || len(data) == 0 {
+		return []UserRecord{} // No users yet
+	}
 
-	for _, port := range known_ports {
-		if port == user.Port {
-			return true
+	var users []UserRecord
+	if help.Check(json.Unmarshal(data, &users)) {
+		return []UserRecord{}
+	}
+	return users
+}
+
+/*
+Write the users to the UserList. A new file is written then renamed,
+so nodes never read half a list.
+*/
+func saveUserList(UserList string, users []UserRecord) {
+	data, err := json.Marshal(users)
+	if help.Check(err) {
+		return
+	}
+
+	tmp := UserList + ".tmp"
+	if help.Check(os.WriteFile(tmp, data, 0644)) {
+		return
+	}
+	help.Check(os.Rename(tmp, UserList))
+}
+
+/*
+Return the user registered on the UserList with the given address, if there is one.
+*/
+func FindUser(UserList string, address string) (UserRecord, bool) {
+	for _, record := range LoadUserList(UserList) {
+		if record.Address == address {
+			return record, true
 		}
 	}
+	return UserRecord{}, false
+}
+
+/*
+Returns true if the user is registered on the UserList, its registration
+has not expired, and it holds the private key of the registered public key:
+the record's address is the address of that key, and a random challenge
+signed by the user's wallet verifies against it.
+*/
+func (user *User) IsUserRegistered() bool {
+	record, found := FindUser(USER_LIST, user.Address)
+	if !found || record.Expired(time.Now()) || !record.Verify() || user.wallet == nil {
+		return false
+	}
+
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); help.Check(err) {
+		return false
+	}
+	challenge := "challenge:" + hex.EncodeToString(nonce)
+	signature, err := user.wallet.Sign(challenge)
+	return err == nil && wlt.Verify(record.PublicKey, challenge, signature)
+}
 
-	return false
+/*
+Renew this user's registration for another reg.REGISTRATION_TTL with a
+renewal signed by its wallet, so a long-running user keeps its identity.
+Returns false if the registration could not be renewed, e.g. the user is
+not registered.
+*/
+func (user *User) Renew() bool {
+	if user.wallet == nil {
+		return false // Not registered
+	}
+	renewal := reg.Renewal{Address: user.Address, Timestamp: time.Now().UnixNano()}
+	signature, err := user.wallet.Sign(reg.RenewalMessage(renewal.Address, renewal.Timestamp))
+	if help.Check(err) {
+		return false
+	}
+	renewal.Signature = signature
+
+	var record UserRecord
+	if reg.IsRegistry(USER_LIST) {
+		record, err = reg.RenewUser(USER_LIST, renewal)
+	} else {
+		record, err = renewInFile(USER_LIST, renewal)
+	}
+	if help.Check(err) {
+		return false
+	}
+	user.Expires = record.Expires
+	user.logger().Infof("renewed its registration until %s", time.Unix(0, record.Expires).Format(time.RFC3339))
+	return true
+}
+
+/*
+Renew the registration of a user on the user list file, see reg.Renew.
+*/
+func renewInFile(UserList string, renewal reg.Renewal) (UserRecord, error) {
+	registration_mutex.Lock()
+	defer registration_mutex.Unlock()
+
+	known_users := LoadUserList(UserList)
+	for i, registered := range known_users {
+		if registered.Address != renewal.Address {
+			continue
+		}
+		record, err := reg.Renew(registered, renewal, time.Now())
+		if err != nil {
+			return registered, err
+		}
+		known_users[i] = record
+		saveUserList(UserList, known_users)
+		return record, nil
+	}
+	return UserRecord{}, reg.ErrUnknownUser
 }
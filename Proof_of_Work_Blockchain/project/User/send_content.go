@@ -3,18 +3,55 @@ package user
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
 	"math/rand"
 	"net/http"
+	blk "project/Block"
 	bc "project/Blockchain"
 	help "project/Helpers"
+	"strings"
+	"time"
 )
 
 /*
 	A user can send content (as a string) to a random set of nodes.
+	The submission is recorded in the user's receipts, see CheckReceipts. Content
+	sent again is sent as it was the first time, so nodes commit it once.
+	Content longer than OFFCHAIN_SIZE is stored off-chain and sent as a reference,
+	except transfers and transactions, which nodes read from the blockchain.
+	Nodes refuse content longer than blk.MAX_CONTENT_SIZE, so it is not sent.
 */
 func (user *User) SendContent(content string) bool {
-	known_nodes := help.GetPorts(NODE_LIST)
+	if OFFCHAIN_SIZE > 0 && len(content) > OFFCHAIN_SIZE && !strings.HasPrefix(content, blk.TRANSFER_PREFIX) && !strings.HasPrefix(content, blk.TRANSACTION_PREFIX) {
+		ref, ok := user.StoreOffChain(content)
+		if !ok {
+			user.logger().Warnf("could not store its content off-chain")
+			return false
+		}
+		content = ref
+	}
+	if len(content) > blk.MAX_CONTENT_SIZE {
+		user.logger().Warnf("cannot send content of %d bytes, over %d", len(content), blk.MAX_CONTENT_SIZE)
+		return false
+	}
+
+	// Every node derives the same content ID from the time the content was first sent,
+	// so content sent again, e.g. retried, keeps its ID and is mined once
+	timestamp, sent := user.submittedAt(content)
+	if !sent {
+		timestamp = time.Now().UnixNano()
+		if len(KnownNodes()) > bc.NON_TRIVIAL {
+			user.RecordSubmission(content, LastBlockIndex(), timestamp)
+		}
+	}
+
+	return user.sendContent(content, timestamp)
+}
+
+/*
+	Send content first sent at timestamp to a random set of nodes, without recording it.
+*/
+func (user *User) sendContent(content string, timestamp int64) bool {
+	known_nodes := KnownNodes()
 
 	/* Ensure there is a non-trivial number of registered nodes */
 	if len(known_nodes) > bc.NON_TRIVIAL {
@@ -23,10 +60,10 @@ func (user *User) SendContent(content string) bool {
 		for _, rand_idx := range rand_indeces {
 			// Send the content and wait for the response.
 			// Continue sending to rest of nodes if the response is false.
-			user.SendContentToNode(known_nodes[rand_idx], content)
+			user.SendContentToNode(known_nodes[rand_idx], content, timestamp)
 		}
 	} else {
-		fmt.Println("User requires non-trivial number of nodes to be registered")
+		user.logger().Warnf("requires non-trivial number of nodes to be registered")
 		return false
 	}
 
@@ -34,14 +71,27 @@ func (user *User) SendContent(content string) bool {
 }
 
 /*
-	Send an http request containing content to a single node.
+	Send an http request containing content, first sent at timestamp, to a single node.
 */
-func (user *User) SendContentToNode(random_port string, content string) bool {
+func (user *User) SendContentToNode(random_port string, content string, timestamp int64) bool {
 	// Store the command port of ever storage server
-	requestURL := "http://localhost:" + random_port
+	requestURL := help.NodeURL(random_port)
+
+	// Pay at least the fee the size of the content requires
+	fee := user.Fee
+	if required := blk.RequiredFee(content); fee < required {
+		fee = required
+	}
+
+	// Sign the content and its fee, so nodes know it comes from this user
+	signature, ok := user.Sign(SignedMessage(content, fee))
+	if !ok {
+		user.logger().Warnf("could not sign its content")
+		return false
+	}
 
 	// Create Content Message
-	message := Content{Content: content, User: *user}
+	message := Content{Content: content, User: *user, Signature: signature, Callback: user.Callback, Timestamp: timestamp, Fee: fee}
 
 	/* Marshall request object */
 	jsonBytes, err := json.Marshal(message)
@@ -57,19 +107,34 @@ func (user *User) SendContentToNode(random_port string, content string) bool {
 	// Set the request's URI to /content
 	req.URL.Path = CONTENT
 
-	client := &http.Client{}
 	// Send request, then wait for a response
-	resp, err := client.Do(req)
+	resp, err := help.HTTP_CLIENT.Do(req)
 	notActive := help.Check(err)
 
 	if !notActive {
-		fmt.Printf("Sent /content to %s\n", random_port)
-		resp.Body.Close()
+		user.logger().Debugf("sent /content to %s", random_port)
+		help.CloseBody(resp)
 	}
 
 	return false // Response was not 200 OK
 }
 
+/*
+	Sign content with the user's wallet. Returns false if the user has no wallet,
+	i.e. it was not registered.
+*/
+func (user *User) Sign(content string) (string, bool) {
+	if user.wallet == nil {
+		return "", false
+	}
+
+	signature, err := user.wallet.Sign(content)
+	if help.Check(err) {
+		return "", false
+	}
+	return signature, true
+}
+
 /*
 Return a set of random numbers that are chosen from a range, without any repeating numbers in the set.
 
@@ -0,0 +1,158 @@
+package user
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	blk "project/Block"
+	help "project/Helpers"
+	"time"
+)
+
+const BALANCE string = "/balance"
+const UTXOS string = "/utxos"
+
+/* The balance of an address as returned by a node's /balance/{address} */
+type Balance struct {
+	Address string `json:"address"`
+	Balance uint64 `json:"balance"`
+	Height  int    `json:"height"`
+}
+
+/* An unspent output as returned by a node's /utxos/{address} */
+type UTXO struct {
+	blk.OutPoint
+	blk.Output
+}
+
+/*
+Return a transfer of amount from this user to the address to, signed with
+the user's wallet. Its nonce is the current time, so each transfer is new.
+Returns false if the user has no wallet.
+*/
+func (user *User) Transfer(to string, amount uint64) (blk.Transfer, bool) {
+	transfer := blk.Transfer{From: user.Address, To: to, Amount: amount, Nonce: time.Now().UnixNano(), PublicKey: user.PublicKey}
+	signature, ok := user.Sign(transfer.Message())
+	if !ok {
+		return blk.Transfer{}, false
+	}
+	transfer.Signature = signature
+	return transfer, true
+}
+
+/*
+Send a transfer of amount from this user to the address to, as content,
+see SendContent. Nodes refuse it if the user cannot afford it.
+*/
+func (user *User) SendTransfer(to string, amount uint64) bool {
+	transfer, ok := user.Transfer(to, amount)
+	if !ok {
+		user.logger().Warnf("could not sign its transfer")
+		return false
+	}
+	return user.SendContent(transfer.String())
+}
+
+/*
+Ask the node at port for the balance of the address.
+Returns false if the node did not answer.
+*/
+func GetBalance(port string, address string) (Balance, bool) {
+	resp, err := help.HTTP_CLIENT.Get(help.NodeURL(port) + BALANCE + "/" + address)
+	if help.Check(err) {
+		return Balance{}, false
+	}
+	defer help.CloseBody(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		return Balance{}, false
+	}
+
+	var balance Balance
+	if help.Check(json.NewDecoder(resp.Body).Decode(&balance)) {
+		return Balance{}, false
+	}
+	return balance, true
+}
+
+/*
+Return a transaction paying amount to the address to out of the given
+unspent outputs of this user, in order, as many as it takes. What the
+outputs spent hold over amount is paid back to the user. Returns false if
+they do not hold amount, or the user has no wallet.
+*/
+func (user *User) Payment(utxos []UTXO, to string, amount uint64) (blk.Transaction, bool) {
+	tx := blk.Transaction{}
+	var in uint64
+	for _, utxo := range utxos {
+		if in >= amount {
+			break
+		}
+		tx.Inputs = append(tx.Inputs, blk.Input{OutPoint: utxo.OutPoint, PublicKey: user.PublicKey})
+		in += utxo.Amount
+	}
+	if amount == 0 || in < amount {
+		return blk.Transaction{}, false
+	}
+
+	tx.Outputs = []blk.Output{{Address: to, Amount: amount}}
+	if in > amount {
+		tx.Outputs = append(tx.Outputs, blk.Output{Address: user.Address, Amount: in - amount})
+	}
+
+	// Every input spends an output of this user, so they all carry the same signature
+	signature, ok := user.Sign(tx.Message())
+	if !ok {
+		return blk.Transaction{}, false
+	}
+	for i := range tx.Inputs {
+		tx.Inputs[i].Signature = signature
+	}
+	return tx, true
+}
+
+/*
+Send a transaction paying amount to the address to out of this user's
+unspent outputs, as a random node knows them, see SendContent. Returns
+false if they do not hold amount.
+*/
+func (user *User) SendPayment(to string, amount uint64) bool {
+	known_nodes := KnownNodes()
+	if len(known_nodes) == 0 {
+		return false
+	}
+	utxos, ok := GetUnspentOutputs(known_nodes[rand.Intn(len(known_nodes))], user.Address)
+	if !ok {
+		user.logger().Warnf("could not get its unspent outputs")
+		return false
+	}
+
+	tx, ok := user.Payment(utxos, to, amount)
+	if !ok {
+		user.logger().Warnf("cannot pay %d out of its unspent outputs", amount)
+		return false
+	}
+	return user.SendContent(tx.String())
+}
+
+/*
+Ask the node at port for the unspent outputs paying to the address.
+Returns false if the node did not answer.
+*/
+func GetUnspentOutputs(port string, address string) ([]UTXO, bool) {
+	resp, err := help.HTTP_CLIENT.Get(help.NodeURL(port) + UTXOS + "/" + address)
+	if help.Check(err) {
+		return nil, false
+	}
+	defer help.CloseBody(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, false
+	}
+
+	var utxos []UTXO
+	if help.Check(json.NewDecoder(resp.Body).Decode(&utxos)) {
+		return nil, false
+	}
+	return utxos, true
+}
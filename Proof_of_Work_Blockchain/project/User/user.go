@@ -1,23 +1,111 @@
 package user
 
 import (
+	blk "project/Block"
+	help "project/Helpers"
+	reg "project/Registry"
+	wlt "project/Wallet"
+	"strconv"
 	"sync"
 )
 
 var USER_LIST string
-var NODE_LIST string
+var SEED string // Port of the node users ask for the nodes on the network
 
 const CONTENT string = "/content"
 const numOfNodes int = 1 // Number of nodes to send to
 
 var registration_mutex sync.Mutex
+var receipts_mutex sync.Mutex
+var peers_mutex sync.Mutex
+var headers_mutex sync.Mutex
+
+// The nodes last learned from the seed, asked instead once the seed left
+var last_peers []string
 
 type User struct {
 	Port string `json:"port"`
 	Name string `json:"name"`
+
+	// Nodes identify users by the address of their wallet's public key
+	Address   string `json:"address"`
+	PublicKey string `json:"public_key"`
+	wallet    *wlt.Wallet
+
+	// Time this user's registration expires, in Unix nanoseconds, see Renew
+	Expires int64 `json:"-"`
+
+	// Receipts of the content this user submitted, stored in its receipt file
+	Receipts []*Submission `json:"-"`
+
+	// Light client: content is confirmed with inclusion proofs checked
+	// against the headers of the blocks, instead of trusting /receipt
+	Light   bool         `json:"-"`
+	Headers []*blk.Block `json:"-"`
+
+	// Fee this user pays the miner of each content it sends, 0 for none,
+	// raised to the fee the size of the content requires, see blk.RequiredFee.
+	// Nodes mine the content paying the highest fees first.
+	Fee uint64 `json:"-"`
+
+	// URL nodes confirm this user's content to once it is committed,
+	// served by HandleConfirmation. Empty to only poll for receipts.
+	Callback string `json:"-"`
+
+	// Where this user logs, see logger. Nil logs to help.LOG.
+	Log *help.Logger `json:"-"`
+}
+
+/*
+Return the logger of this user, which prefixes its lines with the user's port.
+*/
+func (user *User) logger() *help.Logger {
+	return user.Log.With("user " + user.Port)
 }
 
+/*
+A user as stored in the UserList, the registry nodes check users against,
+or by a registry, see the Registry package.
+*/
+type UserRecord = reg.Record
+
 type Content struct {
-	Content string `json:"content"`
-	User    User   `json:"user"`
+	Content   string `json:"content"`
+	User      User   `json:"user"`
+	Signature string `json:"signature"` // Signature of the content by the user's wallet
+
+	// URL the node posts the ContentStatus of the content to once it is committed, see HandleConfirmation
+	Callback string `json:"callback,omitempty"`
+
+	// Time the content was first sent, in nanoseconds, the nodes derive its content ID from
+	Timestamp int64 `json:"timestamp,omitempty"`
+
+	// Fee the user pays the miner of the content, covered by the signature, see SignedMessage
+	Fee uint64 `json:"fee,omitempty"`
+}
+
+/*
+Return the message a user signs to send content paying the given fee, so
+no node can change the fee. Content paying none is signed as is.
+*/
+func SignedMessage(content string, fee uint64) string {
+	if fee == 0 {
+		return content
+	}
+	return content + "\nfee:" + strconv.FormatUint(fee, 10)
+}
+
+/*
+Return the ports of the nodes on the network, as known by the seed node.
+If the seed does not answer, the nodes it last returned are asked.
+*/
+func KnownNodes() []string {
+	peers_mutex.Lock()
+	defer peers_mutex.Unlock()
+
+	known_nodes := help.GetPeers(append([]string{SEED}, last_peers...)...)
+	if len(known_nodes) > 0 {
+		last_peers = known_nodes
+	}
+	return known_nodes
 }
@@ -0,0 +1,182 @@
+/*
+A wallet holds a user's ECDSA key pair and the address derived from it.
+
+Users are identified on the blockchain network by their address, the hex
+encoded first ADDRESS_SIZE bytes of the SHA-256 hash of their public key.
+Public keys are shared as hex encoded DER (PKIX) keys, and signatures as hex
+encoded ASN.1 ECDSA signatures of the SHA-256 hash of the signed message.
+*/
+
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+/* Number of bytes of the public key's hash that make up an address */
+const ADDRESS_SIZE int = 20
+
+type Wallet struct {
+	PrivateKey *ecdsa.PrivateKey
+	PublicKey  string // Hex encoded DER public key
+	Address    string
+}
+
+/*
+Generate a new key pair and return the wallet holding it.
+*/
+func NewWallet() (*Wallet, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey := hex.EncodeToString(der)
+	address, err := Address(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Wallet{PrivateKey: privateKey, PublicKey: publicKey, Address: address}, nil
+}
+
+/*
+Return the address of a hex encoded public key.
+*/
+func Address(publicKey string) (string, error) {
+	der, err := hex.DecodeString(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(der)
+	return hex.EncodeToString(hash[:ADDRESS_SIZE]), nil
+}
+
+/*
+Decode a hex encoded public key.
+*/
+func ParsePublicKey(publicKey string) (*ecdsa.PublicKey, error) {
+	der, err := hex.DecodeString(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	ecdsaKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not an ECDSA key")
+	}
+	return ecdsaKey, nil
+}
+
+/*
+Sign a message with the wallet's private key and return the hex encoded signature.
+*/
+func (wallet *Wallet) Sign(message string) (string, error) {
+	hash := sha256.Sum256([]byte(message))
+	signature, err := ecdsa.SignASN1(rand.Reader, wallet.PrivateKey, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(signature), nil
+}
+
+/*
+Return true if the hex encoded signature of the message was made with the private
+key of the hex encoded public key.
+*/
+func Verify(publicKey string, message string, signature string) bool {
+	key, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return false
+	}
+
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(message))
+	return ecdsa.VerifyASN1(key, hash[:], sig)
+}
+
+/* A wallet as stored in a file, with its hex encoded DER (SEC 1) private key */
+type walletFile struct {
+	PrivateKey string `json:"private_key"`
+	PublicKey  string `json:"public_key"`
+	Address    string `json:"address"`
+}
+
+/*
+Write the wallet to a file only its owner can read.
+*/
+func (wallet *Wallet) Save(path string) error {
+	der, err := x509.MarshalECPrivateKey(wallet.PrivateKey)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(walletFile{PrivateKey: hex.EncodeToString(der), PublicKey: wallet.PublicKey, Address: wallet.Address})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+/*
+Read a wallet written by Save.
+*/
+func LoadWallet(path string) (*Wallet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file walletFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	der, err := hex.DecodeString(file.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := x509.ParseECPrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	// The public key and address must be those of the private key
+	publicDer, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	publicKey := hex.EncodeToString(publicDer)
+	address, err := Address(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if publicKey != file.PublicKey || address != file.Address {
+		return nil, errors.New("wallet keys do not match")
+	}
+
+	return &Wallet{PrivateKey: privateKey, PublicKey: publicKey, Address: address}, nil
+}
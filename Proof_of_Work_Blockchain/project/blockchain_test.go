@@ -1,38 +1,92 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
 	blockchainBlock "project/Block"
+	blockchainBlockchain "project/Blockchain"
+	blockchainConfig "project/Config"
+	blockchainConsensus "project/Consensus"
+	blockchainPoS "project/Consensus/pos"
+	blockchainPoW "project/Consensus/pow"
 	test_helper "project/Helpers"
+	blockchainIndexer "project/Indexer"
 	blockchainNode "project/Node"
+	blockchainRegistry "project/Registry"
+	blockchainSim "project/Sim"
+	blockchainStore "project/Store"
 	blockchainUser "project/User"
+	blockchainWallet "project/Wallet"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testutil"
 	"time"
 )
 
-const NODE_DIR = "/tmp/NodeList.txt"
 const USER_DIR = "/tmp/UserList.txt"
 
-/* The entire journey is logged here */
-const TEST_OUT_DIR = "/tmp/test_output.txt"
+/* Time a network is given to mine a block at DIFFICULTY */
+const MINING_TIMEOUT = 30 * time.Second
 
 /*
- * Delete the Registered Users and Registered Nodes in their repective dirs and kill the processes associated with them.
+Return a logger writing every level to a log file of the test, see testutil.LogFile.
+*/
+func testLogger(t *testing.T, name string) *test_helper.Logger {
+	return test_helper.NewLogger(testutil.LogFile(t, name), test_helper.DEBUG)
+}
+
+/*
+Log to a log file of the test instead of stdout until the test ends.
+*/
+func useTestLogger(t *testing.T, name string) {
+	log := test_helper.LOG
+	test_helper.LOG = testLogger(t, name)
+	t.Cleanup(func() { test_helper.LOG = log })
+}
+
+/* Let blocks naming a proposer be valid for the rest of the test, as nodes under --consensus pos do */
+func useProposedBlocks(t *testing.T) {
+	proposed := blockchainBlock.PROPOSED_BLOCKS
+	blockchainBlock.PROPOSED_BLOCKS = true
+	t.Cleanup(func() { blockchainBlock.PROPOSED_BLOCKS = proposed })
+}
+
+/* Nodes registered by the tests, shut down by cleanup */
+var registered []*blockchainNode.Node
+
+/*
+ * Shut down the nodes registered by the test.
  */
 func cleanup() {
-	if len(test_helper.GetPorts(NODE_DIR)) >= 0 {
-		err := os.Remove(NODE_DIR)
-		if test_helper.Check(err) {
-			fmt.Println("ERR: Could not delete NodeList successfully")
-		} else {
-			fmt.Printf("File %s deleted successfully!\n", NODE_DIR)
-		}
+	for _, node := range registered {
+		node.Shutdown()
 	}
+	registered = nil
+}
+
+/*
+Reserve the ports of a network of n nodes and return its seed port, the first
+of them. Nodes the test registers are shut down when it ends.
+*/
+func newNetwork(t *testing.T, n int) string {
+	t.Cleanup(cleanup)
+	return strconv.Itoa(testutil.FreePortRange(t, n))
 }
 
 /* Happy Journeys */
@@ -42,10 +96,9 @@ Check if a new node is successfully able to register on the blockchain
 */
 func TestRegisterUser(t *testing.T) {
 	fmt.Println("Testing New Blockchain User Registration...")
-	/* Clean anything that may have been reminiscent in the previous run */
-	cleanup()
+	seed := newNetwork(t, 1)
 	testNode := blockchainUser.User{}
-	testNode.RegisterUser(NODE_DIR, USER_DIR)
+	testNode.RegisterUser(USER_DIR, seed)
 	port := testNode.Port
 	if port == "" {
 		t.Errorf("User Registration Failed\n")
@@ -56,11 +109,11 @@ func TestRegisterUser(t *testing.T) {
 
 func TestRegisterNode(t *testing.T) {
 	fmt.Println("Testing New Blockchain Node Registration...")
-	/* Clean anything that may have been reminiscent in the previous run */
-	cleanup()
+	seed := newNetwork(t, 1)
 	testNode := blockchainNode.Node{}
-	LogFile, _ := os.OpenFile(TEST_OUT_DIR, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	testNode.RegisterNode(NODE_DIR, USER_DIR, *LogFile)
+	log := testLogger(t, "nodes")
+	testNode.RegisterNode(seed, USER_DIR, log)
+	registered = append(registered, &testNode)
 	port := testNode.Port
 	if port == "" {
 		t.Errorf("Node Registration Failed\n")
@@ -72,17 +125,19 @@ func TestRegisterNode(t *testing.T) {
 /* Check that a blockchain is not created until there are atleaset 5 nodes in the network */
 func TestBlockChainCreationFailure(t *testing.T) {
 	fmt.Println("Testing New Blockchain Creation Failure...")
-	cleanup()
+	seed := newNetwork(t, 3)
 	blockChainNodes := make([]blockchainNode.Node, 3)
-	LogFile, _ := os.OpenFile(TEST_OUT_DIR, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	for _, blockchainNode := range blockChainNodes {
-		blockchainNode.RegisterNode(NODE_DIR, USER_DIR, *LogFile)
+	log := testLogger(t, "nodes")
+	for i := range blockChainNodes {
+		blockchainNode := &blockChainNodes[i]
+		blockchainNode.RegisterNode(seed, USER_DIR, log)
+		registered = append(registered, blockchainNode)
 		if blockchainNode.Port == "" {
 			t.Errorf("Node Registration Failed\n")
 		}
 		fmt.Printf("Successfully got port %v for registered node\n", blockchainNode.Port)
 	}
-	_, blockchain := blockchainNode.GetBlockchain(NODE_DIR)
+	_, blockchain := blockchainNode.GetBlockchain(seed)
 	if len(blockchain.Blocks) != 0 {
 		t.Errorf("Shouldn't create a blockchain network unless there are atleast 4 nodes\n")
 	}
@@ -93,11 +148,13 @@ Four successful node registrations on the network should trigger a the blockchai
 */
 func TestBlockchainCreationSuccess(t *testing.T) {
 	fmt.Println("Testing New Blockchain Creation Success...")
-	cleanup()
+	seed := newNetwork(t, 5)
 	blockChainNodes := make([]blockchainNode.Node, 5)
-	LogFile, _ := os.OpenFile(TEST_OUT_DIR, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	for _, blockchainNode := range blockChainNodes {
-		blockchainNode.RegisterNode(NODE_DIR, USER_DIR, *LogFile)
+	log := testLogger(t, "nodes")
+	for i := range blockChainNodes {
+		blockchainNode := &blockChainNodes[i]
+		blockchainNode.RegisterNode(seed, USER_DIR, log)
+		registered = append(registered, blockchainNode)
 		if blockchainNode.Port == "" {
 			t.Errorf("Node Registration Failed\n")
 		}
@@ -105,11 +162,13 @@ func TestBlockchainCreationSuccess(t *testing.T) {
 	}
 
 	/* Wait for blockchain to be replicated */
-	/* WARNING: Increase this if Blockchain Creation Fails */
-	time.Sleep(2000 * time.Millisecond)
+	testutil.Eventually(testutil.READY_TIMEOUT, func() bool {
+		found, blockchain := blockchainNode.GetBlockchain(seed)
+		return found && len(blockchain.Blocks) > 0
+	})
 
 	/* The 4th node triggers the blockchain creation */
-	_, blockchain := blockchainNode.GetBlockchain(NODE_DIR)
+	_, blockchain := blockchainNode.GetBlockchain(seed)
 	blockchain_len := len(blockchain.Blocks)
 
 	if len(blockchain.Blocks) == 0 {
@@ -118,7 +177,7 @@ func TestBlockchainCreationSuccess(t *testing.T) {
 	}
 
 	for i := 0; i < 3; i++ {
-		blockchain_found, blockchain_ := blockchainNode.GetBlockchain(NODE_DIR)
+		blockchain_found, blockchain_ := blockchainNode.GetBlockchain(seed)
 		if !blockchain_found || blockchain_len != len(blockchain_.Blocks) {
 			t.Errorf("Length of blockchains differ\n")
 			return
@@ -150,7 +209,7 @@ func TestNewProofOfWork(t *testing.T) {
 	fmt.Println("Testing New Proof of Work ...")
 
 	/* Create New Block with no prior block information */
-	testBlock := blockchainBlock.NewBlock("Test Block", []byte{}, -1)
+	testBlock := blockchainBlock.NewBlock("Test Block", []byte{}, -1, blockchainBlock.DIFFICULTY)
 
 	newProofOfWork := blockchainBlock.NewProofOfWork(testBlock)
 
@@ -163,32 +222,51 @@ func TestNewProofOfWork(t *testing.T) {
 }
 
 /*
-Test that peers can accept content from users and does not accept content from non-registered users.
+Check that miners searching their own nonce ranges find a valid block, and
+that they all stop once a peer's block was validated.
 */
-func TestContentAcceptance(t *testing.T) {
-	/* Create a new file output for logs. */
-	LogFile, err := os.OpenFile("output.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Fatal(err)
-	}
+func TestParallelMining(t *testing.T) {
+	fmt.Println("Testing Parallel Mining...")
 
-	OUT = *LogFile
+	node := &blockchainNode.Node{Port: "1", Miners: 4}
+	success, block := node.MineNewBlock([]string{"Parallel content"}, nil, nil, nil, []byte{}, -1, blockchainBlock.DIFFICULTY)
+	if !success || !block.Validate() {
+		t.Fatalf("Expected parallel miners to mine a valid block\n")
+	}
 
-	if len(test_helper.GetPorts(NODE_LIST)) >= 0 {
-		err = os.Remove(NODE_LIST)
-		if test_helper.Check(err) {
-			fmt.Println("ERR: Could not delete NodeList successfully")
-		} else {
-			fmt.Printf("File %s deleted successfully!\n", NODE_LIST)
+	// A validated block interrupts every miner
+	node.Arrivals = blockchainNode.NewBlockQueue()
+	node.Arrivals.Push(*block)
+	done := make(chan bool)
+	go func() {
+		success, _ := node.MineNewBlock([]string{"Interrupted content"}, nil, nil, nil, block.SelfHash, block.Index, blockchainBlock.MAX_DIFFICULTY)
+		done <- success
+	}()
+	select {
+	case success := <-done:
+		if success {
+			t.Errorf("Expected mining to be interrupted by a validated block\n")
 		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the miners to stop once a block was validated\n")
 	}
+}
+
+/*
+Test that peers can accept content from users and does not accept content from non-registered users.
+*/
+func TestContentAcceptance(t *testing.T) {
+	/* Create a new file output for logs. */
+	LOG = testLogger(t, "nodes")
+
+	seed := newNetwork(t, 5)
 
-	if len(test_helper.GetPorts(USER_LIST)) >= 0 {
-		err = os.Remove(USER_LIST)
+	err := os.Remove(USER_DIR)
+	if !os.IsNotExist(err) {
 		if test_helper.Check(err) {
 			fmt.Println("ERR: Could not delete UserList successfully")
 		} else {
-			fmt.Printf("File %s deleted successfully!\n", USER_LIST)
+			fmt.Printf("File %s deleted successfully!\n", USER_DIR)
 		}
 	}
 
@@ -200,14 +278,18 @@ func TestContentAcceptance(t *testing.T) {
 	node5 := blockchainNode.Node{}
 
 	// Register 5 nodes
-	go node1.RegisterNode(NODE_LIST, USER_LIST, OUT)
-	go node2.RegisterNode(NODE_LIST, USER_LIST, OUT)
-	go node3.RegisterNode(NODE_LIST, USER_LIST, OUT)
-	go node4.RegisterNode(NODE_LIST, USER_LIST, OUT)
-	go node5.RegisterNode(NODE_LIST, USER_LIST, OUT)
+	var registering sync.WaitGroup
+	for _, node := range []*blockchainNode.Node{&node1, &node2, &node3, &node4, &node5} {
+		registering.Add(1)
+		go func(node *blockchainNode.Node) {
+			defer registering.Done()
+			node.RegisterNode(seed, USER_DIR, LOG)
+		}(node)
+	}
 
 	// Wait for registration to be processed
-	time.Sleep(wait_time)
+	registering.Wait()
+	registered = append(registered, &node1, &node2, &node3, &node4, &node5)
 
 	/*
 		Sending content non-concurrently should result in all users getting their
@@ -216,14 +298,17 @@ func TestContentAcceptance(t *testing.T) {
 		Depends on DIFFICULTY and content processing time.
 	*/
 	bob := blockchainUser.User{}
-	bob.RegisterUser(USER_LIST, NODE_LIST)
+	bob.RegisterUser(USER_DIR, seed)
 	bob.SendContent("Test content")
 
 	// Wait for content to be processed
-	time.Sleep(wait_time * 3)
+	testutil.Eventually(MINING_TIMEOUT, func() bool {
+		success, blockchain := blockchainNode.GetBlockchain(seed)
+		return success && len(blockchain.Blocks) >= 2
+	})
 
 	// Get and print majority blockchain
-	success, blockchain := blockchainNode.GetBlockchain(NODE_LIST)
+	success, blockchain := blockchainNode.GetBlockchain(seed)
 	if !success {
 		t.Errorf("Blockchain creation failed\n")
 		return
@@ -236,11 +321,3809 @@ func TestContentAcceptance(t *testing.T) {
 
 	// Check for the right content in each block
 	for idx, block := range blockchain.Blocks {
-		if (idx == 0 && string(block.Content) != "Genesis Block") ||
-			(idx == 1 && string(block.Content) != "Test content") {
+		if (idx == 0 && block.ContentString() != "Genesis Block") ||
+			(idx == 1 && block.ContentString() != "Test content") {
 			t.Errorf("Content in blockchain is not as expected.\n")
 			return
 		}
 	}
 
 }
+
+/*
+Check that peers are ordered by their measured latency, unmeasured peers
+first and failing peers last.
+*/
+func TestPeersByLatency(t *testing.T) {
+	fmt.Println("Testing Peer Latency Ordering...")
+	node := blockchainNode.Node{}
+	node.RecordLatency("1235", 30*time.Millisecond, true)
+	node.RecordLatency("1236", 5*time.Millisecond, true)
+	node.RecordLatency("1237", 0, false)
+
+	ordered := node.PeersByLatency([]string{"1234", "1235", "1236", "1237", "1238"})
+	expected := []string{"1234", "1238", "1236", "1235", "1237"}
+	if fmt.Sprint(ordered) != fmt.Sprint(expected) {
+		t.Errorf("Expected peers in order %v but got %v\n", expected, ordered)
+	}
+}
+
+/*
+Check that off-chain content is verified against the hash its block carries.
+*/
+func TestOffChainContent(t *testing.T) {
+	fmt.Println("Testing Off-Chain Content...")
+	test_helper.BLOB_STORE = test_helper.DirBlobStore{Dir: t.TempDir()}
+	defer func() { test_helper.BLOB_STORE = test_helper.DirBlobStore{Dir: "/tmp/Blobs/"} }()
+
+	user := blockchainUser.User{}
+	ref, ok := user.StoreOffChain("a large payload")
+	if !ok {
+		t.Fatalf("Could not store content off-chain\n")
+	}
+
+	parsed, offchain := blockchainBlock.ParseContentRef([]byte(ref))
+	if !offchain || parsed.Hash != blockchainBlock.ContentHash([]byte("a large payload")) {
+		t.Fatalf("Expected a reference to the content's hash but got %s\n", ref)
+	}
+
+	node := blockchainNode.Node{}
+	if !node.VerifyContent(blockchainBlock.Block{Entries: [][]byte{[]byte(ref)}}) {
+		t.Errorf("Expected off-chain content to match its hash\n")
+	}
+
+	os.WriteFile(parsed.Location, []byte("a tampered payload"), 0644)
+	if node.VerifyContent(blockchainBlock.Block{Entries: [][]byte{[]byte(ref)}}) {
+		t.Errorf("Expected tampered off-chain content to be rejected\n")
+	}
+}
+
+/*
+Check that a peer set learns each port once, forgets a leaving port and
+lists ports by their number.
+*/
+func TestPeerSet(t *testing.T) {
+	fmt.Println("Testing Peer Set...")
+	peers := blockchainNode.NewPeerSet("1236")
+	for _, port := range []string{"10000", "1234", "1235"} {
+		if !peers.Add(port) {
+			t.Errorf("Expected port %s to be new\n", port)
+		}
+	}
+	if peers.Add("1234") {
+		t.Errorf("Expected port 1234 to be known already\n")
+	}
+
+	if !peers.Remove("1235") || peers.Remove("1235") {
+		t.Errorf("Expected port 1235 to be removed once\n")
+	}
+	ports := peers.List()
+	if fmt.Sprint(ports) != fmt.Sprint([]string{"1234", "1236", "10000"}) {
+		t.Errorf("Expected ports [1234 1236 10000] but got %v\n", ports)
+	}
+}
+
+/*
+Check that the block store appends accepted blocks, drops a torn last line
+and replaces the file when the node adopts another chain.
+*/
+func TestBlockStore(t *testing.T) {
+	fmt.Println("Testing Block Store...")
+	store := &blockchainStore.BlockStore{Path: t.TempDir() + "/Blocks.jsonl"}
+
+	genesis := &blockchainBlock.Block{Index: 0, SelfHash: []byte{1}}
+	block1 := &blockchainBlock.Block{Index: 1, PrevBlockHash: []byte{1}, SelfHash: []byte{2}}
+	block2 := &blockchainBlock.Block{Index: 2, PrevBlockHash: []byte{2}, SelfHash: []byte{3}}
+	fork1 := &blockchainBlock.Block{Index: 1, PrevBlockHash: []byte{1}, SelfHash: []byte{4}}
+
+	if test_helper.Check(store.Save([]*blockchainBlock.Block{genesis, block1})) {
+		t.Fatalf("Could not save blocks\n")
+	}
+
+	// A crash while appending leaves half a block
+	file, _ := os.OpenFile(store.Path, os.O_WRONLY|os.O_APPEND, 0644)
+	file.WriteString(`{"index":2,"prev_ha`)
+	file.Close()
+
+	blocks, err := store.Load()
+	if err != nil || len(blocks) != 2 {
+		t.Fatalf("Expected 2 blocks after a torn write but got %d (%v)\n", len(blocks), err)
+	}
+
+	store.Save([]*blockchainBlock.Block{genesis, block1, block2})
+	if blocks, _ = store.Load(); len(blocks) != 3 {
+		t.Errorf("Expected 3 blocks after appending but got %d\n", len(blocks))
+	}
+
+	store.Save([]*blockchainBlock.Block{genesis, fork1})
+	blocks, _ = store.Load()
+	if len(blocks) != 2 || blocks[1].SelfHash[0] != 4 {
+		t.Errorf("Expected the adopted chain to replace the stored one\n")
+	}
+}
+
+/*
+Check that users are registered by the address of their wallet's public key.
+*/
+func TestUserWallet(t *testing.T) {
+	fmt.Println("Testing User Wallets...")
+	userList := t.TempDir() + "/UserList.txt"
+	alice, bob := blockchainUser.User{}, blockchainUser.User{}
+	alice.RegisterUser(userList, SEED)
+	bob.RegisterUser(userList, SEED)
+
+	if alice.Address == "" || alice.Address == bob.Address {
+		t.Fatalf("Expected users to get distinct addresses but got %q and %q\n", alice.Address, bob.Address)
+	}
+
+	address, err := blockchainWallet.Address(alice.PublicKey)
+	if err != nil || address != alice.Address {
+		t.Errorf("Expected address %s to be derived from the public key\n", alice.Address)
+	}
+
+	record, found := blockchainUser.FindUser(userList, alice.Address)
+	if !found || record.PublicKey != alice.PublicKey || record.Port != alice.Port {
+		t.Errorf("Expected %s to be registered with its public key\n", alice.Address)
+	}
+
+	if _, found := blockchainUser.FindUser(userList, "forged"); found {
+		t.Errorf("Expected an unknown address not to be registered\n")
+	}
+
+	// A saved wallet loads back as the same registered user
+	walletFile := t.TempDir() + "/alice.wallet"
+	if !alice.SaveWallet(walletFile) {
+		t.Fatalf("Could not save alice's wallet\n")
+	}
+	loaded, ok := blockchainUser.LoadUser(userList, SEED, walletFile)
+	if !ok || loaded.Address != alice.Address || loaded.Port != alice.Port {
+		t.Fatalf("Expected to load alice back from her wallet\n")
+	}
+	signature, ok := loaded.Sign("Alice sent 1 BTC to Bob")
+	if !ok || !blockchainWallet.Verify(alice.PublicKey, "Alice sent 1 BTC to Bob", signature) {
+		t.Errorf("Expected the loaded wallet to sign as alice\n")
+	}
+	if _, ok := blockchainUser.LoadUser(t.TempDir()+"/UserList.txt", SEED, walletFile); ok {
+		t.Errorf("Expected a user missing from the user list not to load\n")
+	}
+}
+
+/*
+Check that content signed by a user only verifies against that user's public key.
+*/
+func TestSignedContent(t *testing.T) {
+	fmt.Println("Testing Signed Content...")
+	userList := t.TempDir() + "/UserList.txt"
+	alice, bob := blockchainUser.User{}, blockchainUser.User{}
+	alice.RegisterUser(userList, SEED)
+	bob.RegisterUser(userList, SEED)
+
+	signature, signed := alice.Sign("Alice sent 1 BTC to Bob")
+	if !signed {
+		t.Fatalf("Expected a registered user to sign content\n")
+	}
+
+	if !blockchainWallet.Verify(alice.PublicKey, "Alice sent 1 BTC to Bob", signature) {
+		t.Errorf("Expected the signature to verify against the signer's public key\n")
+	}
+
+	if blockchainWallet.Verify(bob.PublicKey, "Alice sent 1 BTC to Bob", signature) {
+		t.Errorf("Expected the signature not to verify against another user's public key\n")
+	}
+
+	if blockchainWallet.Verify(alice.PublicKey, "Alice sent 100 BTC to Bob", signature) {
+		t.Errorf("Expected the signature not to verify for tampered content\n")
+	}
+
+	if _, signed := (&blockchainUser.User{}).Sign("Mallory sent 1 BTC to Mallory"); signed {
+		t.Errorf("Expected an unregistered user not to sign content\n")
+	}
+}
+
+/*
+Check that a node's mempool queues content once and up to its size.
+*/
+func TestMempool(t *testing.T) {
+	fmt.Println("Testing Mempool...")
+	pool := blockchainNode.NewMempool()
+
+	first, _ := pool.Add("First content", "", "", 0)
+	second, _ := pool.Add("Second content", "", "", 0)
+	if first == nil || second == nil {
+		t.Fatalf("Expected new content to be queued\n")
+	}
+
+	if again, _ := pool.Add("First content", "", "", 0); again != nil {
+		t.Errorf("Expected queued content not to be queued twice\n")
+	}
+
+	for i := pool.Len(); i < blockchainNode.MEMPOOL_SIZE; i++ {
+		pool.Add(fmt.Sprintf("Content %d", i), "", "", 0)
+	}
+	if tooMany, _ := pool.Add("One too many", "", "", 0); pool.Len() != blockchainNode.MEMPOOL_SIZE || tooMany != nil {
+		t.Errorf("Expected the mempool to hold at most %d content\n", blockchainNode.MEMPOOL_SIZE)
+	}
+}
+
+/*
+Check that a block's Merkle root commits to its entries and their order.
+*/
+func TestMerkleRoot(t *testing.T) {
+	fmt.Println("Testing Merkle Root...")
+	entries := [][]byte{[]byte("First content"), []byte("Second content"), []byte("Third content")}
+	root := blockchainBlock.MerkleRoot(entries)
+
+	reordered := [][]byte{entries[1], entries[0], entries[2]}
+	if bytes.Equal(root, blockchainBlock.MerkleRoot(reordered)) {
+		t.Errorf("Expected reordered entries to change the Merkle root\n")
+	}
+
+	changed := [][]byte{entries[0], entries[1], []byte("Other content")}
+	if bytes.Equal(root, blockchainBlock.MerkleRoot(changed)) {
+		t.Errorf("Expected a changed entry to change the Merkle root\n")
+	}
+
+	block := blockchainBlock.NewBlock("Test content", []byte{}, 0, blockchainBlock.DIFFICULTY)
+	if !block.Validate() {
+		t.Fatalf("Expected a mined block to be valid\n")
+	}
+
+	block.Entries = append(block.Entries, []byte("Smuggled content"))
+	if block.Validate() {
+		t.Errorf("Expected a block with tampered entries to be invalid\n")
+	}
+}
+
+/*
+Check that the difficulty is only retargeted every RETARGET_INTERVAL blocks,
+towards TARGET_BLOCK_TIME, and that blocks must meet their declared difficulty.
+*/
+func TestDifficultyAdjustment(t *testing.T) {
+	fmt.Println("Testing Difficulty Adjustment...")
+	chain := func(interval time.Duration, length int) []*blockchainBlock.Block {
+		blocks := []*blockchainBlock.Block{}
+		for i := 0; i < length; i++ {
+			blocks = append(blocks, &blockchainBlock.Block{Index: i, Timestamp: int64(i) * int64(interval), Difficulty: blockchainBlock.DIFFICULTY})
+		}
+		return blocks
+	}
+
+	interval := blockchainBlock.RETARGET_INTERVAL
+	if d := blockchainBlock.NextDifficulty(chain(time.Millisecond, interval-1)); d != blockchainBlock.DIFFICULTY {
+		t.Errorf("Expected no adjustment between retargets but got difficulty %d\n", d)
+	}
+	if d := blockchainBlock.NextDifficulty(chain(blockchainBlock.TARGET_BLOCK_TIME, interval)); d != blockchainBlock.DIFFICULTY {
+		t.Errorf("Expected no adjustment for blocks on target but got difficulty %d\n", d)
+	}
+	if d := blockchainBlock.NextDifficulty(chain(blockchainBlock.TARGET_BLOCK_TIME/2, interval)); d != blockchainBlock.DIFFICULTY+1 {
+		t.Errorf("Expected fast blocks to raise the difficulty but got %d\n", d)
+	}
+	if d := blockchainBlock.NextDifficulty(chain(blockchainBlock.TARGET_BLOCK_TIME*1000, interval)); d != blockchainBlock.DIFFICULTY-blockchainBlock.MAX_RETARGET_STEP {
+		t.Errorf("Expected slow blocks to lower the difficulty by at most %d but got %d\n", blockchainBlock.MAX_RETARGET_STEP, d)
+	}
+
+	block := blockchainBlock.NewBlock("Test content", []byte{}, 0, blockchainBlock.MIN_DIFFICULTY)
+	if !block.Validate() {
+		t.Fatalf("Expected a block to meet its declared difficulty\n")
+	}
+
+	block.Difficulty = blockchainBlock.MAX_DIFFICULTY
+	if block.Validate() {
+		t.Errorf("Expected a block not meeting its declared difficulty to be invalid\n")
+	}
+}
+
+/*
+Check that the indexer finds content by author, keyword and time, catches
+up on new blocks, reloads its index and rebuilds it when the chain is replaced.
+*/
+func TestIndexer(t *testing.T) {
+	fmt.Println("Testing Indexer...")
+	node := &blockchainNode.Node{Acceptance_mu: &sync.Mutex{}}
+	server := httptest.NewServer(http.HandlerFunc(node.HandleBlockEvents))
+	defer server.Close()
+	port := server.URL[strings.LastIndex(server.URL, ":")+1:]
+
+	start := time.Now()
+	genesis := &blockchainBlock.Block{Index: 0, SelfHash: []byte{1}, Entries: [][]byte{[]byte("Genesis Block")}, Timestamp: start.UnixNano()}
+	block1 := &blockchainBlock.Block{Index: 1, PrevBlockHash: []byte{1}, SelfHash: []byte{2}, Timestamp: start.Add(time.Minute).UnixNano(),
+		Entries: [][]byte{[]byte("Hello world"), []byte("Second content")}, Authors: []string{"alice", "bob"}}
+	block2 := &blockchainBlock.Block{Index: 2, PrevBlockHash: []byte{2}, SelfHash: []byte{3}, Timestamp: start.Add(2 * time.Minute).UnixNano(),
+		Entries: [][]byte{[]byte("Hello again")}, Authors: []string{"alice"}}
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis, block1}
+
+	dir := t.TempDir()
+	indexer := blockchainIndexer.NewIndexer(port, dir)
+	if err := indexer.Sync(0); err != nil {
+		t.Fatalf("Could not sync the indexer: %v\n", err)
+	}
+
+	contents := func(records []blockchainIndexer.Record) string {
+		found := []string{}
+		for _, record := range records {
+			found = append(found, record.Content)
+		}
+		return strings.Join(found, ",")
+	}
+	if found := contents(indexer.Search(blockchainIndexer.Query{Author: "alice"})); found != "Hello world" {
+		t.Errorf("Expected alice's content but got %s\n", found)
+	}
+	if found := contents(indexer.Search(blockchainIndexer.Query{Keywords: []string{"CONTENT"}})); found != "Second content" {
+		t.Errorf("Expected the content with the keyword but got %s\n", found)
+	}
+
+	node.Blockchain.Blocks = append(node.Blockchain.Blocks, block2)
+	if err := indexer.Sync(0); err != nil {
+		t.Fatalf("Could not sync the indexer: %v\n", err)
+	}
+	query := blockchainIndexer.Query{Author: "alice", Keywords: []string{"hello"}, From: start.Add(30 * time.Second)}
+	if found := contents(indexer.Search(query)); found != "Hello world,Hello again" {
+		t.Errorf("Expected alice's greetings after the genesis block but got %s\n", found)
+	}
+
+	reloaded := blockchainIndexer.NewIndexer(port, dir)
+	if err := reloaded.Load(); err != nil || len(reloaded.Search(blockchainIndexer.Query{})) != 4 {
+		t.Errorf("Expected the reloaded index to hold 4 records\n")
+	}
+
+	// The node adopts a chain where bob's content is in block 1 alone
+	other := &blockchainBlock.Block{Index: 1, PrevBlockHash: []byte{1}, SelfHash: []byte{4}, Timestamp: block1.Timestamp,
+		Entries: [][]byte{[]byte("Second content")}, Authors: []string{"bob"}}
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis, other}
+	if err := reloaded.Sync(0); err != nil {
+		t.Fatalf("Could not sync the indexer: %v\n", err)
+	}
+	if found := contents(reloaded.Search(blockchainIndexer.Query{})); found != "Genesis Block,Second content" {
+		t.Errorf("Expected the index of the adopted chain but got %s\n", found)
+	}
+
+	rec := httptest.NewRecorder()
+	reloaded.HandleSearch(rec, httptest.NewRequest("GET", blockchainIndexer.SEARCH+"?user=bob&keyword=second", nil))
+	var response blockchainIndexer.SearchResponse
+	if json.NewDecoder(rec.Body).Decode(&response); response.Height != 2 || len(response.Records) != 1 {
+		t.Errorf("Expected one record of bob at height 2 but got %+v\n", response)
+	}
+}
+
+/*
+Check that nodes tell their API version, refuse calls from nodes speaking an
+incompatible version and that calls to such nodes fail instead of being decoded.
+*/
+func TestAPIVersion(t *testing.T) {
+	fmt.Println("Testing API Versions...")
+	seed := newNetwork(t, 1)
+	node := blockchainNode.Node{}
+	log := testLogger(t, "nodes")
+	node.RegisterNode(seed, USER_DIR, log)
+	registered = append(registered, &node)
+
+	version, err := test_helper.GetAPIVersion(node.Port)
+	if err != nil || version.Version != test_helper.API_VERSION || version.MinVersion != test_helper.MIN_API_VERSION {
+		t.Errorf("Expected node %s to speak version %d but got %+v (%v)\n", node.Port, test_helper.API_VERSION, version, err)
+	}
+
+	status := func(version string) int {
+		req, _ := http.NewRequest("POST", "http://localhost:"+node.Port+blockchainNode.STATUS, nil)
+		if version != "" {
+			req.Header.Set(test_helper.API_VERSION_HEADER, version)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0
+		}
+		test_helper.CloseBody(resp)
+		return resp.StatusCode
+	}
+	if code := status(""); code != http.StatusOK {
+		t.Errorf("Expected a call without a version to be served but got %d\n", code)
+	}
+	if code := status(fmt.Sprint(test_helper.API_VERSION)); code != http.StatusOK {
+		t.Errorf("Expected a call of the same version to be served but got %d\n", code)
+	}
+	if code := status("1"); code != http.StatusUpgradeRequired {
+		t.Errorf("Expected a call of version 1 to be refused but got %d\n", code)
+	}
+
+	// A node speaking an older version answers
+	old := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(test_helper.API_VERSION_HEADER, "1")
+		w.Write([]byte("{\"height\": 12}"))
+	}))
+	defer old.Close()
+	var incompatible *test_helper.IncompatibleVersionError
+	if _, err := test_helper.HTTP_CLIENT.Get(old.URL + blockchainNode.STATUS); !errors.As(err, &incompatible) || incompatible.Version != 1 {
+		t.Errorf("Expected a call to a node of version 1 to fail but got %v\n", err)
+	}
+}
+
+/*
+Check that a node serves single blocks by index and by hash.
+*/
+func TestGetBlock(t *testing.T) {
+	fmt.Println("Testing Get Block...")
+	node := &blockchainNode.Node{Acceptance_mu: &sync.Mutex{}}
+	node.Blockchain.Blocks = []*blockchainBlock.Block{
+		{Index: 0, SelfHash: []byte{1}, Entries: [][]byte{[]byte("Genesis Block")}},
+		{Index: 1, PrevBlockHash: []byte{1}, SelfHash: []byte{2}, Entries: [][]byte{[]byte("Hello world")}},
+	}
+	server := httptest.NewServer(http.HandlerFunc(node.HandleBlock))
+	defer server.Close()
+	port := server.URL[strings.LastIndex(server.URL, ":")+1:]
+
+	if block, ok := blockchainNode.GetBlockByIndex(port, 1); !ok || block.ContentString() != node.Blockchain.Blocks[1].ContentString() {
+		t.Errorf("Expected block 1 by its index\n")
+	}
+	if block, ok := blockchainNode.GetBlockByHash(port, hex.EncodeToString([]byte{1})); !ok || block.Index != 0 {
+		t.Errorf("Expected the genesis block by its hash\n")
+	}
+	if _, ok := blockchainNode.GetBlockByIndex(port, 2); ok {
+		t.Errorf("Expected no block past the tip\n")
+	}
+	if _, ok := blockchainNode.GetBlockByHash(port, "ff"); ok {
+		t.Errorf("Expected no block with an unknown hash\n")
+	}
+
+	for _, query := range []string{"", "?index=x", "?index=0&hash=01"} {
+		resp, err := http.Get(server.URL + blockchainNode.BLOCK + query)
+		if err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected %q to be a bad request\n", query)
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+}
+
+/*
+Check that a node missing blocks only fetches the blocks after its last one,
+and copies the whole majority blockchain when its last block was forked off.
+*/
+func TestBlocksSince(t *testing.T) {
+	fmt.Println("Testing Blocks Since...")
+	useTestLogger(t, "nodes")
+
+	difficulty := blockchainBlock.MIN_DIFFICULTY
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, difficulty)
+	block1 := blockchainBlock.NewBlock("First content", genesis.SelfHash, 0, difficulty)
+	block2 := blockchainBlock.NewBlock("Second content", block1.SelfHash, 1, difficulty)
+	chain := []*blockchainBlock.Block{genesis, block1, block2}
+
+	// Peers serving the whole chain, counting the copies of it they send
+	var copies int32
+	ports := []string{}
+	for i := 0; i < 3; i++ {
+		peer := &blockchainNode.Node{Acceptance_mu: &sync.Mutex{}}
+		peer.Blockchain.Blocks = chain
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == blockchainNode.COPY_CHAIN {
+				atomic.AddInt32(&copies, 1)
+				peer.HandleCopyChain(w, r)
+				return
+			}
+			if r.URL.Path == blockchainNode.TIP {
+				peer.HandleTip(w, r)
+				return
+			}
+			peer.HandleBlocksSince(w, r)
+		}))
+		defer server.Close()
+		ports = append(ports, server.URL[strings.LastIndex(server.URL, ":")+1:])
+	}
+
+	update := func(blocks []*blockchainBlock.Block) *blockchainNode.Node {
+		node := &blockchainNode.Node{Port: "1", Peers: blockchainNode.NewPeerSet(ports...), Acceptance_mu: &sync.Mutex{}}
+		node.Blockchain.Blocks = blocks
+		if !node.UpdateBlockchain() {
+			t.Fatalf("Could not update the blockchain\n")
+		}
+		return node
+	}
+	sameChain := func(node *blockchainNode.Node) bool {
+		if len(node.Blockchain.Blocks) != len(chain) {
+			return false
+		}
+		for i, block := range node.Blockchain.Blocks {
+			if !bytes.Equal(block.SelfHash, chain[i].SelfHash) {
+				return false
+			}
+		}
+		return true
+	}
+
+	node := update([]*blockchainBlock.Block{genesis})
+	if !sameChain(node) || atomic.LoadInt32(&copies) != 0 {
+		t.Errorf("Expected the node to fetch the 2 blocks it missed only, but it copied the chain %d times\n", copies)
+	}
+
+	forked := blockchainBlock.NewBlock("Forked content", genesis.SelfHash, 0, difficulty)
+	node = update([]*blockchainBlock.Block{genesis, forked})
+	if !sameChain(node) || atomic.LoadInt32(&copies) == 0 {
+		t.Errorf("Expected a forked node to copy the majority blockchain\n")
+	}
+
+	resp, err := http.Get("http://localhost:" + ports[0] + blockchainNode.BLOCKS_SINCE + "?index=x")
+	if err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a bad index to be refused\n")
+	}
+	if err == nil {
+		resp.Body.Close()
+	}
+}
+
+/*
+Check that a node syncs headers first: it checks the majority headers, keeps
+the blocks it holds already and fetches the other bodies from its peers,
+without copying the whole blockchain.
+*/
+func TestHeadersFirstSync(t *testing.T) {
+	fmt.Println("Testing Headers First Sync...")
+	useTestLogger(t, "nodes")
+
+	difficulty := blockchainBlock.MIN_DIFFICULTY
+	chain := []*blockchainBlock.Block{blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, difficulty)}
+	for i := 0; i < 8; i++ {
+		prev := chain[len(chain)-1]
+		chain = append(chain, blockchainBlock.NewBlock(fmt.Sprintf("Content %d", i), prev.SelfHash, prev.Index, difficulty))
+	}
+
+	// Peers counting the copies of the chain and the bodies they send
+	var copies int32
+	bodies := make([]int32, 3)
+	ports := []string{}
+	for i := range bodies {
+		i := i
+		peer := &blockchainNode.Node{Acceptance_mu: &sync.Mutex{}}
+		peer.Blockchain.Blocks = chain
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case blockchainNode.COPY_CHAIN:
+				atomic.AddInt32(&copies, 1)
+			case blockchainNode.BLOCK:
+				atomic.AddInt32(&bodies[i], 1)
+			}
+			peer.HandleRequests(w, r)
+		}))
+		defer server.Close()
+		ports = append(ports, server.URL[strings.LastIndex(server.URL, ":")+1:])
+	}
+
+	resp, err := http.Get("http://localhost:" + ports[0] + blockchainNode.HEADERS + "?since=7")
+	if err != nil {
+		t.Fatalf("Could not get the headers: %v\n", err)
+	}
+	var headers blockchainNode.HeaderChain
+	json.NewDecoder(resp.Body).Decode(&headers)
+	resp.Body.Close()
+	if headers.Height != len(chain) || len(headers.Headers) != 2 || !headers.Headers[0].HeaderOnly || len(headers.Headers[0].Entries) != 0 {
+		t.Errorf("Expected /headers?since=7 to return the last 2 headers but got %d of height %d\n", len(headers.Headers), headers.Height)
+	}
+
+	// A forked node cannot catch up with /blocks_since, so it syncs headers first
+	forked := blockchainBlock.NewBlock("Forked content", chain[2].SelfHash, chain[2].Index, difficulty)
+	node := &blockchainNode.Node{Port: "1", Peers: blockchainNode.NewPeerSet(ports...), Acceptance_mu: &sync.Mutex{}}
+	node.Blockchain.Blocks = []*blockchainBlock.Block{chain[0], chain[1], chain[2], forked}
+	if !node.UpdateBlockchain() || node.TipHash() != hex.EncodeToString(chain[len(chain)-1].SelfHash) {
+		t.Fatalf("Expected the forked node to sync the majority blockchain headers first\n")
+	}
+	for i, block := range node.Blockchain.Blocks {
+		if block.HeaderOnly || block.ContentString() != chain[i].ContentString() {
+			t.Errorf("Expected block %d to be synced in full\n", i)
+		}
+	}
+
+	fetched := int32(0)
+	for i := range bodies {
+		fetched += atomic.LoadInt32(&bodies[i])
+	}
+	if atomic.LoadInt32(&copies) != 0 || fetched != int32(len(chain)-3) {
+		t.Errorf("Expected the node to fetch the %d bodies it missed only, but it fetched %d and copied the chain %d times\n", len(chain)-3, fetched, copies)
+	}
+}
+
+func TestForkResolution(t *testing.T) {
+	fmt.Println("Testing Fork Resolution...")
+	useTestLogger(t, "nodes")
+
+	difficulty := blockchainBlock.MIN_DIFFICULTY
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, difficulty)
+	mainBlock := blockchainBlock.NewBlock("Main content", genesis.SelfHash, 0, difficulty)
+	forkBlock := blockchainBlock.NewBlock("Forked content", genesis.SelfHash, 0, difficulty)
+	forkTip := blockchainBlock.NewBlock("Forked tip", forkBlock.SelfHash, 1, difficulty)
+	lighter := blockchainBlock.NewBlock("Lighter content", genesis.SelfHash, 0, difficulty)
+
+	node := &blockchainNode.Node{Port: "1", Acceptance_mu: &sync.Mutex{}, Branches: blockchainNode.NewBranches()}
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis, mainBlock}
+	server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+	defer server.Close()
+
+	validate := func(block *blockchainBlock.Block) int {
+		body, _ := json.Marshal(block)
+		resp, err := http.Post(server.URL+blockchainNode.VALIDATE, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Could not send the block for validation: %v\n", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	onChain := func(blocks ...*blockchainBlock.Block) bool {
+		if len(node.Blockchain.Blocks) != len(blocks) {
+			return false
+		}
+		for i, block := range node.Blockchain.Blocks {
+			if !bytes.Equal(block.SelfHash, blocks[i].SelfHash) {
+				return false
+			}
+		}
+		return true
+	}
+
+	// A branch as heavy as the blockchain is kept aside
+	if validate(forkBlock) != http.StatusForbidden || !onChain(genesis, mainBlock) {
+		t.Errorf("Expected the node to keep its blockchain on a tie\n")
+	}
+
+	// Once the branch is heavier, the node switches to it
+	if validate(forkTip) != http.StatusOK || !onChain(genesis, forkBlock, forkTip) {
+		t.Errorf("Expected the node to switch to the heavier branch\n")
+	}
+	if blockchainNode.ChainWork(node.Blockchain.Blocks).Cmp(blockchainNode.ChainWork([]*blockchainBlock.Block{genesis, mainBlock})) <= 0 {
+		t.Errorf("Expected the heavier branch to carry more work\n")
+	}
+
+	// A lighter branch is refused, and the rolled back block kept aside
+	if validate(lighter) != http.StatusForbidden || !onChain(genesis, forkBlock, forkTip) {
+		t.Errorf("Expected the node to refuse a lighter branch\n")
+	}
+	if node.Branches.Len() != 2 {
+		t.Errorf("Expected the rolled back and lighter blocks to be kept aside, got %d\n", node.Branches.Len())
+	}
+
+	// A heavier branch is refused if one of its blocks would not be accepted alone, here for its timestamp
+	staleBlock := blockchainBlock.NewBlock("Stale content", genesis.SelfHash, 0, difficulty)
+	staleNext := blockchainBlock.NewBlock("Stale next", staleBlock.SelfHash, 1, difficulty)
+	staleTip := blockchainBlock.NewBlock("Stale tip", staleNext.SelfHash, 2, difficulty)
+	staleTip.Timestamp = staleBlock.Timestamp - 1
+	nonce, hash := blockchainBlock.NewProofOfWork(staleTip).Run()
+	staleTip.Nonce, staleTip.SelfHash = nonce, hash[:]
+	validate(staleBlock)
+	validate(staleNext)
+	if validate(staleTip) != http.StatusForbidden || !onChain(genesis, forkBlock, forkTip) {
+		t.Errorf("Expected the node to refuse a heavier branch holding an invalid block\n")
+	}
+}
+
+/*
+Check that the cmd/node binary serves its API once started, and leaves the
+network listening no more once interrupted.
+*/
+func TestNodeProcess(t *testing.T) {
+	fmt.Println("Testing Node Process...")
+	binary := filepath.Join(t.TempDir(), "node")
+	if output, err := exec.Command("go", "build", "-o", binary, "./cmd/node").CombinedOutput(); err != nil {
+		t.Fatalf("Could not build cmd/node: %v\n%s", err, output)
+	}
+
+	port := strconv.Itoa(testutil.FreePort(t))
+	listening := func() bool { return testutil.Listening("localhost:" + port) }
+	node := testutil.StartProcess(t, "node", listening, binary, "-port", port, "-data", t.TempDir())
+
+	version, err := test_helper.GetAPIVersion(port)
+	if err != nil || version.Version != test_helper.API_VERSION {
+		t.Errorf("Expected node %s to speak version %d but got %+v (%v)\n", port, test_helper.API_VERSION, version, err)
+	}
+
+	node.Stop()
+	if listening() {
+		t.Errorf("Expected node %s to stop listening once interrupted\n", port)
+	}
+}
+
+/*
+Check that finalized blocks are checkpointed, and that neither a block nor a
+heavier branch contradicting a checkpoint is accepted.
+*/
+func TestCheckpoints(t *testing.T) {
+	fmt.Println("Testing Checkpoints...")
+	useTestLogger(t, "nodes")
+
+	// Extend blocks by n blocks, each declaring the difficulty expected next
+	extend := func(blocks []*blockchainBlock.Block, n int, content string) []*blockchainBlock.Block {
+		extended := append([]*blockchainBlock.Block{}, blocks...)
+		for i := 0; i < n; i++ {
+			prev := extended[len(extended)-1]
+			extended = append(extended, blockchainBlock.NewBlock(fmt.Sprintf("%s %d", content, i), prev.SelfHash, prev.Index, blockchainBlock.NextDifficulty(extended)))
+		}
+		return extended
+	}
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	chain := extend([]*blockchainBlock.Block{genesis}, blockchainNode.CHECKPOINT_INTERVAL+blockchainNode.CHECKPOINT_DEPTH, "Main content")
+
+	checkpoints := blockchainNode.NewCheckpoints()
+	recorded := checkpoints.Record(chain)
+	if len(recorded) != 2 || recorded[1].Index != blockchainNode.CHECKPOINT_INTERVAL || recorded[1].Hash != hex.EncodeToString(chain[blockchainNode.CHECKPOINT_INTERVAL].SelfHash) {
+		t.Fatalf("Expected blocks 0 and %d to be checkpointed but got %v\n", blockchainNode.CHECKPOINT_INTERVAL, recorded)
+	}
+	if again := checkpoints.Record(chain); len(again) != 0 {
+		t.Errorf("Expected checkpoints to be recorded once but got %v again\n", again)
+	}
+	if _, contradicted := checkpoints.Contradicted(chain[:blockchainNode.CHECKPOINT_INTERVAL]); !contradicted {
+		t.Errorf("Expected a chain ending before a checkpoint to contradict it\n")
+	}
+
+	node := &blockchainNode.Node{Port: "1", Acceptance_mu: &sync.Mutex{}, Branches: blockchainNode.NewBranches(), Checkpoints: checkpoints}
+	node.Blockchain.Blocks = chain
+	if status := node.Status(); status.Checkpoint == nil || status.Checkpoint.Index != blockchainNode.CHECKPOINT_INTERVAL {
+		t.Errorf("Expected /status to report the checkpoint at block %d but got %v\n", blockchainNode.CHECKPOINT_INTERVAL, status.Checkpoint)
+	}
+	server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+	defer server.Close()
+
+	validate := func(block *blockchainBlock.Block) int {
+		body, _ := json.Marshal(block)
+		resp, err := http.Post(server.URL+blockchainNode.VALIDATE, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Could not send the block for validation: %v\n", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	// Send the blocks of branch after fork, and return the status of the last one
+	sendBranch := func(branch []*blockchainBlock.Block, fork int) int {
+		code := 0
+		for _, block := range branch[fork:] {
+			code = validate(block)
+		}
+		return code
+	}
+
+	forged := *chain[blockchainNode.CHECKPOINT_INTERVAL]
+	forged.SelfHash = []byte{1}
+	if node.ValidateBlock(forged, 0) || node.Checkpoints.Allows(forged) {
+		t.Errorf("Expected a block contradicting a checkpoint to be invalid\n")
+	}
+
+	// A heavier branch forking off before the checkpoint is refused
+	early := extend(chain[:blockchainNode.CHECKPOINT_INTERVAL-1], blockchainNode.CHECKPOINT_DEPTH+3, "Early fork")
+	if sendBranch(early, blockchainNode.CHECKPOINT_INTERVAL-1) != http.StatusForbidden || !bytes.Equal(node.Blockchain.Blocks[blockchainNode.CHECKPOINT_INTERVAL].SelfHash, chain[blockchainNode.CHECKPOINT_INTERVAL].SelfHash) {
+		t.Errorf("Expected the node to refuse a heavier branch rolling back its checkpoint\n")
+	}
+
+	// A heavier branch forking off after the checkpoint is switched to
+	late := extend(chain[:blockchainNode.CHECKPOINT_INTERVAL+2], blockchainNode.CHECKPOINT_DEPTH, "Late fork")
+	if sendBranch(late, blockchainNode.CHECKPOINT_INTERVAL+2) != http.StatusOK || node.TipHash() != hex.EncodeToString(late[len(late)-1].SelfHash) {
+		t.Errorf("Expected the node to switch to a heavier branch after its checkpoint\n")
+	}
+}
+
+func TestPruning(t *testing.T) {
+	fmt.Println("Testing Pruning...")
+	useTestLogger(t, "nodes")
+
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	chain := []*blockchainBlock.Block{genesis}
+	for i := 0; i < blockchainNode.CHECKPOINT_INTERVAL+blockchainNode.CHECKPOINT_DEPTH; i++ {
+		prev := chain[len(chain)-1]
+		chain = append(chain, blockchainBlock.NewBlock(fmt.Sprintf("Pruned content %d", i), prev.SelfHash, prev.Index, blockchainBlock.NextDifficulty(chain)))
+	}
+
+	// A header validates and chains like its block, without its content
+	header := chain[1].Header()
+	if !header.Validate() || len(header.Entries) != 0 || !bytes.Equal(header.SelfHash, chain[1].SelfHash) {
+		t.Errorf("Expected the header of a block to validate without its entries\n")
+	}
+	forged := *header
+	forged.MerkleRoot = chain[2].MerkleRoot
+	if forged.Validate() {
+		t.Errorf("Expected a header with another Merkle root to be invalid\n")
+	}
+	bogus := *chain[1]
+	bogus.SelfHash = chain[2].SelfHash
+	if bogus.Validate() {
+		t.Errorf("Expected a block carrying another block's hash to be invalid\n")
+	}
+
+	archive := &blockchainNode.Node{Acceptance_mu: &sync.Mutex{}, Checkpoints: blockchainNode.NewCheckpoints()}
+	archive.Blockchain.Blocks = chain
+	archiveServer := httptest.NewServer(http.HandlerFunc(archive.HandleRequests))
+	defer archiveServer.Close()
+	archive.Port = archiveServer.URL[strings.LastIndex(archiveServer.URL, ":")+1:]
+	archive.Peers = blockchainNode.NewPeerSet(archive.Port)
+
+	// A pruned node keeps the blocks after its last checkpoint in full, and its last PruneDepth blocks
+	pruned := &blockchainNode.Node{PruneDepth: 3, Acceptance_mu: &sync.Mutex{}, Checkpoints: blockchainNode.NewCheckpoints()}
+	prunedServer := httptest.NewServer(http.HandlerFunc(pruned.HandleRequests))
+	defer prunedServer.Close()
+	pruned.Port = prunedServer.URL[strings.LastIndex(prunedServer.URL, ":")+1:]
+	pruned.Peers = blockchainNode.NewPeerSet(archive.Port) // Catches up with the archive node only
+	pruned.Store = &blockchainStore.BlockStore{Path: t.TempDir() + "/Blocks.jsonl"}
+
+	success, blockchain := blockchainNode.GetHeaders(archive.Port)
+	if !success || len(blockchain.Blocks) != len(chain) || !blockchain.Blocks[len(chain)-1].HeaderOnly {
+		t.Fatalf("Expected /copy_chain?headers=true to return the headers of the blockchain\n")
+	}
+
+	success, blockchain = blockchainNode.GetBlockchain(archive.Port)
+	if !success {
+		t.Fatalf("Could not copy the archive node's blockchain\n")
+	}
+	pruned.Blockchain.Blocks = blockchain.Blocks[:len(chain)-1]
+	if !pruned.UpdateBlockchain() { // Catches up, then stores and prunes the blockchain
+		t.Fatalf("Expected the pruned node to catch up with the archive node\n")
+	}
+
+	for i, block := range pruned.Blockchain.Blocks {
+		if block.HeaderOnly != (i <= blockchainNode.CHECKPOINT_INTERVAL) {
+			t.Errorf("Expected only blocks up to the checkpoint to be pruned, block %d is pruned: %v\n", i, block.HeaderOnly)
+		}
+	}
+	stored, err := pruned.Store.Load()
+	if err != nil || len(stored) != len(chain) || !stored[0].HeaderOnly || stored[len(stored)-1].HeaderOnly {
+		t.Errorf("Expected the pruned blockchain to be stored\n")
+	}
+	if status := pruned.Status(); status.PruneDepth != 3 {
+		t.Errorf("Expected /status to report a prune depth of 3 but got %d\n", status.PruneDepth)
+	}
+
+	// Pruned blocks are served in full, fetched from the archive node
+	block, found := blockchainNode.GetBlockByIndex(pruned.Port, 2)
+	if !found || block.HeaderOnly || !bytes.Equal(block.MerkleRoot, chain[2].MerkleRoot) || block.ContentString() != chain[2].ContentString() {
+		t.Errorf("Expected a pruned node to serve its pruned blocks in full\n")
+	}
+
+	// Without an archive peer, pruned blocks cannot be served
+	archive.PruneDepth = 3
+	if _, found := blockchainNode.GetBlockByIndex(pruned.Port, 2); found {
+		t.Errorf("Expected a pruned node without archive peers to refuse its pruned blocks\n")
+	}
+}
+
+/*
+Check that a light client confirms content with an inclusion proof checked
+against the headers of the blocks, and refuses proofs that do not check out.
+*/
+func TestLightClient(t *testing.T) {
+	fmt.Println("Testing Light Client...")
+	useTestLogger(t, "nodes")
+
+	entries := [][]byte{[]byte("First"), []byte("Second"), []byte("Third"), []byte("Fourth"), []byte("Fifth")}
+	for n := 1; n <= len(entries); n++ {
+		root := blockchainBlock.MerkleRoot(entries[:n])
+		for i := 0; i < n; i++ {
+			proof := blockchainBlock.MerkleProof(entries[:n], i)
+			if !blockchainBlock.VerifyMerkleProof(entries[i], proof, root) {
+				t.Errorf("Expected the proof of entry %d of %d to verify\n", i, n)
+			}
+			if blockchainBlock.VerifyMerkleProof([]byte("Other"), proof, root) {
+				t.Errorf("Expected the proof of entry %d of %d not to verify another entry\n", i, n)
+			}
+		}
+	}
+
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	block := blockchainBlock.NewBlock("Light content", genesis.SelfHash, genesis.Index, blockchainBlock.MIN_DIFFICULTY)
+	node := &blockchainNode.Node{Acceptance_mu: &sync.Mutex{}}
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis, block}
+	server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+	defer server.Close()
+	node.Port = server.URL[strings.LastIndex(server.URL, ":")+1:]
+	node.Peers = blockchainNode.NewPeerSet(node.Port)
+
+	seed, receiptDir := blockchainUser.SEED, blockchainUser.RECEIPT_DIR
+	defer func() { blockchainUser.SEED, blockchainUser.RECEIPT_DIR = seed, receiptDir }()
+	blockchainUser.SEED, blockchainUser.RECEIPT_DIR = node.Port, t.TempDir()
+
+	user := &blockchainUser.User{Port: "light", Light: true}
+	hash := sha256.Sum256([]byte("Light content"))
+	user.Receipts = []*blockchainUser.Submission{{Content: "Light content", ContentHash: hex.EncodeToString(hash[:]), After: 0, SentAt: time.Now(), Attempts: 1}}
+
+	if pending := user.CheckReceipts(); pending != 0 || !user.Receipts[0].Confirmed || user.Receipts[0].Index != 1 {
+		t.Fatalf("Expected the light client to confirm its content with an inclusion proof\n")
+	}
+	if len(user.Headers) != 2 || !user.Headers[1].HeaderOnly || len(user.Headers[1].Entries) != 0 {
+		t.Errorf("Expected the light client to hold the headers of the blocks only\n")
+	}
+
+	proof := node.ProveInclusion(blockchainNode.ReceiptRequest{ContentHash: hex.EncodeToString(hash[:])})
+	light := blockchainUser.InclusionProof{Found: true, Index: proof.Index, BlockHash: proof.BlockHash, Entry: proof.Entry, Proof: proof.Proof}
+	if !user.VerifyInclusion(hex.EncodeToString(hash[:]), light) {
+		t.Errorf("Expected the node's inclusion proof to verify\n")
+	}
+	light.Entry = []byte("Other content")
+	other := sha256.Sum256(light.Entry)
+	if user.VerifyInclusion(hex.EncodeToString(other[:]), light) {
+		t.Errorf("Expected an inclusion proof for content not in the block to be refused\n")
+	}
+}
+
+/*
+Check that a node missing MISSED_PINGS_THRESHOLD pings is voted out of the
+network, and that a live node is not.
+*/
+func TestEviction(t *testing.T) {
+	fmt.Println("Testing Eviction...")
+	useTestLogger(t, "nodes")
+
+	nodes := []*blockchainNode.Node{}
+	servers := []*httptest.Server{}
+	ports := []string{}
+	for i := 0; i < 5; i++ {
+		node := &blockchainNode.Node{Acceptance_mu: &sync.Mutex{}}
+		server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+		defer server.Close()
+		node.Port = server.URL[strings.LastIndex(server.URL, ":")+1:]
+		nodes, servers, ports = append(nodes, node), append(servers, server), append(ports, node.Port)
+	}
+	for _, node := range nodes {
+		node.Peers = blockchainNode.NewPeerSet(ports...)
+	}
+
+	dead := ports[4]
+	servers[4].Close()
+	if blockchainNode.Ping(dead) || !blockchainNode.Ping(ports[1]) {
+		t.Fatalf("Expected only the closed node to miss its ping\n")
+	}
+
+	for i := 0; i < blockchainNode.MISSED_PINGS_THRESHOLD; i++ {
+		nodes[0].CheckLiveness()
+	}
+	for i, node := range nodes[:4] {
+		node := node
+		testutil.WaitFor(t, testutil.READY_TIMEOUT, fmt.Sprintf("node %d to drop the dead node", i), func() bool {
+			for _, port := range node.KnownPeers() {
+				if port == dead {
+					return false
+				}
+			}
+			return len(node.KnownPeers()) == 4
+		})
+	}
+
+	if nodes[0].Evict(ports[1]) || len(nodes[2].KnownPeers()) != 4 {
+		t.Errorf("Expected the peers to refuse evicting a live node\n")
+	}
+}
+
+/*
+Check that a subscriber gets the blocks a node accepts pushed over its WebSocket.
+*/
+func TestSubscribe(t *testing.T) {
+	fmt.Println("Testing Subscribe...")
+	useTestLogger(t, "nodes")
+
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	node := &blockchainNode.Node{Acceptance_mu: &sync.Mutex{}, Branches: blockchainNode.NewBranches(), Checkpoints: blockchainNode.NewCheckpoints()}
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis}
+	server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+	defer server.Close()
+	node.Port = server.URL[strings.LastIndex(server.URL, ":")+1:]
+
+	ws, err := test_helper.DialWebSocket(node.Port, blockchainNode.SUBSCRIBE)
+	if err != nil {
+		t.Fatalf("Could not subscribe: %v\n", err)
+	}
+	defer ws.Close()
+	messages := make(chan []byte)
+	go func() {
+		for {
+			message, err := ws.ReadMessage()
+			if err != nil {
+				close(messages)
+				return
+			}
+			messages <- message
+		}
+	}()
+
+	block := blockchainBlock.NewBlock("Pushed content", genesis.SelfHash, genesis.Index, blockchainBlock.NextDifficulty(node.Blockchain.Blocks))
+	body, _ := json.Marshal(block)
+	resp, err := http.Post(server.URL+blockchainNode.VALIDATE, "application/json", bytes.NewReader(body))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the node to accept the block\n")
+	}
+	resp.Body.Close()
+
+	select {
+	case message := <-messages:
+		var events blockchainNode.BlockEvents
+		if json.Unmarshal(message, &events) != nil || events.Height != 2 || len(events.Blocks) != 1 || events.Blocks[0].ContentString() != block.ContentString() {
+			t.Errorf("Expected the accepted block to be pushed but got %s\n", message)
+		}
+	case <-time.After(testutil.READY_TIMEOUT):
+		t.Fatalf("Expected the accepted block to be pushed to the subscriber\n")
+	}
+
+	resp, err = http.Get(server.URL + blockchainNode.SUBSCRIBE)
+	if err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a request that is not a WebSocket handshake to be refused\n")
+	}
+	if err == nil {
+		resp.Body.Close()
+	}
+}
+
+/*
+Check that a node reports the confirmations of content on /status, and
+posts them to the user's callback once the content is committed.
+*/
+func TestConfirmations(t *testing.T) {
+	fmt.Println("Testing Confirmations...")
+	useTestLogger(t, "nodes")
+
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	block := blockchainBlock.NewBlock("Committed content", genesis.SelfHash, genesis.Index, blockchainBlock.NextDifficulty([]*blockchainBlock.Block{genesis}))
+	node := &blockchainNode.Node{Acceptance_mu: &sync.Mutex{}, Branches: blockchainNode.NewBranches(), Checkpoints: blockchainNode.NewCheckpoints()}
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis, block}
+	server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+	defer server.Close()
+	node.Port = server.URL[strings.LastIndex(server.URL, ":")+1:]
+
+	contentHash := func(content string) string {
+		hash := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(hash[:])
+	}
+	committed := contentHash("Committed content")
+	status, ok := blockchainUser.GetContentStatus(node.Port, committed)
+	if !ok || !status.Found || status.Index != 1 || status.Confirmations != 1 || status.BlockHash != hex.EncodeToString(block.SelfHash) {
+		t.Fatalf("Expected the committed content to be in block 1 with 1 confirmation but got %+v\n", status)
+	}
+	if status, ok := blockchainUser.GetContentStatus(node.Port, contentHash("Unknown content")); !ok || status.Found {
+		t.Errorf("Expected unknown content not to be found\n")
+	}
+
+	receiptDir := blockchainUser.RECEIPT_DIR
+	defer func() { blockchainUser.RECEIPT_DIR = receiptDir }()
+	blockchainUser.RECEIPT_DIR = t.TempDir()
+
+	later := contentHash("Later content")
+	user := &blockchainUser.User{Port: "callback"}
+	user.Receipts = []*blockchainUser.Submission{
+		{Content: "Committed content", ContentHash: committed, SentAt: time.Now(), Attempts: 1},
+		{Content: "Later content", ContentHash: later, After: 1, SentAt: time.Now(), Attempts: 1},
+	}
+	userServer := httptest.NewServer(http.HandlerFunc(user.HandleConfirmation))
+	defer userServer.Close()
+	callback := strings.Replace(userServer.URL, "127.0.0.1", "localhost", 1) + blockchainUser.CONFIRMATION
+
+	if blockchainNode.ValidCallback("http://example.com/confirmation") || !blockchainNode.ValidCallback(callback) {
+		t.Errorf("Expected only local callbacks to be valid\n")
+	}
+
+	// Content committed already is confirmed right away
+	node.AddCallback(committed, callback)
+	testutil.WaitFor(t, testutil.READY_TIMEOUT, "the committed content to be confirmed", func() bool {
+		return user.Submissions()[0].Confirmed
+	})
+	if receipt := user.Submissions()[0]; receipt.Index != 1 || receipt.Confirmations != 1 {
+		t.Errorf("Expected the callback to carry the block index and confirmations\n")
+	}
+
+	// Other content is confirmed once a block holding it is accepted
+	node.AddCallback(later, callback)
+	time.Sleep(100 * time.Millisecond)
+	if user.Submissions()[1].Confirmed {
+		t.Fatalf("Expected content that is not committed not to be confirmed\n")
+	}
+	next := blockchainBlock.NewBlock("Later content", block.SelfHash, block.Index, blockchainBlock.NextDifficulty(node.Blockchain.Blocks))
+	body, _ := json.Marshal(next)
+	resp, err := http.Post(server.URL+blockchainNode.VALIDATE, "application/json", bytes.NewReader(body))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the node to accept the block\n")
+	}
+	resp.Body.Close()
+	testutil.WaitFor(t, testutil.READY_TIMEOUT, "the later content to be confirmed", func() bool {
+		return user.Submissions()[1].Confirmed
+	})
+	if index := user.Submissions()[1].Index; index != 2 {
+		t.Errorf("Expected the later content to be in block 2 but got %d\n", index)
+	}
+
+	if status, _ := blockchainUser.GetContentStatus(node.Port, committed); status.Confirmations != 2 {
+		t.Errorf("Expected the committed content to have 2 confirmations but got %d\n", status.Confirmations)
+	}
+}
+
+/*
+Check that blocks carry the content IDs of their entries under their PoW,
+and that /receipt/{id} finds content by its ID.
+*/
+func TestContentIDs(t *testing.T) {
+	fmt.Println("Testing Content IDs...")
+	useTestLogger(t, "nodes")
+
+	id := blockchainBlock.ContentID("Identified content", "alice", 1)
+	if id != blockchainBlock.ContentID("Identified content", "alice", 1) || id == blockchainBlock.ContentID("Identified content", "alice", 2) || id == blockchainBlock.ContentID("Identified content", "bob", 1) {
+		t.Fatalf("Expected content IDs to depend on the content, its author and its time only\n")
+	}
+
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	node := &blockchainNode.Node{Port: "1", Acceptance_mu: &sync.Mutex{}}
+	success, block := node.MineNewBlock([]string{"Identified content"}, []string{"alice"}, []string{id}, nil, genesis.SelfHash, genesis.Index, blockchainBlock.MIN_DIFFICULTY)
+	if !success || !block.Validate() || block.EntryWithID(id) != 0 {
+		t.Fatalf("Expected a valid block holding the content under its ID\n")
+	}
+	if header := block.Header(); len(header.ContentIDs) != 0 || !bytes.Equal(header.ContentIDsHash(), block.ContentIDsHash()) || !header.Validate() {
+		t.Errorf("Expected the header to keep the hash of the content IDs\n")
+	}
+	forged := *block
+	forged.ContentIDs = []string{blockchainBlock.ContentID("Identified content", "alice", 2)}
+	if forged.Validate() {
+		t.Errorf("Expected a block with forged content IDs to be invalid\n")
+	}
+
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis, block}
+	server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+	defer server.Close()
+	node.Port = server.URL[strings.LastIndex(server.URL, ":")+1:]
+	node.Peers = blockchainNode.NewPeerSet(node.Port)
+
+	receipt, ok := blockchainUser.GetReceipt(node.Port, id)
+	if !ok || !receipt.Found || receipt.ContentID != id || receipt.Index != 1 || receipt.BlockHash != hex.EncodeToString(block.SelfHash) || receipt.Confirmations != 1 {
+		t.Errorf("Expected the receipt of the content in block 1 with 1 confirmation but got %+v\n", receipt)
+	}
+	resp, err := http.Get(server.URL + blockchainNode.RECEIPT + "/" + blockchainBlock.ContentID("Unknown content", "alice", 1))
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected the receipt of unknown content to be 404 Not Found\n")
+	}
+	if err == nil {
+		resp.Body.Close()
+	}
+
+	// Users find their content by the ID they recorded
+	seed, receiptDir := blockchainUser.SEED, blockchainUser.RECEIPT_DIR
+	defer func() { blockchainUser.SEED, blockchainUser.RECEIPT_DIR = seed, receiptDir }()
+	blockchainUser.SEED, blockchainUser.RECEIPT_DIR = node.Port, t.TempDir()
+
+	user := &blockchainUser.User{Port: "identified", Address: "alice"}
+	user.RecordSubmission("Identified content", 0, 1)
+	if user.Receipts[0].ContentID != id {
+		t.Fatalf("Expected the submission to record the content ID\n")
+	}
+	if pending := user.CheckReceipts(); pending != 0 || !user.Receipts[0].Confirmed || user.Receipts[0].Index != 1 || user.Receipts[0].Confirmations != 1 {
+		t.Errorf("Expected the user to confirm its content by its ID\n")
+	}
+}
+
+/*
+Check that loggers drop the lines below their level, prefix the lines of
+derived loggers, and that log files are rotated past their size.
+*/
+func TestLogger(t *testing.T) {
+	fmt.Println("Testing Logger...")
+
+	var out bytes.Buffer
+	logger := test_helper.NewLogger(&out, test_helper.INFO)
+	node := logger.With("node 1234")
+	node.Debugf("dropped")
+	node.Infof("accepted block{ %s }", "content")
+	logger.Warnf("no prefix")
+	lines := strings.Split(strings.TrimRight(out.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], "INFO  [node 1234] accepted block{ content }") || !strings.HasSuffix(lines[1], "WARN  no prefix") {
+		t.Errorf("Expected an INFO line of node 1234 and a WARN line but got %q\n", out.String())
+	}
+
+	node.SetLevel(test_helper.DEBUG) // Shared with the parent logger
+	if !logger.Enabled(test_helper.DEBUG) {
+		t.Errorf("Expected derived loggers to share the level of their parent\n")
+	}
+	if level, err := test_helper.ParseLevel("Warn"); err != nil || level != test_helper.WARN {
+		t.Errorf("Expected warn to parse as WARN but got %v (%v)\n", level, err)
+	}
+	if _, err := test_helper.ParseLevel("loud"); err == nil {
+		t.Errorf("Expected an unknown level to be refused\n")
+	}
+
+	path := filepath.Join(t.TempDir(), "node.log")
+	file, err := test_helper.OpenRotatingFile(path, 64, 2)
+	if err != nil {
+		t.Fatalf("Could not open the log file: %v\n", err)
+	}
+	defer file.Close()
+	line := strings.Repeat("x", 39) + "\n"
+	for i := 0; i < 5; i++ {
+		if _, err := file.Write([]byte(line)); err != nil {
+			t.Fatalf("Could not write to the log file: %v\n", err)
+		}
+	}
+	for _, rotated := range []string{path, path + ".1", path + ".2"} {
+		if content, err := os.ReadFile(rotated); err != nil || string(content) != line {
+			t.Errorf("Expected %s to hold a single line after rotating (%v)\n", rotated, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 rotated files to be kept\n")
+	}
+}
+
+/*
+Check that /metrics counts the blocks a node mined and validated, and
+reports its chain height, in the Prometheus text format.
+*/
+func TestMetrics(t *testing.T) {
+	fmt.Println("Testing Metrics...")
+	useTestLogger(t, "nodes")
+
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	node := &blockchainNode.Node{Acceptance_mu: &sync.Mutex{}, Branches: blockchainNode.NewBranches(), Checkpoints: blockchainNode.NewCheckpoints()}
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis}
+	server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+	defer server.Close()
+	node.Port = server.URL[strings.LastIndex(server.URL, ":")+1:]
+
+	success, block := node.MineNewBlock([]string{"Measured content"}, nil, nil, nil, genesis.SelfHash, genesis.Index, blockchainBlock.NextDifficulty(node.Blockchain.Blocks))
+	if !success {
+		t.Fatalf("Expected the node to mine a block\n")
+	}
+	body, _ := json.Marshal(block)
+	resp, err := http.Post(server.URL+blockchainNode.VALIDATE, "application/json", bytes.NewReader(body))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the node to accept the block\n")
+	}
+	resp.Body.Close()
+
+	resp, err = http.Get(server.URL + blockchainNode.METRICS)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the node to serve its metrics\n")
+	}
+	metrics, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("Expected metrics in the Prometheus text format\n")
+	}
+
+	for _, line := range []string{
+		"# TYPE blockchain_blocks_mined_total counter",
+		"blockchain_blocks_mined_total 1",
+		"# TYPE blockchain_mining_duration_seconds histogram",
+		"blockchain_mining_duration_seconds_bucket{le=\"+Inf\"} 1",
+		"blockchain_mining_duration_seconds_count 1",
+		"blockchain_validation_requests_total 1",
+		"blockchain_conflicts_total 0",
+		"blockchain_chain_height 2",
+	} {
+		if !strings.Contains(string(metrics), line+"\n") {
+			t.Errorf("Expected the metrics to have %q but got:\n%s", line, metrics)
+		}
+	}
+}
+
+/*
+Check that the configuration is read from its defaults, a YAML file and the
+environment, in that order, and that invalid values are refused.
+*/
+func TestConfig(t *testing.T) {
+	fmt.Println("Testing Config...")
+
+	config, err := blockchainConfig.Load("")
+	if err != nil || config != blockchainConfig.Default() {
+		t.Fatalf("Expected the default configuration without a file or environment\n")
+	}
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte("# Test network\ndifficulty: 12\nseed_port: \"2345\"\nwait_time: 500ms # Faster\nfee_per_byte: 2\ngovernors: \"a1b2, c3d4\"\n\nlog_file: ''\n"), 0644)
+	t.Setenv("BLOCKCHAIN_WAIT_TIME", "250ms")
+	config, err = blockchainConfig.Load(path)
+	if err != nil {
+		t.Fatalf("Expected the configuration file to load but got %v\n", err)
+	}
+	if config.Difficulty != 12 || config.SeedPort != "2345" || config.FeePerByte != 2 || config.Governors != "a1b2, c3d4" || config.LogFile != "" {
+		t.Errorf("Expected the file to override the defaults but got %+v\n", config)
+	}
+	if config.WaitTime != 250*time.Millisecond {
+		t.Errorf("Expected the environment to override the file but got %v\n", config.WaitTime)
+	}
+	if config.StoreDir != blockchainConfig.Default().StoreDir {
+		t.Errorf("Expected keys missing from the file to keep their default\n")
+	}
+
+	t.Setenv("BLOCKCHAIN_WAIT_TIME", "soon")
+	if _, err := blockchainConfig.Load(path); err == nil {
+		t.Errorf("Expected an invalid duration to be an error\n")
+	}
+	os.WriteFile(path, []byte("dificulty: 12\n"), 0644)
+	if _, err := blockchainConfig.Load(""); err == nil {
+		t.Errorf("Expected an invalid duration in the environment to be an error\n")
+	}
+	t.Setenv("BLOCKCHAIN_WAIT_TIME", "1s")
+	if _, err := blockchainConfig.Load(path); err == nil {
+		t.Errorf("Expected an unknown key to be an error\n")
+	}
+
+	config = blockchainConfig.Default()
+	config.Difficulty = blockchainBlock.MAX_DIFFICULTY + 1
+	if blockchainNode.Configure(config) == nil || blockchainBlock.DIFFICULTY == config.Difficulty {
+		t.Errorf("Expected nodes to reject a difficulty out of range\n")
+	}
+	config = blockchainConfig.Default()
+	config.ReceiptCheckTime = 0
+	if blockchainUser.Configure(config) == nil {
+		t.Errorf("Expected users to reject a receipt check time that is not positive\n")
+	}
+}
+
+/*
+Check that nodes serve their API over TLS, and that under mutual TLS the
+calls only peers make are refused from callers without a certificate.
+*/
+func TestTLS(t *testing.T) {
+	fmt.Println("Testing TLS...")
+	useTestLogger(t, "nodes")
+	dir := t.TempDir()
+	ca, err := test_helper.NewCertificateAuthority("test nodes")
+	if err != nil {
+		t.Fatalf("Expected a certificate authority but got %v\n", err)
+	}
+	caFile := filepath.Join(dir, "ca.pem")
+	if ca.WriteFiles(caFile, filepath.Join(dir, "ca-key.pem")) != nil || ca.Issue("node 1", filepath.Join(dir, "1.pem"), filepath.Join(dir, "1-key.pem")) != nil {
+		t.Fatalf("Expected the certificates to be written\n")
+	}
+	if loaded, err := test_helper.LoadCertificateAuthority(caFile, filepath.Join(dir, "ca-key.pem")); err != nil || !loaded.Certificate.Equal(ca.Certificate) {
+		t.Fatalf("Expected the certificate authority to load back\n")
+	}
+
+	if err := test_helper.EnableTLS(filepath.Join(dir, "1.pem"), filepath.Join(dir, "1-key.pem"), caFile, true); err != nil {
+		t.Fatalf("Expected TLS to be enabled but got %v\n", err)
+	}
+	defer test_helper.DisableTLS()
+
+	node := &blockchainNode.Node{Acceptance_mu: &sync.Mutex{}, Branches: blockchainNode.NewBranches(), Checkpoints: blockchainNode.NewCheckpoints()}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(node.HandleRequests))
+	server.Listener = test_helper.ListenTLS(server.Listener)
+	server.Start()
+	defer server.Close()
+	node.Port = server.URL[strings.LastIndex(server.URL, ":")+1:]
+
+	// Peers call with their certificate over HTTPS
+	url := test_helper.NodeURL(node.Port)
+	if !strings.HasPrefix(url, "https://") {
+		t.Fatalf("Expected nodes to be called over HTTPS but got %s\n", url)
+	}
+	resp, err := test_helper.HTTP_CLIENT.Post(url+blockchainNode.NEW_CHAIN, "application/json", strings.NewReader("{}"))
+	if err != nil || resp.StatusCode == http.StatusUnauthorized {
+		t.Fatalf("Expected a peer with a certificate to be heard but got %v\n", err)
+	}
+	resp.Body.Close()
+
+	// Other processes trust the nodes but hold no certificate
+	anonymous := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: test_helper.TLS_CLIENT_CONFIG.RootCAs}}}
+	for _, path := range []string{blockchainNode.VALIDATE, blockchainNode.NEW_CHAIN} {
+		resp, err := anonymous.Post(url+path, "application/json", strings.NewReader("{}"))
+		if err != nil || resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected %s to be refused without a certificate\n", path)
+		} else {
+			resp.Body.Close()
+		}
+	}
+	resp, err = anonymous.Get(url + blockchainNode.STATUS)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected users to be served without a certificate\n")
+	} else {
+		resp.Body.Close()
+	}
+	// A governor's change is authorized by its signature, not by a certificate
+	resp, err = anonymous.Post(url+blockchainNode.DIFFICULTY_CHANGE, "application/json", strings.NewReader("{}"))
+	if err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected an unsigned difficulty change to be judged by its signature without a certificate\n")
+	} else {
+		resp.Body.Close()
+	}
+
+	// Plaintext HTTP is not served
+	if resp, err := http.Get("http://localhost:" + node.Port + blockchainNode.STATUS); err == nil {
+		if resp.StatusCode == http.StatusOK {
+			t.Errorf("Expected plaintext HTTP to be refused\n")
+		}
+		resp.Body.Close()
+	}
+}
+
+/*
+Check that blocks over the content or block size are invalid, and that
+nodes refuse oversized content and blockchains.
+*/
+func TestSizeLimits(t *testing.T) {
+	fmt.Println("Testing Size Limits...")
+	useTestLogger(t, "nodes")
+	maxContent, maxBlock := blockchainBlock.MAX_CONTENT_SIZE, blockchainBlock.MAX_BLOCK_SIZE
+	blockchainBlock.MAX_CONTENT_SIZE, blockchainBlock.MAX_BLOCK_SIZE = 16, 24
+	defer func() { blockchainBlock.MAX_CONTENT_SIZE, blockchainBlock.MAX_BLOCK_SIZE = maxContent, maxBlock }()
+
+	block := blockchainBlock.NewBlock("Small content", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	if block.Size() != len("Small content") || !block.Validate() {
+		t.Fatalf("Expected a block within the limits to be valid\n")
+	}
+	if blockchainBlock.NewBlock("Content over the limit", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY).Validate() {
+		t.Errorf("Expected a block with oversized content to be invalid\n")
+	}
+	entries := [][]byte{[]byte("Fifteen bytes!!"), []byte("Fifteen bytes!!")}
+	full := &blockchainBlock.Block{Entries: entries, MerkleRoot: blockchainBlock.MerkleRoot(entries), Difficulty: blockchainBlock.MIN_DIFFICULTY}
+	full.Nonce, full.SelfHash = blockchainBlock.NewProofOfWork(full).Run()
+	if full.Validate() {
+		t.Errorf("Expected a block over the block size to be invalid\n")
+	}
+	if !full.Header().Validate() || blockchainNode.WithinSizeLimits([]*blockchainBlock.Block{block, full}) {
+		t.Errorf("Expected only the blocks over the limits to be refused\n")
+	}
+
+	node := &blockchainNode.Node{Acceptance_mu: &sync.Mutex{}, Branches: blockchainNode.NewBranches(), Checkpoints: blockchainNode.NewCheckpoints()}
+	server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+	defer server.Close()
+
+	content, _ := json.Marshal(blockchainUser.Content{Content: "Content over the limit"})
+	resp, err := http.Post(server.URL+blockchainNode.CONTENT, "application/json", bytes.NewReader(content))
+	if err != nil || resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected oversized content to be refused\n")
+	} else {
+		resp.Body.Close()
+	}
+
+	chain, _ := json.Marshal(blockchainBlockchain.Blockchain{Blocks: []*blockchainBlock.Block{block, full}})
+	resp, err = http.Post(server.URL+blockchainNode.NEW_CHAIN, "application/json", bytes.NewReader(chain))
+	if err != nil || resp.StatusCode != http.StatusRequestEntityTooLarge || len(node.Blockchain.Blocks) != 0 {
+		t.Errorf("Expected a new blockchain with oversized blocks to be refused\n")
+	} else {
+		resp.Body.Close()
+	}
+}
+
+/*
+Check that under Proof of Stake, proposers are selected by stake, the same
+on every node, that only the selected proposer's blocks are valid, and that
+other nodes relay content to it.
+*/
+func TestProofOfStake(t *testing.T) {
+	fmt.Println("Testing Proof of Stake...")
+	useTestLogger(t, "nodes")
+	useProposedBlocks(t)
+
+	stakeList := filepath.Join(t.TempDir(), "StakeList.txt")
+	if blockchainPoS.RegisterStake(stakeList, "1", 1) != nil || blockchainPoS.RegisterStake(stakeList, "2", 3) != nil || blockchainPoS.RegisterStake(stakeList, "3", 0) != nil {
+		t.Fatalf("Expected the stakes to be registered\n")
+	}
+	stakes := blockchainPoS.LoadStakes(stakeList)
+	if len(stakes) != 3 || stakes["2"] != 3 {
+		t.Fatalf("Expected the stakes to load back but got %v\n", stakes)
+	}
+
+	counts := map[string]int{}
+	for height := 0; height < 1000; height++ {
+		proposer, ok := stakes.Proposer(height, []byte("tip"), []string{"3", "2", "1"})
+		if again, _ := stakes.Proposer(height, []byte("tip"), []string{"1", "2", "3"}); !ok || again != proposer {
+			t.Fatalf("Expected every node to select the same proposer\n")
+		}
+		counts[proposer]++
+	}
+	if counts["3"] != 0 || counts["1"] < 150 || counts["2"] < 2*counts["1"] {
+		t.Errorf("Expected proposers to be selected in proportion to their stake but got %v\n", counts)
+	}
+	if proposer, ok := stakes.Proposer(7, []byte("tip"), []string{"1", "3"}); !ok || proposer != "1" {
+		t.Errorf("Expected only the eligible nodes to be selected\n")
+	}
+	if _, ok := stakes.Proposer(7, []byte("tip"), []string{"3", "4"}); ok {
+		t.Errorf("Expected no proposer without a staked node\n")
+	}
+
+	// A node proposes the block of the heights it is selected for, and seals it without a PoW
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	engine := blockchainPoS.NewEngine(stakeList)
+	chain := []*blockchainBlock.Block{genesis}
+	proposer, _ := engine.Leader(chain, []string{"1", "2"})
+	other := map[string]string{"1": "2", "2": "1"}[proposer]
+
+	node := &blockchainNode.Node{Port: proposer, Consensus: engine, Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("1", "2"), Checkpoints: blockchainNode.NewCheckpoints()}
+	node.Blockchain.Blocks = chain
+	success, block := node.MineNewBlock([]string{"Staked content"}, []string{"alice"}, []string{"id"}, nil, genesis.SelfHash, genesis.Index, blockchainBlock.DIFFICULTY)
+	if !success || block.Proposer != proposer || block.Difficulty != 0 || !block.Validate() || !block.Header().Validate() {
+		t.Fatalf("Expected the proposer to seal a valid block\n")
+	}
+	if !node.ValidateBlock(*block, 0) {
+		t.Errorf("Expected the block of the selected proposer to be valid\n")
+	}
+	forged := *block
+	forged.Entries = [][]byte{[]byte("Forged content")}
+	forged.MerkleRoot = blockchainBlock.MerkleRoot(forged.Entries)
+	if forged.Validate() {
+		t.Errorf("Expected a proposed block with forged content to be invalid\n")
+	}
+
+	node.Port = other
+	if success, _ := node.MineNewBlock([]string{"Staked content"}, nil, nil, nil, genesis.SelfHash, genesis.Index, blockchainBlock.DIFFICULTY); success {
+		t.Errorf("Expected a node that is not the proposer not to propose a block\n")
+	}
+	wrong := *block
+	wrong.Proposer = other
+	engine.Seal(&wrong, nil)
+	if node.ValidateBlock(wrong, 0) {
+		t.Errorf("Expected a block proposed by another node to be invalid\n")
+	}
+
+	// Content sent to another node is relayed to the proposer
+	relayed := make(chan blockchainUser.Content, 1)
+	proposerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var content blockchainUser.Content
+		if r.URL.Path == blockchainNode.CONTENT && r.Header.Get(blockchainNode.RELAYED_HEADER) != "" && json.NewDecoder(r.Body).Decode(&content) == nil {
+			relayed <- content
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proposerServer.Close()
+	proposerPort := proposerServer.URL[strings.LastIndex(proposerServer.URL, ":")+1:]
+
+	relayStakes := filepath.Join(t.TempDir(), "StakeList.txt")
+	blockchainPoS.RegisterStake(relayStakes, proposerPort, 1)
+	relay := &blockchainNode.Node{Consensus: blockchainPoS.NewEngine(relayStakes), Acceptance_mu: &sync.Mutex{}, Checkpoints: blockchainNode.NewCheckpoints()}
+	relay.Blockchain.Blocks = chain
+	server := httptest.NewServer(http.HandlerFunc(relay.HandleRequests))
+	defer server.Close()
+	relay.Port = server.URL[strings.LastIndex(server.URL, ":")+1:]
+	relay.Peers = blockchainNode.NewPeerSet(relay.Port, proposerPort)
+
+	userList := blockchainNode.USER_LIST
+	defer func() { blockchainNode.USER_LIST = userList }()
+	blockchainNode.USER_LIST = filepath.Join(t.TempDir(), "UserList.txt")
+	alice := blockchainUser.User{}
+	alice.RegisterUser(blockchainNode.USER_LIST, SEED)
+	alice.SendContentToNode(relay.Port, "Staked content", 1)
+
+	select {
+	case content := <-relayed:
+		if content.Content != "Staked content" || content.User.Address != alice.Address || content.Timestamp != 1 {
+			t.Errorf("Expected the signed content to be relayed as sent but got %+v\n", content)
+		}
+	case <-time.After(testutil.READY_TIMEOUT):
+		t.Errorf("Expected the content to be relayed to the proposer\n")
+	}
+}
+
+/* A consensus engine where a fixed validator seals every block, to test engines dropped into nodes */
+type fixedValidator struct {
+	port string
+}
+
+func (engine fixedValidator) Prepare(blocks []*blockchainBlock.Block, block *blockchainBlock.Block, port string, peers []string) bool {
+	block.Proposer = port
+	block.Difficulty = 0
+	return port == engine.port
+}
+
+func (engine fixedValidator) Seal(block *blockchainBlock.Block, interrupted func() bool) bool {
+	block.SelfHash = block.Hash()
+	return true
+}
+
+func (engine fixedValidator) Verify(blocks []*blockchainBlock.Block, block *blockchainBlock.Block, peers []string) bool {
+	return block.Proposer == engine.port && block.Validate()
+}
+
+func (engine fixedValidator) Leader(blocks []*blockchainBlock.Block, peers []string) (string, bool) {
+	return engine.port, true
+}
+
+/*
+Check that Proof of Work seals and verifies blocks behind the Consensus
+interface, and that nodes seal and validate blocks with any engine they
+are given.
+*/
+func TestConsensus(t *testing.T) {
+	fmt.Println("Testing Consensus...")
+	useTestLogger(t, "nodes")
+
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	chain := []*blockchainBlock.Block{genesis}
+
+	var engine blockchainConsensus.Consensus = blockchainPoW.NewEngine(2)
+	entries := [][]byte{[]byte("Mined content")}
+	block := &blockchainBlock.Block{PrevBlockHash: genesis.SelfHash, Index: 1, Entries: entries, MerkleRoot: blockchainBlock.MerkleRoot(entries), Difficulty: blockchainBlock.NextDifficulty(chain)}
+	if !engine.Prepare(chain, block, "1", nil) || !engine.Seal(block, func() bool { return false }) {
+		t.Fatalf("Expected any node to mine a block\n")
+	}
+	if !engine.Verify(chain, block, nil) {
+		t.Errorf("Expected the mined block to verify\n")
+	}
+	easier := *block
+	easier.Difficulty = blockchainBlock.NextDifficulty(chain) - 1
+	if engine.Seal(&easier, func() bool { return false }); engine.Verify(chain, &easier, nil) {
+		t.Errorf("Expected a block that does not declare the expected difficulty not to verify\n")
+	}
+	hard := &blockchainBlock.Block{Difficulty: blockchainBlock.MAX_DIFFICULTY}
+	if engine.Seal(hard, func() bool { return true }) {
+		t.Errorf("Expected mining to stop once interrupted\n")
+	}
+	if _, led := engine.(blockchainConsensus.Leader); led {
+		t.Errorf("Expected any node to mine under Proof of Work\n")
+	}
+
+	// Under Proof of Work, naming a proposer does not spare a block its PoW
+	forged := &blockchainBlock.Block{PrevBlockHash: genesis.SelfHash, Index: 1, Timestamp: genesis.Timestamp + 1, Entries: entries, MerkleRoot: blockchainBlock.MerkleRoot(entries), Difficulty: blockchainBlock.MAX_DIFFICULTY, Proposer: "1", NetworkID: genesis.NetworkID}
+	forged.SelfHash = forged.Hash()
+	if forged.Validate() || (&blockchainBlockchain.Blockchain{Blocks: []*blockchainBlock.Block{genesis, forged}}).Verify() == nil {
+		t.Errorf("Expected a block naming a proposer without a PoW to be invalid\n")
+	}
+
+	// An engine dropped into nodes seals and validates their blocks
+	useProposedBlocks(t)
+	validator := &blockchainNode.Node{Port: "1", Consensus: fixedValidator{port: "1"}, Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("1", "2"), Checkpoints: blockchainNode.NewCheckpoints()}
+	validator.Blockchain.Blocks = chain
+	success, sealed := validator.MineNewBlock([]string{"Validated content"}, nil, nil, nil, genesis.SelfHash, genesis.Index, blockchainBlock.DIFFICULTY)
+	if !success || sealed.Proposer != "1" || !validator.ValidateBlock(*sealed, 0) {
+		t.Fatalf("Expected the validator to seal a valid block\n")
+	}
+	other := &blockchainNode.Node{Port: "2", Consensus: fixedValidator{port: "1"}, Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("1", "2"), Checkpoints: blockchainNode.NewCheckpoints()}
+	other.Blockchain.Blocks = chain
+	if success, _ := other.MineNewBlock([]string{"Validated content"}, nil, nil, nil, genesis.SelfHash, genesis.Index, blockchainBlock.DIFFICULTY); success {
+		t.Errorf("Expected only the validator to seal blocks\n")
+	}
+	if !other.ValidateBlock(*sealed, 0) {
+		t.Errorf("Expected other nodes to validate the validator's block\n")
+	}
+	mined := blockchainBlock.NewBlock("Mined content", genesis.SelfHash, genesis.Index, blockchainBlock.NextDifficulty(chain))
+	if other.ValidateBlock(*mined, 0) {
+		t.Errorf("Expected a mined block to be invalid under another engine\n")
+	}
+}
+
+/*
+Check that transfers are signed by their sender, that nodes derive balances
+from the transfers on their blockchain, and that they refuse blocks and
+content spending more than a balance.
+*/
+func TestTransfers(t *testing.T) {
+	fmt.Println("Testing Transfers...")
+	useTestLogger(t, "nodes")
+	useProposedBlocks(t)
+
+	userList := blockchainNode.USER_LIST
+	defer func() { blockchainNode.USER_LIST = userList }()
+	blockchainNode.USER_LIST = filepath.Join(t.TempDir(), "UserList.txt")
+	alice := blockchainUser.User{}
+	alice.RegisterUser(blockchainNode.USER_LIST, SEED)
+	wallet, _ := blockchainWallet.NewWallet()
+	bob, _ := blockchainWallet.Address(wallet.PublicKey)
+
+	transfer, ok := alice.Transfer(bob, 60)
+	if parsed, isTransfer := blockchainBlock.ParseTransfer([]byte(transfer.String())); !ok || !isTransfer || parsed != transfer || !parsed.Verify() {
+		t.Fatalf("Expected a signed transfer to parse back and verify\n")
+	}
+	raised := transfer
+	raised.Amount = 600
+	if raised.Verify() {
+		t.Errorf("Expected a transfer whose amount changed after signing not to verify\n")
+	}
+	forged := blockchainBlock.Transfer{From: alice.Address, To: bob, Amount: 60, PublicKey: wallet.PublicKey}
+	forged.Signature, _ = wallet.Sign(forged.Message())
+	if forged.Verify() {
+		t.Errorf("Expected a transfer signed by another wallet than its sender's not to verify\n")
+	}
+
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	chain := []*blockchainBlock.Block{genesis}
+	node := &blockchainNode.Node{Port: "1", Consensus: fixedValidator{port: "1"}, Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("1"), Checkpoints: blockchainNode.NewCheckpoints()}
+	node.Blockchain.Blocks = chain
+	server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+	defer server.Close()
+
+	balance := func(address string) blockchainNode.Balance {
+		var balance blockchainNode.Balance
+		resp, err := http.Get(server.URL + blockchainNode.BALANCE + "/" + address)
+		if err != nil || resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&balance) != nil {
+			t.Fatalf("Expected the balance of %s\n", address)
+		}
+		resp.Body.Close()
+		return balance
+	}
+	if got := balance(alice.Address); got.Balance != blockchainNode.INITIAL_BALANCE || got.Height != 1 {
+		t.Errorf("Expected every address to start with %d but got %+v\n", blockchainNode.INITIAL_BALANCE, got)
+	}
+
+	// A block spending more than a balance is invalid, even over several transfers
+	_, overspent := node.MineNewBlock([]string{transfer.String(), transfer.String()}, nil, nil, nil, genesis.SelfHash, genesis.Index, blockchainBlock.DIFFICULTY)
+	if node.ValidateBlock(*overspent, 0) {
+		t.Errorf("Expected a block overspending a balance to be invalid\n")
+	}
+	_, paid := node.MineNewBlock([]string{transfer.String()}, nil, nil, nil, genesis.SelfHash, genesis.Index, blockchainBlock.DIFFICULTY)
+	if !node.ValidateBlock(*paid, 0) {
+		t.Fatalf("Expected a block with an affordable transfer to be valid\n")
+	}
+	node.Blockchain.Blocks = append(chain, paid)
+	if got := balance(alice.Address); got.Balance != 40 || got.Height != 2 {
+		t.Errorf("Expected the sender's balance to drop to 40 but got %+v\n", got)
+	}
+	if got := balance(bob); got.Balance != 160 {
+		t.Errorf("Expected the recipient's balance to rise to 160 but got %+v\n", got)
+	}
+
+	// Content transferring more than a balance is refused, so are forged transfers
+	send := func(transfer blockchainBlock.Transfer) int {
+		signature, _ := alice.Sign(transfer.String())
+		content, _ := json.Marshal(blockchainUser.Content{Content: transfer.String(), User: alice, Signature: signature})
+		resp, err := http.Post(server.URL+blockchainNode.CONTENT, "application/json", bytes.NewReader(content))
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := send(transfer); code != http.StatusPaymentRequired {
+		t.Errorf("Expected a transfer over the balance to be refused with 402 but got %d\n", code)
+	}
+	if code := send(forged); code != http.StatusForbidden {
+		t.Errorf("Expected a forged transfer to be refused with 403 but got %d\n", code)
+	}
+
+	// Balances follow the blockchain when the node adopts another one
+	node.Blockchain.Blocks = chain
+	if got := balance(bob); got.Balance != blockchainNode.INITIAL_BALANCE || got.Height != 1 {
+		t.Errorf("Expected the balances to be derived again from the adopted chain but got %+v\n", got)
+	}
+}
+
+/*
+Check that nodes keep the unspent outputs of the transactions on their
+blockchain, that an output may only be spent once, by its owner, and that
+payments spend the user's outputs with change paid back.
+*/
+func TestUTXOs(t *testing.T) {
+	fmt.Println("Testing UTXOs...")
+	useTestLogger(t, "nodes")
+	useProposedBlocks(t)
+
+	userList := blockchainNode.USER_LIST
+	defer func() { blockchainNode.USER_LIST = userList }()
+	blockchainNode.USER_LIST = filepath.Join(t.TempDir(), "UserList.txt")
+	alice, bob := blockchainUser.User{}, blockchainUser.User{}
+	alice.RegisterUser(blockchainNode.USER_LIST, SEED)
+	bob.RegisterUser(blockchainNode.USER_LIST, SEED)
+
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	chain := []*blockchainBlock.Block{genesis}
+	node := &blockchainNode.Node{Port: "1", Consensus: fixedValidator{port: "1"}, Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("1"), Checkpoints: blockchainNode.NewCheckpoints()}
+	node.Blockchain.Blocks = chain
+	server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+	defer server.Close()
+	port := server.URL[strings.LastIndex(server.URL, ":")+1:]
+
+	utxos, ok := blockchainUser.GetUnspentOutputs(port, alice.Address)
+	if !ok || len(utxos) != 1 || utxos[0].OutPoint != blockchainBlock.Allocation(alice.Address) || utxos[0].Amount != blockchainNode.INITIAL_BALANCE {
+		t.Fatalf("Expected every address to start with its allocation but got %+v\n", utxos)
+	}
+	payment, ok := alice.Payment(utxos, bob.Address, 60)
+	if !ok || len(payment.Outputs) != 2 || payment.Outputs[1] != (blockchainBlock.Output{Address: alice.Address, Amount: 40}) {
+		t.Fatalf("Expected the payment to pay the change back but got %+v\n", payment)
+	}
+	if parsed, isTransaction := blockchainBlock.ParseTransaction([]byte(payment.String())); !isTransaction || parsed.ID() != payment.ID() || !parsed.Verify() {
+		t.Fatalf("Expected a signed transaction to parse back and verify\n")
+	}
+	if _, ok := alice.Payment(utxos, bob.Address, 101); ok {
+		t.Errorf("Expected no payment over the outputs spent\n")
+	}
+
+	// An output may only be spent once, within a block or across blocks
+	other, _ := alice.Payment(utxos, bob.Address, 10)
+	_, spentTwice := node.MineNewBlock([]string{payment.String(), other.String()}, nil, nil, nil, genesis.SelfHash, genesis.Index, blockchainBlock.DIFFICULTY)
+	if node.ValidateBlock(*spentTwice, 0) {
+		t.Errorf("Expected a block spending an output twice to be invalid\n")
+	}
+	_, paid := node.MineNewBlock([]string{payment.String()}, nil, nil, nil, genesis.SelfHash, genesis.Index, blockchainBlock.DIFFICULTY)
+	if !node.ValidateBlock(*paid, 0) {
+		t.Fatalf("Expected a block spending unspent outputs to be valid\n")
+	}
+	node.Blockchain.Blocks = append(chain, paid)
+	_, again := node.MineNewBlock([]string{other.String()}, nil, nil, nil, paid.SelfHash, paid.Index, blockchainBlock.DIFFICULTY)
+	if node.ValidateBlock(*again, 0) {
+		t.Errorf("Expected a block spending a spent output to be invalid\n")
+	}
+
+	change, _ := blockchainUser.GetUnspentOutputs(port, alice.Address)
+	received, _ := blockchainUser.GetUnspentOutputs(port, bob.Address)
+	if len(change) != 1 || change[0].Amount != 40 || change[0].TxID != payment.ID() {
+		t.Errorf("Expected the sender to be left with its change but got %+v\n", change)
+	}
+	if len(received) != 2 || received[1].Amount != 60 {
+		t.Errorf("Expected the recipient to hold its allocation and the payment but got %+v\n", received)
+	}
+
+	// Only the owner of an output spends it
+	stolen, _ := bob.Payment(change, bob.Address, 40)
+	if node.Spendable(stolen) {
+		t.Errorf("Expected an output not to be spent by another user\n")
+	}
+	signature, _ := alice.Sign(other.String())
+	content, _ := json.Marshal(blockchainUser.Content{Content: other.String(), User: alice, Signature: signature})
+	resp, err := http.Post(server.URL+blockchainNode.CONTENT, "application/json", bytes.NewReader(content))
+	if err != nil || resp.StatusCode != http.StatusPaymentRequired {
+		t.Errorf("Expected a transaction spending a spent output to be refused\n")
+	} else {
+		resp.Body.Close()
+	}
+}
+
+/*
+Check that the mempool mines content paying the highest fees first, that
+mined blocks pay their fees to the miner out of the authors' balances, and
+that nodes refuse fees no balance covers or no signature covers.
+*/
+func TestFees(t *testing.T) {
+	fmt.Println("Testing Fees...")
+	useTestLogger(t, "nodes")
+	useProposedBlocks(t)
+
+	pool := blockchainNode.NewMempool()
+	pool.Add("Free content", "", "", 0)
+	pool.Add("Paying content", "", "", 5)
+	pool.Add("Also paying content", "", "", 5)
+	pool.Add("Cheap content", "", "", 1)
+	if pending := pool.Pending(); strings.Join(pending, ", ") != "Paying content, Also paying content, Cheap content, Free content" {
+		t.Errorf("Expected content to be mined highest fee first but got %v\n", pending)
+	}
+
+	full := blockchainNode.NewMempool()
+	var last chan bool
+	for i := 0; i < blockchainNode.MEMPOOL_SIZE; i++ {
+		last, _ = full.Add(fmt.Sprintf("Content %d", i), "", "", 1)
+	}
+	if mined, _ := full.Add("Content paying as much", "", "", 1); mined != nil {
+		t.Errorf("Expected a full mempool to refuse content paying no more than its lowest fee\n")
+	}
+	if mined, evicted := full.Add("Content paying more", "", "", 2); mined == nil || !evicted || len(last) != 1 || <-last {
+		t.Errorf("Expected content paying more to take the place of the lowest fee\n")
+	}
+
+	userList := blockchainNode.USER_LIST
+	defer func() { blockchainNode.USER_LIST = userList }()
+	blockchainNode.USER_LIST = filepath.Join(t.TempDir(), "UserList.txt")
+	alice := blockchainUser.User{}
+	alice.RegisterUser(blockchainNode.USER_LIST, SEED)
+	wallet, _ := blockchainWallet.NewWallet()
+	miner, _ := blockchainWallet.Address(wallet.PublicKey)
+
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	chain := []*blockchainBlock.Block{genesis}
+	node := &blockchainNode.Node{Port: "1", Address: miner, Consensus: fixedValidator{port: "1"}, Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("1"), Checkpoints: blockchainNode.NewCheckpoints()}
+	node.Blockchain.Blocks = chain
+	server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+	defer server.Close()
+
+	_, paid := node.MineNewBlock([]string{"Paid content"}, []string{alice.Address}, []string{"id"}, []uint64{30}, genesis.SelfHash, genesis.Index, blockchainBlock.DIFFICULTY)
+	if paid.Miner != miner || paid.TotalFees() != 30 || !node.ValidateBlock(*paid, 0) {
+		t.Fatalf("Expected the mined block to pay its fees to the miner\n")
+	}
+	raised := *paid
+	raised.Fees = []uint64{3000}
+	if raised.Validate() || !paid.Header().Validate() {
+		t.Errorf("Expected the fees of a block to be covered by its hash\n")
+	}
+	_, overpaid := node.MineNewBlock([]string{"Overpaid content"}, []string{alice.Address}, []string{"id"}, []uint64{101}, genesis.SelfHash, genesis.Index, blockchainBlock.DIFFICULTY)
+	if node.ValidateBlock(*overpaid, 0) {
+		t.Errorf("Expected a block with a fee over its author's balance to be invalid\n")
+	}
+	node.Blockchain.Blocks = append(chain, paid)
+	if balance, _ := node.Balance(alice.Address); balance.Balance != 70 {
+		t.Errorf("Expected the author to pay its fee but got %+v\n", balance)
+	}
+	if balance, _ := node.Balance(miner); balance.Balance != 130+blockchainBlock.BLOCK_REWARD {
+		t.Errorf("Expected the miner to collect the fee and its reward but got %+v\n", balance)
+	}
+
+	free := &blockchainNode.Node{Port: "1", Consensus: fixedValidator{port: "1"}, Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("1"), Checkpoints: blockchainNode.NewCheckpoints()}
+	free.Blockchain.Blocks = chain
+	if _, block := free.MineNewBlock([]string{"Free content"}, []string{alice.Address}, []string{"id"}, []uint64{30}, genesis.SelfHash, genesis.Index, blockchainBlock.DIFFICULTY); block.Fees != nil {
+		t.Errorf("Expected a node without an address to mine content for free\n")
+	}
+
+	send := func(fee uint64, signedFee uint64) int {
+		signature, _ := alice.Sign(blockchainUser.SignedMessage("Content paying a fee", signedFee))
+		content, _ := json.Marshal(blockchainUser.Content{Content: "Content paying a fee", User: alice, Signature: signature, Fee: fee})
+		resp, err := http.Post(server.URL+blockchainNode.CONTENT, "application/json", bytes.NewReader(content))
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := send(80, 80); code != http.StatusPaymentRequired {
+		t.Errorf("Expected a fee over the author's balance to be refused with 402 but got %d\n", code)
+	}
+	if code := send(50, 5); code != http.StatusForbidden {
+		t.Errorf("Expected a fee the author did not sign to be refused with 403 but got %d\n", code)
+	}
+
+	// Content pays for its size, at least the minimum fee
+	defer func() { blockchainBlock.FEE_PER_BYTE, blockchainBlock.MIN_FEE = 0, 0 }()
+	blockchainBlock.FEE_PER_BYTE, blockchainBlock.MIN_FEE = 1, 10
+	if fee := blockchainBlock.RequiredFee("Content paying a fee"); fee != 20 {
+		t.Errorf("Expected content of 20 bytes to require a fee of 20 but got %d\n", fee)
+	}
+	if fee := blockchainBlock.RequiredFee("Tiny"); fee != 10 {
+		t.Errorf("Expected small content to require the minimum fee but got %d\n", fee)
+	}
+	if code := send(5, 5); code != http.StatusPaymentRequired {
+		t.Errorf("Expected a fee under the one its size requires to be refused with 402 but got %d\n", code)
+	}
+
+	// Every node checks the fees of the blocks it validates, whoever mined them
+	_, unpaid := free.MineNewBlock([]string{"Content paying a fee"}, []string{alice.Address}, []string{"fee id"}, nil, paid.SelfHash, paid.Index, blockchainBlock.DIFFICULTY)
+	if unpaid.PaysRequiredFees() || node.ValidateBlock(*unpaid, 0) || free.ValidateBlock(*unpaid, 0) {
+		t.Errorf("Expected a block holding content under its required fee to be invalid\n")
+	}
+	_, required := node.MineNewBlock([]string{"Content paying a fee"}, []string{alice.Address}, []string{"fee id"}, []uint64{20}, paid.SelfHash, paid.Index, blockchainBlock.DIFFICULTY)
+	if !required.PaysRequiredFees() || !node.ValidateBlock(*required, 0) {
+		t.Errorf("Expected a block paying the required fees to be valid\n")
+	}
+	freeServer := httptest.NewServer(http.HandlerFunc(free.HandleRequests))
+	defer freeServer.Close()
+	signature, _ := alice.Sign(blockchainUser.SignedMessage("Content paying a fee", 20))
+	content, _ := json.Marshal(blockchainUser.Content{Content: "Content paying a fee", User: alice, Signature: signature, Fee: 20})
+	if resp, err := http.Post(freeServer.URL+blockchainNode.CONTENT, "application/json", bytes.NewReader(content)); err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected a node without an address to refuse content requiring a fee with 503\n")
+	} else {
+		resp.Body.Close()
+	}
+
+	// Users raise their fee to the one the size of their content requires
+	var sent blockchainUser.Content
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&sent)
+	}))
+	defer receiver.Close()
+	alice.SendContentToNode(receiver.URL[strings.LastIndex(receiver.URL, ":")+1:], "Content paying a fee", time.Now().UnixNano())
+	if sent.Fee != 20 || !blockchainWallet.Verify(alice.PublicKey, blockchainUser.SignedMessage(sent.Content, 20), sent.Signature) {
+		t.Errorf("Expected the user to pay and sign the fee its content requires but got %d\n", sent.Fee)
+	}
+}
+
+/*
+Check that a node with an address rewards itself with the coinbase of the
+blocks it mines, that its balance tracks the rewards, and that a coinbase
+must come first, reward the block's miner as much as BLOCK_REWARD, and
+never come from a user.
+*/
+func TestCoinbase(t *testing.T) {
+	fmt.Println("Testing Coinbase...")
+	useTestLogger(t, "nodes")
+	useProposedBlocks(t)
+
+	wallet, _ := blockchainWallet.NewWallet()
+	miner, _ := blockchainWallet.Address(wallet.PublicKey)
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	node := &blockchainNode.Node{Port: "1", Address: miner, Consensus: fixedValidator{port: "1"}, Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("1"), Checkpoints: blockchainNode.NewCheckpoints()}
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis}
+
+	_, block := node.MineNewBlock([]string{"Rewarded content"}, []string{"alice"}, []string{"id"}, nil, genesis.SelfHash, genesis.Index, blockchainBlock.DIFFICULTY)
+	coinbase, ok := block.Coinbase()
+	if !ok || coinbase != blockchainBlock.NewCoinbase(miner, 1) || len(block.Entries) != 2 || block.Authors[0] != "" || block.ContentIDs[1] != "id" {
+		t.Fatalf("Expected the mined block to start with the miner's coinbase but got %+v\n", block)
+	}
+	if !node.ValidateBlock(*block, 0) {
+		t.Fatalf("Expected a block rewarding its miner to be valid\n")
+	}
+	node.Blockchain.Blocks = append(node.Blockchain.Blocks, block)
+	_, next := node.MineNewBlock([]string{"More content"}, nil, nil, nil, block.SelfHash, block.Index, blockchainBlock.DIFFICULTY)
+	node.Blockchain.Blocks = append(node.Blockchain.Blocks, next)
+	if balance, _ := node.Balance(miner); balance.Balance != blockchainNode.INITIAL_BALANCE+2*blockchainBlock.BLOCK_REWARD {
+		t.Errorf("Expected the miner to be rewarded for each block but got %+v\n", balance)
+	}
+
+	invalid := map[string]blockchainBlock.Coinbase{
+		"an inflated reward":      {Miner: miner, Reward: 10 * blockchainBlock.BLOCK_REWARD, Index: 1},
+		"another block's index":   {Miner: miner, Reward: blockchainBlock.BLOCK_REWARD, Index: 7},
+		"another address than it": {Miner: "mallory", Reward: blockchainBlock.BLOCK_REWARD, Index: 1},
+	}
+	for reason, coinbase := range invalid {
+		entries := [][]byte{[]byte(coinbase.String())}
+		forged := &blockchainBlock.Block{Index: 1, Entries: entries, MerkleRoot: blockchainBlock.MerkleRoot(entries), Miner: miner}
+		if forged.ValidCoinbase() {
+			t.Errorf("Expected a coinbase with %s to be invalid\n", reason)
+		}
+	}
+	entries := [][]byte{[]byte("Content"), []byte(blockchainBlock.NewCoinbase(miner, 1).String())}
+	if (&blockchainBlock.Block{Index: 1, Entries: entries, Miner: miner}).ValidCoinbase() {
+		t.Errorf("Expected a coinbase that is not the first entry to be invalid\n")
+	}
+
+	userList := blockchainNode.USER_LIST
+	defer func() { blockchainNode.USER_LIST = userList }()
+	blockchainNode.USER_LIST = filepath.Join(t.TempDir(), "UserList.txt")
+	alice := blockchainUser.User{}
+	alice.RegisterUser(blockchainNode.USER_LIST, SEED)
+
+	server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+	defer server.Close()
+	reward := blockchainBlock.NewCoinbase(alice.Address, 3).String()
+	signature, _ := alice.Sign(reward)
+	content, _ := json.Marshal(blockchainUser.Content{Content: reward, User: alice, Signature: signature})
+	resp, err := http.Post(server.URL+blockchainNode.CONTENT, "application/json", bytes.NewReader(content))
+	if err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a coinbase sent as content to be refused\n")
+	} else {
+		resp.Body.Close()
+	}
+}
+
+/*
+Check that nodes refuse blocks and content replaying content, transfers or
+transactions already on their blockchain, or holding the same one twice,
+while the same transfer made again, with a new nonce, goes through.
+*/
+func TestReplays(t *testing.T) {
+	fmt.Println("Testing Replays...")
+	useTestLogger(t, "nodes")
+	useProposedBlocks(t)
+
+	userList := blockchainNode.USER_LIST
+	defer func() { blockchainNode.USER_LIST = userList }()
+	blockchainNode.USER_LIST = filepath.Join(t.TempDir(), "UserList.txt")
+	alice := blockchainUser.User{}
+	alice.RegisterUser(blockchainNode.USER_LIST, SEED)
+	transfer, _ := alice.Transfer("bob", 10)
+	again, _ := alice.Transfer("bob", 10)
+	if transfer.ID() == again.ID() {
+		t.Fatalf("Expected the same transfer made twice to get two IDs\n")
+	}
+
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	node := &blockchainNode.Node{Port: "1", Consensus: fixedValidator{port: "1"}, Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("1"), Checkpoints: blockchainNode.NewCheckpoints()}
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis}
+	mine := func(contents []string, ids []string) *blockchainBlock.Block {
+		tip := node.Blockchain.Blocks[len(node.Blockchain.Blocks)-1]
+		authors := make([]string, len(contents))
+		_, block := node.MineNewBlock(contents, authors, ids, nil, tip.SelfHash, tip.Index, blockchainBlock.DIFFICULTY)
+		return block
+	}
+
+	if block := mine([]string{"First content", "Second content"}, []string{"id-1", "id-1"}); node.ValidateBlock(*block, 0) {
+		t.Errorf("Expected a block holding the same content ID twice to be invalid\n")
+	}
+	committed := mine([]string{"First content", transfer.String()}, []string{"id-1", "id-2"})
+	if !node.ValidateBlock(*committed, 0) {
+		t.Fatalf("Expected a block of new content to be valid\n")
+	}
+	node.Blockchain.Blocks = append(node.Blockchain.Blocks, committed)
+
+	if block := mine([]string{"First content"}, []string{"id-1"}); node.ValidateBlock(*block, 0) {
+		t.Errorf("Expected a block replaying a committed content ID to be invalid\n")
+	}
+	if block := mine([]string{transfer.String()}, []string{"id-3"}); node.ValidateBlock(*block, 0) {
+		t.Errorf("Expected a block replaying a committed transfer under another content ID to be invalid\n")
+	}
+	if block := mine([]string{again.String()}, []string{"id-3"}); !node.ValidateBlock(*block, 0) {
+		t.Errorf("Expected the same transfer made again to be valid\n")
+	}
+	if node.Replays("First content", "", "id-4") || !node.Replays(transfer.String(), "", "id-4") {
+		t.Errorf("Expected only committed IDs to be replays\n")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+	defer server.Close()
+	signature, _ := alice.Sign(transfer.String())
+	content, _ := json.Marshal(blockchainUser.Content{Content: transfer.String(), User: alice, Signature: signature, Timestamp: 7})
+	resp, err := http.Post(server.URL+blockchainNode.CONTENT, "application/json", bytes.NewReader(content))
+	if err != nil || resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected a replayed transfer to be refused\n")
+	} else {
+		resp.Body.Close()
+	}
+}
+
+/*
+Check that a whole blockchain verifies only if every block is at its index,
+links to the previous block, matches its hash, declares the difficulty the
+blockchain expects and carries a valid Proof of Work, and that a node refuses a new blockchain that does not verify.
+*/
+func TestChainVerification(t *testing.T) {
+	fmt.Println("Testing Chain Verification...")
+	useTestLogger(t, "nodes")
+
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	blocks := []*blockchainBlock.Block{genesis}
+	for i := 1; i <= 3; i++ {
+		tip := blocks[len(blocks)-1]
+		blocks = append(blocks, blockchainBlock.NewBlock(fmt.Sprintf("Content %d", i), tip.SelfHash, tip.Index, blockchainBlock.MIN_DIFFICULTY))
+	}
+
+	chain := blockchainBlockchain.Blockchain{Blocks: blocks}
+	if err := chain.Verify(); err != nil {
+		t.Fatalf("Expected a mined blockchain to verify but got %v\n", err)
+	}
+	headers := blockchainBlockchain.Blockchain{}
+	for _, block := range blocks {
+		headers.Blocks = append(headers.Blocks, block.Header())
+	}
+	if err := headers.Verify(); err != nil {
+		t.Errorf("Expected the headers of a mined blockchain to verify but got %v\n", err)
+	}
+
+	tamper := func(i int, change func(*blockchainBlock.Block)) blockchainBlockchain.Blockchain {
+		tampered := append([]*blockchainBlock.Block{}, blocks...)
+		block := *blocks[i]
+		change(&block)
+		tampered[i] = &block
+		return blockchainBlockchain.Blockchain{Blocks: tampered}
+	}
+	cases := map[string]blockchainBlockchain.Blockchain{
+		"skipping an index":     {Blocks: []*blockchainBlock.Block{blocks[0], blocks[2], blocks[3]}},
+		"with a broken link":    tamper(2, func(block *blockchainBlock.Block) { block.PrevBlockHash = genesis.SelfHash }),
+		"with tampered content": tamper(2, func(block *blockchainBlock.Block) { block.Entries = [][]byte{[]byte("Tampered")} }),
+		"with a forged hash":    tamper(3, func(block *blockchainBlock.Block) { block.SelfHash = blocks[2].SelfHash }),
+		"with a broken PoW": tamper(3, func(block *blockchainBlock.Block) {
+			for block.Validate() {
+				block.Nonce++
+				block.SelfHash = block.Hash()
+			}
+		}),
+	}
+	harder := blockchainBlock.NewBlock("Content 3", blocks[2].SelfHash, blocks[2].Index, blockchainBlock.MIN_DIFFICULTY+1)
+	cases["at a difficulty it does not expect"] = blockchainBlockchain.Blockchain{Blocks: []*blockchainBlock.Block{blocks[0], blocks[1], blocks[2], harder}}
+	cases["with a proposed block"] = tamper(3, func(block *blockchainBlock.Block) {
+		block.Proposer, block.Nonce = "1", 0
+		block.SelfHash = block.Hash()
+	})
+	for name, tampered := range cases {
+		if tampered.Verify() == nil {
+			t.Errorf("Expected a blockchain %s not to verify\n", name)
+		}
+	}
+
+	node := &blockchainNode.Node{Port: "1", Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("1"), Checkpoints: blockchainNode.NewCheckpoints()}
+	server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+	defer server.Close()
+	body, _ := json.Marshal(cases["with a broken link"])
+	resp, err := http.Post(server.URL+blockchainNode.NEW_CHAIN, "application/json", bytes.NewReader(body))
+	if err != nil || resp.StatusCode != http.StatusBadRequest || len(node.Blockchain.Blocks) != 0 {
+		t.Errorf("Expected a new blockchain that does not verify to be refused\n")
+	} else {
+		resp.Body.Close()
+	}
+}
+
+/*
+Check that /copy_chain and /blocks_since are gzip compressed for callers
+accepting it only, and that GetGzip reads them decompressed.
+*/
+func TestGzipCompression(t *testing.T) {
+	fmt.Println("Testing Gzip Compression...")
+	useTestLogger(t, "nodes")
+
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	block := blockchainBlock.NewBlock(strings.Repeat("Compressible content ", 20), genesis.SelfHash, genesis.Index, blockchainBlock.MIN_DIFFICULTY)
+	node := &blockchainNode.Node{Port: "1", Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("1"), Checkpoints: blockchainNode.NewCheckpoints()}
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis, block}
+	server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+	defer server.Close()
+
+	resp, err := test_helper.GetGzip(server.URL + blockchainNode.COPY_CHAIN)
+	if err != nil {
+		t.Fatalf("Could not copy the blockchain: %v\n", err)
+	}
+	var chain blockchainBlockchain.Blockchain
+	err = json.NewDecoder(resp.Body).Decode(&chain)
+	test_helper.CloseBody(resp)
+	if err != nil || !resp.Uncompressed || len(chain.Blocks) != 2 || chain.Verify() != nil {
+		t.Errorf("Expected a compressed copy of the blockchain (%v)\n", err)
+	}
+
+	resp, err = test_helper.GetGzip(server.URL + blockchainNode.BLOCKS_SINCE + "?index=1")
+	var events blockchainNode.BlockEvents
+	if err == nil {
+		err = json.NewDecoder(resp.Body).Decode(&events)
+		test_helper.CloseBody(resp)
+	}
+	if err != nil || !resp.Uncompressed || len(events.Blocks) != 1 || !bytes.Equal(events.Tip, block.SelfHash) {
+		t.Errorf("Expected compressed blocks since index 1 (%v)\n", err)
+	}
+
+	for _, encoding := range []string{"identity", "gzip;q=0"} {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+blockchainNode.COPY_CHAIN, nil)
+		req.Header.Set("Accept-Encoding", encoding)
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil || resp.Header.Get("Content-Encoding") != "" || json.NewDecoder(resp.Body).Decode(&chain) != nil {
+			t.Errorf("Expected an uncompressed blockchain for Accept-Encoding %q\n", encoding)
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+}
+
+/*
+Check that /copy_chain serves pages of the blockchain, and that a node
+copying the blockchain assembles it from pages fetched one after the other.
+*/
+func TestPaginatedCopyChain(t *testing.T) {
+	fmt.Println("Testing Paginated Copy Chain...")
+	useTestLogger(t, "nodes")
+
+	pageSize := blockchainNode.CHAIN_PAGE_SIZE
+	defer func() { blockchainNode.CHAIN_PAGE_SIZE = pageSize }()
+	blockchainNode.CHAIN_PAGE_SIZE = 2
+
+	chain := []*blockchainBlock.Block{blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)}
+	for i := 1; i < 5; i++ {
+		tip := chain[len(chain)-1]
+		chain = append(chain, blockchainBlock.NewBlock(fmt.Sprintf("Content %d", i), tip.SelfHash, tip.Index, blockchainBlock.MIN_DIFFICULTY))
+	}
+
+	// Peers serving /tip and /copy_chain only, counting the pages they send
+	var pages int32
+	ports := []string{}
+	for i := 0; i < 3; i++ {
+		peer := &blockchainNode.Node{Acceptance_mu: &sync.Mutex{}}
+		peer.Blockchain.Blocks = chain
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == blockchainNode.TIP {
+				peer.HandleTip(w, r)
+				return
+			}
+			if r.URL.Path != blockchainNode.COPY_CHAIN {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if r.URL.Query().Has("offset") {
+				atomic.AddInt32(&pages, 1)
+			}
+			peer.HandleCopyChain(w, r)
+		}))
+		defer server.Close()
+		ports = append(ports, server.URL[strings.LastIndex(server.URL, ":")+1:])
+	}
+
+	get := func(query string) (int, blockchainNode.ChainPage) {
+		resp, err := http.Get("http://localhost:" + ports[0] + blockchainNode.COPY_CHAIN + query)
+		if err != nil {
+			t.Fatalf("Could not copy the blockchain: %v\n", err)
+		}
+		defer resp.Body.Close()
+		var page blockchainNode.ChainPage
+		json.NewDecoder(resp.Body).Decode(&page)
+		return resp.StatusCode, page
+	}
+	if status, page := get("?offset=3&limit=10"); status != http.StatusOK || page.Offset != 3 || page.Height != 5 || len(page.Blocks) != 2 || page.Blocks[0].Index != 3 || !bytes.Equal(page.Tip, chain[4].SelfHash) {
+		t.Errorf("Expected the last 2 blocks of 5 from offset 3 but got %d blocks of height %d\n", len(page.Blocks), page.Height)
+	}
+	if status, page := get("?offset=9&limit=2&headers=true"); status != http.StatusOK || page.Offset != 5 || len(page.Blocks) != 0 {
+		t.Errorf("Expected no blocks past the end of the blockchain\n")
+	}
+	if status, page := get("?limit=1&headers=true"); status != http.StatusOK || len(page.Blocks) != 1 || !page.Blocks[0].HeaderOnly {
+		t.Errorf("Expected a page of headers\n")
+	}
+	for _, query := range []string{"?offset=-1", "?limit=0", "?offset=first"} {
+		if status, _ := get(query); status != http.StatusBadRequest {
+			t.Errorf("Expected /copy_chain%s to be a bad request but got %d\n", query, status)
+		}
+	}
+
+	atomic.StoreInt32(&pages, 0)
+	node := &blockchainNode.Node{Port: "1", Peers: blockchainNode.NewPeerSet(ports...), Acceptance_mu: &sync.Mutex{}, Checkpoints: blockchainNode.NewCheckpoints()}
+	if !node.UpdateBlockchain() || len(node.Blockchain.Blocks) != len(chain) || !bytes.Equal(node.Blockchain.Blocks[4].SelfHash, chain[4].SelfHash) {
+		t.Fatalf("Expected the blockchain to be copied in pages\n")
+	}
+	// The tips from a majority of 2, then the 3 pages from one peer only
+	if n := atomic.LoadInt32(&pages); n != 3 {
+		t.Errorf("Expected 3 pages to be fetched but got %d\n", n)
+	}
+}
+
+/*
+Check that a node rejecting a block tells its sender its height and tip and
+sends back the blocks the sender is missing, and that the sender catches up
+with them instead of copying the blockchain.
+*/
+func TestRejectionCatchUp(t *testing.T) {
+	fmt.Println("Testing Rejection Catch Up...")
+	useTestLogger(t, "nodes")
+
+	difficulty := blockchainBlock.MIN_DIFFICULTY
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, difficulty)
+	block1 := blockchainBlock.NewBlock("First content", genesis.SelfHash, 0, difficulty)
+	block2 := blockchainBlock.NewBlock("Second content", block1.SelfHash, 1, difficulty)
+	chain := []*blockchainBlock.Block{genesis, block1, block2}
+
+	// Peers ahead of the sender, counting the copies of the chain they send
+	var copies int32
+	ports := []string{}
+	for i := 0; i < 3; i++ {
+		peer := &blockchainNode.Node{Acceptance_mu: &sync.Mutex{}, Branches: blockchainNode.NewBranches(), Checkpoints: blockchainNode.NewCheckpoints()}
+		peer.Blockchain.Blocks = chain
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case blockchainNode.COPY_CHAIN, blockchainNode.HEADERS, blockchainNode.BLOCKS_SINCE:
+				atomic.AddInt32(&copies, 1)
+			}
+			peer.HandleRequests(w, r)
+		}))
+		defer server.Close()
+		ports = append(ports, server.URL[strings.LastIndex(server.URL, ":")+1:])
+	}
+
+	node := &blockchainNode.Node{Port: "1", Peers: blockchainNode.NewPeerSet(ports...), Acceptance_mu: &sync.Mutex{}, Checkpoints: blockchainNode.NewCheckpoints()}
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis}
+	stale := blockchainBlock.NewBlock("Stale content", genesis.SelfHash, 0, difficulty)
+
+	body, _ := json.Marshal(stale)
+	resp, err := http.Post("http://localhost:"+ports[0]+blockchainNode.VALIDATE, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Could not send /validate: %v\n", err)
+	}
+	var rejection blockchainNode.Rejection
+	json.NewDecoder(resp.Body).Decode(&rejection)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden || rejection.Reason != blockchainNode.REJECTED_STALE || rejection.Height != 3 || !bytes.Equal(rejection.Tip, block2.SelfHash) || len(rejection.Blocks) != 2 {
+		t.Errorf("Expected a stale block to be rejected with the 2 blocks after genesis but got %d: %+v\n", resp.StatusCode, rejection)
+	}
+
+	if node.AcceptBlock(*stale, 0) {
+		t.Fatalf("Expected the peers to reject a stale block\n")
+	}
+	if len(node.Blockchain.Blocks) != len(chain) || !bytes.Equal(node.Blockchain.Blocks[2].SelfHash, block2.SelfHash) {
+		t.Errorf("Expected the sender to catch up with the blocks of the rejections\n")
+	}
+	if n := atomic.LoadInt32(&copies); n != 0 {
+		t.Errorf("Expected no blockchain to be copied but got %d requests\n", n)
+	}
+}
+
+/*
+Check that a node catches up with the blocks a peer ahead of it holds, and
+does not append them once its blockchain ends in another block than the one
+it asked the peer from, e.g. after it switched branches meanwhile, nor if
+one of them is invalid.
+*/
+func TestCatchUp(t *testing.T) {
+	fmt.Println("Testing Catch Up...")
+	useTestLogger(t, "nodes")
+
+	difficulty := blockchainBlock.MIN_DIFFICULTY
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, difficulty)
+	block1 := blockchainBlock.NewBlock("First content", genesis.SelfHash, 0, difficulty)
+	block2 := blockchainBlock.NewBlock("Second content", block1.SelfHash, 1, difficulty)
+	other := blockchainBlock.NewBlock("Other genesis", []byte{}, -1, difficulty)
+
+	node := &blockchainNode.Node{Port: "1", Acceptance_mu: &sync.Mutex{}, Checkpoints: blockchainNode.NewCheckpoints()}
+	var switched int32
+	peer := &blockchainNode.Node{Acceptance_mu: &sync.Mutex{}, Checkpoints: blockchainNode.NewCheckpoints()}
+	peer.Blockchain.Blocks = []*blockchainBlock.Block{genesis, block1, block2}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The node switches to another blockchain of the same height while the peer answers
+		if r.URL.Path == blockchainNode.BLOCKS_SINCE && atomic.LoadInt32(&switched) == 1 {
+			node.Acceptance_mu.Lock()
+			node.Blockchain.Blocks = []*blockchainBlock.Block{other}
+			node.Acceptance_mu.Unlock()
+		}
+		peer.HandleRequests(w, r)
+	}))
+	defer server.Close()
+	node.Peers = blockchainNode.NewPeerSet(server.URL[strings.LastIndex(server.URL, ":")+1:])
+
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis}
+	if !node.CatchUp() || len(node.Blockchain.Blocks) != 3 || !bytes.Equal(node.Blockchain.Blocks[2].SelfHash, block2.SelfHash) {
+		t.Fatalf("Expected the node to catch up with the 2 blocks of its peer\n")
+	}
+
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis}
+	atomic.StoreInt32(&switched, 1)
+	if node.CatchUp() || len(node.Blockchain.Blocks) != 1 || !bytes.Equal(node.Blockchain.Blocks[0].SelfHash, other.SelfHash) {
+		t.Errorf("Expected the blocks of the peer not to be appended to another blockchain than the one asked from\n")
+	}
+
+	// A block the node would not accept alone is not appended, however it links up
+	atomic.StoreInt32(&switched, 0)
+	stale := blockchainBlock.NewBlock("Stale content", block1.SelfHash, 1, difficulty)
+	stale.Timestamp = genesis.Timestamp - 1
+	nonce, hash := blockchainBlock.NewProofOfWork(stale).Run()
+	stale.Nonce, stale.SelfHash = nonce, hash[:]
+	peer.Acceptance_mu.Lock()
+	peer.Blockchain.Blocks = []*blockchainBlock.Block{genesis, block1, stale}
+	peer.Acceptance_mu.Unlock()
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis}
+	if node.CatchUp() || len(node.Blockchain.Blocks) != 1 {
+		t.Errorf("Expected an invalid block of the peer not to be appended\n")
+	}
+}
+
+/*
+Check that a simulated network of nodes converges on one blockchain while
+users submit content concurrently, at random delays.
+*/
+func TestSimulation(t *testing.T) {
+	fmt.Println("Testing Simulation...")
+	config := blockchainSim.DefaultConfig(newNetwork(t, blockchainSim.MIN_NODES), filepath.Join(t.TempDir(), "UserList.txt"))
+	config.Log = testLogger(t, "nodes")
+	config.Timeout = MINING_TIMEOUT
+
+	sim := blockchainSim.New(config)
+	defer sim.Stop()
+	result, err := sim.Run()
+	if err != nil {
+		t.Fatalf("Expected the simulated network to converge: %v\n", err)
+	}
+	if result.Submitted != config.Users*config.Contents || result.Refused != 0 {
+		t.Errorf("Expected %d submissions to be sent but got %d, %d refused\n", config.Users*config.Contents, result.Submitted, result.Refused)
+	}
+	if result.Committed == 0 || result.Height != len(sim.Nodes[0].Blockchain.Blocks) || !sim.Converged() {
+		t.Errorf("Expected the nodes to agree on a blockchain holding submitted content but got %+v\n", result)
+	}
+}
+
+/*
+Check that a network driven step by step reaches the heights its steps lead
+to, including a node added once the blockchain exists.
+*/
+func TestNetwork(t *testing.T) {
+	fmt.Println("Testing Network...")
+	network := blockchainSim.NewNetwork(newNetwork(t, blockchainSim.MIN_NODES+1), filepath.Join(t.TempDir(), "UserList.txt"), testLogger(t, "nodes"))
+	defer network.Stop()
+
+	if err := network.Start(blockchainSim.MIN_NODES-1, MINING_TIMEOUT); err == nil {
+		t.Errorf("Expected a network of too few nodes not to start\n")
+	}
+	if err := network.Start(blockchainSim.MIN_NODES, MINING_TIMEOUT); err != nil {
+		t.Fatalf("Expected the network to start: %v\n", err)
+	}
+
+	user := network.AddUser()
+	if !network.Submit(user, "First content") || !network.WaitForHeight(2, MINING_TIMEOUT) {
+		t.Fatalf("Expected the network to reach height 2 but got heights %v\n", network.Heights())
+	}
+	if content := string(network.Nodes[0].Blockchain.Blocks[1].Entries[0]); content != "First content" {
+		t.Errorf("Expected the submitted content on the blockchain but got %q\n", content)
+	}
+
+	if _, err := network.AddNode(); err != nil {
+		t.Fatalf("Expected a node to join the network: %v\n", err)
+	}
+	if !network.WaitForHeight(2, MINING_TIMEOUT) || len(network.Nodes) != blockchainSim.MIN_NODES+1 {
+		t.Errorf("Expected the new node to hold the blockchain but got heights %v\n", network.Heights())
+	}
+	if network.WaitForHeight(3, 10*blockchainSim.POLL_INTERVAL) {
+		t.Errorf("Expected the network not to reach a height no step leads to\n")
+	}
+}
+
+/*
+Check that a registry allocates nodes and users their ports and lists them,
+in place of a user list file, and that a network registers with one of its
+own when given no user list.
+*/
+func TestRegistry(t *testing.T) {
+	fmt.Println("Testing Registry...")
+	seed := newNetwork(t, blockchainSim.MIN_NODES)
+
+	registry := blockchainRegistry.NewRegistry(seed)
+	if err := registry.Start(""); err != nil {
+		t.Fatalf("Expected the registry to start: %v\n", err)
+	}
+	defer registry.Stop()
+	url := registry.URL()
+	port, _ := strconv.Atoi(seed)
+	if !blockchainRegistry.IsRegistry(url) || blockchainRegistry.IsRegistry(USER_DIR) {
+		t.Errorf("Expected the registry's URL only to be taken for a registry\n")
+	}
+
+	first, err := blockchainRegistry.RegisterNode(url)
+	second, _ := blockchainRegistry.RegisterNode(url)
+	if err != nil || first != seed || second != strconv.Itoa(port+1) {
+		t.Errorf("Expected the nodes to be allocated %s and the next port but got %s and %s, %v\n", seed, first, second, err)
+	}
+	if err := blockchainRegistry.RemoveNode(url, first); err != nil {
+		t.Errorf("Expected the node to be removed: %v\n", err)
+	}
+	if err := blockchainRegistry.RemoveNode(url, first); err == nil {
+		t.Errorf("Expected a node removed already not to be removed again\n")
+	}
+	if third, _ := blockchainRegistry.RegisterNode(url); third != strconv.Itoa(port+2) {
+		t.Errorf("Expected a removed node's port not to be allocated again but got %s\n", third)
+	}
+	if nodes, _ := blockchainRegistry.GetNodes(url); len(nodes) != 2 || nodes[0] != second {
+		t.Errorf("Expected the remaining nodes to be listed but got %v\n", nodes)
+	}
+
+	alice, bob := blockchainUser.User{}, blockchainUser.User{}
+	alice.RegisterUser(url, seed)
+	bob.RegisterUser(url, seed)
+	if alice.Port != strconv.Itoa(blockchainRegistry.FIRST_USER_PORT) || bob.Port != strconv.Itoa(blockchainRegistry.FIRST_USER_PORT+1) {
+		t.Errorf("Expected the users to be allocated consecutive ports but got %s and %s\n", alice.Port, bob.Port)
+	}
+	if record, found := blockchainUser.FindUser(url, bob.Address); !found || record.PublicKey != bob.PublicKey || !alice.IsUserRegistered() {
+		t.Errorf("Expected the users to be found on the registry\n")
+	}
+	if users := blockchainUser.LoadUserList(url); len(users) != 2 {
+		t.Errorf("Expected 2 users on the registry but got %d\n", len(users))
+	}
+	if record, _ := blockchainRegistry.RegisterUser(url, alice.Address, alice.PublicKey); record.Port != alice.Port {
+		t.Errorf("Expected a user registering again to keep its port but got %s\n", record.Port)
+	}
+
+	network := blockchainSim.NewNetwork(seed, "", testLogger(t, "nodes"))
+	defer network.Stop()
+	if err := network.Start(blockchainSim.MIN_NODES, MINING_TIMEOUT); err != nil {
+		t.Fatalf("Expected a network to start with a registry of its own: %v\n", err)
+	}
+	if nodes, _ := blockchainRegistry.GetNodes(network.UserList); len(nodes) != blockchainSim.MIN_NODES {
+		t.Errorf("Expected the nodes of the network on its registry but got %v\n", nodes)
+	}
+	user := network.AddUser()
+	if !network.Submit(user, "Registered content") || !network.WaitForHeight(2, MINING_TIMEOUT) {
+		t.Errorf("Expected content of a user on the registry to be committed but got heights %v\n", network.Heights())
+	}
+	network.Nodes[0].Shutdown()
+	if nodes, _ := blockchainRegistry.GetNodes(network.UserList); len(nodes) != blockchainSim.MIN_NODES-1 {
+		t.Errorf("Expected a node leaving to be forgotten by the registry but got %v\n", nodes)
+	}
+}
+
+/*
+Check that registrations expire unless renewed with a renewal signed by the
+user, on a registry and on a user list file alike, and that a user is only
+registered if it holds the key of its record.
+*/
+func TestRegistrationExpiry(t *testing.T) {
+	fmt.Println("Testing Registration Expiry...")
+	ttl := blockchainRegistry.REGISTRATION_TTL
+	defer func() { blockchainRegistry.REGISTRATION_TTL = ttl }()
+	blockchainRegistry.REGISTRATION_TTL = 200 * time.Millisecond
+
+	registry := blockchainRegistry.NewRegistry("1234")
+	if err := registry.Start(""); err != nil {
+		t.Fatalf("Expected the registry to start: %v\n", err)
+	}
+	defer registry.Stop()
+
+	for _, userList := range []string{registry.URL(), filepath.Join(t.TempDir(), "UserList.txt")} {
+		alice := blockchainUser.User{}
+		alice.RegisterUser(userList, "1234")
+		if !alice.IsUserRegistered() || alice.Expires == 0 {
+			t.Fatalf("Expected a user registered on %s to be registered until it expires\n", userList)
+		}
+		impostor := blockchainUser.User{Address: alice.Address, PublicKey: alice.PublicKey}
+		if impostor.IsUserRegistered() {
+			t.Errorf("Expected a user without the registered key not to be registered\n")
+		}
+
+		time.Sleep(blockchainRegistry.REGISTRATION_TTL)
+		if alice.IsUserRegistered() {
+			t.Errorf("Expected the registration on %s to expire\n", userList)
+		}
+		if record, _ := blockchainUser.FindUser(userList, alice.Address); !record.Expired(time.Now()) {
+			t.Errorf("Expected the record of an expired registration to be expired\n")
+		}
+		if !alice.Renew() || !alice.IsUserRegistered() {
+			t.Errorf("Expected a renewed registration on %s to be registered again\n", userList)
+		}
+	}
+
+	alice := blockchainUser.User{}
+	alice.RegisterUser(registry.URL(), "1234")
+	mallory, _ := blockchainWallet.NewWallet()
+	renewal := blockchainRegistry.Renewal{Address: alice.Address, Timestamp: time.Now().UnixNano()}
+	renewal.Signature, _ = mallory.Sign(blockchainRegistry.RenewalMessage(renewal.Address, renewal.Timestamp))
+	if _, err := blockchainRegistry.RenewUser(registry.URL(), renewal); err == nil {
+		t.Errorf("Expected a renewal not signed by the user to be refused\n")
+	}
+	if !alice.Renew() {
+		t.Fatalf("Expected the user to renew its registration\n")
+	}
+	walletFile := filepath.Join(t.TempDir(), "alice.wallet")
+	alice.SaveWallet(walletFile)
+	wallet, _ := blockchainWallet.LoadWallet(walletFile)
+	renewal = blockchainRegistry.Renewal{Address: alice.Address, Timestamp: time.Now().UnixNano()}
+	renewal.Signature, _ = wallet.Sign(blockchainRegistry.RenewalMessage(renewal.Address, renewal.Timestamp))
+	if _, err := blockchainRegistry.RenewUser(registry.URL(), renewal); err != nil {
+		t.Errorf("Expected a renewal signed by the user to be accepted: %v\n", err)
+	}
+	if _, err := blockchainRegistry.RenewUser(registry.URL(), renewal); err == nil {
+		t.Errorf("Expected a replayed renewal to be refused\n")
+	}
+	if _, err := blockchainRegistry.RegisterUser(registry.URL(), alice.Address, mallory.PublicKey); err == nil {
+		t.Errorf("Expected a user whose address is not its key's to be refused\n")
+	}
+}
+
+/*
+Check that blocks timestamped too far in the future, or before the median
+timestamp of the last blocks, are refused by nodes and fail verification.
+*/
+func TestTimestampRules(t *testing.T) {
+	fmt.Println("Testing Timestamp Rules...")
+	useTestLogger(t, "nodes")
+
+	stamped := func(timestamps ...int64) []*blockchainBlock.Block {
+		blocks := []*blockchainBlock.Block{}
+		for _, timestamp := range timestamps {
+			blocks = append(blocks, &blockchainBlock.Block{Timestamp: timestamp})
+		}
+		return blocks
+	}
+	if median := blockchainBlock.MedianTimestamp(stamped(5, 1, 3)); median != 3 {
+		t.Errorf("Expected the median timestamp of 5, 1 and 3 to be 3 but got %d\n", median)
+	}
+	blocks := stamped(100, 100, 100)
+	for i := 0; i < blockchainBlock.MEDIAN_TIME_BLOCKS; i++ {
+		blocks = append(blocks, &blockchainBlock.Block{Timestamp: int64(i)})
+	}
+	if median := blockchainBlock.MedianTimestamp(blocks); median != int64(blockchainBlock.MEDIAN_TIME_BLOCKS/2) {
+		t.Errorf("Expected the median of the last %d blocks only but got %d\n", blockchainBlock.MEDIAN_TIME_BLOCKS, median)
+	}
+
+	now := time.Now()
+	drift := blockchainBlock.MAX_FUTURE_DRIFT
+	for name, valid := range map[string]bool{
+		"at the median":               blockchainBlock.ValidTimestamp(stamped(1, 2, 3), &blockchainBlock.Block{Timestamp: 2}, now),
+		"before the median":           !blockchainBlock.ValidTimestamp(stamped(1, 2, 3), &blockchainBlock.Block{Timestamp: 1}, now),
+		"within the drift":            blockchainBlock.ValidTimestamp(nil, &blockchainBlock.Block{Timestamp: now.Add(drift).UnixNano()}, now),
+		"too far in the future":       !blockchainBlock.ValidTimestamp(nil, &blockchainBlock.Block{Timestamp: now.Add(drift + time.Second).UnixNano()}, now),
+		"after a block in the future": blockchainBlock.ValidTimestamp(stamped(now.UnixNano()), &blockchainBlock.Block{Timestamp: now.Add(time.Second).UnixNano()}, now),
+	} {
+		if !valid {
+			t.Errorf("Expected a block timestamped %s to be judged otherwise\n", name)
+		}
+	}
+
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	chain := []*blockchainBlock.Block{genesis}
+	for i := 1; i <= 3; i++ {
+		tip := chain[len(chain)-1]
+		chain = append(chain, blockchainBlock.NewBlock(fmt.Sprintf("Content %d", i), tip.SelfHash, tip.Index, blockchainBlock.MIN_DIFFICULTY))
+	}
+	restamp := func(block blockchainBlock.Block, timestamp int64) *blockchainBlock.Block {
+		block.Timestamp = timestamp
+		block.SelfHash = block.Hash()
+		for !block.Validate() {
+			block.Nonce++
+			block.SelfHash = block.Hash()
+		}
+		return &block
+	}
+
+	node := &blockchainNode.Node{Port: "1", Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("1"), Checkpoints: blockchainNode.NewCheckpoints()}
+	node.Blockchain.Blocks = chain
+	tip := chain[len(chain)-1]
+	next := blockchainBlock.NewBlock("Next content", tip.SelfHash, tip.Index, blockchainBlock.NextDifficulty(chain))
+	if !node.ValidateBlock(*next, 0) {
+		t.Fatalf("Expected a block timestamped now to be valid\n")
+	}
+	if node.ValidateBlock(*restamp(*next, time.Now().Add(drift+time.Minute).UnixNano()), 0) {
+		t.Errorf("Expected a block timestamped too far in the future to be refused\n")
+	}
+	if node.ValidateBlock(*restamp(*next, genesis.Timestamp-1), 0) {
+		t.Errorf("Expected a block timestamped before the blocks it follows to be refused\n")
+	}
+
+	backdated := append([]*blockchainBlock.Block{}, chain[:3]...)
+	backdated = append(backdated, restamp(*chain[3], genesis.Timestamp-1))
+	if err := (&blockchainBlockchain.Blockchain{Blocks: backdated}).Verify(); err == nil {
+		t.Errorf("Expected a blockchain holding a backdated block not to verify\n")
+	}
+}
+
+/*
+Check that faults drop, delay and duplicate messages as configured, the same
+ones for the same seed, and that a simulated network whose nodes delay and
+duplicate messages still converges.
+*/
+func TestFaultInjection(t *testing.T) {
+	fmt.Println("Testing Fault Injection...")
+
+	var served int32
+	bodies := make(chan string, 10)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&served, 1)
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+	})
+	serve := func(faults *test_helper.Faults, requests int) (int32, int) {
+		atomic.StoreInt32(&served, 0)
+		server := httptest.NewServer(test_helper.LeakyHandler(handler, faults))
+		defer server.Close()
+		failed := 0
+		for i := 0; i < requests; i++ {
+			resp, err := http.Post(server.URL, "text/plain", strings.NewReader("message"))
+			if err != nil {
+				failed++
+				continue
+			}
+			resp.Body.Close()
+			for len(bodies) > 0 {
+				<-bodies
+			}
+		}
+		return atomic.LoadInt32(&served), failed
+	}
+
+	if served, failed := serve(&test_helper.Faults{DropRate: 1}, 3); served != 0 || failed != 3 {
+		t.Errorf("Expected every message to be dropped but %d were served and %d failed\n", served, failed)
+	}
+	if served, failed := serve(&test_helper.Faults{DuplicateRate: 1}, 3); served != 6 || failed != 0 {
+		t.Errorf("Expected every message to be served twice but %d were served and %d failed\n", served, failed)
+	}
+	start := time.Now()
+	if _, failed := serve(&test_helper.Faults{DelayRate: 1, Delay: 20 * time.Millisecond, Seed: 3}, 3); failed != 0 || time.Since(start) > time.Second {
+		t.Errorf("Expected delayed messages to be served\n")
+	}
+	first, firstFailed := serve(&test_helper.Faults{DropRate: 0.3, DuplicateRate: 0.3, Seed: 7}, 20)
+	second, secondFailed := serve(&test_helper.Faults{DropRate: 0.3, DuplicateRate: 0.3, Seed: 7}, 20)
+	if first != second || firstFailed != secondFailed || firstFailed == 0 {
+		t.Errorf("Expected the same faults for the same seed but got %d and %d served\n", first, second)
+	}
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	client := &http.Client{Transport: test_helper.LeakyTransport{Base: http.DefaultTransport, Faults: &test_helper.Faults{DropRate: 1}}}
+	if _, err := client.Get(server.URL); !errors.Is(err, test_helper.ErrDropped) {
+		t.Errorf("Expected a dropped call to fail with ErrDropped but got %v\n", err)
+	}
+
+	config := blockchainSim.DefaultConfig(newNetwork(t, blockchainSim.MIN_NODES), filepath.Join(t.TempDir(), "UserList.txt"))
+	config.Log = testLogger(t, "nodes")
+	config.Timeout = MINING_TIMEOUT
+	config.Faults = &test_helper.Faults{DelayRate: 0.5, Delay: 20 * time.Millisecond, DuplicateRate: 0.2, Seed: 1}
+	sim := blockchainSim.New(config)
+	defer sim.Stop()
+	if _, err := sim.Run(); err != nil {
+		t.Errorf("Expected a network under faults to converge: %v\n", err)
+	}
+}
+
+/*
+Check that a node stores the content it has yet to mine and the nonces it
+got to, and that mining resumes from them once the node restarts.
+*/
+func TestResumableMining(t *testing.T) {
+	fmt.Println("Testing Resumable Mining...")
+
+	// The search for a nonce resumes after the nonces already tried
+	block := blockchainBlock.NewBlock("Resumed", []byte{1}, 0, blockchainBlock.MIN_DIFFICULTY)
+	first := block.Nonce
+	engine := blockchainPoW.NewEngine(1)
+	if !engine.Resume(block, []int{first + 1}, func() bool { return false }, nil) || block.Nonce <= first || !block.Validate() {
+		t.Errorf("Expected the nonce found after %d to seal the block but got %d\n", first, block.Nonce)
+	}
+
+	// The progress is reported while mining, up to an interruption
+	interval := blockchainPoW.CHECKPOINT_INTERVAL
+	blockchainPoW.CHECKPOINT_INTERVAL = time.Millisecond
+	defer func() { blockchainPoW.CHECKPOINT_INTERVAL = interval }()
+	block = blockchainBlock.NewBlock("Interrupted", []byte{1}, 0, blockchainBlock.MIN_DIFFICULTY)
+	block.Difficulty = blockchainBlock.MAX_DIFFICULTY
+	checks, reported := 0, []int{}
+	var mu sync.Mutex
+	interrupted := func() bool {
+		checks++
+		time.Sleep(5 * time.Millisecond)
+		return checks >= 3
+	}
+	checkpoint := func(progress []int) {
+		mu.Lock()
+		defer mu.Unlock()
+		reported = progress
+	}
+	if engine.Resume(block, nil, interrupted, checkpoint) {
+		t.Fatalf("Expected the interrupted mining not to seal the block\n")
+	}
+	mu.Lock()
+	if len(reported) != 1 || reported[0] < blockchainPoW.INTERRUPT_CHECK_NONCES {
+		t.Errorf("Expected the nonces tried to be reported but got %v\n", reported)
+	}
+	mu.Unlock()
+
+	// Work is stored as a whole
+	store := &blockchainStore.WorkStore{Path: filepath.Join(t.TempDir(), "Work.json")}
+	if work, err := store.Load(); err != nil || !work.Empty() {
+		t.Errorf("Expected no work before any was stored (%v)\n", err)
+	}
+
+	// A node mines the content it had yet to mine
+	network := blockchainSim.NewNetwork(newNetwork(t, blockchainSim.MIN_NODES), "", testLogger(t, "nodes"))
+	defer network.Stop()
+	if err := network.Start(blockchainSim.MIN_NODES, MINING_TIMEOUT); err != nil {
+		t.Fatalf("Expected the network to start: %v\n", err)
+	}
+	node := network.Nodes[1]
+	node.Work = store
+	mid := blockchainStore.PendingContent{Content: "Mined when the node stopped", ID: blockchainBlock.ContentID("Mined when the node stopped", "", 1)}
+	pending := blockchainStore.PendingContent{Content: "Pending when the node stopped", ID: blockchainBlock.ContentID("Pending when the node stopped", "", 2)}
+	store.Save(blockchainStore.Work{Pending: []blockchainStore.PendingContent{pending}, Job: &blockchainStore.MiningJob{Content: []blockchainStore.PendingContent{mid}}})
+
+	if resumed := node.ResumeWork(); resumed != 2 {
+		t.Fatalf("Expected 2 content to be mined again but got %d\n", resumed)
+	}
+	if !network.WaitFor(MINING_TIMEOUT, func() bool {
+		return node.FindContentStatus(mid.ID).Found && node.FindContentStatus(pending.ID).Found
+	}) {
+		t.Fatalf("Expected the content left to be mined\n")
+	}
+	if !network.WaitFor(MINING_TIMEOUT, func() bool {
+		work, err := store.Load()
+		return err == nil && work.Empty()
+	}) {
+		t.Errorf("Expected no work left once the content is mined\n")
+	}
+}
+
+/*
+Check that a block is accepted as soon as a majority of peers vote for it,
+without waiting for slow peers, whose requests are cancelled once it is.
+They still validate the block their requests brought them.
+*/
+func TestConcurrentQuorum(t *testing.T) {
+	fmt.Println("Testing Concurrent Quorum...")
+	useTestLogger(t, "nodes")
+
+	difficulty := blockchainBlock.MIN_DIFFICULTY
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, difficulty)
+	block1 := blockchainBlock.NewBlock("First content", genesis.SelfHash, 0, difficulty)
+
+	// 4 peers vote right away, 2 only once released
+	var validated int32
+	release := make(chan struct{})
+	ports := []string{}
+	for i := 0; i < 6; i++ {
+		slow := i >= 4
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slow {
+				<-release
+			}
+			atomic.AddInt32(&validated, 1)
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+		ports = append(ports, server.URL[strings.LastIndex(server.URL, ":")+1:])
+	}
+	defer close(release)
+
+	node := &blockchainNode.Node{Port: "1", Peers: blockchainNode.NewPeerSet(ports...), Acceptance_mu: &sync.Mutex{}, Checkpoints: blockchainNode.NewCheckpoints()}
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis}
+
+	accepted := make(chan bool, 1)
+	go func() { accepted <- node.AcceptBlock(*block1, 0) }()
+	select {
+	case ok := <-accepted:
+		if !ok || len(node.Blockchain.Blocks) != 2 {
+			t.Fatalf("Expected the block to be accepted by a majority of peers\n")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the block to be accepted without waiting for slow peers\n")
+	}
+
+	release <- struct{}{}
+	release <- struct{}{}
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&validated) != 6 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&validated); n != 6 {
+		t.Errorf("Expected every peer to validate the block but %d did\n", n)
+	}
+}
+
+/*
+Check that the quorum of nodes that must agree follows the configured
+policy, and that no network, however small, needs none of its nodes.
+*/
+func TestQuorumPolicy(t *testing.T) {
+	fmt.Println("Testing Quorum Policy...")
+	policy := test_helper.QUORUM
+	defer func() { test_helper.QUORUM = policy }()
+
+	expected := map[string][]int{ // Quorum of 0 to 6 nodes
+		test_helper.QUORUM_TWO_THIRDS: {0, 1, 2, 2, 3, 4, 4},
+		test_helper.QUORUM_MAJORITY:   {0, 1, 2, 2, 3, 3, 4},
+		"3":                           {0, 1, 2, 3, 3, 3, 3},
+	}
+	for quorum, counts := range expected {
+		if err := test_helper.ValidQuorum(quorum); err != nil {
+			t.Errorf("Expected %s to be a valid quorum: %v\n", quorum, err)
+		}
+		test_helper.QUORUM = quorum
+		for n, count := range counts {
+			if got := test_helper.Quorum(n); got != count {
+				t.Errorf("Expected a %s quorum of %d nodes to be %d but got %d\n", quorum, n, count, got)
+			}
+		}
+	}
+	for _, quorum := range []string{"", "0", "all", "-2"} {
+		if test_helper.ValidQuorum(quorum) == nil {
+			t.Errorf("Expected %q not to be a valid quorum\n", quorum)
+		}
+	}
+
+	config := blockchainConfig.Default()
+	config.Quorum = "most"
+	if blockchainNode.Configure(config) == nil {
+		t.Errorf("Expected nodes not to be configured with an invalid quorum\n")
+	}
+}
+
+/*
+Check that content its user sends again, under another content ID, is
+neither queued nor committed twice, and that the retry is answered with the
+confirmation of the content committed, while other users may send the same.
+*/
+func TestIdempotentSubmission(t *testing.T) {
+	fmt.Println("Testing Idempotent Submission...")
+	useTestLogger(t, "nodes")
+	useProposedBlocks(t)
+
+	userList := blockchainNode.USER_LIST
+	defer func() { blockchainNode.USER_LIST = userList }()
+	blockchainNode.USER_LIST = filepath.Join(t.TempDir(), "UserList.txt")
+	alice := blockchainUser.User{}
+	alice.RegisterUser(blockchainNode.USER_LIST, SEED)
+
+	pool := blockchainNode.NewMempool()
+	if mined, _ := pool.Add("Hello", alice.Address, "id-1", 0); mined == nil {
+		t.Fatalf("Expected new content to be queued\n")
+	}
+	if mined, _ := pool.Add("Hello", alice.Address, "id-2", 0); mined != nil {
+		t.Errorf("Expected content sent again to be queued once\n")
+	}
+	if mined, _ := pool.Add("Hello", "bob", "id-3", 0); mined == nil {
+		t.Errorf("Expected the same content of another user to be queued\n")
+	}
+
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	node := &blockchainNode.Node{Port: "1", Consensus: fixedValidator{port: "1"}, Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("1"), Checkpoints: blockchainNode.NewCheckpoints()}
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis}
+	mine := func(contents []string, authors []string, ids []string) *blockchainBlock.Block {
+		_, block := node.MineNewBlock(contents, authors, ids, nil, genesis.SelfHash, genesis.Index, blockchainBlock.DIFFICULTY)
+		return block
+	}
+
+	if block := mine([]string{"Hello", "Hello"}, []string{alice.Address, alice.Address}, []string{"id-1", "id-2"}); node.ValidateBlock(*block, 0) {
+		t.Errorf("Expected a block holding the same content of a user twice to be invalid\n")
+	}
+	committed := mine([]string{"Hello", "Hello"}, []string{alice.Address, "bob"}, []string{"id-1", "id-3"})
+	if !node.ValidateBlock(*committed, 0) {
+		t.Fatalf("Expected a block holding the same content of two users to be valid\n")
+	}
+	node.Blockchain.Blocks = append(node.Blockchain.Blocks, committed)
+	if !node.Replays("Hello", alice.Address, "id-2") || node.Replays("Hello", "carol", "id-4") {
+		t.Errorf("Expected only content its user committed before to be a replay\n")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+	defer server.Close()
+	signature, _ := alice.Sign(blockchainUser.SignedMessage("Hello", 0))
+	content, _ := json.Marshal(blockchainUser.Content{Content: "Hello", User: alice, Signature: signature, Timestamp: 9})
+	resp, err := http.Post(server.URL+blockchainNode.CONTENT, "application/json", bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Could not send /content: %v\n", err)
+	}
+	defer resp.Body.Close()
+	var status blockchainNode.ContentStatus
+	json.NewDecoder(resp.Body).Decode(&status)
+	if resp.StatusCode != http.StatusConflict || !status.Found || status.ContentID != "id-1" || status.Index != 1 {
+		t.Errorf("Expected content sent again to be refused with the confirmation of id-1 but got %d: %+v\n", resp.StatusCode, status)
+	}
+}
+
+/*
+Check that a node's snapshot holds its blockchain, its pending content, its
+peers and its balances, that another node restores them, and that a
+snapshot whose blockchain does not verify is refused.
+*/
+func TestSnapshotRestore(t *testing.T) {
+	fmt.Println("Testing Snapshot and Restore...")
+	useTestLogger(t, "nodes")
+
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	node := &blockchainNode.Node{Port: "1", Address: "miner", Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("1", "3"), Checkpoints: blockchainNode.NewCheckpoints()}
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis}
+	_, block := node.MineNewBlock([]string{"First content"}, []string{"alice"}, []string{"id-1"}, nil, genesis.SelfHash, genesis.Index, blockchainBlock.MIN_DIFFICULTY)
+	node.Blockchain.Blocks = append(node.Blockchain.Blocks, block)
+	node.Mempool = blockchainNode.NewMempool()
+	node.Mempool.Add("Pending content", "alice", "id-2", 0)
+	balance, _ := node.Balance("miner")
+
+	var archive bytes.Buffer
+	if err := node.Snapshot(&archive); err != nil {
+		t.Fatalf("Expected the node's snapshot to be taken: %v\n", err)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatalf("Expected the snapshot to be gzipped: %v\n", err)
+	}
+	var snapshot blockchainNode.NodeSnapshot
+	json.NewDecoder(reader).Decode(&snapshot)
+	if len(snapshot.Blocks) != 2 || len(snapshot.Pending) != 1 || snapshot.Pending[0].ID != "id-2" || len(snapshot.Peers) != 2 || snapshot.Balances.Height != 2 {
+		t.Errorf("Expected the snapshot to hold the node's state but got %+v\n", snapshot)
+	}
+
+	restored := &blockchainNode.Node{Port: "2", Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("2"), Checkpoints: blockchainNode.NewCheckpoints()}
+	restored.Blockchain.Blocks = []*blockchainBlock.Block{genesis}
+	if err := restored.Restore(bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatalf("Expected the snapshot to be restored: %v\n", err)
+	}
+	if len(restored.Blockchain.Blocks) != 2 || !bytes.Equal(restored.Blockchain.Blocks[1].SelfHash, block.SelfHash) {
+		t.Errorf("Expected the blockchain to be restored\n")
+	}
+	if peers := restored.KnownPeers(); len(peers) != 2 || peers[0] != "2" || peers[1] != "3" {
+		t.Errorf("Expected the peers of the snapshot, but for the node it was taken of, to be learned but got %v\n", peers)
+	}
+	if got, ok := restored.Balance("miner"); !ok || got.Balance != balance.Balance || got.Balance == blockchainNode.INITIAL_BALANCE {
+		t.Errorf("Expected the miner's balance of %d to be restored but got %d\n", balance.Balance, got.Balance)
+	}
+
+	// A tampered blockchain does not verify
+	block.Entries[1] = []byte("Tampered content")
+	archive.Reset()
+	node.Snapshot(&archive)
+	rolledBack := &blockchainNode.Node{Port: "4", Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("4"), Checkpoints: blockchainNode.NewCheckpoints()}
+	if rolledBack.Restore(bytes.NewReader(archive.Bytes())) == nil || len(rolledBack.Blockchain.Blocks) != 0 {
+		t.Errorf("Expected a snapshot of a tampered blockchain to be refused\n")
+	}
+}
+
+/*
+Check that blocks carry the network they were made on, and that nodes
+refuse the calls, blocks and blockchains of other networks.
+*/
+func TestNetworkIDs(t *testing.T) {
+	fmt.Println("Testing Network IDs...")
+	useTestLogger(t, "nodes")
+	defer func() { test_helper.NETWORK_ID = "" }()
+
+	legacy := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	if legacy.NetworkID != "" || !legacy.Validate() {
+		t.Errorf("Expected a block of the default network to carry no network ID and validate\n")
+	}
+
+	test_helper.NETWORK_ID = "demo"
+	demo := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	if demo.NetworkID != "demo" || !demo.Validate() || legacy.Validate() {
+		t.Errorf("Expected blocks to validate on their own network only\n")
+	}
+	relabeled := *demo
+	relabeled.NetworkID = "test"
+	if bytes.Equal(relabeled.Hash(), demo.SelfHash) {
+		t.Errorf("Expected the Proof of Work to cover the network ID\n")
+	}
+
+	test_helper.NETWORK_ID = "test"
+	chain := blockchainBlockchain.Blockchain{Blocks: []*blockchainBlock.Block{demo}}
+	if err := chain.Verify(); err == nil {
+		t.Errorf("Expected a blockchain of another network not to verify\n")
+	}
+
+	node := &blockchainNode.Node{Port: "1", Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("1"), Checkpoints: blockchainNode.NewCheckpoints()}
+	server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+	defer server.Close()
+	call := func(uri string, network string, body []byte) int {
+		req, _ := http.NewRequest("POST", server.URL+uri, bytes.NewReader(body))
+		if network != "" {
+			req.Header.Set(test_helper.NETWORK_ID_HEADER, network)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0
+		}
+		test_helper.CloseBody(resp)
+		return resp.StatusCode
+	}
+	if code := call(blockchainNode.STATUS, "demo", nil); code != http.StatusMisdirectedRequest {
+		t.Errorf("Expected a call from another network to be refused but got %d\n", code)
+	}
+	if code := call(blockchainNode.STATUS, "", nil); code == http.StatusMisdirectedRequest {
+		t.Errorf("Expected a call without a network ID to be served\n")
+	}
+	body, _ := json.Marshal(chain)
+	if code := call(blockchainNode.NEW_CHAIN, "test", body); code != http.StatusBadRequest || len(node.Blockchain.Blocks) != 0 {
+		t.Errorf("Expected a blockchain of another network to be refused but got %d\n", code)
+	}
+
+	// A node of another network answers
+	foreign := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(test_helper.NETWORK_ID_HEADER, "demo")
+		w.Write([]byte("{\"height\": 12}"))
+	}))
+	defer foreign.Close()
+	var misdirected *test_helper.ForeignNetworkError
+	if _, err := test_helper.HTTP_CLIENT.Get(foreign.URL + blockchainNode.STATUS); !errors.As(err, &misdirected) || misdirected.Network != "demo" {
+		t.Errorf("Expected a call to a node of another network to fail but got %v\n", err)
+	}
+}
+
+/*
+Check that a node strikes the peers that send it invalid blocks or
+malformed payloads, but not stale blocks, and bans them after enough
+strikes.
+*/
+func TestMisbehaviorBanning(t *testing.T) {
+	fmt.Println("Testing Misbehavior Banning...")
+	useTestLogger(t, "nodes")
+
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	node := &blockchainNode.Node{Port: "1", Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("1"), Checkpoints: blockchainNode.NewCheckpoints()}
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis}
+	server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+	defer server.Close()
+	call := func(uri string, peer string, body []byte) int {
+		req, _ := http.NewRequest("POST", server.URL+uri, bytes.NewReader(body))
+		if peer != "" {
+			req.Header.Set(blockchainNode.PEER_PORT_HEADER, peer)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0
+		}
+		test_helper.CloseBody(resp)
+		return resp.StatusCode
+	}
+
+	// A stale block is what an honest peer sends when it loses a race
+	stale, _ := json.Marshal(genesis)
+	call(blockchainNode.VALIDATE, "3", stale)
+	if node.StrikesOf("3") != 0 {
+		t.Errorf("Expected a stale block to earn no strike but got %d\n", node.StrikesOf("3"))
+	}
+
+	forged := *blockchainBlock.NewBlock("Forged content", genesis.SelfHash, genesis.Index, blockchainBlock.MIN_DIFFICULTY)
+	forged.Entries = [][]byte{[]byte("Tampered content")}
+	invalid, _ := json.Marshal(forged)
+	if code := call(blockchainNode.VALIDATE, "3", []byte("not a block")); code != http.StatusBadRequest {
+		t.Errorf("Expected a malformed block to be refused but got %d\n", code)
+	}
+	for i := 0; i < 3; i++ {
+		if code := call(blockchainNode.VALIDATE, "3", invalid); code != http.StatusBadRequest {
+			t.Errorf("Expected an invalid block to be refused but got %d\n", code)
+		}
+	}
+	if node.StrikesOf("3") != 4 || node.IsBanned("3") {
+		t.Errorf("Expected 4 strikes and no ban but got %d strikes\n", node.StrikesOf("3"))
+	}
+
+	call(blockchainNode.VALIDATE, "3", invalid)
+	if !node.IsBanned("3") {
+		t.Fatalf("Expected the peer to be banned after 5 strikes\n")
+	}
+	if code := call(blockchainNode.STATUS, "3", nil); code != http.StatusForbidden {
+		t.Errorf("Expected the banned peer's calls to be refused but got %d\n", code)
+	}
+	if code := call(blockchainNode.STATUS, "2", nil); code == http.StatusForbidden {
+		t.Errorf("Expected the other peers' calls to be served\n")
+	}
+	join, _ := json.Marshal(blockchainNode.PeerAnnouncement{Port: "3"})
+	if code := call(blockchainNode.JOIN, "2", join); code != http.StatusForbidden || node.Peers.Contains("3") {
+		t.Errorf("Expected the banned peer not to join again but got %d\n", code)
+	}
+	if code := call(blockchainNode.EVICT, "2", join); code != http.StatusOK {
+		t.Errorf("Expected a vote on evicting the banned peer to be agreed with but got %d\n", code)
+	}
+}
+
+/*
+Check that the majority blockchain is found by comparing the tips of the
+peers' blockchains, then downloaded from a single peer of the majority.
+*/
+func TestMajorityTip(t *testing.T) {
+	fmt.Println("Testing Majority Tip...")
+	useTestLogger(t, "nodes")
+
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	block := blockchainBlock.NewBlock("Majority content", genesis.SelfHash, genesis.Index, blockchainBlock.MIN_DIFFICULTY)
+	forked := blockchainBlock.NewBlock("Minority content", genesis.SelfHash, genesis.Index, blockchainBlock.MIN_DIFFICULTY)
+	chains := [][]*blockchainBlock.Block{{genesis, forked}, {genesis, block}, {genesis, block}}
+
+	// Peers counting the copies of their blockchain they send
+	var copies int32
+	ports := []string{}
+	for _, chain := range chains {
+		peer := &blockchainNode.Node{Acceptance_mu: &sync.Mutex{}}
+		peer.Blockchain.Blocks = chain
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == blockchainNode.COPY_CHAIN {
+				atomic.AddInt32(&copies, 1)
+				peer.HandleCopyChain(w, r)
+				return
+			}
+			peer.HandleRequests(w, r)
+		}))
+		defer server.Close()
+		ports = append(ports, server.URL[strings.LastIndex(server.URL, ":")+1:])
+	}
+
+	resp, err := http.Get("http://localhost:" + ports[1] + blockchainNode.TIP)
+	if err != nil {
+		t.Fatalf("Could not ask for the tip: %v\n", err)
+	}
+	var tip blockchainNode.ChainTip
+	json.NewDecoder(resp.Body).Decode(&tip)
+	resp.Body.Close()
+	if tip.Height != 2 || !bytes.Equal(tip.Tip, block.SelfHash) {
+		t.Errorf("Expected the tip of a blockchain of 2 blocks but got %+v\n", tip)
+	}
+
+	node := &blockchainNode.Node{Port: "1", Peers: blockchainNode.NewPeerSet(ports...), Acceptance_mu: &sync.Mutex{}, Checkpoints: blockchainNode.NewCheckpoints()}
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis, forked}
+	if !node.UpdateBlockchain() || !bytes.Equal(node.Blockchain.Blocks[1].SelfHash, block.SelfHash) {
+		t.Fatalf("Expected the majority blockchain to be adopted\n")
+	}
+	if n := atomic.LoadInt32(&copies); n > 1 {
+		t.Errorf("Expected the blockchain to be copied from one peer only but it was copied %d times\n", n)
+	}
+}
+
+/*
+Check that nodes shake hands before peering, and refuse to peer with nodes
+speaking an incompatible version of the API or of another network.
+*/
+func TestHandshake(t *testing.T) {
+	fmt.Println("Testing Handshake...")
+	useTestLogger(t, "nodes")
+
+	node := &blockchainNode.Node{Port: "1", Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("1"), Checkpoints: blockchainNode.NewCheckpoints()}
+	server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+	defer server.Close()
+	port := server.URL[strings.LastIndex(server.URL, ":")+1:]
+
+	handshake, err := test_helper.SendHandshake(port, "2")
+	if err != nil || handshake.Port != "1" || handshake.Version != test_helper.API_VERSION {
+		t.Errorf("Expected a handshake of version %d but got %+v (%v)\n", test_helper.API_VERSION, handshake, err)
+	}
+
+	shake := func(handshake test_helper.Handshake) int {
+		body, _ := json.Marshal(handshake)
+		resp, err := http.Post(server.URL+test_helper.HANDSHAKE_PATH, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return 0
+		}
+		test_helper.CloseBody(resp)
+		return resp.StatusCode
+	}
+	if code := shake(test_helper.Handshake{Port: "2", Version: 1, MinVersion: 1}); code != http.StatusUpgradeRequired {
+		t.Errorf("Expected a node of version 1 to be refused but got %d\n", code)
+	}
+	newer := test_helper.NewHandshake("2")
+	newer.Version, newer.MinVersion = test_helper.API_VERSION+2, test_helper.API_VERSION+1
+	if code := shake(newer); code != http.StatusUpgradeRequired {
+		t.Errorf("Expected a node only speaking newer versions to be refused but got %d\n", code)
+	}
+	foreign := test_helper.NewHandshake("2")
+	foreign.NetworkID = "demo"
+	if code := shake(foreign); code != http.StatusMisdirectedRequest {
+		t.Errorf("Expected a node of another network to be refused but got %d\n", code)
+	}
+
+	// A seed only speaking newer versions is not joined
+	var joins int32
+	seed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == blockchainNode.JOIN {
+			atomic.AddInt32(&joins, 1)
+		}
+		json.NewEncoder(w).Encode(newer)
+	}))
+	defer seed.Close()
+	var incompatible *test_helper.IncompatibleVersionError
+	if _, err := test_helper.SendHandshake(seed.URL[strings.LastIndex(seed.URL, ":")+1:], "1"); !errors.As(err, &incompatible) {
+		t.Errorf("Expected the handshake with a newer seed to fail but got %v\n", err)
+	}
+	if node.Join(seed.URL[strings.LastIndex(seed.URL, ":")+1:]) || atomic.LoadInt32(&joins) != 0 {
+		t.Errorf("Expected the node not to join through a newer seed\n")
+	}
+}
+
+/*
+Check that an operator can pause and resume a node's mining, and that the
+content it receives while paused waits in its mempool.
+*/
+func TestMiningPause(t *testing.T) {
+	fmt.Println("Testing Mining Pause...")
+	useTestLogger(t, "nodes")
+
+	node := &blockchainNode.Node{Port: "1", Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("1"), Checkpoints: blockchainNode.NewCheckpoints()}
+	server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+	defer server.Close()
+
+	control := func(path string) (int, blockchainNode.MiningState) {
+		var state blockchainNode.MiningState
+		resp, err := http.Post(server.URL+path, "application/json", nil)
+		if err != nil {
+			return 0, state
+		}
+		defer test_helper.CloseBody(resp)
+		json.NewDecoder(resp.Body).Decode(&state)
+		return resp.StatusCode, state
+	}
+
+	if code, _ := control(blockchainNode.PAUSE_MINING); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected a node without a mempool to refuse pausing but got %d\n", code)
+	}
+
+	node.Mempool = blockchainNode.NewMempool()
+	if code, state := control(blockchainNode.PAUSE_MINING); code != http.StatusOK || !state.Paused {
+		t.Errorf("Expected mining to be paused but got %d %+v\n", code, state)
+	}
+	if code, _ := control(blockchainNode.PAUSE_MINING); code != http.StatusConflict {
+		t.Errorf("Expected pausing twice to conflict but got %d\n", code)
+	}
+	if resp, err := http.Get(server.URL + blockchainNode.PAUSE_MINING); err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected a GET to be refused\n")
+	} else {
+		test_helper.CloseBody(resp)
+	}
+
+	// Content received while paused is kept, not mined
+	node.Mempool.Add("Paused content", "alice", "id-1", 0)
+	if !node.MiningPaused() || !node.Status().MiningPaused || node.Mempool.Len() != 1 {
+		t.Errorf("Expected the paused node to keep its content pending\n")
+	}
+	if node.PauseMining() {
+		t.Errorf("Expected pausing a paused node to fail\n")
+	}
+
+	// Resuming a node with nothing to mine
+	idle := &blockchainNode.Node{Port: "2", Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("2"), Checkpoints: blockchainNode.NewCheckpoints(), Mempool: blockchainNode.NewMempool()}
+	if idle.ResumeMining() || !idle.PauseMining() || !idle.ResumeMining() || idle.MiningPaused() {
+		t.Errorf("Expected an idle node to be paused and resumed once\n")
+	}
+}
+
+/*
+Check that a node records how long it took to mine its blocks and how often
+its mining was interrupted, and suggests a difficulty from its hash rate.
+*/
+func TestMiningStats(t *testing.T) {
+	fmt.Println("Testing Mining Stats...")
+	useTestLogger(t, "nodes")
+
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	node := &blockchainNode.Node{Port: "1", Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("1"), Checkpoints: blockchainNode.NewCheckpoints()}
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis}
+	if stats := node.MiningStats(); stats.BlocksMined != 0 || stats.HashRate != 0 || stats.SuggestedDifficulty != 0 {
+		t.Errorf("Expected no telemetry before mining but got %+v\n", stats)
+	}
+
+	for i := 0; i < 3; i++ {
+		prev := node.Blockchain.Blocks[len(node.Blockchain.Blocks)-1]
+		if ok, block := node.MineNewBlock([]string{fmt.Sprintf("Content %d", i)}, nil, nil, nil, prev.SelfHash, prev.Index, blockchainBlock.MIN_DIFFICULTY); ok {
+			node.Blockchain.Blocks = append(node.Blockchain.Blocks, block)
+		}
+	}
+
+	// Mining interrupted by a peer's block
+	node.Arrivals = blockchainNode.NewBlockQueue()
+	node.Arrivals.Push(*genesis)
+	prev := node.Blockchain.Blocks[len(node.Blockchain.Blocks)-1]
+	if ok, _ := node.MineNewBlock([]string{"Interrupted content"}, nil, nil, nil, prev.SelfHash, prev.Index, blockchainBlock.MIN_DIFFICULTY); ok {
+		t.Errorf("Expected the mining to be interrupted\n")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+	defer server.Close()
+	resp, err := http.Get(server.URL + blockchainNode.MINING_STATS)
+	if err != nil {
+		t.Fatalf("Expected the mining stats to be served: %v\n", err)
+	}
+	defer test_helper.CloseBody(resp)
+	var stats blockchainNode.MiningStats
+	json.NewDecoder(resp.Body).Decode(&stats)
+
+	if stats.BlocksMined != 3 || len(stats.Recent) != 3 || stats.Interruptions != 1 {
+		t.Errorf("Expected 3 blocks mined and 1 interruption but got %+v\n", stats)
+	}
+	if stats.Difficulty != blockchainBlock.MIN_DIFFICULTY || stats.HashRate <= 0 || stats.AverageDuration <= 0 {
+		t.Errorf("Expected the hash rate to be estimated but got %+v\n", stats)
+	}
+	if stats.SuggestedDifficulty < blockchainBlock.MIN_DIFFICULTY || stats.SuggestedDifficulty > blockchainBlock.MAX_DIFFICULTY {
+		t.Errorf("Expected a suggested difficulty within bounds but got %d\n", stats.SuggestedDifficulty)
+	}
+}
+
+/*
+Check that a node replies with the block buried finality_depth blocks deep
+as finalized, and reports whether content is in a finalized block.
+*/
+func TestFinality(t *testing.T) {
+	fmt.Println("Testing Finality...")
+	useTestLogger(t, "nodes")
+
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	node := &blockchainNode.Node{Port: "1", Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("1"), Checkpoints: blockchainNode.NewCheckpoints()}
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis}
+	server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+	defer server.Close()
+
+	finalized := func() (int, blockchainNode.FinalizedBlock) {
+		var reply blockchainNode.FinalizedBlock
+		resp, err := http.Get(server.URL + blockchainNode.FINALIZED)
+		if err != nil {
+			return 0, reply
+		}
+		defer test_helper.CloseBody(resp)
+		json.NewDecoder(resp.Body).Decode(&reply)
+		return resp.StatusCode, reply
+	}
+	if code, _ := finalized(); code != http.StatusNotFound {
+		t.Errorf("Expected no block to be finalized on a short blockchain but got %d\n", code)
+	}
+
+	for i := 1; i <= blockchainNode.CHECKPOINT_DEPTH+1; i++ {
+		prev := node.Blockchain.Blocks[len(node.Blockchain.Blocks)-1]
+		_, block := node.MineNewBlock([]string{fmt.Sprintf("Content %d", i)}, []string{"alice"}, []string{fmt.Sprintf("id-%d", i)}, nil, prev.SelfHash, prev.Index, blockchainBlock.MIN_DIFFICULTY)
+		node.Blockchain.Blocks = append(node.Blockchain.Blocks, block)
+	}
+
+	// 8 blocks with the default depth of 6, block 1 is the latest finalized
+	code, reply := finalized()
+	if code != http.StatusOK || reply.Height != 8 || reply.Depth != blockchainNode.CHECKPOINT_DEPTH || reply.Block == nil || reply.Block.Index != 1 {
+		t.Errorf("Expected block 1 to be finalized but got %d %+v\n", code, reply)
+	}
+	if status := node.FindContentStatus("id-1"); !status.Final {
+		t.Errorf("Expected content in a finalized block to be final but got %+v\n", status)
+	}
+	if status := node.FindContentStatus("id-2"); !status.Found || status.Final {
+		t.Errorf("Expected content in a block on top of it not to be final but got %+v\n", status)
+	}
+}
+
+/*
+Check that a node accepts a single block of those arriving together for the
+same height, answers the others, and mines again once it is done with them.
+Validation reads the blockchain while a block is accepted, run it with
+-race, see make race.
+*/
+func TestBlockArrivals(t *testing.T) {
+	fmt.Println("Testing Block Arrivals...")
+	useTestLogger(t, "nodes")
+
+	queue := blockchainNode.NewBlockQueue()
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	arrival := queue.Push(*genesis)
+	if !queue.Pending() {
+		t.Errorf("Expected a pushed block to be pending\n")
+	}
+	queue.Done(arrival)
+	if queue.Pending() || arrival.Won() {
+		t.Errorf("Expected a block done with not to be pending\n")
+	}
+
+	node := &blockchainNode.Node{Port: "1", Acceptance_mu: &sync.Mutex{}, Branches: blockchainNode.NewBranches(), Checkpoints: blockchainNode.NewCheckpoints()}
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis}
+	server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	var accepted, refused int32
+	for i := 0; i < 5; i++ {
+		block := blockchainBlock.NewBlock(fmt.Sprintf("Competing content %d", i), genesis.SelfHash, 0, blockchainBlock.MIN_DIFFICULTY)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body, _ := json.Marshal(block)
+			resp, err := http.Post(server.URL+blockchainNode.VALIDATE, "application/json", bytes.NewReader(body))
+			if err != nil {
+				return
+			}
+			test_helper.CloseBody(resp)
+			if resp.StatusCode == http.StatusOK {
+				atomic.AddInt32(&accepted, 1)
+			} else if resp.StatusCode == http.StatusForbidden {
+				atomic.AddInt32(&refused, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if accepted != 1 || refused != 4 || len(node.Blockchain.Blocks) != 2 {
+		t.Errorf("Expected a single competing block to be accepted but %d were, %d refused, height %d\n", accepted, refused, len(node.Blockchain.Blocks))
+	}
+	if node.Arrivals == nil || node.Arrivals.Pending() {
+		t.Errorf("Expected no block to be pending once answered\n")
+	}
+}
+
+/*
+Check that a node proves the content committed under a content ID in a
+block, and that light clients check the proof against their headers only.
+*/
+func TestContentProof(t *testing.T) {
+	fmt.Println("Testing Content Proof...")
+	useTestLogger(t, "nodes")
+
+	entries := [][]byte{[]byte("First"), []byte("Second"), []byte("Third"), []byte("Fourth"), []byte("Fifth")}
+	for n := 1; n <= len(entries); n++ {
+		for i := 0; i < n; i++ {
+			if position := blockchainBlock.MerkleProofPosition(blockchainBlock.MerkleProof(entries[:n], i), n); position != i {
+				t.Errorf("Expected the proof of entry %d of %d to start from it but got %d\n", i, n, position)
+			}
+		}
+	}
+
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	node := &blockchainNode.Node{Port: "1", Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("1"), Checkpoints: blockchainNode.NewCheckpoints()}
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis}
+	_, block := node.MineNewBlock([]string{"First", "Second", "Third"}, []string{"alice", "bob", "carol"}, []string{"id-1", "id-2", "id-3"}, nil, genesis.SelfHash, genesis.Index, blockchainBlock.MIN_DIFFICULTY)
+	node.Blockchain.Blocks = append(node.Blockchain.Blocks, block)
+	server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+	defer server.Close()
+	port := server.URL[strings.LastIndex(server.URL, ":")+1:]
+
+	user := &blockchainUser.User{Port: "light", Light: true, Headers: []*blockchainBlock.Block{genesis.Header(), block.Header()}}
+	proof, ok := user.ProveContent(port, 1, "id-2")
+	if !ok || !proof.Found || string(proof.Entry) != "Second" {
+		t.Fatalf("Expected the node to prove content by its content ID but got %+v\n", proof)
+	}
+	if user.VerifyContentProof("id-1", proof) || user.VerifyContentProof("id-3", proof) {
+		t.Errorf("Expected the proof of an entry not to prove the content IDs of the others\n")
+	}
+	swapped := proof
+	swapped.ContentIDs = []string{"id-1", "id-3", "id-2"}
+	if user.VerifyContentProof("id-3", swapped) {
+		t.Errorf("Expected content IDs other than the header's to be refused\n")
+	}
+
+	if proof, ok := user.ProveContent(port, 1, "id-4"); !ok || proof.Found {
+		t.Errorf("Expected content not in the block not to be found\n")
+	}
+	if proof, ok := user.ProveContent(port, 5, "id-1"); !ok || proof.Found {
+		t.Errorf("Expected a block past the tip not to be found\n")
+	}
+	if resp, err := http.Get(server.URL + blockchainNode.PROOF + "?block=first&content_id=id-1"); err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a block that is not a number to be refused\n")
+	} else {
+		test_helper.CloseBody(resp)
+	}
+}
+
+/*
+Check that an audit passes a valid blockchain, reports the first block
+breaking a hash link, a signature or a balance, and reads a blockchain from
+a block store, a /copy_chain reply or a snapshot, and that cmd/audit exits
+with status 1 on a violation.
+*/
+func TestChainAudit(t *testing.T) {
+	fmt.Println("Testing Chain Audit...")
+	useTestLogger(t, "nodes")
+
+	userList := blockchainNode.USER_LIST
+	defer func() { blockchainNode.USER_LIST = userList }()
+	blockchainNode.USER_LIST = filepath.Join(t.TempDir(), "UserList.txt")
+	alice := blockchainUser.User{}
+	alice.RegisterUser(blockchainNode.USER_LIST, SEED)
+	wallet, _ := blockchainWallet.NewWallet()
+	bob, _ := blockchainWallet.Address(wallet.PublicKey)
+	transfer, _ := alice.Transfer(bob, 60)
+
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	node := &blockchainNode.Node{Port: "1", Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("1"), Checkpoints: blockchainNode.NewCheckpoints()}
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis}
+	_, paid := node.MineNewBlock([]string{transfer.String()}, nil, nil, nil, genesis.SelfHash, genesis.Index, blockchainBlock.MIN_DIFFICULTY)
+	node.Blockchain.Blocks = append(node.Blockchain.Blocks, paid)
+	blocks := node.Blockchain.Blocks
+
+	report := blockchainNode.Audit(blocks)
+	if report.Violation != nil || report.Passed != 2 || report.Balances[alice.Address] != 40 || report.Balances[bob] != 160 {
+		t.Fatalf("Expected a valid blockchain to pass its audit but got %+v\n", report)
+	}
+
+	// Alice is left 40, so a second transfer of 60 is not affordable
+	_, overspent := node.MineNewBlock([]string{"Some content", transfer.String()}, nil, nil, nil, paid.SelfHash, paid.Index, blockchainBlock.MIN_DIFFICULTY)
+	forged := transfer
+	forged.Amount = 1
+	_, unsigned := node.MineNewBlock([]string{forged.String()}, nil, nil, nil, paid.SelfHash, paid.Index, blockchainBlock.MIN_DIFFICULTY)
+	unlinked := blockchainBlock.NewBlock("Unlinked content", genesis.SelfHash, paid.Index, blockchainBlock.MIN_DIFFICULTY)
+	cases := map[string]struct {
+		block *blockchainBlock.Block
+		entry int
+		kind  string
+	}{
+		"an overspent balance": {overspent, 1, blockchainNode.VIOLATION_BALANCE},
+		"a forged signature":   {unsigned, 0, blockchainNode.VIOLATION_SIGNATURE},
+		"a broken link":        {unlinked, -1, blockchainNode.VIOLATION_LINK},
+	}
+	for name, c := range cases {
+		report := blockchainNode.Audit(append(append([]*blockchainBlock.Block{}, blocks...), c.block))
+		violation := report.Violation
+		if violation == nil || violation.Index != 2 || violation.Entry != c.entry || violation.Kind != c.kind || report.Passed != 2 || report.Blocks != 3 {
+			t.Errorf("Expected an audit to report %s at block 2 but got %+v, %v\n", name, report, violation)
+		}
+	}
+
+	// The blockchain as stored, as exported and as archived
+	dir := t.TempDir()
+	storeDir := blockchainStore.STORE_DIR
+	defer func() { blockchainStore.STORE_DIR = storeDir }()
+	blockchainStore.STORE_DIR = dir
+	store := blockchainStore.NewBlockStore("1")
+	store.Save(append(append([]*blockchainBlock.Block{}, blocks...), overspent))
+	exported, _ := json.Marshal(blockchainBlockchain.Blockchain{Blocks: blocks})
+	var archive bytes.Buffer
+	if err := node.Snapshot(&archive); err != nil {
+		t.Fatalf("Expected the node's snapshot to be taken: %v\n", err)
+	}
+	stored, _ := os.ReadFile(store.Path)
+	for name, data := range map[string][]byte{"block store": stored, "/copy_chain reply": exported, "snapshot": archive.Bytes()} {
+		loaded, err := blockchainNode.LoadChain(bytes.NewReader(data))
+		if err != nil || len(loaded) < len(blocks) || !bytes.Equal(loaded[1].SelfHash, paid.SelfHash) {
+			t.Errorf("Expected the blockchain to be read from a %s but got %d blocks, %v\n", name, len(loaded), err)
+		}
+	}
+
+	output, err := exec.Command("go", "run", "./cmd/audit", store.Path).CombinedOutput()
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) || exit.ExitCode() != 1 || !strings.Contains(string(output), "block 2, entry 1: balance") {
+		t.Errorf("Expected cmd/audit to exit with status 1 reporting the overspent balance but got %v\n%s", err, output)
+	}
+}
+
+/*
+Check that /user/{address}/history returns the blocks holding content of
+the user a page at a time, kept up to date as blocks are accepted, and
+indexed again when the node adopts another chain.
+*/
+func TestUserHistory(t *testing.T) {
+	fmt.Println("Testing User History...")
+	useTestLogger(t, "nodes")
+
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	node := &blockchainNode.Node{Port: "1", Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("1"), Checkpoints: blockchainNode.NewCheckpoints()}
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis}
+	server := httptest.NewServer(http.HandlerFunc(node.HandleRequests))
+	defer server.Close()
+
+	history := func(address string, query string) (blockchainNode.HistoryPage, int) {
+		var page blockchainNode.HistoryPage
+		resp, err := http.Get(server.URL + blockchainNode.USER + "/" + address + blockchainNode.HISTORY + query)
+		if err != nil {
+			t.Fatalf("Expected the history of %s to be served: %v\n", address, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK && json.NewDecoder(resp.Body).Decode(&page) != nil {
+			t.Fatalf("Expected the history of %s to decode\n", address)
+		}
+		return page, resp.StatusCode
+	}
+	mine := func(contents []string, authors []string) {
+		tip := node.Blockchain.Blocks[len(node.Blockchain.Blocks)-1]
+		ids := []string{}
+		for i := range contents {
+			ids = append(ids, fmt.Sprintf("id-%d-%d", tip.Index+1, i))
+		}
+		_, block := node.MineNewBlock(contents, authors, ids, nil, tip.SelfHash, tip.Index, blockchainBlock.MIN_DIFFICULTY)
+		node.Acceptance_mu.Lock()
+		node.Blockchain.Blocks = append(node.Blockchain.Blocks, block)
+		node.Acceptance_mu.Unlock()
+	}
+
+	if page, status := history("alice", ""); status != http.StatusOK || page.Total != 0 || len(page.Blocks) != 0 || page.Height != 1 {
+		t.Errorf("Expected a user without content to have an empty history but got %d %+v\n", status, page)
+	}
+
+	mine([]string{"Alice's first", "Bob's first", "Alice's second"}, []string{"alice", "bob", "alice"})
+	mine([]string{"Bob's second"}, []string{"bob"})
+	mine([]string{"Alice's third"}, []string{"alice"})
+	page, status := history("alice", "")
+	if status != http.StatusOK || page.Total != 2 || len(page.Blocks) != 2 || page.Height != 4 {
+		t.Fatalf("Expected the 2 blocks holding content of alice but got %d %+v\n", status, page)
+	}
+	if page.Blocks[0].Block.Index != 1 || len(page.Blocks[0].Entries) != 2 || page.Blocks[0].Entries[1] != 2 || page.Blocks[1].Block.Index != 3 {
+		t.Errorf("Expected alice's blocks oldest first, with her entries, but got %+v\n", page.Blocks)
+	}
+	if page, _ := history("alice", "?offset=1&limit=1"); page.Total != 2 || page.Offset != 1 || len(page.Blocks) != 1 || page.Blocks[0].Block.Index != 3 {
+		t.Errorf("Expected the second page of alice's history to hold block 3 but got %+v\n", page)
+	}
+	if page, _ := history("alice", "?offset=5"); page.Total != 2 || len(page.Blocks) != 0 {
+		t.Errorf("Expected no blocks past the end of alice's history but got %+v\n", page)
+	}
+	for _, query := range []string{"?offset=-1", "?limit=0", "?limit=x"} {
+		if _, status := history("alice", query); status != http.StatusBadRequest {
+			t.Errorf("Expected history%s to be refused but got %d\n", query, status)
+		}
+	}
+
+	// Adopting another chain indexes it again
+	node.Acceptance_mu.Lock()
+	node.Blockchain.Blocks = node.Blockchain.Blocks[:2]
+	node.Acceptance_mu.Unlock()
+	mine([]string{"Bob's other second"}, []string{"bob"})
+	if page, _ := history("bob", ""); page.Total != 2 || page.Height != 3 || page.Blocks[1].Block.Index != 2 || string(page.Blocks[1].Block.Entries[0]) != "Bob's other second" {
+		t.Errorf("Expected bob's history to follow the chain adopted but got %+v\n", page)
+	}
+	if page, _ := history("alice", ""); page.Total != 1 {
+		t.Errorf("Expected alice's history to lose the block of the chain left but got %+v\n", page)
+	}
+}
+
+/*
+Check that a difficulty change signed by a governor sets the difficulty of
+the blocks following the block committing it, that changes not signed by a
+governor are ignored and refused, and that /admin/difficulty queues a
+change and gossips it to the peers.
+*/
+func TestDifficultyChange(t *testing.T) {
+	fmt.Println("Testing Difficulty Change...")
+	useTestLogger(t, "nodes")
+
+	governor, _ := blockchainWallet.NewWallet()
+	outsider, _ := blockchainWallet.NewWallet()
+	governors := blockchainBlock.GOVERNORS
+	defer func() { blockchainBlock.GOVERNORS = governors }()
+	blockchainBlock.GOVERNORS = []string{governor.Address}
+
+	change, _ := blockchainBlock.NewDifficultyChange(governor, blockchainBlock.MIN_DIFFICULTY+2)
+	parsed, ok := blockchainBlock.ParseDifficultyChange([]byte(change.String()))
+	if !ok || parsed != change || !parsed.Verify() {
+		t.Fatalf("Expected a signed difficulty change to parse back and verify\n")
+	}
+	unauthorized, _ := blockchainBlock.NewDifficultyChange(outsider, blockchainBlock.MIN_DIFFICULTY+4)
+	outOfBounds, _ := blockchainBlock.NewDifficultyChange(governor, blockchainBlock.MAX_DIFFICULTY+1)
+	raised := change
+	raised.Difficulty++
+	for name, refused := range map[string]blockchainBlock.DifficultyChange{"by another wallet": unauthorized, "out of bounds": outOfBounds, "changed after signing": raised} {
+		if refused.Verify() {
+			t.Errorf("Expected a difficulty change %s not to verify\n", name)
+		}
+	}
+
+	// The block after the one committing the change declares its difficulty
+	genesis := blockchainBlock.NewBlock("Genesis Block", []byte{}, -1, blockchainBlock.MIN_DIFFICULTY)
+	node := &blockchainNode.Node{Port: "1", Acceptance_mu: &sync.Mutex{}, Peers: blockchainNode.NewPeerSet("1"), Checkpoints: blockchainNode.NewCheckpoints()}
+	node.Blockchain.Blocks = []*blockchainBlock.Block{genesis}
+	ignored := []string{unauthorized.String()}
+	_, block := node.MineNewBlock(ignored, []string{""}, []string{blockchainBlock.ContentID(ignored[0], "", 0)}, nil, genesis.SelfHash, genesis.Index, blockchainBlock.MIN_DIFFICULTY)
+	if got := blockchainBlock.NextDifficulty(append(node.Blockchain.Blocks, block)); got != blockchainBlock.MIN_DIFFICULTY {
+		t.Errorf("Expected a change not signed by a governor to be ignored but got difficulty %d\n", got)
+	}
+	_, committing := node.MineNewBlock([]string{change.String()}, []string{""}, []string{blockchainBlock.ContentID(change.String(), "", 0)}, nil, genesis.SelfHash, genesis.Index, blockchainBlock.MIN_DIFFICULTY)
+	if !node.ValidateBlock(*committing, 0) {
+		t.Fatalf("Expected a block committing a difficulty change to be valid\n")
+	}
+	node.Blockchain.Blocks = append(node.Blockchain.Blocks, committing)
+	if got := blockchainBlock.NextDifficulty(node.Blockchain.Blocks); got != change.Difficulty {
+		t.Errorf("Expected the next block to declare difficulty %d but got %d\n", change.Difficulty, got)
+	}
+	if got := blockchainBlock.NextDifficulty([]*blockchainBlock.Block{genesis, committing.Header()}); got != blockchainBlock.MIN_DIFFICULTY {
+		t.Errorf("Expected a header to hold no difficulty change but got difficulty %d\n", got)
+	}
+	_, replayed := node.MineNewBlock([]string{change.String()}, []string{""}, []string{"another-id"}, nil, committing.SelfHash, committing.Index, change.Difficulty)
+	if !node.IsReplay(node.Blockchain.Blocks, *replayed) {
+		t.Errorf("Expected a block replaying a committed difficulty change to be a replay\n")
+	}
+
+	// A governor's change is queued and gossiped to the peers
+	servers := []*httptest.Server{}
+	nodes := []*blockchainNode.Node{}
+	for i := 0; i < 2; i++ {
+		n := &blockchainNode.Node{Acceptance_mu: &sync.Mutex{}, Checkpoints: blockchainNode.NewCheckpoints(), Mempool: blockchainNode.NewMempool()}
+		n.Blockchain.Blocks = []*blockchainBlock.Block{genesis}
+		n.PauseMining()
+		server := httptest.NewServer(http.HandlerFunc(n.HandleRequests))
+		defer server.Close()
+		n.Port = server.URL[strings.LastIndex(server.URL, ":")+1:]
+		servers, nodes = append(servers, server), append(nodes, n)
+	}
+	for _, n := range nodes {
+		n.Peers = blockchainNode.NewPeerSet(nodes[0].Port, nodes[1].Port)
+	}
+	post := func(change blockchainBlock.DifficultyChange) (int, blockchainNode.DifficultyChangeStatus) {
+		var status blockchainNode.DifficultyChangeStatus
+		body, _ := json.Marshal(change)
+		resp, err := http.Post(servers[0].URL+blockchainNode.DIFFICULTY_CHANGE, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Expected /admin/difficulty to answer: %v\n", err)
+		}
+		defer resp.Body.Close()
+		json.NewDecoder(resp.Body).Decode(&status)
+		return resp.StatusCode, status
+	}
+
+	if code, _ := post(unauthorized); code != http.StatusForbidden || nodes[0].Mempool.Len() != 0 {
+		t.Errorf("Expected a change not signed by a governor to be refused but got %d\n", code)
+	}
+	code, status := post(change)
+	if code != http.StatusAccepted || status.ID != change.ID() || status.ContentID != blockchainBlock.ContentID(change.String(), "", 0) || nodes[0].Mempool.Len() != 1 {
+		t.Fatalf("Expected a governor's change to be queued but got %d %+v\n", code, status)
+	}
+	if code, _ := post(change); code != http.StatusConflict {
+		t.Errorf("Expected a change queued already to conflict but got %d\n", code)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for nodes[1].Mempool.Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pending := nodes[1].Mempool.Pending(); len(pending) != 1 || pending[0] != change.String() {
+		t.Errorf("Expected the change to be gossiped to the peer but it holds %v\n", pending)
+	}
+	if resp, err := http.Get(servers[0].URL + blockchainNode.DIFFICULTY_CHANGE); err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected a GET to be refused\n")
+	} else {
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+/*
+	Audit a blockchain offline, e.g. the chains of nodes that diverged in a
+	test: every Proof of Work, hash link, signature and balance transition is
+	verified again from the genesis block, and the first violation reported.
+
+	go run ./cmd/audit /tmp/Blocks_1234.jsonl
+	go run ./cmd/audit /tmp/node1241.snapshot
+	curl localhost:1234/copy_chain > chain.json && go run ./cmd/audit chain.json
+	go run ./cmd/audit --node 1234 --data /tmp
+
+	The chain is read as a node's block store, the reply of /copy_chain or a
+	snapshot, see cmd/node, without changing it. Blocks must be checked
+	against the settings of the network they were made on, e.g. --network.
+	The tool exits with status 1 if the chain violates any check, so tests
+	and scripts can tell.
+*/
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	blk "project/Block"
+	help "project/Helpers"
+	nd "project/Node"
+	st "project/Store"
+	"strconv"
+)
+
+func main() {
+	node := flag.String("node", "", "port of the node whose block store is audited, in place of a file")
+	data := flag.String("data", st.STORE_DIR, "directory the --node stores its blockchain in")
+	network := flag.String("network", help.NETWORK_ID, "ID of the network the blocks belong to (default the unnamed network)")
+	maxContent := flag.Int("max-content-size", blk.MAX_CONTENT_SIZE, "bytes a content entry on the blockchain may take")
+	maxBlock := flag.Int("max-block-size", blk.MAX_BLOCK_SIZE, "bytes the content entries of a block may take together")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	path := flag.Arg(0)
+	if *node != "" {
+		if _, err := strconv.Atoi(*node); err != nil {
+			log.Fatalf("invalid --node %q", *node)
+		}
+		st.STORE_DIR = *data
+		path = st.NewBlockStore(*node).Path
+	}
+	if path == "" || flag.NArg() > 1 || (*node != "" && flag.NArg() > 0) {
+		log.Fatal("audit a single file, or the block store of a --node")
+	}
+
+	if *maxContent <= 0 || *maxBlock < *maxContent {
+		log.Fatalf("--max-content-size must be positive and at most --max-block-size")
+	}
+	blk.MAX_CONTENT_SIZE, blk.MAX_BLOCK_SIZE = *maxContent, *maxBlock
+	help.NETWORK_ID = *network
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	blocks, err := nd.LoadChain(file)
+	file.Close()
+	if err != nil {
+		log.Fatalf("could not read %s: %v", path, err)
+	}
+
+	report := nd.Audit(blocks)
+	if *asJSON {
+		json.NewEncoder(os.Stdout).Encode(report)
+	} else if report.Violation == nil {
+		fmt.Printf("%s: all %d blocks hold\n", path, report.Blocks)
+	} else {
+		fmt.Printf("%s: %d of %d blocks hold, first violation at %s\n", path, report.Passed, report.Blocks, report.Violation)
+	}
+	if report.Violation != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+/*
+	Issue the certificates nodes serve and call each other with over TLS.
+
+	go run ./cmd/certs --dir certs --nodes 1234,1235,1236,1237
+
+	The certificate authority all nodes trust is created in --dir as ca.pem
+	and ca-key.pem, or loaded from there if it already exists, so nodes
+	joining later get certificates from the same authority. Each node gets
+	<port>.pem and <port>-key.pem, see the --tls-* flags of cmd/node. Keep
+	ca-key.pem away from the nodes, anyone holding it can issue certificates.
+*/
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	help "project/Helpers"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	dir := flag.String("dir", "certs", "directory the certificates are written to")
+	nodes := flag.String("nodes", "", "comma separated ports of the nodes to issue certificates for")
+	flag.Parse()
+
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	caFile, caKeyFile := filepath.Join(*dir, "ca.pem"), filepath.Join(*dir, "ca-key.pem")
+	ca, err := help.LoadCertificateAuthority(caFile, caKeyFile)
+	if os.IsNotExist(err) {
+		ca, err = help.NewCertificateAuthority("blockchain nodes")
+		if err == nil {
+			err = ca.WriteFiles(caFile, caKeyFile)
+		}
+		if err == nil {
+			fmt.Printf("Created the certificate authority %s\n", caFile)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, port := range strings.Split(*nodes, ",") {
+		port = strings.TrimSpace(port)
+		if port == "" {
+			continue
+		}
+		if _, err := strconv.Atoi(port); err != nil {
+			log.Fatalf("invalid port %q", port)
+		}
+
+		certFile, keyFile := filepath.Join(*dir, port+".pem"), filepath.Join(*dir, port+"-key.pem")
+		if err := ca.Issue("node "+port, certFile, keyFile); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Issued %s for node %s\n", certFile, port)
+	}
+}
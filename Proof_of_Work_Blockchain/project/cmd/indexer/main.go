@@ -0,0 +1,56 @@
+package main
+
+/*
+	Start an indexer of a node's blockchain, with its own query API.
+
+	go run ./cmd/indexer --node 1234 --port 7000
+	curl 'localhost:7000/search?keyword=content&user=<address>&from=2023-05-01T00:00:00Z'
+
+	The index is kept up to date as the node accepts blocks, and stored in
+	the --data directory so a restarted indexer only catches up.
+*/
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	help "project/Helpers"
+	ix "project/Indexer"
+	"strconv"
+)
+
+func main() {
+	node := flag.String("node", "1234", "port of the node whose blockchain is indexed")
+	port := flag.String("port", "7000", "port the query API listens on")
+	data := flag.String("data", "/tmp", "directory the index is stored in")
+	tlsCA := flag.String("tls-ca", "", "certificate of the authority that issued the certificate of the node, if it serves TLS")
+	flag.Parse()
+
+	for _, p := range []string{*node, *port} {
+		if _, err := strconv.Atoi(p); err != nil {
+			log.Fatalf("invalid port %q", p)
+		}
+	}
+
+	if *tlsCA != "" {
+		if err := help.EnableTLS("", "", *tlsCA, false); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := os.MkdirAll(*data, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	indexer := ix.NewIndexer(*node, *data)
+	if err := indexer.Load(); err != nil {
+		log.Fatal(err)
+	}
+	go indexer.Tail(make(chan struct{}))
+
+	http.HandleFunc(ix.SEARCH, indexer.HandleSearch)
+	fmt.Printf("Indexing node %s, query API on port %s\n", *node, *port)
+	log.Fatal(http.ListenAndServe("127.0.0.1:"+*port, nil))
+}
@@ -0,0 +1,249 @@
+package main
+
+/*
+	Start a single blockchain node, outside of the demo in main.go.
+
+	go run ./cmd/node --port 1234
+	go run ./cmd/node --port 1235 --peers localhost:1234
+	go run ./cmd/node --port 1236 --peers localhost:1234 --prune 20
+
+	The node joins the network through the first of its peers that answers.
+	Without peers, it starts a network of its own that others join through it.
+	Nodes reach each other on localhost, so peers are given as localhost:port
+	or simply as a port. A pruned node keeps its last blocks in full only and
+	fetches older ones from archive nodes, which run without --prune. Stop the node with Ctrl-C, it then leaves the network.
+
+	go run ./cmd/node --port 1237 --peers 1234 --log /tmp/node1237.log --log-level debug --log-max-size 10
+
+	With TLS, each node serves and calls its peers with its own certificate,
+	issued by an authority all nodes trust, see cmd/certs.
+
+	go run ./cmd/node --port 1238 --peers 1234 --tls-cert certs/1238.pem --tls-key certs/1238-key.pem --tls-ca certs/ca.pem --mutual-tls
+
+	Under Proof of Stake, blocks are proposed by the node selected by stake for
+	their height instead of mined. Every node must share the same --stakes list.
+
+	go run ./cmd/node --port 1239 --peers 1234 --consensus pos --stake 5 --stakes /tmp/StakeList.txt
+
+	A node writes a snapshot of its state, its blockchain, pending content,
+	peers and balances, to --snapshot when stopped, which a node restores
+	with --restore, e.g. to move it to another machine.
+
+	go run ./cmd/node --port 1241 --peers 1234 --snapshot /tmp/node1241.snapshot
+	go run ./cmd/node --port 1241 --peers 1234 --restore /tmp/node1241.snapshot
+
+	The fees users pay for their content, and the reward of each block, are
+	paid to the --address of the node mining it, the address of a wallet, see
+	cmd/user. A node without one mines content for free.
+
+	go run ./cmd/node --port 1240 --peers 1234 --address <address>
+
+	The wallets of --governors may change the difficulty of the whole network
+	at runtime, see the difficulty command of cmd/user. All nodes must be
+	given the same governors.
+
+	go run ./cmd/node --port 1242 --peers 1234 --governors <address>
+*/
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	blk "project/Block"
+	pos "project/Consensus/pos"
+	help "project/Helpers"
+	nd "project/Node"
+	st "project/Store"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func main() {
+	port := flag.String("port", "1234", "port the node listens on")
+	peers := flag.String("peers", "", "comma separated host:port of nodes to join the network through")
+	difficulty := flag.Int("difficulty", blk.DIFFICULTY, "difficulty of the genesis block, if this node creates it")
+	data := flag.String("data", st.STORE_DIR, "directory the node stores its blockchain in")
+	users := flag.String("users", "", "user list, or URL of a registry, nodes check content against (default <data>/UserList.txt)")
+	logFile := flag.String("log", "", "file the node logs to (default stdout)")
+	logLevel := flag.String("log-level", "info", "lowest level logged: debug, info, warn or error")
+	logSize := flag.Int64("log-max-size", 0, "size in MB past which the --log file is rotated (0 never rotates)")
+	logBackups := flag.Int("log-backups", 3, "rotated --log files kept")
+	miners := flag.Int("miners", runtime.NumCPU(), "goroutines mining a block, each over its own range of nonces")
+	maxContent := flag.Int("max-content-size", blk.MAX_CONTENT_SIZE, "bytes a content entry on the blockchain may take")
+	maxBlock := flag.Int("max-block-size", blk.MAX_BLOCK_SIZE, "bytes the content entries of a block may take together")
+	quorum := flag.String("quorum", help.QUORUM, "nodes that must agree on a block, the same on all nodes: majority, two_thirds or a number of nodes")
+	network := flag.String("network", help.NETWORK_ID, "ID of the network the node belongs to, the same on all its nodes, so networks running side by side refuse each other's blocks (default the unnamed network)")
+	consensus := flag.String("consensus", "pow", "how blocks are made: pow mines them, pos has the node selected by stake propose them")
+	stake := flag.Int("stake", 1, "weight this node stakes under --consensus pos")
+	stakes := flag.String("stakes", "", "stake list all nodes under --consensus pos share (default <data>/StakeList.txt)")
+	address := flag.String("address", "", "wallet address the rewards and fees of the blocks this node mines are paid to")
+	prune := flag.Int("prune", 0, "keep only the last N blocks in full, older ones as headers (0 keeps all blocks)")
+	tlsCert := flag.String("tls-cert", "", "certificate the node serves and calls peers with, enables TLS")
+	tlsKey := flag.String("tls-key", "", "key of the --tls-cert certificate")
+	tlsCA := flag.String("tls-ca", "", "certificate of the authority that issued the certificates of all nodes")
+	mutualTLS := flag.Bool("mutual-tls", false, "refuse calls only peers make, e.g. /validate, from callers without a certificate")
+	restore := flag.String("restore", "", "snapshot of a node to restore once started, e.g. taken with --snapshot on another machine")
+	snapshot := flag.String("snapshot", "", "file to write a snapshot of the node to when it is stopped")
+	governors := flag.String("governors", "", "comma separated addresses of the wallets that may change the difficulty of the network, the same on all nodes")
+	flag.Parse()
+
+	if _, err := strconv.Atoi(*port); err != nil {
+		log.Fatalf("invalid --port %q", *port)
+	}
+
+	seeds, err := parsePeers(*peers)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *difficulty < blk.MIN_DIFFICULTY || *difficulty > blk.MAX_DIFFICULTY {
+		log.Fatalf("--difficulty must be between %d and %d", blk.MIN_DIFFICULTY, blk.MAX_DIFFICULTY)
+	}
+	blk.DIFFICULTY = *difficulty
+
+	if *maxContent <= 0 || *maxBlock < *maxContent {
+		log.Fatalf("--max-content-size must be positive and at most --max-block-size")
+	}
+	blk.MAX_CONTENT_SIZE, blk.MAX_BLOCK_SIZE = *maxContent, *maxBlock
+
+	if err := help.ValidQuorum(*quorum); err != nil {
+		log.Fatalf("--quorum: %v", err)
+	}
+	help.QUORUM = *quorum
+	help.NETWORK_ID = *network
+	blk.GOVERNORS = strings.FieldsFunc(*governors, func(r rune) bool { return r == ',' || r == ' ' })
+
+	if err := os.MkdirAll(*data, 0755); err != nil {
+		log.Fatal(err)
+	}
+	st.STORE_DIR = *data
+	if *users == "" {
+		*users = filepath.Join(*data, "UserList.txt")
+	}
+
+	level, err := help.ParseLevel(*logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *logSize < 0 || *logBackups < 0 {
+		log.Fatalf("invalid --log-max-size %d or --log-backups %d", *logSize, *logBackups)
+	}
+	var out io.Writer = os.Stdout
+	if *logFile != "" {
+		out, err = help.OpenRotatingFile(*logFile, *logSize<<20, *logBackups)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	help.LOG = help.NewLogger(out, level)
+
+	if *miners < 1 {
+		log.Fatalf("invalid --miners %d", *miners)
+	}
+	if *prune < 0 {
+		log.Fatalf("invalid --prune %d", *prune)
+	}
+
+	if *tlsCert != "" || *tlsKey != "" || *tlsCA != "" || *mutualTLS {
+		if *tlsCert == "" || *tlsKey == "" || *tlsCA == "" {
+			log.Fatal("TLS needs --tls-cert, --tls-key and --tls-ca")
+		}
+		if err := help.EnableTLS(*tlsCert, *tlsKey, *tlsCA, *mutualTLS); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	node := nd.Node{PruneDepth: *prune, Miners: *miners, Address: *address}
+	switch *consensus {
+	case "pow":
+	case "pos":
+		if *stake < 0 {
+			log.Fatalf("invalid --stake %d", *stake)
+		}
+		if *stakes == "" {
+			*stakes = filepath.Join(*data, "StakeList.txt")
+		}
+		if err := pos.RegisterStake(*stakes, *port, *stake); err != nil {
+			log.Fatal(err)
+		}
+		node.Consensus = pos.NewEngine(*stakes)
+		blk.PROPOSED_BLOCKS = true
+	default:
+		log.Fatalf("invalid --consensus %q, expected pow or pos", *consensus)
+	}
+	if !node.StartNode(*port, seeds, *users, help.LOG) {
+		log.Fatalf("could not start a node at port %s", *port)
+	}
+	if *restore != "" {
+		file, err := os.Open(*restore)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = node.Restore(file)
+		file.Close()
+		if err != nil {
+			log.Fatalf("could not restore %s: %v", *restore, err)
+		}
+	}
+	fmt.Printf("Node %s is running, peers: %v\n", node.Port, node.KnownPeers())
+
+	// Leave the network cleanly when stopped
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	if *snapshot != "" {
+		if err := writeSnapshot(&node, *snapshot); err != nil {
+			log.Printf("could not write a snapshot to %s: %v", *snapshot, err)
+		}
+	}
+	node.Shutdown()
+}
+
+/*
+Write a snapshot of the node to the file at path, see Node.Snapshot.
+*/
+func writeSnapshot(node *nd.Node, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := node.Snapshot(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+/*
+Return the ports of the comma separated peers. Nodes only address each
+other on localhost, so other hosts are refused.
+*/
+func parsePeers(peers string) ([]string, error) {
+	seeds := []string{}
+	for _, peer := range strings.Split(peers, ",") {
+		peer = strings.TrimSpace(peer)
+		if peer == "" {
+			continue
+		}
+
+		host, port, err := net.SplitHostPort(peer)
+		if err != nil {
+			host, port = "localhost", peer // Only a port
+		}
+		if host != "localhost" && host != "127.0.0.1" && host != "" {
+			return nil, fmt.Errorf("peer %s is not on localhost", peer)
+		}
+		if _, err := strconv.Atoi(port); err != nil {
+			return nil, fmt.Errorf("invalid peer %q", peer)
+		}
+		seeds = append(seeds, port)
+	}
+	return seeds, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+/*
+	Start a registry of the nodes and users of a network, in place of a user
+	list file they share.
+
+	go run ./cmd/registry --port 1233 --seed 1234
+	go run ./cmd/node --port 1234 --users http://localhost:1233
+	go run ./cmd/user register --wallet /tmp/alice.wallet --users http://localhost:1233
+
+	The registry allocates users their ports and answers which users are
+	registered, e.g. curl localhost:1233/users. It keeps them in memory only,
+	so a new run starts from an empty registry.
+*/
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	reg "project/Registry"
+	"strconv"
+)
+
+func main() {
+	port := flag.String("port", "1233", "port the registry listens on")
+	seed := flag.String("seed", "1234", "port of the first node, the registry allocates nodes the ports from it")
+	flag.Parse()
+
+	for _, p := range []string{*port, *seed} {
+		if _, err := strconv.Atoi(p); err != nil {
+			log.Fatalf("invalid port %q", p)
+		}
+	}
+
+	registry := reg.NewRegistry(*seed)
+	fmt.Printf("Registry of the network of seed %s on port %s\n", *seed, *port)
+	log.Fatal(http.ListenAndServe("127.0.0.1:"+*port, registry.Handler()))
+}
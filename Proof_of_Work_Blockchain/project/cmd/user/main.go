@@ -0,0 +1,338 @@
+package main
+
+/*
+	Register a user, send content to the blockchain and check that it got there.
+
+	go run ./cmd/user register --wallet /tmp/alice.wallet
+	go run ./cmd/user renew --wallet /tmp/alice.wallet
+	go run ./cmd/user send --wallet /tmp/alice.wallet --wait 30s "Alice sent 1 BTC to Bob"
+	go run ./cmd/user send --wallet /tmp/alice.wallet --wait 30s --callback "Alice sent 2 BTC to Bob"
+	go run ./cmd/user send --wallet /tmp/alice.wallet --fee 5 "Alice sent 3 BTC to Bob"
+	go run ./cmd/user receipts --wallet /tmp/alice.wallet
+	go run ./cmd/user receipts --wallet /tmp/alice.wallet --light
+	go run ./cmd/user transfer --wallet /tmp/alice.wallet --to <bob's address> --wait 30s 10
+	go run ./cmd/user balance --wallet /tmp/alice.wallet
+	go run ./cmd/user pay --wallet /tmp/alice.wallet --to <bob's address> --wait 30s 10
+	go run ./cmd/user difficulty --wallet /tmp/governor.wallet --wait 30s 20
+
+	The wallet file holds the user's private key, keep it to send content
+	later. The user's receipts are kept in the same directory. Nodes must check users against the same --users list, see the
+	--users flag of cmd/node. With --light, the user confirms its content with
+	inclusion proofs checked against the block headers instead of trusting nodes.
+	With --callback, nodes notify the user once its content is committed,
+	instead of the user only polling them for receipts. With --tls-ca, the user
+	calls nodes serving TLS, checking their certificates against that authority.
+	A transfer moves an amount from the user's address to another one, nodes
+	refuse it if the user's balance does not cover it. A payment spends the
+	user's unspent outputs instead, in a transaction nodes refuse if one of
+	them was spent already. With --fee, the user pays the miner of its content
+	that fee out of its balance, at least the fee_per_byte of each byte of the
+	content, and nodes mine the content paying the highest fees first. A registration expires after a day, nodes then refuse the
+	user's content: renew it before with the user's wallet. difficulty changes
+	the difficulty of the blocks the network mines next, signed with the
+	wallet of one of the --governors of the nodes, see cmd/node.
+*/
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	help "project/Helpers"
+	usr "project/User"
+	wlt "project/Wallet"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const usage = `Usage: user <command> [flags]
+
+Commands:
+  register                register a new user and save its wallet
+  renew                   renew the user's registration before it expires
+  send [--wait] <content> send content to the blockchain
+  receipts [--wait]       show the content sent and whether it is on the blockchain
+  transfer [--wait] --to <address> <amount>
+                          transfer an amount to another address
+  balance [--address]     show the balance of the user, or of another address
+  pay [--wait] --to <address> <amount>
+                          pay an amount out of the user's unspent outputs
+  difficulty [--wait] <difficulty>
+                          change the difficulty of the network, as one of its governors
+
+Run "user <command> --help" for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	command, args := os.Args[1], os.Args[2:]
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	seed := flags.String("seed", "1234", "port of the node to ask for the nodes on the network")
+	users := flags.String("users", "/tmp/UserList.txt", "user list, or URL of a registry, nodes check content against")
+	wallet := flags.String("wallet", "/tmp/Wallet.json", "file holding the user's wallet")
+	light := flags.Bool("light", false, "confirm content with inclusion proofs against block headers only")
+	tlsCA := flags.String("tls-ca", "", "certificate of the authority that issued the certificates of nodes serving TLS")
+	fee := flags.Uint64("fee", 0, "fee paid to the miner of the content sent, mined highest fee first")
+
+	// Keep the user's receipts with its wallet, away from other users on the same port
+	setReceiptDir := func() { usr.RECEIPT_DIR = filepath.Dir(*wallet) }
+
+	// Users hold no certificate of their own, they only check the nodes'
+	enableTLS := func() {
+		if *tlsCA != "" {
+			if err := help.EnableTLS("", "", *tlsCA, false); err != nil {
+				fail("%v", err)
+			}
+		}
+	}
+
+	switch command {
+	case "register":
+		flags.Parse(args)
+		checkPort(*seed)
+		setReceiptDir()
+		enableTLS()
+		register(*users, *seed, *wallet)
+	case "renew":
+		flags.Parse(args)
+		checkPort(*seed)
+		setReceiptDir()
+		renew(load(*users, *seed, *wallet, *light))
+	case "send":
+		wait := flags.Duration("wait", 0, "how long to wait for the content to be on the blockchain")
+		callback := flags.Bool("callback", false, "have nodes notify the user once the content is committed, needs --wait")
+		flags.Parse(args)
+		checkPort(*seed)
+		setReceiptDir()
+		enableTLS()
+		if flags.NArg() == 0 {
+			fail("send needs the content to send")
+		}
+		user := load(*users, *seed, *wallet, *light)
+		user.Fee = *fee
+		if *callback && *wait > 0 {
+			listenForConfirmations(user)
+		}
+		send(user, strings.Join(flags.Args(), " "), *wait)
+	case "receipts":
+		wait := flags.Duration("wait", 0, "how long to wait for pending content to be on the blockchain")
+		flags.Parse(args)
+		checkPort(*seed)
+		setReceiptDir()
+		enableTLS()
+		receipts(load(*users, *seed, *wallet, *light), *wait)
+	case "transfer":
+		to := flags.String("to", "", "address to transfer the amount to")
+		wait := flags.Duration("wait", 0, "how long to wait for the transfer to be on the blockchain")
+		flags.Parse(args)
+		checkPort(*seed)
+		setReceiptDir()
+		enableTLS()
+		if *to == "" || flags.NArg() != 1 {
+			fail("transfer needs --to and the amount to transfer")
+		}
+		amount, err := strconv.ParseUint(flags.Arg(0), 10, 64)
+		if err != nil || amount == 0 {
+			fail("invalid amount %q", flags.Arg(0))
+		}
+		user := load(*users, *seed, *wallet, *light)
+		user.Fee = *fee
+		transfer(user, *to, amount, *wait)
+	case "pay":
+		to := flags.String("to", "", "address to pay the amount to")
+		wait := flags.Duration("wait", 0, "how long to wait for the payment to be on the blockchain")
+		flags.Parse(args)
+		checkPort(*seed)
+		setReceiptDir()
+		enableTLS()
+		if *to == "" || flags.NArg() != 1 {
+			fail("pay needs --to and the amount to pay")
+		}
+		amount, err := strconv.ParseUint(flags.Arg(0), 10, 64)
+		if err != nil || amount == 0 {
+			fail("invalid amount %q", flags.Arg(0))
+		}
+		user := load(*users, *seed, *wallet, *light)
+		user.Fee = *fee
+		pay(user, *to, amount, *wait)
+	case "balance":
+		address := flags.String("address", "", "address to show the balance of, the user's by default")
+		flags.Parse(args)
+		checkPort(*seed)
+		enableTLS()
+		if *address == "" {
+			*address = load(*users, *seed, *wallet, *light).Address
+		}
+		balance(*seed, *address)
+	case "difficulty":
+		wait := flags.Duration("wait", 0, "how long to wait for the change to be on the blockchain")
+		flags.Parse(args)
+		checkPort(*seed)
+		enableTLS()
+		if flags.NArg() != 1 {
+			fail("difficulty needs the difficulty to change to")
+		}
+		difficulty, err := strconv.Atoi(flags.Arg(0))
+		if err != nil {
+			fail("invalid difficulty %q", flags.Arg(0))
+		}
+		changeDifficulty(*seed, *wallet, difficulty, *wait)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func register(users string, seed string, wallet string) {
+	if _, err := os.Stat(wallet); err == nil {
+		fail("%s already holds a wallet, pick another --wallet", wallet)
+	}
+
+	user := usr.User{}
+	user.RegisterUser(users, seed)
+	if user.Port == "" || !user.SaveWallet(wallet) {
+		fail("could not register the user")
+	}
+	fmt.Printf("Wallet saved to %s\n", wallet)
+}
+
+func renew(user *usr.User) {
+	if !user.Renew() {
+		fail("could not renew the registration of %s", user.Address)
+	}
+	fmt.Printf("Registration renewed until %s\n", time.Unix(0, user.Expires).Format(time.RFC3339))
+}
+
+func load(users string, seed string, wallet string, light bool) *usr.User {
+	user, ok := usr.LoadUser(users, seed, wallet)
+	if !ok {
+		fail("no user registered on %s with the wallet %s, run register first", users, wallet)
+	}
+	user.Light = light
+	return user
+}
+
+/*
+Serve the user's confirmations on a free local port, and have nodes post
+them there.
+*/
+func listenForConfirmations(user *usr.User) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fail("could not listen for confirmations: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(usr.CONFIRMATION, user.HandleConfirmation)
+	go http.Serve(listener, mux)
+	user.Callback = "http://" + listener.Addr().String() + usr.CONFIRMATION
+}
+
+func send(user *usr.User, content string, wait time.Duration) {
+	if !user.SendContent(content) {
+		fail("could not send the content")
+	}
+	if wait > 0 {
+		receipts(user, wait)
+	}
+}
+
+func transfer(user *usr.User, to string, amount uint64, wait time.Duration) {
+	if !user.SendTransfer(to, amount) {
+		fail("could not send the transfer")
+	}
+	if wait > 0 {
+		receipts(user, wait)
+	}
+}
+
+func pay(user *usr.User, to string, amount uint64, wait time.Duration) {
+	if !user.SendPayment(to, amount) {
+		fail("could not send the payment")
+	}
+	if wait > 0 {
+		receipts(user, wait)
+	}
+}
+
+/*
+Change the difficulty of the network as the governor whose wallet was
+saved at path, and wait up to wait for the change to be committed.
+*/
+func changeDifficulty(seed string, path string, difficulty int, wait time.Duration) {
+	governor, err := wlt.LoadWallet(path)
+	if err != nil {
+		fail("could not read the wallet %s: %v", path, err)
+	}
+	status, ok := usr.ChangeDifficulty(seed, governor, difficulty)
+	if !ok {
+		fail("node %s refused the change, is %s one of its --governors?", seed, governor.Address)
+	}
+	fmt.Printf("Difficulty change %s to %d queued\n", status.ID[:16], status.Difficulty)
+
+	for deadline := time.Now().Add(wait); wait > 0; time.Sleep(500 * time.Millisecond) {
+		if receipt, ok := usr.GetReceipt(seed, status.ContentID); ok && receipt.Found {
+			fmt.Printf("Committed in block %d, the blocks after it are mined at difficulty %d\n", receipt.Index, status.Difficulty)
+			return
+		}
+		if time.Now().After(deadline) {
+			fail("the change is not on the blockchain yet")
+		}
+	}
+}
+
+func balance(seed string, address string) {
+	balance, ok := usr.GetBalance(seed, address)
+	if !ok {
+		fail("could not get the balance of %s from node %s", address, seed)
+	}
+	fmt.Printf("%s  %d  (after %d blocks)\n", balance.Address, balance.Balance, balance.Height)
+}
+
+/*
+Print the user's receipts, after waiting up to wait for pending content.
+Exits with status 1 if some content is still pending.
+*/
+func receipts(user *usr.User, wait time.Duration) {
+	pending := 0
+	if wait > 0 {
+		pending = user.WatchReceiptsFor(wait)
+	} else {
+		pending = user.CheckReceipts()
+	}
+
+	for _, submission := range user.Submissions() {
+		status := "pending"
+		if submission.Confirmed {
+			status = fmt.Sprintf("in block %d", submission.Index)
+		} else if submission.Attempts >= usr.MAX_ATTEMPTS {
+			status = "given up"
+		}
+		id := submission.ContentID // Receipts recorded before content IDs only have the hash
+		if id == "" {
+			id = submission.ContentHash
+		}
+		fmt.Printf("%s  %-12s  %s\n", id[:16], status, submission.Content)
+	}
+
+	if pending > 0 {
+		os.Exit(1)
+	}
+}
+
+func checkPort(port string) {
+	if _, err := strconv.Atoi(port); err != nil {
+		fail("invalid port %q", port)
+	}
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
@@ -1,3 +1,13 @@
 module project
 
 go 1.20
+
+require (
+	dfsclient v0.0.0
+	testutil v0.0.0
+)
+
+replace (
+	dfsclient => ../../distributed_file_system/dfsclient
+	testutil => ../../testutil
+)
@@ -7,35 +7,51 @@ package main
 
 	Node: A Machine on the Blockchain
 
+	The network is configured by the file named by $BLOCKCHAIN_CONFIG, if set,
+	and by BLOCKCHAIN_* environment variables, see the Config package, e.g.
+
+	BLOCKCHAIN_DIFFICULTY=12 BLOCKCHAIN_LOG_LEVEL=debug go run main.go
 */
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 	blk "project/Block"
+	cfg "project/Config"
 	help "project/Helpers"
 	nd "project/Node"
+	sim "project/Sim"
 	usr "project/User"
 	"sync"
 	"time"
 )
 
-var OUT os.File
-var wait_time time.Duration = 2000 * time.Millisecond
+var LOG *help.Logger
+var wait_time time.Duration = cfg.Default().WaitTime
 
-/* Global Constants */
-const NODE_LIST = "/tmp/NodeList.txt"
-const USER_LIST = "/tmp/UserList.txt"
+/* Set from the configuration */
+var SEED string = cfg.Default().SeedPort // Port of the first node, the others join through it
+var USER_LIST string = cfg.Default().UserList
 
 var USER_LIST_MUTEX sync.Mutex
-var NODE_LIST_MUTEX sync.Mutex
 
 /* Random Function for Sanity Test */
 func Hello() string {
 	return "Hello World"
 }
 
+/*
+Wait for every node to hold the same blockchain of at least height blocks,
+warning if they did not within timeout.
+*/
+func waitForHeight(network *sim.Network, height int, timeout time.Duration) {
+	if !network.WaitForHeight(height, timeout) {
+		fmt.Printf("WARN: the nodes did not reach height %d within %v, heights %v\n", height, timeout, network.Heights())
+	}
+}
+
 /*
 	Entry point of application
 	We register 5 nodes that constitute the blockchain network
@@ -43,51 +59,40 @@ func Hello() string {
 
 func main() {
 
-	/* Create a new file output for logs. */
-	LogFile, err := os.OpenFile("output.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	config, err := cfg.FromEnvironment()
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	OUT = *LogFile
-
-	if len(help.GetPorts(NODE_LIST)) >= 0 {
-		err = os.Remove(NODE_LIST)
-		if help.Check(err) {
-			fmt.Println("ERR: Could not delete NodeList successfully")
-		} else {
-			fmt.Printf("File %s deleted successfully!\n", NODE_LIST)
-		}
+	if err := nd.Configure(config); err != nil {
+		log.Fatal(err)
 	}
+	if err := usr.Configure(config); err != nil {
+		log.Fatal(err)
+	}
+	SEED, USER_LIST, wait_time = config.SeedPort, config.UserList, config.WaitTime
 
-	if len(help.GetPorts(USER_LIST)) >= 0 {
-		err = os.Remove(USER_LIST)
-		if help.Check(err) {
-			fmt.Println("ERR: Could not delete UserList successfully")
-		} else {
-			fmt.Printf("File %s deleted successfully!\n", USER_LIST)
+	/* Create a new file output for logs. */
+	level, err := help.ParseLevel(config.LogLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var out io.Writer = os.Stdout
+	if config.LogFile != "" {
+		out, err = os.OpenFile(config.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatal(err)
 		}
 	}
+	LOG = help.NewLogger(out, level)
 
-	// Initialize 5 empty nodes
-	node1 := nd.Node{}
-	node2 := nd.Node{}
-	node3 := nd.Node{}
-	node4 := nd.Node{}
-	node5 := nd.Node{}
-
-	// Register 5 nodes
-	go node1.RegisterNode(NODE_LIST, USER_LIST, OUT)
-	go node2.RegisterNode(NODE_LIST, USER_LIST, OUT)
-	go node3.RegisterNode(NODE_LIST, USER_LIST, OUT)
-	go node4.RegisterNode(NODE_LIST, USER_LIST, OUT)
-	go node5.RegisterNode(NODE_LIST, USER_LIST, OUT)
-
-	// Wait for registration to be processed
-	time.Sleep(wait_time)
+	// Register 5 nodes, then wait for them to create the blockchain
+	network := sim.NewNetwork(SEED, USER_LIST, LOG)
+	if err := network.Start(5, wait_time*3); err != nil {
+		log.Fatal(err)
+	}
 
 	// Get and print majority blockchain
-	success, blockchain := nd.GetBlockchain(NODE_LIST)
+	success, blockchain := nd.GetBlockchain(SEED)
 	if success {
 		nd.PrintBlockchain(blockchain)
 	}
@@ -98,17 +103,17 @@ func main() {
 
 		Depends on DIFFICULTY and content processing time.
 	*/
-	bob := usr.User{}
-	bob.RegisterUser(USER_LIST, NODE_LIST)
-	bob.SendContent("First content")
-	bob.SendContent("Second content")
-	bob.SendContent("Third content")
+	bob := network.AddUser()
+	height := network.Height()
+	network.Submit(bob, "First content")
+	network.Submit(bob, "Second content")
+	network.Submit(bob, "Third content")
 
 	// Wait for content to be processed
-	time.Sleep(wait_time * 3)
+	waitForHeight(network, height+3, wait_time*3)
 
 	// Get and print majority blockchain
-	success, blockchain = nd.GetBlockchain(NODE_LIST)
+	success, blockchain = nd.GetBlockchain(SEED)
 	if success {
 		nd.PrintBlockchain(blockchain)
 	}
@@ -120,15 +125,16 @@ func main() {
 
 		Depends on DIFFICULTY and content processing time.
 	*/
-	go bob.SendContent("Concurrent content")
-	go bob.SendContent("Concurrent content")
-	go bob.SendContent("Concurrent content")
+	height = network.Height()
+	go network.Submit(bob, "Concurrent content")
+	go network.Submit(bob, "Concurrent content")
+	go network.Submit(bob, "Concurrent content")
 
 	// Wait for content to be processed
-	time.Sleep(wait_time * 3)
+	waitForHeight(network, height+1, wait_time*3)
 
 	// Get and print majority blockchain
-	success, blockchain = nd.GetBlockchain(NODE_LIST)
+	success, blockchain = nd.GetBlockchain(SEED)
 	if success {
 		nd.PrintBlockchain(blockchain)
 	}
@@ -142,9 +148,10 @@ func main() {
 	prevBlock := blockchain.Blocks[len(blockchain.Blocks)-1]
 
 	// Basically mining a block for testing purposes
-	validBlock := blk.NewBlock("Interception", prevBlock.SelfHash, prevBlock.Index)
+	validBlock := blk.NewBlock("Interception", prevBlock.SelfHash, prevBlock.Index, blk.NextDifficulty(blockchain.Blocks))
 
-	go bob.SendContent("Do not accept")
+	height = network.Height()
+	go network.Submit(bob, "Do not accept")
 
 	node := nd.Node{} // Instantiate unregistered node
 
@@ -152,10 +159,10 @@ func main() {
 	node.AcceptBlock(*validBlock, 1)
 
 	// Wait for content to be processed
-	time.Sleep(wait_time)
+	waitForHeight(network, height+1, wait_time)
 
 	// Get and print majority blockchain
-	success, blockchain = nd.GetBlockchain(NODE_LIST)
+	success, blockchain = nd.GetBlockchain(SEED)
 	if success {
 		nd.PrintBlockchain(blockchain)
 	}
@@ -166,18 +173,19 @@ func main() {
 	*/
 	prevBlock = blockchain.Blocks[len(blockchain.Blocks)-1]
 	// Invalid interruption block
-	invalidBlock := blk.NewBlock("Do not accept", prevBlock.PrevBlockHash, prevBlock.Index)
+	invalidBlock := blk.NewBlock("Do not accept", prevBlock.PrevBlockHash, prevBlock.Index, blk.NextDifficulty(blockchain.Blocks))
 
-	go bob.SendContent("Fourth content")
+	height = network.Height()
+	go network.Submit(bob, "Fourth content")
 
 	// Sent interuption block via /validate
 	node.AcceptBlock(*invalidBlock, 1)
 
 	// Wait for content to be processed
-	time.Sleep(wait_time)
+	waitForHeight(network, height+1, wait_time)
 
 	// Get and print majority blockchain
-	success, blockchain = nd.GetBlockchain(NODE_LIST)
+	success, blockchain = nd.GetBlockchain(SEED)
 	if success {
 		nd.PrintBlockchain(blockchain)
 	}
@@ -192,12 +200,13 @@ func main() {
 		of actually testing it at scale.
 	*/
 	prevBlock = blockchain.Blocks[len(blockchain.Blocks)-1]
-	validBlock = blk.NewBlock("Interception", prevBlock.SelfHash, prevBlock.Index)
+	validBlock = blk.NewBlock("Interception", prevBlock.SelfHash, prevBlock.Index, blk.NextDifficulty(blockchain.Blocks))
 
 	// Tied valid interruption block
-	validBlockTied := blk.NewBlock("Interception 2", prevBlock.SelfHash, prevBlock.Index)
+	validBlockTied := blk.NewBlock("Interception 2", prevBlock.SelfHash, prevBlock.Index, blk.NextDifficulty(blockchain.Blocks))
 
-	go bob.SendContent("Do not accept")
+	height = network.Height()
+	go network.Submit(bob, "Do not accept")
 
 	// Send interuption block via /validate
 	go node.AcceptBlock(*validBlock, 1)
@@ -205,10 +214,10 @@ func main() {
 	go node.AcceptBlock(*validBlockTied, 1)
 
 	// Wait for content to be processed
-	time.Sleep(wait_time)
+	waitForHeight(network, height+1, wait_time)
 
 	// Get and print majority blockchain
-	success, blockchain = nd.GetBlockchain(NODE_LIST)
+	success, blockchain = nd.GetBlockchain(SEED)
 	if success {
 		nd.PrintBlockchain(blockchain)
 	}
@@ -234,23 +243,25 @@ func main() {
 	*/
 
 	prevBlock = blockchain.Blocks[len(blockchain.Blocks)-1]
-	validBlock = blk.NewBlock("Do not accept", prevBlock.SelfHash, prevBlock.Index)
+	validBlock = blk.NewBlock("Do not accept", prevBlock.SelfHash, prevBlock.Index, blk.NextDifficulty(blockchain.Blocks))
 
 	// Valid interruption block with a valid index + 1
-	validBlockDiff := blk.NewBlock("Highest Index block", prevBlock.SelfHash, prevBlock.Index+1)
+	validBlockDiff := blk.NewBlock("Highest Index block", prevBlock.SelfHash, prevBlock.Index+1, blk.NextDifficulty(blockchain.Blocks))
 
-	go bob.SendContent("Do not accept")
+	go network.Submit(bob, "Do not accept")
 
 	// Send interuption block via /validate
 	go node.AcceptBlock(*validBlock, 1)
 	// Send a tied interuption block via /validate
 	go node.AcceptBlock(*validBlockDiff, 1)
 
-	// Wait for content to be processed
-	time.Sleep(wait_time)
+	// Wait for the nodes to agree again
+	if !network.WaitFor(wait_time, network.Converged) {
+		fmt.Printf("WARN: the nodes did not agree within %v, heights %v\n", wait_time, network.Heights())
+	}
 
 	// Get and print majority blockchain
-	success, blockchain = nd.GetBlockchain(NODE_LIST)
+	success, blockchain = nd.GetBlockchain(SEED)
 	if success {
 		nd.PrintBlockchain(blockchain)
 	}
@@ -264,6 +275,7 @@ func main() {
 		  Index: 0
 		  Timestamp: 1683257165757555400
 		  Data: Genesis Block
+		  Difficulty: 18
 		  Nonce: 61744
 		  SelfHash: 000023f33275e73b7dd560dcb4dfb892c609c5225f5d2845bf8f966040606482
 		Block 1:
@@ -271,6 +283,7 @@ func main() {
 		  Index: 1
 		  Timestamp: 1683257167467456700
 		  Data: First content
+		  Difficulty: 18
 		  Nonce: 114289
 		  SelfHash: 00001722f1933b39d3f44c3db9ba6fdcfbbc614c37df72c57a0bf48c33eed1d0
 		Block 2:
@@ -278,6 +291,7 @@ func main() {
 		  Index: 2
 		  Timestamp: 1683257167628935100
 		  Data: Second content
+		  Difficulty: 18
 		  Nonce: 132389
 		  SelfHash: 000009da6bd0a43c795090424d0c9bea55d37706e8f3a6b6e7b07117281b2099
 		Block 3:
@@ -285,6 +299,7 @@ func main() {
 		  Index: 3
 		  Timestamp: 1683257167786835900
 		  Data: Third content
+		  Difficulty: 18
 		  Nonce: 64310
 		  SelfHash: 00003c230667621bb097c1b19a750ac5cdfd0dd00a6d579155b9120fbf5d1741
 		Block 4:
@@ -292,6 +307,7 @@ func main() {
 		  Index: 4
 		  Timestamp: 1683257173099325800
 		  Data: Concurrent content
+		  Difficulty: 18
 		  Nonce: 43141
 		  SelfHash: 000026bbea7598039ad27f04af7f5a2a23ca412ffb074f3bb944915e69d017fc
 		Block 5:
@@ -299,6 +315,7 @@ func main() {
 		  Index: 5
 		  Timestamp: 1683257178305923100
 		  Data: Interception
+		  Difficulty: 18
 		  Nonce: 48616
 		  SelfHash: 000012888c643c1b8fb4ff16268f8ca5793cad41bbb0a22be794c49118f0c649
 		Block 6:
@@ -306,6 +323,7 @@ func main() {
 		  Index: 6
 		  Timestamp: 1683257180186994000
 		  Data: Fourth content
+		  Difficulty: 18
 		  Nonce: 3030
 		  SelfHash: 000008916f8f337a823e6b5891af9e1282d82ddf719f170fb8904ca682988a54
 		Block 7:
@@ -313,6 +331,7 @@ func main() {
 		  Index: 7
 		  Timestamp: 1683257182083840000
 		  Data: Interception (or Interception 2)
+		  Difficulty: 18
 		  Nonce: 399095
 		  SelfHash: 000010f5a3e1177951e0492e916a4e4f90edf5496e3ce59feb9fb9baaf3fb35d
 		---------------------------------*****---------------------------------
@@ -0,0 +1,364 @@
+/*
+
+dfsadmin is the command line tool for administering the Distributed Filesystem
+(DFS). It sends the admin commands described in API_Naming_Service.md to the
+service interface of the Naming Server and prints the results.
+
+To run it:
+	`go run dfsadmin/dfsadmin.go [-server host:port] <command> [arguments]`
+where -server is the service address of the Naming Server, localhost:4444 by default.
+
+Commands:
+	servers              list the registered storage servers and the files they own
+	namespace [path]     dump the directory tree below path, with locks and owners
+	health [path]        check that the replicas of the files below path match
+	scrub [path]         check the replicas below path and repair divergent ones
+	rebalance            move files until storage servers of a zone own about as many each
+	unlock <path>        forcibly release every lock held on path
+	readonly <on|off>    turn read-only mode on or off
+	export [window] [n]  write the accesses of every file over the last n windows as CSV
+
+Paths default to the root "/". dfsadmin exits with status 1 if the Naming
+Server cannot be reached or answers with an exception.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+/* Admin commands of the Naming Server */
+const ADMIN_SERVERS string = "/admin_servers"
+const ADMIN_NAMESPACE string = "/admin_namespace"
+const ADMIN_UNLOCK string = "/admin_unlock"
+const ADMIN_REBALANCE string = "/admin_rebalance"
+const ADMIN_READ_ONLY string = "/admin_read_only"
+const ADMIN_ACCESS_EXPORT string = "/admin_access_export"
+const CHECK_REPLICAS string = "/check_replicas"
+
+const USAGE string = `usage: dfsadmin [-server host:port] <command> [arguments]
+
+commands:
+  servers              list the registered storage servers and the files they own
+  namespace [path]     dump the directory tree below path, with locks and owners
+  health [path]        check that the replicas of the files below path match
+  scrub [path]         check the replicas below path and repair divergent ones
+  rebalance            move files until storage servers of a zone own about as many each
+  unlock <path>        forcibly release every lock held on path
+  readonly <on|off>    turn read-only mode on or off
+  export [window] [n]  write the accesses of every file over the last n windows as CSV
+`
+
+/* Service address of the Naming Server */
+var SERVER string
+
+/* Responses of the Naming Server, see API_Naming_Service.md */
+type StorageServer struct {
+	StorageIP   string   `json:"storage_ip"`
+	ClientPort  int      `json:"client_port"`
+	CommandPort int      `json:"command_port"`
+	Files       []string `json:"files"`
+	Zone        string   `json:"zone"`
+}
+
+type ServersResponse struct {
+	Servers []StorageServer `json:"servers"`
+}
+
+type NamespaceEntry struct {
+	PathString string `json:"path"`
+	Directory  bool   `json:"directory"`
+	Locks      int    `json:"locks"`
+	Exclusive  bool   `json:"exclusive"`
+	Owner      int    `json:"owner"`
+}
+
+type NamespaceResponse struct {
+	Locations []NamespaceEntry `json:"locations"`
+}
+
+type ReplicaReport struct {
+	Path      string `json:"path"`
+	Checksum  string `json:"checksum"`
+	Divergent []int  `json:"divergent"`
+	Repaired  bool   `json:"repaired"`
+	Replicas  []struct {
+		ServerPort int `json:"server_port"`
+	} `json:"replicas"`
+}
+
+type ReplicaCheckResponse struct {
+	Files []ReplicaReport `json:"files"`
+}
+
+type MoveReport struct {
+	Path          string `json:"path"`
+	From          int    `json:"from"`
+	To            int    `json:"to"`
+	SourceDeleted bool   `json:"source_deleted"`
+}
+
+type RebalanceResponse struct {
+	Moves []MoveReport `json:"moves"`
+	Error string       `json:"error"`
+}
+
+type ForceUnlockResponse struct {
+	PathString string `json:"path"`
+	Released   int    `json:"released"`
+	Exclusive  bool   `json:"exclusive"`
+}
+
+type ReadOnlyRequest struct {
+	ReadOnly bool `json:"read_only"`
+}
+
+type PathRequest struct {
+	PathString string `json:"path"`
+}
+
+type ReplicaCheckRequest struct {
+	PathString string `json:"path"`
+	Repair     bool   `json:"repair"`
+}
+
+type AccessExportRequest struct {
+	Window  string `json:"window"`
+	Windows int    `json:"windows"`
+	Format  string `json:"format"`
+}
+
+type ExceptionResponse struct {
+	ExceptionType string `json:"exception_type"`
+	ExceptionInfo string `json:"exception_info"`
+}
+
+/* Print an error and exit with status 1 */
+func Fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "dfsadmin: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+/*
+Send command to the Naming Server with body encoded as JSON, and decode
+the response into response. Exits if the Naming Server answers with an exception.
+*/
+func Call(command string, body interface{}, response interface{}) {
+	resp := Send(command, body)
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		Fail("error decoding response of %s: %v", command, err)
+	}
+}
+
+/*
+Send command to the Naming Server with body encoded as JSON and return the
+response, for the caller to close. Exits if the Naming Server answers with an exception.
+*/
+func Send(command string, body interface{}) *http.Response {
+	jsonBytes, err := json.Marshal(body)
+	if err != nil {
+		Fail("error encoding JSON: %v", err)
+	}
+
+	resp, err := http.Post("http://"+SERVER+command, "application/json", bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		Fail("%v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var exception ExceptionResponse
+		if json.NewDecoder(resp.Body).Decode(&exception) != nil {
+			Fail("%s failed: %s", command, resp.Status)
+		}
+		Fail("%s: %s", exception.ExceptionType, exception.ExceptionInfo)
+	}
+	return resp
+}
+
+/* Return the path argument of a command, the root if there is none */
+func PathArg(args []string) string {
+	if len(args) == 0 {
+		return "/"
+	}
+	return args[0]
+}
+
+/* Format the client ports of storage servers as a comma separated list */
+func Ports(ports []int) string {
+	strs := []string{}
+	for _, port := range ports {
+		strs = append(strs, fmt.Sprint(port))
+	}
+	if len(strs) == 0 {
+		return "-"
+	}
+	return strings.Join(strs, ",")
+}
+
+func Servers(out *tabwriter.Writer) {
+	var response ServersResponse
+	Call(ADMIN_SERVERS, struct{}{}, &response)
+
+	fmt.Fprintln(out, "IP\tCLIENT PORT\tCOMMAND PORT\tZONE\tFILES")
+	for _, ss := range response.Servers {
+		zone := ss.Zone
+		if zone == "" {
+			zone = "-"
+		}
+		fmt.Fprintf(out, "%s\t%d\t%d\t%s\t%d\n", ss.StorageIP, ss.ClientPort, ss.CommandPort, zone, len(ss.Files))
+	}
+}
+
+func Namespace(out *tabwriter.Writer, path string) {
+	var response NamespaceResponse
+	Call(ADMIN_NAMESPACE, PathRequest{PathString: path}, &response)
+
+	fmt.Fprintln(out, "PATH\tTYPE\tLOCKS\tOWNER")
+	for _, location := range response.Locations {
+		kind, locks, owner := "file", fmt.Sprint(location.Locks), "-"
+		if location.Directory {
+			kind = "dir"
+		}
+		if location.Exclusive {
+			locks += " (exclusive)"
+		}
+		if location.Owner != 0 {
+			owner = fmt.Sprint(location.Owner)
+		}
+		fmt.Fprintf(out, "%s\t%s\t%s\t%s\n", location.PathString, kind, locks, owner)
+	}
+}
+
+func Health(out *tabwriter.Writer, path string, repair bool) {
+	var response ReplicaCheckResponse
+	Call(CHECK_REPLICAS, ReplicaCheckRequest{PathString: path, Repair: repair}, &response)
+
+	fmt.Fprintln(out, "PATH\tREPLICAS\tDIVERGENT\tSTATUS")
+	for _, report := range response.Files {
+		replicas := []int{}
+		for _, replica := range report.Replicas {
+			replicas = append(replicas, replica.ServerPort)
+		}
+
+		status := "ok"
+		if len(report.Divergent) > 0 {
+			status = "divergent"
+			if report.Repaired {
+				status = "repaired"
+			}
+		}
+		fmt.Fprintf(out, "%s\t%s\t%s\t%s\n", report.Path, Ports(replicas), Ports(report.Divergent), status)
+	}
+}
+
+func Rebalance(out *tabwriter.Writer) {
+	var response RebalanceResponse
+	Call(ADMIN_REBALANCE, struct{}{}, &response)
+
+	fmt.Fprintln(out, "PATH\tFROM\tTO\tSOURCE DELETED")
+	for _, move := range response.Moves {
+		fmt.Fprintf(out, "%s\t%d\t%d\t%v\n", move.Path, move.From, move.To, move.SourceDeleted)
+	}
+	out.Flush()
+
+	fmt.Printf("%d files moved\n", len(response.Moves))
+	if response.Error != "" {
+		Fail("rebalancing stopped: %s", response.Error)
+	}
+}
+
+func Unlock(path string) {
+	var response ForceUnlockResponse
+	Call(ADMIN_UNLOCK, PathRequest{PathString: path}, &response)
+
+	kind := "shared"
+	if response.Exclusive {
+		kind = "exclusive"
+	}
+	fmt.Printf("released %d %s locks on %s\n", response.Released, kind, response.PathString)
+}
+
+func ReadOnly(mode string) {
+	if mode != "on" && mode != "off" {
+		Fail("readonly takes on or off, not %q", mode)
+	}
+
+	var response ReadOnlyRequest
+	Call(ADMIN_READ_ONLY, ReadOnlyRequest{ReadOnly: mode == "on"}, &response)
+	fmt.Printf("read-only mode: %v\n", response.ReadOnly)
+}
+
+func Export(args []string) {
+	req := AccessExportRequest{Window: "1h", Windows: 1, Format: "csv"}
+	if len(args) > 0 {
+		req.Window = args[0]
+	}
+	if len(args) > 1 {
+		windows, err := strconv.Atoi(args[1])
+		if err != nil {
+			Fail("export takes a number of windows, not %q", args[1])
+		}
+		req.Windows = windows
+	}
+
+	resp := Send(ADMIN_ACCESS_EXPORT, req)
+	defer resp.Body.Close()
+	if _, err := io.Copy(os.Stdout, resp.Body); err != nil {
+		Fail("error reading response of %s: %v", ADMIN_ACCESS_EXPORT, err)
+	}
+}
+
+func main() {
+	flag.StringVar(&SERVER, "server", "localhost:4444", "service address of the Naming Server")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, USAGE)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer out.Flush()
+
+	command, args := args[0], args[1:]
+	switch {
+	case command == "servers" && len(args) == 0:
+		Servers(out)
+	case command == "namespace" && len(args) <= 1:
+		Namespace(out, PathArg(args))
+	case command == "health" && len(args) <= 1:
+		Health(out, PathArg(args), false)
+	case command == "scrub" && len(args) <= 1:
+		Health(out, PathArg(args), true)
+	case command == "rebalance" && len(args) == 0:
+		Rebalance(out)
+	case command == "unlock" && len(args) == 1:
+		Unlock(args[0])
+	case command == "readonly" && len(args) == 1:
+		ReadOnly(args[0])
+	case command == "export" && len(args) <= 2:
+		Export(args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
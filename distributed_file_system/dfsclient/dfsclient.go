@@ -0,0 +1,142 @@
+/*
+Client side of the Distributed Filesystem (DFS) for Go programs, reading
+files straight from the storage servers holding their replicas. Large files
+are read in chunks, from every replica at once, and reassembled. The
+blockchain's DFSBlobStore reads its bodies with it.
+*/
+package dfsclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/* Bytes read per request, unless another chunk size is given */
+const CHUNK_SIZE int64 = 1 << 20
+
+/* Chunks read in parallel, unless another number of readers is given */
+const READERS int = 4
+
+/* Longest time a storage server may take to serve a chunk */
+const REQUEST_TIMEOUT time.Duration = 10 * time.Second
+
+/* The client sending every request to the storage servers */
+var HTTP_CLIENT = &http.Client{Timeout: REQUEST_TIMEOUT}
+
+/* Request and response of /storage_read, see API_Storage_Storage.md */
+type readRequest struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+}
+
+type readResponse struct {
+	Data          []byte `json:"data"` // Encoded as Base64
+	ExceptionType string `json:"exception_type"`
+	ExceptionInfo string `json:"exception_info"`
+}
+
+/*
+Read length bytes at offset of the file at path from the storage server at address.
+*/
+func readChunk(address string, path string, offset int64, length int64) ([]byte, error) {
+	jsonBytes, err := json.Marshal(readRequest{path, offset, length})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := HTTP_CLIENT.Post("http://"+address+"/storage_read", "application/json", bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response readResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+	if resp.StatusCode != http.StatusOK || response.ExceptionType != "" {
+		return nil, fmt.Errorf("/storage_read failed: %s %s", response.ExceptionType, response.ExceptionInfo)
+	}
+	if int64(len(response.Data)) != length {
+		return nil, fmt.Errorf("short read of %s at %d from %s", path, offset, address)
+	}
+	return response.Data, nil
+}
+
+/*
+Read the size bytes of the DFS file at path in chunks of chunkSize bytes,
+readers chunks at a time, and reassemble them. Chunks are spread over the
+storage servers at addresses, the client addresses of the file's replicas,
+and a chunk that cannot be read from one of them is retried on the next.
+*/
+func ReadChunks(addresses []string, path string, size int64, chunkSize int64, readers int) ([]byte, error) {
+	if len(addresses) == 0 {
+		return nil, errors.New("no replica of " + path)
+	}
+	if chunkSize <= 0 {
+		chunkSize = CHUNK_SIZE
+	}
+	if readers <= 0 {
+		readers = READERS
+	}
+
+	body := make([]byte, size)
+	chunks := make(chan int64)
+	errs := make(chan error, readers)
+
+	var wg sync.WaitGroup
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for offset := range chunks {
+				length := chunkSize
+				if offset+length > size {
+					length = size - offset
+				}
+
+				// Start from a different replica for each chunk, then try the others
+				first := int(offset/chunkSize) % len(addresses)
+				var err error
+				for try := 0; try < len(addresses); try++ {
+					var data []byte
+					data, err = readChunk(addresses[(first+try)%len(addresses)], path, offset, length)
+					if err == nil {
+						copy(body[offset:], data)
+						break
+					}
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	// Stop handing out chunks once a reader failed
+	var err error
+	for offset := int64(0); offset < size && err == nil; offset += chunkSize {
+		select {
+		case chunks <- offset:
+		case err = <-errs:
+		}
+	}
+	close(chunks)
+	wg.Wait()
+
+	if err == nil {
+		select {
+		case err = <-errs:
+		default:
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
@@ -0,0 +1,58 @@
+package dfsclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/*
+A DFS file must be read in chunks spread over its replicas, and chunks a
+replica fails to serve must be retried on another one.
+*/
+func TestReadChunks(t *testing.T) {
+	body := []byte("a body read in chunks from two replicas")
+
+	var mu sync.Mutex
+	served := map[string]int{}
+	storage := func(name string, fail bool) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req readRequest
+			json.NewDecoder(r.Body).Decode(&req)
+
+			mu.Lock()
+			served[name]++
+			mu.Unlock()
+
+			if fail {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(readResponse{ExceptionType: "FileNotFoundException"})
+				return
+			}
+			json.NewEncoder(w).Encode(readResponse{Data: body[req.Offset : req.Offset+req.Length]})
+		}))
+	}
+	healthy, failing := storage("healthy", false), storage("failing", true)
+	defer healthy.Close()
+	defer failing.Close()
+
+	addresses := []string{strings.TrimPrefix(failing.URL, "http://"), strings.TrimPrefix(healthy.URL, "http://")}
+	read, err := ReadChunks(addresses, "/blobs/hash", int64(len(body)), 4, 3)
+	if err != nil || !bytes.Equal(read, body) {
+		t.Fatalf("got %q, %v, want %q", read, err, body)
+	}
+	if served["failing"] == 0 || served["healthy"] < (len(body)+3)/4 {
+		t.Errorf("got chunks served %v, want them spread over both replicas", served)
+	}
+
+	if _, err := ReadChunks(addresses[:1], "/blobs/hash", int64(len(body)), 4, 3); err == nil {
+		t.Errorf("read succeeded, want it to fail when no replica serves a chunk")
+	}
+	if _, err := ReadChunks(nil, "/blobs/hash", int64(len(body)), 4, 3); err == nil {
+		t.Errorf("read succeeded, want it to fail without replicas")
+	}
+}
@@ -0,0 +1,3 @@
+module dfsclient
+
+go 1.20
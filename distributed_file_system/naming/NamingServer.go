@@ -14,10 +14,17 @@ and has low security and robustness against misbehaving or malicious
 participants. This is meant as a demo and further limitations are described below.
 
 To start the Naming Server simply run this pseudo command line:
-	`go run NamingServer.go arg0 arg1`
+	`go run NamingServer.go arg0 arg1 [arg2] [standby=addr]`
 where arg0 is the Service Port and arg1 is the Registration Port
 and it will start listening for registering storage servers and
-client requests. Outputs can be printed to console in a normal go run
+client requests. The optional arg2 is a retention period such as 24h;
+it turns on trash mode, where deleted locations are moved to /.trash
+and only purged from storage servers once the retention period is over.
+With standby=addr the server is a warm standby of the primary whose
+service interface is at addr, see "Warm Standby" below.
+With config=file the settings in the JSON file (access_threshold,
+replication_factor, lease_duration, trash_retention, min_zones, copy_fanout) override the defaults
+and are reloaded on SIGHUP or /reload, without losing the namespace. Outputs can be printed to console in a normal go run
 however if running `make test`, then the java tests will run the Naming
 Server in threads, so you will not be able to view comments, simply output to
 the designated output files SERVICE_OUT and REGISTRATION_OUT. This is also
@@ -33,6 +40,24 @@ A location contains sublocations that are also locations. This way, the NAMING_S
 only maintains the root Location, from which it can travers and explore all other
 locations on the DFS.
 
+The location tree is partitioned into shards, one per top-level location, each
+with its own mutexes. Requests on paths under different top-level locations
+can therefore create, list and lock concurrently.
+
+---------------------------Warm Standby: ---------------------------
+Every change to the directory tree and the registry is recorded in an
+in-memory metadata journal, served on /journal. A standby tails the
+primary's journal and replays it, so it holds the same namespace, but
+refuses client and registration requests. If the primary fails, an admin
+sends /promote to the standby and points clients and storage servers at it.
+Locks, leases and access counts are not journaled and start afresh.
+
+---------------------------Administration: ---------------------------
+The admin commands (/admin_servers, /admin_namespace, /admin_unlock,
+/admin_rebalance, /admin_read_only, /admin_access_export) are sent by the
+dfsadmin tool in dfsadmin/dfsadmin.go. In read-only mode the namespace does
+not change and files cannot be locked for exclusive access.
+
 ---------------------------Design Limitations: ---------------------------
 This DFS design for a Naming Server assumes well behaved clients and
 storage servers. Clients and storage server implementations should not be
@@ -51,8 +76,8 @@ Here are some suggestions for future design characteristics for the Naming Serve
 	- Economic incentives could be used alongside consensus algorithms to fully decentralize
 	  the system and make it robust against attacks on Naming Server as single source of failure.
 	- Ensure that clients and storage servers are well behaved, punish those who misbehave.
-	- Locks/Unlocks should be able to be transmitted to a storage server, to stop a client from
-	  replaying writes and reads without getting a lock from Naming Server first.
+	- Exclusive locks are transmitted to storage servers as fencing tokens, which stops a client
+	  from replaying writes without a lock. Reads and shared locks are not fenced yet.
 
 */
 
@@ -60,20 +85,39 @@ package main
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
 /* Global Variables and Constants */
 var NAMING_SERVER *NamingServer
-var mu sync.Mutex
+var shards_mu sync.Mutex
 var access_mu sync.Mutex
+var lease_mu sync.Mutex
+var fencing_mu sync.Mutex
+var trash_mu sync.Mutex
+var journal_mu sync.Mutex
+var standby_mu sync.Mutex
+var idempotency_mu sync.Mutex
+var settings_mu sync.Mutex
+var read_only_mu sync.Mutex
+var registry_mu sync.Mutex
 
 /* Output files for logs */
 var SERVICE_OUT os.File
@@ -88,9 +132,68 @@ const IS_DIRECTORY string = "/is_directory"
 const CREATE_DIRECTORY string = "/create_directory"
 const CREATE_FILE string = "/create_file"
 const GET_STORAGE string = "/get_storage"
+const GET_REPLICAS string = "/get_replicas"
 const LOCK string = "/lock"
 const UNLOCK string = "/unlock"
 const DELETE string = "/delete"
+const WATCH string = "/watch"
+const CHECK_REPLICAS string = "/check_replicas"
+const RESTORE string = "/restore"
+const PURGE string = "/purge"
+const JOURNAL string = "/journal"
+const PROMOTE string = "/promote"
+const RELOAD string = "/reload"
+const STORAGE_MOVE string = "/storage_move"
+
+/* API Commands for administering the DFS, used by dfsadmin */
+const ADMIN_SERVERS string = "/admin_servers"
+const ADMIN_NAMESPACE string = "/admin_namespace"
+const ADMIN_UNLOCK string = "/admin_unlock"
+const ADMIN_REBALANCE string = "/admin_rebalance"
+const ADMIN_READ_ONLY string = "/admin_read_only"
+const ADMIN_ACCESS_EXPORT string = "/admin_access_export"
+
+/* Deleted locations are kept here in trash mode */
+const TRASH_NAME string = ".trash"
+const TRASH_PATH string = "/" + TRASH_NAME
+
+/* API Commands sent to Storage Servers */
+const STORAGE_COPY string = "/storage_copy"
+const STORAGE_DELETE string = "/storage_delete"
+const STORAGE_FENCE string = "/storage_fence"
+const STORAGE_CHECKSUM string = "/storage_checksum"
+
+/* API Command of the client interface of Storage Servers */
+const STORAGE_SIZE string = "/storage_size"
+
+/* Response header carrying the fencing token of an exclusive lock */
+const FENCING_TOKEN_HEADER string = "Fencing-Token"
+
+/* Response headers of an exclusive /unlock, when writes must be acknowledged by replicas */
+const REPLICAS_ACKNOWLEDGED_HEADER string = "Replicas-Acknowledged"
+const REPLICATION_WARNING_HEADER string = "Replication-Warning"
+
+/* Request header a client sets to make a retried create or delete safe */
+const IDEMPOTENCY_KEY_HEADER string = "Idempotency-Key"
+
+/* How long the response to an idempotent request is kept for retries */
+const IDEMPOTENCY_TTL = 10 * time.Minute
+
+/* How long a client may cache a /get_storage response before asking again, unless configured */
+const LEASE_DURATION = 10 * time.Second
+
+/* How long an exclusive /unlock waits for replicas to acknowledge a write, unless configured */
+const WRITE_ACK_TIMEOUT = 5 * time.Second
+
+/* How many accesses make a file hot enough to copy to other storage servers, unless configured */
+const ACCESS_THRESHOLD = 20
+
+/* Granularity and retention of the access history exported by /admin_access_export */
+const ACCESS_BUCKET = time.Minute
+const ACCESS_HISTORY = 24 * time.Hour
+
+/* Longest a /journal request waits for new entries */
+const JOURNAL_MAX_WAIT = 30 * time.Second
 
 // This is a Helper function used for easy printing of &Location
 // nested in arrays and structs.
@@ -120,14 +223,60 @@ type NamingServer struct {
 	registrationListener net.Listener
 	registrationPort     string
 
-	/* List of storage servers that registered */
+	/* List of storage servers that registered. Guarded by registry_mu, see Registry. */
 	registry []StorageServer
 
 	/* Root of DFS directory tree. */
 	root *Location
 
+	/* Shards of the directory tree, one per top-level location, keyed by name */
+	shards map[string]*Shard
+
 	/* A map of all files on system and how many times they have been accessed */
 	access_counts map[string]int
+
+	/* Accesses to each file per ACCESS_BUCKET, keyed by path then bucket start in unix seconds */
+	access_history map[string]map[int64]int
+
+	/* Outstanding leases on file locations handed out by /get_storage, keyed by path */
+	leases map[string][]Lease
+
+	/* Clients blocked on /watch, waiting for a path's leases to be invalidated */
+	watchers map[string][]chan bool
+
+	/* Last fencing token issued. Only incremented. */
+	fencing_token int64
+
+	/* Runtime-tunable settings, see Settings. Guarded by settings_mu. */
+	settings Settings
+
+	/* Settings from the command line, used for whatever the config file leaves out */
+	base_settings Settings
+
+	/* JSON config file the settings are reloaded from, if any */
+	config_file string
+
+	/* Locations in /.trash, keyed by their path in the trash */
+	trash map[string]TrashEntry
+
+	/* Used to give each location in /.trash a unique name. Only incremented. */
+	trash_count int
+
+	/* Every change to the metadata, in order. Entry i has sequence number i+1. */
+	journal []JournalEntry
+
+	/* Closed and replaced whenever an entry is added, to wake up /journal requests */
+	journal_notify chan struct{}
+
+	/* A standby tails the journal of the primary at this service address until promoted */
+	standby bool
+	primary string
+
+	/* Responses to requests carrying an Idempotency-Key, keyed by that key */
+	idempotent map[string]*IdempotentResponse
+
+	/* In read-only mode the namespace cannot change and files cannot be locked for writing */
+	read_only bool
 }
 
 /* Functions Related to File System, paths and locations */
@@ -150,6 +299,127 @@ type Location struct {
 
 	/* Array of EL indeces that are waiting to lock this location */
 	exclusive_locks_waiting []int //
+
+	/* Shard of the directory tree this location belongs to */
+	shard *Shard
+}
+
+/*
+A Shard is an independently locked partition of the directory tree.
+Each top-level location and everything below it belongs to its own shard,
+while the root "/" has a shard of its own. Operations on paths under
+different top-level locations never wait on each other.
+*/
+type Shard struct {
+	/*
+		Guards the shape of the subtree, i.e. the sublocations.
+		The root's tree mutex guards the list of top-level locations.
+	*/
+	tree sync.RWMutex
+
+	/* Guards the locks and lock queues of every location in the subtree */
+	locks sync.Mutex
+}
+
+/*
+Return the shard of the top-level location on path, creating it if needed.
+The root path "/" maps to the root's own shard.
+*/
+func (naming_server *NamingServer) ShardOf(path string) *Shard {
+	top := strings.Split(strings.TrimLeft(path, "/"), "/")[0]
+	if top == "" {
+		return naming_server.root.shard
+	}
+
+	shards_mu.Lock()
+	defer shards_mu.Unlock()
+
+	shard, ok := naming_server.shards[top]
+	if !ok {
+		shard = &Shard{}
+		naming_server.shards[top] = shard
+	}
+	return shard
+}
+
+/*
+Return a copy of the registry, each storage server with its own list of
+files, so it can be gone through, and storage servers called, without
+holding registry_mu.
+*/
+func (naming_server *NamingServer) Registry() []StorageServer {
+	registry_mu.Lock()
+	defer registry_mu.Unlock()
+
+	servers := make([]StorageServer, len(naming_server.registry))
+	for i, ss := range naming_server.registry {
+		ss.Files = append([]string{}, ss.Files...)
+		servers[i] = ss
+	}
+	return servers
+}
+
+/*
+Lock the entire directory tree, for operations that move locations
+between shards, and return the function that unlocks it again.
+Every other namespace operation holds the root's tree mutex, so this waits
+for all of them and keeps new ones out.
+*/
+func (naming_server *NamingServer) LockWholeNamespace() func() {
+	naming_server.root.shard.tree.Lock()
+	return naming_server.root.shard.tree.Unlock
+}
+
+/*
+Lock the part of the directory tree needed to operate on path,
+and return the function that unlocks it again.
+
+Readers share the root and the shard of the path. Writers take the
+path's shard exclusively, and also the root's when the write adds
+a top-level location.
+*/
+func (naming_server *NamingServer) LockNamespace(path string, write bool) func() {
+	locations := strings.Split(strings.TrimLeft(path, "/"), "/")
+	root := naming_server.root.shard
+	shard := naming_server.ShardOf(path)
+
+	// Only the root itself is involved
+	if shard == root {
+		if write {
+			root.tree.Lock()
+			return root.tree.Unlock
+		}
+		root.tree.RLock()
+		return root.tree.RUnlock
+	}
+
+	// Always lock the root before a shard, so no two requests deadlock
+	rootWrite := write && len(locations) == 1
+	if rootWrite {
+		root.tree.Lock()
+	} else {
+		root.tree.RLock()
+	}
+
+	if write {
+		shard.tree.Lock()
+	} else {
+		shard.tree.RLock()
+	}
+
+	return func() {
+		if write {
+			shard.tree.Unlock()
+		} else {
+			shard.tree.RUnlock()
+		}
+
+		if rootWrite {
+			root.tree.Unlock()
+		} else {
+			root.tree.RUnlock()
+		}
+	}
 }
 
 /*
@@ -161,6 +431,8 @@ File owner is the storage server that registered with the file.
 func Increment_Access_Count(file string) {
 	access_mu.Lock()
 
+	RecordAccess(file, time.Now())
+
 	// check if the map contains a certain string key
 	if _, ok := NAMING_SERVER.access_counts[file]; ok {
 
@@ -173,7 +445,7 @@ func Increment_Access_Count(file string) {
 		NAMING_SERVER.access_counts[file] = 1
 	}
 
-	if NAMING_SERVER.access_counts[file] >= 20 {
+	if NAMING_SERVER.access_counts[file] >= CurrentSettings().AccessThreshold {
 		NAMING_SERVER.access_counts[file] = 0 // Reset access count
 		access_mu.Unlock()
 		CallStorageCopy(file) // Call storage copy on all storage servers, except file owner
@@ -182,6 +454,194 @@ func Increment_Access_Count(file string) {
 	access_mu.Unlock()
 }
 
+/*
+Count an access to file in the access history, and forget the accesses
+older than ACCESS_HISTORY. Must be called holding access_mu.
+*/
+func RecordAccess(file string, at time.Time) {
+	history, ok := NAMING_SERVER.access_history[file]
+	if !ok {
+		history = map[int64]int{}
+		NAMING_SERVER.access_history[file] = history
+	}
+	history[at.Truncate(ACCESS_BUCKET).Unix()]++
+
+	oldest := at.Add(-ACCESS_HISTORY).Unix()
+	for bucket := range history {
+		if bucket < oldest {
+			delete(history, bucket)
+		}
+	}
+}
+
+/*
+Return the accesses to file in each of the given number of consecutive
+windows ending at end, oldest first.
+*/
+func AccessWindows(file string, end time.Time, window time.Duration, windows int) []AccessWindow {
+	access_mu.Lock()
+	defer access_mu.Unlock()
+
+	counts := []AccessWindow{}
+	start := end.Add(-time.Duration(windows) * window)
+	for i := 0; i < windows; i++ {
+		from, to := start.Add(time.Duration(i)*window), start.Add(time.Duration(i+1)*window)
+		accesses := 0
+		for bucket, count := range NAMING_SERVER.access_history[file] {
+			at := time.Unix(bucket, 0)
+			if !at.Before(from) && at.Before(to) {
+				accesses += count
+			}
+		}
+		counts = append(counts, AccessWindow{Start: from.UTC(), End: to.UTC(), Accesses: accesses})
+	}
+	return counts
+}
+
+/*
+Ask every registered storage server for the size of its replica of file.
+Returns the size of the file and the number of replicas, the size being
+-1 if no storage server holds it.
+*/
+func ReplicaSizes(file string) (int, int) {
+	size, replicas := -1, 0
+
+	for _, ss := range NAMING_SERVER.Registry() {
+		if replica_size, ok := FetchSize(ss.ClientPort, file); ok {
+			replicas++
+			if replica_size > size {
+				size = replica_size
+			}
+		}
+	}
+
+	return size, replicas
+}
+
+/*
+Ask the storage server listening on client_port for the size of its
+replica of file. Returns false if it has no replica or could not answer.
+*/
+func FetchSize(client_port int, file string) (int, bool) {
+	jsonBytes, err := json.Marshal(PathRequest{PathString: file})
+	if err != nil {
+		fmt.Fprintf(&SERVICE_OUT, "Error encoding JSON: %v\n", err)
+		return 0, false
+	}
+
+	requestURL := fmt.Sprintf("http://localhost:%d%s", client_port, STORAGE_SIZE)
+	resp, err := http.Post(requestURL, "application/json", bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		return 0, false
+	}
+	defer resp.Body.Close()
+
+	var reply StorageSize
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&reply) != nil {
+		return 0, false
+	}
+	return reply.Size, true
+}
+
+/*
+Return the size of file and the storage servers holding a replica of it of
+that size, its owner first. Replicas of another size may be stale and are
+left out. The size is the one held by the majority of replicas; on a tie,
+the owner's size wins, as in CheckReplicas.
+*/
+func FileReplicas(file string) (int, []StorageServer) {
+	owner_size := -1
+	votes := map[int]int{}
+	sizes := map[int]int{} // Size of the replica held by each client port
+	holders := []StorageServer{}
+
+	for _, ss := range NAMING_SERVER.Registry() {
+		size, ok := FetchSize(ss.ClientPort, file)
+		if !ok {
+			continue
+		}
+		votes[size]++
+		sizes[ss.ClientPort] = size
+
+		owner := false
+		for _, f := range ss.Files {
+			owner = owner || f == file
+		}
+		if owner {
+			owner_size = size
+			holders = append([]StorageServer{ss}, holders...)
+		} else {
+			holders = append(holders, ss)
+		}
+	}
+
+	// Without the owner, ties go to the largest size, so the choice does not depend on map order
+	correct := owner_size
+	for size, count := range votes {
+		if count > votes[correct] || (count == votes[correct] && correct != owner_size && size > correct) {
+			correct = size
+		}
+	}
+
+	replicas := []StorageServer{}
+	for _, ss := range holders {
+		if sizes[ss.ClientPort] == correct {
+			replicas = append(replicas, ss)
+		}
+	}
+	return correct, replicas
+}
+
+/*
+Summarize the accesses, size and replicas of every file below path
+over the windows of the request, for capacity planning.
+*/
+func ExportAccesses(path string, window time.Duration, windows int) AccessExportResponse {
+	files := []string{}
+	for _, ss := range NAMING_SERVER.Registry() {
+		for _, file := range ss.Files {
+			if path == "/" || file == path || strings.HasPrefix(file, path+"/") {
+				files = append(files, file)
+			}
+		}
+	}
+	sort.Strings(files)
+
+	end := time.Now().Truncate(time.Second)
+	export := AccessExportResponse{Window: window.String(), Files: []AccessSummary{}}
+	for _, file := range files {
+		summary := AccessSummary{PathString: file, Windows: AccessWindows(file, end, window, windows)}
+		summary.Size, summary.Replicas = ReplicaSizes(file)
+		for _, counts := range summary.Windows {
+			summary.Accesses += counts.Accesses
+		}
+		export.Files = append(export.Files, summary)
+	}
+	return export
+}
+
+/*
+Write an access export as CSV, one row per file and window.
+*/
+func WriteAccessCSV(w io.Writer, export AccessExportResponse) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"path", "window_start", "window_end", "accesses", "size", "replicas"})
+	for _, summary := range export.Files {
+		for _, counts := range summary.Windows {
+			out.Write([]string{
+				summary.PathString,
+				counts.Start.Format(time.RFC3339),
+				counts.End.Format(time.RFC3339),
+				strconv.Itoa(counts.Accesses),
+				strconv.Itoa(summary.Size),
+				strconv.Itoa(summary.Replicas),
+			})
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
 /*
 Send the delete command to all Storage Servers,
 but when all == false, send to storage servers that are not
@@ -194,7 +654,8 @@ func SendDelete(file string, all bool) {
 	ports := []int{}
 
 	/* Find which Storage Server owns which file */
-	for _, ss := range NAMING_SERVER.registry {
+	registry := NAMING_SERVER.Registry()
+	for _, ss := range registry {
 		ports = append(ports, ss.CommandPort)
 
 		for _, f := range ss.Files {
@@ -205,7 +666,7 @@ func SendDelete(file string, all bool) {
 		}
 	}
 
-	if len(NAMING_SERVER.registry) > 1 {
+	if len(registry) > 1 {
 
 		/* Create a PathRequest an object */
 		req_obj := PathRequest{PathString: file}
@@ -255,408 +716,1428 @@ func SendDelete(file string, all bool) {
 	}
 }
 
-/* Call storage copy as per API */
-func CallStorageCopy(file string) {
+/*
+Issue a new fencing token, greater than every token issued before.
+*/
+func IssueFencingToken() int64 {
+	fencing_mu.Lock()
+	defer fencing_mu.Unlock()
 
-	owner_port := 0 // Port of storage server that owns file
-	owner_command_port := 0
-	ports := []int{}
+	NAMING_SERVER.fencing_token++
+	return NAMING_SERVER.fencing_token
+}
 
-	/* Find which Storage Server owns which file */
-	for _, ss := range NAMING_SERVER.registry {
-		ports = append(ports, ss.CommandPort)
+/*
+Send the /storage_fence command to all Storage Servers, so that from now on
+they only accept writes to file that present token or a newer one.
 
-		for _, f := range ss.Files {
-			if f == file {
-				owner_port = ss.ClientPort          // Get Storage Server's Client Port
-				owner_command_port = ss.CommandPort // Get Storage Server's Command Port
-			}
-		}
-	}
+This is how exclusive locks are transmitted to storage servers: a client
+that lost its lock can no longer replay writes with its old token.
+*/
+func SendFence(file string, token int64) {
 
-	/* If there are storage servers in the registry and owner's port exists*/
-	if len(NAMING_SERVER.registry) > 1 && owner_port != 0 {
+	/* Create a StorageFence request object */
+	req_obj := StorageFence{Path: file, FencingToken: token}
+
+	/* Marshall request object */
+	jsonBytes, err := json.Marshal(req_obj)
+	if err != nil {
+		fmt.Fprintf(&SERVICE_OUT, "Error encoding JSON: %v\n", err)
+		return
+	}
 
-		/* Get the storage copy as an object */
-		req_obj := StorageCopy{Path: file, ServerIP: "127.0.0.1", ServerPort: owner_port}
+	for _, ss := range NAMING_SERVER.Registry() {
+		requestURL := fmt.Sprintf("http://localhost:%d%s", ss.CommandPort, STORAGE_FENCE)
 
-		/* Marshall request object */
-		jsonBytes, err := json.Marshal(req_obj)
+		// Send request, then wait for a response
+		resp, err := http.Post(requestURL, "application/json", bytes.NewBuffer(jsonBytes))
 		if err != nil {
-			fmt.Fprintf(&SERVICE_OUT, "Error encoding JSON: %v\n", err)
-			return
+			fmt.Fprintf(&SERVICE_OUT, "Error sending HTTP request: %v\n", err)
+			continue
 		}
+		fmt.Fprintf(&SERVICE_OUT, "Sent %s token %d for %s to %d\n", STORAGE_FENCE, token, file, ss.CommandPort)
+		resp.Body.Close()
+	}
+}
 
-		// For each storage server
-		for _, port := range ports {
+/*
+Ask the storage server listening on command_port for the checksum of its
+replica of file. Returns false if it has no replica or could not answer.
+*/
+func FetchChecksum(command_port int, file string) (string, bool) {
+	jsonBytes, err := json.Marshal(PathRequest{PathString: file})
+	if err != nil {
+		fmt.Fprintf(&SERVICE_OUT, "Error encoding JSON: %v\n", err)
+		return "", false
+	}
 
-			if port == owner_command_port {
-				continue
-			}
+	requestURL := fmt.Sprintf("http://localhost:%d%s", command_port, STORAGE_CHECKSUM)
+	resp, err := http.Post(requestURL, "application/json", bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		fmt.Fprintf(&SERVICE_OUT, "Error sending HTTP request: %v\n", err)
+		return "", false
+	}
+	defer resp.Body.Close()
 
-			// Store the command port of ever storage server
-			requestURL := fmt.Sprintf("http://localhost:%d", port)
+	// No replica on this storage server
+	if resp.StatusCode != http.StatusOK {
+		return "", false
+	}
 
-			// Create the request
-			req, err := http.NewRequest("POST", requestURL, bytes.NewBuffer(jsonBytes))
-			if err != nil {
-				fmt.Fprintf(&SERVICE_OUT, "Error creating HTTP request: %v\n", err)
-				return
-			}
+	var checksum StorageChecksum
+	err = json.NewDecoder(resp.Body).Decode(&checksum)
+	if err != nil {
+		fmt.Fprintf(&SERVICE_OUT, "Error decoding JSON: %v\n", err)
+		return "", false
+	}
+	return checksum.Checksum, true
+}
 
-			// Set the request's header to JSON
-			req.Header.Set("Content-Type", "application/json")
+/*
+Compare the checksums of every replica of file held by the registered
+storage servers. The correct copy is the one held by the majority of
+replicas; on a tie, the owner's copy wins.
 
-			// Set the request's URI
-			req.URL.Path = "/storage_copy"
+When repair is true, every divergent replica is overwritten with
+/storage_copy from a storage server holding the correct copy.
+*/
+func CheckReplicas(file string, repair bool) ReplicaReport {
+	report := ReplicaReport{Path: file, Replicas: []ReplicaChecksum{}, Divergent: []int{}}
+
+	owner_checksum := ""
+	votes := map[string]int{}
+	sources := map[string]StorageServer{} // A storage server holding each copy
+
+	/* Collect the checksum of every replica */
+	for _, ss := range NAMING_SERVER.Registry() {
+		checksum, ok := FetchChecksum(ss.CommandPort, file)
+		if !ok {
+			continue
+		}
 
-			client := &http.Client{}
-			// Send request, then wait for a response
-			resp, err := client.Do(req)
-			if err != nil {
-				fmt.Fprintf(&SERVICE_OUT, "Error sending HTTP request: %v\n", err)
-				return
+		report.Replicas = append(report.Replicas, ReplicaChecksum{ServerPort: ss.ClientPort, Checksum: checksum})
+		votes[checksum]++
+		sources[checksum] = ss
+
+		for _, f := range ss.Files {
+			if f == file {
+				owner_checksum = checksum
 			}
-			resp.Body.Close()
 		}
 	}
-}
 
-// return false if path.Path is empty string,
-// doesnt start with delimiter or string contains a colon.
-func IsPathValid(path string) bool {
-	return len(path) > 0 && path[0] == '/' && !strings.Contains(path, ":")
-}
+	/* Elect the correct copy */
+	correct := owner_checksum
+	for checksum, count := range votes {
+		if count > votes[correct] {
+			correct = checksum
+		}
+	}
+	report.Checksum = correct
 
-/*
-Returns true if the given parent directory contains a file.
-*/
-func ContainsX(parentDirectory []string, x string) bool {
-	for _, location := range parentDirectory {
-		if strings.Contains(location, x) {
-			return true
+	for _, replica := range report.Replicas {
+		if replica.Checksum != correct {
+			report.Divergent = append(report.Divergent, replica.ServerPort)
 		}
 	}
 
-	return false
-}
+	if !repair || len(report.Divergent) == 0 {
+		return report
+	}
 
-/* Pop a lock off this location's queue. */
-func (currentLocation *Location) Pop(unlock Lock) {
-	if len(currentLocation.lock_queue) == 0 {
-		return
+	/* Overwrite the divergent replicas from a correct one */
+	source := sources[correct]
+	req_obj := StorageCopy{Path: file, ServerIP: source.StorageIP, ServerPort: source.ClientPort}
+	jsonBytes, err := json.Marshal(req_obj)
+	if err != nil {
+		fmt.Fprintf(&SERVICE_OUT, "Error encoding JSON: %v\n", err)
+		return report
 	}
 
-	currentLock := currentLocation.locks[0]     // Get lock on current location
-	topOfQueue := currentLocation.lock_queue[0] // Get top of the current location's lock queue
+	report.Repaired = true
+	for _, ss := range NAMING_SERVER.Registry() {
+		for _, port := range report.Divergent {
+			if ss.ClientPort != port {
+				continue
+			}
 
-	/*Can safely pop SL from queue IF:
-	unlock, currentLock and topOfQueue are SL
-	*/
-	if !unlock.Exclusive && !currentLock.Exclusive && !topOfQueue.Exclusive {
-		currentLocation.lock_queue = currentLocation.lock_queue[1:]
-		// fmt.Fprintf(&SERVICE_OUT, "Popped lock%v from Queue: %v\n", unlock, currentLocation.lock_queue)
-		return
+			requestURL := fmt.Sprintf("http://localhost:%d/storage_copy", ss.CommandPort)
+			resp, err := http.Post(requestURL, "application/json", bytes.NewBuffer(jsonBytes))
+			if err != nil || resp.StatusCode != http.StatusOK {
+				fmt.Fprintf(&SERVICE_OUT, "Could not repair %s on %d\n", file, port)
+				report.Repaired = false
+			}
+			if err == nil {
+				resp.Body.Close()
+			}
+		}
 	}
 
-	if unlock.Exclusive && topOfQueue.Exclusive && currentLock.Exclusive {
-		currentLocation.lock_queue = currentLocation.lock_queue[1:]
-		currentLocation.exclusive_locks_waiting = currentLocation.exclusive_locks_waiting[1:]
-		// fmt.Fprintf(&SERVICE_OUT, "Popped lock%v from Queue: %v\n", unlock, currentLocation.lock_queue)
-		return
-	}
+	return report
 }
 
 /*
-Return a list of files found at this Directory location.
+Make the storage server with client_port the owner of file, in place
+of whichever storage server owned it before.
 */
-func (currentLocation *Location) GetContentsAt(locationNames []string, ret *[]string) {
+func SwitchOwner(file string, client_port int) {
+	registry_mu.Lock()
+	defer registry_mu.Unlock()
 
-	isFinalLocation := len(locationNames) == 1
-	// Base case, we are the location
-	if isFinalLocation {
-		lastLocation := locationNames[0]
-
-		// If we at the root
-		if currentLocation.name == "/" {
-			// If we want to list the root contents
-			if lastLocation == currentLocation.name {
-				for _, sub := range currentLocation.subLocations {
-					// Collect root sublocation names
-					*ret = append(*ret, sub.name)
-				}
-
-				return // Exit as we have listed root
+	for i := range NAMING_SERVER.registry {
+		ss := &NAMING_SERVER.registry[i]
+		files := []string{}
+		for _, f := range ss.Files {
+			if f != file {
+				files = append(files, f)
 			}
 		}
-
-		// If we are not listing root
-		for _, sub := range currentLocation.subLocations {
-			// If the location we are listing is a currentLocation's sublocation
-			if sub.name == lastLocation {
-				for _, subSub := range sub.subLocations {
-					// Collect all sublocations of the currentLocation sub
-					*ret = append(*ret, subSub.name)
-				}
-			}
+		if ss.ClientPort == client_port {
+			files = append(files, file)
 		}
+		ss.Files = files
+	}
+}
 
-		return // Exit because this is final location
+/* Send a storage command with a JSON body to the storage server on command_port */
+func SendStorageCommand(command_port int, command string, body interface{}) bool {
+	jsonBytes, err := json.Marshal(body)
+	if err != nil {
+		fmt.Fprintf(&SERVICE_OUT, "Error encoding JSON: %v\n", err)
+		return false
 	}
 
-	// Else we have not reached final location
-	for _, sub := range currentLocation.subLocations {
-		midwayLocation := locationNames[0]
+	requestURL := fmt.Sprintf("http://localhost:%d%s", command_port, command)
+	resp, err := http.Post(requestURL, "application/json", bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		fmt.Fprintf(&SERVICE_OUT, "Error sending HTTP request: %v\n", err)
+		return false
+	}
+	defer resp.Body.Close()
 
-		if sub.name == midwayLocation {
-			// Get the rest of the locations, without this midwayLocation
-			restOfLocations := append(locationNames[:0], locationNames[1:]...)
-			sub.GetContentsAt(restOfLocations, ret)
-		}
+	var response ServiceResponse
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&response) != nil {
+		return false
 	}
+	return response.Success
 }
 
 /*
-Returns true if location already exists; false otherwise.
-This function expects the input ret to be false.
-*/
-func (currentLocation *Location) LocationExists(locationNames []string, ret *bool) {
+Move the ownership of file from its owner to the storage server dest:
+copy the file to dest, verify its checksum, switch the owner and delete
+the source copy. The file is locked exclusively while it moves, so no
+client writes to it half way.
 
-	// Base case, final location on path was reached
-	isFinalLocation := len(locationNames) == 1
-	if isFinalLocation && len(currentLocation.subLocations) > 0 {
-		finalLocation := locationNames[0] // Get the final location
-		// For each sub location
-		for _, sub := range currentLocation.subLocations {
-			if finalLocation == sub.name {
-				*ret = true // Set ret to true (because this is a recursive func in golang)
-				return      // Found location
+Returns an error, and leaves the owner as it was, if any step before
+switching the owner fails.
+*/
+func MoveFile(file string, dest StorageServer) (MoveReport, error) {
+	report := MoveReport{Path: file, To: dest.ClientPort}
+
+	lock := Lock{PathString: file, Exclusive: true}
+	locked := false
+	NAMING_SERVER.root.LockLocation(lock, 0, &locked)
+	if !locked {
+		return report, errors.New("could not lock the file")
+	}
+	defer func() {
+		unlocked := false
+		NAMING_SERVER.root.UnlockLocation(lock, 0, &unlocked)
+	}()
+
+	/* Find the owner */
+	var owner *StorageServer
+	for _, ss := range NAMING_SERVER.Registry() {
+		for _, f := range ss.Files {
+			if f == file {
+				ss := ss
+				owner = &ss
 			}
 		}
-		*ret = false // Exited forloop, could not find location
-		return       // Location does not exist
+	}
+	if owner == nil {
+		return report, errors.New("no storage server owns the file")
+	}
+	report.From = owner.ClientPort
+	if owner.ClientPort == dest.ClientPort {
+		return report, errors.New("the storage server already owns the file")
 	}
 
-	// else, this is a midway location
-	midwayLocation := locationNames[0]
+	/* Copy and verify */
+	source := *owner
+	copied := SendStorageCommand(dest.CommandPort, STORAGE_COPY,
+		StorageCopy{Path: file, ServerIP: source.StorageIP, ServerPort: source.ClientPort})
+	if !copied {
+		return report, errors.New("could not copy the file to the storage server")
+	}
 
-	// For each sublocation
-	for _, sub := range currentLocation.subLocations {
-		// If midway is in sublocations
-		if midwayLocation == sub.name {
-			// Get the rest of the locations, without this midwayLocation
-			restOfLocations := append(locationNames[:0], locationNames[1:]...)
-			sub.LocationExists(restOfLocations, ret) // Recurse over midway location
-		}
+	sourceChecksum, ok := FetchChecksum(source.CommandPort, file)
+	destChecksum, destOk := FetchChecksum(dest.CommandPort, file)
+	if !ok || !destOk || sourceChecksum != destChecksum {
+		return report, errors.New("the copy does not match the owner's file")
+	}
+	report.Checksum = destChecksum
+
+	/* Switch owner, then the source copy can go */
+	SwitchOwner(file, dest.ClientPort)
+	Journal(JournalEntry{Op: STORAGE_MOVE, Path: file, Port: dest.ClientPort})
+	InvalidateLeases(file)
+
+	report.SourceDeleted = SendStorageCommand(source.CommandPort, STORAGE_DELETE, PathRequest{PathString: file})
+	if !report.SourceDeleted {
+		fmt.Fprintf(&SERVICE_OUT, "Could not delete %s from %d after moving it\n", file, source.ClientPort)
 	}
+
+	return report, nil
 }
 
 /*
-Returns true if file or directory path does not already exist
-and creates a new location;
-
-Return false if path already exists.
+A location that was deleted in trash mode, waiting in /.trash
+to be restored or purged.
 */
-func (currentLocation *Location) CheckNewPath(locationNames []string, idx int) bool {
-	// If no sub locations,
-	if len(currentLocation.subLocations) == 0 {
-		// just add new locations
-		currentLocation.AppendNewLocation(locationNames)
-		return true // No Conflict
-	}
+type TrashEntry struct {
+	OriginalPath string    // Where the location was before it was deleted
+	Location     *Location // The detached location and everything below it
+	Deleted      time.Time
+}
 
-	// Check if we have reached the final location on path
-	isFinalLocation := (idx == (len(locationNames) - 1))
+/* Split a valid path into the names of its locations, root being no name at all */
+func SplitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
 
-	// Base case, reached final location in "new path"
-	if isFinalLocation {
-		// Get final location of the "new path"
-		finalLocation := locationNames[len(locationNames)-1]
+/*
+Move the location at path into /.trash instead of deleting it.
+Files stay on the storage servers until the location is purged.
 
-		/*
-			Itterate over current sublocations
-		*/
-		for _, sub := range currentLocation.subLocations {
-			// Is sublocation conflicting with finalLocation in "new path"?
-			if finalLocation == sub.name {
-				return false // finalLocation already exists
-			}
-		}
-		// fmt.Fprintf(&REGISTRATION_OUT, "Final Location; No Conflicts\n")
-		// No location conflicts were found from root to finalLocation
-		currentLocation.AppendNewLocation([]string{finalLocation})
+Returns the location's path in the trash, and false if it does not exist.
+*/
+func MoveToTrash(path string) (string, bool) {
+	defer NAMING_SERVER.LockWholeNamespace()()
 
-		return true // No Conflics were found
+	location := NAMING_SERVER.root.DetachLocation(SplitPath(path))
+	if location == nil {
+		return "", false
 	}
 
-	/* Not finalLocation, so get the midway location */
-	midwayLocation := locationNames[idx]
-
-	/*
-		Itterate over current sublocations
-	*/
-	for _, sub := range currentLocation.subLocations {
-		// Is there a sublocation with the same name as the midwayLocation?
-		if midwayLocation == sub.name {
-			// Yes, then increase index and run again
-			return sub.CheckNewPath(locationNames, idx+1)
-		}
+	// Create /.trash the first time it is needed
+	trashExists := false
+	NAMING_SERVER.root.LocationExists([]string{TRASH_NAME}, &trashExists)
+	if !trashExists {
+		trash := &Location{name: TRASH_NAME, locks: []Lock{}, shard: NAMING_SERVER.ShardOf(TRASH_PATH)}
+		NAMING_SERVER.root.subLocations = append(NAMING_SERVER.root.subLocations, trash)
 	}
 
-	/*
-		Exited forloop without finding a location with the same
-		name as the midway location. No conflicting locations.
-	*/
+	trash_mu.Lock()
+	defer trash_mu.Unlock()
 
-	// Path starts from midway location
-	locationNames = locationNames[idx:]
-	// Recursively create a new path without worrying about conflicts
-	currentLocation.AppendNewLocation(locationNames)
+	// Keep the original name, so files and directories can still be told apart
+	NAMING_SERVER.trash_count++
+	location.name = fmt.Sprintf("%s~%d", location.name, NAMING_SERVER.trash_count)
+	NAMING_SERVER.root.AttachLocation([]string{TRASH_NAME}, location)
 
-	// Midway location did not exist, so No Conflicts.
-	return true
+	trashPath := TRASH_PATH + "/" + location.name
+	NAMING_SERVER.trash[trashPath] = TrashEntry{OriginalPath: path, Location: location, Deleted: time.Now()}
+
+	return trashPath, true
 }
 
-/* Create a new path, without worrying about conflicts */
-func (currentLocation *Location) AppendNewLocation(locationNames []string) {
+/*
+Move the location at trashPath back to where it was deleted from.
 
-	// Base case, last location to append
-	if len(locationNames) == 1 {
-		// Append final location
-		newFinalLocation := &Location{name: locationNames[0], locks: []Lock{}}
-		currentLocation.subLocations = append(currentLocation.subLocations, newFinalLocation)
-		fmt.Fprintf(&SERVICE_OUT, "Appending location %v\n", newFinalLocation)
-		return // Return
-	}
+Returns false if trashPath is not in the trash, if the original parent
+directory is gone or if a new location took the original path.
+*/
+func RestoreFromTrash(trashPath string) bool {
+	defer NAMING_SERVER.LockWholeNamespace()()
 
-	/* If not last location, then we are midway */
-	midwayLocation := locationNames[0]
+	trash_mu.Lock()
+	defer trash_mu.Unlock()
 
-	if currentLocation.name == midwayLocation {
-		otherLocations := append(locationNames[:0], locationNames[1:]...) // Remove 0th element
-		currentLocation.AppendNewLocation(otherLocations)                 // Recursive call on the rest of the path
-		return                                                            // Exit
+	entry, ok := NAMING_SERVER.trash[trashPath]
+	if !ok {
+		return false
 	}
 
-	/* Run recursive call on a sub location with the same name. */
-	for _, sub := range currentLocation.subLocations {
-		// If there exists a sublocation with the same name
-		if sub.name == midwayLocation {
-			otherLocations := append(locationNames[:0], locationNames[1:]...)
-			sub.AppendNewLocation(otherLocations) // Recursive call on the rest of the path
-			return                                // Exit
-		}
+	// Take the location out of the trash, rename it back and put it where it was
+	originalNames := SplitPath(entry.OriginalPath)
+	trashName := entry.Location.name
+	NAMING_SERVER.root.DetachLocation(SplitPath(trashPath))
+	entry.Location.name = originalNames[len(originalNames)-1]
+
+	if !NAMING_SERVER.root.AttachLocation(originalNames[:len(originalNames)-1], entry.Location) {
+		// Leave it in the trash
+		entry.Location.name = trashName
+		NAMING_SERVER.root.AttachLocation([]string{TRASH_NAME}, entry.Location)
+		return false
 	}
 
-	// Outside of the loop, there is no midway location with the same name
-	// So create it.
-	newMidwayLocation := &Location{name: midwayLocation, locks: []Lock{}}
-	currentLocation.subLocations = append(currentLocation.subLocations, newMidwayLocation)
+	delete(NAMING_SERVER.trash, trashPath)
+	return true
+}
 
-	/* Run recursive call on a sub location with the same name. */
-	for _, sub := range currentLocation.subLocations {
-		// If there exists a sublocation with the same name
-		if sub.name == midwayLocation {
-			// fmt.Fprintf(&REGISTRATION_OUT, "Midway Location /%s already exists\n", midwayLocation)
-			otherLocations := append(locationNames[:0], locationNames[1:]...)
-			sub.AppendNewLocation(otherLocations) // Recursive call on the rest of the path
-			return                                // Exit
+/*
+Remove the locations in the trash picked by purge from the directory tree.
+Returns the paths in the trash that were removed and their original paths.
+*/
+func TakeFromTrash(purge func(trashPath string, entry TrashEntry) bool) ([]string, []string) {
+	purged := []string{}
+	originalPaths := []string{}
+
+	defer NAMING_SERVER.LockWholeNamespace()()
+	trash_mu.Lock()
+	defer trash_mu.Unlock()
+
+	for trashPath, entry := range NAMING_SERVER.trash {
+		if !purge(trashPath, entry) {
+			continue
 		}
+		NAMING_SERVER.root.DetachLocation(SplitPath(trashPath))
+		delete(NAMING_SERVER.trash, trashPath)
+
+		purged = append(purged, trashPath)
+		originalPaths = append(originalPaths, entry.OriginalPath)
 	}
+
+	return purged, originalPaths
 }
 
 /*
-This function sends a create file command to the first storage server
-in NAMING_SERVER's registry.
+Permanently delete the locations in the trash picked by purge,
+commanding the storage servers to delete their files.
 
-Returns true if API call responded with success == true, false otherwise
+Returns the paths in the trash that were purged.
 */
-func (naming_server *NamingServer) CreateFileOnStorage(path PathRequest) bool {
+func PurgeTrash(purge func(trashPath string, entry TrashEntry) bool) []string {
+	purged, originalPaths := TakeFromTrash(purge)
 
-	// If there are storage servers in the NAMING_SERVER's registry
-	if len(naming_server.registry) > 0 {
+	for _, trashPath := range purged {
+		Journal(JournalEntry{Op: PURGE, Path: trashPath})
+	}
 
-		// Get storage server's command port & create request url
-		command_port := naming_server.registry[0].CommandPort
-		requestURL := fmt.Sprintf("http://localhost:%d", command_port)
+	// Talk to storage servers without holding up the namespace
+	for _, originalPath := range originalPaths {
+		SendDelete(originalPath, true)
+	}
 
-		// JSON encode the path object
-		jsonBytes, err := json.Marshal(path)
-		if err != nil {
-			fmt.Fprintf(&SERVICE_OUT, "Error encoding JSON: %v", err)
-			return false
-		}
+	sort.Strings(purged)
+	return purged
+}
 
-		// Create the request
-		req, err := http.NewRequest("POST", requestURL, bytes.NewBuffer(jsonBytes))
-		if err != nil {
-			fmt.Fprintf(&SERVICE_OUT, "Error creating HTTP request: %v", err)
-			return false
+/*
+Purge the locations in the trash once they are older than the trash retention.
+Runs for as long as the Naming Server does. Turning trash mode off leaves
+the locations in the trash until they are purged with /purge.
+*/
+func PurgeExpiredTrash() {
+	for {
+		// The retention may be reloaded at any time, so check it every round
+		retention := CurrentSettings().TrashRetention
+		interval := retention / 10
+		if interval < time.Second {
+			interval = time.Second
 		}
+		time.Sleep(interval)
 
-		// Set the request's header to JSON
-		req.Header.Set("Content-Type", "application/json")
-
-		// Set the request's URI
-		req.URL.Path = "/storage_create"
-
-		client := &http.Client{} // Initialize an http client
-		// Send request, then wait for a response
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Fprintf(&SERVICE_OUT, "Error sending HTTP request: %v", err)
-			return false
+		// The primary purges the trash, a standby follows its journal
+		if retention == 0 || IsStandby() {
+			continue
 		}
-		fmt.Fprintf(&SERVICE_OUT, "Sent request to storage server: %v", req)
 
-		// Close the connection once done
-		defer resp.Body.Close()
-
-		var response ServiceResponse
-		err = json.NewDecoder(resp.Body).Decode(&response)
-		if err != nil {
-			fmt.Fprintf(&SERVICE_OUT, "Error decoding JSON: %v", err)
-			return false
+		expired := time.Now().Add(-retention)
+		purged := PurgeTrash(func(trashPath string, entry TrashEntry) bool {
+			return entry.Deleted.Before(expired)
+		})
+		if len(purged) > 0 {
+			fmt.Fprintf(&SERVICE_OUT, "Purged expired trash: %v\n", purged)
 		}
-
-		return response.Success // Return whether response was successful or not
 	}
-	return false
 }
 
 /*
-This function starts at root, navigates to the path in the lock,
-then locks that location.
-
-Locations can only be locked if they are not already locked exclusively (write lock),
-otherwise the lock is queued until location is available for locking.
-
-A single location can have multiple read locks, but only one write lock
-at a time.
-
-When a location gets locked for any kind of access,
-all objects along the path to that object, including the root directory,
-must be locked for shared access.
+Purge the locations in the trash that were deleted from path or from
+a directory above it. Their files are still on the storage servers,
+and would get in the way of a new file being created at path.
+*/
+func PurgeTrashAt(path string) {
+	PurgeTrash(func(trashPath string, entry TrashEntry) bool {
+		return path == entry.OriginalPath || strings.HasPrefix(path, entry.OriginalPath+"/")
+	})
+}
 
-ret is set to true if this function is successful.
+/*
+An entry of the metadata journal, recording one change to the directory
+tree or the registry. Op is the command that made the change:
+  - /register: Server registered, with the files it offered
+  - /create_directory, /create_file: Path was created; Stored is set when
+    the first storage server now owns the new file
+  - /delete: Path was deleted, or moved to To in the trash
+  - /restore: Path was restored from the trash
+  - /purge: Path was purged from the trash
+  - /storage_move: the storage server with client port Port now owns Path
 */
-func (currentLocation *Location) LockLocation(lock Lock, idx int, ret *bool) {
-	// Split path string into locations, remove the empty element at beginning of arr
-	locations := strings.Split(lock.PathString, "/")[1:]
+type JournalEntry struct {
+	Seq    int64          `json:"seq"`
+	Op     string         `json:"op"`
+	Path   string         `json:"path,omitempty"`
+	To     string         `json:"to,omitempty"`
+	Stored bool           `json:"stored,omitempty"`
+	Server *StorageServer `json:"server,omitempty"`
+	Port   int            `json:"port,omitempty"`
+}
 
-	// Base case, found location to lock
-	if currentLocation.name == lock.PathString || locations[len(locations)-1] == currentLocation.name {
+/* Append entry to the metadata journal and wake up /journal requests waiting for it */
+func Journal(entry JournalEntry) {
+	journal_mu.Lock()
+	defer journal_mu.Unlock()
 
-		currentLocation.num_locks++                  // Increment the number of locks
-		lock.queue_index = currentLocation.num_locks // Set the lock's queue index
+	entry.Seq = int64(len(NAMING_SERVER.journal)) + 1
+	NAMING_SERVER.journal = append(NAMING_SERVER.journal, entry)
 
-		/* Append lock to queue */
-		mu.Lock()
-		currentLocation.lock_queue = append(currentLocation.lock_queue, lock)
+	close(NAMING_SERVER.journal_notify)
+	NAMING_SERVER.journal_notify = make(chan struct{})
+}
 
-		/* Store the EL's index */
-		if lock.Exclusive {
-			currentLocation.exclusive_locks_waiting = append(currentLocation.exclusive_locks_waiting, lock.queue_index)
+/*
+Return the journal entries after sequence number since, waiting up to wait
+for one to be added when there are none yet.
+*/
+func ReadJournal(since int64, wait time.Duration) []JournalEntry {
+	journal_mu.Lock()
+	if since >= int64(len(NAMING_SERVER.journal)) && wait > 0 {
+		notify := NAMING_SERVER.journal_notify
+		journal_mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-time.After(wait):
+		}
+		journal_mu.Lock()
+	}
+	defer journal_mu.Unlock()
+
+	if since < 0 || since >= int64(len(NAMING_SERVER.journal)) {
+		return []JournalEntry{}
+	}
+	entries := make([]JournalEntry, int64(len(NAMING_SERVER.journal))-since)
+	copy(entries, NAMING_SERVER.journal[since:])
+	return entries
+}
+
+/*
+Make the change recorded by a journal entry of the primary, without
+talking to storage servers, and add it to this server's own journal.
+*/
+func ApplyJournalEntry(entry JournalEntry) {
+	switch entry.Op {
+	case REGISTER:
+		for _, file := range entry.Server.Files {
+			locations := SplitPath(file)
+			if len(locations) == 0 {
+				continue
+			}
+			unlockNamespace := NAMING_SERVER.LockNamespace("/"+locations[0], true)
+			NAMING_SERVER.root.CheckNewPath(locations, 0)
+			unlockNamespace()
+		}
+		registry_mu.Lock()
+		NAMING_SERVER.registry = append(NAMING_SERVER.registry, *entry.Server)
+		registry_mu.Unlock()
+
+	case CREATE_DIRECTORY, CREATE_FILE:
+		unlockNamespace := NAMING_SERVER.LockNamespace(entry.Path, true)
+		NAMING_SERVER.root.CheckNewPath(SplitPath(entry.Path), 0)
+		unlockNamespace()
+
+		registry_mu.Lock()
+		if entry.Stored && len(NAMING_SERVER.registry) > 0 {
+			NAMING_SERVER.registry[0].Files = append(NAMING_SERVER.registry[0].Files, entry.Path)
+		}
+		registry_mu.Unlock()
+
+	case DELETE:
+		if entry.To != "" {
+			// Trash names are handed out in order, so this gives the same name
+			if trashPath, _ := MoveToTrash(entry.Path); trashPath != entry.To {
+				fmt.Fprintf(&SERVICE_OUT, "Journal: %s moved to %s, primary moved it to %s\n", entry.Path, trashPath, entry.To)
+			}
+		} else {
+			unlockNamespace := NAMING_SERVER.LockWholeNamespace()
+			NAMING_SERVER.root.DetachLocation(SplitPath(entry.Path))
+			unlockNamespace()
+		}
+
+	case RESTORE:
+		RestoreFromTrash(entry.Path)
+
+	case PURGE:
+		TakeFromTrash(func(trashPath string, _ TrashEntry) bool {
+			return trashPath == entry.Path
+		})
+
+	case STORAGE_MOVE:
+		SwitchOwner(entry.Path, entry.Port)
+
+	default:
+		fmt.Fprintf(&SERVICE_OUT, "Journal: unknown op %v\n", entry)
+		return
+	}
+
+	Journal(entry)
+}
+
+/* Returns true while this naming server is a standby that has not been promoted */
+func IsStandby() bool {
+	standby_mu.Lock()
+	defer standby_mu.Unlock()
+	return NAMING_SERVER.standby
+}
+
+/* Fetch the primary's journal entries after sequence number since */
+func FetchJournal(client *http.Client, since int64) ([]JournalEntry, error) {
+	jsonBytes, err := json.Marshal(JournalRequest{Since: since, WaitMs: JOURNAL_MAX_WAIT.Milliseconds()})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Post("http://"+NAMING_SERVER.primary+JOURNAL, "application/json", bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("primary responded %v", resp.Status)
+	}
+
+	var response JournalResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	return response.Entries, nil
+}
+
+/*
+Follow the primary's journal, applying every entry to this server's
+directory tree and registry, until this server is promoted.
+*/
+func TailJournal() {
+	client := &http.Client{Timeout: JOURNAL_MAX_WAIT + 5*time.Second}
+	since := int64(0)
+
+	for IsStandby() {
+		entries, err := FetchJournal(client, since)
+		if err != nil {
+			fmt.Fprintf(&SERVICE_OUT, "Journal: cannot reach primary %s: %v\n", NAMING_SERVER.primary, err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		for _, entry := range entries {
+			if entry.Seq != since+1 {
+				fmt.Fprintf(&SERVICE_OUT, "Journal: expected entry %d, got %d\n", since+1, entry.Seq)
+			}
+
+			// Hold off promotion until the entry is applied
+			standby_mu.Lock()
+			if !NAMING_SERVER.standby {
+				standby_mu.Unlock()
+				return
+			}
+			ApplyJournalEntry(entry)
+			standby_mu.Unlock()
+
+			since = entry.Seq
+		}
+	}
+}
+
+/*
+Call storage copy as per API.
+
+The copies are made in waves. In each wave every storage server already
+holding the file, the owner and the replicas updated in earlier waves,
+is the source of at most CopyFanout new replicas, so no single server
+serves every copy. With CopyFanout 0 every replica copies from the owner
+in a single wave.
+*/
+func CallStorageCopy(file string) {
+
+	owner_port := 0 // Port of storage server that owns file
+	var owner StorageServer
+
+	/* Find which Storage Server owns which file */
+	registry := NAMING_SERVER.Registry()
+	for _, ss := range registry {
+		for _, f := range ss.Files {
+			if f == file {
+				owner_port = ss.ClientPort // Get Storage Server's Client Port
+				owner = ss
+			}
+		}
+	}
+
+	/* If there are storage servers in the registry and owner's port exists*/
+	if len(registry) > 1 && owner_port != 0 {
+
+		// Keep the file on at most ReplicationFactor storage servers, owner included,
+		// unless spanning MinZones zones takes more
+		settings := CurrentSettings()
+		copies := settings.ReplicationFactor - 1
+		if settings.ReplicationFactor > 0 && copies < settings.MinZones-1 {
+			copies = settings.MinZones - 1
+		}
+
+		targets, zones := ReplicaTargets(owner)
+		if zones < settings.MinZones {
+			fmt.Fprintf(&SERVICE_OUT, "Only %d zones to copy %s to, want %d\n", zones, file, settings.MinZones)
+		}
+
+		// Those in new zones first
+		if copies >= 0 && copies < len(targets) {
+			targets = targets[:copies]
+		}
+
+		sources := []StorageServer{owner}
+		for len(targets) > 0 {
+			var wave []CopyAssignment
+			wave, targets = CopyWave(sources, targets, settings.CopyFanout)
+
+			// Copy the wave in parallel, the updated replicas are sources of the next one
+			copied := make([]bool, len(wave))
+			var wg sync.WaitGroup
+			for i, assignment := range wave {
+				wg.Add(1)
+				go func(i int, assignment CopyAssignment) {
+					defer wg.Done()
+					copied[i] = SendStorageCopy(file, assignment.Source, assignment.Target)
+				}(i, assignment)
+			}
+			wg.Wait()
+
+			for i, assignment := range wave {
+				if copied[i] {
+					sources = append(sources, assignment.Target)
+				}
+			}
+		}
+	}
+}
+
+/* A storage server to copy a file to, and the one it copies the file from */
+type CopyAssignment struct {
+	Source StorageServer
+	Target StorageServer
+}
+
+/*
+Pair the next targets with the sources to copy from, each source being
+given at most fanout targets, or all of them when fanout is 0.
+Returns the assignments of this wave and the targets left for later waves.
+*/
+func CopyWave(sources []StorageServer, targets []StorageServer, fanout int) ([]CopyAssignment, []StorageServer) {
+	size := len(targets)
+	if fanout > 0 && len(sources)*fanout < size {
+		size = len(sources) * fanout
+	}
+
+	wave := []CopyAssignment{}
+	for i, target := range targets[:size] {
+		wave = append(wave, CopyAssignment{Source: sources[i%len(sources)], Target: target})
+	}
+	return wave, targets[size:]
+}
+
+/*
+Tell target to copy file from source with /storage_copy.
+Returns true once target holds the file.
+*/
+func SendStorageCopy(file string, source StorageServer, target StorageServer) bool {
+	/* Get the storage copy as an object */
+	req_obj := StorageCopy{Path: file, ServerIP: source.StorageIP, ServerPort: source.ClientPort}
+
+	/* Marshall request object */
+	jsonBytes, err := json.Marshal(req_obj)
+	if err != nil {
+		fmt.Fprintf(&SERVICE_OUT, "Error encoding JSON: %v\n", err)
+		return false
+	}
+
+	// The target pulls the file from the source's client interface
+	requestURL := fmt.Sprintf("http://localhost:%d%s", target.CommandPort, STORAGE_COPY)
+
+	// Send request, then wait for a response
+	resp, err := http.Post(requestURL, "application/json", bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		fmt.Fprintf(&SERVICE_OUT, "Error sending HTTP request: %v\n", err)
+		return false
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(&SERVICE_OUT, "Could not copy %s from %d to %d\n", file, source.ClientPort, target.ClientPort)
+		return false
+	}
+	return true
+}
+
+/*
+Make a written file safe before its exclusive lock is released: stale
+replicas are deleted, then the file is copied from its owner to the storage
+servers that must acknowledge the write, WriteReplicas in all with the
+owner, waiting at most WriteAckTimeout for them.
+Returns the number of replicas holding the write, owner included, and the
+number wanted. A copy acknowledged too late still holds the write, but is
+not counted; /check_replicas finds it if a later write makes it stale.
+*/
+func ReplicateWrite(file string) (int, int) {
+	// Stale replicas must not be read once the lock is released
+	SendDelete(file, false)
+
+	settings := CurrentSettings()
+	var owner StorageServer
+	found := false
+	for _, ss := range NAMING_SERVER.Registry() {
+		for _, f := range ss.Files {
+			if f == file {
+				owner, found = ss, true
+			}
+		}
+	}
+	if !found {
+		return 0, 0 // A directory, or a file no storage server hosts
+	}
+	if settings.WriteReplicas <= 1 {
+		return 1, settings.WriteReplicas
+	}
+
+	targets, _ := ReplicaTargets(owner)
+	if len(targets) > settings.WriteReplicas-1 {
+		targets = targets[:settings.WriteReplicas-1]
+	}
+
+	// Buffered, so copies finishing after the timeout do not block
+	acks := make(chan bool, len(targets))
+	for _, target := range targets {
+		go func(target StorageServer) {
+			acks <- SendStorageCopy(file, owner, target)
+		}(target)
+	}
+
+	acknowledged := 1 // The owner holds the write
+	timeout := time.After(settings.WriteAckTimeout)
+	for range targets {
+		select {
+		case ok := <-acks:
+			if ok {
+				acknowledged++
+			}
+		case <-timeout:
+			fmt.Fprintf(&SERVICE_OUT, "Only %d of %d replicas of %s acknowledged the write in %v\n", acknowledged, settings.WriteReplicas, file, settings.WriteAckTimeout)
+			return acknowledged, settings.WriteReplicas
+		}
+	}
+	return acknowledged, settings.WriteReplicas
+}
+
+/*
+A lease on a file's location, handed out with a /get_storage response.
+While the lease is live, a client may keep reading from the storage server
+it was given without asking the Naming Server again.
+*/
+type Lease struct {
+	PathString string
+	Expires    time.Time
+}
+
+/*
+Grant a new lease on the location of file and return it.
+Expired leases on the same file are dropped along the way.
+*/
+func GrantLease(file string) Lease {
+	lease_mu.Lock()
+	defer lease_mu.Unlock()
+
+	now := time.Now()
+
+	// Keep only the leases that are still live
+	live := []Lease{}
+	for _, lease := range NAMING_SERVER.leases[file] {
+		if lease.Expires.After(now) {
+			live = append(live, lease)
+		}
+	}
+
+	lease := Lease{PathString: file, Expires: now.Add(CurrentSettings().LeaseDuration)}
+	NAMING_SERVER.leases[file] = append(live, lease)
+
+	return lease
+}
+
+/*
+Invalidate every lease held on path, or on any file below path when
+path is a directory, and wake up all clients watching those files.
+
+This must be called whenever a file moves between storage servers
+or is deleted, so that clients stop using their cached locations.
+*/
+func InvalidateLeases(path string) {
+	lease_mu.Lock()
+	defer lease_mu.Unlock()
+
+	for file := range NAMING_SERVER.leases {
+		if file == path || strings.HasPrefix(file, strings.TrimRight(path, "/")+"/") {
+			delete(NAMING_SERVER.leases, file)
+			fmt.Fprintf(&SERVICE_OUT, "Invalidated leases on %s\n", file)
+		}
+	}
+
+	for file, watchers := range NAMING_SERVER.watchers {
+		if file == path || strings.HasPrefix(file, strings.TrimRight(path, "/")+"/") {
+			for _, watcher := range watchers {
+				watcher <- true // Buffered, never blocks
+			}
+			delete(NAMING_SERVER.watchers, file)
+		}
+	}
+}
+
+/*
+Block until the leases on file are invalidated or until the
+last live lease on file expires, whichever comes first.
+
+Returns true if the leases were invalidated, or if there was no live lease
+to begin with; false if the leases simply ran out.
+*/
+func WatchLeases(file string) bool {
+	lease_mu.Lock()
+
+	// Find when the last live lease on this file expires
+	expires := time.Now()
+	for _, lease := range NAMING_SERVER.leases[file] {
+		if lease.Expires.After(expires) {
+			expires = lease.Expires
+		}
+	}
+
+	// No live lease, so any cached location is already stale
+	if !expires.After(time.Now()) {
+		lease_mu.Unlock()
+		return true
+	}
+
+	watcher := make(chan bool, 1)
+	NAMING_SERVER.watchers[file] = append(NAMING_SERVER.watchers[file], watcher)
+	lease_mu.Unlock()
+
+	select {
+	case <-watcher:
+		return true
+	case <-time.After(time.Until(expires)):
+		// Stop watching, the lease ran out on its own
+		lease_mu.Lock()
+		watchers := NAMING_SERVER.watchers[file]
+		for i, w := range watchers {
+			if w == watcher {
+				NAMING_SERVER.watchers[file] = append(watchers[:i], watchers[i+1:]...)
+				break
+			}
+		}
+		lease_mu.Unlock()
+		return false
+	}
+}
+
+// return false if path.Path is empty string,
+// doesnt start with delimiter or string contains a colon.
+func IsPathValid(path string) bool {
+	return len(path) > 0 && path[0] == '/' && !strings.Contains(path, ":")
+}
+
+/*
+Sort the names listed in a directory, keep the ones matching the request's
+glob pattern, then cut out the page that starts right after the request's
+continuation token and holds at most Limit names (all of them if Limit <= 0).
+
+Returns the page and the continuation token of the next page,
+which is empty when this is the last page.
+*/
+func PageContents(content []string, req ListRequest) ([]string, string, error) {
+	names := []string{}
+
+	for _, name := range content {
+		if req.Pattern != "" {
+			matched, err := filepath.Match(req.Pattern, name)
+			if err != nil {
+				return nil, "", err // Malformed pattern
+			}
+			if !matched {
+				continue
+			}
+		}
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	/* Skip everything up to and including the last name of the previous page */
+	if req.Token != "" {
+		last, err := base64.RawURLEncoding.DecodeString(req.Token)
+		if err != nil {
+			return nil, "", errors.New("malformed continuation token")
+		}
+		start := sort.SearchStrings(names, string(last))
+		if start < len(names) && names[start] == string(last) {
+			start++
+		}
+		names = names[start:]
+	}
+
+	/* Cut the page, hand out a token if more names are left */
+	if req.Limit > 0 && len(names) > req.Limit {
+		names = names[:req.Limit]
+		return names, base64.RawURLEncoding.EncodeToString([]byte(names[len(names)-1])), nil
+	}
+
+	return names, "", nil
+}
+
+/*
+Returns true if the given parent directory contains a file.
+*/
+func ContainsX(parentDirectory []string, x string) bool {
+	for _, location := range parentDirectory {
+		if strings.Contains(location, x) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/* Pop a lock off this location's queue. */
+func (currentLocation *Location) Pop(unlock Lock) {
+	if len(currentLocation.lock_queue) == 0 {
+		return
+	}
+
+	currentLock := currentLocation.locks[0]     // Get lock on current location
+	topOfQueue := currentLocation.lock_queue[0] // Get top of the current location's lock queue
+
+	/*Can safely pop SL from queue IF:
+	unlock, currentLock and topOfQueue are SL
+	*/
+	if !unlock.Exclusive && !currentLock.Exclusive && !topOfQueue.Exclusive {
+		currentLocation.lock_queue = currentLocation.lock_queue[1:]
+		// fmt.Fprintf(&SERVICE_OUT, "Popped lock%v from Queue: %v\n", unlock, currentLocation.lock_queue)
+		return
+	}
+
+	if unlock.Exclusive && topOfQueue.Exclusive && currentLock.Exclusive {
+		currentLocation.lock_queue = currentLocation.lock_queue[1:]
+		currentLocation.exclusive_locks_waiting = currentLocation.exclusive_locks_waiting[1:]
+		// fmt.Fprintf(&SERVICE_OUT, "Popped lock%v from Queue: %v\n", unlock, currentLocation.lock_queue)
+		return
+	}
+}
+
+/*
+Return a list of files found at this Directory location.
+*/
+func (currentLocation *Location) GetContentsAt(locationNames []string, ret *[]string) {
+
+	isFinalLocation := len(locationNames) == 1
+	// Base case, we are the location
+	if isFinalLocation {
+		lastLocation := locationNames[0]
+
+		// If we at the root
+		if currentLocation.name == "/" {
+			// If we want to list the root contents
+			if lastLocation == currentLocation.name {
+				for _, sub := range currentLocation.subLocations {
+					// Collect root sublocation names
+					*ret = append(*ret, sub.name)
+				}
+
+				return // Exit as we have listed root
+			}
+		}
+
+		// If we are not listing root
+		for _, sub := range currentLocation.subLocations {
+			// If the location we are listing is a currentLocation's sublocation
+			if sub.name == lastLocation {
+				for _, subSub := range sub.subLocations {
+					// Collect all sublocations of the currentLocation sub
+					*ret = append(*ret, subSub.name)
+				}
+			}
+		}
+
+		return // Exit because this is final location
+	}
+
+	// Else we have not reached final location
+	for _, sub := range currentLocation.subLocations {
+		midwayLocation := locationNames[0]
+
+		if sub.name == midwayLocation {
+			// Get the rest of the locations, without this midwayLocation
+			restOfLocations := append(locationNames[:0], locationNames[1:]...)
+			sub.GetContentsAt(restOfLocations, ret)
+		}
+	}
+}
+
+/*
+Return the location at the end of locationNames, an empty locationNames
+being this location, or nil if there is no such location.
+*/
+func (currentLocation *Location) FindLocation(locationNames []string) *Location {
+	if len(locationNames) == 0 {
+		return currentLocation
+	}
+
+	for _, sub := range currentLocation.subLocations {
+		if sub.name == locationNames[0] {
+			return sub.FindLocation(locationNames[1:])
+		}
+	}
+
+	return nil // Location does not exist
+}
+
+/*
+Append an entry for every location below this one to ret, depth first,
+where path is the path of this location. owners maps files to the
+client port of the storage server that owns them.
+*/
+func (currentLocation *Location) DumpLocations(path string, owners map[string]int, ret *[]NamespaceEntry) {
+	for _, sub := range currentLocation.subLocations {
+		subPath := strings.TrimRight(path, "/") + "/" + sub.name
+
+		sub.shard.locks.Lock()
+		entry := NamespaceEntry{
+			PathString: subPath,
+			Directory:  !strings.Contains(sub.name, "file"),
+			Locks:      len(sub.locks),
+			Exclusive:  len(sub.locks) > 0 && sub.locks[0].Exclusive,
+			Owner:      owners[subPath],
+		}
+		sub.shard.locks.Unlock()
+
+		*ret = append(*ret, entry)
+		sub.DumpLocations(subPath, owners, ret)
+	}
+}
+
+/*
+Returns true if location already exists; false otherwise.
+This function expects the input ret to be false.
+*/
+func (currentLocation *Location) LocationExists(locationNames []string, ret *bool) {
+
+	// Base case, final location on path was reached
+	isFinalLocation := len(locationNames) == 1
+	if isFinalLocation && len(currentLocation.subLocations) > 0 {
+		finalLocation := locationNames[0] // Get the final location
+		// For each sub location
+		for _, sub := range currentLocation.subLocations {
+			if finalLocation == sub.name {
+				*ret = true // Set ret to true (because this is a recursive func in golang)
+				return      // Found location
+			}
+		}
+		*ret = false // Exited forloop, could not find location
+		return       // Location does not exist
+	}
+
+	// else, this is a midway location
+	midwayLocation := locationNames[0]
+
+	// For each sublocation
+	for _, sub := range currentLocation.subLocations {
+		// If midway is in sublocations
+		if midwayLocation == sub.name {
+			// Get the rest of the locations, without this midwayLocation
+			restOfLocations := append(locationNames[:0], locationNames[1:]...)
+			sub.LocationExists(restOfLocations, ret) // Recurse over midway location
+		}
+	}
+}
+
+/*
+Returns true if file or directory path does not already exist
+and creates a new location;
+
+Return false if path already exists.
+*/
+func (currentLocation *Location) CheckNewPath(locationNames []string, idx int) bool {
+	// If no sub locations,
+	if len(currentLocation.subLocations) == 0 {
+		// just add new locations
+		currentLocation.AppendNewLocation(locationNames)
+		return true // No Conflict
+	}
+
+	// Check if we have reached the final location on path
+	isFinalLocation := (idx == (len(locationNames) - 1))
+
+	// Base case, reached final location in "new path"
+	if isFinalLocation {
+		// Get final location of the "new path"
+		finalLocation := locationNames[len(locationNames)-1]
+
+		/*
+			Itterate over current sublocations
+		*/
+		for _, sub := range currentLocation.subLocations {
+			// Is sublocation conflicting with finalLocation in "new path"?
+			if finalLocation == sub.name {
+				return false // finalLocation already exists
+			}
+		}
+		// fmt.Fprintf(&REGISTRATION_OUT, "Final Location; No Conflicts\n")
+		// No location conflicts were found from root to finalLocation
+		currentLocation.AppendNewLocation([]string{finalLocation})
+
+		return true // No Conflics were found
+	}
+
+	/* Not finalLocation, so get the midway location */
+	midwayLocation := locationNames[idx]
+
+	/*
+		Itterate over current sublocations
+	*/
+	for _, sub := range currentLocation.subLocations {
+		// Is there a sublocation with the same name as the midwayLocation?
+		if midwayLocation == sub.name {
+			// Yes, then increase index and run again
+			return sub.CheckNewPath(locationNames, idx+1)
+		}
+	}
+
+	/*
+		Exited forloop without finding a location with the same
+		name as the midway location. No conflicting locations.
+	*/
+
+	// Path starts from midway location
+	locationNames = locationNames[idx:]
+	// Recursively create a new path without worrying about conflicts
+	currentLocation.AppendNewLocation(locationNames)
+
+	// Midway location did not exist, so No Conflicts.
+	return true
+}
+
+/*
+Detach the location at the end of locationNames from its parent and return it,
+or return nil if there is no such location. locationNames is left untouched.
+*/
+func (currentLocation *Location) DetachLocation(locationNames []string) *Location {
+	name := locationNames[0]
+
+	for i, sub := range currentLocation.subLocations {
+		if sub.name != name {
+			continue
+		}
+
+		// Base case, sub is the location to detach
+		if len(locationNames) == 1 {
+			currentLocation.subLocations = append(currentLocation.subLocations[:i:i], currentLocation.subLocations[i+1:]...)
+			return sub
+		}
+
+		return sub.DetachLocation(locationNames[1:])
+	}
+
+	return nil // Location does not exist
+}
+
+/*
+Attach location under the existing directory at parentNames,
+an empty parentNames being the root. Returns false if the parent
+does not exist or already holds a location with the same name.
+*/
+func (currentLocation *Location) AttachLocation(parentNames []string, location *Location) bool {
+	// Base case, currentLocation is the parent
+	if len(parentNames) == 0 {
+		for _, sub := range currentLocation.subLocations {
+			if sub.name == location.name {
+				return false // Name is taken
+			}
+		}
+		currentLocation.subLocations = append(currentLocation.subLocations, location)
+		return true
+	}
+
+	for _, sub := range currentLocation.subLocations {
+		if sub.name == parentNames[0] {
+			return sub.AttachLocation(parentNames[1:], location)
+		}
+	}
+
+	return false // Parent does not exist
+}
+
+/*
+Return the shard of a new sublocation called name. Top-level locations
+get a shard of their own, all others share their parent's shard.
+*/
+func (currentLocation *Location) SubShard(name string) *Shard {
+	if currentLocation == NAMING_SERVER.root {
+		return NAMING_SERVER.ShardOf(name)
+	}
+	return currentLocation.shard
+}
+
+/* Create a new path, without worrying about conflicts */
+func (currentLocation *Location) AppendNewLocation(locationNames []string) {
+
+	// Base case, last location to append
+	if len(locationNames) == 1 {
+		// Append final location
+		newFinalLocation := &Location{name: locationNames[0], locks: []Lock{}, shard: currentLocation.SubShard(locationNames[0])}
+		currentLocation.subLocations = append(currentLocation.subLocations, newFinalLocation)
+		fmt.Fprintf(&SERVICE_OUT, "Appending location %v\n", newFinalLocation)
+		return // Return
+	}
+
+	/* If not last location, then we are midway */
+	midwayLocation := locationNames[0]
+
+	if currentLocation.name == midwayLocation {
+		otherLocations := append(locationNames[:0], locationNames[1:]...) // Remove 0th element
+		currentLocation.AppendNewLocation(otherLocations)                 // Recursive call on the rest of the path
+		return                                                            // Exit
+	}
+
+	/* Run recursive call on a sub location with the same name. */
+	for _, sub := range currentLocation.subLocations {
+		// If there exists a sublocation with the same name
+		if sub.name == midwayLocation {
+			otherLocations := append(locationNames[:0], locationNames[1:]...)
+			sub.AppendNewLocation(otherLocations) // Recursive call on the rest of the path
+			return                                // Exit
+		}
+	}
+
+	// Outside of the loop, there is no midway location with the same name
+	// So create it.
+	newMidwayLocation := &Location{name: midwayLocation, locks: []Lock{}, shard: currentLocation.SubShard(midwayLocation)}
+	currentLocation.subLocations = append(currentLocation.subLocations, newMidwayLocation)
+
+	/* Run recursive call on a sub location with the same name. */
+	for _, sub := range currentLocation.subLocations {
+		// If there exists a sublocation with the same name
+		if sub.name == midwayLocation {
+			// fmt.Fprintf(&REGISTRATION_OUT, "Midway Location /%s already exists\n", midwayLocation)
+			otherLocations := append(locationNames[:0], locationNames[1:]...)
+			sub.AppendNewLocation(otherLocations) // Recursive call on the rest of the path
+			return                                // Exit
+		}
+	}
+}
+
+/*
+This function sends a create file command to the first storage server
+in NAMING_SERVER's registry.
+
+Returns true if API call responded with success == true, false otherwise
+*/
+func (naming_server *NamingServer) CreateFileOnStorage(path PathRequest) bool {
+
+	// If there are storage servers in the NAMING_SERVER's registry
+	if registry := naming_server.Registry(); len(registry) > 0 {
+
+		// Get storage server's command port & create request url
+		command_port := registry[0].CommandPort
+		requestURL := fmt.Sprintf("http://localhost:%d", command_port)
+
+		// JSON encode the path object
+		jsonBytes, err := json.Marshal(path)
+		if err != nil {
+			fmt.Fprintf(&SERVICE_OUT, "Error encoding JSON: %v", err)
+			return false
+		}
+
+		// Create the request
+		req, err := http.NewRequest("POST", requestURL, bytes.NewBuffer(jsonBytes))
+		if err != nil {
+			fmt.Fprintf(&SERVICE_OUT, "Error creating HTTP request: %v", err)
+			return false
+		}
+
+		// Set the request's header to JSON
+		req.Header.Set("Content-Type", "application/json")
+
+		// Set the request's URI
+		req.URL.Path = "/storage_create"
+
+		client := &http.Client{} // Initialize an http client
+		// Send request, then wait for a response
+		resp, err := client.Do(req)
+		if err != nil {
+			fmt.Fprintf(&SERVICE_OUT, "Error sending HTTP request: %v", err)
+			return false
+		}
+		fmt.Fprintf(&SERVICE_OUT, "Sent request to storage server: %v", req)
+
+		// Close the connection once done
+		defer resp.Body.Close()
+
+		var response ServiceResponse
+		err = json.NewDecoder(resp.Body).Decode(&response)
+		if err != nil {
+			fmt.Fprintf(&SERVICE_OUT, "Error decoding JSON: %v", err)
+			return false
+		}
+
+		return response.Success // Return whether response was successful or not
+	}
+	return false
+}
+
+/*
+This function starts at root, navigates to the path in the lock,
+then locks that location.
+
+Locations can only be locked if they are not already locked exclusively (write lock),
+otherwise the lock is queued until location is available for locking.
+
+A single location can have multiple read locks, but only one write lock
+at a time.
+
+When a location gets locked for any kind of access,
+all objects along the path to that object, including the root directory,
+must be locked for shared access.
+
+ret is set to true if this function is successful.
+*/
+func (currentLocation *Location) LockLocation(lock Lock, idx int, ret *bool) {
+	// Split path string into locations, remove the empty element at beginning of arr
+	locations := strings.Split(lock.PathString, "/")[1:]
+
+	// Base case, found location to lock
+	if currentLocation.name == lock.PathString || locations[len(locations)-1] == currentLocation.name {
+
+		currentLocation.num_locks++                  // Increment the number of locks
+		lock.queue_index = currentLocation.num_locks // Set the lock's queue index
+
+		/* Append lock to queue */
+		currentLocation.shard.locks.Lock()
+		currentLocation.lock_queue = append(currentLocation.lock_queue, lock)
+
+		/* Store the EL's index */
+		if lock.Exclusive {
+			currentLocation.exclusive_locks_waiting = append(currentLocation.exclusive_locks_waiting, lock.queue_index)
 		}
 
 		/* Handle Read lock encounter first. */
@@ -665,400 +2146,1415 @@ func (currentLocation *Location) LockLocation(lock Lock, idx int, ret *bool) {
 		if !lock.Exclusive && len(currentLocation.exclusive_locks_waiting) < 1 && len(currentLocation.locks) >= 1 && !currentLocation.locks[0].Exclusive {
 			// Append new read lock
 			currentLocation.locks = append(currentLocation.locks, lock)
-			mu.Unlock()
+			currentLocation.shard.locks.Unlock()
+			*ret = true
+			return
+		}
+
+		// If this location already has a lock
+		if len(currentLocation.locks) > 0 {
+			currentLocation.shard.locks.Unlock()
+
+			for {
+				// If there are no more locks on this location
+				currentLocation.shard.locks.Lock()
+				if len(currentLocation.locks) == 0 {
+					topOfQueue := lock // Initialize top of queue variable
+
+					if len(currentLocation.lock_queue) > 0 {
+						topOfQueue = currentLocation.lock_queue[0]
+					}
+
+					// Only top of the queue ELs are allowed.
+					if topOfQueue.Exclusive && lock.queue_index != topOfQueue.queue_index {
+						currentLocation.shard.locks.Unlock()
+						continue
+					}
+
+					// If top of the queue is SL and EL is in queue
+					// Do not surpass EL's index
+					if !topOfQueue.Exclusive && len(currentLocation.exclusive_locks_waiting) > 0 && lock.queue_index >= currentLocation.exclusive_locks_waiting[0] {
+						currentLocation.shard.locks.Unlock()
+						continue
+					}
+
+					// Append new read or write lock
+					currentLocation.locks = append(currentLocation.locks, lock)
+					currentLocation.shard.locks.Unlock()
+					*ret = true
+					return
+				}
+
+				// If there is an SL on this location
+				if len(currentLocation.locks) > 0 && !currentLocation.locks[0].Exclusive {
+
+					// Ensure they do no surpass index of EL in queue
+					if len(currentLocation.exclusive_locks_waiting) > 0 && lock.queue_index >= currentLocation.exclusive_locks_waiting[0] {
+						currentLocation.shard.locks.Unlock()
+						continue
+					}
+
+					// Append new read or write lock
+					currentLocation.locks = append(currentLocation.locks, lock)
+					currentLocation.shard.locks.Unlock()
+					*ret = true
+					return
+				}
+				currentLocation.shard.locks.Unlock()
+			}
+		}
+
+		// Append new read or write lock
+		currentLocation.locks = append(currentLocation.locks, lock)
+		currentLocation.shard.locks.Unlock()
+		*ret = true
+		return
+	}
+
+	nextLocation := locations[idx]
+
+	// For each sublocation
+	for _, sub := range currentLocation.subLocations {
+
+		// Find the sublocation with the same name as next location
+		if nextLocation == sub.name {
+
+			// If current location is exclusively locked,
+			// wait until it becomes free
+			currentLocation.shard.locks.Lock()
+			if len(currentLocation.locks) > 0 && len(currentLocation.locks) == 1 && currentLocation.locks[0].Exclusive {
+				currentLocation.shard.locks.Unlock()
+
+				fmt.Fprintf(&SERVICE_OUT, "Waiting for %v to be free...", currentLocation.name)
+
+				for {
+					currentLocation.shard.locks.Lock()
+					if len(currentLocation.locks) == 0 {
+						currentLocation.shard.locks.Unlock()
+						break
+					}
+					currentLocation.shard.locks.Unlock()
+				}
+
+				sl := Lock{PathString: currentLocation.name, Exclusive: false}
+
+				currentLocation.num_locks++                // Increment the number of locks
+				sl.queue_index = currentLocation.num_locks // Set the lock's queue index
+
+				/* Append lock to queue */
+				currentLocation.lock_queue = append(currentLocation.lock_queue, sl)
+				fmt.Fprintf(&SERVICE_OUT, "\nAdded lock%v to Queue\n\n", sl)
+
+				/* Actually lock this location */
+				currentLocation.locks = append(currentLocation.locks, sl) // Locking all locations along the path
+				fmt.Fprintf(&SERVICE_OUT, "Midway location %v has New Read Lock appended\n", currentLocation.name)
+
+				/* Recurse */
+				sub.LockLocation(lock, idx+1, ret)
+				return // return after recursing
+			}
+			currentLocation.shard.locks.Unlock()
+
+			/*
+				API:
+				"When a client requests that any object be locked for any kind of access,
+				all objects along the path to that object, including the root directory,
+				must be locked for shared access."
+			*/
+
+			/* Create shared lock */
+			sl := Lock{PathString: currentLocation.name, Exclusive: false}
+
+			currentLocation.num_locks++                // Increment the number of locks
+			sl.queue_index = currentLocation.num_locks // Set the lock's queue index
+
+			/* Append lock to queue */
+			currentLocation.lock_queue = append(currentLocation.lock_queue, sl)
+			fmt.Fprintf(&SERVICE_OUT, "\nAdded lock%v to Queue\n\n", sl)
+
+			/* Actually lock this location */
+			currentLocation.locks = append(currentLocation.locks, sl) // Locking all locations along the path
+			fmt.Fprintf(&SERVICE_OUT, "Midway location %v has New Read Lock appended\n", currentLocation.name)
+
+			/* Recurse */
+			sub.LockLocation(lock, idx+1, ret)
+			return // return after recursing
+		}
+	}
+}
+
+/*
+This function starts at root, recursively navigates to the path to be unlocked,
+then unlocks it if it is the correct unlock. Assumes location exists.
+
+Along the way, it removes a single read lock per midway location, assuming it finds
+a read lock there.
+
+ret is set to true if this function is successful.
+*/
+func (currentLocation *Location) UnlockLocation(unlock Lock, idx int, ret *bool) {
+
+	// Split path string into locations, remove the empty element at beginning of arr
+	locations := strings.Split(unlock.PathString, "/")[1:]
+
+	/* Case 1:
+	-> pathString is root or reached the final location to unlock
+	*/
+	if currentLocation.name == unlock.PathString || currentLocation.name == locations[len(locations)-1] {
+
+		/* Handle Non-Exclusive unlocks first */
+		// Read unlock and final location has One or multiple read locks
+		currentLocation.shard.locks.Lock()
+		if !unlock.Exclusive && len(currentLocation.locks) >= 1 && !currentLocation.locks[0].Exclusive {
+
+			currentLocation.Pop(unlock) // Pop thhis lock off the queue
+
+			currentLocation.locks = currentLocation.locks[1:] // Unlock this location
+			currentLocation.shard.locks.Unlock()
+			*ret = true
+			return
+		}
+
+		/* Handle Exclusive unlocks */
+		// Exclusive unlock and final location has one exclusive lock
+		if unlock.Exclusive && len(currentLocation.locks) == 1 && currentLocation.locks[0].Exclusive {
+			currentLocation.Pop(unlock)                       // Pop this lock off the queue
+			currentLocation.locks = currentLocation.locks[1:] // Unlock location
+			currentLocation.shard.locks.Unlock()
 			*ret = true
 			return
 		}
+		currentLocation.shard.locks.Unlock()
+
+		return // Exit
+	}
+
+	/* Case 2:
+	-> Search for next location, remove one lock from current location
+	*/
+	nextLocation := locations[idx]
+	// For each sublocation
+	for _, sub := range currentLocation.subLocations {
+		// Search and find the next sub location
+		if sub.name == nextLocation {
+
+			/* Handle current location before recursing on sub location. */
+
+			// If current location has a read lock on it, remove it
+			currentLocation.shard.locks.Lock()
+			if len(currentLocation.locks) >= 1 && !currentLocation.locks[0].Exclusive {
+				currentLocation.Pop(currentLocation.locks[0])     // XD Pop lock
+				currentLocation.locks = currentLocation.locks[1:] // Remove one read lock from this location
+				currentLocation.shard.locks.Unlock()
+				/* Recurse to next sublocation */
+				sub.UnlockLocation(unlock, idx+1, ret)
+				return // return after recursing
+			}
+			currentLocation.shard.locks.Unlock()
+
+			/* Recurse to next sublocation */
+			sub.UnlockLocation(unlock, idx+1, ret)
+			return // return after recursing
+		}
+	}
+}
+
+/* Exception types according to API */
+const ILLEGAL_ARGUMENT string = "IllegalArgumentException"
+const FILE_NOT_FOUND string = "FileNotFoundException"
+const ILLEGAL_STATE string = "IllegalStateException"
+
+/*
+Respond to a request with an exception, as per API.
+Invalid paths are IllegalArgumentException, missing files and directories
+are FileNotFoundException, both with 404; IllegalStateException is 409.
+*/
+func RespondWithException(w http.ResponseWriter, status int, exceptionType string, exceptionInfo string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	response := ExceptionResponse{
+		ExceptionType: exceptionType,
+		ExceptionInfo: exceptionInfo,
+	}
+	fmt.Fprintf(&SERVICE_OUT, "Sending %d: %v\n", status, response)
+	json.NewEncoder(w).Encode(response)
+}
+
+/*
+Decode the JSON body of r into req. Unknown fields, fields of the wrong type
+and bodies that leave out one of the required fields are rejected with
+400 Bad Request and an IllegalArgumentException saying what is wrong,
+in which case false is returned.
+*/
+func DecodeRequest(w http.ResponseWriter, r *http.Request, req interface{}, required ...string) bool {
+	err := DecodeStrict(r.Body, req, required)
+	if err != nil {
+		fmt.Fprintf(&SERVICE_OUT, "ERROR: %v\n", err)
+		RespondWithException(w, http.StatusBadRequest, ILLEGAL_ARGUMENT, "invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+/* Decode a JSON object from body into req, see DecodeRequest */
+func DecodeStrict(body io.Reader, req interface{}, required []string) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return fmt.Errorf("not a JSON object: %v", err)
+	}
+	for _, field := range required {
+		if value, ok := fields[field]; !ok || string(value) == "null" {
+			return fmt.Errorf("missing required field %q", field)
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(req)
+}
+
+/* JSON structs according to API */
+
+type PathRequest struct {
+	PathString string `json:"path"`
+}
+
+type StorageServer struct {
+	StorageIP   string   `json:"storage_ip"`
+	ClientPort  int      `json:"client_port"`
+	CommandPort int      `json:"command_port"`
+	Files       []string `json:"files"`
+	Zone        string   `json:"zone,omitempty"` // Simulated datacenter, empty if not given
+}
+
+type StorageCopy struct {
+	Path       string `json:"path"`
+	ServerIP   string `json:"server_ip"`
+	ServerPort int    `json:"server_port"`
+}
+
+type StorageFence struct {
+	Path         string `json:"path"`
+	FencingToken int64  `json:"fencing_token"`
+}
+
+type StorageChecksum struct {
+	Path     string `json:"path"`
+	Checksum string `json:"checksum"`
+}
+
+type ReplicaCheckRequest struct {
+	PathString string `json:"path"`   // File or directory to check
+	Repair     bool   `json:"repair"` // Overwrite divergent replicas
+}
+
+type ReplicaChecksum struct {
+	ServerPort int    `json:"server_port"`
+	Checksum   string `json:"checksum"`
+}
+
+type ReplicaReport struct {
+	Path      string            `json:"path"`
+	Checksum  string            `json:"checksum"`  // Checksum of the correct copy
+	Replicas  []ReplicaChecksum `json:"replicas"`  // Every replica found
+	Divergent []int             `json:"divergent"` // Client ports of replicas that differ
+	Repaired  bool              `json:"repaired"`
+}
+
+type MoveRequest struct {
+	PathString string `json:"path"`        // File to move
+	ServerPort int    `json:"server_port"` // Client port of the storage server to move it to
+}
+
+type MoveReport struct {
+	Path          string `json:"path"`
+	From          int    `json:"from"` // Client port of the previous owner
+	To            int    `json:"to"`   // Client port of the new owner
+	Checksum      string `json:"checksum"`
+	SourceDeleted bool   `json:"source_deleted"`
+}
+
+type NamespaceEntry struct {
+	PathString string `json:"path"`
+	Directory  bool   `json:"directory"`
+	Locks      int    `json:"locks"`           // Number of locks held on the location
+	Exclusive  bool   `json:"exclusive"`       // The lock held is exclusive
+	Owner      int    `json:"owner,omitempty"` // Client port of the storage server owning a file
+}
+
+type NamespaceResponse struct {
+	Locations []NamespaceEntry `json:"locations"`
+}
+
+type ServersResponse struct {
+	Servers []StorageServer `json:"servers"`
+}
+
+type ForceUnlockResponse struct {
+	PathString string `json:"path"`
+	Released   int    `json:"released"` // Number of locks released
+	Exclusive  bool   `json:"exclusive"`
+}
+
+type RebalanceResponse struct {
+	Moves []MoveReport `json:"moves"`
+	Error string       `json:"error,omitempty"` // Why rebalancing stopped early
+}
+
+type ReadOnlyRequest struct {
+	ReadOnly bool `json:"read_only"`
+}
+
+type AccessExportRequest struct {
+	PathString string `json:"path"`    // Only export files below path, "/" by default
+	Window     string `json:"window"`  // Length of a window, "1h" by default
+	Windows    int    `json:"windows"` // Number of windows, ending now, 1 by default
+	Format     string `json:"format"`  // "json" (default) or "csv"
+}
+
+type AccessWindow struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Accesses int       `json:"accesses"`
+}
+
+type AccessSummary struct {
+	PathString string         `json:"path"`
+	Size       int            `json:"size"`     // Bytes, -1 if no storage server holds the file
+	Replicas   int            `json:"replicas"` // Storage servers holding the file, owner included
+	Accesses   int            `json:"accesses"` // Accesses over all windows
+	Windows    []AccessWindow `json:"windows"`
+}
+
+type AccessExportResponse struct {
+	Window string          `json:"window"`
+	Files  []AccessSummary `json:"files"`
+}
+
+type StorageSize struct {
+	Size int `json:"size"`
+}
+
+/* Response to /get_replicas */
+type ReplicasInfo struct {
+	Size     int               `json:"size"`
+	Replicas []ReplicaLocation `json:"replicas"` // The owner first
+}
+
+type ReplicaLocation struct {
+	ServerIP   string `json:"server_ip"`
+	ServerPort int    `json:"server_port"`
+}
+
+type ReplicaCheckResponse struct {
+	Files []ReplicaReport `json:"files"`
+}
+
+type RegistrationResponse struct {
+	Files []string `json:"files"`
+}
+
+type ListRequest struct {
+	PathString string `json:"path"`
+	Limit      int    `json:"limit"`              // Maximum number of names per page, 0 for all
+	Token      string `json:"continuation_token"` // Where the previous page ended
+	Pattern    string `json:"pattern"`            // Optional glob filter on names
+}
+
+type ListSuccessfulResponse struct {
+	Files     []string `json:"files"`
+	NextToken string   `json:"continuation_token,omitempty"`
+}
+
+type ExceptionResponse struct {
+	ExceptionType string `json:"exception_type"`
+	ExceptionInfo string `json:"exception_info"`
+}
+
+type ServiceResponse struct {
+	Success bool `json:"success"`
+}
+
+type StorageInfo struct {
+	ServerIP   string `json:"server_ip"`
+	ServerPort int    `json:"server_port"`
+	LeaseMs    int64  `json:"lease_ms"` // How long the client may cache this location
+}
+
+type JournalRequest struct {
+	Since  int64 `json:"since"`   // Last sequence number already seen
+	WaitMs int64 `json:"wait_ms"` // How long to wait for new entries, 0 to return at once
+}
+
+type JournalResponse struct {
+	Entries []JournalEntry `json:"entries"`
+}
+
+type WatchResponse struct {
+	PathString  string `json:"path"`
+	Invalidated bool   `json:"invalidated"`
+}
+
+type Lock struct {
+	PathString  string `json:"path"`
+	Exclusive   bool   `json:"exclusive"`
+	queue_index int
+}
+
+/* The next set of functions all deal with handling requests and running the server */
+
+/*
+Create a NamingServer listening on the given service and registration ports
+of localhost, with an empty directory tree.
+*/
+func NewNamingServer(servicePort string, registrationPort string) *NamingServer {
+	return &NamingServer{
+		servicePort:      "127.0.0.1:" + servicePort,
+		registrationPort: "127.0.0.1:" + registrationPort,
+		running:          false,
+		root:             &Location{name: "/", locks: []Lock{}, shard: &Shard{}},
+		shards:           map[string]*Shard{},
+		access_counts:    map[string]int{},
+		access_history:   map[string]map[int64]int{},
+		leases:           map[string][]Lease{},
+		watchers:         map[string][]chan bool{},
+		trash:            map[string]TrashEntry{},
+		journal:          []JournalEntry{},
+		journal_notify:   make(chan struct{}),
+		idempotent:       map[string]*IdempotentResponse{},
+		settings:         DefaultSettings(),
+	}
+}
+
+/*
+Start a NamingServer.
+*/
+func (serv *NamingServer) Start() {
+	// Return an error if the service is already started
+	if serv.running {
+		return
+	}
+
+	/* Otherwise, start the service. */
+
+	// Set the Service's running boolean to true
+	serv.running = true
+	// Create a listener
+	ln1, err := net.Listen(PROTOCOL, serv.servicePort)
+	if err != nil { // Catch error while creating listener
+		log.Println("Error listening on PORT", serv.servicePort, err)
+		return
+	}
+	// Set the server's serviceListener
+	serv.serviceListener = ln1
+
+	// Create a second listener
+	ln2, err := net.Listen(PROTOCOL, serv.registrationPort)
+	// Catch error while creating listener
+	if err != nil {
+		log.Println("Error listening on PORT", serv.registrationPort, err)
+		return
+	}
+	// Set the server's registrationListener
+	serv.registrationListener = ln2
+
+	// Start serving registration requests
+	go StartRegistration(serv)
+
+	// Purge the trash as locations in it expire
+	go PurgeExpiredTrash()
+
+	// Reload the settings from the config file on SIGHUP
+	go ReloadOnSignal()
+
+	// A standby follows the primary's journal until it is promoted
+	if serv.standby {
+		go TailJournal()
+	}
+
+	// Start serving client requests
+	StartService(serv)
+
+	return
+}
+
+/*
+Start the NamingServer's Registration Listener and server http requests.
+*/
+func StartRegistration(serv *NamingServer) {
+
+	/* Define a request handler function using HandleServiceConnection */
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		HandleRegistration(w, r)
+	}
+
+	fmt.Fprintf(&REGISTRATION_OUT, "Listening on %s for Registration Requests...\n", serv.registrationPort)
+
+	// Serve the HTTP request using the registration listener and handler function
+	err := http.Serve(serv.registrationListener, http.HandlerFunc(handler))
+	if err != nil {
+		fmt.Fprintln(&REGISTRATION_OUT, "ERROR:", err)
+	}
+}
+
+/* Returns true if a storage server in registry has the client or command port of storage_server */
+func IsRegistered(registry []StorageServer, storage_server StorageServer) bool {
+	for _, ss := range registry {
+		if ss.ClientPort == storage_server.ClientPort || ss.CommandPort == storage_server.CommandPort {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Handler function for http registration requests.
+
+DANGER NOTE: Registration assumes well behaved storage servers.
+Registration is best done when there is not heavy usage of the file system.
+*/
+func HandleRegistration(w http.ResponseWriter, r *http.Request) {
+	/* Storage servers register with the primary, a standby learns of them from its journal */
+	if IsStandby() {
+		RespondWithException(w, http.StatusServiceUnavailable, ILLEGAL_STATE, "this naming server is a standby.")
+		return
+	}
+
+	/* Check if valid Register command was sent */
+	if r.RequestURI == REGISTER {
+
+		/* Get the StorageServer object from the json request */
+		var storage_server StorageServer
+		if !DecodeRequest(w, r, &storage_server, "storage_ip", "client_port", "command_port", "files") {
+			return
+		}
 
-		// If this location already has a lock
-		if len(currentLocation.locks) > 0 {
-			mu.Unlock()
+		// If StorageServer is already registerd,
+		if IsRegistered(NAMING_SERVER.Registry(), storage_server) {
+			RespondWithException(w, http.StatusConflict, ILLEGAL_STATE, "This storage server is already registered.")
+			return
+		}
 
-			for {
-				// If there are no more locks on this location
-				mu.Lock()
-				if len(currentLocation.locks) == 0 {
-					topOfQueue := lock // Initialize top of queue variable
+		filesToDelete := []string{}
 
-					if len(currentLocation.lock_queue) > 0 {
-						topOfQueue = currentLocation.lock_queue[0]
-					}
+		// If files are not empty,
+		if len(storage_server.Files) > 0 {
+			// For each file path in the storage server's files
+			for _, filePath := range storage_server.Files {
 
-					// Only top of the queue ELs are allowed.
-					if topOfQueue.Exclusive && lock.queue_index != topOfQueue.queue_index {
-						mu.Unlock()
-						continue
-					}
+				filePath = strings.TrimLeft(filePath, "/") // trim first slash
+				locations := strings.Split(filePath, "/")  // split locations by delimiter
 
-					// If top of the queue is SL and EL is in queue
-					// Do not surpass EL's index
-					if !topOfQueue.Exclusive && len(currentLocation.exclusive_locks_waiting) > 0 && lock.queue_index >= currentLocation.exclusive_locks_waiting[0] {
-						mu.Unlock()
-						continue
-					}
+				// Check if filePath is a new path or not,
+				// the path may add a new top-level location
+				unlockNamespace := NAMING_SERVER.LockNamespace("/"+locations[0], true)
+				isValidPath := NAMING_SERVER.root.CheckNewPath(locations, 0)
+				unlockNamespace()
 
-					// Append new read or write lock
-					currentLocation.locks = append(currentLocation.locks, lock)
-					mu.Unlock()
-					*ret = true
-					return
+				if !isValidPath {
+					filePath := "/" + filePath
+					filesToDelete = append(filesToDelete, filePath)
+					fmt.Fprintf(&REGISTRATION_OUT, "Invalid Path: %s\n", filePath)
+				} else {
+					fmt.Fprint(&REGISTRATION_OUT, "NEW ROOT: ", NAMING_SERVER.root.subLocations, "\n")
 				}
+			}
 
-				// If there is an SL on this location
-				if len(currentLocation.locks) > 0 && !currentLocation.locks[0].Exclusive {
+		}
 
-					// Ensure they do no surpass index of EL in queue
-					if len(currentLocation.exclusive_locks_waiting) > 0 && lock.queue_index >= currentLocation.exclusive_locks_waiting[0] {
-						mu.Unlock()
-						continue
-					}
+		// The same storage server may have registered meanwhile
+		registry_mu.Lock()
+		if IsRegistered(NAMING_SERVER.registry, storage_server) {
+			registry_mu.Unlock()
+			RespondWithException(w, http.StatusConflict, ILLEGAL_STATE, "This storage server is already registered.")
+			return
+		}
+		NAMING_SERVER.registry = append(NAMING_SERVER.registry, storage_server) // Register storage server
+		registry_mu.Unlock()
+		Journal(JournalEntry{Op: REGISTER, Server: &storage_server})
 
-					// Append new read or write lock
-					currentLocation.locks = append(currentLocation.locks, lock)
-					mu.Unlock()
-					*ret = true
-					return
-				}
-				mu.Unlock()
+		/* Handle response */
+		w.Header().Set("Content-Type", "application/json")
+		response := RegistrationResponse{Files: filesToDelete}
+		fmt.Fprintf(&REGISTRATION_OUT, "Response to registration: %v\n", response)
+		json.NewEncoder(w).Encode(response)
+		return // Exit, 200, No files to delete
+	}
+
+	// Respond with 400 Bad Request, if the command is unknown.
+	http.Error(w, "Unknown Command", http.StatusBadRequest)
+}
+
+/*
+Handler function for the /get_replicas command.
+
+Like /get_storage, but returns every storage server holding an up-to-date
+replica of the file, so clients can read parts of a large file from
+several of them in parallel.
+*/
+func HandleGetReplicas(w http.ResponseWriter, r *http.Request) {
+	var path PathRequest
+	if !DecodeRequest(w, r, &path, "path") {
+		return
+	}
+
+	/* Handle an invalid pathString */
+	if !IsPathValid(path.PathString) {
+		RespondWithException(w, http.StatusNotFound, ILLEGAL_ARGUMENT, "the path is not valid.")
+		return
+	}
+
+	size, replicas := FileReplicas(path.PathString)
+	if len(replicas) == 0 {
+		fmt.Fprintf(&SERVICE_OUT, "No storage server hosts: %v\n", path)
+		RespondWithException(w, http.StatusNotFound, FILE_NOT_FOUND, "no storage server hosts the file.")
+		return
+	}
+
+	response := ReplicasInfo{Size: size, Replicas: []ReplicaLocation{}}
+	for _, ss := range replicas {
+		response.Replicas = append(response.Replicas, ReplicaLocation{ServerIP: ss.StorageIP, ServerPort: ss.ClientPort})
+	}
+	fmt.Fprintf(&SERVICE_OUT, "Replicas of %s: %v\n", path.PathString, response)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+/*
+Handler function for the /check_replicas admin command.
+
+Checks the replicas of the file at the requested path, or of every file
+below it when the path is a directory, and reports which ones diverge.
+*/
+func HandleCheckReplicas(w http.ResponseWriter, r *http.Request) {
+	var req ReplicaCheckRequest
+	if !DecodeRequest(w, r, &req, "path") {
+		return
+	}
+
+	/* Handle an invalid pathString */
+	if !IsPathValid(req.PathString) {
+		RespondWithException(w, http.StatusNotFound, ILLEGAL_ARGUMENT, "the path is not valid.")
+		return
+	}
+
+	/* Find every registered file at or below the path */
+	prefix := strings.TrimRight(req.PathString, "/") + "/"
+	seen := map[string]bool{}
+	files := []string{}
+	for _, ss := range NAMING_SERVER.Registry() {
+		for _, file := range ss.Files {
+			if (file == req.PathString || strings.HasPrefix(file, prefix)) && !seen[file] {
+				seen[file] = true
+				files = append(files, file)
 			}
 		}
+	}
+	sort.Strings(files)
 
-		// Append new read or write lock
-		currentLocation.locks = append(currentLocation.locks, lock)
-		mu.Unlock()
-		*ret = true
+	if len(files) == 0 {
+		RespondWithException(w, http.StatusNotFound, FILE_NOT_FOUND, "no registered file at or below the path.")
+		return
+	}
+
+	response := ReplicaCheckResponse{Files: []ReplicaReport{}}
+	for _, file := range files {
+		report := CheckReplicas(file, req.Repair)
+		fmt.Fprintf(&SERVICE_OUT, "Replicas of %s: %v\n", file, report)
+		response.Files = append(response.Files, report)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+/*
+Handler function for the /storage_move admin command.
+Moves the ownership of a file to another storage server, e.g. to
+rebalance storage servers or to empty one before decommissioning it.
+*/
+func HandleStorageMove(w http.ResponseWriter, r *http.Request) {
+	var req MoveRequest
+	if !DecodeRequest(w, r, &req, "path", "server_port") {
+		return
+	}
+
+	/* Handle an invalid pathString */
+	if !IsPathValid(req.PathString) {
+		RespondWithException(w, http.StatusNotFound, ILLEGAL_ARGUMENT, "the path is not valid.")
+		return
+	}
+
+	locationExists := false
+	unlockNamespace := NAMING_SERVER.LockNamespace(req.PathString, false)
+	NAMING_SERVER.root.LocationExists(SplitPath(req.PathString), &locationExists)
+	unlockNamespace()
+	if !locationExists {
+		RespondWithException(w, http.StatusNotFound, FILE_NOT_FOUND, "the file does not exist.")
+		return
+	}
+
+	/* Find the storage server to move the file to */
+	var dest *StorageServer
+	for _, ss := range NAMING_SERVER.Registry() {
+		if ss.ClientPort == req.ServerPort {
+			ss := ss
+			dest = &ss
+		}
+	}
+	if dest == nil {
+		RespondWithException(w, http.StatusNotFound, ILLEGAL_ARGUMENT, "no storage server is registered with that port.")
+		return
+	}
+
+	report, err := MoveFile(req.PathString, *dest)
+	if err != nil {
+		fmt.Fprintf(&SERVICE_OUT, "Could not move %s: %v\n", req.PathString, err)
+		RespondWithException(w, http.StatusConflict, ILLEGAL_STATE, err.Error()+".")
 		return
 	}
+	fmt.Fprintf(&SERVICE_OUT, "Moved %v\n", report)
 
-	nextLocation := locations[idx]
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
 
-	// For each sublocation
-	for _, sub := range currentLocation.subLocations {
+/* Return true if the DFS is in read-only mode */
+func IsReadOnly() bool {
+	read_only_mu.Lock()
+	defer read_only_mu.Unlock()
+	return NAMING_SERVER.read_only
+}
 
-		// Find the sublocation with the same name as next location
-		if nextLocation == sub.name {
+/*
+Release every lock clients hold on the location at path, as if they had
+unlocked it, so clients waiting for it can go ahead. Returns the number
+of locks released and whether the lock was exclusive.
+*/
+func ForceUnlock(path string) (int, bool) {
+	unlockNamespace := NAMING_SERVER.LockNamespace(path, false)
+	location := NAMING_SERVER.root.FindLocation(SplitPath(path))
+	unlockNamespace()
+	if location == nil {
+		return 0, false
+	}
 
-			// If current location is exclusively locked,
-			// wait until it becomes free
-			mu.Lock()
-			if len(currentLocation.locks) > 0 && len(currentLocation.locks) == 1 && currentLocation.locks[0].Exclusive {
-				mu.Unlock()
+	/* Only locks taken on the location itself, not on locations below it */
+	held := []Lock{}
+	location.shard.locks.Lock()
+	for _, lock := range location.locks {
+		if lock.PathString == path {
+			held = append(held, lock)
+		}
+	}
+	location.shard.locks.Unlock()
 
-				fmt.Fprintf(&SERVICE_OUT, "Waiting for %v to be free...", currentLocation.name)
+	released := 0
+	exclusive := false
+	for _, lock := range held {
+		successfullyUnlocked := false
+		NAMING_SERVER.root.UnlockLocation(lock, 0, &successfullyUnlocked)
+		if !successfullyUnlocked {
+			break
+		}
+		released++
+		exclusive = exclusive || lock.Exclusive
+	}
 
-				for {
-					mu.Lock()
-					if len(currentLocation.locks) == 0 {
-						mu.Unlock()
-						break
-					}
-					mu.Unlock()
-				}
+	// The client may have written, and must not write again with its token
+	if exclusive {
+		SendDelete(path, false)
+		InvalidateLeases(path)
+		SendFence(path, IssueFencingToken())
+	}
 
-				sl := Lock{PathString: currentLocation.name, Exclusive: false}
+	return released, exclusive
+}
 
-				currentLocation.num_locks++                // Increment the number of locks
-				sl.queue_index = currentLocation.num_locks // Set the lock's queue index
+/*
+Returns true if the location at path is locked for exclusive access by a
+lock taken on it, i.e. a client may have written to it.
+*/
+func HoldsExclusiveLock(path string) bool {
+	unlockNamespace := NAMING_SERVER.LockNamespace(path, false)
+	location := NAMING_SERVER.root.FindLocation(SplitPath(path))
+	unlockNamespace()
+	if location == nil {
+		return false
+	}
 
-				/* Append lock to queue */
-				currentLocation.lock_queue = append(currentLocation.lock_queue, sl)
-				fmt.Fprintf(&SERVICE_OUT, "\nAdded lock%v to Queue\n\n", sl)
+	location.shard.locks.Lock()
+	defer location.shard.locks.Unlock()
+	return len(location.locks) == 1 && location.locks[0].Exclusive && location.locks[0].PathString == path
+}
 
-				/* Actually lock this location */
-				currentLocation.locks = append(currentLocation.locks, sl) // Locking all locations along the path
-				fmt.Fprintf(&SERVICE_OUT, "Midway location %v has New Read Lock appended\n", currentLocation.name)
+/*
+Return the storage servers other than owner, in the order a file should
+be copied to them: one server of every zone not holding the file yet
+first, then the rest. Also returns the number of zones there are.
+*/
+func ReplicaTargets(owner StorageServer) ([]StorageServer, int) {
+	covered := map[string]bool{owner.Zone: true}
+	newZones, rest := []StorageServer{}, []StorageServer{}
 
-				/* Recurse */
-				sub.LockLocation(lock, idx+1, ret)
-				return // return after recursing
-			}
-			mu.Unlock()
+	for _, ss := range NAMING_SERVER.Registry() {
+		if ss.CommandPort == owner.CommandPort {
+			continue
+		}
+		if !covered[ss.Zone] {
+			covered[ss.Zone] = true
+			newZones = append(newZones, ss)
+		} else {
+			rest = append(rest, ss)
+		}
+	}
 
-			/*
-				API:
-				"When a client requests that any object be locked for any kind of access,
-				all objects along the path to that object, including the root directory,
-				must be locked for shared access."
-			*/
+	return append(newZones, rest...), len(covered)
+}
 
-			/* Create shared lock */
-			sl := Lock{PathString: currentLocation.name, Exclusive: false}
+/*
+Move files from the storage server owning the most files to the one
+owning the fewest in the same zone, until no two storage servers of a
+zone differ by more than one file. Files never leave their zone, so
+rebalancing does not undo their placement.
+Returns the moves made, and why it stopped early if it did.
+*/
+func Rebalance() ([]MoveReport, error) {
+	moves := []MoveReport{}
+
+	for {
+		zones := map[string][]StorageServer{}
+		names := []string{}
+		for _, ss := range NAMING_SERVER.Registry() {
+			if _, ok := zones[ss.Zone]; !ok {
+				names = append(names, ss.Zone)
+			}
+			zones[ss.Zone] = append(zones[ss.Zone], ss)
+		}
+		sort.Strings(names)
+
+		moved := false
+		for _, zone := range names {
+			most, fewest := zones[zone][0], zones[zone][0]
+			for _, ss := range zones[zone] {
+				if len(ss.Files) > len(most.Files) {
+					most = ss
+				}
+				if len(ss.Files) < len(fewest.Files) {
+					fewest = ss
+				}
+			}
+			if len(most.Files)-len(fewest.Files) <= 1 {
+				continue
+			}
 
-			currentLocation.num_locks++                // Increment the number of locks
-			sl.queue_index = currentLocation.num_locks // Set the lock's queue index
+			files := append([]string{}, most.Files...)
+			sort.Strings(files)
 
-			/* Append lock to queue */
-			currentLocation.lock_queue = append(currentLocation.lock_queue, sl)
-			fmt.Fprintf(&SERVICE_OUT, "\nAdded lock%v to Queue\n\n", sl)
+			report, err := MoveFile(files[0], fewest)
+			if err != nil {
+				return moves, fmt.Errorf("could not move %s: %v", files[0], err)
+			}
+			fmt.Fprintf(&SERVICE_OUT, "Rebalanced %v\n", report)
+			moves = append(moves, report)
 
-			/* Actually lock this location */
-			currentLocation.locks = append(currentLocation.locks, sl) // Locking all locations along the path
-			fmt.Fprintf(&SERVICE_OUT, "Midway location %v has New Read Lock appended\n", currentLocation.name)
+			// The registry changed, so group the storage servers again
+			moved = true
+			break
+		}
 
-			/* Recurse */
-			sub.LockLocation(lock, idx+1, ret)
-			return // return after recursing
+		if !moved {
+			return moves, nil
 		}
 	}
 }
 
 /*
-This function starts at root, recursively navigates to the path to be unlocked,
-then unlocks it if it is the correct unlock. Assumes location exists.
+Handler function for the admin commands used by dfsadmin:
+/admin_servers lists the registered storage servers and their files,
+/admin_namespace dumps the directory tree below a path,
+/admin_unlock forcibly releases the locks on a location,
+/admin_rebalance spreads files evenly across storage servers,
+/admin_read_only turns read-only mode on or off and
+/admin_access_export summarizes the accesses to files over time windows.
+*/
+func HandleAdminCommand(w http.ResponseWriter, r *http.Request) {
+	var response interface{}
+
+	switch r.RequestURI {
+	case ADMIN_SERVERS:
+		servers := NAMING_SERVER.Registry()
+		for _, ss := range servers {
+			sort.Strings(ss.Files)
+		}
+		response = ServersResponse{Servers: servers}
 
-Along the way, it removes a single read lock per midway location, assuming it finds
-a read lock there.
+	case ADMIN_NAMESPACE, ADMIN_UNLOCK:
+		var path PathRequest
+		if !DecodeRequest(w, r, &path, "path") {
+			return
+		}
 
-ret is set to true if this function is successful.
-*/
-func (currentLocation *Location) UnlockLocation(unlock Lock, idx int, ret *bool) {
+		/* Handle an invalid pathString */
+		if !IsPathValid(path.PathString) {
+			RespondWithException(w, http.StatusNotFound, ILLEGAL_ARGUMENT, "the path is not valid.")
+			return
+		}
 
-	// Split path string into locations, remove the empty element at beginning of arr
-	locations := strings.Split(unlock.PathString, "/")[1:]
+		locationExists := false
+		unlockNamespace := NAMING_SERVER.LockNamespace(path.PathString, false)
+		if path.PathString != "/" {
+			NAMING_SERVER.root.LocationExists(SplitPath(path.PathString), &locationExists)
+		}
+		unlockNamespace()
+		if !locationExists && path.PathString != "/" {
+			RespondWithException(w, http.StatusNotFound, FILE_NOT_FOUND, "the file/directory does not exist.")
+			return
+		}
 
-	/* Case 1:
-	-> pathString is root or reached the final location to unlock
-	*/
-	if currentLocation.name == unlock.PathString || currentLocation.name == locations[len(locations)-1] {
+		if r.RequestURI == ADMIN_UNLOCK {
+			released, exclusive := ForceUnlock(path.PathString)
+			fmt.Fprintf(&SERVICE_OUT, "Forcibly released %d locks on %s\n", released, path.PathString)
+			response = ForceUnlockResponse{PathString: path.PathString, Released: released, Exclusive: exclusive}
+			break
+		}
 
-		/* Handle Non-Exclusive unlocks first */
-		// Read unlock and final location has One or multiple read locks
-		mu.Lock()
-		if !unlock.Exclusive && len(currentLocation.locks) >= 1 && !currentLocation.locks[0].Exclusive {
+		owners := map[string]int{}
+		for _, ss := range NAMING_SERVER.Registry() {
+			for _, file := range ss.Files {
+				owners[file] = ss.ClientPort
+			}
+		}
 
-			currentLocation.Pop(unlock) // Pop thhis lock off the queue
+		locations := []NamespaceEntry{}
+		defer NAMING_SERVER.LockWholeNamespace()()
+		location := NAMING_SERVER.root.FindLocation(SplitPath(path.PathString))
+		location.DumpLocations(path.PathString, owners, &locations)
+		response = NamespaceResponse{Locations: locations}
 
-			currentLocation.locks = currentLocation.locks[1:] // Unlock this location
-			mu.Unlock()
-			*ret = true
+	case ADMIN_REBALANCE:
+		moves, err := Rebalance()
+		result := RebalanceResponse{Moves: moves}
+		if err != nil {
+			fmt.Fprintf(&SERVICE_OUT, "Rebalancing stopped: %v\n", err)
+			result.Error = err.Error()
+		}
+		response = result
+
+	case ADMIN_READ_ONLY:
+		var req ReadOnlyRequest
+		if !DecodeRequest(w, r, &req, "read_only") {
 			return
 		}
 
-		/* Handle Exclusive unlocks */
-		// Exclusive unlock and final location has one exclusive lock
-		if unlock.Exclusive && len(currentLocation.locks) == 1 && currentLocation.locks[0].Exclusive {
-			currentLocation.Pop(unlock)                       // Pop this lock off the queue
-			currentLocation.locks = currentLocation.locks[1:] // Unlock location
-			mu.Unlock()
-			*ret = true
+		read_only_mu.Lock()
+		NAMING_SERVER.read_only = req.ReadOnly
+		read_only_mu.Unlock()
+
+		fmt.Fprintf(&SERVICE_OUT, "Read-only mode: %v\n", req.ReadOnly)
+		response = req
+
+	case ADMIN_ACCESS_EXPORT:
+		req := AccessExportRequest{PathString: "/", Window: "1h", Windows: 1, Format: "json"}
+		if !DecodeRequest(w, r, &req) {
 			return
 		}
-		mu.Unlock()
 
-		return // Exit
+		window, err := time.ParseDuration(req.Window)
+		if err != nil || window < ACCESS_BUCKET || req.Windows < 1 || time.Duration(req.Windows)*window > ACCESS_HISTORY {
+			RespondWithException(w, http.StatusBadRequest, ILLEGAL_ARGUMENT,
+				fmt.Sprintf("windows must be at least %v long and span at most %v.", ACCESS_BUCKET, ACCESS_HISTORY))
+			return
+		}
+		if req.Format != "json" && req.Format != "csv" {
+			RespondWithException(w, http.StatusBadRequest, ILLEGAL_ARGUMENT, "the format must be json or csv.")
+			return
+		}
+		if !IsPathValid(req.PathString) {
+			RespondWithException(w, http.StatusNotFound, ILLEGAL_ARGUMENT, "the path is not valid.")
+			return
+		}
+
+		export := ExportAccesses(req.PathString, window, req.Windows)
+		if req.Format == "csv" {
+			w.Header().Set("Content-Type", "text/csv")
+			if err := WriteAccessCSV(w, export); err != nil {
+				fmt.Fprintf(&SERVICE_OUT, "Error writing CSV: %v\n", err)
+			}
+			return
+		}
+		response = export
 	}
 
-	/* Case 2:
-	-> Search for next location, remove one lock from current location
-	*/
-	nextLocation := locations[idx]
-	// For each sublocation
-	for _, sub := range currentLocation.subLocations {
-		// Search and find the next sub location
-		if sub.name == nextLocation {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
 
-			/* Handle current location before recursing on sub location. */
+/*
+Handler function for the /restore and /purge trash commands.
+Both take the path of a location in /.trash; purging /.trash itself
+empties the whole trash.
+*/
+func HandleTrashCommand(w http.ResponseWriter, r *http.Request) {
+	var path PathRequest
+	if !DecodeRequest(w, r, &path, "path") {
+		return
+	}
 
-			// If current location has a read lock on it, remove it
-			mu.Lock()
-			if len(currentLocation.locks) >= 1 && !currentLocation.locks[0].Exclusive {
-				currentLocation.Pop(currentLocation.locks[0])     // XD Pop lock
-				currentLocation.locks = currentLocation.locks[1:] // Remove one read lock from this location
-				mu.Unlock()
-				/* Recurse to next sublocation */
-				sub.UnlockLocation(unlock, idx+1, ret)
-				return // return after recursing
-			}
-			mu.Unlock()
+	/* Only paths in the trash can be restored or purged */
+	if !IsPathValid(path.PathString) || !strings.HasPrefix(path.PathString, TRASH_PATH) {
+		RespondWithException(w, http.StatusNotFound, ILLEGAL_ARGUMENT, "the path is not in "+TRASH_PATH+".")
+		return
+	}
 
-			/* Recurse to next sublocation */
-			sub.UnlockLocation(unlock, idx+1, ret)
-			return // return after recursing
+	trash_mu.Lock()
+	_, inTrash := NAMING_SERVER.trash[path.PathString]
+	trash_mu.Unlock()
+
+	if !inTrash && !(r.RequestURI == PURGE && path.PathString == TRASH_PATH) {
+		RespondWithException(w, http.StatusNotFound, FILE_NOT_FOUND, "the location is not in "+TRASH_PATH+".")
+		return
+	}
+
+	success := false
+	if r.RequestURI == RESTORE {
+		success = RestoreFromTrash(path.PathString)
+		fmt.Fprintf(&SERVICE_OUT, "Restored %s: %v\n", path.PathString, success)
+		if success {
+			Journal(JournalEntry{Op: RESTORE, Path: path.PathString})
 		}
+	} else {
+		purged := PurgeTrash(func(trashPath string, entry TrashEntry) bool {
+			return path.PathString == TRASH_PATH || trashPath == path.PathString
+		})
+		fmt.Fprintf(&SERVICE_OUT, "Purged %v\n", purged)
+		success = true
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := ServiceResponse{Success: success}
+	json.NewEncoder(w).Encode(response)
 }
 
-/* JSON structs according to API */
+/*
+The response to a request that carried an Idempotency-Key. A retry with the
+same key gets this response again instead of repeating the command, so a
+create retried after a timeout does not answer false and a delete is not
+sent to the storage servers twice.
+*/
+type IdempotentResponse struct {
+	command string // Command and body of the original request,
+	body    string // a retry must match them
+	expires time.Time
 
-type PathRequest struct {
-	PathString string `json:"path"`
+	status int
+	header http.Header
+	data   []byte
+
+	done chan struct{} // Closed once the original request has been answered
 }
 
-type StorageServer struct {
-	StorageIP   string   `json:"storage_ip"`
-	ClientPort  int      `json:"client_port"`
-	CommandPort int      `json:"command_port"`
-	Files       []string `json:"files"`
+/* Records the response written by a handler, while passing it through */
+type RecordingWriter struct {
+	http.ResponseWriter
+	status int
+	data   bytes.Buffer
 }
 
-type StorageCopy struct {
-	Path       string `json:"path"`
-	ServerIP   string `json:"server_ip"`
-	ServerPort int    `json:"server_port"`
+func (w *RecordingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
 }
 
-type RegistrationResponse struct {
-	Files []string `json:"files"`
+func (w *RecordingWriter) Write(data []byte) (int, error) {
+	w.data.Write(data)
+	return w.ResponseWriter.Write(data)
 }
 
-type ListSuccessfulResponse struct {
-	Files []string `json:"files"`
+/* Commands that change the namespace, which are deduplicated by Idempotency-Key */
+func IsMutatingCommand(command string) bool {
+	return command == CREATE_FILE || command == CREATE_DIRECTORY || command == DELETE ||
+		command == RESTORE || command == PURGE
 }
 
-type ExceptionResponse struct {
-	ExceptionType string `json:"exception_type"`
-	ExceptionInfo string `json:"exception_info"`
+/*
+Handle a mutating command carrying an Idempotency-Key. The first request
+with a key runs the command, retries wait for it and get the same response.
+Reusing a key for a different request is an IllegalStateException.
+*/
+func HandleIdempotentCommand(w http.ResponseWriter, r *http.Request, key string) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		fmt.Fprintf(&SERVICE_OUT, "ERROR: %v\n", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest) // Could not read the command
+		return
+	}
+
+	idempotency_mu.Lock()
+	// Forget responses too old to be retried
+	now := time.Now()
+	for k, response := range NAMING_SERVER.idempotent {
+		if now.After(response.expires) {
+			delete(NAMING_SERVER.idempotent, k)
+		}
+	}
+
+	previous, retried := NAMING_SERVER.idempotent[key]
+	if !retried {
+		NAMING_SERVER.idempotent[key] = &IdempotentResponse{
+			command: r.RequestURI,
+			body:    string(body),
+			expires: now.Add(IDEMPOTENCY_TTL),
+			done:    make(chan struct{}),
+		}
+	}
+	idempotency_mu.Unlock()
+
+	if retried {
+		if previous.command != r.RequestURI || previous.body != string(body) {
+			RespondWithException(w, http.StatusConflict, ILLEGAL_STATE, "the idempotency key was used for a different request.")
+			return
+		}
+
+		<-previous.done
+		fmt.Fprintf(&SERVICE_OUT, "Repeating response to %s %s\n", IDEMPOTENCY_KEY_HEADER, key)
+		for name, values := range previous.header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(previous.status)
+		w.Write(previous.data)
+		return
+	}
+
+	// Run the command once, without the key so it is not deduplicated again
+	r.Header.Del(IDEMPOTENCY_KEY_HEADER)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	recorder := &RecordingWriter{ResponseWriter: w, status: http.StatusOK}
+	HandleServiceCommand(recorder, r)
+
+	idempotency_mu.Lock()
+	response := NAMING_SERVER.idempotent[key]
+	idempotency_mu.Unlock()
+
+	response.status = recorder.status
+	response.header = w.Header().Clone()
+	response.data = recorder.data.Bytes()
+	close(response.done)
+
+	// An exception changed nothing, e.g. no storage was available, so a retry runs the command again
+	if response.status >= http.StatusBadRequest {
+		idempotency_mu.Lock()
+		delete(NAMING_SERVER.idempotent, key)
+		idempotency_mu.Unlock()
+	}
 }
 
-type ServiceResponse struct {
-	Success bool `json:"success"`
+/*
+Settings that can be changed while the Naming Server runs, by editing
+its config file and sending it SIGHUP or /reload.
+*/
+type Settings struct {
+	AccessThreshold   int           // Accesses that make a file hot, so it is copied to other storage servers
+	ReplicationFactor int           // Most storage servers a hot file is copied to, owner included; 0 for all
+	LeaseDuration     time.Duration // How long a client may cache a /get_storage response
+	TrashRetention    time.Duration // How long deleted locations stay in /.trash; 0 disables trash mode
+	MinZones          int           // Zones the copies of a hot file must span, owner's included
+	CopyFanout        int           // Copies each holder of a hot file serves per wave; 0 for all from the owner
+	WriteReplicas     int           // Replicas, owner included, that must hold a write before its lock is released; 0 for the owner alone
+	WriteAckTimeout   time.Duration // How long the release of an exclusive lock waits for WriteReplicas
 }
 
-type StorageInfo struct {
-	ServerIP   string `json:"server_ip"`
-	ServerPort int    `json:"server_port"`
+/* Settings as written in the config file and reported by /reload. Left out fields keep their startup value. */
+type SettingsFile struct {
+	AccessThreshold   int    `json:"access_threshold,omitempty"`
+	ReplicationFactor int    `json:"replication_factor,omitempty"`
+	LeaseDuration     string `json:"lease_duration,omitempty"`
+	TrashRetention    string `json:"trash_retention,omitempty"`
+	MinZones          int    `json:"min_zones,omitempty"`
+	CopyFanout        int    `json:"copy_fanout,omitempty"`
+	WriteReplicas     int    `json:"write_replicas,omitempty"`
+	WriteAckTimeout   string `json:"write_ack_timeout,omitempty"`
 }
 
-type Lock struct {
-	PathString  string `json:"path"`
-	Exclusive   bool   `json:"exclusive"`
-	queue_index int
+func DefaultSettings() Settings {
+	return Settings{
+		AccessThreshold: ACCESS_THRESHOLD,
+		LeaseDuration:   LEASE_DURATION,
+		WriteAckTimeout: WRITE_ACK_TIMEOUT,
+	}
 }
 
-/* The next set of functions all deal with handling requests and running the server */
+/* Return a copy of the settings in effect */
+func CurrentSettings() Settings {
+	settings_mu.Lock()
+	defer settings_mu.Unlock()
+	return NAMING_SERVER.settings
+}
 
-/*
-Start a NamingServer.
-*/
-func (serv *NamingServer) Start() {
-	// Return an error if the service is already started
-	if serv.running {
-		return
+/* Return settings, overridden by the fields set in file */
+func (file SettingsFile) Apply(settings Settings) (Settings, error) {
+	if file.AccessThreshold < 0 || file.ReplicationFactor < 0 || file.MinZones < 0 || file.CopyFanout < 0 || file.WriteReplicas < 0 {
+		return settings, errors.New("access_threshold, replication_factor, min_zones, copy_fanout and write_replicas cannot be negative")
+	}
+	if file.AccessThreshold > 0 {
+		settings.AccessThreshold = file.AccessThreshold
+	}
+	if file.ReplicationFactor > 0 {
+		settings.ReplicationFactor = file.ReplicationFactor
+	}
+	if file.MinZones > 0 {
+		settings.MinZones = file.MinZones
+	}
+	if file.CopyFanout > 0 {
+		settings.CopyFanout = file.CopyFanout
+	}
+	if file.WriteReplicas > 0 {
+		settings.WriteReplicas = file.WriteReplicas
 	}
 
-	/* Otherwise, start the service. */
-
-	// Set the Service's running boolean to true
-	serv.running = true
-	// Create a listener
-	ln1, err := net.Listen(PROTOCOL, serv.servicePort)
-	if err != nil { // Catch error while creating listener
-		log.Println("Error listening on PORT", serv.servicePort, err)
-		return
+	if file.LeaseDuration != "" {
+		lease, err := time.ParseDuration(file.LeaseDuration)
+		if err != nil || lease <= 0 {
+			return settings, fmt.Errorf("invalid lease_duration %q", file.LeaseDuration)
+		}
+		settings.LeaseDuration = lease
 	}
-	// Set the server's serviceListener
-	serv.serviceListener = ln1
 
-	// Create a second listener
-	ln2, err := net.Listen(PROTOCOL, serv.registrationPort)
-	// Catch error while creating listener
-	if err != nil {
-		log.Println("Error listening on PORT", serv.registrationPort, err)
-		return
+	if file.WriteAckTimeout != "" {
+		timeout, err := time.ParseDuration(file.WriteAckTimeout)
+		if err != nil || timeout <= 0 {
+			return settings, fmt.Errorf("invalid write_ack_timeout %q", file.WriteAckTimeout)
+		}
+		settings.WriteAckTimeout = timeout
 	}
-	// Set the server's registrationListener
-	serv.registrationListener = ln2
 
-	// Start serving registration requests
-	go StartRegistration(serv)
+	if file.TrashRetention != "" {
+		retention, err := time.ParseDuration(file.TrashRetention)
+		if err != nil || retention < 0 {
+			return settings, fmt.Errorf("invalid trash_retention %q", file.TrashRetention)
+		}
+		settings.TrashRetention = retention
+	}
 
-	// Start serving client requests
-	StartService(serv)
+	return settings, nil
+}
 
-	return
+/* Format settings the way they are written in the config file */
+func (settings Settings) File() SettingsFile {
+	file := SettingsFile{
+		AccessThreshold:   settings.AccessThreshold,
+		ReplicationFactor: settings.ReplicationFactor,
+		LeaseDuration:     settings.LeaseDuration.String(),
+		MinZones:          settings.MinZones,
+		CopyFanout:        settings.CopyFanout,
+		WriteReplicas:     settings.WriteReplicas,
+		WriteAckTimeout:   settings.WriteAckTimeout.String(),
+	}
+	if settings.TrashRetention > 0 {
+		file.TrashRetention = settings.TrashRetention.String()
+	}
+	return file
 }
 
 /*
-Start the NamingServer's Registration Listener and server http requests.
+Read the config file and put its settings into effect. The namespace and
+everything else in memory are left alone. A broken config file changes nothing.
+Returns the settings in effect.
 */
-func StartRegistration(serv *NamingServer) {
+func ReloadSettings() (Settings, error) {
+	if NAMING_SERVER.config_file == "" {
+		return CurrentSettings(), nil
+	}
 
-	/* Define a request handler function using HandleServiceConnection */
-	handler := func(w http.ResponseWriter, r *http.Request) {
-		HandleRegistration(w, r)
+	data, err := os.ReadFile(NAMING_SERVER.config_file)
+	if err != nil {
+		return CurrentSettings(), err
 	}
 
-	fmt.Fprintf(&REGISTRATION_OUT, "Listening on "+serv.registrationPort+" for Registration Requests...\n")
+	var file SettingsFile
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return CurrentSettings(), err
+	}
 
-	// Serve the HTTP request using the registration listener and handler function
-	err := http.Serve(serv.registrationListener, http.HandlerFunc(handler))
+	settings, err := file.Apply(NAMING_SERVER.base_settings)
 	if err != nil {
-		fmt.Fprintln(&REGISTRATION_OUT, "ERROR:", err)
+		return CurrentSettings(), err
 	}
-}
 
-/*
-Handler function for http registration requests.
+	settings_mu.Lock()
+	NAMING_SERVER.settings = settings
+	settings_mu.Unlock()
 
-DANGER NOTE: Registration assumes well behaved storage servers.
-Registration is best done when there is not heavy usage of the file system.
-*/
-func HandleRegistration(w http.ResponseWriter, r *http.Request) {
-	/* Check if valid Register command was sent */
-	if r.RequestURI == REGISTER {
+	fmt.Fprintf(&SERVICE_OUT, "Reloaded settings from %s: %+v\n", NAMING_SERVER.config_file, settings)
+	return settings, nil
+}
 
-		/* Get the StorageServer object from the json request */
-		var storage_server StorageServer
-		err := json.NewDecoder(r.Body).Decode(&storage_server) // Decode the request's body
-		if err != nil {
-			fmt.Fprintf(&REGISTRATION_OUT, "ERROR: %v\n", err)
-		}
+/* Reload the settings every time the process receives SIGHUP */
+func ReloadOnSignal() {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
 
-		// For each currently registered server
-		for _, ss := range NAMING_SERVER.registry {
-			// If StorageServer is already registerd,
-			if ss.ClientPort == storage_server.ClientPort || ss.CommandPort == storage_server.CommandPort {
-				w.Header().Set("Content-Type", "application/json")
-				// TODO: Make sure code is 409
-				w.WriteHeader(http.StatusConflict)
-				// Send a bad registration response, in accordance with API.
-				response := ExceptionResponse{
-					ExceptionType: "IllegalStateException",
-					ExceptionInfo: "This storage server is already registered.",
-				}
-				// fmt.Fprintf(&REGISTRATION_OUT, "409 Conflict %v\n", response)
-				json.NewEncoder(w).Encode(response)
-				return
-			}
+	for range hangup {
+		if _, err := ReloadSettings(); err != nil {
+			fmt.Fprintf(&SERVICE_OUT, "Could not reload settings: %v\n", err)
 		}
+	}
+}
 
-		filesToDelete := []string{}
-
-		// If files are not empty,
-		if len(storage_server.Files) > 0 {
-			// For each file path in the storage server's files
-			for _, filePath := range storage_server.Files {
-
-				filePath = strings.TrimLeft(filePath, "/") // trim first slash
-				locations := strings.Split(filePath, "/")  // split locations by delimiter
-
-				// Check if filePath is a new path or not
-				// isNewPath := NAMING_SERVER.root.CheckNewPath(locations, 0)
-				isValidPath := NAMING_SERVER.root.CheckNewPath(locations, 0)
+/*
+Handler function for the /reload admin command.
+Reloads the config file and responds with the settings in effect.
+*/
+func HandleReload(w http.ResponseWriter, r *http.Request) {
+	settings, err := ReloadSettings()
+	if err != nil {
+		RespondWithException(w, http.StatusConflict, ILLEGAL_STATE, fmt.Sprintf("could not reload settings: %v", err))
+		return
+	}
 
-				if !isValidPath {
-					filePath := "/" + filePath
-					filesToDelete = append(filesToDelete, filePath)
-					fmt.Fprintf(&REGISTRATION_OUT, "Invalid Path: %s\n", filePath)
-				} else {
-					fmt.Fprint(&REGISTRATION_OUT, "NEW ROOT: ", NAMING_SERVER.root.subLocations, "\n")
-				}
-			}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings.File())
+}
 
-		}
+/*
+Handler function for the /journal and /promote commands of warm standby.
+/journal streams the metadata journal to a standby, /promote turns a
+standby into a primary that serves clients and storage servers.
+*/
+func HandleStandbyCommand(w http.ResponseWriter, r *http.Request) {
+	if r.RequestURI == PROMOTE {
+		standby_mu.Lock()
+		promoted := NAMING_SERVER.standby
+		NAMING_SERVER.standby = false
+		standby_mu.Unlock()
+
+		fmt.Fprintf(&SERVICE_OUT, "Promoted to primary: %v\n", promoted)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ServiceResponse{Success: promoted})
+		return
+	}
 
-		NAMING_SERVER.registry = append(NAMING_SERVER.registry, storage_server) // Register storage server
+	var req JournalRequest
+	if !DecodeRequest(w, r, &req, "since") {
+		return
+	}
 
-		/* Handle response */
-		w.Header().Set("Content-Type", "application/json")
-		response := RegistrationResponse{Files: filesToDelete}
-		fmt.Fprintf(&REGISTRATION_OUT, "Response to registration: %v\n", response)
-		json.NewEncoder(w).Encode(response)
-		return // Exit, 200, No files to delete
+	wait := time.Duration(req.WaitMs) * time.Millisecond
+	if wait > JOURNAL_MAX_WAIT {
+		wait = JOURNAL_MAX_WAIT
 	}
 
-	// Respond with 400 Bad Request, if the command is unknown.
-	http.Error(w, "Unknown Command", http.StatusBadRequest)
+	w.Header().Set("Content-Type", "application/json")
+	response := JournalResponse{Entries: ReadJournal(req.Since, wait)}
+	json.NewEncoder(w).Encode(response)
 }
 
 /*
@@ -1071,7 +3567,7 @@ func StartService(serv *NamingServer) {
 		HandleServiceCommand(w, r)
 	}
 
-	fmt.Fprintf(&SERVICE_OUT, "Listening on "+serv.servicePort+" for Service Requests...\n")
+	fmt.Fprintf(&SERVICE_OUT, "Listening on %s for Service Requests...\n", serv.servicePort)
 
 	// Serve the HTTP request using the service listener and handler function
 	err := http.Serve(serv.serviceListener, http.HandlerFunc(handler))
@@ -1088,13 +3584,39 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Fprintf(&SERVICE_OUT, "\n---------------Received %v command---------------\n", r.RequestURI)
 
+	// Reloading the settings is an admin command, also served by a standby
+	if r.RequestURI == RELOAD {
+		HandleReload(w, r)
+		return
+	}
+
+	// A standby only serves its journal and promotion, clients talk to the primary
+	if r.RequestURI == JOURNAL || r.RequestURI == PROMOTE {
+		HandleStandbyCommand(w, r)
+		return
+	}
+	if IsStandby() {
+		RespondWithException(w, http.StatusServiceUnavailable, ILLEGAL_STATE, "this naming server is a standby, send requests to the primary.")
+		return
+	}
+
+	// In read-only mode nothing may change the namespace or move files
+	if IsReadOnly() && (IsMutatingCommand(r.RequestURI) || r.RequestURI == STORAGE_MOVE || r.RequestURI == ADMIN_REBALANCE) {
+		RespondWithException(w, http.StatusConflict, ILLEGAL_STATE, "the DFS is in read-only mode.")
+		return
+	}
+
+	// A retried create or delete gets the response of the first attempt
+	if key := r.Header.Get(IDEMPOTENCY_KEY_HEADER); key != "" && IsMutatingCommand(r.RequestURI) {
+		HandleIdempotentCommand(w, r, key)
+		return
+	}
+
 	// If the command is /is_valid_path
 	if r.RequestURI == IS_VALID_PATH {
 		/* Get the path from the request */
 		var req PathRequest
-		err := json.NewDecoder(r.Body).Decode(&req) // Decode the request's body
-		if err != nil {
-			fmt.Fprintf(&SERVICE_OUT, "ERROR! %s\n", err)
+		if !DecodeRequest(w, r, &req, "path") {
 			return
 		}
 		path := req.PathString // The path string sent by client
@@ -1124,9 +3646,8 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 
 		/* Get the StorageServer object from the json request */
 		var path PathRequest
-		err := json.NewDecoder(r.Body).Decode(&path) // Decode the request's body
-		if err != nil {
-			fmt.Fprintf(&SERVICE_OUT, "ERROR: %v\n", err)
+		if !DecodeRequest(w, r, &path, "path") {
+			return
 		}
 
 		pathString := strings.TrimLeft(path.PathString, "/") // trim first slash
@@ -1135,20 +3656,15 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 		/* Check if path is valid */
 		if !IsPathValid(path.PathString) {
 			fmt.Fprintf(&SERVICE_OUT, "Invalid path:%v\n", path)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusNotFound) // 404
-			response := ExceptionResponse{
-				ExceptionType: "IllegalArgumentException",
-				ExceptionInfo: "the file/directory or parent directory is not a valid path.",
-			}
-			fmt.Fprintf(&SERVICE_OUT, "Sending: %v\n", w)
-			json.NewEncoder(w).Encode(response)
+			RespondWithException(w, http.StatusNotFound, ILLEGAL_ARGUMENT, "the path is not valid.")
 			return
 		}
 
 		/* Check if path exists */
 		locationExists := false
+		unlockNamespace := NAMING_SERVER.LockNamespace(path.PathString, false)
 		NAMING_SERVER.root.LocationExists(locations, &locationExists)
+		unlockNamespace()
 		if locationExists || path.PathString == "/" {
 			// If path leads to a file, then respond with {success: false}
 			if strings.Contains(locations[len(locations)-1], "file") {
@@ -1168,14 +3684,7 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 			return
 		} else {
 			/* Directory does NOT exist*/
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusNotFound) // 404
-			response := ExceptionResponse{
-				ExceptionType: "FileNotFoundException",
-				ExceptionInfo: "the file/directory or parent directory does not exist.",
-			}
-			fmt.Fprintf(&SERVICE_OUT, "Sending: %v\n", w)
-			json.NewEncoder(w).Encode(response)
+			RespondWithException(w, http.StatusNotFound, FILE_NOT_FOUND, "the file/directory or parent directory does not exist.")
 			return
 		}
 	}
@@ -1184,24 +3693,17 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 	// DANGER NOTE: The directory should be locked for shared access before this
 	// operation is performed, to allow for safe reading of the directory contents.
 	if r.RequestURI == LIST {
-		/* Get the StorageServer object from the json request */
-		var path PathRequest
-		err := json.NewDecoder(r.Body).Decode(&path) // Decode the request's body
-		if err != nil {
-			fmt.Fprintf(&SERVICE_OUT, "ERROR: %v\n", err)
+		/* Get the ListRequest object from the json request */
+		var path ListRequest
+		if !DecodeRequest(w, r, &path, "path") {
+			return
 		}
 
 		/* Handle an invalid pathString */
 		if !IsPathValid(path.PathString) {
 			fmt.Fprintf(&SERVICE_OUT, "Invalid path: %v\n", path)
 			// respond with success = false
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusNotFound) // 404
-			response := ExceptionResponse{
-				ExceptionType: "IllegalArgumentException",
-				ExceptionInfo: "the file/directory or parent directory does not exist.",
-			}
-			json.NewEncoder(w).Encode(response)
+			RespondWithException(w, http.StatusNotFound, ILLEGAL_ARGUMENT, "the path is not valid.")
 			return
 		}
 
@@ -1210,6 +3712,9 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 
 		locationExists := false // Initialize to false
 
+		// Hold the directory's shard until it is listed
+		defer NAMING_SERVER.LockNamespace(path.PathString, false)()
+
 		// This will set the locationExists bool to true if location exists
 		NAMING_SERVER.root.LocationExists(locations, &locationExists)
 
@@ -1219,16 +3724,10 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 			finalLocation := locations[len(locations)-1] // The end of the path
 
 			/* If final location is NOT a Directory */
-			if !strings.Contains(finalLocation, "directory") && path.PathString != "/" {
+			if !strings.Contains(finalLocation, "directory") && path.PathString != "/" && path.PathString != TRASH_PATH {
 				fmt.Fprintf(&SERVICE_OUT, "File is not Directory: %v\n", path)
 				// respond with {Success = false}
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusNotFound) // 404
-				response := ExceptionResponse{
-					ExceptionType: "FileNotFoundException",
-					ExceptionInfo: "the file/directory or parent directory does not exist.",
-				}
-				json.NewEncoder(w).Encode(response)
+				RespondWithException(w, http.StatusNotFound, FILE_NOT_FOUND, "the file/directory or parent directory does not exist.")
 				return
 			}
 
@@ -1244,20 +3743,22 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 
 			fmt.Fprintf(&SERVICE_OUT, "Files at %s are: %v", path.PathString, content)
 
+			/* Filter, order and paginate the contents */
+			page, nextToken, err := PageContents(content, path)
+			if err != nil {
+				fmt.Fprintf(&SERVICE_OUT, "Invalid list request: %v\n", err)
+				RespondWithException(w, http.StatusNotFound, ILLEGAL_ARGUMENT, fmt.Sprintf("invalid pattern or continuation token: %v", err))
+				return
+			}
+
 			/* Path requested is existing directory respond with contents */
 			w.Header().Set("Content-Type", "application/json")
-			response := ListSuccessfulResponse{Files: content}
+			response := ListSuccessfulResponse{Files: page, NextToken: nextToken}
 			json.NewEncoder(w).Encode(response)
 			return
 		} else {
 			/*Location does not exist; path string is not root.*/
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusNotFound) // 404
-			response := ExceptionResponse{
-				ExceptionType: "FileNotFoundException",
-				ExceptionInfo: "the file/directory or parent directory does not exist.",
-			}
-			json.NewEncoder(w).Encode(response)
+			RespondWithException(w, http.StatusNotFound, FILE_NOT_FOUND, "the file/directory or parent directory does not exist.")
 			return
 		}
 	}
@@ -1268,9 +3769,8 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 	if r.RequestURI == CREATE_DIRECTORY {
 		/* Get the StorageServer object from the json request */
 		var path PathRequest
-		err := json.NewDecoder(r.Body).Decode(&path) // Decode the request's body
-		if err != nil {
-			fmt.Fprintf(&SERVICE_OUT, "ERROR: %v\n", err)
+		if !DecodeRequest(w, r, &path, "path") {
+			return
 		}
 
 		pathStringTrimmed := strings.TrimLeft(path.PathString, "/") // trim first slash
@@ -1278,18 +3778,15 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 
 		/* Handle an invalid pathString */
 		if !IsPathValid(path.PathString) {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusNotFound) // 404
-			response := ExceptionResponse{
-				ExceptionType: "IllegalArgumentException",
-				ExceptionInfo: "the file/directory or parent directory does not exist.",
-			}
-			json.NewEncoder(w).Encode(response)
+			RespondWithException(w, http.StatusNotFound, ILLEGAL_ARGUMENT, "the path is not valid.")
 			return
 		}
 
 		isRoot := false // Initialize as false
 
+		// Hold the new directory's shard until it is created
+		defer NAMING_SERVER.LockNamespace(path.PathString, true)()
+
 		// If parent directory exists.
 		if len(locations) > 1 {
 			parentExists := false // Initialize to false
@@ -1307,13 +3804,7 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 			if !parentExists || ContainsX(parentDirectory, "file") {
 				fmt.Fprintf(&SERVICE_OUT, "File Not Found: %v\n", path)
 				// Respond with {ExceptionType: "FileNotFoundException"}
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusNotFound) // 404
-				response := ExceptionResponse{
-					ExceptionType: "FileNotFoundException",
-					ExceptionInfo: "the parent directory does not exist.",
-				}
-				json.NewEncoder(w).Encode(response)
+				RespondWithException(w, http.StatusNotFound, FILE_NOT_FOUND, "the parent directory does not exist.")
 				return
 			}
 		} else {
@@ -1343,6 +3834,9 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 
 		// Create a new path, if it does not already exist.
 		success := NAMING_SERVER.root.CheckNewPath(locations, 0)
+		if success {
+			Journal(JournalEntry{Op: CREATE_DIRECTORY, Path: path.PathString})
+		}
 
 		/* Respond with {Success: success}, probably true */
 		w.Header().Set("Content-Type", "application/json")
@@ -1356,22 +3850,15 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 	if r.RequestURI == CREATE_FILE {
 		/* Get the StorageServer object from the json request */
 		var path PathRequest
-		err := json.NewDecoder(r.Body).Decode(&path) // Decode the request's body
-		if err != nil {
-			fmt.Fprintf(&SERVICE_OUT, "ERROR: %v\n", err)
+		if !DecodeRequest(w, r, &path, "path") {
+			return
 		}
 
 		/* Handle an invalid pathString */
 		if !IsPathValid(path.PathString) {
 			fmt.Fprintf(&SERVICE_OUT, "Invalid path: %v\n", path)
 			// Respond with {ExceptionType: "IllegalArgumentException"}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusNotFound) // 404
-			response := ExceptionResponse{
-				ExceptionType: "IllegalArgumentException",
-				ExceptionInfo: "the file/directory or parent directory does not exist.",
-			}
-			json.NewEncoder(w).Encode(response)
+			RespondWithException(w, http.StatusNotFound, ILLEGAL_ARGUMENT, "the path is not valid.")
 			return
 		}
 
@@ -1380,6 +3867,14 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 
 		isRoot := false // Initialize as false
 
+		// An older file deleted from this path may still be in the trash
+		if CurrentSettings().TrashRetention > 0 {
+			PurgeTrashAt(path.PathString)
+		}
+
+		// Hold the new file's shard until it is created
+		defer NAMING_SERVER.LockNamespace(path.PathString, true)()
+
 		// If parent directory exists.
 		if len(locations) > 1 {
 			parentExists := false // Initialize to false
@@ -1397,13 +3892,7 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 			// or parent directory contains a file
 			if !parentExists || ContainsX(parentDirectory, "file") {
 				// Respond with {ExceptionType: "FileNotFoundException"}
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusNotFound) // 404
-				response := ExceptionResponse{
-					ExceptionType: "FileNotFoundException",
-					ExceptionInfo: "the parent directory does not exist.",
-				}
-				json.NewEncoder(w).Encode(response)
+				RespondWithException(w, http.StatusNotFound, FILE_NOT_FOUND, "the parent directory does not exist.")
 				return
 			}
 		} else {
@@ -1427,13 +3916,30 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		/* There must be a storage server to store the new file */
+		if len(NAMING_SERVER.Registry()) == 0 {
+			RespondWithException(w, http.StatusConflict, ILLEGAL_STATE, "no storage servers are registered with the naming server.")
+			return
+		}
+
 		/* At this point we are free to create a new directory */
 		createdNewPath := NAMING_SERVER.root.CheckNewPath(locations, 0)
 
 		if createdNewPath {
-			if NAMING_SERVER.CreateFileOnStorage(path) {
-				//TODO: send /storage_copy to all other StorageServers
+			// The file only exists once a storage server stored it,
+			// otherwise take it out of the namespace again.
+			if !NAMING_SERVER.CreateFileOnStorage(path) {
+				NAMING_SERVER.root.DetachLocation(SplitPath(path.PathString))
+				RespondWithException(w, http.StatusConflict, ILLEGAL_STATE, "the storage server could not create the file.")
+				return
 			}
+
+			// The first storage server now owns the file
+			registry_mu.Lock()
+			NAMING_SERVER.registry[0].Files = append(NAMING_SERVER.registry[0].Files, path.PathString)
+			registry_mu.Unlock()
+			//TODO: send /storage_copy to all other StorageServers
+			Journal(JournalEntry{Op: CREATE_FILE, Path: path.PathString, Stored: true})
 		}
 
 		/* Respond with {Success: success}, probably true */
@@ -1452,21 +3958,14 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 
 		/* Get the StorageServer object from the json request */
 		var path PathRequest
-		err := json.NewDecoder(r.Body).Decode(&path) // Decode the request's body
-		if err != nil {
-			fmt.Fprintf(&SERVICE_OUT, "ERROR: %v\n", err)
+		if !DecodeRequest(w, r, &path, "path") {
+			return
 		}
 
 		/* Handle an invalid pathString */
 		if !IsPathValid(path.PathString) {
 			// Respond with {ExceptionType: "IllegalArgumentException"}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusNotFound) // 404
-			response := ExceptionResponse{
-				ExceptionType: "IllegalArgumentException",
-				ExceptionInfo: "the file/directory or parent directory does not exist.",
-			}
-			json.NewEncoder(w).Encode(response)
+			RespondWithException(w, http.StatusNotFound, ILLEGAL_ARGUMENT, "the path is not valid.")
 			return
 		}
 
@@ -1479,36 +3978,39 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 		locationExists := false // Initialize as false
 
 		// Recursively check if location exists
+		unlockNamespace := NAMING_SERVER.LockNamespace(path.PathString, false)
 		NAMING_SERVER.root.LocationExists(locs, &locationExists)
+		unlockNamespace()
 
 		// If the location does not exist or the final location is a directory
 		if !locationExists || strings.Contains(locations[len(locations)-1], "directory") {
 			fmt.Fprintf(&SERVICE_OUT, "Location not found: %v\n", path)
 			// respond with {Success = false}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusNotFound) // 404
-			response := ExceptionResponse{
-				ExceptionType: "FileNotFoundException",
-				ExceptionInfo: "the file/directory or parent directory does not exist.",
-			}
-			json.NewEncoder(w).Encode(response)
+			RespondWithException(w, http.StatusNotFound, FILE_NOT_FOUND, "the file/directory or parent directory does not exist.")
 			return
 		}
 
-		for _, storage_server := range NAMING_SERVER.registry {
+		for _, storage_server := range NAMING_SERVER.Registry() {
 			for _, file := range storage_server.Files {
 				if file == path.PathString {
 					fmt.Fprintf(&SERVICE_OUT, "Storage %d owns %s", storage_server.ClientPort, path.PathString)
+					lease := GrantLease(path.PathString) // Let client cache this location
 					w.Header().Set("Content-Type", "application/json")
 					response := StorageInfo{
 						ServerIP:   storage_server.StorageIP,
 						ServerPort: storage_server.ClientPort,
+						LeaseMs:    time.Until(lease.Expires).Milliseconds(),
 					}
 					json.NewEncoder(w).Encode(response)
 					return
 				}
 			}
 		}
+
+		// The file exists in the directory tree, but no storage server hosts it
+		fmt.Fprintf(&SERVICE_OUT, "No storage server hosts: %v\n", path)
+		RespondWithException(w, http.StatusNotFound, FILE_NOT_FOUND, "no storage server hosts the file.")
+		return
 	}
 
 	// Handle locking
@@ -1516,21 +4018,20 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 	if r.RequestURI == LOCK {
 		/* Get the StorageServer object from the json request */
 		var lock Lock
-		err := json.NewDecoder(r.Body).Decode(&lock) // Decode the request's body
-		if err != nil {
-			fmt.Fprintf(&SERVICE_OUT, "ERROR: %v\n", err)
+		if !DecodeRequest(w, r, &lock, "path", "exclusive") {
+			return
 		}
 
 		/* Handle an invalid pathString */
 		if !IsPathValid(lock.PathString) {
 			// Respond with {ExceptionType: "IllegalArgumentException"}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusNotFound) // 404
-			response := ExceptionResponse{
-				ExceptionType: "IllegalArgumentException",
-				ExceptionInfo: "the file/directory or parent directory does not exist.",
-			}
-			json.NewEncoder(w).Encode(response)
+			RespondWithException(w, http.StatusNotFound, ILLEGAL_ARGUMENT, "the path is not valid.")
+			return
+		}
+
+		/* Files cannot be written in read-only mode */
+		if lock.Exclusive && IsReadOnly() {
+			RespondWithException(w, http.StatusConflict, ILLEGAL_STATE, "the DFS is in read-only mode.")
 			return
 		}
 
@@ -1543,19 +4044,15 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 		locationExists := false // Initialize as false
 
 		// Check if location exists
+		unlockNamespace := NAMING_SERVER.LockNamespace(lock.PathString, false)
 		NAMING_SERVER.root.LocationExists(locs, &locationExists)
+		unlockNamespace()
 
 		// If location does not exist
 		if !locationExists && lock.PathString != "/" {
 			fmt.Fprintf(&SERVICE_OUT, "Location not found: %v\n", lock)
 			// respond with {ExceptionType: "FileNotFoundException"}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusNotFound) // 404
-			response := ExceptionResponse{
-				ExceptionType: "FileNotFoundException",
-				ExceptionInfo: "the file/directory or parent directory does not exist.",
-			}
-			json.NewEncoder(w).Encode(response)
+			RespondWithException(w, http.StatusNotFound, FILE_NOT_FOUND, "the file/directory or parent directory does not exist.")
 			return
 		}
 
@@ -1565,6 +4062,14 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 		NAMING_SERVER.root.LockLocation(lock, 0, &successfullyLocked)
 
 		if successfullyLocked {
+			// Exclusive locks come with a fencing token for writing to storage.
+			// It is sent as a header, since the API requires an empty body.
+			if lock.Exclusive {
+				token := IssueFencingToken()
+				SendFence(lock.PathString, token)
+				w.Header().Set(FENCING_TOKEN_HEADER, strconv.FormatInt(token, 10))
+			}
+
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
 			fmt.Fprintf(&SERVICE_OUT, "Successfully locked!\n")
@@ -1578,21 +4083,14 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 	if r.RequestURI == UNLOCK {
 		/* Get the StorageServer object from the json request */
 		var lock Lock
-		err := json.NewDecoder(r.Body).Decode(&lock) // Decode the request's body
-		if err != nil {
-			fmt.Fprintf(&SERVICE_OUT, "ERROR: %v\n", err)
+		if !DecodeRequest(w, r, &lock, "path", "exclusive") {
+			return
 		}
 
 		/* Handle an invalid pathString */
 		if !IsPathValid(lock.PathString) {
 			// Respond with {ExceptionType: "IllegalArgumentException"}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusNotFound) // 404
-			response := ExceptionResponse{
-				ExceptionType: "IllegalArgumentException",
-				ExceptionInfo: "the file/directory or parent directory does not exist.",
-			}
-			json.NewEncoder(w).Encode(response)
+			RespondWithException(w, http.StatusNotFound, ILLEGAL_ARGUMENT, "the path is not valid.")
 			return
 		}
 
@@ -1604,21 +4102,29 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 
 		locationExists := false // Initialize as false
 
+		unlockNamespace := NAMING_SERVER.LockNamespace(lock.PathString, false)
 		NAMING_SERVER.root.LocationExists(locs, &locationExists)
+		unlockNamespace()
 
 		// If location does not exist
 		if !locationExists && lock.PathString != "/" {
 			// respond with {ExceptionType: "IllegalArgumentException"}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusNotFound) // 404
-			response := ExceptionResponse{
-				ExceptionType: "IllegalArgumentException",
-				ExceptionInfo: "the file/directory or parent directory does not exist.",
-			}
-			json.NewEncoder(w).Encode(response)
+			RespondWithException(w, http.StatusNotFound, ILLEGAL_ARGUMENT, "the file/directory cannot be found.")
 			return
 		}
 
+		// A write is replicated while the client still holds its exclusive lock,
+		// so no one reads the file before enough replicas acknowledged it
+		if lock.Exclusive && HoldsExclusiveLock(lock.PathString) {
+			acknowledged, wanted := ReplicateWrite(lock.PathString)
+			if wanted > 0 {
+				w.Header().Set(REPLICAS_ACKNOWLEDGED_HEADER, strconv.Itoa(acknowledged))
+			}
+			if acknowledged < wanted {
+				w.Header().Set(REPLICATION_WARNING_HEADER, fmt.Sprintf("only %d of %d replicas acknowledged the write", acknowledged, wanted))
+			}
+		}
+
 		successfullyUnlocked := false
 
 		NAMING_SERVER.root.UnlockLocation(lock, 0, &successfullyUnlocked)
@@ -1627,14 +4133,16 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
 
-			// Increment access counts and send deletes if access count >= 20
+			// Increment access counts and send deletes if the access count reaches the threshold
 			Increment_Access_Count(lock.PathString)
 
 			// If lock was exclusive
 			if lock.Exclusive {
-				// Delete it from all storage servers,
-				// except owner's.
-				SendDelete(lock.PathString, false)
+				// Replicas moved, so cached locations are stale
+				InvalidateLeases(lock.PathString)
+
+				// Fence off the released token, so writes cannot be replayed with it
+				SendFence(lock.PathString, IssueFencingToken())
 			}
 			return // Exit
 		} else {
@@ -1649,22 +4157,15 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 	if r.RequestURI == DELETE {
 		/* Get the StorageServer object from the json request */
 		var path PathRequest
-		err := json.NewDecoder(r.Body).Decode(&path) // Decode the request's body
-		if err != nil {
-			fmt.Fprintf(&SERVICE_OUT, "ERROR: %v\n", err)
+		if !DecodeRequest(w, r, &path, "path") {
+			return
 		}
 
 		/* Handle an invalid pathString */
 		if !IsPathValid(path.PathString) {
 			fmt.Fprintf(&SERVICE_OUT, "Invalid path: %v\n", path)
 			// Respond with {ExceptionType: "IllegalArgumentException"}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusNotFound) // 404
-			response := ExceptionResponse{
-				ExceptionType: "IllegalArgumentException",
-				ExceptionInfo: "the file/directory or parent directory does not exist.",
-			}
-			json.NewEncoder(w).Encode(response)
+			RespondWithException(w, http.StatusNotFound, ILLEGAL_ARGUMENT, "the path is not valid.")
 			return
 		}
 
@@ -1676,24 +4177,58 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 
 		locationExists := false // Initialize as false
 
+		unlockNamespace := NAMING_SERVER.LockNamespace(path.PathString, false)
 		NAMING_SERVER.root.LocationExists(locs, &locationExists)
+		unlockNamespace()
 
 		// If location does not exist
 		if !locationExists && path.PathString != "/" {
 			fmt.Fprintf(&SERVICE_OUT, "Location not found: %v\n", path)
 			// respond with {ExceptionType: "FileNotFoundException"}
+			RespondWithException(w, http.StatusNotFound, FILE_NOT_FOUND, "the file/directory or parent directory does not exist.")
+			return
+		}
+
+		// The root directory cannot be deleted
+		if path.PathString == "/" {
 			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusNotFound) // 404
-			response := ExceptionResponse{
-				ExceptionType: "FileNotFoundException",
-				ExceptionInfo: "the file/directory or parent directory does not exist.",
+			response := ServiceResponse{Success: false}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		// File is gone, so cached locations are stale
+		InvalidateLeases(path.PathString)
+
+		// In trash mode, move the location to /.trash instead of deleting it.
+		// Deleting something that is already in the trash purges it.
+		if CurrentSettings().TrashRetention > 0 && !strings.HasPrefix(path.PathString, TRASH_PATH) {
+			trashPath, moved := MoveToTrash(path.PathString)
+			fmt.Fprintf(&SERVICE_OUT, "Moved %s to %s\n", path.PathString, trashPath)
+			if moved {
+				Journal(JournalEntry{Op: DELETE, Path: path.PathString, To: trashPath})
 			}
+
+			w.Header().Set("Content-Type", "application/json")
+			response := ServiceResponse{Success: moved}
 			json.NewEncoder(w).Encode(response)
 			return
 		}
 
-		// Send delete to all storage servers
-		SendDelete(path.PathString, true)
+		if strings.HasPrefix(path.PathString, TRASH_PATH) {
+			PurgeTrash(func(trashPath string, entry TrashEntry) bool {
+				return path.PathString == TRASH_PATH || trashPath == path.PathString
+			})
+		} else {
+			// Remove the location from the directory tree
+			unlockNamespace = NAMING_SERVER.LockWholeNamespace()
+			NAMING_SERVER.root.DetachLocation(SplitPath(path.PathString))
+			unlockNamespace()
+			Journal(JournalEntry{Op: DELETE, Path: path.PathString})
+
+			// Send delete to all storage servers
+			SendDelete(path.PathString, true)
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -1702,6 +4237,61 @@ func HandleServiceCommand(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Handle watching a cached file location
+	// This is a blocking request call, it only responds back when the leases
+	// on the path are invalidated or have expired.
+	if r.RequestURI == WATCH {
+		var path PathRequest
+		if !DecodeRequest(w, r, &path, "path") {
+			return
+		}
+
+		/* Handle an invalid pathString */
+		if !IsPathValid(path.PathString) {
+			// Respond with {ExceptionType: "IllegalArgumentException"}
+			RespondWithException(w, http.StatusNotFound, ILLEGAL_ARGUMENT, "the path is not valid.")
+			return
+		}
+
+		invalidated := WatchLeases(path.PathString)
+
+		w.Header().Set("Content-Type", "application/json")
+		response := WatchResponse{PathString: path.PathString, Invalidated: invalidated}
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	// Handle listing every replica of a file, for parallel reads
+	if r.RequestURI == GET_REPLICAS {
+		HandleGetReplicas(w, r)
+		return
+	}
+
+	// Handle the replica consistency check admin command
+	if r.RequestURI == CHECK_REPLICAS {
+		HandleCheckReplicas(w, r)
+		return
+	}
+
+	// Handle moving a file to another storage server
+	if r.RequestURI == STORAGE_MOVE {
+		HandleStorageMove(w, r)
+		return
+	}
+
+	// Handle restoring or purging locations in the trash
+	if r.RequestURI == RESTORE || r.RequestURI == PURGE {
+		HandleTrashCommand(w, r)
+		return
+	}
+
+	// Handle the admin commands of dfsadmin
+	if r.RequestURI == ADMIN_SERVERS || r.RequestURI == ADMIN_NAMESPACE || r.RequestURI == ADMIN_UNLOCK ||
+		r.RequestURI == ADMIN_REBALANCE || r.RequestURI == ADMIN_READ_ONLY || r.RequestURI == ADMIN_ACCESS_EXPORT {
+		HandleAdminCommand(w, r)
+		return
+	}
+
 	/* Respond with 400 Bad Request, if the command is unknown. */
 	http.Error(w, "Unknown Command", http.StatusBadRequest)
 }
@@ -1725,18 +4315,41 @@ func main() {
 	REGISTRATION_OUT = *file2
 
 	/*
-		Get arguments in the form `go run NamingServer.go arg0 arg1`,
-		where arg0 is the Service Port and arg1 is the Registration Port
+		Get arguments in the form `go run NamingServer.go arg0 arg1 [arg2] [standby=addr] [config=file]`,
+		where arg0 is the Service Port and arg1 is the Registration Port.
+		The optional arg2 turns on trash mode, keeping deleted locations
+		in /.trash for that long (e.g. 24h) before purging them.
+		standby=addr starts a standby of the primary serving at addr (e.g. 127.0.0.1:4444).
+		config=file loads the runtime-tunable settings from a JSON file,
+		reloaded on SIGHUP or /reload.
 	*/
 	args := os.Args[1:]
 
 	// Create a NamingServer struct
-	NAMING_SERVER = &NamingServer{
-		servicePort:      "127.0.0.1:" + args[0],
-		registrationPort: "127.0.0.1:" + args[1],
-		running:          false,
-		root:             &Location{name: "/", locks: []Lock{}},
-		access_counts:    map[string]int{},
+	NAMING_SERVER = NewNamingServer(args[0], args[1])
+
+	for _, arg := range args[2:] {
+		if primary, ok := strings.CutPrefix(arg, "standby="); ok {
+			NAMING_SERVER.standby = true
+			NAMING_SERVER.primary = primary
+			continue
+		}
+
+		if configFile, ok := strings.CutPrefix(arg, "config="); ok {
+			NAMING_SERVER.config_file = configFile
+			continue
+		}
+
+		retention, err := time.ParseDuration(arg)
+		if err != nil || retention <= 0 {
+			log.Fatalf("Invalid trash retention %q: %v", arg, err)
+		}
+		NAMING_SERVER.settings.TrashRetention = retention
+	}
+
+	NAMING_SERVER.base_settings = NAMING_SERVER.settings
+	if _, err := ReloadSettings(); err != nil {
+		log.Fatalf("Invalid config file %q: %v", NAMING_SERVER.config_file, err)
 	}
 
 	fmt.Fprint(&SERVICE_OUT, "\n----------------------------**Starting a NamingServer**----------------------------\n")
@@ -0,0 +1,1006 @@
+package main
+
+import (
+	"../../testutil"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/*
+Set up a fresh NAMING_SERVER holding /directory_a and /directory_a/file_a,
+with no storage server registered. Logs go to the test's log file, shown if
+it fails.
+*/
+func setupNamingServer(t *testing.T) {
+	out := testutil.LogFile(t, "naming")
+	SERVICE_OUT = *out
+	REGISTRATION_OUT = *out
+
+	NAMING_SERVER = NewNamingServer("0", "0")
+	NAMING_SERVER.root.CheckNewPath([]string{"directory_a", "file_a"}, 0)
+}
+
+/*
+Every failure mode of the service interface must respond with the
+exception type and status code required by API_Naming_Service.md.
+*/
+func TestServiceExceptions(t *testing.T) {
+	setupNamingServer(t)
+
+	tests := []struct {
+		name          string
+		command       string
+		body          string
+		wantStatus    int
+		wantException string // Empty when no exception body is expected
+	}{
+		{"is_directory invalid path", IS_DIRECTORY, `{"path":"directory_a"}`, http.StatusNotFound, ILLEGAL_ARGUMENT},
+		{"is_directory missing", IS_DIRECTORY, `{"path":"/directory_x"}`, http.StatusNotFound, FILE_NOT_FOUND},
+		{"list invalid path", LIST, `{"path":"/directory:a"}`, http.StatusNotFound, ILLEGAL_ARGUMENT},
+		{"list missing", LIST, `{"path":"/directory_x"}`, http.StatusNotFound, FILE_NOT_FOUND},
+		{"list file", LIST, `{"path":"/directory_a/file_a"}`, http.StatusNotFound, FILE_NOT_FOUND},
+		{"list bad pattern", LIST, `{"path":"/directory_a","pattern":"["}`, http.StatusNotFound, ILLEGAL_ARGUMENT},
+		{"create_directory invalid path", CREATE_DIRECTORY, `{"path":""}`, http.StatusNotFound, ILLEGAL_ARGUMENT},
+		{"create_directory missing parent", CREATE_DIRECTORY, `{"path":"/directory_x/directory_y"}`, http.StatusNotFound, FILE_NOT_FOUND},
+		{"create_file invalid path", CREATE_FILE, `{"path":"file_b"}`, http.StatusNotFound, ILLEGAL_ARGUMENT},
+		{"create_file missing parent", CREATE_FILE, `{"path":"/directory_x/file_b"}`, http.StatusNotFound, FILE_NOT_FOUND},
+		{"create_file no storage server", CREATE_FILE, `{"path":"/directory_a/file_b"}`, http.StatusConflict, ILLEGAL_STATE},
+		{"get_storage invalid path", GET_STORAGE, `{"path":"file_a"}`, http.StatusNotFound, ILLEGAL_ARGUMENT},
+		{"get_storage missing", GET_STORAGE, `{"path":"/directory_a/file_x"}`, http.StatusNotFound, FILE_NOT_FOUND},
+		{"get_storage directory", GET_STORAGE, `{"path":"/directory_a"}`, http.StatusNotFound, FILE_NOT_FOUND},
+		{"get_storage unhosted", GET_STORAGE, `{"path":"/directory_a/file_a"}`, http.StatusNotFound, FILE_NOT_FOUND},
+		{"get_replicas invalid path", GET_REPLICAS, `{"path":"file_a"}`, http.StatusNotFound, ILLEGAL_ARGUMENT},
+		{"get_replicas unhosted", GET_REPLICAS, `{"path":"/directory_a/file_a"}`, http.StatusNotFound, FILE_NOT_FOUND},
+		{"lock invalid path", LOCK, `{"path":"","exclusive":true}`, http.StatusNotFound, ILLEGAL_ARGUMENT},
+		{"lock missing", LOCK, `{"path":"/directory_x","exclusive":true}`, http.StatusNotFound, FILE_NOT_FOUND},
+		{"unlock invalid path", UNLOCK, `{"path":"","exclusive":true}`, http.StatusNotFound, ILLEGAL_ARGUMENT},
+		{"unlock missing", UNLOCK, `{"path":"/directory_x","exclusive":true}`, http.StatusNotFound, ILLEGAL_ARGUMENT},
+		{"delete invalid path", DELETE, `{"path":"a:b"}`, http.StatusNotFound, ILLEGAL_ARGUMENT},
+		{"delete missing", DELETE, `{"path":"/directory_x"}`, http.StatusNotFound, FILE_NOT_FOUND},
+		{"malformed request", LIST, `{"path":`, http.StatusBadRequest, ILLEGAL_ARGUMENT},
+		{"unknown field", LIST, `{"path":"/","recursive":true}`, http.StatusBadRequest, ILLEGAL_ARGUMENT},
+		{"missing field", LOCK, `{"path":"/directory_a"}`, http.StatusBadRequest, ILLEGAL_ARGUMENT},
+		{"null field", CREATE_DIRECTORY, `{"path":null}`, http.StatusBadRequest, ILLEGAL_ARGUMENT},
+		{"wrong type", LOCK, `{"path":"/directory_a","exclusive":"yes"}`, http.StatusBadRequest, ILLEGAL_ARGUMENT},
+		{"unknown command", "/unknown", `{"path":"/"}`, http.StatusBadRequest, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", test.command, strings.NewReader(test.body))
+			rec := httptest.NewRecorder()
+
+			HandleServiceCommand(rec, req)
+
+			if rec.Code != test.wantStatus {
+				t.Fatalf("%s %s: got status %d, want %d", test.command, test.body, rec.Code, test.wantStatus)
+			}
+
+			if test.wantException == "" {
+				return
+			}
+
+			var response ExceptionResponse
+			if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+				t.Fatalf("%s %s: could not decode exception: %v", test.command, test.body, err)
+			}
+			if response.ExceptionType != test.wantException {
+				t.Errorf("%s %s: got %s, want %s", test.command, test.body, response.ExceptionType, test.wantException)
+			}
+		})
+	}
+}
+
+/* Send a command to the service interface and return the recorded response */
+func serve(t *testing.T, handler http.HandlerFunc, command string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", command, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s %s: got status %d: %s", command, body, rec.Code, rec.Body.String())
+	}
+	return rec
+}
+
+/*
+A standby replaying the primary's journal must end up with the same
+directory tree and registry, and the same journal to hand on.
+*/
+func TestJournalReplay(t *testing.T) {
+	setupNamingServer(t)
+	NAMING_SERVER = NewNamingServer("0", "0")
+	NAMING_SERVER.settings.TrashRetention = time.Hour
+
+	serve(t, HandleRegistration, REGISTER, `{"storage_ip":"http://127.0.0.1:","client_port":1,"command_port":2,"files":["/directory_a/file_a","/directory_b/file_b"]}`)
+	serve(t, HandleServiceCommand, CREATE_DIRECTORY, `{"path":"/directory_c"}`)
+	serve(t, HandleServiceCommand, DELETE, `{"path":"/directory_a/file_a"}`)
+	serve(t, HandleServiceCommand, DELETE, `{"path":"/directory_b"}`)
+	serve(t, HandleTrashCommand, RESTORE, `{"path":"/.trash/file_a~1"}`)
+	serve(t, HandleTrashCommand, PURGE, `{"path":"/.trash/directory_b~2"}`)
+
+	primary := NAMING_SERVER
+	journal := ReadJournal(0, 0)
+	if len(journal) != 6 {
+		t.Fatalf("got %d journal entries, want 6: %v", len(journal), journal)
+	}
+
+	NAMING_SERVER = NewNamingServer("0", "0")
+	NAMING_SERVER.settings.TrashRetention = time.Hour
+	for _, entry := range journal {
+		ApplyJournalEntry(entry)
+	}
+
+	// Sorted names of the locations in the directory at path
+	contents := func(server *NamingServer, path string) string {
+		names := SplitPath(path)
+		if path == "/" {
+			names = []string{"/"}
+		}
+		content := []string{}
+		server.root.GetContentsAt(names, &content)
+		sort.Strings(content)
+		return strings.Join(content, ",")
+	}
+
+	for _, path := range []string{"/", "/directory_a", "/directory_c", TRASH_PATH} {
+		if got, want := contents(NAMING_SERVER, path), contents(primary, path); got != want {
+			t.Errorf("contents of %s: got %v, want %v", path, got, want)
+		}
+	}
+
+	if len(NAMING_SERVER.registry) != 1 || NAMING_SERVER.registry[0].CommandPort != 2 {
+		t.Errorf("got registry %v, want the registered storage server", NAMING_SERVER.registry)
+	}
+	if replayed := ReadJournal(0, 0); len(replayed) != len(journal) {
+		t.Errorf("standby journal has %d entries, want %d", len(replayed), len(journal))
+	}
+}
+
+/*
+A file the storage server fails to create must be taken out of the
+namespace again and never reach the journal, and a retry with the same
+Idempotency-Key must create it once the storage server can.
+*/
+func TestCreateFileRollback(t *testing.T) {
+	setupNamingServer(t)
+	NAMING_SERVER = NewNamingServer("0", "0")
+
+	stored := false
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(ServiceResponse{Success: stored})
+	}))
+	defer storage.Close()
+
+	var port int
+	fmt.Sscanf(storage.URL, "http://127.0.0.1:%d", &port)
+	serve(t, HandleRegistration, REGISTER, fmt.Sprintf(`{"storage_ip":"http://127.0.0.1:","client_port":1,"command_port":%d,"files":[]}`, port))
+	serve(t, HandleServiceCommand, CREATE_DIRECTORY, `{"path":"/directory_a"}`)
+
+	create := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", CREATE_FILE, strings.NewReader(`{"path":"/directory_a/file_a"}`))
+		req.Header.Set(IDEMPOTENCY_KEY_HEADER, "key-1")
+		rec := httptest.NewRecorder()
+		HandleServiceCommand(rec, req)
+		return rec
+	}
+
+	rec := create()
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("%s: got status %d, want %d", CREATE_FILE, rec.Code, http.StatusConflict)
+	}
+
+	exists := false
+	NAMING_SERVER.root.LocationExists([]string{"directory_a", "file_a"}, &exists)
+	if exists {
+		t.Errorf("%s: file left in the namespace after the storage server failed", CREATE_FILE)
+	}
+	for _, entry := range ReadJournal(0, 0) {
+		if entry.Op == CREATE_FILE {
+			t.Errorf("%s: journaled %v after the storage server failed", CREATE_FILE, entry)
+		}
+	}
+
+	// Once the storage server stores files, the same path can be created
+	stored = true
+	rec = create()
+	var response ServiceResponse
+	json.NewDecoder(rec.Body).Decode(&response)
+	if !response.Success || !reflect.DeepEqual(NAMING_SERVER.registry[0].Files, []string{"/directory_a/file_a"}) {
+		t.Errorf("%s: got success %v and files %v, want the file stored", CREATE_FILE, response.Success, NAMING_SERVER.registry[0].Files)
+	}
+}
+
+/*
+Retrying a create with the same Idempotency-Key must get the first response
+back, instead of being told that the directory already exists.
+*/
+func TestIdempotentRetry(t *testing.T) {
+	setupNamingServer(t)
+
+	send := func(key string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", CREATE_DIRECTORY, strings.NewReader(body))
+		req.Header.Set(IDEMPOTENCY_KEY_HEADER, key)
+		rec := httptest.NewRecorder()
+		HandleServiceCommand(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name       string
+		key        string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"first attempt", "key-1", `{"path":"/directory_b"}`, http.StatusOK, `{"success":true}`},
+		{"retry", "key-1", `{"path":"/directory_b"}`, http.StatusOK, `{"success":true}`},
+		{"new request", "key-2", `{"path":"/directory_b"}`, http.StatusOK, `{"success":false}`},
+		{"key reused", "key-1", `{"path":"/directory_c"}`, http.StatusConflict, ""},
+	}
+
+	for _, test := range tests {
+		rec := send(test.key, test.body)
+		if rec.Code != test.wantStatus {
+			t.Fatalf("%s: got status %d, want %d", test.name, rec.Code, test.wantStatus)
+		}
+		if test.wantBody != "" && strings.TrimSpace(rec.Body.String()) != test.wantBody {
+			t.Errorf("%s: got %s, want %s", test.name, rec.Body.String(), test.wantBody)
+		}
+	}
+
+	if len(NAMING_SERVER.journal) != 1 {
+		t.Errorf("directory was created %d times, want once", len(NAMING_SERVER.journal))
+	}
+}
+
+/*
+A forced unlock must release the lock and the shared locks taken along
+its path, and read-only mode must refuse changes but still allow reads.
+*/
+func TestAdminCommands(t *testing.T) {
+	setupNamingServer(t)
+	NAMING_SERVER = NewNamingServer("0", "0")
+
+	serve(t, HandleRegistration, REGISTER, `{"storage_ip":"http://127.0.0.1:","client_port":1,"command_port":2,"files":["/directory_a/file_a"]}`)
+	serve(t, HandleServiceCommand, LOCK, `{"path":"/directory_a/file_a","exclusive":true}`)
+
+	rec := serve(t, HandleServiceCommand, ADMIN_UNLOCK, `{"path":"/directory_a/file_a"}`)
+	if got, want := strings.TrimSpace(rec.Body.String()), `{"path":"/directory_a/file_a","released":1,"exclusive":true}`; got != want {
+		t.Errorf("%s: got %s, want %s", ADMIN_UNLOCK, got, want)
+	}
+
+	var namespace NamespaceResponse
+	rec = serve(t, HandleServiceCommand, ADMIN_NAMESPACE, `{"path":"/"}`)
+	if err := json.Unmarshal(rec.Body.Bytes(), &namespace); err != nil {
+		t.Fatal(err)
+	}
+	want := []NamespaceEntry{
+		{PathString: "/directory_a", Directory: true},
+		{PathString: "/directory_a/file_a", Owner: 1},
+	}
+	if !reflect.DeepEqual(namespace.Locations, want) {
+		t.Errorf("%s: got %v, want %v", ADMIN_NAMESPACE, namespace.Locations, want)
+	}
+
+	serve(t, HandleServiceCommand, ADMIN_READ_ONLY, `{"read_only":true}`)
+
+	tests := []struct {
+		command    string
+		body       string
+		wantStatus int
+	}{
+		{CREATE_DIRECTORY, `{"path":"/directory_b"}`, http.StatusConflict},
+		{DELETE, `{"path":"/directory_a"}`, http.StatusConflict},
+		{LOCK, `{"path":"/directory_a/file_a","exclusive":true}`, http.StatusConflict},
+		{LOCK, `{"path":"/directory_a/file_a","exclusive":false}`, http.StatusOK},
+		{ADMIN_REBALANCE, `{}`, http.StatusConflict},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", test.command, strings.NewReader(test.body))
+		rec := httptest.NewRecorder()
+		HandleServiceCommand(rec, req)
+		if rec.Code != test.wantStatus {
+			t.Errorf("%s %s in read-only mode: got status %d, want %d", test.command, test.body, rec.Code, test.wantStatus)
+		}
+	}
+
+	serve(t, HandleServiceCommand, ADMIN_READ_ONLY, `{"read_only":false}`)
+	serve(t, HandleServiceCommand, CREATE_DIRECTORY, `{"path":"/directory_b"}`)
+}
+
+/*
+An exclusive unlock must wait for write_replicas replicas to copy the
+written file while the lock is still held, and warn the client when some
+do not acknowledge the write in time.
+*/
+func TestWriteAcknowledgements(t *testing.T) {
+	setupNamingServer(t)
+	NAMING_SERVER = NewNamingServer("0", "0")
+	NAMING_SERVER.settings.WriteReplicas = 3
+	NAMING_SERVER.settings.WriteAckTimeout = 200 * time.Millisecond
+
+	var slow atomic.Bool
+	var copiedLocked atomic.Int32
+	var copying atomic.Int32 // Copies still being made
+	slow.Store(true)
+	storage := func(delay *atomic.Bool) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == STORAGE_COPY {
+				copying.Add(1)
+				defer copying.Add(-1)
+				if delay != nil && delay.Load() {
+					time.Sleep(time.Second)
+				}
+				if HoldsExclusiveLock("/directory_a/file_a") {
+					copiedLocked.Add(1)
+				}
+			}
+			json.NewEncoder(w).Encode(ServiceResponse{Success: true})
+		}))
+	}
+	servers := []*httptest.Server{storage(nil), storage(nil), storage(&slow)}
+	for i, server := range servers {
+		defer server.Close()
+		var port int
+		fmt.Sscanf(server.URL, "http://127.0.0.1:%d", &port)
+		files := `[]`
+		if i == 0 {
+			files = `["/directory_a/file_a"]`
+		}
+		serve(t, HandleRegistration, REGISTER, fmt.Sprintf(`{"storage_ip":"http://127.0.0.1:","client_port":%d,"command_port":%d,"files":%s}`, i+1, port, files))
+	}
+
+	write := func() *httptest.ResponseRecorder {
+		serve(t, HandleServiceCommand, LOCK, `{"path":"/directory_a/file_a","exclusive":true}`)
+		return serve(t, HandleServiceCommand, UNLOCK, `{"path":"/directory_a/file_a","exclusive":true}`)
+	}
+
+	rec := write()
+	if got := rec.Header().Get(REPLICAS_ACKNOWLEDGED_HEADER); got != "2" {
+		t.Errorf("%s: got %s replicas acknowledged, want 2", UNLOCK, got)
+	}
+	if rec.Header().Get(REPLICATION_WARNING_HEADER) == "" {
+		t.Errorf("%s: no warning when a replica did not acknowledge the write", UNLOCK)
+	}
+	if HoldsExclusiveLock("/directory_a/file_a") {
+		t.Errorf("%s: lock still held after a partial replication", UNLOCK)
+	}
+
+	slow.Store(false)
+	testutil.WaitFor(t, testutil.READY_TIMEOUT, "the late copy to finish", func() bool {
+		return copying.Load() == 0
+	})
+	copiedLocked.Store(0)
+	rec = write()
+	if got := rec.Header().Get(REPLICAS_ACKNOWLEDGED_HEADER); got != "3" || rec.Header().Get(REPLICATION_WARNING_HEADER) != "" {
+		t.Errorf("%s: got %s replicas acknowledged and warning %q, want 3 and none", UNLOCK, got, rec.Header().Get(REPLICATION_WARNING_HEADER))
+	}
+	if copiedLocked.Load() != 2 {
+		t.Errorf("%s: %d copies made under the lock, want 2", UNLOCK, copiedLocked.Load())
+	}
+
+	// Shared unlocks do not replicate
+	serve(t, HandleServiceCommand, LOCK, `{"path":"/directory_a/file_a","exclusive":false}`)
+	rec = serve(t, HandleServiceCommand, UNLOCK, `{"path":"/directory_a/file_a","exclusive":false}`)
+	if rec.Header().Get(REPLICAS_ACKNOWLEDGED_HEADER) != "" {
+		t.Errorf("%s: shared unlock replicated the file", UNLOCK)
+	}
+}
+
+/* Copies of a file must go to zones that do not hold it yet before doubling up in a zone */
+func TestReplicaTargets(t *testing.T) {
+	setupNamingServer(t)
+	NAMING_SERVER = NewNamingServer("0", "0")
+
+	for i, zone := range []string{"east", "east", "", "west", "west"} {
+		body := fmt.Sprintf(`{"storage_ip":"http://127.0.0.1:","client_port":%d,"command_port":%d,"files":[],"zone":%q}`, 10*i+1, 10*i+2, zone)
+		serve(t, HandleRegistration, REGISTER, body)
+	}
+
+	targets, zones := ReplicaTargets(NAMING_SERVER.registry[0])
+	ports := []int{}
+	for _, target := range targets {
+		ports = append(ports, target.ClientPort)
+	}
+
+	if want := []int{21, 31, 11, 41}; !reflect.DeepEqual(ports, want) {
+		t.Errorf("got targets %v, want %v", ports, want)
+	}
+	if zones != 3 {
+		t.Errorf("got %d zones, want 3", zones)
+	}
+}
+
+/* Each holder of a file is the source of at most copy_fanout copies per wave */
+func TestCopyWave(t *testing.T) {
+	servers := []StorageServer{}
+	for i := 0; i < 6; i++ {
+		servers = append(servers, StorageServer{ClientPort: 10*i + 1})
+	}
+	owner, targets := servers[0], servers[1:]
+
+	tests := []struct {
+		fanout int
+		waves  [][]int // Source and target client ports of the assignments of each wave
+	}{
+		{0, [][]int{{1, 11, 1, 21, 1, 31, 1, 41, 1, 51}}},
+		{1, [][]int{{1, 11}, {1, 21, 11, 31}, {1, 41, 11, 51}}},
+		{2, [][]int{{1, 11, 1, 21}, {1, 31, 11, 41, 21, 51}}},
+	}
+
+	for _, test := range tests {
+		sources, left := []StorageServer{owner}, targets
+		waves := [][]int{}
+		for len(left) > 0 {
+			var wave []CopyAssignment
+			wave, left = CopyWave(sources, left, test.fanout)
+
+			ports := []int{}
+			for _, assignment := range wave {
+				ports = append(ports, assignment.Source.ClientPort, assignment.Target.ClientPort)
+				sources = append(sources, assignment.Target)
+			}
+			waves = append(waves, ports)
+		}
+
+		if !reflect.DeepEqual(waves, test.waves) {
+			t.Errorf("copy_fanout %d: got waves %v, want %v", test.fanout, waves, test.waves)
+		}
+	}
+}
+
+/* Accesses are exported per window, as JSON or CSV, and forgotten after ACCESS_HISTORY */
+func TestAccessExport(t *testing.T) {
+	setupNamingServer(t)
+	serve(t, HandleRegistration, REGISTER, `{"storage_ip":"http://127.0.0.1:","client_port":1,"command_port":2,"files":["/directory_a/file_a"]}`)
+
+	now := time.Now()
+	access_mu.Lock()
+	for _, ago := range []time.Duration{25 * time.Hour, 90 * time.Minute, 30 * time.Minute, 30 * time.Minute} {
+		RecordAccess("/directory_a/file_a", now.Add(-ago))
+	}
+	access_mu.Unlock()
+
+	var export AccessExportResponse
+	rec := serve(t, HandleServiceCommand, ADMIN_ACCESS_EXPORT, `{"window":"1h","windows":2}`)
+	if err := json.Unmarshal(rec.Body.Bytes(), &export); err != nil {
+		t.Fatal(err)
+	}
+	if len(export.Files) != 1 {
+		t.Fatalf("%s: got %d files, want 1", ADMIN_ACCESS_EXPORT, len(export.Files))
+	}
+	summary := export.Files[0]
+	counts := []int{}
+	for _, window := range summary.Windows {
+		counts = append(counts, window.Accesses)
+	}
+	if !reflect.DeepEqual(counts, []int{1, 2}) || summary.Accesses != 3 {
+		t.Errorf("%s: got accesses %v (%d in all), want [1 2] (3 in all)", ADMIN_ACCESS_EXPORT, counts, summary.Accesses)
+	}
+	if summary.Size != -1 || summary.Replicas != 0 {
+		t.Errorf("%s: got size %d and %d replicas without storage servers, want -1 and 0", ADMIN_ACCESS_EXPORT, summary.Size, summary.Replicas)
+	}
+
+	rec = serve(t, HandleServiceCommand, ADMIN_ACCESS_EXPORT, `{"window":"1h","windows":2,"format":"csv"}`)
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 3 || lines[0] != "path,window_start,window_end,accesses,size,replicas" || !strings.HasSuffix(lines[2], ",2,-1,0") {
+		t.Errorf("%s: got CSV %q", ADMIN_ACCESS_EXPORT, lines)
+	}
+
+	for _, body := range []string{`{"window":"1s"}`, `{"window":"2h","windows":13}`, `{"format":"xml"}`} {
+		rec := httptest.NewRecorder()
+		HandleServiceCommand(rec, httptest.NewRequest("POST", ADMIN_ACCESS_EXPORT, strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: got status %d, want %d", ADMIN_ACCESS_EXPORT, body, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+/*
+A write must invalidate the leases handed out by /get_storage and wake up
+the client watching the file, so it stops reading its cached location.
+*/
+func TestLeaseInvalidation(t *testing.T) {
+	setupNamingServer(t)
+	NAMING_SERVER = NewNamingServer("0", "0")
+	serve(t, HandleRegistration, REGISTER, `{"storage_ip":"http://127.0.0.1:","client_port":1,"command_port":2,"files":["/directory_a/file_a"]}`)
+
+	var info StorageInfo
+	rec := serve(t, HandleServiceCommand, GET_STORAGE, `{"path":"/directory_a/file_a"}`)
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.ServerPort != 1 || info.LeaseMs <= 0 || info.LeaseMs > LEASE_DURATION.Milliseconds() {
+		t.Fatalf("%s: got %+v, want client port 1 with a lease of at most %v", GET_STORAGE, info, LEASE_DURATION)
+	}
+
+	watched := make(chan WatchResponse, 1)
+	go func() {
+		rec := httptest.NewRecorder()
+		HandleServiceCommand(rec, httptest.NewRequest("POST", WATCH, strings.NewReader(`{"path":"/directory_a/file_a"}`)))
+		var response WatchResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		watched <- response
+	}()
+	testutil.WaitFor(t, testutil.READY_TIMEOUT, "the client to watch its lease", func() bool {
+		lease_mu.Lock()
+		defer lease_mu.Unlock()
+		return len(NAMING_SERVER.watchers["/directory_a/file_a"]) == 1
+	})
+
+	serve(t, HandleServiceCommand, LOCK, `{"path":"/directory_a/file_a","exclusive":true}`)
+	serve(t, HandleServiceCommand, UNLOCK, `{"path":"/directory_a/file_a","exclusive":true}`)
+
+	select {
+	case response := <-watched:
+		if !response.Invalidated {
+			t.Errorf("%s: got %+v, want the lease invalidated by the write", WATCH, response)
+		}
+	case <-time.After(LEASE_DURATION / 2):
+		t.Fatalf("%s: the watcher was not woken up by the write", WATCH)
+	}
+
+	lease_mu.Lock()
+	leases := len(NAMING_SERVER.leases["/directory_a/file_a"])
+	lease_mu.Unlock()
+	if leases != 0 {
+		t.Errorf("%d leases left on the written file, want none", leases)
+	}
+
+	// A shared unlock is a read, the new lease outlives it
+	serve(t, HandleServiceCommand, GET_STORAGE, `{"path":"/directory_a/file_a"}`)
+	serve(t, HandleServiceCommand, LOCK, `{"path":"/directory_a/file_a","exclusive":false}`)
+	serve(t, HandleServiceCommand, UNLOCK, `{"path":"/directory_a/file_a","exclusive":false}`)
+	lease_mu.Lock()
+	leases = len(NAMING_SERVER.leases["/directory_a/file_a"])
+	lease_mu.Unlock()
+	if leases != 1 {
+		t.Errorf("%d leases left after a read, want 1", leases)
+	}
+}
+
+/*
+Locks on paths in different shards must not wait on each other, while a
+lock on a directory must still keep out locks on the locations below it.
+*/
+func TestShardedLocks(t *testing.T) {
+	setupNamingServer(t)
+	NAMING_SERVER = NewNamingServer("0", "0")
+	serve(t, HandleRegistration, REGISTER, `{"storage_ip":"http://127.0.0.1:","client_port":1,"command_port":2,"files":["/directory_a/file_a","/directory_b/file_b"]}`)
+
+	/* Send a command in the background, the channel is closed once it is answered */
+	send := func(command string, body string) chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			rec := httptest.NewRecorder()
+			HandleServiceCommand(rec, httptest.NewRequest("POST", command, strings.NewReader(body)))
+			if rec.Code != http.StatusOK {
+				t.Errorf("%s %s: got status %d: %s", command, body, rec.Code, rec.Body.String())
+			}
+		}()
+		return done
+	}
+	answered := func(done chan struct{}, within time.Duration) bool {
+		select {
+		case <-done:
+			return true
+		case <-time.After(within):
+			return false
+		}
+	}
+
+	// Hold the shard of /directory_a as a request half way through it would
+	shard := NAMING_SERVER.ShardOf("/directory_a")
+	shard.tree.Lock()
+	shard.locks.Lock()
+	locked := send(LOCK, `{"path":"/directory_b/file_b","exclusive":true}`)
+	if !answered(locked, testutil.READY_TIMEOUT) {
+		t.Fatalf("%s /directory_b/file_b waited on the shard of /directory_a", LOCK)
+	}
+	if !answered(send(UNLOCK, `{"path":"/directory_b/file_b","exclusive":true}`), testutil.READY_TIMEOUT) {
+		t.Fatalf("%s /directory_b/file_b waited on the shard of /directory_a", UNLOCK)
+	}
+	shard.locks.Unlock()
+	shard.tree.Unlock()
+
+	// An exclusive lock on a directory excludes its files, in the same shard
+	serve(t, HandleServiceCommand, LOCK, `{"path":"/directory_a","exclusive":true}`)
+	locked = send(LOCK, `{"path":"/directory_a/file_a","exclusive":false}`)
+	if answered(locked, 200*time.Millisecond) {
+		t.Fatalf("%s /directory_a/file_a granted while /directory_a is locked exclusively", LOCK)
+	}
+	serve(t, HandleServiceCommand, UNLOCK, `{"path":"/directory_a","exclusive":true}`)
+	if !answered(locked, testutil.READY_TIMEOUT) {
+		t.Fatalf("%s /directory_a/file_a not granted once /directory_a was unlocked", LOCK)
+	}
+	serve(t, HandleServiceCommand, UNLOCK, `{"path":"/directory_a/file_a","exclusive":false}`)
+}
+
+/*
+/list must return names in order, filtered by the glob pattern, one page
+of at most limit names at a time, until no continuation token is left.
+*/
+func TestListPagination(t *testing.T) {
+	setupNamingServer(t)
+	for _, name := range []string{"directory_c", "directory_b", "directory_e", "directory_d"} {
+		serve(t, HandleServiceCommand, CREATE_DIRECTORY, fmt.Sprintf(`{"path":"/directory_a/%s"}`, name))
+	}
+
+	list := func(body string) ListSuccessfulResponse {
+		var response ListSuccessfulResponse
+		rec := serve(t, HandleServiceCommand, LIST, body)
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	tests := []struct {
+		name    string
+		limit   int
+		pattern string
+		pages   [][]string
+	}{
+		{"unpaged", 0, "", [][]string{{"directory_b", "directory_c", "directory_d", "directory_e", "file_a"}}},
+		{"pages of 2", 2, "", [][]string{{"directory_b", "directory_c"}, {"directory_d", "directory_e"}, {"file_a"}}},
+		{"filtered pages", 2, "directory_*", [][]string{{"directory_b", "directory_c"}, {"directory_d", "directory_e"}}},
+		{"no match", 2, "*_x", [][]string{{}}},
+	}
+
+	for _, test := range tests {
+		pages := [][]string{}
+		token := ""
+		for {
+			response := list(fmt.Sprintf(`{"path":"/directory_a","limit":%d,"pattern":%q,"continuation_token":%q}`, test.limit, test.pattern, token))
+			pages = append(pages, response.Files)
+			if token = response.NextToken; token == "" || len(pages) > len(test.pages) {
+				break
+			}
+		}
+		if !reflect.DeepEqual(pages, test.pages) {
+			t.Errorf("%s: got pages %v, want %v", test.name, pages, test.pages)
+		}
+	}
+
+	// A token points after a name, so a page still starts at the next one when that name goes away
+	first := list(`{"path":"/directory_a","limit":2}`)
+	serve(t, HandleServiceCommand, DELETE, `{"path":"/directory_a/directory_c"}`)
+	next := list(fmt.Sprintf(`{"path":"/directory_a","limit":2,"continuation_token":%q}`, first.NextToken))
+	if !reflect.DeepEqual(next.Files, []string{"directory_d", "directory_e"}) {
+		t.Errorf("%s after the last listed name was deleted: got %v, want [directory_d directory_e]", LIST, next.Files)
+	}
+}
+
+/*
+Each exclusive lock must come with a fencing token newer than any before,
+which the storage servers are told of, and its release must fence off the
+token so a client that lost its lock cannot write with it.
+*/
+func TestFencingTokens(t *testing.T) {
+	setupNamingServer(t)
+	NAMING_SERVER = NewNamingServer("0", "0")
+
+	var fenced atomic.Int64 // Newest token sent to the storage server
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var fence StorageFence
+		if r.URL.Path == STORAGE_FENCE && json.NewDecoder(r.Body).Decode(&fence) == nil && fence.Path == "/directory_a/file_a" {
+			fenced.Store(fence.FencingToken)
+		}
+		json.NewEncoder(w).Encode(ServiceResponse{Success: true})
+	}))
+	defer storage.Close()
+
+	var port int
+	fmt.Sscanf(storage.URL, "http://127.0.0.1:%d", &port)
+	serve(t, HandleRegistration, REGISTER, fmt.Sprintf(`{"storage_ip":"http://127.0.0.1:","client_port":1,"command_port":%d,"files":["/directory_a/file_a"]}`, port))
+
+	tokens := []int64{}
+	for i := 0; i < 2; i++ {
+		rec := serve(t, HandleServiceCommand, LOCK, `{"path":"/directory_a/file_a","exclusive":true}`)
+		token, err := strconv.ParseInt(rec.Header().Get(FENCING_TOKEN_HEADER), 10, 64)
+		if err != nil {
+			t.Fatalf("%s: no fencing token with the exclusive lock: %v", LOCK, err)
+		}
+		if fenced.Load() != token {
+			t.Errorf("%s: storage server fenced at %d, want the lock's token %d", LOCK, fenced.Load(), token)
+		}
+		if len(tokens) > 0 && token <= tokens[len(tokens)-1] {
+			t.Errorf("%s: got token %d after %d, want a newer one", LOCK, token, tokens[len(tokens)-1])
+		}
+		tokens = append(tokens, token)
+
+		serve(t, HandleServiceCommand, UNLOCK, `{"path":"/directory_a/file_a","exclusive":true}`)
+		if fenced.Load() <= token {
+			t.Errorf("%s: storage server fenced at %d, want the released token %d fenced off", UNLOCK, fenced.Load(), token)
+		}
+	}
+
+	rec := serve(t, HandleServiceCommand, LOCK, `{"path":"/directory_a/file_a","exclusive":false}`)
+	if token := rec.Header().Get(FENCING_TOKEN_HEADER); token != "" {
+		t.Errorf("%s: got fencing token %s with a shared lock, want none", LOCK, token)
+	}
+	serve(t, HandleServiceCommand, UNLOCK, `{"path":"/directory_a/file_a","exclusive":false}`)
+}
+
+/*
+/check_replicas must elect the copy held by most replicas, the owner's on
+a tie, report the replicas that differ from it, and overwrite them from a
+correct replica when asked to repair.
+*/
+func TestCheckReplicas(t *testing.T) {
+	setupNamingServer(t)
+	NAMING_SERVER = NewNamingServer("0", "0")
+
+	var mu sync.Mutex
+	checksums := map[int]string{} // Checksum of the replica on each client port, none if empty
+	storage := func(client_port int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			switch r.URL.Path {
+			case STORAGE_CHECKSUM:
+				if checksums[client_port] == "" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				json.NewEncoder(w).Encode(StorageChecksum{Path: "/directory_a/file_a", Checksum: checksums[client_port]})
+			case STORAGE_COPY:
+				var req StorageCopy
+				json.NewDecoder(r.Body).Decode(&req)
+				checksums[client_port] = checksums[req.ServerPort]
+				json.NewEncoder(w).Encode(ServiceResponse{Success: true})
+			}
+		}))
+	}
+	for i := 1; i <= 3; i++ {
+		server := storage(i)
+		defer server.Close()
+		var port int
+		fmt.Sscanf(server.URL, "http://127.0.0.1:%d", &port)
+		files := `[]`
+		if i == 1 {
+			files = `["/directory_a/file_a"]`
+		}
+		serve(t, HandleRegistration, REGISTER, fmt.Sprintf(`{"storage_ip":"http://127.0.0.1:","client_port":%d,"command_port":%d,"files":%s}`, i, port, files))
+	}
+
+	check := func(body string) ReplicaReport {
+		var response ReplicaCheckResponse
+		rec := serve(t, HandleServiceCommand, CHECK_REPLICAS, body)
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if len(response.Files) != 1 {
+			t.Fatalf("%s %s: got %d files, want 1", CHECK_REPLICAS, body, len(response.Files))
+		}
+		return response.Files[0]
+	}
+
+	// The owner kept a stale copy, the other replicas agree on the write
+	checksums[1], checksums[2], checksums[3] = "stale", "written", "written"
+	report := check(`{"path":"/directory_a"}`)
+	if report.Checksum != "written" || !reflect.DeepEqual(report.Divergent, []int{1}) || report.Repaired {
+		t.Errorf("%s: got %+v, want the owner divergent from the majority and left as it is", CHECK_REPLICAS, report)
+	}
+	if checksums[1] != "stale" {
+		t.Errorf("%s: repaired the owner without being asked to", CHECK_REPLICAS)
+	}
+
+	report = check(`{"path":"/directory_a/file_a","repair":true}`)
+	if !report.Repaired || checksums[1] != "written" {
+		t.Errorf("%s with repair: got %+v and owner checksum %s, want the owner repaired", CHECK_REPLICAS, report, checksums[1])
+	}
+	if report = check(`{"path":"/directory_a/file_a"}`); len(report.Divergent) != 0 {
+		t.Errorf("%s after repair: got %+v, want no divergent replica", CHECK_REPLICAS, report)
+	}
+
+	// On a tie, the owner's copy is correct
+	checksums[2], checksums[3] = "other", ""
+	report = check(`{"path":"/directory_a/file_a"}`)
+	if report.Checksum != "written" || !reflect.DeepEqual(report.Divergent, []int{2}) || len(report.Replicas) != 2 {
+		t.Errorf("%s on a tie: got %+v, want the owner's copy elected", CHECK_REPLICAS, report)
+	}
+}
+
+/*
+In trash mode a delete must only move the location into /.trash, keeping
+its files on the storage servers, until it is restored to where it was or
+purged, which deletes its files.
+*/
+func TestTrash(t *testing.T) {
+	setupNamingServer(t)
+	NAMING_SERVER = NewNamingServer("0", "0")
+	NAMING_SERVER.settings.TrashRetention = time.Hour
+
+	var mu sync.Mutex
+	deleted := []string{} // Paths the storage servers were told to delete
+	storage := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var path PathRequest
+		if r.URL.Path == STORAGE_DELETE && json.NewDecoder(r.Body).Decode(&path) == nil {
+			mu.Lock()
+			deleted = append(deleted, path.PathString)
+			mu.Unlock()
+		}
+		json.NewEncoder(w).Encode(ServiceResponse{Success: true})
+	})
+	// Files are only deleted through storage servers when several are registered
+	for i, files := range []string{`["/directory_a/file_a","/directory_b/file_b"]`, `[]`} {
+		server := httptest.NewServer(storage)
+		defer server.Close()
+		var port int
+		fmt.Sscanf(server.URL, "http://127.0.0.1:%d", &port)
+		serve(t, HandleRegistration, REGISTER, fmt.Sprintf(`{"storage_ip":"http://127.0.0.1:","client_port":%d,"command_port":%d,"files":%s}`, i+1, port, files))
+	}
+
+	list := func(path string) []string {
+		var response ListSuccessfulResponse
+		rec := serve(t, HandleServiceCommand, LIST, fmt.Sprintf(`{"path":%q}`, path))
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return response.Files
+	}
+	deletes := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, deleted...)
+	}
+
+	serve(t, HandleServiceCommand, DELETE, `{"path":"/directory_a/file_a"}`)
+	if got := list(TRASH_PATH); !reflect.DeepEqual(got, []string{"file_a~1"}) {
+		t.Errorf("%s: got trash %v, want [file_a~1]", DELETE, got)
+	}
+	if got := list("/directory_a"); len(got) != 0 || len(deletes()) != 0 {
+		t.Errorf("%s: got /directory_a %v and storage deletes %v, want the file in the trash only", DELETE, got, deletes())
+	}
+
+	serve(t, HandleTrashCommand, RESTORE, `{"path":"/.trash/file_a~1"}`)
+	if got := list("/directory_a"); !reflect.DeepEqual(got, []string{"file_a"}) {
+		t.Errorf("%s: got /directory_a %v, want [file_a]", RESTORE, got)
+	}
+	if got := list(TRASH_PATH); len(got) != 0 {
+		t.Errorf("%s: got trash %v, want it empty", RESTORE, got)
+	}
+
+	serve(t, HandleServiceCommand, DELETE, `{"path":"/directory_b"}`)
+	if got := list("/"); !reflect.DeepEqual(got, []string{TRASH_NAME, "directory_a"}) {
+		t.Errorf("%s: got / %v, want [%s directory_a]", DELETE, got, TRASH_NAME)
+	}
+	serve(t, HandleTrashCommand, PURGE, `{"path":"/.trash/directory_b~2"}`)
+	if got := list(TRASH_PATH); len(got) != 0 {
+		t.Errorf("%s: got trash %v, want it empty", PURGE, got)
+	}
+	if got := deletes(); !reflect.DeepEqual(got, []string{"/directory_b", "/directory_b"}) {
+		t.Errorf("%s: got storage deletes %v, want /directory_b on both storage servers", PURGE, got)
+	}
+
+	rec := httptest.NewRecorder()
+	HandleTrashCommand(rec, httptest.NewRequest("POST", RESTORE, strings.NewReader(`{"path":"/.trash/directory_b~2"}`)))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("%s of a purged location: got status %d, want %d", RESTORE, rec.Code, http.StatusNotFound)
+	}
+}
+
+/*
+/storage_move must copy the file to the new storage server, verify the
+copy, make it the owner and delete the source copy, and must leave the
+owner as it was when the copy does not match.
+*/
+func TestStorageMove(t *testing.T) {
+	setupNamingServer(t)
+	NAMING_SERVER = NewNamingServer("0", "0")
+
+	var mu sync.Mutex
+	contents := map[int]string{} // Content of the copy on each client port, none if empty
+	corrupt := false             // Copies come out different from their source
+	storage := func(client_port int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			switch r.URL.Path {
+			case STORAGE_COPY:
+				var req StorageCopy
+				json.NewDecoder(r.Body).Decode(&req)
+				contents[client_port] = contents[req.ServerPort]
+				if corrupt {
+					contents[client_port] += " corrupted"
+				}
+			case STORAGE_CHECKSUM:
+				if contents[client_port] == "" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				json.NewEncoder(w).Encode(StorageChecksum{Path: "/directory_a/file_a", Checksum: contents[client_port]})
+				return
+			case STORAGE_DELETE:
+				delete(contents, client_port)
+			}
+			json.NewEncoder(w).Encode(ServiceResponse{Success: true})
+		}))
+	}
+	for i := 1; i <= 2; i++ {
+		server := storage(i)
+		defer server.Close()
+		var port int
+		fmt.Sscanf(server.URL, "http://127.0.0.1:%d", &port)
+		files := `[]`
+		if i == 1 {
+			files = `["/directory_a/file_a"]`
+		}
+		serve(t, HandleRegistration, REGISTER, fmt.Sprintf(`{"storage_ip":"http://127.0.0.1:","client_port":%d,"command_port":%d,"files":%s}`, i, port, files))
+	}
+	contents[1] = "data"
+
+	owner := func() int {
+		var info StorageInfo
+		rec := serve(t, HandleServiceCommand, GET_STORAGE, `{"path":"/directory_a/file_a"}`)
+		if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+			t.Fatal(err)
+		}
+		return info.ServerPort
+	}
+	move := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		HandleServiceCommand(rec, httptest.NewRequest("POST", STORAGE_MOVE, strings.NewReader(body)))
+		return rec
+	}
+
+	var report MoveReport
+	rec := move(`{"path":"/directory_a/file_a","server_port":2}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s: got status %d: %s", STORAGE_MOVE, rec.Code, rec.Body.String())
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if want := (MoveReport{Path: "/directory_a/file_a", From: 1, To: 2, Checksum: "data", SourceDeleted: true}); report != want {
+		t.Errorf("%s: got %+v, want %+v", STORAGE_MOVE, report, want)
+	}
+	if got := owner(); got != 2 || contents[1] != "" || contents[2] != "data" {
+		t.Errorf("%s: got owner %d and copies %v, want the file on 2 only", STORAGE_MOVE, got, contents)
+	}
+	journal := ReadJournal(0, 0)
+	if last := journal[len(journal)-1]; last.Op != STORAGE_MOVE || last.Port != 2 {
+		t.Errorf("%s: last journal entry %+v, want the move to 2", STORAGE_MOVE, last)
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		corrupt    bool
+		wantStatus int
+	}{
+		{"copy does not match", `{"path":"/directory_a/file_a","server_port":1}`, true, http.StatusConflict},
+		{"already the owner", `{"path":"/directory_a/file_a","server_port":2}`, false, http.StatusConflict},
+		{"unknown storage server", `{"path":"/directory_a/file_a","server_port":3}`, false, http.StatusNotFound},
+		{"missing file", `{"path":"/directory_a/file_x","server_port":1}`, false, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		mu.Lock()
+		corrupt = test.corrupt
+		mu.Unlock()
+		if rec := move(test.body); rec.Code != test.wantStatus {
+			t.Errorf("%s: got status %d, want %d", test.name, rec.Code, test.wantStatus)
+		}
+		if got := owner(); got != 2 {
+			t.Errorf("%s: got owner %d, want 2 kept", test.name, got)
+		}
+	}
+
+	// A file missing from the directory tree cannot be locked, so it is not moved
+	SwitchOwner("/directory_b/file_b", 1)
+	if _, err := MoveFile("/directory_b/file_b", NAMING_SERVER.Registry()[1]); err == nil || err.Error() != "could not lock the file" {
+		t.Errorf("unlocked file: got error %v, want the lock refused", err)
+	}
+}
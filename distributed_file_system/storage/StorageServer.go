@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,8 +13,13 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
-	// "strconv"
 	"encoding/base64"
 	"errors"
 )
@@ -25,6 +32,20 @@ const STORAGE_IP string = "http://127.0.0.1:"
 
 const FILE_PERMISSIONS = 0644
 
+/* Suffix of the temporary file a copy is written to before it replaces the file */
+const COPY_TEMP_SUFFIX string = ".copy~"
+
+/* Longest time a write stays in the write buffer, unless buffer_ms=<ms> is given */
+const WRITE_BUFFER_DELAY time.Duration = 50 * time.Millisecond
+
+/* Durability modes of the write buffer, see WriteBufferSettings */
+const DURABILITY_FLUSH string = "flush"
+const DURABILITY_BUFFER string = "buffer"
+
+/* States of an intent in the journal */
+const INTENT_BEGIN string = "begin"
+const INTENT_COMMIT string = "commit"
+
 /* API Endpoints */
 const REGISTRATION_API_ENDPOINT string = "/register"
 const STORAGE_SIZE_API_ENDPOINT string = "/storage_size"
@@ -33,6 +54,9 @@ const STORAGE_WRITE_API_ENDPOINT string = "/storage_write"
 const STORAGE_CREATE_API_ENDPOINT string = "/storage_create"
 const STORAGE_DELETE_API_ENDPOINT string = "/storage_delete"
 const STORAGE_COPY_API_ENDPOINT string = "/storage_copy"
+const STORAGE_FENCE_API_ENDPOINT string = "/storage_fence"
+const STORAGE_CHECKSUM_API_ENDPOINT string = "/storage_checksum"
+const STORAGE_DISKS_API_ENDPOINT string = "/storage_disks"
 
 /* End of Global Constants */
 
@@ -52,13 +76,41 @@ type StorageServer struct {
 	commandPort      string
 	registrationPort string
 	root             string
+
+	/* Zone (simulated datacenter) the server is placed in, reported at registration */
+	zone string
+
+	/* Every disk the server stores files on, the primary root first */
+	roots []string
+
+	/* Disk (root directory) holding each file, persisted next to the primary root */
+	disks     map[string]string
+	disks_mu  sync.Mutex
+	disksFile string
+
+	/* Intent journal of mutating operations in progress, next to the primary root */
+	intents         *os.File
+	intents_mu      sync.Mutex
+	intentsFile     string
+	intents_seq     int64
+	intents_pending int
+
+	/* Newest fencing token received from the Naming Server for each file */
+	fences    map[string]int64
+	fences_mu sync.Mutex
+
+	/* Writes not yet on disk, by location of the file on disk */
+	writeBuffer WriteBufferSettings
+	buffers     map[string]*writeBuffer
+	buffers_mu  sync.Mutex
 }
 
 type RegisterRequest struct {
 	Storage_IP  string   `json:"storage_ip"`
-	ClientPort  string   `json:"client_port"`
-	CommandPort string   `json:"command_port"`
+	ClientPort  int      `json:"client_port"`
+	CommandPort int      `json:"command_port"`
 	Files       []string `json:"files"`
+	Zone        string   `json:"zone,omitempty"`
 }
 
 type StorageSizeRequest struct {
@@ -80,9 +132,10 @@ type StorageReadResponse struct {
 }
 
 type StorageWriteRequest struct {
-	Path   string `json:"path"`
-	Offset int    `json:"offset"`
-	Data   string `json:"data"`
+	Path         string `json:"path"`
+	Offset       int    `json:"offset"`
+	Data         string `json:"data"`
+	FencingToken int64  `json:"fencing_token"`
 }
 
 type StorageWriteResponse struct {
@@ -115,6 +168,124 @@ type StorageCopyResponse struct {
 	Success bool `json:"success"`
 }
 
+type StorageChecksumRequest struct {
+	Path string `json:"path"`
+}
+
+type StorageChecksumResponse struct {
+	Path     string `json:"path"`
+	Checksum string `json:"checksum"`
+}
+
+type DiskStats struct {
+	Root       string `json:"root"`
+	Files      int    `json:"files"`
+	UsedBytes  int64  `json:"used_bytes"`
+	FreeBytes  uint64 `json:"free_bytes"`
+	TotalBytes uint64 `json:"total_bytes"`
+}
+
+type StorageDisksResponse struct {
+	Disks []DiskStats `json:"disks"`
+}
+
+/*
+A record in the intent journal. A mutating operation writes a begin record,
+holding what is needed to undo or finish it, before touching the disk and
+a commit record once it is done. Operations begun but never committed were
+cut short by a crash and are rolled back or completed at startup.
+*/
+type IntentRecord struct {
+	Seq    int64  `json:"seq"`
+	State  string `json:"state"`
+	Op     string `json:"op,omitempty"`     // API endpoint of the operation
+	Path   string `json:"path,omitempty"`   // Location of the file on disk
+	Offset int64  `json:"offset,omitempty"` // Write: where the data goes
+	Undo   string `json:"undo,omitempty"`   // Write: base64 of the bytes overwritten
+	Size   int64  `json:"size,omitempty"`   // Write: size of the file before writing
+	Temp   string `json:"temp,omitempty"`   // Copy: temporary file the copy is written to
+}
+
+/*
+Settings of the optional write buffer, given as arguments after the storage root:
+buffer=<bytes> enables it, buffer_ms=<ms> and durability=flush|buffer tune it.
+
+Sequential writes to a file are coalesced and written to disk together, with
+a single sync, once size bytes are buffered, once the oldest has waited for
+delay, when a write to another offset of the file arrives, or before any other
+operation on the file, a fence (a lock being granted or released) included.
+With durability=flush, a write is only acknowledged once it is on disk, so
+concurrent writers share a group commit. With durability=buffer, it is
+acknowledged as soon as it is buffered, and lost if the server crashes first.
+*/
+type WriteBufferSettings struct {
+	Size    int           // Bytes buffered per file before flushing, 0 disables the buffer
+	Delay   time.Duration // Longest time a write stays buffered
+	Durable bool          // Acknowledge writes once flushed rather than once buffered
+}
+
+/* Sequential writes to a file, waiting to be written to disk together */
+type writeBuffer struct {
+	path    string // Location of the file on disk
+	offset  int64
+	data    []byte
+	waiters []chan bool // Durable writes waiting to learn if they reached the disk
+	timer   *time.Timer
+}
+
+type StorageFenceRequest struct {
+	Path         string `json:"path"`
+	FencingToken int64  `json:"fencing_token"`
+}
+
+type StorageFenceResponse struct {
+	Success bool `json:"success"`
+}
+
+/*
+Decode the JSON body of r into req. Unknown fields, fields of the wrong type
+and bodies that leave out one of the required fields are rejected with
+400 Bad Request and an IllegalArgumentException saying what is wrong,
+instead of going ahead with zero values. Returns false if the body was rejected.
+*/
+func DecodeRequest(w http.ResponseWriter, r *http.Request, req interface{}, required ...string) bool {
+	decode_err := decodeStrict(r.Body, req, required)
+	if decode_err == nil {
+		return true
+	}
+
+	fmt.Fprintf(&STORAGE_OUT, "Storage: Decoding Error: %v\n", decode_err)
+	response := ExceptionResponse{
+		ExceptionType: "IllegalArgumentException",
+		ExceptionInfo: "Invalid request body: " + decode_err.Error(),
+	}
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(response)
+	fmt.Fprintln(&STORAGE_OUT, "Storage Response:", response)
+	return false
+}
+
+func decodeStrict(body io.Reader, req interface{}, required []string) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return fmt.Errorf("not a JSON object: %v", err)
+	}
+	for _, field := range required {
+		if value, ok := fields[field]; !ok || string(value) == "null" {
+			return fmt.Errorf("missing required field %q", field)
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(req)
+}
+
 func (storageServer *StorageServer) HandleInvalidRequestParams(
 	w http.ResponseWriter,
 	r *http.Request,
@@ -133,7 +304,7 @@ func (storageServer *StorageServer) HandleInvalidRequestParams(
 		return true
 	}
 
-	filePath := filepath.Join(storageServer.root, path)
+	filePath := storageServer.FilePath(path)
 	fileInfo, err := os.Stat(filePath)
 
 	create := API == STORAGE_CREATE_API_ENDPOINT
@@ -171,20 +342,22 @@ func (storageServer *StorageServer) HandleInvalidRequestParams(
 
 func (storageServer *StorageServer) HandleStorageSizeRequest(w http.ResponseWriter, r *http.Request) {
 	var req StorageSizeRequest
-	decode_err := json.NewDecoder(r.Body).Decode(&req)
-	if decode_err != nil {
-		fmt.Fprintf(&STORAGE_OUT, "Storage: Decoding Error: %v\n", decode_err)
+	if !DecodeRequest(w, r, &req, "path") {
+		return
 	}
 
+	// Buffered writes to the file come first
+	storageServer.FlushPath(req.Path)
+
 	invalidRequestParams := storageServer.HandleInvalidRequestParams(w, r, req.Path, 0, 0, STORAGE_SIZE_API_ENDPOINT)
 
 	if invalidRequestParams {
 		return
 	}
 
-	filePath := filepath.Join(storageServer.root, req.Path)
+	filePath := storageServer.FilePath(req.Path)
 	fileInfo, _ := os.Stat(filePath)
-	fmt.Fprintln(&STORAGE_OUT, "Client Requested File Information for : %v\n", filePath)
+	fmt.Fprintf(&STORAGE_OUT, "Client Requested File Information for : %v\n", filePath)
 
 	/* Return the size of the valid file */
 	response := StorageSizeResponse{
@@ -197,10 +370,12 @@ func (storageServer *StorageServer) HandleStorageSizeRequest(w http.ResponseWrit
 
 func (storageServer *StorageServer) HandleStorageReadRequest(w http.ResponseWriter, r *http.Request) {
 	var req StorageReadRequest
-	decode_err := json.NewDecoder(r.Body).Decode(&req)
-	if decode_err != nil {
-		fmt.Fprintf(&STORAGE_OUT, "Storage: Decoding Error: %v\n", decode_err)
+	if !DecodeRequest(w, r, &req, "path", "offset", "length") {
+		return
 	}
+
+	// Buffered writes to the file come first
+	storageServer.FlushPath(req.Path)
 	fmt.Fprintf(&STORAGE_OUT, "Storage: New SR Request: %v\n", req)
 	invalidRequestParams := storageServer.HandleInvalidRequestParams(w, r, req.Path, req.Offset, req.Length, STORAGE_READ_API_ENDPOINT)
 
@@ -208,7 +383,7 @@ func (storageServer *StorageServer) HandleStorageReadRequest(w http.ResponseWrit
 		return
 	}
 
-	filePath := filepath.Join(storageServer.root, req.Path)
+	filePath := storageServer.FilePath(req.Path)
 
 	/* Return the contents of the valid file */
 	data, read_err := os.ReadFile(filePath)
@@ -231,9 +406,8 @@ func (storageServer *StorageServer) HandleStorageReadRequest(w http.ResponseWrit
 
 func (storageServer *StorageServer) HandleStorageWriteRequest(w http.ResponseWriter, r *http.Request) {
 	var req StorageWriteRequest
-	decode_err := json.NewDecoder(r.Body).Decode(&req)
-	if decode_err != nil {
-		fmt.Fprintf(&STORAGE_OUT, "Storage: Decoding Error: %v\n", decode_err)
+	if !DecodeRequest(w, r, &req, "path", "offset", "data") {
+		return
 	}
 
 	invalidRequestParams := storageServer.HandleInvalidRequestParams(w, r, req.Path, req.Offset, 0, STORAGE_WRITE_API_ENDPOINT)
@@ -242,10 +416,45 @@ func (storageServer *StorageServer) HandleStorageWriteRequest(w http.ResponseWri
 		return
 	}
 
-	filePath := filepath.Join(storageServer.root, req.Path)
+	/* Reject writes that do not hold the current exclusive lock on the file */
+	if !storageServer.CheckFence(req.Path, req.FencingToken) {
+		response := ExceptionResponse{
+			ExceptionType: "IllegalStateException",
+			ExceptionInfo: "Missing or stale fencing token supplied in Storage Write Request",
+		}
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(response)
+		fmt.Fprintln(&STORAGE_OUT, "Storage Response:", response)
+		return
+	}
+
+	filePath := storageServer.FilePath(req.Path)
 
 	response := StorageWriteResponse{}
 
+	fmt.Fprintf(&STORAGE_OUT, "Storage: Request Body: %v\n", req)
+
+	base64RequestString, encoding_err := base64.StdEncoding.DecodeString(req.Data)
+	if encoding_err != nil {
+		fmt.Fprintf(&STORAGE_OUT, "Storage: Error Encoding Base64 String %v\n", encoding_err)
+		return
+	}
+
+	data := []byte(base64RequestString)
+
+	/* Leave small writes to the write buffer, which writes them to disk together */
+	if storageServer.writeBuffer.Size > 0 {
+		response.Success = <-storageServer.BufferWrite(filePath, int64(req.Offset), data)
+
+		json.NewEncoder(w).Encode(response)
+		fmt.Fprintln(&STORAGE_OUT, "Storage Write Response:", response)
+		return
+	}
+
+	/* Keep the bytes about to be overwritten, so a write cut short by a crash can be undone */
+	intent := storageServer.BeginIntent(WriteIntent(filePath, int64(req.Offset), len(data)))
+	defer storageServer.CommitIntent(intent)
+
 	/* Open the file */
 	file, open_err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE, FILE_PERMISSIONS)
 
@@ -262,22 +471,15 @@ func (storageServer *StorageServer) HandleStorageWriteRequest(w http.ResponseWri
 	}
 
 	/* Write the contents of the request to the valid file */
-	fmt.Fprintf(&STORAGE_OUT, "Storage: Request Body: %v\n", req)
-
-	base64RequestString, encoding_err := base64.StdEncoding.DecodeString(req.Data)
-	if encoding_err != nil {
-		fmt.Fprintf(&STORAGE_OUT, "Storage: Error Encoding Base64 String %v\n", encoding_err)
-		return
-	}
-
-	data := []byte(base64RequestString)
-
 	_, write_err = file.Write(data)
 	if write_err != nil {
 		fmt.Fprintf(&STORAGE_OUT, "Storage: Error Writing Contents to File: %v\n", write_err)
 		response.Success = false
 	}
 
+	// The write must be on disk before its intent is committed
+	file.Sync()
+
 	response.Success = true
 
 	json.NewEncoder(w).Encode(response)
@@ -288,9 +490,8 @@ func (storageServer *StorageServer) HandleStorageWriteRequest(w http.ResponseWri
 
 func (storageServer *StorageServer) HandleStorageCreateRequest(w http.ResponseWriter, r *http.Request) {
 	var req StorageCreateRequest
-	decode_err := json.NewDecoder(r.Body).Decode(&req)
-	if decode_err != nil {
-		fmt.Fprintf(&STORAGE_OUT, "Storage: Decoding Error: %v\n", decode_err)
+	if !DecodeRequest(w, r, &req, "path") {
+		return
 	}
 	invalidRequestParams := storageServer.HandleInvalidRequestParams(w, r, req.Path, 0, 0, STORAGE_CREATE_API_ENDPOINT)
 
@@ -298,7 +499,7 @@ func (storageServer *StorageServer) HandleStorageCreateRequest(w http.ResponseWr
 		return
 	}
 
-	filePath := filepath.Join(storageServer.root, req.Path)
+	filePath := storageServer.FilePath(req.Path)
 	_, err := os.Stat(filePath)
 
 	/* Create a new file */
@@ -308,12 +509,17 @@ func (storageServer *StorageServer) HandleStorageCreateRequest(w http.ResponseWr
 		fmt.Fprintf(&STORAGE_OUT, "Storage: Error Creating New File: File with same name already exists\n")
 		response.Success = false
 	} else {
+		/* New files go to the disk with the most free space */
+		root := storageServer.ChooseDisk()
+		filePath = filepath.Join(root, req.Path)
+
 		/* Create all the directories in the path */
 		mkdir_err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
 		if mkdir_err != nil {
 			fmt.Fprintf(&STORAGE_OUT, "Storage: Error Creating New Directories: %v\n", mkdir_err)
 			response.Success = false
 		} else {
+			intent := storageServer.BeginIntent(IntentRecord{Op: STORAGE_CREATE_API_ENDPOINT, Path: filePath})
 			file, create_err := os.Create(filePath)
 			if create_err != nil {
 				fmt.Fprintf(&STORAGE_OUT, "Storage: Error Creating New File: %v\n", create_err)
@@ -321,7 +527,9 @@ func (storageServer *StorageServer) HandleStorageCreateRequest(w http.ResponseWr
 			} else {
 				response.Success = true
 				file.Close()
+				storageServer.MapFile(req.Path, root)
 			}
+			storageServer.CommitIntent(intent)
 		}
 	}
 
@@ -333,10 +541,12 @@ func (storageServer *StorageServer) HandleStorageCreateRequest(w http.ResponseWr
 
 func (storageServer *StorageServer) HandleStorageDeleteRequest(w http.ResponseWriter, r *http.Request) {
 	var req StorageDeleteRequest
-	decode_err := json.NewDecoder(r.Body).Decode(&req)
-	if decode_err != nil {
-		fmt.Fprintf(&STORAGE_OUT, "Storage: Decoding Error: %v\n", decode_err)
+	if !DecodeRequest(w, r, &req, "path") {
+		return
 	}
+
+	// Buffered writes to the file come first
+	storageServer.FlushPath(req.Path)
 	fmt.Fprintf(&STORAGE_OUT, "Storage: New Delete Request: %v\n", req)
 	invalidRequestParams := storageServer.HandleInvalidRequestParams(w, r, req.Path, 0, 0, STORAGE_DELETE_API_ENDPOINT)
 
@@ -344,7 +554,7 @@ func (storageServer *StorageServer) HandleStorageDeleteRequest(w http.ResponseWr
 		return
 	}
 
-	filePath := filepath.Join(storageServer.root, req.Path)
+	filePath := storageServer.FilePath(req.Path)
 	fileInfo, _ := os.Stat(filePath)
 
 	/* Remove the file */
@@ -352,11 +562,32 @@ func (storageServer *StorageServer) HandleStorageDeleteRequest(w http.ResponseWr
 
 	remove_err := errors.New("")
 
-	if fileInfo.IsDir() && filePath != storageServer.root {
-		remove_err = os.RemoveAll(filePath)
+	if fileInfo.IsDir() {
+		/* A directory may have parts on every disk */
+		remove_err = nil
+		for _, root := range storageServer.roots {
+			dirPath := filepath.Join(root, req.Path)
+			if _, err := os.Stat(dirPath); os.IsNotExist(err) {
+				continue
+			}
+			var err error
+			intent := storageServer.BeginIntent(IntentRecord{Op: STORAGE_DELETE_API_ENDPOINT, Path: dirPath})
+			if dirPath != root {
+				err = os.RemoveAll(dirPath)
+			} else {
+				err = os.Remove(dirPath)
+			}
+			storageServer.CommitIntent(intent)
+			if err != nil {
+				remove_err = err
+			}
+		}
 	} else {
+		intent := storageServer.BeginIntent(IntentRecord{Op: STORAGE_DELETE_API_ENDPOINT, Path: filePath})
 		remove_err = os.Remove(filePath)
+		storageServer.CommitIntent(intent)
 	}
+	storageServer.UnmapFiles(req.Path)
 
 	if remove_err != nil {
 		fmt.Fprintf(&STORAGE_OUT, "Storage: Error Deleting File: %v\n", remove_err)
@@ -374,10 +605,12 @@ func (storageServer *StorageServer) HandleStorageDeleteRequest(w http.ResponseWr
 
 func (storageServer *StorageServer) HandleStorageCopyRequest(w http.ResponseWriter, r *http.Request) {
 	var req StorageCopyRequest
-	decode_err := json.NewDecoder(r.Body).Decode(&req)
-	if decode_err != nil {
-		fmt.Fprintf(&STORAGE_OUT, "Storage: Decoding Error: %v\n", decode_err)
+	if !DecodeRequest(w, r, &req, "path", "server_ip", "server_port") {
+		return
 	}
+
+	// Buffered writes to the file come first
+	storageServer.FlushPath(req.Path)
 	fmt.Fprintf(&STORAGE_OUT, "Storage: New Copy Request: %v\n", req)
 	invalidRequestParams := storageServer.HandleInvalidRequestParams(w, r, req.Path, 0, 0, STORAGE_COPY_API_ENDPOINT)
 
@@ -531,54 +764,41 @@ func (storageServer *StorageServer) HandleStorageCopyRequest(w http.ResponseWrit
 			fmt.Fprintf(&STORAGE_OUT, "Storage: Response Normal String: %v\n", normalString)
 
 			/* Create the file and all directories leading to it. Overwriting the file if it exists */
-			filePath := filepath.Join(storageServer.root, req.Path)
-			_, fileErr := os.Stat(filePath)
-
-			if !os.IsNotExist(fileErr) {
-				fmt.Fprintf(&STORAGE_OUT, "Storage: File %v already existed, removing ... :\n", filePath)
-				os.Remove(filePath)
+			root, found := storageServer.DiskOf(req.Path)
+			if !found {
+				root = storageServer.ChooseDisk()
 			}
-
-			storageServer.RecursivelyDeleteEmptyDirs()
-
+			filePath := filepath.Join(root, req.Path)
 			mkdir_err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
 			if mkdir_err != nil {
 				fmt.Fprintf(&STORAGE_OUT, "Storage: Error Creating New Directories: %v\n", mkdir_err)
 				w.WriteHeader(http.StatusNotFound)
 				json.NewEncoder(w).Encode(response)
-			} else {
-				file, file_create_err := os.Create(filePath)
-				if file_create_err != nil {
-					fmt.Fprintf(&STORAGE_OUT, "Storage: Error Creating New File: %v\n", file_create_err)
-					w.WriteHeader(http.StatusNotFound)
-					json.NewEncoder(w).Encode(response)
-				} else {
-					file.Close()
-				}
+				return
 			}
 
-			/* Open the file */
+			/*
+				Write the copy next to the file, then rename it over the file.
+				A crash half way leaves the old file as it was, and the
+				journal says which temporary file to clean up.
+			*/
 			fmt.Fprintf(&STORAGE_OUT, "Storage: Creating/Overwriting File %v\n", filePath)
+			tempPath := filePath + COPY_TEMP_SUFFIX
+			intent := storageServer.BeginIntent(IntentRecord{Op: STORAGE_COPY_API_ENDPOINT, Path: filePath, Temp: tempPath})
+			defer storageServer.CommitIntent(intent)
 
-			file, open_err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE, 0644)
-
-			/* Write the contents to the file */
-			if open_err != nil {
-				fmt.Fprintf(&STORAGE_OUT, "Storage: Error Opening File: %v\n", open_err)
-				w.WriteHeader(http.StatusNotFound)
-				json.NewEncoder(w).Encode(response)
-				return
+			write_err := WriteFileSynced(tempPath, []byte(normalString))
+			if write_err == nil {
+				write_err = os.Rename(tempPath, filePath)
 			}
-
-			data := []byte(normalString)
-
-			_, write_err := file.Write(data)
 			if write_err != nil {
 				fmt.Fprintf(&STORAGE_OUT, "Storage: Error Writing Contents to File: %v\n", write_err)
+				os.Remove(tempPath)
 				w.WriteHeader(http.StatusNotFound)
 				json.NewEncoder(w).Encode(response)
 				return
 			}
+			storageServer.MapFile(req.Path, root)
 		}
 	}
 	response.Success = true
@@ -587,6 +807,271 @@ func (storageServer *StorageServer) HandleStorageCopyRequest(w http.ResponseWrit
 	return
 }
 
+/* Return the SHA-256 checksum of a file's contents, so replicas can be compared */
+func (storageServer *StorageServer) HandleStorageChecksumRequest(w http.ResponseWriter, r *http.Request) {
+	var req StorageChecksumRequest
+	if !DecodeRequest(w, r, &req, "path") {
+		return
+	}
+
+	// Buffered writes to the file come first
+	storageServer.FlushPath(req.Path)
+
+	invalidRequestParams := storageServer.HandleInvalidRequestParams(w, r, req.Path, 0, 0, STORAGE_CHECKSUM_API_ENDPOINT)
+
+	if invalidRequestParams {
+		return
+	}
+
+	filePath := storageServer.FilePath(req.Path)
+
+	data, read_err := os.ReadFile(filePath)
+	if read_err != nil {
+		fmt.Fprintf(&STORAGE_OUT, "Storage: Error Reading Contents from File: %v\n", read_err)
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ExceptionResponse{ExceptionType: "IOException", ExceptionInfo: read_err.Error()})
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	response := StorageChecksumResponse{
+		Path:     req.Path,
+		Checksum: hex.EncodeToString(sum[:]),
+	}
+
+	json.NewEncoder(w).Encode(response)
+	fmt.Fprintln(&STORAGE_OUT, "Storage Checksum Response:", response)
+}
+
+/*
+Returns true if a write to path presenting token may go ahead.
+Files that were never fenced by the Naming Server accept any write,
+fenced files only accept their newest token.
+*/
+func (storageServer *StorageServer) CheckFence(path string, token int64) bool {
+	storageServer.fences_mu.Lock()
+	defer storageServer.fences_mu.Unlock()
+
+	fence, fenced := storageServer.fences[path]
+	return !fenced || token == fence
+}
+
+/* Record the newest fencing token for a file, as directed by Naming Server */
+func (storageServer *StorageServer) HandleStorageFenceRequest(w http.ResponseWriter, r *http.Request) {
+	var req StorageFenceRequest
+	if !DecodeRequest(w, r, &req, "path", "fencing_token") {
+		return
+	}
+
+	// Buffered writes to the file come first
+	storageServer.FlushPath(req.Path)
+	fmt.Fprintf(&STORAGE_OUT, "Storage: New Fence Request: %v\n", req)
+
+	response := StorageFenceResponse{}
+
+	/* Tokens only move forward, a late or replayed fence is ignored */
+	storageServer.fences_mu.Lock()
+	if req.FencingToken > storageServer.fences[req.Path] {
+		storageServer.fences[req.Path] = req.FencingToken
+		response.Success = true
+	}
+	storageServer.fences_mu.Unlock()
+
+	json.NewEncoder(w).Encode(response)
+	fmt.Fprintln(&STORAGE_OUT, "Storage Fence Response:", response)
+}
+
+/*
+Returns the disk holding path, searching every disk when the path has not
+been mapped (directories, or files placed by an older run). Paths that are
+on no disk resolve to the primary root.
+*/
+func (storageServer *StorageServer) DiskOf(path string) (string, bool) {
+	path = filepath.Join("/", path)
+
+	storageServer.disks_mu.Lock()
+	root, mapped := storageServer.disks[path]
+	storageServer.disks_mu.Unlock()
+	if mapped {
+		return root, true
+	}
+
+	for _, root := range storageServer.roots {
+		if _, err := os.Stat(filepath.Join(root, path)); err == nil {
+			return root, true
+		}
+	}
+	return storageServer.root, false
+}
+
+/* Location of path on the disk holding it */
+func (storageServer *StorageServer) FilePath(path string) string {
+	root, _ := storageServer.DiskOf(path)
+	return filepath.Join(root, path)
+}
+
+/* Free and total bytes of the file system a disk lives on */
+func DiskSpace(root string) (uint64, uint64) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(root, &stat); err != nil {
+		fmt.Fprintf(&STORAGE_OUT, "Storage: Error Reading Disk Stats of %v: %v\n", root, err)
+		return 0, 0
+	}
+	return stat.Bavail * uint64(stat.Bsize), stat.Blocks * uint64(stat.Bsize)
+}
+
+/* Pick the disk with the most free space for a new file, the primary root on a tie */
+func (storageServer *StorageServer) ChooseDisk() string {
+	best := storageServer.root
+	if len(storageServer.roots) == 1 {
+		return best
+	}
+
+	bestFree, _ := DiskSpace(best)
+	for _, root := range storageServer.roots[1:] {
+		if free, _ := DiskSpace(root); free > bestFree {
+			best, bestFree = root, free
+		}
+	}
+	return best
+}
+
+/* Record that path is stored on root */
+func (storageServer *StorageServer) MapFile(path string, root string) {
+	storageServer.disks_mu.Lock()
+	defer storageServer.disks_mu.Unlock()
+
+	storageServer.disks[filepath.Join("/", path)] = root
+	storageServer.SaveDisks()
+}
+
+/* Forget path and everything below it, after it was deleted */
+func (storageServer *StorageServer) UnmapFiles(path string) {
+	storageServer.disks_mu.Lock()
+	defer storageServer.disks_mu.Unlock()
+
+	path = filepath.Join("/", path)
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	for file := range storageServer.disks {
+		if file == path || strings.HasPrefix(file, prefix) {
+			delete(storageServer.disks, file)
+		}
+	}
+	storageServer.SaveDisks()
+}
+
+/*
+Write the path-to-disk map to disk. With a single root the map is implied,
+so nothing is written. Callers must hold disks_mu.
+*/
+func (storageServer *StorageServer) SaveDisks() {
+	if len(storageServer.roots) == 1 {
+		return
+	}
+
+	payload, err := json.Marshal(storageServer.disks)
+	if err != nil {
+		fmt.Fprintf(&STORAGE_OUT, "Storage: Error Encoding JSON: %v\n", err)
+		return
+	}
+
+	// Write then rename, so a crash never leaves a half written map behind
+	tmpFile := storageServer.disksFile + ".tmp"
+	if err := os.WriteFile(tmpFile, payload, FILE_PERMISSIONS); err != nil {
+		fmt.Fprintf(&STORAGE_OUT, "Storage: Error Saving Disk Map: %v\n", err)
+		return
+	}
+	if err := os.Rename(tmpFile, storageServer.disksFile); err != nil {
+		fmt.Fprintf(&STORAGE_OUT, "Storage: Error Saving Disk Map: %v\n", err)
+	}
+}
+
+/*
+Load the saved path-to-disk map and reconcile it with what is on the disks.
+A file found on a disk other than the one it is mapped to is a leftover copy
+(e.g. from an interrupted copy) and is ignored while the mapped one exists.
+Returns every file the server holds.
+*/
+func (storageServer *StorageServer) ScanDisks() []string {
+	storageServer.disks_mu.Lock()
+	defer storageServer.disks_mu.Unlock()
+
+	saved := map[string]string{}
+	if data, err := os.ReadFile(storageServer.disksFile); err == nil {
+		if decode_err := json.Unmarshal(data, &saved); decode_err != nil {
+			fmt.Fprintf(&STORAGE_OUT, "Storage: Decoding Error: %v\n", decode_err)
+		}
+	}
+
+	storageServer.disks = map[string]string{}
+	for _, root := range storageServer.roots {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			relPath, err := filepath.Rel(root, path)
+			if err != nil {
+				fmt.Fprintf(&STORAGE_OUT, "Storage: Error finding the rel path of file %v\n", path)
+				return nil
+			}
+			relPath = fmt.Sprintf("/%v", relPath)
+			fmt.Fprintf(&STORAGE_OUT, "Found File : %v\n", path)
+
+			if savedRoot, ok := saved[relPath]; ok && savedRoot != root {
+				if _, err := os.Stat(filepath.Join(savedRoot, relPath)); err == nil {
+					fmt.Fprintf(&STORAGE_OUT, "Storage: Ignoring leftover copy %v, file is on %v\n", path, savedRoot)
+					return nil
+				}
+			}
+			if _, ok := storageServer.disks[relPath]; !ok {
+				storageServer.disks[relPath] = root
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(&STORAGE_OUT, "Error in Path Walk: %v\n", err)
+		}
+	}
+	storageServer.SaveDisks()
+
+	fileList := []string{}
+	for file := range storageServer.disks {
+		fileList = append(fileList, file)
+	}
+	sort.Strings(fileList)
+	return fileList
+}
+
+/* Report how many files and bytes each disk holds, and how much space it has left */
+func (storageServer *StorageServer) HandleStorageDisksRequest(w http.ResponseWriter, r *http.Request) {
+	response := StorageDisksResponse{Disks: []DiskStats{}}
+	for _, root := range storageServer.roots {
+		stats := DiskStats{Root: root}
+		stats.FreeBytes, stats.TotalBytes = DiskSpace(root)
+		response.Disks = append(response.Disks, stats)
+	}
+
+	storageServer.disks_mu.Lock()
+	for file, root := range storageServer.disks {
+		for i := range response.Disks {
+			if response.Disks[i].Root != root {
+				continue
+			}
+			response.Disks[i].Files++
+			if info, err := os.Stat(filepath.Join(root, file)); err == nil {
+				response.Disks[i].UsedBytes += info.Size()
+			}
+		}
+	}
+	storageServer.disks_mu.Unlock()
+
+	json.NewEncoder(w).Encode(response)
+	fmt.Fprintln(&STORAGE_OUT, "Storage Disks Response:", response)
+}
+
 func (storageServer *StorageServer) HandleHTTPRequest(w http.ResponseWriter, r *http.Request) {
 	switch r.RequestURI {
 	case STORAGE_SIZE_API_ENDPOINT:
@@ -601,6 +1086,10 @@ func (storageServer *StorageServer) HandleHTTPRequest(w http.ResponseWriter, r *
 		storageServer.HandleStorageDeleteRequest(w, r)
 	case STORAGE_COPY_API_ENDPOINT:
 		storageServer.HandleStorageCopyRequest(w, r)
+	case STORAGE_CHECKSUM_API_ENDPOINT:
+		storageServer.HandleStorageChecksumRequest(w, r)
+	case STORAGE_DISKS_API_ENDPOINT:
+		storageServer.HandleStorageDisksRequest(w, r)
 	default:
 		return
 	}
@@ -624,18 +1113,21 @@ func (storageServer *StorageServer) RecursivelyDeleteEmptyDirs() {
 	var emptyDirs []string
 
 	// Print the names of the files
-	filepath.Walk(storageServer.root, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			empty := isDirEmpty(path)
-			if empty {
-				emptyDirs = append(emptyDirs, path)
+	for _, root := range storageServer.roots {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
 			}
-		}
-		return nil
-	})
+			// Extra disks keep their root directory, so they can still be measured for placement
+			if info.IsDir() && (path != root || root == storageServer.root) {
+				empty := isDirEmpty(path)
+				if empty {
+					emptyDirs = append(emptyDirs, path)
+				}
+			}
+			return nil
+		})
+	}
 
 	fmt.Fprintf(&STORAGE_OUT, "Empty Directory List : %v\n", emptyDirs)
 
@@ -657,14 +1149,278 @@ func (storageServer *StorageServer) RecursivelyDeleteEmptyDirs() {
 
 }
 
+/* Write data to a new file at path and flush it to disk */
+func WriteFileSynced(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, FILE_PERMISSIONS)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+/* Intent to write length bytes at offset of the file at path, keeping what they overwrite */
+func WriteIntent(path string, offset int64, length int) IntentRecord {
+	intent := IntentRecord{Op: STORAGE_WRITE_API_ENDPOINT, Path: path, Offset: offset}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return intent
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return intent
+	}
+	intent.Size = info.Size()
+
+	if offset < intent.Size {
+		undo := make([]byte, min(int64(length), intent.Size-offset))
+		n, _ := file.ReadAt(undo, offset)
+		intent.Undo = base64.StdEncoding.EncodeToString(undo[:n])
+	}
+	return intent
+}
+
+/* Append a record to the intent journal and flush it to disk. Callers must hold intents_mu. */
+func (storageServer *StorageServer) appendIntent(intent IntentRecord) {
+	line, err := json.Marshal(intent)
+	if err != nil {
+		fmt.Fprintf(&STORAGE_OUT, "Storage: Error Encoding JSON: %v\n", err)
+		return
+	}
+	if _, err := storageServer.intents.Write(append(line, '\n')); err != nil {
+		fmt.Fprintf(&STORAGE_OUT, "Storage: Error Writing Intent Journal: %v\n", err)
+		return
+	}
+	storageServer.intents.Sync()
+}
+
+/* Record that an operation is about to change the disk. Returns its sequence number. */
+func (storageServer *StorageServer) BeginIntent(intent IntentRecord) int64 {
+	storageServer.intents_mu.Lock()
+	defer storageServer.intents_mu.Unlock()
+
+	if storageServer.intents == nil {
+		return 0
+	}
+
+	storageServer.intents_seq++
+	intent.Seq = storageServer.intents_seq
+	intent.State = INTENT_BEGIN
+	storageServer.appendIntent(intent)
+	storageServer.intents_pending++
+
+	return intent.Seq
+}
+
+/*
+Record that the operation begun as seq is done. Once no operation is in
+progress, nothing needs recovering and the journal is emptied.
+*/
+func (storageServer *StorageServer) CommitIntent(seq int64) {
+	storageServer.intents_mu.Lock()
+	defer storageServer.intents_mu.Unlock()
+
+	if storageServer.intents == nil {
+		return
+	}
+
+	storageServer.appendIntent(IntentRecord{Seq: seq, State: INTENT_COMMIT})
+	storageServer.intents_pending--
+
+	if storageServer.intents_pending == 0 {
+		storageServer.intents.Truncate(0)
+		storageServer.intents.Seek(0, io.SeekStart)
+	}
+}
+
+/*
+Buffer data to be written at offset of the file at path. Returns a channel
+receiving whether the write succeeded, as soon as it is buffered or, with
+durability=flush, once it is on disk.
+*/
+func (storageServer *StorageServer) BufferWrite(path string, offset int64, data []byte) chan bool {
+	storageServer.buffers_mu.Lock()
+	defer storageServer.buffers_mu.Unlock()
+
+	// Only sequential writes are coalesced
+	buffer, buffered := storageServer.buffers[path]
+	if buffered && offset != buffer.offset+int64(len(buffer.data)) {
+		storageServer.flushBuffer(buffer)
+		buffered = false
+	}
+	if !buffered {
+		buffer = &writeBuffer{path: path, offset: offset}
+		buffer.timer = time.AfterFunc(storageServer.writeBuffer.Delay, func() { storageServer.FlushBuffer(path) })
+		storageServer.buffers[path] = buffer
+	}
+
+	buffer.data = append(buffer.data, data...)
+	done := make(chan bool, 1)
+	if storageServer.writeBuffer.Durable {
+		buffer.waiters = append(buffer.waiters, done)
+	} else {
+		done <- true
+	}
+
+	if len(buffer.data) >= storageServer.writeBuffer.Size {
+		storageServer.flushBuffer(buffer)
+	}
+	return done
+}
+
+/* Write the buffered writes to the file at path to disk, if there are any */
+func (storageServer *StorageServer) FlushBuffer(path string) {
+	storageServer.buffers_mu.Lock()
+	defer storageServer.buffers_mu.Unlock()
+
+	if buffer, buffered := storageServer.buffers[path]; buffered {
+		storageServer.flushBuffer(buffer)
+	}
+}
+
+/* Write the buffered writes to path, or to any file under it, to disk */
+func (storageServer *StorageServer) FlushPath(path string) {
+	filePath := storageServer.FilePath(path)
+
+	storageServer.buffers_mu.Lock()
+	defer storageServer.buffers_mu.Unlock()
+
+	for bufferPath, buffer := range storageServer.buffers {
+		if bufferPath == filePath || strings.HasPrefix(bufferPath, filePath+"/") {
+			storageServer.flushBuffer(buffer)
+		}
+	}
+}
+
+/*
+Write a buffer to disk as a single journaled write, then tell its waiters
+how it went. Callers must hold buffers_mu, which keeps the writes to a
+file in order.
+*/
+func (storageServer *StorageServer) flushBuffer(buffer *writeBuffer) {
+	delete(storageServer.buffers, buffer.path)
+	buffer.timer.Stop()
+
+	intent := storageServer.BeginIntent(WriteIntent(buffer.path, buffer.offset, len(buffer.data)))
+	defer storageServer.CommitIntent(intent)
+
+	success := false
+	file, err := os.OpenFile(buffer.path, os.O_WRONLY|os.O_CREATE, FILE_PERMISSIONS)
+	if err == nil {
+		if _, err = file.WriteAt(buffer.data, buffer.offset); err == nil {
+			err = file.Sync()
+		}
+		file.Close()
+		success = err == nil
+	}
+	if err != nil {
+		fmt.Fprintf(&STORAGE_OUT, "Storage: Error Flushing Write Buffer of %v: %v\n", buffer.path, err)
+	}
+
+	for _, waiter := range buffer.waiters {
+		waiter <- success
+	}
+}
+
+/* Undo or finish an operation that was cut short */
+func RecoverIntent(intent IntentRecord) {
+	fmt.Fprintf(&STORAGE_OUT, "Storage: Recovering interrupted operation %v\n", intent)
+
+	var err error
+	switch intent.Op {
+	case STORAGE_CREATE_API_ENDPOINT:
+		// The file did not exist before, and its creation was never reported
+		err = os.Remove(intent.Path)
+
+	case STORAGE_DELETE_API_ENDPOINT:
+		// Finish the delete, a half deleted directory is no use to anyone
+		err = os.RemoveAll(intent.Path)
+
+	case STORAGE_WRITE_API_ENDPOINT:
+		// Put back the overwritten bytes and cut off anything appended
+		undo, decode_err := base64.StdEncoding.DecodeString(intent.Undo)
+		if decode_err != nil {
+			err = decode_err
+			break
+		}
+		file, open_err := os.OpenFile(intent.Path, os.O_WRONLY, FILE_PERMISSIONS)
+		if open_err != nil {
+			err = open_err
+			break
+		}
+		if _, err = file.WriteAt(undo, intent.Offset); err == nil {
+			err = file.Truncate(intent.Size)
+		}
+		file.Close()
+
+	case STORAGE_COPY_API_ENDPOINT:
+		// Either the copy replaced the file or the old file is intact
+		err = os.Remove(intent.Temp)
+	}
+
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(&STORAGE_OUT, "Storage: Error Recovering %v: %v\n", intent, err)
+	}
+}
+
+/*
+Open the intent journal, recovering every operation that was begun but
+not committed before the server last stopped. Must run before the
+server registers, so it only offers files in a known state.
+*/
+func (storageServer *StorageServer) RecoverIntents() {
+	pending := map[int64]IntentRecord{}
+	if data, err := os.ReadFile(storageServer.intentsFile); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			var intent IntentRecord
+			// A torn last line was never committed, so skipping it is safe
+			if line == "" || json.Unmarshal([]byte(line), &intent) != nil {
+				continue
+			}
+			if intent.State == INTENT_BEGIN {
+				pending[intent.Seq] = intent
+			} else {
+				delete(pending, intent.Seq)
+			}
+		}
+	}
+
+	// Recover in the order the operations began
+	seqs := []int64{}
+	for seq := range pending {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	for _, seq := range seqs {
+		RecoverIntent(pending[seq])
+	}
+	if len(seqs) > 0 {
+		storageServer.RecursivelyDeleteEmptyDirs()
+	}
+
+	intents, err := os.OpenFile(storageServer.intentsFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, FILE_PERMISSIONS)
+	if err != nil {
+		fmt.Fprintf(&STORAGE_OUT, "Storage: Error Opening Intent Journal: %v\n", err)
+		return
+	}
+	storageServer.intents = intents
+}
+
 /* Delete Files as directed by Naming Server */
 func (storageServer *StorageServer) DeleteFiles(fileList FileList) {
-	fmt.Fprintf(&STORAGE_OUT, "Storage: Deleting these file from %v:%v", storageServer.root, fileList)
+	fmt.Fprintf(&STORAGE_OUT, "Storage: Deleting these file from %v:%v", storageServer.roots, fileList)
 	for _, file := range fileList.Files {
-		filePath := filepath.Join(storageServer.root, file)
+		filePath := storageServer.FilePath(file)
 		if _, err := os.Stat(filePath); os.IsNotExist(err) {
 			// File does not exist, handle error or skip
-			fmt.Fprintf(&STORAGE_OUT, "Storage: File %v does on exist on %v", file, storageServer.root)
+			fmt.Fprintf(&STORAGE_OUT, "Storage: File %v does on exist on %v", file, storageServer.roots)
 			continue
 		}
 		err := os.Remove(filePath)
@@ -672,6 +1428,7 @@ func (storageServer *StorageServer) DeleteFiles(fileList FileList) {
 			fmt.Fprintf(&STORAGE_OUT, "Storage: Unable to delete %v: %v", file, err)
 			continue
 		}
+		storageServer.UnmapFiles(file)
 		fmt.Fprintf(&STORAGE_OUT, "Deleted File %v\n", filePath)
 	}
 
@@ -694,37 +1451,20 @@ func (storageServer *StorageServer) Register() {
 		REGISTRATION_API_ENDPOINT,
 	)
 
-	fileList := []string{}
-
-	// Print the names of the files
-	err := filepath.Walk(storageServer.root, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() {
-			relPath, err := filepath.Rel(storageServer.root, path)
-			if err != nil {
-				fmt.Fprintf(&STORAGE_OUT, "Storage: Error finding the rel path of file %v\n", path)
-				return nil
-			}
-			relPath = fmt.Sprintf("/%v", relPath)
-			fileList = append(fileList, relPath)
-			fmt.Fprintf(&STORAGE_OUT, "Found File : %v\n", path)
-		}
-		return nil
-	})
-
-	if err != nil {
-		fmt.Fprintf(&STORAGE_OUT, "Error in Path Walk: %v\n", err)
-	}
+	fileList := storageServer.ScanDisks()
 
 	fmt.Fprintf(&STORAGE_OUT, "Current List of Files : %v\n", fileList)
 
+	// Ports are numbers as per API, so the Naming Server can send us commands
+	clientPort, _ := strconv.Atoi(storageServer.clientPort)
+	commandPort, _ := strconv.Atoi(storageServer.commandPort)
+
 	registerRequest := RegisterRequest{
 		Storage_IP:  STORAGE_IP,
-		ClientPort:  storageServer.clientPort,
-		CommandPort: storageServer.commandPort,
+		ClientPort:  clientPort,
+		CommandPort: commandPort,
 		Files:       fileList,
+		Zone:        storageServer.zone,
 	}
 
 	// Create a GET request to Naming Server
@@ -782,6 +1522,11 @@ func (storageServer *StorageServer) ServeClient(clientListener *net.Listener) {
 func (storageServer *StorageServer) ServeCommand(commandListener *net.Listener) {
 	COMMAND_ADDRESS := "127.0.0.1:" + storageServer.commandPort
 	handler := func(w http.ResponseWriter, r *http.Request) {
+		// Only the Naming Server may fence files, so fences are not served to clients
+		if r.RequestURI == STORAGE_FENCE_API_ENDPOINT {
+			storageServer.HandleStorageFenceRequest(w, r)
+			return
+		}
 		storageServer.HandleHTTPRequest(w, r)
 	}
 	command_err := http.Serve(*commandListener, http.HandlerFunc(handler))
@@ -847,11 +1592,53 @@ func main() {
 
 	STORAGE_ROOT := args[3]
 
+	// Any further arguments are extra disks to spread files across,
+	// except zone=label which places the server in a zone, and the
+	// settings of the write buffer (see WriteBufferSettings)
+	ZONE := ""
+	STORAGE_ROOTS := []string{STORAGE_ROOT}
+	WRITE_BUFFER := WriteBufferSettings{Delay: WRITE_BUFFER_DELAY, Durable: true}
+	for _, arg := range args[4:] {
+		key, value, _ := strings.Cut(arg, "=")
+		switch key {
+		case "zone":
+			ZONE = value
+		case "buffer":
+			WRITE_BUFFER.Size, _ = strconv.Atoi(value)
+		case "buffer_ms":
+			if ms, err := strconv.Atoi(value); err == nil {
+				WRITE_BUFFER.Delay = time.Duration(ms) * time.Millisecond
+			}
+		case "durability":
+			if value != DURABILITY_FLUSH && value != DURABILITY_BUFFER {
+				fmt.Fprintf(&STORAGE_OUT, "Storage: Unknown Durability %v, Using %v\n", value, DURABILITY_FLUSH)
+				continue
+			}
+			WRITE_BUFFER.Durable = value == DURABILITY_FLUSH
+		default:
+			STORAGE_ROOTS = append(STORAGE_ROOTS, arg)
+		}
+	}
+	for _, root := range STORAGE_ROOTS[1:] {
+		if err := os.MkdirAll(root, os.ModePerm); err != nil {
+			fmt.Fprintf(&STORAGE_OUT, "Storage: Error Creating Disk Root %v: %v\n", root, err)
+		}
+	}
+
 	storageServer := &StorageServer{clientPort: CLIENT_PORT,
 		commandPort:      COMMAND_PORT,
 		registrationPort: REGISTRATION_PORT,
 		root:             STORAGE_ROOT,
+		zone:             ZONE,
+		roots:            STORAGE_ROOTS,
+		disks:            map[string]string{},
+		disksFile:        filepath.Clean(STORAGE_ROOT) + ".disks.json",
+		intentsFile:      filepath.Clean(STORAGE_ROOT) + ".journal",
+		fences:           map[string]int64{},
+		writeBuffer:      WRITE_BUFFER,
+		buffers:          map[string]*writeBuffer{},
 	}
+	storageServer.RecoverIntents()
 	storageServer.Register()
 	storageServer.Start()
 }
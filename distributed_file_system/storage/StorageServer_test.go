@@ -0,0 +1,245 @@
+package main
+
+import (
+	"../../testutil"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+Set up a storage server spreading its files across two disks, with its
+path-to-disk map next to the primary root. Logs go to the test's log file,
+shown if it fails.
+*/
+func setupStorageServer(t *testing.T, roots []string, disksFile string) *StorageServer {
+	STORAGE_OUT = *testutil.LogFile(t, "storage")
+
+	return &StorageServer{
+		root:      roots[0],
+		roots:     roots,
+		disks:     map[string]string{},
+		disksFile: disksFile,
+		fences:    map[string]int64{},
+		buffers:   map[string]*writeBuffer{},
+	}
+}
+
+/* Write a file of size bytes at path below root, creating its directories */
+func writeFile(t *testing.T, root string, path string, size int) {
+	filePath := filepath.Join(root, path)
+	if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filePath, []byte(strings.Repeat("x", size)), FILE_PERMISSIONS); err != nil {
+		t.Fatal(err)
+	}
+}
+
+/*
+Files must be found on whichever disk holds them, the path-to-disk map must
+survive a restart and win over a leftover copy on another disk, /storage_disks
+must report each disk, and deleting a directory must clear it off every disk.
+*/
+func TestDisks(t *testing.T) {
+	primary, extra := t.TempDir(), t.TempDir()
+	disksFile := filepath.Join(t.TempDir(), "storage.disks.json")
+	storageServer := setupStorageServer(t, []string{primary, extra}, disksFile)
+
+	writeFile(t, primary, "/directory_a/file_a", 3)
+	writeFile(t, extra, "/directory_a/file_b", 5)
+
+	if files := storageServer.ScanDisks(); !reflect.DeepEqual(files, []string{"/directory_a/file_a", "/directory_a/file_b"}) {
+		t.Fatalf("got files %v, want both disks' files", files)
+	}
+	if got := storageServer.FilePath("/directory_a/file_b"); got != filepath.Join(extra, "/directory_a/file_b") {
+		t.Errorf("got %s for the file on the extra disk", got)
+	}
+
+	// A copy left on the primary disk, e.g. by an interrupted copy, is ignored after a restart
+	writeFile(t, primary, "/directory_a/file_b", 1)
+	storageServer = setupStorageServer(t, []string{primary, extra}, disksFile)
+	if files := storageServer.ScanDisks(); !reflect.DeepEqual(files, []string{"/directory_a/file_a", "/directory_a/file_b"}) {
+		t.Fatalf("got files %v after a restart, want each file once", files)
+	}
+	if root, _ := storageServer.DiskOf("/directory_a/file_b"); root != extra {
+		t.Errorf("got disk %s for the file mapped to the extra disk, want %s", root, extra)
+	}
+
+	var disks StorageDisksResponse
+	rec := httptest.NewRecorder()
+	storageServer.HandleHTTPRequest(rec, httptest.NewRequest("POST", STORAGE_DISKS_API_ENDPOINT, strings.NewReader(`{}`)))
+	if err := json.Unmarshal(rec.Body.Bytes(), &disks); err != nil {
+		t.Fatal(err)
+	}
+	stats := []DiskStats{}
+	for _, disk := range disks.Disks {
+		stats = append(stats, DiskStats{Root: disk.Root, Files: disk.Files, UsedBytes: disk.UsedBytes})
+		if disk.TotalBytes == 0 {
+			t.Errorf("%s: no total bytes reported for %s", STORAGE_DISKS_API_ENDPOINT, disk.Root)
+		}
+	}
+	if want := []DiskStats{{Root: primary, Files: 1, UsedBytes: 3}, {Root: extra, Files: 1, UsedBytes: 5}}; !reflect.DeepEqual(stats, want) {
+		t.Errorf("%s: got %v, want %v", STORAGE_DISKS_API_ENDPOINT, stats, want)
+	}
+
+	rec = httptest.NewRecorder()
+	storageServer.HandleHTTPRequest(rec, httptest.NewRequest("POST", STORAGE_DELETE_API_ENDPOINT, strings.NewReader(`{"path":"/directory_a"}`)))
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"success":true}` {
+		t.Fatalf("%s: got status %d: %s", STORAGE_DELETE_API_ENDPOINT, rec.Code, rec.Body.String())
+	}
+	for _, root := range []string{primary, extra} {
+		if _, err := os.Stat(filepath.Join(root, "/directory_a")); !os.IsNotExist(err) {
+			t.Errorf("%s: /directory_a left on %s", STORAGE_DELETE_API_ENDPOINT, root)
+		}
+	}
+	if files := storageServer.ScanDisks(); len(files) != 0 {
+		t.Errorf("%s: got files %v, want none", STORAGE_DELETE_API_ENDPOINT, files)
+	}
+}
+
+/* Return the contents of the file at path below root, failing if it cannot be read */
+func readFile(t *testing.T, root string, path string) string {
+	data, err := os.ReadFile(filepath.Join(root, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+/*
+Operations begun but not committed when the server stops must be rolled back
+(writes, creates, copies) or completed (deletes) when it restarts, while
+committed operations are left alone and the journal starts over empty.
+*/
+func TestIntents(t *testing.T) {
+	root := t.TempDir()
+	intentsFile := filepath.Join(t.TempDir(), "storage.journal")
+	storageServer := setupStorageServer(t, []string{root}, filepath.Join(t.TempDir(), "storage.disks.json"))
+	storageServer.intentsFile = intentsFile
+	storageServer.RecoverIntents()
+
+	writeFile(t, root, "/directory_a/file_a", 8)
+	writeFile(t, root, "/directory_a/file_b", 5)
+	writeFile(t, root, "/directory_b/file_c", 3)
+	writeFile(t, root, "/directory_b/file_d", 3)
+
+	// A write overwriting and extending file_a, cut short
+	filePath := filepath.Join(root, "/directory_a/file_a")
+	storageServer.BeginIntent(WriteIntent(filePath, 6, 8))
+	file, err := os.OpenFile(filePath, os.O_WRONLY, FILE_PERMISSIONS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteAt([]byte("everyone"), 6)
+	file.Close()
+
+	// A write to file_b that completed
+	filePath = filepath.Join(root, "/directory_a/file_b")
+	seq := storageServer.BeginIntent(WriteIntent(filePath, 5, 3))
+	os.WriteFile(filePath, []byte("xxxxxyyy"), FILE_PERMISSIONS)
+	storageServer.CommitIntent(seq)
+
+	// A create, a delete and a copy, cut short
+	filePath = filepath.Join(root, "/directory_a/file_e")
+	storageServer.BeginIntent(IntentRecord{Op: STORAGE_CREATE_API_ENDPOINT, Path: filePath})
+	writeFile(t, root, "/directory_a/file_e", 0)
+
+	filePath = filepath.Join(root, "/directory_b")
+	storageServer.BeginIntent(IntentRecord{Op: STORAGE_DELETE_API_ENDPOINT, Path: filePath})
+	os.Remove(filepath.Join(root, "/directory_b/file_c"))
+
+	temp := filepath.Join(root, "/directory_a/file_b"+COPY_TEMP_SUFFIX)
+	storageServer.BeginIntent(IntentRecord{Op: STORAGE_COPY_API_ENDPOINT, Path: filepath.Join(root, "/directory_a/file_b"), Temp: temp})
+	writeFile(t, root, "/directory_a/file_b"+COPY_TEMP_SUFFIX, 2)
+
+	// Restart without committing them
+	storageServer.intents.Close()
+	storageServer = setupStorageServer(t, []string{root}, filepath.Join(t.TempDir(), "storage.disks.json"))
+	storageServer.intentsFile = intentsFile
+	storageServer.RecoverIntents()
+	defer storageServer.intents.Close()
+
+	if got := readFile(t, root, "/directory_a/file_a"); got != "xxxxxxxx" {
+		t.Errorf("got %q for the interrupted write, want it rolled back to %q", got, "xxxxxxxx")
+	}
+	if got := readFile(t, root, "/directory_a/file_b"); got != "xxxxxyyy" {
+		t.Errorf("got %q for the committed write, want %q", got, "xxxxxyyy")
+	}
+	for _, path := range []string{"/directory_a/file_e", "/directory_b", "/directory_a/file_b" + COPY_TEMP_SUFFIX} {
+		if _, err := os.Stat(filepath.Join(root, path)); !os.IsNotExist(err) {
+			t.Errorf("%s left behind by an interrupted operation", path)
+		}
+	}
+
+	if info, err := os.Stat(intentsFile); err != nil || info.Size() != 0 {
+		t.Errorf("got %v for the journal after recovery, want it empty", info)
+	}
+}
+
+/*
+Buffered writes must reach the disk together once the buffer holds its size
+in bytes or the oldest has waited for its delay, and be seen by reads before
+either. With durability=flush, a write is only acknowledged once on disk.
+*/
+func TestWriteBuffer(t *testing.T) {
+	root := t.TempDir()
+	storageServer := setupStorageServer(t, []string{root}, filepath.Join(t.TempDir(), "storage.disks.json"))
+	storageServer.writeBuffer = WriteBufferSettings{Size: 8, Delay: time.Hour}
+	filePath := storageServer.FilePath("/file")
+
+	// Flushed on size
+	if !<-storageServer.BufferWrite(filePath, 0, []byte("abcd")) {
+		t.Fatal("buffered write failed")
+	}
+	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+		t.Fatalf("write of 4 bytes reached the disk, want it buffered until 8 are")
+	}
+	<-storageServer.BufferWrite(filePath, 4, []byte("efgh"))
+	if got := readFile(t, root, "/file"); got != "abcdefgh" {
+		t.Fatalf("got %q once 8 bytes were buffered, want %q", got, "abcdefgh")
+	}
+
+	// Flushed on time
+	storageServer.writeBuffer.Delay = 20 * time.Millisecond
+	<-storageServer.BufferWrite(filePath, 8, []byte("ij"))
+	if !testutil.Eventually(time.Second, func() bool { return readFile(t, root, "/file") == "abcdefghij" }) {
+		t.Fatalf("got %q, want the buffered write on disk after its delay", readFile(t, root, "/file"))
+	}
+
+	// Read your writes
+	storageServer.writeBuffer.Delay = time.Hour
+	<-storageServer.BufferWrite(filePath, 10, []byte("kl"))
+	var read StorageReadResponse
+	rec := httptest.NewRecorder()
+	storageServer.HandleHTTPRequest(rec, httptest.NewRequest("POST", STORAGE_READ_API_ENDPOINT, strings.NewReader(`{"path":"/file","offset":8,"length":4}`)))
+	if err := json.Unmarshal(rec.Body.Bytes(), &read); err != nil {
+		t.Fatalf("%s: %v: %s", STORAGE_READ_API_ENDPOINT, err, rec.Body.String())
+	}
+	if data, _ := base64.StdEncoding.DecodeString(read.Data); string(data) != "ijkl" {
+		t.Errorf("%s: got %q, want the buffered %q", STORAGE_READ_API_ENDPOINT, data, "ijkl")
+	}
+
+	// Acknowledged once on disk
+	storageServer.writeBuffer.Durable = true
+	done := storageServer.BufferWrite(filePath, 12, []byte("mn"))
+	select {
+	case <-done:
+		t.Fatal("durable write acknowledged while buffered")
+	default:
+	}
+	storageServer.FlushBuffer(filePath)
+	if !<-done {
+		t.Fatal("durable write failed")
+	}
+	if got := readFile(t, root, "/file"); got != "abcdefghijklmn" {
+		t.Errorf("got %q after the durable write, want %q", got, "abcdefghijklmn")
+	}
+}
@@ -0,0 +1,171 @@
+package raft
+
+import (
+	"log"
+	"time"
+
+	rpc "../remote"
+)
+
+/* Upper bounds of the commit latency histogram's buckets, the last bucket holds anything slower */
+var COMMIT_LATENCY_BUCKETS = []time.Duration{
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond,
+	250 * time.Millisecond, 500 * time.Millisecond, time.Second, 2500 * time.Millisecond, 5 * time.Second,
+}
+
+/* A follower that has not acknowledged an AppendEntries for this long is slow */
+const SLOW_FOLLOWER_THRESHOLD = 1 * time.Second
+
+/*
+Histogram of the time entries took from NewCommand to commit, on the leader.
+Counts[i] is the number of entries committed within Buckets[i], the last count
+is the number of entries slower than every bucket.
+*/
+type CommitLatency struct {
+	Buckets []time.Duration
+	Counts  []int
+	Count   int           // Number of entries measured
+	Sum     time.Duration // Sum of the latencies, for the mean
+	Max     time.Duration
+}
+
+/*
+How far a follower is behind the leader.
+*/
+type FollowerLag struct {
+	ID         int
+	Entries    int           // Entries of the leader's log the follower is not known to have
+	SinceAck   time.Duration // Time since the follower last acknowledged an AppendEntries
+	Slow       bool          // SinceAck exceeds SLOW_FOLLOWER_THRESHOLD
+	SlowAlarms int           // Number of times the follower became slow
+}
+
+/*
+Metrics a peer exposes through GetMetrics. Followers is only set on the leader.
+*/
+type RaftMetrics struct {
+	CommitLatency CommitLatency
+	Followers     []FollowerLag
+}
+
+/* When a client command was appended to the leader's log */
+type proposal struct {
+	term int
+	at   time.Time
+}
+
+/*
+Record when the client command at logIndex was appended to the leader's log.
+Must be called while holding the peer's mutex.
+*/
+func (leader *RaftPeer) recordProposal(logIndex int) {
+	leader.proposals[logIndex] = proposal{term: leader.currentTerm, at: time.Now()}
+}
+
+/*
+Add the commit latency of the entries between oldCommitIndex and newCommitIndex
+to the leader's histogram. Entries replaced since they were proposed are not counted.
+Must be called while holding the peer's mutex.
+*/
+func (leader *RaftPeer) recordCommits(oldCommitIndex int, newCommitIndex int) {
+	for i := oldCommitIndex + 1; i <= newCommitIndex; i++ {
+		p, found := leader.proposals[i]
+		if !found {
+			continue
+		}
+		delete(leader.proposals, i)
+
+		if leader.logEntries[i].Term != p.term {
+			continue
+		}
+
+		latency := time.Since(p.at)
+		bucket := 0
+		for bucket < len(COMMIT_LATENCY_BUCKETS) && latency > COMMIT_LATENCY_BUCKETS[bucket] {
+			bucket++
+		}
+
+		leader.commitLatency.Counts[bucket]++
+		leader.commitLatency.Count++
+		leader.commitLatency.Sum += latency
+		if latency > leader.commitLatency.Max {
+			leader.commitLatency.Max = latency
+		}
+	}
+}
+
+/*
+Record that a follower acknowledged an AppendEntries.
+Must be called while holding the peer's mutex.
+*/
+func (leader *RaftPeer) recordAck(peerId int) {
+	leader.lastAck[peerId] = time.Now()
+}
+
+/*
+Return how far each follower is behind this leader.
+Must be called while holding the peer's mutex.
+*/
+func (leader *RaftPeer) followerLags() []FollowerLag {
+	lags := []FollowerLag{}
+	for peerId := 0; peerId < leader.numPeers; peerId++ {
+		if peerId == leader.ID {
+			continue
+		}
+
+		// Followers are measured from the start of this leadership at the earliest
+		lastAck := leader.lastAck[peerId]
+		if lastAck.Before(leader.leaderSince) {
+			lastAck = leader.leaderSince
+		}
+
+		sinceAck := time.Since(lastAck)
+		lags = append(lags, FollowerLag{
+			ID:         peerId,
+			Entries:    len(leader.logEntries) - 1 - leader.matchIndex[peerId],
+			SinceAck:   sinceAck,
+			Slow:       sinceAck > SLOW_FOLLOWER_THRESHOLD,
+			SlowAlarms: leader.slowAlarms[peerId],
+		})
+	}
+	return lags
+}
+
+/*
+Log a warning for each follower that became slow since the last check,
+so operators can spot degraded peers. Called by the leader on every heartbeat.
+*/
+func (leader *RaftPeer) CheckFollowerLag() {
+	leader.Mutex.Lock()
+	defer leader.Mutex.Unlock()
+
+	if leader.role != LEADER || !leader.active {
+		return
+	}
+
+	for _, lag := range leader.followerLags() {
+		if lag.Slow && !leader.slow[lag.ID] {
+			leader.slowAlarms[lag.ID]++
+			log.Printf("WARNING: P%d follower P%d is lagging: no AppendEntries acknowledged for %v, %d entries behind",
+				leader.ID, lag.ID, lag.SinceAck.Round(time.Millisecond), lag.Entries)
+		}
+		leader.slow[lag.ID] = lag.Slow
+	}
+}
+
+/*
+GetMetrics -- this is a remote call operators can use to collect the commit latency
+histogram of the peer and, if it is the leader, the replication lag of its followers.
+*/
+func (peer *RaftPeer) GetMetrics() (RaftMetrics, rpc.RemoteObjectError) {
+	peer.Mutex.Lock()
+	defer peer.Mutex.Unlock()
+
+	metrics := RaftMetrics{CommitLatency: peer.commitLatency}
+	metrics.CommitLatency.Counts = append([]int{}, peer.commitLatency.Counts...)
+	if peer.role == LEADER {
+		metrics.Followers = peer.followerLags()
+	}
+
+	return metrics, rpc.RemoteObjectError{}
+}
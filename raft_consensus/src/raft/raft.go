@@ -37,6 +37,14 @@ func min(a, b int) int {
 	return b
 }
 
+// Given two ints a and b, return the larger int
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
 /* Start of RAFT Constants */
 const RAFT_HEARTBEAT = 150 * time.Millisecond
 const RAFT_IP_ADDRESS = "127.0.0.1:"
@@ -44,6 +52,11 @@ const LEADER = "LEADER"
 const CANDIDATE = "CANDIDATE"
 const FOLLOWER = "FOLLOWER"
 
+/* Types of log entries */
+const NORMAL_ENTRY = "NORMAL"               // A command submitted by a client
+const CONFIGURATION_ENTRY = "CONFIGURATION" // A change to the peer group's membership
+const NOOP_ENTRY = "NOOP"                   // Appended by a new leader to commit entries of prior terms
+
 var GlobalMutex sync.Mutex
 
 /* End of RAFT Constants */
@@ -115,11 +128,19 @@ type StatusReport struct {
 	CallCount int
 }
 
-// This is an entry that is logged onto the Raft network
+/*
+This is an entry that is logged onto the Raft network.
+
+Only NORMAL entries hold client commands. NOOP and CONFIGURATION entries are
+internal to Raft, so the indices given to the Controller count NORMAL entries
+only, see commandIndex.
+*/
 type LogEntry struct {
-	Term        int // Term this entry was created
+	Term        int    // Term this entry was created
+	Type        string // NORMAL_ENTRY, CONFIGURATION_ENTRY or NOOP_ENTRY
 	Command     int
-	Index       int // Starting from 1
+	Peers       []int // IDs of the peer group's members, in CONFIGURATION entries
+	Index       int   // Starting from 1
 	IsCommitted bool
 	commitCount int
 }
@@ -134,6 +155,7 @@ type RaftInterface struct {
 	GetCommittedCmd func(int) (int, rpc.RemoteObjectError)
 	GetStatus       func() (StatusReport, rpc.RemoteObjectError)
 	NewCommand      func(int) (StatusReport, rpc.RemoteObjectError)
+	GetMetrics      func() (RaftMetrics, rpc.RemoteObjectError)
 }
 
 /*
@@ -175,6 +197,16 @@ type RaftPeer struct {
 	lastCommit   int
 	nextIndex    []int // Initialized as 1 for all peers
 	matchIndex   []int
+
+	/* Metrics, see metrics.go */
+	proposals     map[int]proposal // Client commands not committed yet, by log index
+	commitLatency CommitLatency
+	leaderSince   time.Time   // When this peer last became leader
+	lastAck       []time.Time // Last acknowledged AppendEntries of each follower
+	slow          []bool      // Followers currently lagging
+	slowAlarms    []int       // Number of times each follower became slow
+
+	catchUp *catchUpLimiter // Limits on catch-up traffic, see throttle.go
 }
 
 /*
@@ -187,6 +219,39 @@ func removeElements(slice []LogEntry, prevIndex int) []LogEntry {
 	return newSlice
 }
 
+/*
+Return the number of client commands in the peer's log up to logIndex,
+i.e. the index the Controller knows the entry at logIndex by.
+Must be called while holding the peer's mutex.
+*/
+func (peer *RaftPeer) commandIndex(logIndex int) int {
+	index := 0
+	for i := 1; i <= logIndex && i < len(peer.logEntries); i++ {
+		if peer.logEntries[i].Type == NORMAL_ENTRY {
+			index++
+		}
+	}
+	return index
+}
+
+/*
+Return the position in the peer's log of the client command the Controller
+knows by index, or -1 if the log holds fewer commands.
+Must be called while holding the peer's mutex.
+*/
+func (peer *RaftPeer) logIndex(index int) int {
+	count := 0
+	for i := 1; i < len(peer.logEntries); i++ {
+		if peer.logEntries[i].Type == NORMAL_ENTRY {
+			count++
+			if count == index {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
 /*
 Dispatcher deals with a peer's deactivation, timeouts and corresponding role switches,
 using channels.
@@ -207,6 +272,7 @@ func (peer *RaftPeer) Dispatcher() {
 			case <-time.After(RAFT_HEARTBEAT): // After heartbeat timeout
 				// Send Empty Heartbeat
 				go peer.SendHeartbeat(-1) // Send Empty Heartbeat
+				peer.CheckFollowerLag()   // Warn about slow followers
 			}
 
 		case FOLLOWER:
@@ -251,12 +317,15 @@ func (peer *RaftPeer) Dispatcher() {
 /*
 Wrapper function ran in go routines to call AppendEntries on stubs, repeatedly, until
 leader gets a valid response from stub peer, gets deactivated or switches roles.
+Every call carries the entries the follower is missing, so heartbeats (entryIndex -1)
+repair lagging followers too.
 */
 func (leader *RaftPeer) CallAppendEntries(peerId int, entryIndex int) {
 
 	/* Initialize some variables */
 	entry := []LogEntry{}
 	successful := false
+	transferring := false // True once this follower holds a catch-up transfer slot
 	leader.Mutex.Lock()
 	peerStub := leader.peerStubs[peerId] // Get stub peer
 
@@ -275,9 +344,8 @@ func (leader *RaftPeer) CallAppendEntries(peerId int, entryIndex int) {
 		prevLogIndex = leader.nextIndex[peerId] - 1         // Decrement prevLogindex
 		prevLogTerm := leader.logEntries[prevLogIndex].Term // Get the term of the entry at prevLogIndex
 
-		if entryIndex != -1 { // If this is not an empty heartbeat
-			entry = leader.logEntries[prevLogIndex+1:] // Get the entry
-		}
+		// Send the entries the follower is missing, none for an up-to-date follower's heartbeat
+		entry = leader.logEntries[prevLogIndex+1:]
 		leader.Mutex.Unlock()
 
 		/* If candidate is not active or candidate is not a FOLLOWER, do not send remote calls. */
@@ -288,6 +356,20 @@ func (leader *RaftPeer) CallAppendEntries(peerId int, entryIndex int) {
 		}
 		leader.Mutex.Unlock()
 
+		// Catch-up traffic is sent in batches, within the leader's limits
+		catchUp := len(entry) > CATCHUP_MIN_ENTRIES
+		if catchUp {
+			if !transferring {
+				if !leader.startTransfer() {
+					return
+				}
+				transferring = true
+				defer leader.endTransfer()
+			}
+			entry = leader.catchUp.batch(entry)
+			leader.catchUp.throttle(entriesSize(entry))
+		}
+
 		/* Send AppendEntrie RPC */
 		term, success, roe := peerStub.AppendEntries(leaderTerm, leader.ID, prevLogIndex, prevLogTerm, entry, leaderCommitIndex)
 		if (roe != rpc.RemoteObjectError{}) { // Handle Remote Object Error
@@ -296,6 +378,16 @@ func (leader *RaftPeer) CallAppendEntries(peerId int, entryIndex int) {
 
 		successful = success // If peet stub replied true, forloop will exit at end
 
+		if successful && catchUp {
+			leader.Mutex.Lock()
+			// The follower has this batch, send the next one if it is still behind
+			leader.nextIndex[peerId] = prevLogIndex + 1 + len(entry)
+			leader.matchIndex[peerId] = leader.nextIndex[peerId] - 1
+			leader.recordAck(peerId)
+			successful = leader.nextIndex[peerId] >= len(leader.logEntries)
+			leader.Mutex.Unlock()
+		}
+
 		if !successful { // If peer stub retplied false
 			leader.Mutex.Lock()
 
@@ -305,10 +397,8 @@ func (leader *RaftPeer) CallAppendEntries(peerId int, entryIndex int) {
 				leader.role = FOLLOWER    // and convert to Follower.
 				leader.Mutex.Unlock()
 				return
-			} else {
-				if entryIndex != -1 {
-					leader.nextIndex[peerId]-- // Decrement nextIndex
-				}
+			} else if leader.nextIndex[peerId] > 1 {
+				leader.nextIndex[peerId]-- // Decrement nextIndex, and retry from the entry before
 			}
 			leader.Mutex.Unlock()
 		}
@@ -316,11 +406,14 @@ func (leader *RaftPeer) CallAppendEntries(peerId int, entryIndex int) {
 	leader.Mutex.Lock()
 
 	// At this point, RPC was Successful
+	leader.recordAck(peerId)
 
-	// Set this peer stub's nextIndex to the Leader's last log entry
-	leader.nextIndex[peerId] = len(leader.logEntries)
-	// Set this peer stub's nextIndex to its nextIndex - 1
-	leader.matchIndex[peerId] = leader.nextIndex[peerId] - 1
+	// The follower holds the leader's log up to the last entry sent. A reply to
+	// an older call may come in last, so matchIndex never moves back.
+	if match := prevLogIndex + len(entry); match > leader.matchIndex[peerId] {
+		leader.matchIndex[peerId] = match
+	}
+	leader.nextIndex[peerId] = leader.matchIndex[peerId] + 1
 
 	leader.Mutex.Unlock()
 
@@ -330,22 +423,33 @@ func (leader *RaftPeer) CallAppendEntries(peerId int, entryIndex int) {
 func (leader *RaftPeer) SendHeartbeat(entryIndex int) {
 	prettyPrint(Leader, "P%d Sending HBs to all servers", leader.ID)
 
+	var wg sync.WaitGroup
 	for peerId := range leader.peerStubs {
 
 		/* If candidate is not active or candidate is not a FOLLOWER, do not send remote calls. */
 		leader.Mutex.Lock()
 		if !leader.active || !(leader.role == LEADER) {
 			leader.Mutex.Unlock()
-			return
+			break
 		}
 		leader.Mutex.Unlock()
 
 		prettyPrint(Client, "P%d Sending Heartbeat to %d", leader.ID, peerId)
 
-		// Handle each peer's AppendEntries operations in a go routine
-		leader.CallAppendEntries(peerId, entryIndex)
+		// Handle each peer's AppendEntries operations in a go routine, so a follower
+		// being caught up does not hold back the heartbeats of the others
+		wg.Add(1)
+		go func(peerId int) {
+			defer wg.Done()
+			leader.CallAppendEntries(peerId, entryIndex)
+			leader.advanceCommitIndex()
+		}(peerId)
 	}
+	wg.Wait()
+}
 
+// Commit the entries of the Leader's term held by a majority
+func (leader *RaftPeer) advanceCommitIndex() {
 	leader.Mutex.Lock()
 	/* If candidate is not active or candidate is not a FOLLOWER, do not send remote calls. */
 	if !leader.active || !(leader.role == LEADER) {
@@ -354,12 +458,12 @@ func (leader *RaftPeer) SendHeartbeat(entryIndex int) {
 	}
 
 	commitIndex := leader.commitIndex // Get the Leader's latest committed index
-	commitCount := 1                  // Initialize the commit count
 
 	for i := commitIndex + 1; i < len(leader.logEntries); i++ {
+		commitCount := 1 // The Leader holds the entry
 
 		for mIndex := 0; mIndex < leader.numPeers; mIndex++ {
-			if leader.matchIndex[mIndex] >= i && leader.logEntries[i].Term == leader.currentTerm {
+			if mIndex != leader.ID && leader.matchIndex[mIndex] >= i && leader.logEntries[i].Term == leader.currentTerm {
 				commitCount++ // Increment commit count
 			}
 		}
@@ -370,6 +474,7 @@ func (leader *RaftPeer) SendHeartbeat(entryIndex int) {
 		}
 	}
 
+	leader.recordCommits(leader.commitIndex, commitIndex)
 	leader.commitIndex = commitIndex
 
 	leader.Mutex.Unlock()
@@ -411,8 +516,26 @@ func (candidate *RaftPeer) LeaderElection() {
 				candidate.Mutex.Lock()
 				candidate.role = LEADER           // become leader
 				candidate.leaderId = candidate.ID // enforce self as leader
+				candidate.leaderSince = time.Now()
+				candidate.slow = make([]bool, candidate.numPeers)
+
+				// Nothing is known of the followers' logs yet (Figure 2)
+				for i := 0; i < candidate.numPeers; i++ {
+					candidate.nextIndex[i] = len(candidate.logEntries)
+					candidate.matchIndex[i] = 0
+				}
+
+				/*
+					A leader only commits entries of its own term (§5.4.2), so entries left
+					uncommitted by prior terms would wait for the next client command.
+					Append a no-op entry of this term to commit them right away (§8).
+				*/
+				noopIndex := len(candidate.logEntries)
+				candidate.logEntries = append(candidate.logEntries, LogEntry{Term: candidate.currentTerm, Type: NOOP_ENTRY, Index: noopIndex})
 				candidate.Mutex.Unlock()
-				candidate.SendHeartbeat(-1) // Send Empty Heartbeats to all followers
+				// Replicate the no-op to all followers off the Dispatcher, which must keep
+				// taking heartbeats while followers answer
+				go candidate.SendHeartbeat(noopIndex)
 				return
 			}
 		}
@@ -458,13 +581,19 @@ func NewRaftPeer(port int, id int, num int) *RaftPeer {
 		logEntries:       []LogEntry{},
 		nextIndex:        make([]int, num),
 		matchIndex:       make([]int, num),
+		proposals:        map[int]proposal{},
+		commitLatency:    CommitLatency{Buckets: COMMIT_LATENCY_BUCKETS, Counts: make([]int, len(COMMIT_LATENCY_BUCKETS)+1)},
+		lastAck:          make([]time.Time, num),
+		slow:             make([]bool, num),
+		slowAlarms:       make([]int, num),
+		catchUp:          newCatchUpLimiter(),
 	}
 	for i := 0; i < num; i++ {
 		peer.nextIndex[i] = 1 // Set each stub peer's nextIndex
 	}
 
 	// Initialize log with empty log at array position 0
-	peer.logEntries = append(peer.logEntries, LogEntry{Term: 0, Index: 0})
+	peer.logEntries = append(peer.logEntries, LogEntry{Term: 0, Type: NOOP_ENTRY, Index: 0})
 
 	// Create a new remote service attached to this peer
 	s, err := rpc.NewService(&RaftInterface{}, &peer, port, false, false)
@@ -579,40 +708,39 @@ func (peer *RaftPeer) AppendEntries(leaderTerm int, leaderID int, prevLogIndex i
 	peer.role = FOLLOWER          // Enforce that peer is a Follower
 	peer.votedFor = leaderID      // Accept leader
 
-	// If this is not an empty heartbeat
-	if len(entry) != 0 {
+	// Check that the peer's log holds the Leader's entry at prevLogIndex, heartbeats
+	// included, before appending. If not, reply false and the Leader retries from
+	// the entry before (§5.3).
+	matches := prevLogIndex < len(peer.logEntries) && prevLogTerm == peer.logEntries[prevLogIndex].Term
 
-		// Check that Leader's logs are at least as-up-to-date as peer's, before appending,
-		// If not as-up-to-date (as defined in the Raft paper), then reply false.
-		if prevLogIndex >= len(peer.logEntries) || prevLogTerm != peer.logEntries[prevLogIndex].Term {
-			peerCurrentTerm := peer.currentTerm
-			peer.Mutex.Unlock()
-			return peerCurrentTerm, false, rpc.RemoteObjectError{}
-		}
-
-		// Delete anything from prevLogIndex + 1 onwards
-		peer.logEntries = removeElements(peer.logEntries, prevLogIndex)
-
-		// Append each of the entries sent by the leader
+	if matches {
 		for i := 0; i < len(entry); i++ {
-			prettyPrint(Error, "P%d successfully appended Entry %v", peer.ID, entry)
-			peer.logEntries = append(peer.logEntries, entry[i])
+			position := prevLogIndex + 1 + i
+			if position < len(peer.logEntries) && peer.logEntries[position].Term == entry[i].Term {
+				continue // Already holds the entry, e.g. from an earlier call
+			}
+
+			// Delete the conflicting entry and anything after it, then append the rest
+			peer.logEntries = removeElements(peer.logEntries, position-1)
+			peer.logEntries = append(peer.logEntries, entry[i:]...)
+			prettyPrint(Error, "P%d successfully appended Entry %v", peer.ID, entry[i:])
+			break
 		}
 	}
 
 	peer.Mutex.Unlock()
 
-	peer.heartbeatChannel <- true // Successful Heartbeat, reset timeout
+	peer.heartbeatChannel <- true // Heartbeat from a legit leader, reset timeout
 
 	peer.Mutex.Lock()
-	// If Leader's commit index is larger
-	if commitIndex > peer.commitIndex {
-		peer.commitIndex = min(len(peer.logEntries)-1, commitIndex) // Update peer's commit index
+	// If Leader's commit index is larger, commit up to the last entry known to match the Leader's
+	if matches && commitIndex > peer.commitIndex {
+		peer.commitIndex = max(peer.commitIndex, min(prevLogIndex+len(entry), commitIndex)) // Update peer's commit index
 	}
 	peerCurrentTerm := peer.currentTerm
 	peer.Mutex.Unlock()
 
-	return peerCurrentTerm, true, rpc.RemoteObjectError{}
+	return peerCurrentTerm, matches, rpc.RemoteObjectError{}
 }
 
 /*
@@ -626,7 +754,7 @@ GetStatus -- this is a remote call that is used by the Controller to collect sta
 */
 func (peer *RaftPeer) GetStatus() (StatusReport, rpc.RemoteObjectError) {
 	peer.Mutex.Lock()
-	index := len(peer.logEntries) - 1
+	index := peer.commandIndex(len(peer.logEntries) - 1) // Index of the last client command
 	term := peer.currentTerm
 	leader := peer.role == LEADER // Is peer Leader?
 	numCallsReceived := peer.service.GetCount()
@@ -660,10 +788,11 @@ func (peer *RaftPeer) NewCommand(command int) (StatusReport, rpc.RemoteObjectErr
 	/* Create LogEntry */
 	index := len(peer.logEntries)
 
-	entry := LogEntry{Term: peer.currentTerm, Command: command, IsCommitted: false, Index: index, commitCount: 1}
+	entry := LogEntry{Term: peer.currentTerm, Type: NORMAL_ENTRY, Command: command, IsCommitted: false, Index: index, commitCount: 1}
 
 	/* Append of list of logEntries */
 	peer.logEntries = append(peer.logEntries, entry)
+	peer.recordProposal(index)
 
 	/* Update Leader's last commit index */
 	if peer.lastCommit == 0 {
@@ -684,8 +813,9 @@ func (peer *RaftPeer) NewCommand(command int) (StatusReport, rpc.RemoteObjectErr
 // command number and indicates that no committed log entry exists at that index
 func (peer *RaftPeer) GetCommittedCmd(index int) (int, rpc.RemoteObjectError) {
 	peer.Mutex.Lock()
-	if index <= peer.commitIndex {
-		logEntry := peer.logEntries[index] // Get peer's log entry at index
+	position := peer.logIndex(index) // Position of the index-th client command in the log
+	if position != -1 && position <= peer.commitIndex {
+		logEntry := peer.logEntries[position] // Get peer's log entry at index
 		peer.Mutex.Unlock()
 		return logEntry.Command, rpc.RemoteObjectError{} // Send command inside the entry
 	} else {
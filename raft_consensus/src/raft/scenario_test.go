@@ -0,0 +1,648 @@
+package raft
+
+// Scripted fault scenarios, beyond the Controller tests of test_test.go.
+//
+// Peers are held in-process, so each scenario can cut the links between them
+// and read their logs and terms directly. Links are cut by routing every stub
+// of a peer through a network, which fails the calls of cut links the way a
+// stopped service does. Timing still relies on the peers' own timers, so each
+// step waits for its outcome up to SCENARIO_TIMEOUT.
+
+import (
+	"../../../testutil"
+	"../remote"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+const SCENARIO_TIMEOUT time.Duration = 10 * time.Second
+
+// the links between peers, cut from caller to callee
+type network struct {
+	mu  sync.Mutex
+	cut map[[2]int]bool
+}
+
+func (net *network) connected(from int, to int) bool {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	return !net.cut[[2]int{from, to}]
+}
+
+// cut every link between peers of different groups, in both directions
+func (net *network) partition(groups ...[]int) {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	for i, group := range groups {
+		for j, other := range groups {
+			if i == j {
+				continue
+			}
+			for _, from := range group {
+				for _, to := range other {
+					net.cut[[2]int{from, to}] = true
+				}
+			}
+		}
+	}
+}
+
+// cut the links from one peer to others, leaving the way back up
+func (net *network) cutFrom(from int, to ...int) {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	for _, peer := range to {
+		net.cut[[2]int{from, peer}] = true
+	}
+}
+
+// restore every link
+func (net *network) heal() {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	net.cut = map[[2]int]bool{}
+}
+
+// return a stub calling through the link from peer from to peer to
+func (net *network) link(from int, to int, stub *RaftInterface) *RaftInterface {
+	dropped := remote.RemoteObjectError{Err: fmt.Sprintf("link from P%d to P%d is cut", from, to)}
+	return &RaftInterface{
+		RequestVote: func(term int, candidateId int, lastLogIndex int, lastLogTerm int) (int, bool, remote.RemoteObjectError) {
+			if !net.connected(from, to) {
+				return 0, false, dropped
+			}
+			return stub.RequestVote(term, candidateId, lastLogIndex, lastLogTerm)
+		},
+		AppendEntries: func(leaderTerm int, leaderID int, prevLogIndex int, prevLogTerm int, entry []LogEntry, leaderCommit int) (int, bool, remote.RemoteObjectError) {
+			if !net.connected(from, to) {
+				return 0, false, dropped
+			}
+			return stub.AppendEntries(leaderTerm, leaderID, prevLogIndex, prevLogTerm, entry, leaderCommit)
+		},
+	}
+}
+
+// a group of Raft peers run in-process, with the network between them
+type cluster struct {
+	t      *testing.T
+	peers  []*RaftPeer
+	active []bool
+	net    *network
+}
+
+// create num peers, routed through a network, without activating them
+func newCluster(t *testing.T, num int) *cluster {
+	port := testutil.FreePortRange(t, num)
+	c := &cluster{t: t, peers: make([]*RaftPeer, num), active: make([]bool, num), net: &network{cut: map[[2]int]bool{}}}
+	for i := 0; i < num; i++ {
+		c.peers[i] = NewRaftPeer(port+i, i, num)
+		for id, stub := range c.peers[i].peerStubs {
+			c.peers[i].peerStubs[id] = c.net.link(i, id, stub)
+		}
+	}
+	return c
+}
+
+func (c *cluster) start() {
+	for i := range c.peers {
+		c.restart(i)
+	}
+}
+
+func (c *cluster) restart(i int) {
+	c.peers[i].Activate()
+	c.active[i] = true
+}
+
+func (c *cluster) crash(i int) {
+	c.peers[i].Deactivate()
+	c.active[i] = false
+}
+
+func (c *cluster) cleanup() {
+	for i := range c.peers {
+		if c.active[i] {
+			c.crash(i)
+		}
+	}
+}
+
+func (c *cluster) fatalf(format string, a ...interface{}) {
+	c.cleanup()
+	c.t.Fatalf(format, a...)
+}
+
+// wait until cond holds, failing the test with msg after SCENARIO_TIMEOUT
+func (c *cluster) waitFor(msg string, cond func() bool) {
+	if !testutil.Eventually(SCENARIO_TIMEOUT, cond) {
+		c.fatalf("timed out waiting for %s", msg)
+	}
+}
+
+// a copy of a peer's state
+type peerState struct {
+	role        string
+	term        int
+	log         []LogEntry
+	commitIndex int
+}
+
+func (c *cluster) state(i int) peerState {
+	peer := c.peers[i]
+	peer.Mutex.Lock()
+	defer peer.Mutex.Unlock()
+	return peerState{role: peer.role, term: peer.currentTerm, log: append([]LogEntry{}, peer.logEntries...), commitIndex: peer.commitIndex}
+}
+
+// return the active leader among peers, or -1 while there is none or some
+// active peer is on a later term than the leader, e.g. a leader deposed
+// while cut off that has not heard of it yet. Fails the test if two peers
+// lead the same term.
+func (c *cluster) leaderAmong(peers ...int) int {
+	leader, leaderTerm, maxTerm := -1, -1, -1
+	leaders := map[int]int{}
+	for _, i := range peers {
+		if !c.active[i] {
+			continue
+		}
+		s := c.state(i)
+		if s.term > maxTerm {
+			maxTerm = s.term
+		}
+		if s.role != LEADER {
+			continue
+		}
+		if other, found := leaders[s.term]; found {
+			c.fatalf("P%d and P%d both lead term %d", other, i, s.term)
+		}
+		leaders[s.term] = i
+		if s.term > leaderTerm {
+			leader, leaderTerm = i, s.term
+		}
+	}
+	if leaderTerm < maxTerm {
+		return -1
+	}
+	return leader
+}
+
+// wait for one of peers to lead, and return it
+func (c *cluster) waitLeader(peers ...int) int {
+	leader := -1
+	c.waitFor("a leader", func() bool {
+		leader = c.leaderAmong(peers...)
+		return leader != -1
+	})
+	return leader
+}
+
+// return the position of the client command cmd in peer i's log, or -1
+func (c *cluster) position(i int, cmd int) int {
+	for position, entry := range c.state(i).log {
+		if entry.Type == NORMAL_ENTRY && entry.Command == cmd {
+			return position
+		}
+	}
+	return -1
+}
+
+// return true if peer i committed the client command cmd
+func (c *cluster) committed(i int, cmd int) bool {
+	position := c.position(i, cmd)
+	return position != -1 && position <= c.state(i).commitIndex
+}
+
+// wait for every one of peers to commit the client command cmd at the same position
+func (c *cluster) waitCommitted(cmd int, peers ...int) {
+	c.waitFor(fmt.Sprintf("command %d to commit on %v", cmd, peers), func() bool {
+		for _, i := range peers {
+			if !c.committed(i, cmd) || c.position(i, cmd) != c.position(peers[0], cmd) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// return the client commands in peer i's log, in order
+func (c *cluster) commands(i int) []int {
+	commands := []int{}
+	for _, entry := range c.state(i).log {
+		if entry.Type == NORMAL_ENTRY {
+			commands = append(commands, entry.Command)
+		}
+	}
+	return commands
+}
+
+// wait for peers to hold the same log, entry for entry
+func (c *cluster) waitSameLogs(peers ...int) {
+	c.waitFor(fmt.Sprintf("the logs of %v to match", peers), func() bool {
+		first := c.state(peers[0]).log
+		for _, i := range peers[1:] {
+			log := c.state(i).log
+			if len(log) != len(first) {
+				return false
+			}
+			for position := range log {
+				if log[position].Term != first[position].Term || log[position].Type != first[position].Type || log[position].Command != first[position].Command {
+					return false
+				}
+			}
+		}
+		return true
+	})
+}
+
+// submit the client command cmd to peer i, which must lead
+func (c *cluster) submit(i int, cmd int) {
+	sr, _ := c.peers[i].NewCommand(cmd)
+	if !sr.Leader {
+		c.fatalf("P%d no longer leads, could not submit command %d", i, cmd)
+	}
+}
+
+// submit the client command cmd to the leader among peers, again if the
+// leader changes before taking it, and wait for peers to commit it.
+// Returns the leader that took the command.
+func (c *cluster) commit(cmd int, peers ...int) int {
+	leader := -1
+	c.waitFor(fmt.Sprintf("a leader to take command %d", cmd), func() bool {
+		if leader != -1 && c.position(leader, cmd) != -1 {
+			return true
+		}
+		leader = c.leaderAmong(peers...)
+		if leader == -1 {
+			return false
+		}
+		c.peers[leader].NewCommand(cmd)
+		return c.position(leader, cmd) != -1
+	})
+	c.waitCommitted(cmd, peers...)
+	return leader
+}
+
+// return the peers other than the given ones
+func (c *cluster) others(except ...int) []int {
+	others := []int{}
+	for i := range c.peers {
+		excluded := false
+		for _, e := range except {
+			excluded = excluded || i == e
+		}
+		if !excluded {
+			others = append(others, i)
+		}
+	}
+	return others
+}
+
+// A leader crashes after replicating an entry to a bare majority. Only
+// followers holding the entry can win the next election, so the entry
+// survives and commits on every peer, including the old leader once back.
+// A lagging follower may still win if a holder led in between and sent it
+// the entry, e.g. after its timeouts deposed the leader before the crash.
+func TestScenario_LeaderCrashDuringReplication(t *testing.T) {
+	c := newCluster(t, 5)
+	c.start()
+
+	leader := c.commit(101, c.others()...)
+	oldTerm := c.state(leader).term
+
+	followers := c.others(leader)
+	holders, lagging := followers[:2], followers[2:]
+	c.net.cutFrom(leader, lagging...)
+	c.submit(leader, 102)
+	for _, i := range lagging {
+		if c.position(i, 102) != -1 {
+			c.fatalf("P%d received command 102 over a cut link", i)
+		}
+	}
+	for _, i := range holders {
+		if c.position(i, 102) == -1 {
+			c.fatalf("P%d did not receive command 102", i)
+		}
+	}
+
+	c.crash(leader)
+	c.net.heal()
+
+	newLeader := c.waitLeader(followers...)
+	if c.position(newLeader, 102) == -1 {
+		c.fatalf("P%d won the election without command 102, held by %v", newLeader, holders)
+	}
+	if term := c.state(newLeader).term; term <= oldTerm {
+		c.fatalf("new leader P%d is on term %d, expected more than %d", newLeader, term, oldTerm)
+	}
+	c.waitCommitted(102, followers...)
+
+	c.restart(leader)
+	c.commit(103, c.others()...)
+	c.waitSameLogs(c.others()...)
+
+	c.cleanup()
+}
+
+// Two halves of a group of four cannot elect a leader: every candidate falls
+// a vote short, term after term. Once healed, exactly one leader emerges.
+func TestScenario_SplitVote(t *testing.T) {
+	c := newCluster(t, 4)
+	c.net.partition([]int{0, 1}, []int{2, 3})
+	c.start()
+
+	time.Sleep(5 * RandomElectionTimeoutDuration())
+	maxTerm := 0
+	for i := range c.peers {
+		s := c.state(i)
+		if s.role == LEADER {
+			c.fatalf("P%d leads term %d without a majority", i, s.term)
+		}
+		if s.term > maxTerm {
+			maxTerm = s.term
+		}
+	}
+	if maxTerm < 2 {
+		c.fatalf("expected repeated elections, the highest term is %d", maxTerm)
+	}
+
+	c.net.heal()
+	leader := c.waitLeader(c.others()...)
+	if term := c.state(leader).term; term < maxTerm {
+		c.fatalf("leader P%d is on term %d, behind the split vote's term %d", leader, term, maxTerm)
+	}
+	c.commit(201, c.others()...)
+
+	c.cleanup()
+}
+
+// A leader cut off in a minority keeps taking commands it cannot commit,
+// while the majority elects a leader of its own. Once healed, the old leader
+// steps down and its uncommitted command is replaced by the majority's.
+func TestScenario_PartitionAndHeal(t *testing.T) {
+	c := newCluster(t, 5)
+	c.start()
+
+	c.commit(301, c.others()...)
+
+	// The leader may lose its term just before being cut off; cut off the
+	// next one then, until one takes command 302
+	var oldLeader int
+	var minority, majority []int
+	c.waitFor("a cut off leader to take command 302", func() bool {
+		c.net.heal()
+		oldLeader = c.waitLeader(c.others()...)
+		minority = []int{oldLeader, c.others(oldLeader)[0]}
+		majority = c.others(minority...)
+		c.net.partition(minority, majority)
+		sr, _ := c.peers[oldLeader].NewCommand(302)
+		return sr.Leader
+	})
+	newLeader := c.commit(303, majority...)
+
+	for _, i := range c.others() {
+		if c.committed(i, 302) {
+			c.fatalf("P%d committed command 302, only held by the minority", i)
+		}
+	}
+	if c.state(oldLeader).term >= c.state(newLeader).term {
+		c.fatalf("expected the majority to move past the minority's term %d", c.state(oldLeader).term)
+	}
+
+	c.net.heal()
+	c.waitFor("the old leader to step down", func() bool {
+		return c.state(oldLeader).role != LEADER
+	})
+	c.commit(304, c.others()...)
+	c.waitSameLogs(c.others()...)
+
+	for _, i := range c.others() {
+		if c.position(i, 302) != -1 {
+			c.fatalf("P%d still holds command 302 after healing: %v", i, c.commands(i))
+		}
+	}
+	terms := map[int]bool{}
+	for _, i := range c.others() {
+		terms[c.state(i).term] = true
+	}
+	if len(terms) != 1 {
+		c.fatalf("peers disagree about the term after healing: %v", terms)
+	}
+
+	c.cleanup()
+}
+
+// Peers start from diverging logs left by earlier leaders. The peer with the
+// most up-to-date log wins, and the entries it does not hold are replaced on
+// the other peers by its own.
+func TestScenario_LogDivergenceRepair(t *testing.T) {
+	c := newCluster(t, 3)
+
+	logs := [][]LogEntry{
+		{{Term: 1, Command: 1}, {Term: 1, Command: 2}, {Term: 3, Command: 4}},
+		{{Term: 1, Command: 1}, {Term: 2, Command: 3}, {Term: 2, Command: 5}},
+		{{Term: 1, Command: 1}},
+	}
+	for i, entries := range logs {
+		peer := c.peers[i]
+		for _, entry := range entries {
+			entry.Type = NORMAL_ENTRY
+			entry.Index = len(peer.logEntries)
+			peer.logEntries = append(peer.logEntries, entry)
+		}
+		peer.currentTerm = entries[len(entries)-1].Term
+		peer.commitIndex = 1 // Command 1 reached every peer
+	}
+
+	// P1 and P2 could elect each other; keep them apart so P0's vote decides
+	c.net.partition([]int{1}, []int{2})
+	c.start()
+
+	leader := c.waitLeader(c.others()...)
+	if leader != 0 {
+		c.fatalf("P%d won the election, but P0 holds the most up-to-date log", leader)
+	}
+	c.net.heal()
+
+	c.commit(6, c.others()...)
+	c.waitSameLogs(c.others()...)
+
+	want := []int{1, 2, 4, 6}
+	for _, i := range c.others() {
+		if got := c.commands(i); fmt.Sprint(got) != fmt.Sprint(want) {
+			c.fatalf("P%d holds commands %v, expected %v", i, got, want)
+		}
+	}
+	if term := c.state(leader).term; term <= 3 {
+		c.fatalf("leader is on term %d, expected more than 3", term)
+	}
+
+	c.cleanup()
+}
+
+// A peer is deactivated while its dispatcher waits for the peer's mutex to
+// start an election, its timeout having run out. Deactivation must let go of
+// the mutex before telling the dispatcher to stop, or each waits on the other.
+func TestScenario_DeactivateDuringElectionTimeout(t *testing.T) {
+	c := newCluster(t, 1)
+	c.start()
+	peer := c.peers[0]
+
+	// Queue the deactivation on the mutex first, then the dispatcher once its timeout runs out
+	peer.Mutex.Lock()
+	deactivated := make(chan bool)
+	go func() {
+		peer.Deactivate()
+		deactivated <- true
+	}()
+	c.active[0] = false
+	time.Sleep(2 * RandomElectionTimeoutDuration())
+	peer.Mutex.Unlock()
+
+	select {
+	case <-deactivated:
+	case <-time.After(SCENARIO_TIMEOUT):
+		c.fatalf("P0 still deactivating after %v", SCENARIO_TIMEOUT)
+	}
+}
+
+// Peers start holding entries of an earlier term that never committed. A new
+// leader only commits entries of its own term, so it commits them by
+// committing the no-op it appends when elected, without any client command.
+func TestScenario_NoopCommitsEarlierTerms(t *testing.T) {
+	c := newCluster(t, 3)
+
+	for _, peer := range c.peers {
+		for _, cmd := range []int{1, 2} {
+			peer.logEntries = append(peer.logEntries, LogEntry{Term: 1, Type: NORMAL_ENTRY, Command: cmd, Index: len(peer.logEntries)})
+		}
+		peer.currentTerm = 1
+	}
+	c.start()
+
+	c.waitCommitted(2, c.others()...)
+
+	// The leader may change after the commit; check the latest one
+	c.waitFor("a leader to commit its no-op", func() bool {
+		leader := c.leaderAmong(c.others()...)
+		if leader == -1 {
+			return false
+		}
+		s := c.state(leader)
+		noop := LogEntry{}
+		for _, entry := range s.log[c.position(leader, 2)+1:] {
+			if entry.Index > s.commitIndex {
+				break
+			}
+			if entry.Type != NOOP_ENTRY {
+				c.fatalf("P%d committed %v, expected no-ops only", leader, entry)
+			}
+			noop = entry
+		}
+		return noop.Term == s.term
+	})
+
+	c.cleanup()
+}
+
+// The leader measures the commit latency of the commands it takes, and
+// raises one alarm for a follower cut off for longer than
+// SLOW_FOLLOWER_THRESHOLD, however long it stays cut off.
+func TestScenario_Metrics(t *testing.T) {
+	c := newCluster(t, 3)
+	c.start()
+
+	leader := c.waitLeader(c.others()...)
+	commands := []int{601, 602, 603, 604, 605}
+	for _, cmd := range commands {
+		c.submit(leader, cmd)
+	}
+	c.waitCommitted(commands[len(commands)-1], c.others()...)
+
+	metrics, _ := c.peers[leader].GetMetrics()
+	latency := metrics.CommitLatency
+	if latency.Count != len(commands) {
+		c.fatalf("P%d measured %d commits, expected %d", leader, latency.Count, len(commands))
+	}
+	counted := 0
+	for _, count := range latency.Counts {
+		counted += count
+	}
+	if counted != latency.Count || len(latency.Counts) != len(latency.Buckets)+1 {
+		c.fatalf("P%d buckets %v hold %d commits, expected %d", leader, latency.Counts, counted, latency.Count)
+	}
+	if latency.Max <= 0 || latency.Max > latency.Sum {
+		c.fatalf("P%d measured a maximum latency of %v out of %v", leader, latency.Max, latency.Sum)
+	}
+
+	// Cut a follower off both ways, so it does not depose the leader
+	slow, fast := c.others(leader)[0], c.others(leader)[1]
+	c.net.partition([]int{slow}, c.others(slow))
+	c.waitFor(fmt.Sprintf("P%d to report P%d as slow", leader, slow), func() bool {
+		metrics, _ = c.peers[leader].GetMetrics()
+		for _, lag := range metrics.Followers {
+			if lag.ID == slow {
+				return lag.Slow
+			}
+		}
+		return false
+	})
+	time.Sleep(SLOW_FOLLOWER_THRESHOLD)
+
+	metrics, _ = c.peers[leader].GetMetrics()
+	if len(metrics.Followers) != 2 {
+		c.fatalf("P%d reported %d followers, expected 2", leader, len(metrics.Followers))
+	}
+	for _, lag := range metrics.Followers {
+		if lag.ID == slow && (!lag.Slow || lag.SlowAlarms != 1) {
+			c.fatalf("P%d reported cut off P%d as %+v, expected one alarm", leader, slow, lag)
+		}
+		if lag.ID == fast && (lag.Slow || lag.SlowAlarms != 0) {
+			c.fatalf("P%d reported P%d as %+v, expected no alarm", leader, fast, lag)
+		}
+	}
+
+	c.cleanup()
+}
+
+// A follower back from a crash is caught up in batches of maxEntries, sent
+// no faster than the leader's bandwidth cap allows. The cap lets perSecond
+// batches through each second, after a burst of as many, so catching up on
+// batches of them takes at least (batches-perSecond)/perSecond seconds. A
+// batch waits less than an election timeout, or the follower would not hear
+// from the leader in time.
+func TestScenario_CatchUpThrottle(t *testing.T) {
+	const maxEntries, batches, perSecond = 20, 12, 4
+	c := newCluster(t, 3)
+	c.start()
+
+	leader := c.waitLeader(c.others()...)
+	lagging := c.others(leader)[0]
+	c.crash(lagging)
+	commands := []int{}
+	for cmd := 701; cmd <= 700+maxEntries*batches; cmd++ {
+		c.submit(leader, cmd)
+		commands = append(commands, cmd)
+	}
+	last := commands[len(commands)-1]
+	c.waitCommitted(last, c.others(lagging)...)
+
+	s := c.state(leader)
+	first := c.position(leader, commands[0])
+	c.peers[leader].SetCatchUpLimits(maxEntries, perSecond*entriesSize(s.log[first:first+maxEntries]), 1)
+
+	start := time.Now()
+	c.restart(lagging)
+	c.waitCommitted(last, c.others()...)
+	elapsed := time.Since(start)
+
+	if c.state(leader).term != s.term {
+		c.fatalf("P%d lost its term while catching up P%d", leader, lagging)
+	}
+	if want := (batches - perSecond) * time.Second / perSecond; elapsed < want {
+		c.fatalf("P%d caught up on %d entries in %v, expected at least %v", lagging, len(commands), elapsed, want)
+	}
+
+	c.cleanup()
+}
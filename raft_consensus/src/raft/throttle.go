@@ -0,0 +1,150 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/gob"
+	"sync"
+	"time"
+)
+
+/* Defaults of the catch-up limits, see SetCatchUpLimits */
+const CATCHUP_MIN_ENTRIES = 8        // AppendEntries carrying more entries than this are catch-up traffic
+const CATCHUP_MAX_ENTRIES = 64       // Entries sent in one catch-up AppendEntries
+const CATCHUP_BANDWIDTH = 256 * 1024 // Bytes per second of catch-up traffic sent by a leader
+const CATCHUP_MAX_TRANSFERS = 2      // Followers a leader catches up at once
+const CATCHUP_WAIT = 10 * time.Millisecond
+
+/*
+Limits on the traffic a leader sends to bring lagging followers up to date,
+so catch-up traffic does not starve heartbeats. Heartbeats and the replication
+of new commands are never limited.
+*/
+type catchUpLimiter struct {
+	mu           sync.Mutex
+	maxEntries   int // Entries per AppendEntries
+	bandwidth    int // Bytes per second, 0 for no cap
+	maxTransfers int // Concurrent transfers, 0 for no limit
+	transfers    int // Transfers in progress
+
+	/* Token bucket of the bandwidth cap, holding up to one second of traffic */
+	tokens float64
+	last   time.Time
+}
+
+func newCatchUpLimiter() *catchUpLimiter {
+	return &catchUpLimiter{
+		maxEntries:   CATCHUP_MAX_ENTRIES,
+		bandwidth:    CATCHUP_BANDWIDTH,
+		maxTransfers: CATCHUP_MAX_TRANSFERS,
+		tokens:       CATCHUP_BANDWIDTH,
+		last:         time.Now(),
+	}
+}
+
+/*
+SetCatchUpLimits -- configure the traffic this peer sends as leader to catch up
+lagging followers: the entries per AppendEntries, the bandwidth cap in bytes per
+second and the number of followers caught up at once. A bandwidth or transfer
+limit of 0 removes that limit.
+*/
+func (peer *RaftPeer) SetCatchUpLimits(maxEntries int, bandwidth int, maxTransfers int) {
+	limiter := peer.catchUp
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	if maxEntries > 0 {
+		limiter.maxEntries = maxEntries
+	}
+	limiter.bandwidth = bandwidth
+	limiter.maxTransfers = maxTransfers
+	limiter.tokens = float64(bandwidth)
+	limiter.last = time.Now()
+}
+
+/*
+Return at most the number of entries one catch-up AppendEntries may carry.
+*/
+func (limiter *catchUpLimiter) batch(entries []LogEntry) []LogEntry {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	if len(entries) > limiter.maxEntries {
+		return entries[:limiter.maxEntries]
+	}
+	return entries
+}
+
+/*
+Wait for a free transfer slot. Returns false, without a slot, if the leader
+steps down or is deactivated while waiting.
+*/
+func (leader *RaftPeer) startTransfer() bool {
+	limiter := leader.catchUp
+	for {
+		limiter.mu.Lock()
+		if limiter.maxTransfers == 0 || limiter.transfers < limiter.maxTransfers {
+			limiter.transfers++
+			limiter.mu.Unlock()
+			return true
+		}
+		limiter.mu.Unlock()
+
+		leader.Mutex.Lock()
+		leading := leader.role == LEADER && leader.active
+		leader.Mutex.Unlock()
+		if !leading {
+			return false
+		}
+
+		time.Sleep(CATCHUP_WAIT)
+	}
+}
+
+/*
+Free the transfer slot taken by startTransfer.
+*/
+func (leader *RaftPeer) endTransfer() {
+	leader.catchUp.mu.Lock()
+	leader.catchUp.transfers--
+	leader.catchUp.mu.Unlock()
+}
+
+/*
+Wait until the bandwidth cap allows sending size more bytes.
+*/
+func (limiter *catchUpLimiter) throttle(size int) {
+	limiter.mu.Lock()
+	if limiter.bandwidth == 0 {
+		limiter.mu.Unlock()
+		return
+	}
+
+	// Refill the bucket for the time since the last transfer
+	now := time.Now()
+	limiter.tokens += now.Sub(limiter.last).Seconds() * float64(limiter.bandwidth)
+	if limiter.tokens > float64(limiter.bandwidth) {
+		limiter.tokens = float64(limiter.bandwidth)
+	}
+	limiter.last = now
+
+	// Take the tokens now, going into debt if needed, and wait the debt out
+	limiter.tokens -= float64(size)
+	wait := time.Duration(0)
+	if limiter.tokens < 0 {
+		wait = time.Duration(-limiter.tokens / float64(limiter.bandwidth) * float64(time.Second))
+	}
+	limiter.mu.Unlock()
+
+	time.Sleep(wait)
+}
+
+/*
+Return the number of bytes entries take when sent to a follower.
+*/
+func entriesSize(entries []LogEntry) int {
+	var buffer bytes.Buffer
+	if gob.NewEncoder(&buffer).Encode(entries) != nil {
+		return 0
+	}
+	return buffer.Len()
+}
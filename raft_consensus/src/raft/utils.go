@@ -70,10 +70,10 @@ func (peer *RaftPeer) Deactivate() {
 
 	peer.active = false // Set peer to inactive
 
-	/* Send message to break out of loop */
-	peer.killChannel <- true
-
 	peer.Mutex.Unlock()
 
+	/* Send message to break out of loop, once the Dispatcher can take the mutex again */
+	peer.killChannel <- true
+
 	prettyPrint(Client, "P%v deactivated", peer.ID)
 }
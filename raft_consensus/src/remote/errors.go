@@ -15,6 +15,8 @@ const UNABLE_TO_SEND_CONNECTION_TO_SERVER = 9
 const ENCODING_ERROR = 10
 const LEAKY_SOCKET_READ_ERROR_CLIENT = 11
 const DECODING_ERROR = 11
+const HEDGED_METHOD_NOT_FOUND = 13
+const CALL_CANCELLED = 14
 
 /* End of Constant Global Variables */
 
@@ -32,4 +34,6 @@ var error_message = []string{
 	"Error (Client): Error in encoding",
 	"Error (Client): Unable to read from LS on client for Method : %v Error: %v",
 	"Error (Client): Error in decoding",
+	"Error (HedgeStub): Hedged method is not a method of the stub",
+	"Error (HedgeStub): Call cancelled, the other Service answered first",
 }
@@ -0,0 +1,105 @@
+package remote
+
+import (
+	"errors"
+	"reflect"
+	"time"
+)
+
+/* Suggested delay before a hedged call is also sent to the replica */
+const HEDGE_DELAY = 20 * time.Millisecond
+
+/*
+HedgeStub -- make a client-side stub that hedges some of its calls
+
+	Hedging cuts the tail latency of slow calls: a hedged method first calls the
+	Service, and if no response arrives within the given delay, sends the same
+	call to a replica Service and returns whichever response arrives first. The
+	slower call is then cancelled, closing its connection. If the first
+	response is a RemoteObjectError, the other one is waited for.
+
+	Only idempotent, read-only methods may be hedged, since both Services may
+	execute the call. Methods are marked for hedging by listing their names;
+	all other methods of the stub only call the Service, as in StubFactory.
+
+	A call to HedgeStub requires the following inputs:
+	-- a struct of function declarations to act as the stub's interface/proxy
+	-- the remote address of the Service as "<ip-address>:<port-number>"
+	-- the remote address of the replica Service, in the same form
+	-- the delay before the call is sent to the replica
+	-- indicators of packet loss and propagation delay, as in StubFactory
+	-- the names of the methods to hedge
+	   performs the following:
+	-- returns a local error if the stub is nil or not a remote interface
+	-- returns a local error if a listed method is not a method of the stub
+*/
+func HedgeStub(ifc interface{}, adr string, replica string, delay time.Duration, lossy bool, delayed bool, methods ...string) error {
+	// Populate every method with a plain call to the Service first
+	err := StubFactory(ifc, adr, lossy, delayed)
+	if err != nil {
+		return err
+	}
+
+	stub := reflect.ValueOf(ifc).Elem()
+
+	// Check every method before changing any of them
+	for _, method_name := range methods {
+		if !stub.FieldByName(method_name).IsValid() {
+			return errors.New(error_message[HEDGED_METHOD_NOT_FOUND])
+		}
+	}
+
+	for _, method_name := range methods {
+		field := stub.FieldByName(method_name)
+		method_type := field.Type()
+
+		method_def := reflect.MakeFunc(method_type, func(args []reflect.Value) []reflect.Value {
+			// Cancel the slower call once a response is returned
+			done := make(chan struct{})
+			defer close(done)
+			call := func(adr string) []reflect.Value {
+				return callRemote(method_type, method_name, adr, lossy, delayed, args, done)
+			}
+
+			// Buffered so the slower call does not block once a response is returned
+			results := make(chan []reflect.Value, 2)
+			go func() { results <- call(adr) }()
+
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+
+			select {
+			case out := <-results:
+				if succeeded(out) {
+					return out // Answered before the delay, no need to hedge
+				}
+				// The Service failed, so ask the replica right away
+				return call(replica)
+			case <-timer.C:
+				go func() { results <- call(replica) }()
+			}
+
+			// Take the first successful response
+			first := <-results
+			if succeeded(first) {
+				return first
+			}
+			return <-results
+		})
+
+		field.Set(method_def)
+	}
+
+	return nil
+}
+
+/*
+Return true if the outputs of a stub function do not end with a RemoteObjectError.
+*/
+func succeeded(out []reflect.Value) bool {
+	if len(out) == 0 {
+		return false
+	}
+	roe, ok := out[len(out)-1].Interface().(RemoteObjectError)
+	return ok && roe.Err == ""
+}
@@ -0,0 +1,139 @@
+package remote
+
+// Tests of HedgeStub, calling a Service and an endpoint that never answers.
+
+import (
+	"../../../testutil"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const HEDGE_TEST_TIMEOUT = 5 * time.Second
+
+// start a Service of SimpleInterface and return its address
+func startSimpleService(t *testing.T) (*Service, string) {
+	port := testutil.FreePort(t)
+	srvc, err := NewService(&SimpleInterface{}, &SimpleObject{}, port, false, false)
+	if err != nil {
+		t.Fatalf("Error in NewService: %s", err.Error())
+	}
+	if err = srvc.Start(); err != nil {
+		t.Fatalf("Error in Service.Start(): %s", err.Error())
+	}
+	// Start listens before returning, so the Service can be called right away
+	return srvc, "127.0.0.1:" + strconv.Itoa(port)
+}
+
+// an endpoint that accepts calls but never answers them, reporting each
+// connection it accepts and each one its caller closes
+type silentEndpoint struct {
+	ln       net.Listener
+	accepted chan bool
+	closed   chan bool
+}
+
+func startSilentEndpoint(t *testing.T) *silentEndpoint {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %s", err.Error())
+	}
+	endpoint := &silentEndpoint{ln: ln, accepted: make(chan bool, 10), closed: make(chan bool, 10)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			endpoint.accepted <- true
+			go func() {
+				// Read the request, then wait for the caller to hang up
+				buf := make([]byte, 4096)
+				for {
+					if _, err := conn.Read(buf); err != nil {
+						conn.Close()
+						endpoint.closed <- true
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return endpoint
+}
+
+func (endpoint *silentEndpoint) address() string {
+	return endpoint.ln.Addr().String()
+}
+
+// wait up to HEDGE_TEST_TIMEOUT for a signal on c
+func received(c chan bool) bool {
+	select {
+	case <-c:
+		return true
+	case <-time.After(HEDGE_TEST_TIMEOUT):
+		return false
+	}
+}
+
+// TestHedge_ReplicaWins -- a hedged call to a Service that does not answer
+// is also sent to the replica after the delay. The replica's response is
+// returned, and the call to the Service is cancelled.
+func TestHedge_ReplicaWins(t *testing.T) {
+	slow := startSilentEndpoint(t)
+	defer slow.ln.Close()
+	fast, fastAddress := startSimpleService(t)
+	defer fast.Stop()
+
+	stub := &SimpleInterface{}
+	if err := HedgeStub(stub, slow.address(), fastAddress, HEDGE_DELAY, false, false, "Method"); err != nil {
+		t.Fatalf("Error in HedgeStub: %s", err.Error())
+	}
+
+	start := time.Now()
+	value, _, roe := stub.Method(42, false)
+	if (roe != RemoteObjectError{}) || value != 42 {
+		t.Fatalf("Hedged call returned %d, %v, expected the replica's 42", value, roe)
+	}
+	if elapsed := time.Since(start); elapsed >= HEDGE_TEST_TIMEOUT {
+		t.Fatalf("Hedged call took %v", elapsed)
+	}
+
+	if !received(slow.closed) {
+		t.Fatalf("The call to the Service was not cancelled")
+	}
+}
+
+// TestHedge_ServiceWins -- a hedged call answered by the Service within the
+// delay is never sent to the replica.
+func TestHedge_ServiceWins(t *testing.T) {
+	replica := startSilentEndpoint(t)
+	defer replica.ln.Close()
+	fast, fastAddress := startSimpleService(t)
+	defer fast.Stop()
+
+	stub := &SimpleInterface{}
+	if err := HedgeStub(stub, fastAddress, replica.address(), HEDGE_TEST_TIMEOUT, false, false, "Method"); err != nil {
+		t.Fatalf("Error in HedgeStub: %s", err.Error())
+	}
+
+	value, _, roe := stub.Method(7, false)
+	if (roe != RemoteObjectError{}) || value != 7 {
+		t.Fatalf("Hedged call returned %d, %v, expected the Service's 7", value, roe)
+	}
+	select {
+	case <-replica.accepted:
+		t.Fatalf("The call was sent to the replica")
+	default:
+	}
+}
+
+// TestHedge_UnknownMethod -- HedgeStub rejects a method the stub does not have.
+func TestHedge_UnknownMethod(t *testing.T) {
+	stub := &SimpleInterface{}
+	err := HedgeStub(stub, "127.0.0.1:1", "127.0.0.1:2", HEDGE_DELAY, false, false, "Missing")
+	if err == nil || err.Error() != error_message[HEDGED_METHOD_NOT_FOUND] {
+		t.Fatalf("HedgeStub accepted an unknown method: %v", err)
+	}
+}
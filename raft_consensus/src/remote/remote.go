@@ -375,6 +375,8 @@ func StubFactory(ifc interface{}, adr string, lossy bool, delayed bool) error {
 
 		// Get the field's method name
 		method_name := ifc_reflection.Type().Field(i).Name
+		// and type
+		method_type := ifc_reflection.Field(i).Type()
 
 		/*
 				Define a "stub function" that makes a connection to the remote object,
@@ -382,161 +384,206 @@ func StubFactory(ifc interface{}, adr string, lossy bool, delayed bool) error {
 
 				Return an array of reflection values.
 		*/
-		method_def := reflect.MakeFunc(ifc_reflection.Field(i).Type(), func(args []reflect.Value) []reflect.Value {
+		method_def := reflect.MakeFunc(method_type, func(args []reflect.Value) []reflect.Value {
+			return callRemote(method_type, method_name, adr, lossy, delayed, args, nil)
+		})
 
-			// Create an array of reflection values to return
-			returnval := []reflect.Value{}
+		// Set the given stub's field to be the stub remote function defined above,
+		// so when method_name is called on this stub, the remote function will be called instead.
+		ifc_reflection.FieldByName(method_name).Set(method_def)
+	}
 
-			// Start a TCP connection with the service's address.
-			conn, err := net.DialTimeout("tcp", adr, 5*time.Second)
+	// Successfully ran StubFactory with no errors.
+	return nil
+}
 
-			// If there was an error making the connection:
-			if err != nil {
-				// Append the zero value of each of the method's output to an array of reflection values,
-				// except the last output in the method.
-				for j := 0; j < ifc_reflection.FieldByName(method_name).Type().NumOut()-1; j++ {
-					returnval = append(returnval, reflect.Zero(ifc_reflection.FieldByName(method_name).Type().Out(j)))
-				}
+/*
+Make the remote call method_name(args) on the Service at adr, for a stub function
+of type method_type, and return its outputs.
 
-				// Append the final reflection value as a RemoteObjectError{},
-				// Thereby fulfilling the output requirements of the method being called.
-				returnval = append(returnval, reflect.ValueOf(RemoteObjectError{
-					Err: error_message[UNABLE_TO_SEND_CONNECTION_TO_SERVER]}))
+Closing cancel abandons the call: its connection is closed, and the call returns
+a RemoteObjectError at once. A nil cancel never abandons the call.
+*/
+func callRemote(method_type reflect.Type, method_name string, adr string, lossy bool, delayed bool, args []reflect.Value, cancel <-chan struct{}) []reflect.Value {
 
-				// Return the list of reflection values containing output values that have been
-				// zeroed out and a RemoteObjectError{}.
-				return returnval
-			}
+	// Create an array of reflection values to return
+	returnval := []reflect.Value{}
 
-			// Create a new leaky socket using the connection to the service
-			ls := NewLeakySocket(conn, lossy, delayed)
+	// Start a TCP connection with the service's address.
+	conn, err := net.DialTimeout("tcp", adr, 5*time.Second)
 
-			/* Convert given arguments values into interfaces, for easy transmission. */
-			var req_ifc []interface{}
-			for _, arg := range args {
-				req_ifc = append(req_ifc, arg.Interface())
-			}
+	// If there was an error making the connection:
+	if err != nil {
+		// Append the zero value of each of the method's output to an array of reflection values,
+		// except the last output in the method.
+		for j := 0; j < method_type.NumOut()-1; j++ {
+			returnval = append(returnval, reflect.Zero(method_type.Out(j)))
+		}
 
-			// Cretae a request message to send to the service as a method call.
-			request_message := RequestMsg{
-				Method:               method_name,
-				Args:                 req_ifc,
-				ExpectedReturnValues: ifc_reflection.FieldByName(method_name).Type().NumOut()}
+		// Append the final reflection value as a RemoteObjectError{},
+		// Thereby fulfilling the output requirements of the method being called.
+		returnval = append(returnval, reflect.ValueOf(RemoteObjectError{
+			Err: error_message[UNABLE_TO_SEND_CONNECTION_TO_SERVER]}))
 
-			/* Gob encode the request message */
-			var req_bytes bytes.Buffer
+		// Return the list of reflection values containing output values that have been
+		// zeroed out and a RemoteObjectError{}.
+		return returnval
+	}
 
-			/* Encode */
-			for i := 0; i < ifc_reflection.FieldByName(method_name).Type().NumIn(); i++ {
-				t := ifc_reflection.FieldByName(method_name).Type().In(i)
-				v := reflect.New(t).Elem().Interface()
-				gob.Register(v)
+	// Close the connection if the call is abandoned before it returns
+	if cancel != nil {
+		returned := make(chan struct{})
+		defer close(returned)
+		go func() {
+			select {
+			case <-cancel:
+				conn.Close()
+			case <-returned:
 			}
+		}()
+	}
 
-			enc := gob.NewEncoder(&req_bytes)
-			err = enc.Encode(&request_message)
-			// If encoding results in an error:
-			if err != nil {
-				log.Printf("%v %v", error_message[ENCODING_ERROR], err)
-				// Append the zero value of each of the method's output to an array of reflection values,
-				// except the last output in the method.
-				for j := 0; j < ifc_reflection.FieldByName(method_name).Type().NumOut()-1; j++ {
-					returnval = append(returnval, reflect.Zero(ifc_reflection.FieldByName(method_name).Type().Out(j)))
-				}
-				// Append the final reflection value as a RemoteObjectError{},
-				// Thereby fulfilling the output requirements of the method being called.
-				returnval = append(returnval, reflect.ValueOf(RemoteObjectError{
-					Err: error_message[ENCODING_ERROR]}))
-				// Return the list of reflection values containing output values that have been
-				// zeroed out and a RemoteObjectError{}.
-				return returnval
-			}
+	// Create a new leaky socket using the connection to the service
+	ls := NewLeakySocket(conn, lossy, delayed)
 
-			/* Try sending the encoded request to the service, until it is successfully sent */
-			for {
-				sent, _ := ls.SendObject(req_bytes.Bytes())
-				if sent {
-					break // Message successfully sent, so escape infinite loop
-				}
-			}
+	/* Convert given arguments values into interfaces, for easy transmission. */
+	var req_ifc []interface{}
+	for _, arg := range args {
+		req_ifc = append(req_ifc, arg.Interface())
+	}
 
-			/* Wait to receive response from service */
-			response, err := ls.RecvObject() // Blocking call
-			// If receiving results in an error:
-			if err != nil {
-				log.Printf(error_message[LEAKY_SOCKET_READ_ERROR_CLIENT], method_name, err)
-				// Append the zero value of each of the method's output to an array of reflection values,
-				// except the last output in the method.
-				for j := 0; j < ifc_reflection.FieldByName(method_name).Type().NumOut()-1; j++ {
-					returnval = append(returnval, reflect.Zero(ifc_reflection.FieldByName(method_name).Type().Out(j)))
-				}
-				// Append the final reflection value as a RemoteObjectError{},
-				// Thereby fulfilling the output requirements of the method being called.
-				returnval = append(returnval, reflect.ValueOf(RemoteObjectError{
-					Err: error_message[LEAKY_SOCKET_READ_ERROR_CLIENT]}))
-				// Return the list of reflection values containing output values that have been
-				// zeroed out and a RemoteObjectError{}.
-				return returnval
-			}
+	// Cretae a request message to send to the service as a method call.
+	request_message := RequestMsg{
+		Method:               method_name,
+		Args:                 req_ifc,
+		ExpectedReturnValues: method_type.NumOut()}
 
-			// Create an empty reply object to contain the remote service's response
-			res := ReplyMsg{}
+	/* Gob encode the request message */
+	var req_bytes bytes.Buffer
 
-			/* Gob decode the service's response to the remote call */
-			dec := gob.NewDecoder(bytes.NewReader(response))
-			err = dec.Decode(&res)
-			// If there was an error while decoding:
-			if err != nil {
-				log.Println(error_message[DECODING_ERROR])
-				// Append the zero value of each of the method's output to an array of reflection values,
-				// except the last output in the method.
-				for j := 0; j < ifc_reflection.FieldByName(method_name).Type().NumOut()-1; j++ {
-					returnval = append(returnval, reflect.Zero(ifc_reflection.FieldByName(method_name).Type().Out(j)))
-				}
-				// Append the final reflection value as a RemoteObjectError{},
-				// Thereby fulfilling the output requirements of the method being called.
-				returnval = append(returnval, reflect.ValueOf(RemoteObjectError{
-					Err: error_message[DECODING_ERROR]}))
-				// Return the list of reflection values containing output values that have been
-				// zeroed out and a RemoteObjectError{}.
-				return returnval
-			}
+	/* Encode */
+	for i := 0; i < method_type.NumIn(); i++ {
+		t := method_type.In(i)
+		v := reflect.New(t).Elem().Interface()
+		gob.Register(v)
+	}
 
-			// If the reply's Err field is NOT an empty RemoteObjectError,
-			// then the reply object contains an error.
-			if (res.Err != RemoteObjectError{}) {
-				// Append the zero value of each of the method's output to an array of reflection values,
-				// except the last output in the method.
-				for j := 0; j < ifc_reflection.FieldByName(method_name).Type().NumOut()-1; j++ {
-					returnval = append(returnval, reflect.Zero(ifc_reflection.FieldByName(method_name).Type().Out(j)))
-				}
-				// Append the final reflection value as a RemoteObjectError{},
-				// Thereby fulfilling the output requirements of the method being called.
-				returnval = append(returnval, reflect.ValueOf(RemoteObjectError{
-					Err: res.Err.Err}))
-				// Return the list of reflection values containing output values that have been
-				// zeroed out and a RemoteObjectError{}.
-				return returnval
-			}
+	enc := gob.NewEncoder(&req_bytes)
+	err = enc.Encode(&request_message)
+	// If encoding results in an error:
+	if err != nil {
+		log.Printf("%v %v", error_message[ENCODING_ERROR], err)
+		// Append the zero value of each of the method's output to an array of reflection values,
+		// except the last output in the method.
+		for j := 0; j < method_type.NumOut()-1; j++ {
+			returnval = append(returnval, reflect.Zero(method_type.Out(j)))
+		}
+		// Append the final reflection value as a RemoteObjectError{},
+		// Thereby fulfilling the output requirements of the method being called.
+		returnval = append(returnval, reflect.ValueOf(RemoteObjectError{
+			Err: error_message[ENCODING_ERROR]}))
+		// Return the list of reflection values containing output values that have been
+		// zeroed out and a RemoteObjectError{}.
+		return returnval
+	}
 
-			// Convert the returned outputs from a list of interfaces into a list of reflection values
-			result := interfaceSliceToReflectValue(res.Reply)
+	/* Try sending the encoded request to the service, until it is successfully sent */
+	for {
+		sent, err := ls.SendObject(req_bytes.Bytes())
+		if sent || err != nil {
+			break // Message successfully sent, or the connection is closed, so escape infinite loop
+		}
+	}
 
-			// Add each returned output result[j] to the final return variable,
-			// which is a list of reflection values
-			for j := 0; j < ifc_reflection.FieldByName(method_name).Type().NumOut(); j++ {
-				returnval = append(returnval, result[j])
-			}
+	/* Wait to receive response from service */
+	response, err := ls.RecvObject() // Blocking call
+	// If the call was abandoned, its connection was closed under it
+	if err != nil && cancelled(cancel) {
+		for j := 0; j < method_type.NumOut()-1; j++ {
+			returnval = append(returnval, reflect.Zero(method_type.Out(j)))
+		}
+		returnval = append(returnval, reflect.ValueOf(RemoteObjectError{
+			Err: error_message[CALL_CANCELLED]}))
+		return returnval
+	}
+	// If receiving results in an error:
+	if err != nil {
+		log.Printf(error_message[LEAKY_SOCKET_READ_ERROR_CLIENT], method_name, err)
+		// Append the zero value of each of the method's output to an array of reflection values,
+		// except the last output in the method.
+		for j := 0; j < method_type.NumOut()-1; j++ {
+			returnval = append(returnval, reflect.Zero(method_type.Out(j)))
+		}
+		// Append the final reflection value as a RemoteObjectError{},
+		// Thereby fulfilling the output requirements of the method being called.
+		returnval = append(returnval, reflect.ValueOf(RemoteObjectError{
+			Err: error_message[LEAKY_SOCKET_READ_ERROR_CLIENT]}))
+		// Return the list of reflection values containing output values that have been
+		// zeroed out and a RemoteObjectError{}.
+		return returnval
+	}
 
-			// Return the outputs from the remote call.
-			return returnval
-		})
+	// Create an empty reply object to contain the remote service's response
+	res := ReplyMsg{}
 
-		// Set the given stub's field to be the stub remote function defined above,
-		// so when method_name is called on this stub, the remote function will be called instead.
-		ifc_reflection.FieldByName(method_name).Set(method_def)
+	/* Gob decode the service's response to the remote call */
+	dec := gob.NewDecoder(bytes.NewReader(response))
+	err = dec.Decode(&res)
+	// If there was an error while decoding:
+	if err != nil {
+		log.Println(error_message[DECODING_ERROR])
+		// Append the zero value of each of the method's output to an array of reflection values,
+		// except the last output in the method.
+		for j := 0; j < method_type.NumOut()-1; j++ {
+			returnval = append(returnval, reflect.Zero(method_type.Out(j)))
+		}
+		// Append the final reflection value as a RemoteObjectError{},
+		// Thereby fulfilling the output requirements of the method being called.
+		returnval = append(returnval, reflect.ValueOf(RemoteObjectError{
+			Err: error_message[DECODING_ERROR]}))
+		// Return the list of reflection values containing output values that have been
+		// zeroed out and a RemoteObjectError{}.
+		return returnval
 	}
 
-	// Successfully ran StubFactory with no errors.
-	return nil
+	// If the reply's Err field is NOT an empty RemoteObjectError,
+	// then the reply object contains an error.
+	if (res.Err != RemoteObjectError{}) {
+		// Append the zero value of each of the method's output to an array of reflection values,
+		// except the last output in the method.
+		for j := 0; j < method_type.NumOut()-1; j++ {
+			returnval = append(returnval, reflect.Zero(method_type.Out(j)))
+		}
+		// Append the final reflection value as a RemoteObjectError{},
+		// Thereby fulfilling the output requirements of the method being called.
+		returnval = append(returnval, reflect.ValueOf(RemoteObjectError{
+			Err: res.Err.Err}))
+		// Return the list of reflection values containing output values that have been
+		// zeroed out and a RemoteObjectError{}.
+		return returnval
+	}
+
+	// Convert the returned outputs from a list of interfaces into a list of reflection values
+	result := interfaceSliceToReflectValue(res.Reply)
+
+	// Add each returned output result[j] to the final return variable,
+	// which is a list of reflection values
+	for j := 0; j < method_type.NumOut(); j++ {
+		returnval = append(returnval, result[j])
+	}
+
+	// Return the outputs from the remote call.
+	return returnval
+}
+
+/*
+Return true once cancel is closed.
+*/
+func cancelled(cancel <-chan struct{}) bool {
+	select {
+	case <-cancel:
+		return true
+	default:
+		return false
+	}
 }
@@ -0,0 +1,76 @@
+package testutil
+
+import (
+	"os"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+)
+
+/* Time a stopped process is given to exit before it is killed */
+const STOP_TIMEOUT = 5 * time.Second
+
+/* A server component run by a test, stopped when the test ends at the latest */
+type Component struct {
+	Name string   // Name of the component in failure messages and its log file
+	Log  *os.File // Log file of the component, see LogFile
+	stop func()
+	once sync.Once
+}
+
+/*
+Start a component: run start with the component's log file, then wait up to
+READY_TIMEOUT for ready to hold, failing the test otherwise. start returns
+the function stopping the component, called once, by Stop or when the test
+ends.
+*/
+func Start(t testing.TB, name string, start func(log *os.File) (stop func()), ready func() bool) *Component {
+	t.Helper()
+
+	component := &Component{Name: name, Log: LogFile(t, name)}
+	component.stop = start(component.Log)
+	t.Cleanup(component.Stop)
+
+	WaitFor(t, READY_TIMEOUT, name+" to be ready", ready)
+	return component
+}
+
+/* Stop the component, if not stopped already */
+func (component *Component) Stop() {
+	component.once.Do(component.stop)
+}
+
+/*
+Start the program at path with args as a component, its output going to the
+component's log file. Stopping it interrupts the program, and kills it if it
+has not exited after STOP_TIMEOUT.
+*/
+func StartProcess(t testing.TB, name string, ready func() bool, path string, args ...string) *Component {
+	t.Helper()
+
+	return Start(t, name, func(log *os.File) func() {
+		cmd := exec.Command(path, args...)
+		cmd.Stdout = log
+		cmd.Stderr = log
+		if err := cmd.Start(); err != nil {
+			t.Fatalf("could not start %s: %v", name, err)
+		}
+
+		exited := make(chan struct{})
+		go func() {
+			cmd.Wait()
+			close(exited)
+		}()
+
+		return func() {
+			cmd.Process.Signal(os.Interrupt)
+			select {
+			case <-exited:
+			case <-time.After(STOP_TIMEOUT):
+				cmd.Process.Kill()
+				<-exited
+			}
+		}
+	}, ready)
+}
@@ -0,0 +1,3 @@
+module testutil
+
+go 1.20
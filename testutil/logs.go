@@ -0,0 +1,48 @@
+package testutil
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/* Lines of a log file shown when the test fails */
+const LOG_TAIL_LINES int = 50
+
+/*
+Create a log file for the component name in the test's temporary directory.
+If the test fails, its last LOG_TAIL_LINES lines are logged with the failure;
+either way the file is closed and removed when the test ends.
+*/
+func LogFile(t testing.TB, name string) *os.File {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name+".log")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("could not create log file for %s: %v", name, err)
+	}
+
+	// Registered after TempDir's removal, so it runs first
+	t.Cleanup(func() {
+		file.Close()
+		if t.Failed() {
+			t.Logf("last lines of the %s log:\n%s", name, tail(path, LOG_TAIL_LINES))
+		}
+	})
+	return file
+}
+
+/* Return the last n lines of the file at path */
+func tail(path string, n int) string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err.Error()
+	}
+	lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,79 @@
+/*
+Testing utilities shared by the blockchain, DFS and Raft tests: free ports,
+server components started and stopped around a test with readiness checks
+instead of fixed sleeps, and a log file per test.
+*/
+package testutil
+
+import (
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+/*
+Ports are drawn below the ephemeral range, so outgoing connections do not
+take a port between the check and the server binding it
+*/
+const PORT_MIN int = 20000
+const PORT_MAX int = 32000
+
+/* Attempts at finding a free range before failing the test */
+const PORT_ATTEMPTS int = 100
+
+/* Ports handed out to tests of this process, never handed out twice */
+var reserved = map[int]bool{}
+var reserved_mutex sync.Mutex
+
+/*
+Return the first of n consecutive ports free on localhost, for components
+numbering their ports from a base port, e.g. Raft peers or blockchain nodes
+joining a seed. The ports are not handed out again to tests of this process.
+*/
+func FreePortRange(t testing.TB, n int) int {
+	t.Helper()
+
+	reserved_mutex.Lock()
+	defer reserved_mutex.Unlock()
+
+	for attempt := 0; attempt < PORT_ATTEMPTS; attempt++ {
+		base := PORT_MIN + rand.Intn(PORT_MAX-PORT_MIN-n)
+		if rangeFree(base, n) {
+			for port := base; port < base+n; port++ {
+				reserved[port] = true
+			}
+			return base
+		}
+	}
+
+	t.Fatalf("no %d consecutive free ports between %d and %d", n, PORT_MIN, PORT_MAX)
+	return 0
+}
+
+/* Return a port free on localhost */
+func FreePort(t testing.TB) int {
+	t.Helper()
+	return FreePortRange(t, 1)
+}
+
+/* Return true if ports base to base+n-1 are unreserved and can be bound */
+func rangeFree(base int, n int) bool {
+	for port := base; port < base+n; port++ {
+		if reserved[port] || !Bindable(port) {
+			return false
+		}
+	}
+	return true
+}
+
+/* Return true if a server could listen on port of localhost right now */
+func Bindable(port int) bool {
+	listener, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(port))
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
+}
@@ -0,0 +1,72 @@
+package testutil
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"testing"
+)
+
+/* Ranges handed out are free, and never overlap one another */
+func TestFreePortRange(t *testing.T) {
+	seen := map[int]bool{}
+	for i := 0; i < 20; i++ {
+		base := FreePortRange(t, 5)
+		for port := base; port < base+5; port++ {
+			if seen[port] {
+				t.Fatalf("port %d handed out twice", port)
+			}
+			if !Bindable(port) {
+				t.Fatalf("port %d handed out but taken", port)
+			}
+			seen[port] = true
+		}
+	}
+}
+
+/* A component is ready once it listens, and released once stopped */
+func TestStart(t *testing.T) {
+	address := "127.0.0.1:" + strconv.Itoa(FreePort(t))
+
+	server := Start(t, "server", func(log *os.File) func() {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		log.WriteString("listening on " + address + "\n")
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				conn.Close()
+			}
+		}()
+		return func() { listener.Close() }
+	}, func() bool { return Listening(address) })
+
+	server.Stop()
+	server.Stop() // Stopping twice is harmless
+	if Listening(address) {
+		t.Fatalf("%s still accepts connections after Stop", address)
+	}
+
+	if got := tail(server.Log.Name(), 1); got != "listening on "+address {
+		t.Fatalf("expected the component's output in its log, got %q", got)
+	}
+}
+
+/* A condition that never holds times out */
+func TestEventually(t *testing.T) {
+	calls := 0
+	if Eventually(3*POLL_INTERVAL, func() bool { calls++; return false }) {
+		t.Fatalf("expected a condition that never holds to time out")
+	}
+	if calls < 2 {
+		t.Fatalf("expected the condition to be checked repeatedly, got %d checks", calls)
+	}
+	if !Eventually(POLL_INTERVAL, func() bool { return true }) {
+		t.Fatalf("expected a condition that holds not to time out")
+	}
+}
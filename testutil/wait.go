@@ -0,0 +1,57 @@
+package testutil
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+/* Time a component is given to become ready, or a condition to hold */
+const READY_TIMEOUT = 10 * time.Second
+
+/* Time between two checks of a condition */
+const POLL_INTERVAL = 20 * time.Millisecond
+
+/*
+Check cond until it holds and return true, or return false once timeout has
+passed without it holding
+*/
+func Eventually(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(POLL_INTERVAL)
+	}
+	return true
+}
+
+/*
+Wait until cond holds, failing the test after timeout. what describes the
+awaited outcome in the failure message.
+*/
+func WaitFor(t testing.TB, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	if !Eventually(timeout, cond) {
+		t.Fatalf("timed out after %v waiting for %s", timeout, what)
+	}
+}
+
+/* Return true if a server accepts connections at address */
+func Listening(address string) bool {
+	conn, err := net.DialTimeout("tcp", address, POLL_INTERVAL*5)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+/* Wait until a server accepts connections at address, failing the test after timeout */
+func WaitListening(t testing.TB, address string, timeout time.Duration) {
+	t.Helper()
+	WaitFor(t, timeout, address+" to accept connections", func() bool {
+		return Listening(address)
+	})
+}